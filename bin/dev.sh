@@ -67,9 +67,9 @@ fi
 # Build Mockzure if needed
 [ "$QUIET" = false ] && print_status "Checking Mockzure binary..."
 
-if [ ! -f "mockzure" ] || [ "main.go" -nt "mockzure" ]; then
+if [ ! -f "mockzure" ] || [ -n "$(find . -name '*.go' -newer mockzure)" ]; then
     [ "$QUIET" = false ] && print_status "Building Mockzure..."
-    go build -o mockzure main.go
+    go build -o mockzure .
     [ "$QUIET" = false ] && print_success "Mockzure built"
 else
     [ "$QUIET" = false ] && print_success "Mockzure is up to date"
@@ -5,10 +5,10 @@
 echo "🚀 Starting Mockzure..."
 echo ""
 
-# Build the binary if it doesn't exist or main.go is newer
-if [ ! -f mockzure ] || [ main.go -nt mockzure ]; then
+# Build the binary if it doesn't exist or any Go source is newer
+if [ ! -f mockzure ] || [ -n "$(find . -name '*.go' -newer mockzure)" ]; then
     echo "📦 Building Mockzure..."
-    go build -o mockzure main.go
+    go build -o mockzure .
     if [ $? -ne 0 ]; then
         echo "❌ Build failed"
         exit 1
@@ -0,0 +1,1218 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	blobServiceVersion     = "2025-01-05"
+	defaultBlobContentType = "application/octet-stream"
+	maxBlobListResults     = 5000
+)
+
+// blobETagSequence keeps ETags unique when two writes share a clock tick
+var blobETagSequence atomic.Int64
+
+// blobRequest is a Blob service request to a storage account by an authenticated caller
+type blobRequest struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	store   *Store
+	account *GenericResource
+	svc     *blobAccount
+	base    string // service endpoint, such as https://stdev.blob.core.windows.net/
+	caller  *blobCaller
+	now     time.Time
+}
+
+// blobListQuery is what List Containers and List Blobs read from the query string
+type blobListQuery struct {
+	prefix     string
+	delimiter  string
+	marker     string
+	maxResults int
+	metadata   bool
+}
+
+// blobMetadataXML writes metadata as <Metadata><name>value</name>...</Metadata>
+type blobMetadataXML map[string]string
+
+func (m blobMetadataXML) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := e.EncodeElement(m[name], xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+type containerListXML struct {
+	XMLName         xml.Name           `xml:"EnumerationResults"`
+	ServiceEndpoint string             `xml:"ServiceEndpoint,attr"`
+	Prefix          string             `xml:"Prefix,omitempty"`
+	Marker          string             `xml:"Marker,omitempty"`
+	MaxResults      int                `xml:"MaxResults"`
+	Containers      []containerItemXML `xml:"Containers>Container"`
+	NextMarker      string             `xml:"NextMarker"`
+}
+
+type containerItemXML struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		LastModified          string `xml:"Last-Modified"`
+		ETag                  string `xml:"Etag"`
+		LeaseStatus           string `xml:"LeaseStatus"`
+		LeaseState            string `xml:"LeaseState"`
+		LeaseDuration         string `xml:"LeaseDuration,omitempty"`
+		PublicAccess          string `xml:"PublicAccess,omitempty"`
+		HasImmutabilityPolicy bool   `xml:"HasImmutabilityPolicy"`
+		HasLegalHold          bool   `xml:"HasLegalHold"`
+	} `xml:"Properties"`
+	Metadata blobMetadataXML `xml:"Metadata,omitempty"`
+}
+
+type blobListXML struct {
+	XMLName         xml.Name       `xml:"EnumerationResults"`
+	ServiceEndpoint string         `xml:"ServiceEndpoint,attr"`
+	ContainerName   string         `xml:"ContainerName,attr"`
+	Prefix          string         `xml:"Prefix,omitempty"`
+	Marker          string         `xml:"Marker,omitempty"`
+	MaxResults      int            `xml:"MaxResults"`
+	Delimiter       string         `xml:"Delimiter,omitempty"`
+	Blobs           blobEntriesXML `xml:"Blobs"`
+	NextMarker      string         `xml:"NextMarker"`
+}
+
+type blobEntriesXML struct {
+	Blobs    []blobItemXML   `xml:"Blob"`
+	Prefixes []blobPrefixXML `xml:"BlobPrefix"`
+}
+
+type blobPrefixXML struct {
+	Name string `xml:"Name"`
+}
+
+type blobItemXML struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		CreationTime       string `xml:"Creation-Time"`
+		LastModified       string `xml:"Last-Modified"`
+		ETag               string `xml:"Etag"`
+		ContentLength      int64  `xml:"Content-Length"`
+		ContentType        string `xml:"Content-Type"`
+		ContentEncoding    string `xml:"Content-Encoding"`
+		ContentLanguage    string `xml:"Content-Language"`
+		ContentMD5         string `xml:"Content-MD5"`
+		ContentDisposition string `xml:"Content-Disposition"`
+		CacheControl       string `xml:"Cache-Control"`
+		BlobType           string `xml:"BlobType"`
+		AccessTier         string `xml:"AccessTier"`
+		AccessTierInferred bool   `xml:"AccessTierInferred"`
+		LeaseStatus        string `xml:"LeaseStatus"`
+		LeaseState         string `xml:"LeaseState"`
+		LeaseDuration      string `xml:"LeaseDuration,omitempty"`
+		ServerEncrypted    bool   `xml:"ServerEncrypted"`
+	} `xml:"Properties"`
+	Metadata blobMetadataXML `xml:"Metadata,omitempty"`
+}
+
+type blockListXML struct {
+	XMLName     xml.Name       `xml:"BlockList"`
+	Committed   []blockItemXML `xml:"CommittedBlocks>Block"`
+	Uncommitted []blockItemXML `xml:"UncommittedBlocks>Block"`
+}
+
+type blockItemXML struct {
+	Name string `xml:"Name"`
+	Size int64  `xml:"Size"`
+}
+
+// serveBlobRoute serves the Blob service, reporting whether the request was addressed to a
+// storage account
+func serveBlobRoute(w http.ResponseWriter, r *http.Request, store *Store) bool {
+	account, base, path := store.storageAccountForRequest(r)
+	if account == nil {
+		return false
+	}
+	version := r.Header.Get("x-ms-version")
+	if version == "" {
+		version = blobServiceVersion
+	}
+	w.Header().Set("x-ms-request-id", newBlobRequestID())
+	w.Header().Set("x-ms-version", version)
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	caller, ok := store.blobCaller(w, r, account)
+	if !ok {
+		return true
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	svc, err := store.blobService(account)
+	if err != nil {
+		log.Printf("Failed to load blobs of storage account %s: %v", account.Name, err)
+		writeBlobError(w, r, http.StatusInternalServerError, "InternalError", "The server encountered an internal error. Please retry the request.", "")
+		return true
+	}
+
+	q := &blobRequest{w: w, r: r, store: store, account: account, svc: svc, base: base, caller: caller, now: time.Now().UTC()}
+	containerName, blobName, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	query := r.URL.Query()
+	comp := query.Get("comp")
+	switch {
+	case containerName == "":
+		if comp == "list" && r.Method == http.MethodGet {
+			q.listContainers()
+		} else {
+			q.unsupported()
+		}
+	case blobName == "":
+		if query.Get("restype") != "container" {
+			q.error(http.StatusBadRequest, "InvalidUri", "The requested URI does not represent any resource on the server.")
+			return true
+		}
+		q.serveContainer(containerName, comp)
+	default:
+		q.serveBlob(containerName, blobName, comp)
+	}
+	return true
+}
+
+// serveContainer serves the container operations
+func (q *blobRequest) serveContainer(name, comp string) {
+	method := q.r.Method
+	switch {
+	case comp == "" && method == http.MethodPut:
+		q.createContainer(name)
+	case (comp == "" || comp == "metadata") && (method == http.MethodGet || method == http.MethodHead):
+		if c := q.container(name, blobContainerRead, "container"); c != nil {
+			q.containerHeaders(c)
+			q.w.WriteHeader(http.StatusOK)
+		}
+	case comp == "" && method == http.MethodDelete:
+		q.deleteContainer(name)
+	case comp == "metadata" && method == http.MethodPut:
+		c := q.container(name, blobContainerWrite, "")
+		// Container leases only guard deletion, so the lease ID is optional here
+		if c == nil || !q.checkLease(&c.Lease, "container", true) {
+			return
+		}
+		c.Metadata = q.metadata()
+		q.touchContainer(c)
+		q.saveContainer(c)
+		q.containerHeaders(c)
+		q.w.WriteHeader(http.StatusOK)
+	case comp == "list" && method == http.MethodGet:
+		q.listBlobs(name)
+	case comp == "lease" && method == http.MethodPut:
+		if c := q.container(name, blobContainerWrite, ""); c != nil {
+			q.lease(&c.Lease, "container", func() { q.saveContainer(c) }, c.ETag, c.Modified)
+		}
+	default:
+		q.unsupported()
+	}
+}
+
+// serveBlob serves the blob operations
+func (q *blobRequest) serveBlob(containerName, name, comp string) {
+	method := q.r.Method
+	read := method == http.MethodGet || method == http.MethodHead
+	action := blobWrite
+	switch {
+	case read:
+		action = blobRead
+	case method == http.MethodDelete:
+		action = blobDelete
+	}
+	public := ""
+	if read {
+		public = "blob"
+	}
+	c := q.container(containerName, action, public)
+	if c == nil {
+		return
+	}
+
+	switch {
+	case comp == "" && method == http.MethodPut:
+		q.putBlob(c, name)
+	case (comp == "" || comp == "metadata" || comp == "properties") && read:
+		q.getBlob(c, name, comp == "" && method == http.MethodGet)
+	case comp == "" && method == http.MethodDelete:
+		q.deleteBlob(c, name)
+	case comp == "block" && method == http.MethodPut:
+		q.putBlock(c, name)
+	case comp == "blocklist" && method == http.MethodPut:
+		q.putBlockList(c, name)
+	case comp == "blocklist" && method == http.MethodGet:
+		q.getBlockList(c, name)
+	case comp == "lease" && method == http.MethodPut:
+		if b := q.blob(c, name); b != nil {
+			q.lease(&b.Lease, "blob", func() { q.saveContainer(c) }, b.ETag, b.Modified)
+		}
+	case (comp == "metadata" || comp == "properties") && method == http.MethodPut:
+		b := q.blob(c, name)
+		if b == nil || !q.checkLease(&b.Lease, "blob", false) || !q.conditionsMet(b.ETag, false) {
+			return
+		}
+		if comp == "metadata" {
+			b.Metadata = q.metadata()
+		} else {
+			// Setting properties clears the content headers the request leaves out
+			b.ContentType = q.r.Header.Get("x-ms-blob-content-type")
+			b.ContentEncoding = q.r.Header.Get("x-ms-blob-content-encoding")
+			b.ContentLanguage = q.r.Header.Get("x-ms-blob-content-language")
+			b.ContentDisposition = q.r.Header.Get("x-ms-blob-content-disposition")
+			b.CacheControl = q.r.Header.Get("x-ms-blob-cache-control")
+			b.ContentMD5 = q.r.Header.Get("x-ms-blob-content-md5")
+		}
+		b.Modified, b.ETag = q.now, newBlobETag()
+		q.saveContainer(c)
+		q.w.Header().Set("ETag", b.ETag)
+		q.w.Header().Set("Last-Modified", b.Modified.Format(http.TimeFormat))
+		q.w.WriteHeader(http.StatusOK)
+	default:
+		q.unsupported()
+	}
+}
+
+// permitted reports whether the caller may perform a data action at a scope, answering the
+// request when not. Anonymous callers only get what a public container allows.
+func (q *blobRequest) permitted(action, scope string, public bool) bool {
+	switch {
+	case q.caller.sharedKey:
+		return true
+	case q.caller.anonymous:
+		if public {
+			return true
+		}
+		q.w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer authorization_uri=%s/%s/oauth2/authorize resource_id=%s",
+			baseURL(q.r), q.store.storageAccountTenant(q.account), storageAudience))
+		q.error(http.StatusUnauthorized, "NoAuthenticationInformation", "Server failed to authenticate the request. Please refer to the information in the www-authenticate header.")
+		return false
+	}
+	for _, role := range q.caller.roles {
+		if role.grants(action, scope) {
+			return true
+		}
+	}
+	q.error(http.StatusForbidden, "AuthorizationPermissionMismatch", "This request is not authorized to perform this operation using this permission.")
+	return false
+}
+
+// scope returns the resource ID data actions on a container are checked at, or the Blob
+// service's for an empty container name
+func (q *blobRequest) scope(container string) string {
+	scope := q.account.ID + "/blobServices/default"
+	if container != "" {
+		scope += "/containers/" + container
+	}
+	return scope
+}
+
+// container checks the caller's permission and returns a container, answering the request when
+// either fails. Anonymous callers get in when the container's public access is the given level
+// ("blob" or "container") or wider; an empty level keeps them out.
+func (q *blobRequest) container(name, action, level string) *blobContainer {
+	c := q.svc.containers[name]
+	public := c != nil && level != "" && (c.PublicAccess == "container" || c.PublicAccess == level)
+	if !q.permitted(action, q.scope(name), public) {
+		return nil
+	}
+	if c == nil {
+		q.error(http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+	}
+	return c
+}
+
+// blob returns a committed blob, answering the request when there is none
+func (q *blobRequest) blob(c *blobContainer, name string) *blobItem {
+	b := c.Blobs[name]
+	if b == nil {
+		q.error(http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+	}
+	return b
+}
+
+// createContainer serves Create Container
+func (q *blobRequest) createContainer(name string) {
+	if !q.permitted(blobContainerWrite, q.scope(name), false) {
+		return
+	}
+	if !validContainerName(name) {
+		q.error(http.StatusBadRequest, "InvalidResourceName", "The specified resource name contains invalid characters.")
+		return
+	}
+	access := strings.ToLower(q.r.Header.Get("x-ms-blob-public-access"))
+	if access != "" && access != "blob" && access != "container" {
+		q.error(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+		return
+	}
+	if q.svc.containers[name] != nil {
+		q.error(http.StatusConflict, "ContainerAlreadyExists", "The specified container already exists.")
+		return
+	}
+	c := &blobContainer{Name: name, Created: q.now, Metadata: q.metadata(), PublicAccess: access, Blobs: map[string]*blobItem{}}
+	q.touchContainer(c)
+	q.svc.containers[name] = c
+	q.saveContainer(c)
+	q.w.Header().Set("ETag", c.ETag)
+	q.w.Header().Set("Last-Modified", c.Modified.Format(http.TimeFormat))
+	q.w.WriteHeader(http.StatusCreated)
+}
+
+// deleteContainer serves Delete Container, along with the container's blobs
+func (q *blobRequest) deleteContainer(name string) {
+	c := q.container(name, blobContainerDelete, "")
+	if c == nil || !q.checkLease(&c.Lease, "container", false) || !q.conditionsMet(c.ETag, false) {
+		return
+	}
+	if err := q.store.blobBackend.deleteContainer(q.svc.name, c); err != nil {
+		q.internalError(err)
+		return
+	}
+	delete(q.svc.containers, name)
+	q.w.WriteHeader(http.StatusAccepted)
+}
+
+// listContainers serves List Containers
+func (q *blobRequest) listContainers() {
+	if !q.permitted(blobContainerRead, q.scope(""), false) {
+		return
+	}
+	list, ok := q.listQuery()
+	if !ok {
+		return
+	}
+	names := []string{}
+	for name := range q.svc.containers {
+		if strings.HasPrefix(name, list.prefix) && name >= list.marker {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	result := containerListXML{ServiceEndpoint: q.base, Prefix: list.prefix, Marker: list.marker, MaxResults: list.maxResults}
+	if len(names) > list.maxResults {
+		result.NextMarker = names[list.maxResults]
+		names = names[:list.maxResults]
+	}
+	for _, name := range names {
+		c := q.svc.containers[name]
+		item := containerItemXML{Name: name}
+		item.Properties.LastModified = c.Modified.Format(http.TimeFormat)
+		item.Properties.ETag = c.ETag
+		item.Properties.LeaseStatus, item.Properties.LeaseState, item.Properties.LeaseDuration = q.leaseProperties(&c.Lease)
+		item.Properties.PublicAccess = c.PublicAccess
+		if list.metadata {
+			item.Metadata = c.Metadata
+		}
+		result.Containers = append(result.Containers, item)
+	}
+	q.replyXML(http.StatusOK, result)
+}
+
+// listBlobs serves List Blobs. With a delimiter, blobs below it are rolled up into prefixes.
+func (q *blobRequest) listBlobs(containerName string) {
+	c := q.container(containerName, blobRead, "container")
+	if c == nil {
+		return
+	}
+	list, ok := q.listQuery()
+	if !ok {
+		return
+	}
+
+	// Entries are blob names, and prefixes ending in the delimiter
+	entries := []string{}
+	prefixes := map[string]bool{}
+	for name := range c.Blobs {
+		if !strings.HasPrefix(name, list.prefix) {
+			continue
+		}
+		if list.delimiter != "" {
+			if i := strings.Index(name[len(list.prefix):], list.delimiter); i >= 0 {
+				prefix := name[:len(list.prefix)+i+len(list.delimiter)]
+				if !prefixes[prefix] {
+					prefixes[prefix] = true
+					entries = append(entries, prefix)
+				}
+				continue
+			}
+		}
+		entries = append(entries, name)
+	}
+	sort.Strings(entries)
+	for len(entries) > 0 && entries[0] < list.marker {
+		entries = entries[1:]
+	}
+
+	result := blobListXML{ServiceEndpoint: q.base, ContainerName: c.Name, Prefix: list.prefix, Marker: list.marker,
+		MaxResults: list.maxResults, Delimiter: list.delimiter}
+	if len(entries) > list.maxResults {
+		result.NextMarker = entries[list.maxResults]
+		entries = entries[:list.maxResults]
+	}
+	for _, entry := range entries {
+		if prefixes[entry] {
+			result.Blobs.Prefixes = append(result.Blobs.Prefixes, blobPrefixXML{Name: entry})
+			continue
+		}
+		b := c.Blobs[entry]
+		item := blobItemXML{Name: b.Name}
+		p := &item.Properties
+		p.CreationTime = b.Created.Format(http.TimeFormat)
+		p.LastModified = b.Modified.Format(http.TimeFormat)
+		p.ETag = b.ETag
+		p.ContentLength = b.Size
+		p.ContentType = b.ContentType
+		p.ContentEncoding = b.ContentEncoding
+		p.ContentLanguage = b.ContentLanguage
+		p.ContentMD5 = b.ContentMD5
+		p.ContentDisposition = b.ContentDisposition
+		p.CacheControl = b.CacheControl
+		p.BlobType = "BlockBlob"
+		p.AccessTier = "Hot"
+		p.AccessTierInferred = true
+		p.LeaseStatus, p.LeaseState, p.LeaseDuration = q.leaseProperties(&b.Lease)
+		p.ServerEncrypted = true
+		if list.metadata {
+			item.Metadata = b.Metadata
+		}
+		result.Blobs.Blobs = append(result.Blobs.Blobs, item)
+	}
+	q.replyXML(http.StatusOK, result)
+}
+
+// listQuery reads the paging and filtering parameters of a list operation
+func (q *blobRequest) listQuery() (*blobListQuery, bool) {
+	query := q.r.URL.Query()
+	list := &blobListQuery{
+		prefix:     query.Get("prefix"),
+		delimiter:  query.Get("delimiter"),
+		marker:     query.Get("marker"),
+		maxResults: maxBlobListResults,
+	}
+	if v := query.Get("maxresults"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			q.error(http.StatusBadRequest, "OutOfRangeQueryParameterValue", "One of the query parameters specified in the request URI is outside the permissible range.")
+			return nil, false
+		}
+		list.maxResults = min(n, maxBlobListResults)
+	}
+	for _, include := range strings.Split(query.Get("include"), ",") {
+		if strings.EqualFold(include, "metadata") {
+			list.metadata = true
+		}
+	}
+	return list, true
+}
+
+// putBlob serves Put Blob for block blobs
+func (q *blobRequest) putBlob(c *blobContainer, name string) {
+	switch blobType := q.r.Header.Get("x-ms-blob-type"); {
+	case blobType == "":
+		q.error(http.StatusBadRequest, "MissingRequiredHeader", "An HTTP header that's mandatory for this request is not specified.")
+		return
+	case !strings.EqualFold(blobType, "BlockBlob"):
+		// Page and append blobs are not emulated
+		q.error(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+		return
+	}
+	existing := c.Blobs[name]
+	if !q.writable(existing) {
+		return
+	}
+	data, ok := q.body()
+	if !ok {
+		return
+	}
+	b := q.newBlob(c, name, existing)
+	if b.ContentType == "" {
+		b.ContentType = q.r.Header.Get("Content-Type")
+	}
+	if b.ContentMD5 == "" {
+		sum := md5.Sum(data)
+		b.ContentMD5 = base64.StdEncoding.EncodeToString(sum[:])
+	}
+	if q.commit(c, b, data) {
+		q.w.Header().Set("Content-MD5", b.ContentMD5)
+		q.blobWritten(b)
+	}
+}
+
+// putBlock serves Put Block, staging a block until a block list commits it
+func (q *blobRequest) putBlock(c *blobContainer, name string) {
+	id := q.r.URL.Query().Get("blockid")
+	if decoded, err := base64.StdEncoding.DecodeString(id); err != nil || len(decoded) == 0 || len(decoded) > 64 {
+		q.error(http.StatusBadRequest, "InvalidQueryParameterValue", "Value for one of the query parameters specified in the request URI is invalid.")
+		return
+	}
+	if b := c.Blobs[name]; b != nil && !q.checkLease(&b.Lease, "blob", false) {
+		return
+	}
+	data, ok := q.body()
+	if !ok {
+		return
+	}
+	if c.uncommitted == nil {
+		c.uncommitted = map[string][]*blobBlockData{}
+	}
+	staged := c.uncommitted[name]
+	for i, block := range staged {
+		if block.id == id {
+			staged = append(staged[:i], staged[i+1:]...)
+			break
+		}
+	}
+	c.uncommitted[name] = append(staged, &blobBlockData{id: id, data: data})
+	sum := md5.Sum(data)
+	q.w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	q.w.Header().Set("x-ms-request-server-encrypted", "true")
+	q.w.WriteHeader(http.StatusCreated)
+}
+
+// putBlockList serves Put Block List, which writes a blob out of staged and committed blocks in
+// the order the list names them
+func (q *blobRequest) putBlockList(c *blobContainer, name string) {
+	existing := c.Blobs[name]
+	if !q.writable(existing) {
+		return
+	}
+	body, ok := q.body()
+	if !ok {
+		return
+	}
+
+	type blockRef struct{ source, id string }
+	refs := []blockRef{}
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			q.error(http.StatusBadRequest, "InvalidXmlDocument", "XML specified is not syntactically valid.")
+			return
+		}
+		start, isStart := token.(xml.StartElement)
+		if !isStart || (start.Name.Local != "Latest" && start.Name.Local != "Committed" && start.Name.Local != "Uncommitted") {
+			continue
+		}
+		var id string
+		if err := decoder.DecodeElement(&id, &start); err != nil {
+			q.error(http.StatusBadRequest, "InvalidXmlDocument", "XML specified is not syntactically valid.")
+			return
+		}
+		refs = append(refs, blockRef{start.Name.Local, strings.TrimSpace(id)})
+	}
+
+	staged := map[string][]byte{}
+	for _, block := range c.uncommitted[name] {
+		staged[block.id] = block.data
+	}
+	committed := map[string][]byte{}
+	if existing != nil && len(existing.Blocks) > 0 {
+		data, err := q.store.blobBackend.readBlob(q.svc.name, c.Name, existing)
+		if err != nil {
+			q.internalError(err)
+			return
+		}
+		offset := int64(0)
+		for _, block := range existing.Blocks {
+			if offset+block.Size <= int64(len(data)) {
+				committed[block.ID] = data[offset : offset+block.Size]
+			}
+			offset += block.Size
+		}
+	}
+
+	var content []byte
+	blocks := []blobBlock{}
+	for _, ref := range refs {
+		data, found := []byte(nil), false
+		if ref.source != "Committed" {
+			data, found = staged[ref.id]
+		}
+		if !found && ref.source != "Uncommitted" {
+			data, found = committed[ref.id]
+		}
+		if !found {
+			q.error(http.StatusBadRequest, "InvalidBlockList", "The specified block list is invalid.")
+			return
+		}
+		content = append(content, data...)
+		blocks = append(blocks, blobBlock{ID: ref.id, Size: int64(len(data))})
+	}
+
+	b := q.newBlob(c, name, existing)
+	b.Blocks = blocks
+	if q.commit(c, b, content) {
+		q.blobWritten(b)
+	}
+}
+
+// getBlockList serves Get Block List
+func (q *blobRequest) getBlockList(c *blobContainer, name string) {
+	b := c.Blobs[name]
+	staged := c.uncommitted[name]
+	if b == nil && len(staged) == 0 {
+		q.error(http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+		return
+	}
+	if b != nil && !q.checkLease(&b.Lease, "blob", true) {
+		return
+	}
+	listType := strings.ToLower(q.r.URL.Query().Get("blocklisttype"))
+	if listType == "" {
+		listType = "committed"
+	}
+	result := blockListXML{}
+	if b != nil && (listType == "committed" || listType == "all") {
+		for _, block := range b.Blocks {
+			result.Committed = append(result.Committed, blockItemXML{Name: block.ID, Size: block.Size})
+		}
+		q.w.Header().Set("ETag", b.ETag)
+		q.w.Header().Set("Last-Modified", b.Modified.Format(http.TimeFormat))
+		q.w.Header().Set("x-ms-blob-content-length", strconv.FormatInt(b.Size, 10))
+	}
+	if listType == "uncommitted" || listType == "all" {
+		for _, block := range staged {
+			result.Uncommitted = append(result.Uncommitted, blockItemXML{Name: block.id, Size: int64(len(block.data))})
+		}
+	}
+	q.replyXML(http.StatusOK, result)
+}
+
+// getBlob serves Get Blob and Get Blob Properties. Get Blob honours a single byte range.
+func (q *blobRequest) getBlob(c *blobContainer, name string, content bool) {
+	b := q.blob(c, name)
+	if b == nil || !q.checkLease(&b.Lease, "blob", true) || !q.conditionsMet(b.ETag, true) {
+		return
+	}
+	h := q.w.Header()
+	h.Set("ETag", b.ETag)
+	h.Set("Last-Modified", b.Modified.Format(http.TimeFormat))
+	h.Set("x-ms-creation-time", b.Created.Format(http.TimeFormat))
+	h.Set("x-ms-blob-type", "BlockBlob")
+	h.Set("x-ms-server-encrypted", "true")
+	h.Set("x-ms-access-tier", "Hot")
+	h.Set("x-ms-access-tier-inferred", "true")
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Type", b.ContentType)
+	for header, value := range map[string]string{
+		"Content-Encoding": b.ContentEncoding, "Content-Language": b.ContentLanguage,
+		"Content-Disposition": b.ContentDisposition, "Cache-Control": b.CacheControl,
+	} {
+		if value != "" {
+			h.Set(header, value)
+		}
+	}
+	status, state, duration := q.leaseProperties(&b.Lease)
+	h.Set("x-ms-lease-status", status)
+	h.Set("x-ms-lease-state", state)
+	if duration != "" {
+		h.Set("x-ms-lease-duration", duration)
+	}
+	for key, value := range b.Metadata {
+		h.Set("x-ms-meta-"+key, value)
+	}
+	if !content {
+		if b.ContentMD5 != "" {
+			h.Set("Content-MD5", b.ContentMD5)
+		}
+		h.Set("Content-Length", strconv.FormatInt(b.Size, 10))
+		q.w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	data, err := q.store.blobBackend.readBlob(q.svc.name, c.Name, b)
+	if err != nil {
+		q.internalError(err)
+		return
+	}
+	spec := q.r.Header.Get("x-ms-range")
+	if spec == "" {
+		spec = q.r.Header.Get("Range")
+	}
+	if spec == "" {
+		if b.ContentMD5 != "" {
+			h.Set("Content-MD5", b.ContentMD5)
+		}
+		h.Set("Content-Length", strconv.Itoa(len(data)))
+		q.w.WriteHeader(http.StatusOK)
+		q.write(data)
+		return
+	}
+	start, end, ok := parseBlobRange(spec, int64(len(data)))
+	if !ok {
+		h.Del("Content-Type")
+		q.error(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The range specified is invalid for the current size of the resource.")
+		return
+	}
+	if b.ContentMD5 != "" {
+		h.Set("x-ms-blob-content-md5", b.ContentMD5)
+	}
+	h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+	h.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	q.w.WriteHeader(http.StatusPartialContent)
+	q.write(data[start : end+1])
+}
+
+// deleteBlob serves Delete Blob
+func (q *blobRequest) deleteBlob(c *blobContainer, name string) {
+	b := q.blob(c, name)
+	if b == nil || !q.checkLease(&b.Lease, "blob", false) || !q.conditionsMet(b.ETag, false) {
+		return
+	}
+	if err := q.store.blobBackend.deleteBlob(q.svc.name, c.Name, b); err != nil {
+		q.internalError(err)
+		return
+	}
+	delete(c.Blobs, name)
+	delete(c.uncommitted, name)
+	q.saveContainer(c)
+	q.w.WriteHeader(http.StatusAccepted)
+}
+
+// lease serves Lease Container and Lease Blob: acquire, renew, change, release and break
+// See https://learn.microsoft.com/rest/api/storageservices/lease-blob
+func (q *blobRequest) lease(l *blobLease, noun string, save func(), etag string, modified time.Time) {
+	if !q.conditionsMet(etag, false) {
+		return
+	}
+	h := q.r.Header
+	leaseID := h.Get("x-ms-lease-id")
+	proposed := h.Get("x-ms-proposed-lease-id")
+	state := l.state(q.now)
+	mismatch := func() {
+		q.error(http.StatusConflict, "LeaseIdMismatchWithLeaseOperation", fmt.Sprintf("The lease ID specified did not match the lease ID for the %s.", noun))
+	}
+	notPresent := func() {
+		q.error(http.StatusConflict, "LeaseNotPresentWithLeaseOperation", fmt.Sprintf("There is currently no lease on the %s.", noun))
+	}
+	requireID := func(value string) bool {
+		if value == "" {
+			q.error(http.StatusBadRequest, "MissingRequiredHeader", "An HTTP header that's mandatory for this request is not specified.")
+			return false
+		}
+		return true
+	}
+
+	status := http.StatusOK
+	switch strings.ToLower(h.Get("x-ms-lease-action")) {
+	case "acquire":
+		duration, err := strconv.Atoi(h.Get("x-ms-lease-duration"))
+		if err != nil || (duration != -1 && (duration < 15 || duration > 60)) {
+			q.error(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+			return
+		}
+		if proposed == "" {
+			proposed = newBlobRequestID()
+		}
+		switch {
+		case state == "breaking":
+			q.error(http.StatusConflict, "LeaseIsBreakingAndCannotBeAcquired", fmt.Sprintf("There is already a breaking lease on the %s.", noun))
+			return
+		case state == "leased" && l.ID != proposed:
+			q.error(http.StatusConflict, "LeaseAlreadyPresent", "There is already a lease present.")
+			return
+		}
+		*l = blobLease{ID: proposed, Duration: duration, Expires: q.now.Add(time.Duration(duration) * time.Second)}
+		status = http.StatusCreated
+	case "renew":
+		switch {
+		case !requireID(leaseID):
+			return
+		case l.ID == "":
+			notPresent()
+			return
+		case l.ID != leaseID:
+			mismatch()
+			return
+		case state == "breaking" || state == "broken":
+			q.error(http.StatusConflict, "LeaseIsBrokenAndCannotBeRenewed", "The lease ID matched, but the lease has been broken explicitly and cannot be renewed.")
+			return
+		}
+		l.Expires = q.now.Add(time.Duration(l.Duration) * time.Second)
+	case "change":
+		switch {
+		case !requireID(leaseID) || !requireID(proposed):
+			return
+		case !l.locked(q.now):
+			notPresent()
+			return
+		case state == "breaking":
+			q.error(http.StatusConflict, "LeaseIsBreakingAndCannotBeChanged", "The lease ID matched, but the lease is currently in breaking state and cannot be changed.")
+			return
+		case l.ID != leaseID && l.ID != proposed:
+			mismatch()
+			return
+		}
+		l.ID = proposed
+	case "release":
+		switch {
+		case !requireID(leaseID):
+			return
+		case l.ID == "":
+			notPresent()
+			return
+		case l.ID != leaseID:
+			mismatch()
+			return
+		}
+		*l = blobLease{}
+	case "break":
+		if state == "available" || state == "expired" {
+			notPresent()
+			return
+		}
+		// Infinite leases break at once by default, fixed ones when they would expire; a break
+		// period can only shorten that
+		period := 0
+		if l.Duration > 0 {
+			period = max(int(l.Expires.Sub(q.now).Seconds()), 0)
+		}
+		if v := h.Get("x-ms-lease-break-period"); v != "" {
+			p, err := strconv.Atoi(v)
+			if err != nil || p < 0 || p > 60 {
+				q.error(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+				return
+			}
+			period = p
+			if l.Duration > 0 {
+				period = min(p, max(int(l.Expires.Sub(q.now).Seconds()), 0))
+			}
+		}
+		if breakAt := q.now.Add(time.Duration(period) * time.Second); state == "leased" || (state == "breaking" && breakAt.Before(l.Broken)) {
+			l.Broken = breakAt
+		}
+		q.w.Header().Set("x-ms-lease-time", strconv.Itoa(max(int(l.Broken.Sub(q.now).Seconds()), 0)))
+		status = http.StatusAccepted
+	default:
+		q.error(http.StatusBadRequest, "InvalidHeaderValue", "The value for one of the HTTP headers is not in the correct format.")
+		return
+	}
+	save()
+	if l.ID != "" && status != http.StatusAccepted {
+		q.w.Header().Set("x-ms-lease-id", l.ID)
+	}
+	q.w.Header().Set("ETag", etag)
+	q.w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	q.w.WriteHeader(status)
+}
+
+// checkLease enforces the lease on a container or blob. Unless the lease ID is optional, as it is
+// for reads, requests to a locked target must carry it; a lease ID on any request must match the
+// active lease.
+func (q *blobRequest) checkLease(l *blobLease, noun string, optional bool) bool {
+	operation := "Blob"
+	if noun == "container" {
+		operation = "Container"
+	}
+	leaseID := q.r.Header.Get("x-ms-lease-id")
+	switch {
+	case leaseID == "" && (optional || !l.locked(q.now)):
+		return true
+	case leaseID == "":
+		q.error(http.StatusPreconditionFailed, "LeaseIdMissing", fmt.Sprintf("There is currently a lease on the %s and no lease ID was specified in the request.", noun))
+		return false
+	case !l.locked(q.now):
+		q.error(http.StatusPreconditionFailed, "LeaseNotPresentWith"+operation+"Operation", fmt.Sprintf("There is currently no lease on the %s.", noun))
+		return false
+	case leaseID != l.ID:
+		q.error(http.StatusPreconditionFailed, "LeaseIdMismatchWith"+operation+"Operation", fmt.Sprintf("The lease ID specified did not match the lease ID for the %s.", noun))
+		return false
+	}
+	return true
+}
+
+// conditionsMet checks If-Match and If-None-Match against an ETag, empty when the target does not
+// exist. Reads whose If-None-Match matches get 304 Not Modified.
+func (q *blobRequest) conditionsMet(etag string, read bool) bool {
+	matches := func(header string) bool {
+		for _, tag := range strings.Split(header, ",") {
+			if tag = strings.TrimSpace(tag); tag == "*" && etag != "" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if m := q.r.Header.Get("If-Match"); m != "" && (etag == "" || !matches(m)) {
+		q.error(http.StatusPreconditionFailed, "ConditionNotMet", "The condition specified using HTTP conditional header(s) is not met.")
+		return false
+	}
+	if m := q.r.Header.Get("If-None-Match"); m != "" && etag != "" && matches(m) {
+		switch {
+		case read:
+			q.w.WriteHeader(http.StatusNotModified)
+		case m == "*":
+			q.error(http.StatusConflict, "BlobAlreadyExists", "The specified blob already exists.")
+		default:
+			q.error(http.StatusPreconditionFailed, "ConditionNotMet", "The condition specified using HTTP conditional header(s) is not met.")
+		}
+		return false
+	}
+	return true
+}
+
+// writable checks the lease and conditions before a blob is overwritten
+func (q *blobRequest) writable(existing *blobItem) bool {
+	if existing == nil {
+		return q.conditionsMet("", false)
+	}
+	return q.checkLease(&existing.Lease, "blob", false) && q.conditionsMet(existing.ETag, false)
+}
+
+// newBlob builds the committed blob a write produces from the request's content headers and
+// metadata. The creation time and lease carry over from the blob it replaces.
+func (q *blobRequest) newBlob(c *blobContainer, name string, existing *blobItem) *blobItem {
+	h := q.r.Header
+	b := &blobItem{
+		Name:               name,
+		Created:            q.now,
+		ContentType:        h.Get("x-ms-blob-content-type"),
+		ContentEncoding:    h.Get("x-ms-blob-content-encoding"),
+		ContentLanguage:    h.Get("x-ms-blob-content-language"),
+		ContentDisposition: h.Get("x-ms-blob-content-disposition"),
+		CacheControl:       h.Get("x-ms-blob-cache-control"),
+		ContentMD5:         h.Get("x-ms-blob-content-md5"),
+		Metadata:           q.metadata(),
+	}
+	if existing != nil {
+		b.Created, b.Lease = existing.Created, existing.Lease
+	}
+	return b
+}
+
+// commit stores a blob's content and makes it the container's committed blob, answering the
+// request when the backend fails
+func (q *blobRequest) commit(c *blobContainer, b *blobItem, data []byte) bool {
+	if b.ContentType == "" {
+		b.ContentType = defaultBlobContentType
+	}
+	b.Size = int64(len(data))
+	b.Modified, b.ETag = q.now, newBlobETag()
+	if err := q.store.blobBackend.writeBlob(q.svc.name, c.Name, b, data); err != nil {
+		q.internalError(err)
+		return false
+	}
+	c.Blobs[b.Name] = b
+	delete(c.uncommitted, b.Name)
+	q.saveContainer(c)
+	return true
+}
+
+// blobWritten answers a request that wrote a blob
+func (q *blobRequest) blobWritten(b *blobItem) {
+	q.w.Header().Set("ETag", b.ETag)
+	q.w.Header().Set("Last-Modified", b.Modified.Format(http.TimeFormat))
+	q.w.Header().Set("x-ms-request-server-encrypted", "true")
+	q.w.WriteHeader(http.StatusCreated)
+}
+
+// body reads the request body, checking it against Content-MD5 when sent
+func (q *blobRequest) body() ([]byte, bool) {
+	data, err := io.ReadAll(q.r.Body)
+	if err != nil {
+		q.error(http.StatusBadRequest, "InvalidInput", "One of the request inputs is not valid.")
+		return nil, false
+	}
+	if expected := q.r.Header.Get("Content-MD5"); expected != "" {
+		sum := md5.Sum(data)
+		if expected != base64.StdEncoding.EncodeToString(sum[:]) {
+			q.error(http.StatusBadRequest, "Md5Mismatch", "The MD5 value specified in the request did not match with the MD5 value calculated by the server.")
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// metadata returns the x-ms-meta-* headers of the request
+func (q *blobRequest) metadata() map[string]string {
+	metadata := map[string]string{}
+	for name, values := range q.r.Header {
+		if key, ok := strings.CutPrefix(strings.ToLower(name), "x-ms-meta-"); ok && key != "" {
+			metadata[key] = strings.Join(values, ",")
+		}
+	}
+	return metadata
+}
+
+// touchContainer records a change to a container's properties
+func (q *blobRequest) touchContainer(c *blobContainer) {
+	c.Modified, c.ETag = q.now, newBlobETag()
+}
+
+// saveContainer writes a container's index through the backend
+func (q *blobRequest) saveContainer(c *blobContainer) {
+	if err := q.store.blobBackend.saveContainer(q.svc.name, c); err != nil {
+		log.Printf("Failed to save container %s/%s: %v", q.svc.name, c.Name, err)
+	}
+}
+
+// containerHeaders sets the headers Get Container Properties returns
+func (q *blobRequest) containerHeaders(c *blobContainer) {
+	h := q.w.Header()
+	h.Set("ETag", c.ETag)
+	h.Set("Last-Modified", c.Modified.Format(http.TimeFormat))
+	status, state, duration := q.leaseProperties(&c.Lease)
+	h.Set("x-ms-lease-status", status)
+	h.Set("x-ms-lease-state", state)
+	if duration != "" {
+		h.Set("x-ms-lease-duration", duration)
+	}
+	if c.PublicAccess != "" {
+		h.Set("x-ms-blob-public-access", c.PublicAccess)
+	}
+	h.Set("x-ms-has-immutability-policy", "false")
+	h.Set("x-ms-has-legal-hold", "false")
+	for key, value := range c.Metadata {
+		h.Set("x-ms-meta-"+key, value)
+	}
+}
+
+// leaseProperties returns the lease status, state and duration reported for a lease
+func (q *blobRequest) leaseProperties(l *blobLease) (string, string, string) {
+	state := l.state(q.now)
+	if state != "leased" {
+		status := "unlocked"
+		if state == "breaking" {
+			status = "locked"
+		}
+		return status, state, ""
+	}
+	if l.Duration == -1 {
+		return "locked", state, "infinite"
+	}
+	return "locked", state, "fixed"
+}
+
+// unsupported answers requests for operations the Blob service does not have or Mockzure does
+// not emulate
+func (q *blobRequest) unsupported() {
+	q.error(http.StatusBadRequest, "UnsupportedQueryParameter", "One of the query parameters specified in the request URI is not supported.")
+}
+
+// internalError answers a request the backend failed
+func (q *blobRequest) internalError(err error) {
+	log.Printf("Blob storage backend failed: %v", err)
+	q.error(http.StatusInternalServerError, "InternalError", "The server encountered an internal error. Please retry the request.")
+}
+
+func (q *blobRequest) error(status int, code, message string) {
+	writeBlobError(q.w, q.r, status, code, message, "")
+}
+
+func (q *blobRequest) replyXML(status int, body interface{}) {
+	data, err := xml.Marshal(body)
+	if err != nil {
+		q.internalError(err)
+		return
+	}
+	q.w.Header().Set("Content-Type", "application/xml")
+	q.w.WriteHeader(status)
+	q.write(append([]byte(xml.Header), data...))
+}
+
+func (q *blobRequest) write(data []byte) {
+	if _, err := q.w.Write(data); err != nil {
+		log.Printf("Failed to write blob response: %v", err)
+	}
+}
+
+// writeBlobError writes a Blob service error; responses to HEAD requests only carry the code
+func writeBlobError(w http.ResponseWriter, r *http.Request, status int, code, message, detail string) {
+	w.Header().Set("x-ms-error-code", code)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	body := struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+		Detail  string   `xml:"AuthenticationErrorDetail,omitempty"`
+	}{
+		Code:    code,
+		Message: fmt.Sprintf("%s\nRequestId:%s\nTime:%s", message, w.Header().Get("x-ms-request-id"), time.Now().UTC().Format("2006-01-02T15:04:05.0000000Z")),
+		Detail:  detail,
+	}
+	data, err := xml.Marshal(body)
+	if err != nil {
+		log.Printf("Failed to encode blob error: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if _, err := w.Write(append([]byte(xml.Header), data...)); err != nil {
+		log.Printf("Failed to write blob error: %v", err)
+	}
+}
+
+// parseBlobRange parses a bytes=start-end range against a blob size, returning inclusive offsets
+func parseBlobRange(spec string, size int64) (int64, int64, bool) {
+	r, ok := strings.CutPrefix(strings.TrimSpace(spec), "bytes=")
+	if !ok || strings.Contains(r, ",") {
+		return 0, 0, false
+	}
+	first, last, _ := strings.Cut(r, "-")
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+// validContainerName reports whether a container name follows the Blob service naming rules: 3 to
+// 63 lowercase letters, digits and single dashes, starting and ending with a letter or digit
+func validContainerName(name string) bool {
+	if len(name) < 3 || len(name) > 63 || name[0] == '-' || name[len(name)-1] == '-' || strings.Contains(name, "--") {
+		return false
+	}
+	for _, ch := range name {
+		if (ch < 'a' || ch > 'z') && (ch < '0' || ch > '9') && ch != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// newBlobETag returns a new ETag in the Blob service's format
+func newBlobETag() string {
+	return fmt.Sprintf("\"0x%X\"", time.Now().UnixNano()+blobETagSequence.Add(1))
+}
+
+// newBlobRequestID returns a random GUID for request and lease IDs
+func newBlobRequestID() string {
+	h := newVaultVersion()
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
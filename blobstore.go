@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// blobAccount is the Blob service of a storage account
+type blobAccount struct {
+	name       string
+	containers map[string]*blobContainer // by name
+}
+
+// blobContainer is a container with its committed blobs. Exported fields make up the index that
+// persistent backends keep per container.
+type blobContainer struct {
+	Name         string               `json:"name"`
+	Created      time.Time            `json:"created"`
+	Modified     time.Time            `json:"modified"`
+	ETag         string               `json:"etag"`
+	Metadata     map[string]string    `json:"metadata,omitempty"`
+	PublicAccess string               `json:"publicAccess,omitempty"` // "", "blob" or "container"
+	Lease        blobLease            `json:"lease"`
+	Blobs        map[string]*blobItem `json:"blobs"`
+
+	uncommitted map[string][]*blobBlockData // staged blocks by blob name, in upload order
+}
+
+// blobItem is a committed block blob
+type blobItem struct {
+	Name               string            `json:"name"`
+	Size               int64             `json:"size"`
+	Created            time.Time         `json:"created"`
+	Modified           time.Time         `json:"modified"`
+	ETag               string            `json:"etag"`
+	ContentType        string            `json:"contentType,omitempty"`
+	ContentEncoding    string            `json:"contentEncoding,omitempty"`
+	ContentLanguage    string            `json:"contentLanguage,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	ContentMD5         string            `json:"contentMD5,omitempty"` // base64
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Blocks             []blobBlock       `json:"blocks,omitempty"` // committed block list, in content order
+	Lease              blobLease         `json:"lease"`
+
+	data []byte // content, when the backend keeps it in memory
+}
+
+// blobBlock is a committed block of a blob
+type blobBlock struct {
+	ID   string `json:"id"` // base64 block ID
+	Size int64  `json:"size"`
+}
+
+// blobBlockData is a staged block waiting for a block list to commit it
+type blobBlockData struct {
+	id   string
+	data []byte
+}
+
+// blobLease is the lease on a container or blob; an empty ID means no lease
+type blobLease struct {
+	ID       string    `json:"id,omitempty"`
+	Duration int       `json:"duration,omitempty"` // seconds, or -1 for an infinite lease
+	Expires  time.Time `json:"expires,omitempty"`
+	Broken   time.Time `json:"broken,omitempty"` // when a break ends; zero unless the lease was broken
+}
+
+// state returns the lease state as the Blob service reports it
+func (l *blobLease) state(now time.Time) string {
+	switch {
+	case l.ID == "":
+		return "available"
+	case !l.Broken.IsZero() && now.Before(l.Broken):
+		return "breaking"
+	case !l.Broken.IsZero():
+		return "broken"
+	case l.Duration > 0 && !now.Before(l.Expires):
+		return "expired"
+	}
+	return "leased"
+}
+
+// locked reports whether the lease keeps writers without its ID out
+func (l *blobLease) locked(now time.Time) bool {
+	state := l.state(now)
+	return state == "leased" || state == "breaking"
+}
+
+// blobBackend keeps the contents of committed blobs. Container and blob properties live in the
+// store; persistent backends also write them to an index per container and read it back when an
+// account is first used.
+type blobBackend interface {
+	loadAccount(account string) (map[string]*blobContainer, error)
+	saveContainer(account string, c *blobContainer) error
+	deleteContainer(account string, c *blobContainer) error
+	deleteAccount(account string) error
+	readBlob(account, container string, b *blobItem) ([]byte, error)
+	writeBlob(account, container string, b *blobItem, data []byte) error
+	deleteBlob(account, container string, b *blobItem) error
+}
+
+// memoryBlobs keeps blobs in memory, for as long as Mockzure runs
+type memoryBlobs struct{}
+
+func (memoryBlobs) loadAccount(string) (map[string]*blobContainer, error) {
+	return map[string]*blobContainer{}, nil
+}
+
+func (memoryBlobs) saveContainer(string, *blobContainer) error   { return nil }
+func (memoryBlobs) deleteContainer(string, *blobContainer) error { return nil }
+func (memoryBlobs) deleteAccount(string) error                   { return nil }
+
+func (memoryBlobs) readBlob(_, _ string, b *blobItem) ([]byte, error) {
+	return b.data, nil
+}
+
+func (memoryBlobs) writeBlob(_, _ string, b *blobItem, data []byte) error {
+	b.data = data
+	return nil
+}
+
+func (memoryBlobs) deleteBlob(string, string, *blobItem) error { return nil }
+
+// fileBlobs keeps blobs under a directory, as {account}/{container}/container.json for the index
+// and {account}/{container}/blobs/{sha256 of the blob name} for the contents
+type fileBlobs struct {
+	root string
+}
+
+func (f fileBlobs) containerDir(account, container string) string {
+	return filepath.Join(f.root, account, container)
+}
+
+func (f fileBlobs) blobFile(account, container, name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(f.containerDir(account, container), "blobs", hex.EncodeToString(sum[:]))
+}
+
+func (f fileBlobs) loadAccount(account string) (map[string]*blobContainer, error) {
+	containers := map[string]*blobContainer{}
+	entries, err := os.ReadDir(filepath.Join(f.root, account))
+	if errors.Is(err, os.ErrNotExist) {
+		return containers, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.root, account, entry.Name(), "container.json"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		c := &blobContainer{}
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("decode %s/%s index: %w", account, entry.Name(), err)
+		}
+		if c.Blobs == nil {
+			c.Blobs = map[string]*blobItem{}
+		}
+		containers[c.Name] = c
+	}
+	return containers, nil
+}
+
+func (f fileBlobs) saveContainer(account string, c *blobContainer) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(f.containerDir(account, c.Name), "container.json"), data)
+}
+
+func (f fileBlobs) deleteContainer(account string, c *blobContainer) error {
+	return os.RemoveAll(f.containerDir(account, c.Name))
+}
+
+func (f fileBlobs) deleteAccount(account string) error {
+	return os.RemoveAll(filepath.Join(f.root, account))
+}
+
+func (f fileBlobs) readBlob(account, container string, b *blobItem) ([]byte, error) {
+	data, err := os.ReadFile(f.blobFile(account, container, b.Name))
+	if errors.Is(err, os.ErrNotExist) && b.Size == 0 {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f fileBlobs) writeBlob(account, container string, b *blobItem, data []byte) error {
+	return writeFileAtomic(f.blobFile(account, container, b.Name), data)
+}
+
+func (f fileBlobs) deleteBlob(account, container string, b *blobItem) error {
+	err := os.Remove(f.blobFile(account, container, b.Name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// writeFileAtomic replaces a file through a temporary file, creating its directory as needed
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// blobService returns the Blob service of a storage account, loading it from the backend on
+// first use. The caller holds s.mu.
+func (s *Store) blobService(account *GenericResource) (*blobAccount, error) {
+	name := strings.ToLower(account.Name)
+	if svc := s.blobAccounts[name]; svc != nil {
+		return svc, nil
+	}
+	containers, err := s.blobBackend.loadAccount(name)
+	if err != nil {
+		return nil, err
+	}
+	svc := &blobAccount{name: name, containers: containers}
+	s.blobAccounts[name] = svc
+	return svc, nil
+}
+
+// deleteBlobData drops the blobs of the storage accounts with an ID or ID prefix. The caller
+// holds s.mu and has not yet removed the accounts.
+func (s *Store) deleteBlobData(id string) {
+	for _, r := range s.resources {
+		if !strings.EqualFold(r.Type, storageAccountType) || (!strings.EqualFold(r.ID, id) && !hasIDPrefix(r.ID, id)) {
+			continue
+		}
+		name := strings.ToLower(r.Name)
+		delete(s.blobAccounts, name)
+		if err := s.blobBackend.deleteAccount(name); err != nil {
+			log.Printf("Failed to delete blobs of storage account %s: %v", name, err)
+		}
+	}
+}
+
+// clearBlobData drops the blobs of every storage account
+func (s *Store) clearBlobData() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.resources {
+		if strings.EqualFold(r.Type, storageAccountType) {
+			s.deleteBlobData(r.ID)
+		}
+	}
+	s.blobAccounts = map[string]*blobAccount{}
+}
@@ -0,0 +1,689 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+	"gopkg.in/yaml.v3"
+)
+
+// Config files are checked against the config types and against each other: unknown keys,
+// values of the wrong type, invalid enum values, duplicates, references to things the file does
+// not define and IDs that disagree with the names next to them. `mockzure validate` reports every
+// problem and fails; loadConfig logs them as warnings and loads the file as before.
+
+// configProblem is a problem found in a config file
+type configProblem struct {
+	Line    int    // 1-based, or 0 when the problem has no position
+	Path    string // key path, such as vms[0].resourceGroup
+	Message string
+}
+
+func (p configProblem) String() string {
+	var b strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&b, "%d: ", p.Line)
+	}
+	if p.Path != "" {
+		b.WriteString(p.Path + ": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// configEnum lists the values an enum field accepts
+type configEnum struct {
+	values   []string
+	foldCase bool // values are compared case-insensitively
+}
+
+// configEnums are the enum fields of the config types, by type name and key. Enums on string
+// lists apply to every item.
+var configEnums = map[string]configEnum{
+	"Subscription.state":               {values: []string{"Enabled", "Warned", "PastDue", "Disabled", "Deleted"}},
+	"MockVM.osType":                    {values: []string{"Linux", "Windows"}, foldCase: true},
+	"MockVM.status":                    {values: []string{"running", "stopped"}},
+	"MockVM.powerState":                {values: []string{"VM running", "VM deallocated", "VM stopped", "VM starting", "VM stopping", "VM deallocating"}},
+	"MockVM.provisioningState":         {values: []string{"Succeeded", "Failed", "Creating", "Updating", "Deleting", "Canceled"}},
+	"VMDisk.storageAccountType":        {values: []string{"Standard_LRS", "StandardSSD_LRS", "StandardSSD_ZRS", "Premium_LRS", "Premium_ZRS", "PremiumV2_LRS", "UltraSSD_LRS"}},
+	"VMDisk.caching":                   {values: []string{"None", "ReadOnly", "ReadWrite"}},
+	"VMDisk.createOption":              {values: []string{"FromImage", "Empty", "Attach", "Copy", "Restore"}},
+	"VMAgent.status":                   {values: []string{"Ready", "Not Ready"}},
+	"MockUser.userType":                {values: []string{"Member", "Guest"}},
+	"ResourceGroupPerm.permissions":    {values: []string{"read", "write", "start", "stop", "restart", "delete", "*"}},
+	"RunCommandResponder.commandId":    {values: []string{"RunShellScript", "RunPowerShellScript", "CustomScript"}},
+	"FullConfig.stateBackend":          {values: []string{"files", "bolt"}, foldCase: true},
+	"FaultRule.method":                 {values: []string{"GET", "HEAD", "PUT", "POST", "PATCH", "DELETE", "OPTIONS"}, foldCase: true},
+	"FaultRule.failureCause":           {values: []string{"service", "gateway"}},
+	"SkuRestriction.reasonCode":        {values: []string{"NotAvailableForSubscription", "QuotaId"}},
+	"KeyVaultPermissions.secrets":      {values: []string{"get", "list", "set", "delete", "recover", "backup", "restore", "purge", "all"}, foldCase: true},
+	"KeyVaultPermissions.certificates": {values: []string{"get", "list", "create", "update", "import", "delete", "recover", "backup", "restore", "purge", "managecontacts", "manageissuers", "getissuers", "listissuers", "setissuers", "deleteissuers", "all"}, foldCase: true},
+	"KeyVaultPermissions.keys":         {values: []string{"get", "list", "create", "update", "import", "delete", "recover", "backup", "restore", "purge", "decrypt", "encrypt", "unwrapKey", "wrapKey", "verify", "sign", "release", "rotate", "getrotationpolicy", "setrotationpolicy", "all"}, foldCase: true},
+}
+
+// accepts reports whether the enum accepts a value
+func (e configEnum) accepts(value string) bool {
+	for _, v := range e.values {
+		if v == value || e.foldCase && strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	yamlLinePrefix = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+)
+
+// configChecker collects the problems of a config document
+type configChecker struct {
+	nodes    map[string]*yaml.Node // value nodes by key path
+	problems []configProblem
+}
+
+// validateConfig checks a YAML or JSON config document and returns its problems by line
+func validateConfig(data []byte) []configProblem {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return []configProblem{yamlProblem(err.Error())}
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+	c := &configChecker{nodes: map[string]*yaml.Node{}}
+	doc := root.Content[0]
+	c.walk(doc, reflect.TypeOf(FullConfig{}), "")
+
+	var fc FullConfig
+	if err := doc.Decode(&fc); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, msg := range typeErr.Errors {
+				c.problems = append(c.problems, yamlProblem(msg))
+			}
+		} else {
+			c.problems = append(c.problems, yamlProblem(err.Error()))
+		}
+	}
+	c.checkReferences(&fc)
+
+	sort.SliceStable(c.problems, func(i, j int) bool { return c.problems[i].Line < c.problems[j].Line })
+	return c.problems
+}
+
+// yamlProblem turns a YAML error message into a problem at the line it names
+func yamlProblem(msg string) configProblem {
+	if m := yamlLinePrefix.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return configProblem{Line: line, Message: msg[len(m[0]):]}
+	}
+	return configProblem{Message: strings.TrimPrefix(msg, "yaml: ")}
+}
+
+// walk indexes the nodes below node by key path and reports the keys the type t does not have
+// and invalid enum values. Values of the wrong kind are left to the decoder, which reports them.
+func (c *configChecker) walk(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	c.nodes[path] = node
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		// The decoder stops at the first time it cannot parse; report it here and decode it as unset
+		var value time.Time
+		if err := node.Decode(&value); err != nil {
+			c.problems = append(c.problems, configProblem{Line: node.Line, Path: path, Message: fmt.Sprintf("invalid time %q; expected RFC 3339, such as 2024-01-01T00:00:00Z", node.Value)})
+			node.Kind, node.Tag, node.Value = yaml.ScalarNode, "!!null", "null"
+		}
+	case t.Kind() == reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := configFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				continue // merge keys are checked where their anchors are defined
+			}
+			childPath := joinConfigPath(path, key.Value)
+			field, ok := fields[key.Value]
+			if !ok {
+				c.problems = append(c.problems, configProblem{Line: key.Line, Path: childPath, Message: fmt.Sprintf("unknown key %q%s", key.Value, suggestKey(key.Value, fields))})
+				continue
+			}
+			c.walk(value, field.Type, childPath)
+			if enum, ok := configEnums[t.Name()+"."+key.Value]; ok && len(enum.values) > 0 {
+				c.checkEnum(value, enum, key.Value, childPath)
+			}
+		}
+	case t.Kind() == reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			c.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case t.Kind() == reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			c.walk(node.Content[i+1], t.Elem(), joinConfigPath(path, node.Content[i].Value))
+		}
+	}
+}
+
+// checkEnum reports a scalar, or the items of a list, that an enum does not accept
+func (c *configChecker) checkEnum(node *yaml.Node, enum configEnum, key, path string) {
+	values := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		values = node.Content
+	}
+	for _, value := range values {
+		if value.Kind != yaml.ScalarNode || value.Value == "" || enum.accepts(value.Value) {
+			continue
+		}
+		c.problems = append(c.problems, configProblem{Line: value.Line, Path: path, Message: fmt.Sprintf("invalid %s %q; expected one of %s", key, value.Value, strings.Join(enum.values, ", "))})
+	}
+}
+
+// configFields returns the fields of a config type by their YAML key
+func configFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			for key, inner := range configFields(field.Type) {
+				fields[key] = inner
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// suggestKey names the key an unknown key differs from only in case, if any
+func suggestKey(key string, fields map[string]reflect.StructField) string {
+	for name := range fields {
+		if strings.EqualFold(name, key) {
+			return fmt.Sprintf("; did you mean %q?", name)
+		}
+	}
+	return ""
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// add reports a problem at the line of the node at path, or of its nearest indexed parent
+func (c *configChecker) add(path, format string, args ...interface{}) {
+	c.problems = append(c.problems, configProblem{Line: c.line(path), Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *configChecker) line(path string) int {
+	for path != "" {
+		if node, ok := c.nodes[path]; ok {
+			return node.Line
+		}
+		if i := strings.LastIndexAny(path, ".["); i >= 0 {
+			path = path[:i]
+		} else {
+			path = ""
+		}
+	}
+	return 0
+}
+
+// unique reports a value that was already used at another path of the same collection
+func (c *configChecker) unique(seen map[string]string, value, path, what string) {
+	if value == "" {
+		return
+	}
+	key := strings.ToLower(value)
+	if first, ok := seen[key]; ok {
+		c.add(path, "duplicate %s %q, first defined on line %d", what, value, c.line(first))
+		return
+	}
+	seen[key] = path
+}
+
+// checkReferences reports duplicates, dangling references and IDs that disagree with the names
+// next to them. Collections the config leaves out get the defaults loadConfig gives them.
+func (c *configChecker) checkReferences(fc *FullConfig) {
+	tenants := map[string]string{}
+	for i, t := range fc.Tenants {
+		path := fmt.Sprintf("tenants[%d]", i)
+		if t == nil {
+			continue
+		}
+		if t.ID == "" {
+			c.add(path, "id is required")
+		}
+		c.unique(tenants, t.ID, path+".id", "tenant ID")
+	}
+	if len(fc.Tenants) == 0 {
+		tenants[strings.ToLower(defaultTenantID)] = ""
+	}
+	checkTenant := func(id, path string) {
+		if _, ok := tenants[strings.ToLower(id)]; id != "" && !ok {
+			c.add(path, "tenant %q is not configured", id)
+		}
+	}
+
+	subscriptions := map[string]string{}
+	for i, sub := range fc.Subscriptions {
+		path := fmt.Sprintf("subscriptions[%d]", i)
+		if sub == nil {
+			continue
+		}
+		if sub.ID == "" {
+			c.add(path, "id is required")
+		}
+		c.unique(subscriptions, sub.ID, path+".id", "subscription ID")
+		checkTenant(sub.TenantID, path+".tenantId")
+	}
+	// Without subscriptions, loadConfig derives them from the resource groups
+	declaredSubscriptions := len(fc.Subscriptions) > 0
+	checkSubscription := func(id, path string) {
+		if _, ok := subscriptions[strings.ToLower(id)]; declaredSubscriptions && id != "" && !ok {
+			c.add(path, "subscription %q is not configured", id)
+		}
+	}
+
+	resourceGroups := map[string]string{} // by lowercased subscription/name
+	groupNames := map[string]bool{}
+	for i, rg := range fc.ResourceGroups {
+		path := fmt.Sprintf("resourceGroups[%d]", i)
+		if rg == nil {
+			continue
+		}
+		if rg.Name == "" {
+			c.add(path, "name is required")
+		}
+		subID := rg.SubscriptionID
+		if rg.ID != "" {
+			parts := strings.Split(strings.Trim(rg.ID, "/"), "/")
+			switch {
+			case len(parts) != 4 || !strings.EqualFold(parts[0], "subscriptions") || !strings.EqualFold(parts[2], "resourceGroups"):
+				c.add(path+".id", "%q is not a resource group ID of the form /subscriptions/{id}/resourceGroups/{name}", rg.ID)
+			default:
+				if rg.Name != "" && !strings.EqualFold(parts[3], rg.Name) {
+					c.add(path+".id", "id names resource group %q but name is %q", parts[3], rg.Name)
+				}
+				if subID != "" && !strings.EqualFold(parts[1], subID) {
+					c.add(path+".subscriptionId", "subscriptionId %q differs from subscription %q in the id", subID, parts[1])
+				}
+				subID = parts[1]
+			}
+		}
+		if !declaredSubscriptions && subID != "" {
+			subscriptions[strings.ToLower(subID)] = path
+		}
+		checkSubscription(subID, path+".id")
+		c.unique(resourceGroups, subID+"/"+rg.Name, path+".name", "resource group")
+		groupNames[strings.ToLower(rg.Name)] = true
+	}
+	checkGroupName := func(name, path string) {
+		if name != "" && name != "*" && !groupNames[strings.ToLower(name)] {
+			c.add(path, "resource group %q is not configured", name)
+		}
+	}
+	// groupExists checks the resource group an ARM ID lies in
+	groupExists := func(rid *mappers.ResourceID, path string) {
+		if rid.ResourceGroup == "" {
+			return
+		}
+		if _, ok := resourceGroups[strings.ToLower(rid.SubscriptionID+"/"+rid.ResourceGroup)]; !ok {
+			c.add(path, "resource group %q of subscription %q is not configured", rid.ResourceGroup, rid.SubscriptionID)
+		}
+	}
+
+	resourceIDs := map[string]string{}
+	for i, r := range fc.Resources {
+		if r != nil {
+			c.unique(resourceIDs, r.ID, fmt.Sprintf("resources[%d].id", i), "resource ID")
+		}
+	}
+	vmIDs := map[string]string{}
+	vmNames := map[string]bool{}
+	for i, vm := range fc.VMs {
+		path := fmt.Sprintf("vms[%d]", i)
+		if vm == nil {
+			continue
+		}
+		for j, nic := range vm.NetworkInterfaces {
+			if _, ok := resourceIDs[strings.ToLower(nic.ID)]; nic.ID != "" && !ok {
+				c.add(fmt.Sprintf("%s.networkInterfaces[%d].id", path, j), "network interface %q is not configured in resources", nic.ID)
+			}
+		}
+		for j, disk := range vm.DataDisks {
+			if _, ok := resourceIDs[strings.ToLower(disk.ID)]; disk.ID != "" && !ok {
+				c.add(fmt.Sprintf("%s.dataDisks[%d].id", path, j), "disk %q is not configured in resources", disk.ID)
+			}
+		}
+		if vm.OSDisk != nil && vm.OSDisk.ID != "" {
+			if _, ok := resourceIDs[strings.ToLower(vm.OSDisk.ID)]; !ok {
+				c.add(path+".osDisk.id", "disk %q is not configured in resources", vm.OSDisk.ID)
+			}
+		}
+		if vm.ID == "" {
+			// The resource group named next to the missing id is still checked
+			c.add(path, "id is required")
+			checkGroupName(vm.ResourceGroup, path+".resourceGroup")
+			continue
+		}
+		c.unique(vmIDs, vm.ID, path+".id", "VM ID")
+		vmNames[strings.ToLower(vm.Name)] = true
+		rid, err := mappers.ParseResourceID(vm.ID)
+		if err != nil || rid.ResourceGroup == "" || !strings.EqualFold(rid.Type(), "Microsoft.Compute/virtualMachines") {
+			c.add(path+".id", "%q is not a virtual machine ID", vm.ID)
+			continue
+		}
+		if vm.Name != "" && !strings.EqualFold(rid.Name(), vm.Name) {
+			c.add(path+".id", "id names VM %q but name is %q", rid.Name(), vm.Name)
+		}
+		if vm.ResourceGroup != "" && !strings.EqualFold(rid.ResourceGroup, vm.ResourceGroup) {
+			c.add(path+".resourceGroup", "resourceGroup %q differs from resource group %q in the id", vm.ResourceGroup, rid.ResourceGroup)
+		}
+		checkSubscription(rid.SubscriptionID, path+".id")
+		groupExists(rid, path+".resourceGroup")
+	}
+
+	for i, r := range fc.Resources {
+		path := fmt.Sprintf("resources[%d]", i)
+		if r == nil {
+			continue
+		}
+		if r.ID == "" {
+			c.add(path, "id is required")
+			continue
+		}
+		rid, err := mappers.ParseResourceID(r.ID)
+		if err != nil {
+			c.add(path+".id", "%q is not a provider resource ID", r.ID)
+			continue
+		}
+		if r.Name != "" && !strings.EqualFold(rid.Name(), r.Name) {
+			c.add(path+".name", "name %q differs from %q in the id", r.Name, rid.Name())
+		}
+		if r.Type != "" && !strings.EqualFold(rid.Type(), r.Type) {
+			c.add(path+".type", "type %q differs from %q in the id", r.Type, rid.Type())
+		}
+		checkSubscription(rid.SubscriptionID, path+".id")
+		groupExists(rid, path+".id")
+		if parent := rid.Parent(); parent != nil {
+			id := strings.ToLower(parent.String())
+			if _, ok := resourceIDs[id]; !ok {
+				if _, ok := vmIDs[id]; !ok {
+					c.add(path+".id", "parent resource %q is not configured", parent.String())
+				}
+			}
+		}
+	}
+
+	users := map[string]string{}
+	principalNames := map[string]string{}
+	objectIDs := map[string]bool{}
+	for i, u := range fc.Users {
+		path := fmt.Sprintf("users[%d]", i)
+		if u == nil {
+			continue
+		}
+		if u.ID == "" {
+			c.add(path, "id is required")
+		}
+		c.unique(users, u.ID, path+".id", "user ID")
+		c.unique(principalNames, u.UserPrincipalName, path+".userPrincipalName", "userPrincipalName")
+		objectIDs[strings.ToLower(u.ID)] = true
+		checkTenant(u.TenantID, path+".tenantId")
+		for j, name := range u.ResourceGroups {
+			checkGroupName(name, fmt.Sprintf("%s.resourceGroups[%d]", path, j))
+		}
+		for j, sub := range u.Subscriptions {
+			checkSubscription(sub, fmt.Sprintf("%s.subscriptions[%d]", path, j))
+		}
+		for j, perm := range u.Permissions {
+			checkGroupName(perm.ResourceGroup, fmt.Sprintf("%s.permissions[%d].resourceGroup", path, j))
+		}
+	}
+
+	applications := map[string]string{}
+	accounts := map[string]string{}
+	for i, sa := range fc.ServiceAccounts {
+		path := fmt.Sprintf("serviceAccounts[%d]", i)
+		if sa.ApplicationID == "" {
+			c.add(path, "applicationId is required")
+		}
+		c.unique(applications, sa.ApplicationID, path+".applicationId", "applicationId")
+		c.unique(accounts, sa.ID, path+".id", "service account ID")
+		objectIDs[strings.ToLower(sa.ID)] = true
+		checkTenant(sa.TenantID, path+".tenantId")
+		for j, sub := range sa.Subscriptions {
+			checkSubscription(sub, fmt.Sprintf("%s.subscriptions[%d]", path, j))
+		}
+		for j, perm := range sa.Permissions {
+			checkGroupName(perm.ResourceGroup, fmt.Sprintf("%s.permissions[%d].resourceGroup", path, j))
+		}
+	}
+
+	sizes := map[string]string{}
+	for i, size := range fc.VMSizes {
+		if size != nil {
+			c.unique(sizes, size.Name, fmt.Sprintf("vmSizes[%d].name", i), "VM size")
+		}
+	}
+
+	for i, responder := range fc.RunCommands {
+		path := fmt.Sprintf("runCommands[%d]", i)
+		if responder == nil {
+			continue
+		}
+		if _, err := regexp.Compile(responder.Script); err != nil {
+			c.add(path+".script", "invalid script pattern: %v", err)
+		}
+		if vm := responder.VM; vm != "" && !vmNames[strings.ToLower(vm)] {
+			if _, ok := vmIDs[strings.ToLower(vm)]; !ok {
+				c.add(path+".vm", "VM %q is not configured", vm)
+			}
+		}
+	}
+
+	faultIDs := map[string]string{}
+	for i, rule := range fc.Faults {
+		path := fmt.Sprintf("faults[%d]", i)
+		if rule == nil {
+			continue
+		}
+		c.unique(faultIDs, rule.ID, path+".id", "fault rule ID")
+		if _, err := rule.validate(); err != nil {
+			c.add(path, "%v", err)
+		}
+	}
+
+	if fc.StateBackend != "" && fc.StateDir == "" {
+		c.add("stateBackend", "stateBackend needs a stateDir")
+	}
+
+	if fc.Throttling != nil {
+		if _, err := newThrottler(fc.Throttling); err != nil {
+			c.add("throttling", "%v", err)
+		}
+	}
+
+	vaults := map[string]string{}
+	for i, vault := range fc.KeyVaults {
+		path := fmt.Sprintf("keyVaults[%d]", i)
+		if vault == nil {
+			continue
+		}
+		if !vaultNamePattern.MatchString(vault.Name) {
+			c.add(path+".name", "invalid vault name %q", vault.Name)
+		}
+		c.unique(vaults, vault.Name, path+".name", "vault name")
+		checkTenant(vault.TenantID, path+".tenantId")
+		if days := vault.SoftDeleteRetentionInDays; days != 0 && (days < 7 || days > 90) {
+			c.add(path+".softDeleteRetentionInDays", "softDeleteRetentionInDays must be between 7 and 90")
+		}
+		for j, secret := range vault.Secrets {
+			if !objectNamePattern.MatchString(secret.Name) {
+				c.add(fmt.Sprintf("%s.secrets[%d].name", path, j), "invalid secret name %q", secret.Name)
+			}
+		}
+		for j, policy := range vault.AccessPolicies {
+			policyPath := fmt.Sprintf("%s.accessPolicies[%d]", path, j)
+			if id := policy.ObjectID; id != "" && !objectIDs[strings.ToLower(id)] {
+				c.add(policyPath+".objectId", "no user or service account has ID %q", id)
+			}
+			if _, ok := applications[strings.ToLower(policy.ApplicationID)]; policy.ApplicationID != "" && !ok {
+				c.add(policyPath+".applicationId", "no service account has applicationId %q", policy.ApplicationID)
+			}
+		}
+	}
+}
+
+// runValidate implements `mockzure validate`, which checks a config file without starting the
+// server. It returns the exit code: 0 when the file is valid, 1 when it has problems and 2 when
+// it cannot be checked.
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "Path to config file (json|yaml). Can also use MOCKZURE_CONFIG env var")
+	printSchema := flags.Bool("schema", false, "Print the JSON Schema of config files")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *printSchema {
+		if _, err := stdout.Write(configSchemaJSON()); err != nil {
+			return 2
+		}
+		return 0
+	}
+
+	path := *configPath
+	if path == "" {
+		path = os.Getenv("MOCKZURE_CONFIG")
+	}
+	if path == "" {
+		fmt.Fprintln(stderr, "config path required via --config or MOCKZURE_CONFIG")
+		return 2
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "read config: %v\n", err)
+		return 2
+	}
+	problems := validateConfig(data)
+	for _, problem := range problems {
+		fmt.Fprintf(stdout, "%s:%s\n", path, problem)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(stdout, "%d problem(s) found\n", len(problems))
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s: OK\n", path)
+	return 0
+}
+
+// configSchemaJSON returns the JSON Schema of config files, generated from the config types and
+// the enums the validator checks. config.schema.json is its published copy.
+func configSchemaJSON() []byte {
+	definitions := map[string]interface{}{}
+	root := configObjectSchema(reflect.TypeOf(FullConfig{}), definitions)
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	root["title"] = "Mockzure configuration"
+	root["definitions"] = definitions
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		panic(err) // the schema holds only maps, slices and strings
+	}
+	return append(data, '\n')
+}
+
+// configObjectSchema returns the schema of a config struct type
+func configObjectSchema(t reflect.Type, definitions map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for key, field := range configFields(t) {
+		enum, ok := configEnums[t.Name()+"."+key]
+		if !ok {
+			enum = configEnum{}
+		}
+		properties[key] = configTypeSchema(field.Type, enum, definitions)
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// configTypeSchema returns the schema of a field type, referencing struct types by name
+func configTypeSchema(t reflect.Type, enum configEnum, definitions map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		if _, ok := definitions[t.Name()]; !ok {
+			definitions[t.Name()] = nil // reserved while the type's fields are described
+			definitions[t.Name()] = configObjectSchema(t, definitions)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": configTypeSchema(t.Elem(), enum, definitions)}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": configTypeSchema(t.Elem(), configEnum{}, definitions)}
+	case t.Kind() == reflect.String:
+		schema := map[string]interface{}{"type": "string"}
+		if len(enum.values) > 0 {
+			values := append([]string{}, enum.values...)
+			if enum.foldCase {
+				for _, v := range enum.values {
+					if lower := strings.ToLower(v); lower != v {
+						values = append(values, lower)
+					}
+				}
+			}
+			schema["enum"] = values
+		}
+		return schema
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
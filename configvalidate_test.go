@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	t.Run("example configs are valid", func(t *testing.T) {
+		for _, path := range []string{"config.yaml.example", "config.json.example"} {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if problems := validateConfig(data); len(problems) != 0 {
+				t.Errorf("%s: expected no problems, got %v", path, problems)
+			}
+		}
+	})
+
+	t.Run("problems are reported by line", func(t *testing.T) {
+		config := `tenants:
+  - id: tenant-a
+subscriptions:
+  - id: sub-1
+    tenantId: tenant-b
+    state: Active
+resourceGroups:
+  - id: /subscriptions/sub-1/resourceGroups/rg-a
+    name: rg-b
+  - id: /subscriptions/sub-1/resourceGroups/rg-c
+    name: rg-c
+  - name: rg-c
+    subscriptionId: sub-1
+vms:
+  - id: /subscriptions/sub-1/resourceGroups/rg-missing/providers/Microsoft.Compute/virtualMachines/vm-1
+    name: vm-2
+    vmsize: Standard_B2s
+    status: Running
+    lastUpdated: yesterday
+  - name: vm-3
+    resourceGroup: rg-gone
+serviceAccounts:
+  - applicationId: app-1
+    permissions:
+      - resourceGroup: rg-x
+        permissions: [read, fly]
+  - applicationId: app-1
+resources:
+  - id: /subscriptions/sub-1/resourceGroups/rg-c/providers/Microsoft.Network/virtualNetworks/vnet/subnets/snet
+users:
+  - id: user-1
+    accountEnabled: maybe
+`
+		problems := validateConfig([]byte(config))
+		want := []struct {
+			line    int
+			message string
+		}{
+			{5, `subscriptions[0].tenantId: tenant "tenant-b" is not configured`},
+			{6, `invalid state "Active"`},
+			{8, `id names resource group "rg-a" but name is "rg-b"`},
+			{12, `duplicate resource group "sub-1/rg-c", first defined on line 11`},
+			{15, `id names VM "vm-1" but name is "vm-2"`},
+			{15, `resource group "rg-missing" of subscription "sub-1" is not configured`},
+			{17, `unknown key "vmsize"; did you mean "vmSize"?`},
+			{18, `invalid status "Running"`},
+			{19, `invalid time "yesterday"`},
+			{20, `vms[1]: id is required`},
+			{21, `resource group "rg-gone" is not configured`},
+			{25, `resource group "rg-x" is not configured`},
+			{26, `invalid permissions "fly"`},
+			{27, `duplicate applicationId "app-1", first defined on line 23`},
+			{29, `parent resource "/subscriptions/sub-1/resourceGroups/rg-c/providers/Microsoft.Network/virtualNetworks/vnet" is not configured`},
+			{32, "cannot unmarshal !!str `maybe` into bool"},
+		}
+		for _, w := range want {
+			found := false
+			for _, p := range problems {
+				if p.Line == w.line && strings.Contains(p.String(), w.message) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected a problem on line %d containing %q", w.line, w.message)
+			}
+		}
+		if len(problems) != len(want) {
+			t.Errorf("expected %d problems, got %d:\n%v", len(want), len(problems), problems)
+		}
+	})
+
+	t.Run("syntax errors", func(t *testing.T) {
+		problems := validateConfig([]byte("vms:\n  - id: a\n name: b\n"))
+		if len(problems) != 1 || problems[0].Line == 0 || strings.HasPrefix(problems[0].Message, "yaml:") {
+			t.Errorf("expected one problem with the line of the syntax error, got %v", problems)
+		}
+	})
+}
+
+func TestValidateCommand(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(bad, []byte("users:\n  - id: u1\n    userType: Admin\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runValidate([]string{"--config", "config.yaml.example"}, &stdout, &stderr); code != 0 {
+		t.Errorf("expected the example to pass, got %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	stdout.Reset()
+	if code := runValidate([]string{"--config", bad}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if want := bad + `:3: users[0].userType: invalid userType "Admin"`; !strings.Contains(stdout.String(), want) {
+		t.Errorf("expected %q in the output, got %s", want, stdout.String())
+	}
+	if code := runValidate([]string{"--config", filepath.Join(dir, "missing.yaml")}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for a missing file, got %d", code)
+	}
+
+	// The published schema is generated from the config types
+	published, err := os.ReadFile("config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(published, configSchemaJSON()) {
+		t.Errorf("config.schema.json is out of date; regenerate it with: go run . validate --schema > config.schema.json")
+	}
+}
+
+func TestDisabledServiceAccount(t *testing.T) {
+	config := `serviceAccounts:
+  - id: sp-enabled
+    applicationId: app-enabled
+    secret: s1
+  - id: sp-disabled
+    applicationId: app-disabled
+    secret: s2
+    accountEnabled: false
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := &Store{configPath: path}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	for appID, want := range map[string]int{"app-enabled": http.StatusOK, "app-disabled": http.StatusUnauthorized} {
+		secret := map[string]string{"app-enabled": "s1", "app-disabled": "s2"}[appID]
+		form := url.Values{"grant_type": {"client_credentials"}, "client_id": {appID}, "client_secret": {secret}, "scope": {"https://management.azure.com/.default"}}
+		req := httptest.NewRequest("POST", "/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d %s", appID, want, w.Code, w.Body.String())
+		}
+	}
+	if sa := store.serviceAccounts[0]; !sa.AccountEnabled || !sa.ServicePrincipal {
+		t.Errorf("expected accounts to default to enabled service principals, got %+v", sa)
+	}
+}
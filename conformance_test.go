@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourcloudtools/mockzure/internal/routes"
+)
+
+// conformanceSpec declares ResourceGroups_Get with a location of the given JSON type, so the
+// resource groups Mockzure returns either match it or drift from it
+func conformanceSpec(t *testing.T, locationType string) string {
+	t.Helper()
+	dir := t.TempDir()
+	spec := `{
+  "swagger": "2.0",
+  "info": {"title": "ResourceManagementClient", "version": "2021-04-01"},
+  "paths": {
+    "/subscriptions/{subscriptionId}/resourcegroups/{resourceGroupName}": {
+      "get": {
+        "operationId": "ResourceGroups_Get",
+        "parameters": [
+          {"name": "subscriptionId", "in": "path", "required": true, "type": "string"},
+          {"name": "resourceGroupName", "in": "path", "required": true, "type": "string"},
+          {"name": "api-version", "in": "query", "required": true, "type": "string"}
+        ],
+        "responses": {
+          "200": {"description": "OK", "schema": {"$ref": "#/definitions/ResourceGroup"}},
+          "default": {"description": "Error", "schema": {"$ref": "#/definitions/CloudError"}}
+        }
+      }
+    }
+  },
+  "definitions": {
+    "ResourceGroup": {
+      "required": ["location"],
+      "properties": {
+        "id": {"readOnly": true, "type": "string"},
+        "name": {"readOnly": true, "type": "string"},
+        "location": {"type": "` + locationType + `"}
+      }
+    },
+    "CloudError": {
+      "required": ["error"],
+      "properties": {"error": {"type": "object", "required": ["code", "message"], "properties": {"code": {"type": "string"}, "message": {"type": "string"}}}}
+    }
+  }
+}`
+	if err := os.MkdirAll(filepath.Join(dir, "arm"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "arm", "arm-resources.json"), []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestResponseConformance(t *testing.T) {
+	serve := func(t *testing.T, mode routes.ConformanceMode, specsDir, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		store := &Store{configPath: "config.yaml.example"}
+		store.init()
+		store.conformance = mode
+		mux := newMux(store, specsDir)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	rgPath := testSubscriptionPath + "/resourcegroups/rg-dev?api-version=2021-04-01"
+
+	t.Run("conforming responses pass", func(t *testing.T) {
+		w := serve(t, routes.ConformanceStrict, conformanceSpec(t, "string"), rgPath)
+		if w.Code != http.StatusOK || w.Header().Get(routes.ConformanceHeader) != "" {
+			t.Fatalf("expected 200 without a diagnostic, got %d %q %s", w.Code, w.Header().Get(routes.ConformanceHeader), w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), `"rg-dev"`) {
+			t.Errorf("expected the resource group in the body, got %s", w.Body.String())
+		}
+
+		// Errors are checked against the default response
+		w = serve(t, routes.ConformanceStrict, conformanceSpec(t, "string"), testSubscriptionPath+"/resourcegroups/rg-missing?api-version=2021-04-01")
+		if w.Code != http.StatusNotFound || w.Header().Get(routes.ConformanceHeader) != "" {
+			t.Errorf("expected a conforming 404, got %d %q %s", w.Code, w.Header().Get(routes.ConformanceHeader), w.Body.String())
+		}
+	})
+
+	t.Run("strict mode fails drift", func(t *testing.T) {
+		w := serve(t, routes.ConformanceStrict, conformanceSpec(t, "integer"), rgPath)
+		if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "ResponseConformanceFailed") {
+			t.Fatalf("expected ResponseConformanceFailed, got %d %s", w.Code, w.Body.String())
+		}
+		diagnostic := w.Header().Get(routes.ConformanceHeader)
+		if !strings.Contains(diagnostic, "ResourceGroups_Get 200") || !strings.Contains(diagnostic, "location") {
+			t.Errorf("expected a diagnostic naming the operation and property, got %q", diagnostic)
+		}
+	})
+
+	t.Run("log mode and off send drift unchanged", func(t *testing.T) {
+		for _, mode := range []routes.ConformanceMode{routes.ConformanceLog, routes.ConformanceOff} {
+			w := serve(t, mode, conformanceSpec(t, "integer"), rgPath)
+			if w.Code != http.StatusOK || w.Header().Get(routes.ConformanceHeader) != "" {
+				t.Errorf("mode %d: expected the response unchanged, got %d %q", mode, w.Code, w.Header().Get(routes.ConformanceHeader))
+			}
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Deployment is an ARM template deployment, kept with its template and operations
+type Deployment struct {
+	ID         string                 `json:"id" yaml:"id"`
+	Name       string                 `json:"name" yaml:"name"`
+	Location   string                 `json:"location,omitempty" yaml:"location,omitempty"`
+	Tags       map[string]string      `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Properties map[string]interface{} `json:"properties" yaml:"properties"`
+	Template   map[string]interface{} `json:"template,omitempty" yaml:"template,omitempty"`
+	Operations []interface{}          `json:"operations,omitempty" yaml:"operations,omitempty"`
+}
+
+// deploymentPattern matches the deployment routes at resource group and subscription scope
+var deploymentPattern = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)(?:/resourcegroups/([^/]+))?/providers/Microsoft\.Resources/deployments(?:/([^/]+)(?:/(validate|whatIf|exportTemplate|operations)(?:/([^/]+))?)?)?/?$`)
+
+// GetDeployments returns deployments as interface slice for mappers.
+// Each entry is a copy, so mappers may modify it freely.
+func (s *Store) GetDeployments() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]interface{}, 0, len(s.deployments))
+	for _, d := range s.deployments {
+		var m map[string]interface{}
+		data, err := json.Marshal(d)
+		if err == nil {
+			err = json.Unmarshal(data, &m)
+		}
+		if err != nil {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// PutDeployment creates or replaces a deployment, keyed by its ID
+func (s *Store) PutDeployment(deployment map[string]interface{}) error {
+	data, err := json.Marshal(deployment)
+	if err != nil {
+		return fmt.Errorf("encode deployment: %w", err)
+	}
+	var d Deployment
+	if err := json.Unmarshal(data, &d); err != nil {
+		return fmt.Errorf("decode deployment: %w", err)
+	}
+	if d.ID == "" {
+		return fmt.Errorf("deployment id is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	for i, existing := range s.deployments {
+		if strings.EqualFold(existing.ID, d.ID) {
+			s.deployments[i] = &d
+			return nil
+		}
+	}
+	s.deployments = append(s.deployments, &d)
+	return nil
+}
+
+// DeleteDeployment deletes a deployment record. It reports whether the deployment existed.
+func (s *Store) DeleteDeployment(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	for i, d := range s.deployments {
+		if strings.EqualFold(d.ID, id) {
+			s.deployments = append(s.deployments[:i], s.deployments[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// deploymentRoute matches the Microsoft.Resources/deployments routes, which the bundled
+// resources spec does not describe. It returns nil when the request does not match.
+func deploymentRoute(r *http.Request) *armRoute {
+	matches := deploymentPattern.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		return nil
+	}
+	subscriptionID, resourceGroup, name, action, operationID := matches[1], matches[2], matches[3], strings.ToLower(matches[4]), matches[5]
+
+	var op string
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		op = "Deployments_List"
+		if resourceGroup != "" {
+			op = "Deployments_ListByResourceGroup"
+		}
+	case name == "":
+	case action == "" && r.Method == http.MethodGet:
+		op = "Deployments_Get"
+	case action == "" && r.Method == http.MethodPut:
+		op = "Deployments_CreateOrUpdate"
+	case action == "" && r.Method == http.MethodDelete:
+		op = "Deployments_Delete"
+	case action == "" && r.Method == http.MethodHead:
+		op = "Deployments_CheckExistence"
+	case action == "validate" && r.Method == http.MethodPost:
+		op = "Deployments_Validate"
+	case action == "whatif" && r.Method == http.MethodPost:
+		op = "Deployments_WhatIf"
+	case action == "exporttemplate" && r.Method == http.MethodPost:
+		op = "Deployments_ExportTemplate"
+	case action == "operations" && r.Method == http.MethodGet && operationID == "":
+		op = "DeploymentOperations_List"
+	case action == "operations" && r.Method == http.MethodGet:
+		op = "DeploymentOperations_Get"
+	}
+	if op == "" {
+		return nil
+	}
+
+	pattern := "/subscriptions/{subscriptionId}"
+	params := map[string]string{"subscriptionId": subscriptionID}
+	if resourceGroup != "" {
+		pattern += "/resourcegroups/{resourceGroupName}"
+		params["resourceGroupName"] = resourceGroup
+	} else {
+		op += "AtSubscriptionScope"
+	}
+	pattern += "/providers/Microsoft.Resources/deployments"
+	if name != "" {
+		pattern += "/{deploymentName}"
+		params["deploymentName"] = name
+	}
+	if action != "" {
+		pattern += "/" + matches[4]
+	}
+	if operationID != "" {
+		pattern += "/{operationId}"
+		params["operationId"] = operationID
+	}
+	return &armRoute{operationID: op, pattern: pattern, params: params}
+}
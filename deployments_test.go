@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// networkTemplate deploys a storage account and a VNet with a nested subnet, then a VM
+const networkTemplate = `{
+  "$schema": "https://schema.management.azure.com/schemas/2019-04-01/deploymentTemplate.json#",
+  "contentVersion": "1.0.0.0",
+  "parameters": {
+    "prefix": {"type": "string", "allowedValues": ["app", "web"]},
+    "location": {"type": "string", "defaultValue": "[resourceGroup().location]"}
+  },
+  "variables": {
+    "vnetName": "[concat(parameters('prefix'), '-vnet')]",
+    "storageName": "[concat('st', uniqueString(resourceGroup().id))]"
+  },
+  "resources": [
+    {
+      "type": "Microsoft.Compute/virtualMachines",
+      "apiVersion": "2023-03-01",
+      "name": "[concat(parameters('prefix'), '-vm')]",
+      "location": "[parameters('location')]",
+      "dependsOn": ["[resourceId('Microsoft.Network/virtualNetworks/subnets', variables('vnetName'), 'default')]"],
+      "properties": {"hardwareProfile": {"vmSize": "Standard_B2s"}, "storageProfile": {"osDisk": {"osType": "Linux"}}}
+    },
+    {
+      "type": "Microsoft.Network/virtualNetworks",
+      "apiVersion": "2023-04-01",
+      "name": "[variables('vnetName')]",
+      "location": "[parameters('location')]",
+      "properties": {"addressSpace": {"addressPrefixes": ["10.1.0.0/16"]}},
+      "resources": [
+        {"type": "subnets", "apiVersion": "2023-04-01", "name": "default", "properties": {"addressPrefix": "10.1.0.0/24"}}
+      ]
+    },
+    {
+      "type": "Microsoft.Storage/storageAccounts",
+      "apiVersion": "2023-01-01",
+      "name": "[variables('storageName')]",
+      "location": "[parameters('location')]",
+      "kind": "StorageV2",
+      "sku": {"name": "Standard_LRS"}
+    }
+  ],
+  "outputs": {
+    "vnetId": {"type": "string", "value": "[resourceId('Microsoft.Network/virtualNetworks', variables('vnetName'))]"},
+    "storageName": {"type": "string", "value": "[variables('storageName')]"}
+  }
+}`
+
+// deploymentBody wraps a template and parameter values in a deployment request body
+func deploymentBody(t *testing.T, template string, parameters map[string]interface{}, mode string) string {
+	t.Helper()
+	values := map[string]interface{}{}
+	for k, v := range parameters {
+		values[k] = map[string]interface{}{"value": v}
+	}
+	body := map[string]interface{}{
+		"location": "eastus",
+		"properties": map[string]interface{}{
+			"template":   json.RawMessage(template),
+			"parameters": values,
+			"mode":       mode,
+		},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to encode deployment: %v", err)
+	}
+	return string(data)
+}
+
+func TestTemplateDeployment(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	deploymentPath := testSubscriptionPath + "/resourcegroups/rg-dev/providers/Microsoft.Resources/deployments/network"
+	body := deploymentBody(t, networkTemplate, map[string]interface{}{"prefix": "app"}, "Incremental")
+
+	code, result := armRequest(t, mux, "POST", deploymentPath+"/validate", body)
+	if code != http.StatusOK || len(result["properties"].(map[string]interface{})["validatedResources"].([]interface{})) != 4 {
+		t.Fatalf("expected validation to succeed with 4 resources, got %d %v", code, result)
+	}
+
+	code, result = armRequest(t, mux, "PUT", deploymentPath, body)
+	if code != http.StatusCreated {
+		t.Fatalf("expected 201 creating the deployment, got %d %v", code, result)
+	}
+	properties := result["properties"].(map[string]interface{})
+	if properties["provisioningState"] != "Succeeded" {
+		t.Fatalf("expected the deployment to succeed, got %v", properties)
+	}
+	outputs := properties["outputs"].(map[string]interface{})
+	vnetID := testSubscriptionPath + "/resourceGroups/rg-dev/providers/Microsoft.Network/virtualNetworks/app-vnet"
+	if outputs["vnetId"].(map[string]interface{})["value"] != vnetID {
+		t.Errorf("expected the vnetId output to be evaluated, got %v", outputs)
+	}
+	storageName := outputs["storageName"].(map[string]interface{})["value"].(string)
+	if !strings.HasPrefix(storageName, "st") || len(storageName) != 15 {
+		t.Errorf("expected a uniqueString storage name, got %q", storageName)
+	}
+
+	// The resources exist, with expressions evaluated
+	code, vnet := armRequest(t, mux, "GET", vnetID, "")
+	if code != http.StatusOK || vnet["location"] != "East US" {
+		t.Errorf("expected the vnet in the resource group's location, got %d %v", code, vnet)
+	}
+	if code, _ := armRequest(t, mux, "GET", vnetID+"/subnets/default", ""); code != http.StatusOK {
+		t.Errorf("expected the nested subnet to be deployed, got %d", code)
+	}
+	code, vm := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines/app-vm", "")
+	if code != http.StatusOK || vm["properties"].(map[string]interface{})["hardwareProfile"].(map[string]interface{})["vmSize"] != "Standard_B2s" {
+		t.Errorf("expected the VM to be created, got %d %v", code, vm)
+	}
+
+	// Operations follow dependsOn: the subnet comes after its vnet and before the VM
+	code, result = armRequest(t, mux, "GET", deploymentPath+"/operations", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected deployment operations, got %d %v", code, result)
+	}
+	order := []string{}
+	for _, op := range result["value"].([]interface{}) {
+		target := op.(map[string]interface{})["properties"].(map[string]interface{})["targetResource"].(map[string]interface{})
+		order = append(order, target["resourceType"].(string))
+	}
+	if strings.Join(order, ",") != "Microsoft.Network/virtualNetworks,Microsoft.Network/virtualNetworks/subnets,Microsoft.Compute/virtualMachines,Microsoft.Storage/storageAccounts" {
+		t.Errorf("unexpected deployment order: %v", order)
+	}
+
+	// Redeploying is idempotent and updates the deployment record
+	if code, result := armRequest(t, mux, "PUT", deploymentPath, body); code != http.StatusOK {
+		t.Errorf("expected 200 redeploying, got %d %v", code, result)
+	}
+	code, result = armRequest(t, mux, "GET", testSubscriptionPath+"/resourcegroups/rg-dev/providers/Microsoft.Resources/deployments", "")
+	if code != http.StatusOK || len(result["value"].([]interface{})) != 1 {
+		t.Errorf("expected one deployment in the history, got %d %v", code, result)
+	}
+}
+
+func TestTemplateDeploymentValidation(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+	deploymentPath := testSubscriptionPath + "/resourcegroups/rg-dev/providers/Microsoft.Resources/deployments/invalid"
+
+	tests := []struct {
+		name       string
+		template   string
+		parameters map[string]interface{}
+	}{
+		{"missing parameter", networkTemplate, nil},
+		{"disallowed value", networkTemplate, map[string]interface{}{"prefix": "db"}},
+		{"undeclared parameter", networkTemplate, map[string]interface{}{"prefix": "app", "extra": "x"}},
+		{"unknown dependency", `{"resources":[{"type":"Microsoft.KeyVault/vaults","name":"kv","dependsOn":["missing"]}]}`, nil},
+		{"circular dependency", `{"resources":[
+			{"type":"Microsoft.KeyVault/vaults","name":"a","dependsOn":["b"]},
+			{"type":"Microsoft.KeyVault/vaults","name":"b","dependsOn":["a"]}]}`, nil},
+		{"unsupported function", `{"resources":[{"type":"Microsoft.KeyVault/vaults","name":"[reference('x')]"}]}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, result := armRequest(t, mux, "PUT", deploymentPath, deploymentBody(t, tt.template, tt.parameters, ""))
+			if code != http.StatusBadRequest || armErrorCode(result) != "InvalidTemplate" {
+				t.Errorf("expected InvalidTemplate, got %d %v", code, result)
+			}
+		})
+	}
+	if code, _ := armRequest(t, mux, "GET", deploymentPath, ""); code != http.StatusNotFound {
+		t.Errorf("expected invalid deployments not to be recorded, got %d", code)
+	}
+}
+
+func TestTemplateDeploymentCompleteModeAndWhatIf(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	template := `{"resources":[{"type":"Microsoft.Compute/virtualMachines","name":"vm-api-01","location":"East US",
+		"properties":{"hardwareProfile":{"vmSize":"Standard_B2s"},"storageProfile":{"osDisk":{"osType":"linux"}}}}]}`
+	deploymentPath := testSubscriptionPath + "/resourcegroups/rg-dev/providers/Microsoft.Resources/deployments/complete"
+	body := deploymentBody(t, template, nil, "Complete")
+
+	req := httptest.NewRequest("POST", deploymentPath+"/whatIf?api-version=2021-04-01", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	code, result := pollOperation(t, mux, w)
+	if code != http.StatusOK {
+		t.Fatalf("expected the what-if result, got %d %v", code, result)
+	}
+	changeTypes := map[string]string{}
+	for _, c := range result["properties"].(map[string]interface{})["changes"].([]interface{}) {
+		change := c.(map[string]interface{})
+		id := change["resourceId"].(string)
+		changeTypes[id[strings.LastIndex(id, "/")+1:]] = change["changeType"].(string)
+	}
+	if changeTypes["vm-api-01"] != "NoChange" || changeTypes["vm-web-01"] != "Delete" || changeTypes["vm-web-01-nic"] != "Delete" {
+		t.Errorf("unexpected what-if changes: %v", changeTypes)
+	}
+	if code, _ := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines/vm-web-01", ""); code != http.StatusOK {
+		t.Fatalf("expected what-if to leave resources in place, got %d", code)
+	}
+
+	if code, result := armRequest(t, mux, "PUT", deploymentPath, body); code != http.StatusCreated || result["properties"].(map[string]interface{})["provisioningState"] != "Succeeded" {
+		t.Fatalf("expected the complete deployment to succeed, got %d %v", code, result)
+	}
+	if code, _ := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines/vm-web-01", ""); code != http.StatusNotFound {
+		t.Errorf("expected complete mode to delete vm-web-01, got %d", code)
+	}
+	// The network resources vm-web-01 used are deleted after it, in dependency order
+	if code, _ := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-dev/providers/Microsoft.Network/networkSecurityGroups/nsg-web", ""); code != http.StatusNotFound {
+		t.Errorf("expected complete mode to delete nsg-web, got %d", code)
+	}
+	if code, _ := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-prod/providers/Microsoft.Compute/virtualMachines/vm-web-prod-01", ""); code != http.StatusOK {
+		t.Errorf("expected complete mode to leave other resource groups alone, got %d", code)
+	}
+}
+
+func TestSubscriptionDeploymentRedeploysExportedTemplate(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	// Create the DR resource group from a subscription-scope deployment
+	rgTemplate := `{"resources":[{"type":"Microsoft.Resources/resourceGroups","apiVersion":"2021-04-01","name":"rg-dr","location":"westus","tags":{"purpose":"dr"}}]}`
+	code, result := armRequest(t, mux, "PUT", testSubscriptionPath+"/providers/Microsoft.Resources/deployments/dr-group", deploymentBody(t, rgTemplate, nil, ""))
+	if code != http.StatusCreated || result["properties"].(map[string]interface{})["provisioningState"] != "Succeeded" {
+		t.Fatalf("expected the subscription deployment to succeed, got %d %v", code, result)
+	}
+	if code, _ := armRequest(t, mux, "HEAD", testSubscriptionPath+"/resourcegroups/rg-dr", ""); code != http.StatusNoContent {
+		t.Fatalf("expected rg-dr to exist, got %d", code)
+	}
+
+	// Export rg-dev and deploy the template into rg-dr
+	req := httptest.NewRequest("POST", testSubscriptionPath+"/resourcegroups/rg-dev/exportTemplate?api-version=2021-04-01",
+		strings.NewReader(`{"resources":["*"],"options":"IncludeParameterDefaultValue"}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	code, exported := pollOperation(t, mux, w)
+	if code != http.StatusOK {
+		t.Fatalf("expected the export to succeed, got %d %v", code, exported)
+	}
+	template, err := json.Marshal(exported["template"])
+	if err != nil {
+		t.Fatalf("failed to encode template: %v", err)
+	}
+	code, result = armRequest(t, mux, "PUT", testSubscriptionPath+"/resourcegroups/rg-dr/providers/Microsoft.Resources/deployments/restore", deploymentBody(t, string(template), nil, ""))
+	if code != http.StatusCreated || result["properties"].(map[string]interface{})["provisioningState"] != "Succeeded" {
+		t.Fatalf("expected the exported template to redeploy, got %d %v", code, result)
+	}
+	code, result = armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-dr/providers/Microsoft.Compute/virtualMachines", "")
+	if code != http.StatusOK || len(result["value"].([]interface{})) != 2 {
+		t.Errorf("expected both rg-dev VMs in rg-dr, got %d %v", code, result)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// diskPattern matches the disk and snapshot list routes and their access actions. Single
+// disks and snapshots are served by the generic resource routes.
+var diskPattern = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)(?:/resourcegroups/([^/]+))?/providers/Microsoft\.Compute/(disks|snapshots)(?:/([^/]+)/(beginGetAccess|endGetAccess))?/?$`)
+
+// diskRoute matches the disk and snapshot routes, returning nil when the request does not
+func diskRoute(r *http.Request) *armRoute {
+	matches := diskPattern.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		return nil
+	}
+	subscriptionID, resourceGroup, collection, name, action := matches[1], matches[2], matches[3], matches[4], strings.ToLower(matches[5])
+
+	prefix, nameParam := "Disks", "diskName"
+	if strings.EqualFold(collection, "snapshots") {
+		prefix, nameParam = "Snapshots", "snapshotName"
+	}
+	pattern := "/subscriptions/{subscriptionId}"
+	params := map[string]string{"subscriptionId": subscriptionID}
+	if resourceGroup != "" {
+		pattern += "/resourceGroups/{resourceGroupName}"
+		params["resourceGroupName"] = resourceGroup
+	}
+	pattern += "/providers/Microsoft.Compute/" + collection
+
+	var op string
+	switch {
+	case name == "" && r.Method == http.MethodGet && resourceGroup != "":
+		op = prefix + "_ListByResourceGroup"
+	case name == "" && r.Method == http.MethodGet:
+		op = prefix + "_List"
+	case name != "" && resourceGroup != "" && r.Method == http.MethodPost:
+		pattern += "/{" + nameParam + "}/" + matches[5]
+		params[nameParam] = name
+		op = prefix + "_GrantAccess"
+		if action == "endgetaccess" {
+			op = prefix + "_RevokeAccess"
+		}
+	}
+	if op == "" {
+		return nil
+	}
+	return &armRoute{operationID: op, pattern: pattern, params: params}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testComputePath = testSubscriptionPath + "/resourceGroups/rg-dev/providers/Microsoft.Compute"
+
+// diskState returns the disk state of a disk or snapshot
+func diskState(disk map[string]interface{}) interface{} {
+	properties, _ := disk["properties"].(map[string]interface{})
+	return properties["diskState"]
+}
+
+// armAction posts to an ARM action, such as beginGetAccess or start, and returns the result of its operation
+func armAction(t *testing.T, mux http.Handler, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest("POST", path+"?api-version=2023-04-02", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		return w.Code, nil
+	}
+	return pollOperation(t, mux, w)
+}
+
+func TestManagedDisksAndSnapshots(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+	osDiskID := testComputePath + "/disks/vm-web-01_OsDisk_1"
+
+	t.Run("vm disks", func(t *testing.T) {
+		code, disk := armRequest(t, mux, "GET", osDiskID, "")
+		if code != http.StatusOK || diskState(disk) != "Attached" || disk["managedBy"] != testComputePath+"/virtualMachines/vm-web-01" {
+			t.Fatalf("expected the VM's OS disk to be attached, got %d %v", code, disk)
+		}
+		if disk["properties"].(map[string]interface{})["diskSizeGB"] != float64(64) {
+			t.Errorf("unexpected OS disk size: %v", disk["properties"])
+		}
+		code, body := armRequest(t, mux, "DELETE", osDiskID, "")
+		if code != http.StatusConflict || armErrorCode(body) != "OperationNotAllowed" {
+			t.Errorf("expected deleting an attached disk to fail, got %d %v", code, body)
+		}
+		_, list := armRequest(t, mux, "GET", testComputePath+"/disks", "")
+		if value, _ := list["value"].([]interface{}); len(value) != 3 {
+			t.Errorf("expected the OS and data disks of the rg-dev VMs, got %v", list)
+		}
+	})
+
+	t.Run("snapshot and export", func(t *testing.T) {
+		snapshotPath := testComputePath + "/snapshots/web-os-snap"
+		code, snapshot := armRequest(t, mux, "PUT", snapshotPath, `{"location":"eastus","properties":{"creationData":{"createOption":"Copy","sourceResourceId":"`+osDiskID+`"}}}`)
+		if code != http.StatusCreated {
+			t.Fatalf("expected the snapshot to be created, got %d %v", code, snapshot)
+		}
+		properties := snapshot["properties"].(map[string]interface{})
+		if properties["diskSizeGB"] != float64(64) || properties["osType"] != "linux" || diskState(snapshot) != "Unattached" {
+			t.Errorf("expected the snapshot to copy the OS disk, got %v", properties)
+		}
+
+		code, access := armAction(t, mux, snapshotPath+"/beginGetAccess", `{"access":"Read","durationInSeconds":3600}`)
+		if sas, _ := access["accessSAS"].(string); code != http.StatusOK || !strings.HasPrefix(sas, "https://md-") || !strings.Contains(sas, "sig=") {
+			t.Fatalf("expected a SAS URL, got %d %v", code, access)
+		}
+		if _, snapshot = armRequest(t, mux, "GET", snapshotPath, ""); diskState(snapshot) != "ActiveSAS" {
+			t.Errorf("expected the snapshot to be exported, got %v", diskState(snapshot))
+		}
+		if code, body := armRequest(t, mux, "DELETE", snapshotPath, ""); code != http.StatusConflict {
+			t.Errorf("expected deleting an exported snapshot to fail, got %d %v", code, body)
+		}
+		if code, _ := armAction(t, mux, snapshotPath+"/endGetAccess", ""); code != http.StatusNoContent && code != http.StatusOK {
+			t.Fatalf("expected access to be revoked, got %d", code)
+		}
+		if _, snapshot = armRequest(t, mux, "GET", snapshotPath, ""); diskState(snapshot) != "Unattached" {
+			t.Errorf("expected the export to end, got %v", diskState(snapshot))
+		}
+		if code, _ := armRequest(t, mux, "DELETE", snapshotPath, ""); code != http.StatusOK {
+			t.Errorf("expected the snapshot to be deleted, got %d", code)
+		}
+
+		if code, _ := armAction(t, mux, osDiskID+"/beginGetAccess", `{"access":"Read","durationInSeconds":3600}`); code != http.StatusConflict {
+			t.Errorf("expected exporting the disk of a running VM to fail, got %d", code)
+		}
+	})
+
+	t.Run("attach and detach", func(t *testing.T) {
+		diskPath := testComputePath + "/disks/web-data-1"
+		code, disk := armRequest(t, mux, "PUT", diskPath, `{"location":"eastus","sku":{"name":"Premium_LRS"},"properties":{"creationData":{"createOption":"Empty"},"diskSizeGB":128}}`)
+		if code != http.StatusCreated || diskState(disk) != "Unattached" || disk["sku"].(map[string]interface{})["tier"] != "Premium" {
+			t.Fatalf("expected an unattached disk, got %d %v", code, disk)
+		}
+
+		vmPath := testComputePath + "/virtualMachines/vm-web-01"
+		_, vm := armRequest(t, mux, "GET", vmPath, "")
+		dataDisks := vm["properties"].(map[string]interface{})["storageProfile"].(map[string]interface{})["dataDisks"].([]interface{})
+		dataDisks = append(dataDisks, map[string]interface{}{"lun": 1, "createOption": "Attach", "managedDisk": map[string]interface{}{"id": diskPath}})
+		patch, err := json.Marshal(map[string]interface{}{"properties": map[string]interface{}{"storageProfile": map[string]interface{}{"dataDisks": dataDisks}}})
+		if err != nil {
+			t.Fatalf("failed to encode the VM update: %v", err)
+		}
+		code, vm = armRequest(t, mux, "PATCH", vmPath, string(patch))
+		if code != http.StatusOK {
+			t.Fatalf("expected the disk to be attached, got %d %v", code, vm)
+		}
+		if disks := vm["properties"].(map[string]interface{})["storageProfile"].(map[string]interface{})["dataDisks"].([]interface{}); len(disks) != 2 {
+			t.Errorf("expected two data disks, got %v", disks)
+		}
+		if _, disk = armRequest(t, mux, "GET", diskPath, ""); diskState(disk) != "Attached" || disk["managedBy"] != vmPath {
+			t.Errorf("expected the disk to be attached to the VM, got %v", disk)
+		}
+		if code, body := armRequest(t, mux, "DELETE", diskPath, ""); code != http.StatusConflict || armErrorCode(body) != "OperationNotAllowed" {
+			t.Errorf("expected deleting an attached disk to fail, got %d %v", code, body)
+		}
+		code, body := armRequest(t, mux, "PATCH", testComputePath+"/virtualMachines/vm-api-01", `{"properties":{"storageProfile":{"dataDisks":[{"lun":0,"createOption":"Attach","managedDisk":{"id":"`+diskPath+`"}}]}}}`)
+		if code != http.StatusConflict {
+			t.Errorf("expected attaching a disk to a second VM to fail, got %d %v", code, body)
+		}
+
+		// Detaching everything keeps the disks, including the one created with the VM
+		if code, body := armRequest(t, mux, "PATCH", vmPath, `{"properties":{"storageProfile":{"dataDisks":[]}}}`); code != http.StatusOK {
+			t.Fatalf("expected the disks to be detached, got %d %v", code, body)
+		}
+		for _, name := range []string{"web-data-1", "vm-web-01-data-0"} {
+			code, disk := armRequest(t, mux, "GET", testComputePath+"/disks/"+name, "")
+			if code != http.StatusOK || diskState(disk) != "Unattached" || disk["managedBy"] != nil {
+				t.Errorf("expected %s to be unattached, got %d %v", name, code, disk)
+			}
+		}
+		if code, _ := armRequest(t, mux, "DELETE", diskPath, ""); code != http.StatusOK {
+			t.Errorf("expected the detached disk to be deleted, got %d", code)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		tests := []struct {
+			name, path, body string
+			status           int
+			code             string
+		}{
+			{"missing size", "/disks/bad", `{"location":"eastus","properties":{"creationData":{"createOption":"Empty"}}}`, http.StatusBadRequest, "InvalidParameter"},
+			{"missing source", "/snapshots/bad", `{"location":"eastus","properties":{"creationData":{"createOption":"Copy","sourceResourceId":"` + testComputePath + `/disks/missing"}}}`, http.StatusNotFound, "NotFound"},
+			{"empty snapshot", "/snapshots/bad", `{"location":"eastus","properties":{"creationData":{"createOption":"Empty"},"diskSizeGB":8}}`, http.StatusBadRequest, "InvalidParameter"},
+			{"unknown sku", "/disks/bad", `{"location":"eastus","sku":{"name":"Fast_LRS"},"properties":{"creationData":{"createOption":"Empty"},"diskSizeGB":8}}`, http.StatusBadRequest, "InvalidParameter"},
+			{"shrink", "/disks/vm-web-01_OsDisk_1", `{"location":"eastus","properties":{"diskSizeGB":32}}`, http.StatusBadRequest, "InvalidParameter"},
+		}
+		for _, tt := range tests {
+			code, body := armRequest(t, mux, "PUT", testComputePath+tt.path, tt.body)
+			if code != tt.status || armErrorCode(body) != tt.code {
+				t.Errorf("%s: expected %d %s, got %d %v", tt.name, tt.status, tt.code, code, body)
+			}
+		}
+		code, body := armRequest(t, mux, "PATCH", testComputePath+"/virtualMachines/vm-api-01", `{"properties":{"storageProfile":{"dataDisks":[
+			{"lun":0,"createOption":"Empty","diskSizeGB":8},{"lun":0,"createOption":"Empty","diskSizeGB":8,"name":"other"}]}}}`)
+		if code != http.StatusBadRequest || armErrorCode(body) != "InvalidParameter" {
+			t.Errorf("expected duplicate LUNs to fail, got %d %v", code, body)
+		}
+	})
+}
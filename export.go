@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exportConfig writes the mock data as a YAML or JSON config file that loads back into the same
+// data. Secrets are left out unless includeSecrets is set, so service accounts loaded from an
+// export without them cannot sign in.
+func (s *Store) exportConfig(format string, includeSecrets bool) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return encodeConfig(s.configView(includeSecrets), format)
+}
+
+// configView returns the mock data in the config file schema. Data the config file cannot
+// express, such as scale set instances, deployments, run command invocations, app registrations
+// and vault keys and certificates, is left out. The caller must hold s.mu.
+func (s *Store) configView(includeSecrets bool) *FullConfig {
+	fc := &FullConfig{
+		Tenants:         s.tenants,
+		Subscriptions:   s.subscriptions,
+		ResourceGroups:  s.resourceGroups,
+		VMs:             s.vms,
+		Users:           s.users,
+		ServiceAccounts: []FullConfigServiceAcc{},
+		Resources:       s.resources,
+		VMSizes:         s.vmSizes,
+		Quotas:          s.quotas,
+		RunCommands:     s.runResponders,
+	}
+	secret := func(value string) string {
+		if includeSecrets {
+			return value
+		}
+		return ""
+	}
+
+	secrets := map[string]string{}
+	if s.config != nil {
+		for _, sec := range s.config.ServiceAccounts {
+			secrets[sec.ApplicationID] = sec.Secret
+		}
+	}
+	for _, sa := range s.serviceAccounts {
+		csa := FullConfigServiceAcc{
+			ID:               sa.ID,
+			ApplicationID:    sa.ApplicationID,
+			Secret:           secret(secrets[sa.ApplicationID]),
+			DisplayName:      sa.DisplayName,
+			Description:      sa.Description,
+			CreatedDateTime:  sa.CreatedDateTime,
+			Permissions:      sa.Permissions,
+			GraphPermissions: sa.GraphPermissions,
+			TenantID:         sa.TenantID,
+			Subscriptions:    sa.Subscriptions,
+			AzureRoles:       sa.AzureRoles,
+		}
+		// Both default to true, so only false is written out
+		disabled := false
+		if !sa.AccountEnabled {
+			csa.AccountEnabled = &disabled
+		}
+		if !sa.ServicePrincipal {
+			csa.ServicePrincipal = &disabled
+		}
+		fc.ServiceAccounts = append(fc.ServiceAccounts, csa)
+	}
+
+	for _, vault := range s.keyVaults {
+		exported := *vault
+		exported.objects = nil
+		exported.Secrets = []KeyVaultSecret{}
+		for key, obj := range vault.objects {
+			if !strings.HasPrefix(key, secretKind.collection+"/") || !obj.deleted.IsZero() || obj.managed || len(obj.versions) == 0 {
+				continue
+			}
+			latest := obj.versions[len(obj.versions)-1]
+			exported.Secrets = append(exported.Secrets, KeyVaultSecret{Name: obj.name, Value: secret(latest.value), ContentType: latest.contentType, Tags: latest.tags})
+		}
+		sort.Slice(exported.Secrets, func(i, j int) bool { return exported.Secrets[i].Name < exported.Secrets[j].Name })
+		fc.KeyVaults = append(fc.KeyVaults, &exported)
+	}
+
+	if s.keyVaultHost != defaultKeyVaultHost {
+		fc.KeyVaultHost = s.keyVaultHost
+	}
+	if s.keyVaultPath != defaultKeyVaultPath {
+		fc.KeyVaultPath = s.keyVaultPath
+	}
+	if s.blobHost != defaultBlobHost {
+		fc.BlobHost = s.blobHost
+	}
+	if s.blobPath != defaultBlobPath {
+		fc.BlobPath = s.blobPath
+	}
+	if blobs, ok := s.blobBackend.(fileBlobs); ok {
+		fc.BlobDataDir = blobs.root
+	}
+	switch state := s.stateBackend.(type) {
+	case fileState:
+		fc.StateDir = state.root
+	case boltState:
+		fc.StateDir, fc.StateBackend = state.root, "bolt"
+	}
+	fc.Faults = s.faults.configRules()
+	if s.throttling != nil {
+		fc.Throttling = s.throttling.config()
+	}
+	return fc
+}
+
+// encodeConfig writes a config in the YAML or JSON format of config files
+func encodeConfig(fc *FullConfig, format string) ([]byte, error) {
+	switch format {
+	case "yaml", "yml":
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(fc); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "json":
+		data, err := json.MarshalIndent(fc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q; use yaml or json", format)
+	}
+}
+
+// serveExport handles GET /mock/azure/admin/export?format=yaml|json&includeSecrets=true
+func serveExport(w http.ResponseWriter, r *http.Request, store *Store) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "yaml"
+	}
+	includeSecrets := r.URL.Query().Get("includeSecrets") == "true"
+	data, err := store.exportConfig(format, includeSecrets)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"message": "Export failed", "error": err.Error(), "status": "error"}); err != nil {
+			log.Printf("Failed to encode JSON response: %v", err)
+		}
+		return
+	}
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/yaml")
+	}
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Failed to write export: %v", err)
+	}
+}
+
+// runExport implements the export subcommand: it fetches the config export of a running
+// Mockzure and writes it to stdout or a file. It returns the process exit code.
+func runExport(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	serverURL := flags.String("url", "", "Base URL of the running Mockzure (default http://localhost:8090). Can also use MOCKZURE_URL env var")
+	format := flags.String("format", "yaml", "Output format: yaml or json")
+	includeSecrets := flags.Bool("include-secrets", false, "Include service account and vault secrets instead of leaving them out")
+	output := flags.String("output", "", "File to write the config to (default stdout)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	base := *serverURL
+	if base == "" {
+		base = os.Getenv("MOCKZURE_URL")
+	}
+	if base == "" {
+		base = "http://localhost:8090"
+	}
+	query := url.Values{"format": {*format}}
+	if *includeSecrets {
+		query.Set("includeSecrets", "true")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(strings.TrimRight(base, "/") + "/mock/azure/admin/export?" + query.Encode())
+	if err != nil {
+		fmt.Fprintf(stderr, "export: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintf(stderr, "export: %v\n", err)
+		return 1
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(stderr, "export: %s: %s\n", resp.Status, strings.TrimSpace(string(data)))
+		return 1
+	}
+
+	if *output == "" {
+		if _, err := stdout.Write(data); err != nil {
+			return 1
+		}
+		return 0
+	}
+	if err := writeFileAtomic(*output, data); err != nil {
+		fmt.Fprintf(stderr, "export: %v\n", err)
+		return 1
+	}
+	return 0
+}
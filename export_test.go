@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	config := `
+keyVaults:
+  - name: kv-export
+    secrets:
+      - name: db-password
+        value: hunter2
+`
+	data, err := os.ReadFile("config.yaml.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, append(data, config...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := &Store{configPath: configPath}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+	store.serviceAccounts[0].AccountEnabled = false
+	if code, body := armRequest(t, mux, "PUT", testSubscriptionPath+"/resourcegroups/rg-portal", `{"location":"westeurope","tags":{"made":"interactively"}}`); code != http.StatusCreated {
+		t.Fatalf("expected the resource group to be created, got %d %v", code, body)
+	}
+
+	export := func(t *testing.T, query string) (int, string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/mock/azure/admin/export"+query, nil))
+		return w.Code, w.Body.String()
+	}
+
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format+" round-trips", func(t *testing.T) {
+			code, body := export(t, "?format="+format)
+			if code != http.StatusOK {
+				t.Fatalf("expected the export, got %d %s", code, body)
+			}
+			if strings.Contains(body, "sandman-secret-key-development-only") || strings.Contains(body, "hunter2") || strings.Contains(body, "secret\"") || strings.Contains(body, "secret:") {
+				t.Errorf("expected secrets to be left out:\n%s", body)
+			}
+			if problems := validateConfig([]byte(body)); len(problems) != 0 {
+				t.Errorf("expected the export to validate, got %v", problems)
+			}
+
+			exported := filepath.Join(t.TempDir(), "exported."+format)
+			if err := os.WriteFile(exported, []byte(body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			loaded := &Store{configPath: exported}
+			loaded.init()
+			loadedMux := newMux(loaded, "mockzure-specs")
+			code, rg := armRequest(t, loadedMux, "GET", testSubscriptionPath+"/resourcegroups/rg-portal", "")
+			if tags, _ := rg["tags"].(map[string]interface{}); code != http.StatusOK || tags["made"] != "interactively" {
+				t.Errorf("expected the resource group made at runtime, got %d %v", code, rg)
+			}
+			if len(loaded.resourceGroups) != len(store.resourceGroups) || len(loaded.vms) != len(store.vms) || len(loaded.users) != len(store.users) {
+				t.Errorf("expected the same data after loading the export")
+			}
+			if sa := loaded.serviceAccounts[0]; sa.ApplicationID != "sandman-app-id-12345" || sa.AccountEnabled || !sa.ServicePrincipal {
+				t.Errorf("expected the disabled service account, got %+v", sa)
+			}
+
+			// Service accounts without a secret cannot get a token, even when enabled
+			loaded.serviceAccounts[0].AccountEnabled = true
+			for _, clientSecret := range []string{"", "REDACTED"} {
+				form := url.Values{"grant_type": {"client_credentials"}, "client_id": {"sandman-app-id-12345"}, "client_secret": {clientSecret}, "scope": {"https://management.azure.com/.default"}}
+				req := httptest.NewRequest("POST", "/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				loadedMux.ServeHTTP(w, req)
+				if w.Code != http.StatusUnauthorized {
+					t.Errorf("expected the secret %q to be refused, got %d %s", clientSecret, w.Code, w.Body.String())
+				}
+			}
+		})
+	}
+
+	t.Run("secrets only on request", func(t *testing.T) {
+		_, body := export(t, "?includeSecrets=true")
+		if !strings.Contains(body, "sandman-secret-key-development-only") || !strings.Contains(body, "hunter2") {
+			t.Errorf("expected the secrets:\n%s", body)
+		}
+		if code, _ := export(t, "?format=xml"); code != http.StatusBadRequest {
+			t.Errorf("expected 400 for an unknown format, got %d", code)
+		}
+	})
+
+	t.Run("export command", func(t *testing.T) {
+		server := httptest.NewServer(mux)
+		defer server.Close()
+		output := filepath.Join(t.TempDir(), "fixture.json")
+		var stdout, stderr bytes.Buffer
+		if code := runExport([]string{"--url", server.URL, "--format", "json", "--include-secrets", "--output", output}, &stdout, &stderr); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+		}
+		written, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(written, []byte("{")) || !bytes.Contains(written, []byte("sandman-secret-key-development-only")) {
+			t.Errorf("expected the JSON export with secrets, got %s", written)
+		}
+		if code := runExport([]string{"--url", server.URL, "--format", "xml"}, &stdout, &stderr); code != 1 || !strings.Contains(stderr.String(), "unsupported format") {
+			t.Errorf("expected the server's error, got %d %s", code, stderr.String())
+		}
+	})
+}
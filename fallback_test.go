@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yourcloudtools/mockzure/internal/routes"
+)
+
+func TestSpecFallbackResponses(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	// Synthesized responses must pass the same check as mapped ones
+	store.conformance = routes.ConformanceStrict
+	mux := newMux(store, "mockzure-specs")
+
+	code, body := armRequest(t, mux, "GET", testSubscriptionPath+"/providers", "")
+	if code != http.StatusOK {
+		t.Fatalf("Providers_List: expected 200, got %d %v", code, body)
+	}
+	value, _ := body["value"].([]interface{})
+	if len(value) != 1 {
+		t.Fatalf("Providers_List: expected one synthesized provider, got %v", body)
+	}
+	provider, _ := value[0].(map[string]interface{})
+	if _, ok := provider["namespace"].(string); !ok {
+		t.Errorf("Providers_List: expected the provider to be filled from the schema, got %v", provider)
+	}
+	if _, ok := provider["resourceTypes"].([]interface{}); !ok {
+		t.Errorf("Providers_List: expected nested arrays to be filled, got %v", provider)
+	}
+	if _, ok := body["nextLink"]; ok {
+		t.Errorf("Providers_List: synthesized pages must not link to a next page, got %v", body)
+	}
+
+	// Responses are deterministic
+	_, again := armRequest(t, mux, "GET", testSubscriptionPath+"/providers", "")
+	first, _ := json.Marshal(body)
+	second, _ := json.Marshal(again)
+	if string(first) != string(second) {
+		t.Errorf("expected identical synthesized responses, got %s and %s", first, second)
+	}
+
+	code, body = armRequest(t, mux, "GET", testSubscriptionPath+"/providers/Microsoft.Compute/resourceTypes", "")
+	if code != http.StatusOK || body["value"] == nil {
+		t.Errorf("ProviderResourceTypes_List: expected a synthesized list, got %d %v", code, body)
+	}
+
+	// Operations whose success response has no body answer with its status alone
+	code, body = armRequest(t, mux, "DELETE", testSubscriptionPath+"/tagNames/env/tagValues/dev", "")
+	if code != http.StatusOK || body != nil {
+		t.Errorf("Tags_DeleteValue: expected 200 without a body, got %d %v", code, body)
+	}
+
+	// Mapped operations are unaffected
+	code, body = armRequest(t, mux, "GET", testSubscriptionPath+"/resourcegroups/rg-dev", "")
+	if code != http.StatusOK || body["name"] != "rg-dev" {
+		t.Errorf("ResourceGroups_Get: expected the configured resource group, got %d %v", code, body)
+	}
+}
+
+func TestSpecFallbackInlineExample(t *testing.T) {
+	dir := t.TempDir()
+	spec := `{
+  "swagger": "2.0",
+  "info": {"title": "FeatureClient", "version": "2021-07-01"},
+  "paths": {
+    "/subscriptions/{subscriptionId}/providers/Microsoft.Features/features": {
+      "get": {
+        "operationId": "Features_ListAll",
+        "parameters": [
+          {"name": "subscriptionId", "in": "path", "required": true, "type": "string"},
+          {"name": "api-version", "in": "query", "required": true, "type": "string"}
+        ],
+        "responses": {"200": {"description": "OK", "schema": {"type": "object", "properties": {"value": {"type": "array", "items": {"type": "object"}}}}}},
+        "x-ms-examples": {
+          "List features": {
+            "parameters": {"subscriptionId": "subid", "api-version": "2021-07-01"},
+            "responses": {"200": {"headers": {"x-ms-example": "inline"}, "body": {"value": [{"name": "Microsoft.Compute/AHUB"}]}}}
+          }
+        }
+      }
+    }
+  }
+}`
+	if err := os.MkdirAll(filepath.Join(dir, "arm"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "arm", "arm-features.json"), []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, dir)
+
+	req := httptest.NewRequest("GET", testSubscriptionPath+"/providers/Microsoft.Features/features?api-version=2021-07-01", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("x-ms-example") != "inline" {
+		t.Fatalf("expected the example's status and headers, got %d %v", w.Code, w.Header())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	value, _ := body["value"].([]interface{})
+	if len(value) != 1 || value[0].(map[string]interface{})["name"] != "Microsoft.Compute/AHUB" {
+		t.Errorf("expected the example body, got %v", body)
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+)
+
+// FaultRule injects a fault into the requests it matches. Empty match fields match any request;
+// the first matching rule that fires wins.
+type FaultRule struct {
+	ID          string  `json:"id,omitempty" yaml:"id,omitempty"`                   // assigned when empty
+	Method      string  `json:"method,omitempty" yaml:"method,omitempty"`           // HTTP method
+	Path        string  `json:"path,omitempty" yaml:"path,omitempty"`               // regular expression matched against the request path
+	OperationID string  `json:"operationId,omitempty" yaml:"operationId,omitempty"` // operationId of the spec-driven route the request matches
+	ClientID    string  `json:"clientId,omitempty" yaml:"clientId,omitempty"`       // application ID of the caller's token or credentials
+	Probability float64 `json:"probability,omitempty" yaml:"probability,omitempty"` // chance of firing on a matching request; 1 when unset
+	Count       int     `json:"count,omitempty" yaml:"count,omitempty"`             // fires at most this many times; unlimited when unset
+
+	Status             int    `json:"status,omitempty" yaml:"status,omitempty"`                         // answers with this status and an Azure error body
+	ErrorCode          string `json:"errorCode,omitempty" yaml:"errorCode,omitempty"`                   // defaults to the status, such as TooManyRequests
+	Message            string `json:"message,omitempty" yaml:"message,omitempty"`                       // error message
+	RetryAfter         int    `json:"retryAfter,omitempty" yaml:"retryAfter,omitempty"`                 // Retry-After seconds; 1 for 429 when unset
+	FailureCause       string `json:"failureCause,omitempty" yaml:"failureCause,omitempty"`             // x-ms-failure-cause header: service or gateway
+	LatencyMs          int    `json:"latencyMs,omitempty" yaml:"latencyMs,omitempty"`                   // delays the request
+	Drop               bool   `json:"drop,omitempty" yaml:"drop,omitempty"`                             // closes the connection without a response
+	FailOperation      bool   `json:"failOperation,omitempty" yaml:"failOperation,omitempty"`           // the long-running operation the request starts fails
+	PollsBeforeFailure int    `json:"pollsBeforeFailure,omitempty" yaml:"pollsBeforeFailure,omitempty"` // polls reported in progress before it fails; 1 when unset
+}
+
+// validate checks a rule and compiles its path pattern
+func (rule *FaultRule) validate() (*regexp.Regexp, error) {
+	path, err := regexp.Compile(rule.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path pattern: %w", err)
+	}
+	switch {
+	case rule.Status == 0 && rule.LatencyMs == 0 && !rule.Drop && !rule.FailOperation:
+		return nil, fmt.Errorf("rule has no fault: set status, latencyMs, drop or failOperation")
+	case rule.Status != 0 && (rule.Status < 400 || rule.Status > 599):
+		return nil, fmt.Errorf("status %d is not an error status", rule.Status)
+	case rule.Drop && (rule.Status != 0 || rule.FailOperation):
+		return nil, fmt.Errorf("drop cannot be combined with status or failOperation")
+	case rule.Probability < 0 || rule.Probability > 1:
+		return nil, fmt.Errorf("probability %v is not between 0 and 1", rule.Probability)
+	case rule.Count < 0 || rule.LatencyMs < 0 || rule.RetryAfter < 0 || rule.PollsBeforeFailure < 0:
+		return nil, fmt.Errorf("count, latencyMs, retryAfter and pollsBeforeFailure cannot be negative")
+	case rule.FailureCause != "" && rule.FailureCause != "service" && rule.FailureCause != "gateway":
+		return nil, fmt.Errorf("failureCause must be service or gateway")
+	}
+	return path, nil
+}
+
+// faultInjector holds the fault rules and the long-running operations they fail
+type faultInjector struct {
+	mu      sync.Mutex
+	rules   []*activeFaultRule
+	nextID  int
+	failing map[string]*failingOperation // by operation ID
+}
+
+type activeFaultRule struct {
+	rule  *FaultRule
+	path  *regexp.Regexp
+	fired int
+}
+
+type failingOperation struct {
+	rule  *FaultRule
+	polls int
+}
+
+// faultRuleView is a rule as the admin API lists it
+type faultRuleView struct {
+	*FaultRule
+	Fired int `json:"fired"`
+}
+
+func newFaultInjector(rules []*FaultRule) (*faultInjector, error) {
+	f := &faultInjector{failing: map[string]*failingOperation{}}
+	for i, rule := range rules {
+		if _, err := f.add(rule); err != nil {
+			return nil, fmt.Errorf("faults[%d]: %w", i, err)
+		}
+	}
+	return f, nil
+}
+
+// add validates a rule and appends it, assigning an ID when it has none
+func (f *faultInjector) add(rule *FaultRule) (*FaultRule, error) {
+	path, err := rule.validate()
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rule.ID == "" {
+		// Skip IDs that rules were given explicitly
+		for rule.ID == "" || f.ruleExists(rule.ID) {
+			f.nextID++
+			rule.ID = fmt.Sprintf("fault-%d", f.nextID)
+		}
+	} else if f.ruleExists(rule.ID) {
+		return nil, fmt.Errorf("a rule with ID %q already exists", rule.ID)
+	}
+	f.rules = append(f.rules, &activeFaultRule{rule: rule, path: path})
+	return rule, nil
+}
+
+// ruleExists reports whether a rule has an ID. The caller must hold f.mu.
+func (f *faultInjector) ruleExists(id string) bool {
+	for _, active := range f.rules {
+		if active.rule.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// remove deletes a rule by ID, reporting whether it existed
+func (f *faultInjector) remove(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, active := range f.rules {
+		if active.rule.ID == id {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// clear deletes every rule and forgets the operations they were failing
+func (f *faultInjector) clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = nil
+	f.failing = map[string]*failingOperation{}
+}
+
+func (f *faultInjector) list() []faultRuleView {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	views := []faultRuleView{}
+	for _, active := range f.rules {
+		views = append(views, faultRuleView{FaultRule: active.rule, Fired: active.fired})
+	}
+	return views
+}
+
+// configRules returns the rules in the config file schema
+func (f *faultInjector) configRules() []*FaultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rules []*FaultRule
+	for _, active := range f.rules {
+		rules = append(rules, active.rule)
+	}
+	return rules
+}
+
+// match returns the rule that fires for a request, or nil. operationID and clientID are only
+// looked up for rules that need them.
+func (f *faultInjector) match(r *http.Request, operationID, clientID func() string) *FaultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, active := range f.rules {
+		rule := active.rule
+		// Each case but the last skips the rule
+		switch {
+		case rule.Count > 0 && active.fired >= rule.Count:
+		case rule.Method != "" && !strings.EqualFold(rule.Method, r.Method):
+		case !active.path.MatchString(r.URL.Path):
+		case rule.OperationID != "" && !strings.EqualFold(rule.OperationID, operationID()):
+		case rule.ClientID != "" && !strings.EqualFold(rule.ClientID, clientID()):
+		case rule.Probability > 0 && rand.Float64() >= rule.Probability:
+		default:
+			active.fired++
+			return rule
+		}
+	}
+	return nil
+}
+
+// failOperation makes a long-running operation fail when it is polled
+func (f *faultInjector) failOperation(id string, rule *FaultRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing[id] = &failingOperation{rule: rule}
+}
+
+// failingPoll returns the rule failing a polled operation, and whether the poll should still
+// report it in progress
+func (f *faultInjector) failingPoll(id string) (*FaultRule, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	op := f.failing[id]
+	if op == nil {
+		return nil, false
+	}
+	polls := op.rule.PollsBeforeFailure
+	if polls == 0 {
+		polls = 1
+	}
+	if op.polls < polls {
+		op.polls++
+		return op.rule, true
+	}
+	return op.rule, false
+}
+
+// operationResultPath matches the polling URL of long-running ARM operations
+var operationResultPath = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/operationresults/([^/]+)/?$`)
+
+// faultHandler applies the store's fault rules in front of every handler, hardcoded and spec-driven
+// alike. The admin API is exempt, so rules can always be removed again.
+func faultHandler(store *Store, operations *reloader, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		faults := store.faultInjector()
+		if faults == nil || strings.HasPrefix(r.URL.Path, "/mock/azure/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if m := operationResultPath.FindStringSubmatch(r.URL.Path); m != nil && r.Method == http.MethodGet {
+			if rule, inProgress := faults.failingPoll(m[1]); rule != nil {
+				if inProgress {
+					w.Header().Set("Location", baseURL(r)+r.URL.RequestURI())
+					w.Header().Set("Retry-After", "1")
+					w.WriteHeader(http.StatusAccepted)
+					return
+				}
+				status := rule.Status
+				if status == 0 {
+					status = http.StatusInternalServerError
+				}
+				writeFault(w, r, store, rule, status)
+				return
+			}
+		}
+
+		operationID := sync.OnceValue(func() string { return operations.operationID(r) })
+		clientID := sync.OnceValue(func() string { return requestClientID(r) })
+		rule := faults.match(r, operationID, clientID)
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		log.Printf("Injecting fault %s into %s %s", rule.ID, r.Method, r.URL.Path)
+		if rule.LatencyMs > 0 {
+			select {
+			case <-time.After(time.Duration(rule.LatencyMs) * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		switch {
+		case rule.Drop:
+			// The server closes the connection without writing a response
+			panic(http.ErrAbortHandler)
+		case rule.FailOperation:
+			next.ServeHTTP(w, r)
+			var started []string
+			if location, err := url.Parse(w.Header().Get("Location")); err == nil {
+				started = operationResultPath.FindStringSubmatch(location.Path)
+			}
+			if started != nil {
+				faults.failOperation(started[1], rule)
+			} else {
+				log.Printf("Fault %s: %s %s started no long-running operation", rule.ID, r.Method, r.URL.Path)
+			}
+		case rule.Status != 0:
+			writeFault(w, r, store, rule, rule.Status)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// writeFault answers with an injected error in the shape of the API the request is for
+func writeFault(w http.ResponseWriter, r *http.Request, store *Store, rule *FaultRule, status int) {
+	code := rule.ErrorCode
+	if code == "" {
+		code = strings.ReplaceAll(http.StatusText(status), " ", "")
+	}
+	message := rule.Message
+	if message == "" {
+		message = fmt.Sprintf("Mockzure injected this %d fault (rule %s).", status, rule.ID)
+	}
+	retryAfter := rule.RetryAfter
+	if retryAfter == 0 && status == http.StatusTooManyRequests {
+		retryAfter = 1
+	}
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	if rule.FailureCause != "" {
+		w.Header().Set("x-ms-failure-cause", rule.FailureCause)
+	}
+
+	if account, _, _ := store.storageAccountForRequest(r); account != nil {
+		w.Header().Set("x-ms-request-id", newBlobRequestID())
+		writeBlobError(w, r, status, code, message, "")
+		return
+	}
+	body := (&mappers.ARMError{StatusCode: status, Code: code, Message: message}).Body()
+	if strings.HasPrefix(r.URL.Path, "/v1.0/") || strings.HasPrefix(r.URL.Path, "/beta/") {
+		requestID := newBlobRequestID()
+		body = map[string]interface{}{"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"innerError": map[string]interface{}{
+				"date":              time.Now().UTC().Format(time.RFC3339),
+				"request-id":        requestID,
+				"client-request-id": requestID,
+			},
+		}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// requestClientID returns the application ID of a request's caller: the appid of its bearer
+// token, its basic auth user name, or its client_id parameter
+func requestClientID(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if claims, err := parseUnsignedJWT(token); err == nil {
+			if appID, _ := claims["appid"].(string); appID != "" {
+				return appID
+			}
+		}
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	if clientID := r.URL.Query().Get("client_id"); clientID != "" {
+		return clientID
+	}
+	// Token requests send it in the form; the body is put back for the handler
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" && r.Body != nil {
+		data, err := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		if err == nil {
+			if form, err := url.ParseQuery(string(data)); err == nil {
+				return form.Get("client_id")
+			}
+		}
+	}
+	return ""
+}
+
+// serveFaults handles the fault rule admin API:
+//
+//	GET    /mock/azure/admin/faults       - list rules and how often they fired
+//	POST   /mock/azure/admin/faults       - add a rule
+//	DELETE /mock/azure/admin/faults       - delete every rule
+//	DELETE /mock/azure/admin/faults/{id}  - delete a rule
+func serveFaults(w http.ResponseWriter, r *http.Request, store *Store) {
+	faults := store.faultInjector()
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/mock/azure/admin/faults"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		writeAdminJSON(w, http.StatusOK, map[string]interface{}{"value": faults.list()})
+	case id == "" && r.Method == http.MethodPost:
+		var rule FaultRule
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&rule); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "Invalid fault rule", err)
+			return
+		}
+		added, err := faults.add(&rule)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, "Invalid fault rule", err)
+			return
+		}
+		log.Printf("Added fault rule %s", added.ID)
+		writeAdminJSON(w, http.StatusCreated, map[string]interface{}{"message": fmt.Sprintf("Fault rule %s added", added.ID), "rule": added, "status": "success"})
+	case id == "" && r.Method == http.MethodDelete:
+		faults.clear()
+		writeAdminJSON(w, http.StatusOK, map[string]interface{}{"message": "Fault rules deleted", "status": "success"})
+	case id != "" && r.Method == http.MethodDelete:
+		if !faults.remove(id) {
+			writeAdminJSON(w, http.StatusNotFound, map[string]interface{}{"message": fmt.Sprintf("Fault rule %s not found", id), "status": "error"})
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Fault rule %s deleted", id), "status": "success"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// faultInjector returns the fault rules of the loaded config
+func (s *Store) faultInjector() *faultInjector {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.faults
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addFault posts a rule to the fault admin API
+func addFault(t *testing.T, mux http.Handler, rule string) (int, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/mock/azure/admin/faults", strings.NewReader(rule)))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON response: %v: %s", err, w.Body.String())
+	}
+	return w.Code, body
+}
+
+func TestFaultRules(t *testing.T) {
+	rgPath := testSubscriptionPath + "/resourcegroups/rg-dev"
+
+	t.Run("config rules answer with Azure errors", func(t *testing.T) {
+		data, err := os.ReadFile("config.yaml.example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := string(data) + `
+faults:
+  - path: /resourcegroups/rg-dev$
+    method: GET
+    status: 503
+    failureCause: service
+    count: 1
+`
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if problems := validateConfig([]byte(config)); len(problems) != 0 {
+			t.Errorf("expected the config to validate, got %v", problems)
+		}
+		store := &Store{configPath: configPath}
+		store.init()
+		mux := newMux(store, "mockzure-specs")
+
+		req := httptest.NewRequest("GET", rgPath+"?api-version=2021-04-01", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusServiceUnavailable || armErrorCode(body) != "ServiceUnavailable" || w.Header().Get("x-ms-failure-cause") != "service" {
+			t.Errorf("expected the injected 503, got %d %v %v", w.Code, w.Header(), body)
+		}
+		if code, _ := armRequest(t, mux, "GET", rgPath, ""); code != http.StatusOK {
+			t.Errorf("expected the rule to stop after its count, got %d", code)
+		}
+	})
+
+	t.Run("rules match operationId and caller", func(t *testing.T) {
+		store := &Store{configPath: "config.yaml.example"}
+		store.init()
+		mux := newMux(store, "mockzure-specs")
+
+		if code, body := addFault(t, mux, `{"operationId":"ResourceGroups_Get","status":429,"errorCode":"TooManyRequests"}`); code != http.StatusCreated {
+			t.Fatalf("expected the rule to be added, got %d %v", code, body)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", rgPath+"?api-version=2021-04-01", nil))
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+			t.Errorf("expected a throttled response with Retry-After, got %d %v", w.Code, w.Header())
+		}
+		if code, _ := armRequest(t, mux, "GET", testSubscriptionPath+"/resourcegroups", ""); code != http.StatusOK {
+			t.Errorf("expected other operations to pass, got %d", code)
+		}
+
+		// Operations served by the hardcoded routes match too
+		vmPath := testSubscriptionPath + "/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines/vm-web-01"
+		addFault(t, mux, `{"operationId":"VirtualMachines_Get","status":503,"count":1}`)
+		if code, body := armRequest(t, mux, "GET", vmPath, ""); code != http.StatusServiceUnavailable {
+			t.Errorf("expected the injected 503 on VirtualMachines_Get, got %d %v", code, body)
+		}
+		if code, _ := armRequest(t, mux, "GET", vmPath+"/instanceView", ""); code != http.StatusOK {
+			t.Errorf("expected other VM operations to pass, got %d", code)
+		}
+
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/mock/azure/admin/faults", nil))
+		if code, body := addFault(t, mux, `{"id":"graph-500","path":"^/v1.0/users","clientId":"sandman-app-id-12345","status":500,"retryAfter":7}`); code != http.StatusCreated || body["rule"].(map[string]interface{})["id"] != "graph-500" {
+			t.Fatalf("expected the rule to be added, got %d %v", code, body)
+		}
+		req := httptest.NewRequest("GET", "/v1.0/users", nil)
+		req.SetBasicAuth("sandman-app-id-12345", "sandman-secret-key-development-only")
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		graphErr, _ := body["error"].(map[string]interface{})
+		if w.Code != http.StatusInternalServerError || w.Header().Get("Retry-After") != "7" || graphErr["innerError"] == nil {
+			t.Errorf("expected a Graph-shaped 500, got %d %v", w.Code, body)
+		}
+		req = httptest.NewRequest("GET", "/v1.0/users", nil)
+		req.SetBasicAuth("other-app", "secret")
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code == http.StatusInternalServerError {
+			t.Errorf("expected other callers to pass")
+		}
+
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/mock/azure/admin/faults", nil))
+		var list struct {
+			Value []faultRuleView `json:"value"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Value) != 1 || list.Value[0].Fired != 1 {
+			t.Errorf("expected the rule to have fired once, got %s", w.Body.String())
+		}
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/mock/azure/admin/faults/graph-500", nil))
+		if w.Code != http.StatusOK || len(store.faultInjector().list()) != 0 {
+			t.Errorf("expected the rule to be deleted, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("invalid rules are rejected", func(t *testing.T) {
+		store := &Store{configPath: "config.yaml.example"}
+		store.init()
+		mux := newMux(store, "mockzure-specs")
+		for _, rule := range []string{`{"path":"("}`, `{"path":"x"}`, `{"status":200}`, `{"drop":true,"status":500}`, `{"status":500,"probability":2}`, `{"status":500,"bogus":1}`} {
+			if code, _ := addFault(t, mux, rule); code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", rule, code)
+			}
+		}
+	})
+
+	t.Run("assigned IDs skip the ones taken", func(t *testing.T) {
+		store := &Store{configPath: "config.yaml.example"}
+		store.init()
+		mux := newMux(store, "mockzure-specs")
+		if code, body := addFault(t, mux, `{"id":"fault-1","status":500}`); code != http.StatusCreated {
+			t.Fatalf("expected the rule to be added, got %d %v", code, body)
+		}
+		code, body := addFault(t, mux, `{"status":500}`)
+		if rule, _ := body["rule"].(map[string]interface{}); code != http.StatusCreated || rule["id"] != "fault-2" {
+			t.Errorf("expected the next free ID, got %d %v", code, body)
+		}
+		if code, _ := addFault(t, mux, `{"id":"fault-2","status":500}`); code == http.StatusCreated {
+			t.Errorf("expected an explicit duplicate ID to be rejected")
+		}
+	})
+
+	t.Run("latency and dropped connections", func(t *testing.T) {
+		store := &Store{configPath: "config.yaml.example"}
+		store.init()
+		server := httptest.NewServer(newMux(store, "mockzure-specs"))
+		defer server.Close()
+
+		addFault(t, server.Config.Handler, `{"path":"/resourcegroups/rg-dev$","latencyMs":100}`)
+		addFault(t, server.Config.Handler, `{"path":"/resourcegroups/rg-prod$","drop":true}`)
+		start := time.Now()
+		resp, err := http.Get(server.URL + rgPath + "?api-version=2021-04-01")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || time.Since(start) < 100*time.Millisecond {
+			t.Errorf("expected a delayed 200, got %d after %v", resp.StatusCode, time.Since(start))
+		}
+		if resp, err := http.Get(server.URL + testSubscriptionPath + "/resourcegroups/rg-prod?api-version=2021-04-01"); err == nil {
+			resp.Body.Close()
+			t.Errorf("expected the connection to be dropped, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("long-running operations fail midway", func(t *testing.T) {
+		store := &Store{configPath: "config.yaml.example"}
+		store.init()
+		mux := newMux(store, "mockzure-specs")
+		addFault(t, mux, `{"operationId":"ResourceGroups_ExportTemplate","failOperation":true,"pollsBeforeFailure":2,"errorCode":"ExportFailed"}`)
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", rgPath+"/exportTemplate?api-version=2021-04-01", strings.NewReader(`{"resources":["*"]}`)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("expected the operation to start, got %d %s", w.Code, w.Body.String())
+		}
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for poll := 1; poll <= 3; poll++ {
+			code, body := armRequest(t, mux, "GET", location.RequestURI(), "")
+			want := http.StatusAccepted
+			if poll == 3 {
+				want = http.StatusInternalServerError
+			}
+			if code != want {
+				t.Errorf("poll %d: expected %d, got %d %v", poll, want, code, body)
+			}
+			if poll == 3 && armErrorCode(body) != "ExportFailed" {
+				t.Errorf("expected the rule's error code, got %v", body)
+			}
+		}
+	})
+}
@@ -2,81 +2,274 @@ package mappers
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 )
 
 // MapARMResponse maps store data to ARM API response format
 func MapARMResponse(operationID, pathPattern, method string, params map[string]string, store StoreInterface) (interface{}, error) {
+	return MapARMRequest(&Request{
+		OperationID: operationID,
+		PathPattern: pathPattern,
+		Method:      method,
+		Params:      params,
+	}, store)
+}
+
+// MapARMRequest maps store data to ARM API response format on behalf of the request's caller
+func MapARMRequest(req *Request, store StoreInterface) (interface{}, error) {
+	operationID, method, params := req.OperationID, req.Method, req.Params
+
+	// Every subscription-scoped call must target a subscription the caller can see
+	if subscriptionID := params["subscriptionId"]; subscriptionID != "" {
+		if err := checkSubscriptionAccess(subscriptionID, req.Principal, store); err != nil {
+			return nil, err
+		}
+	}
+
 	// Handle different ARM operations based on operation ID and path pattern
-	pathLower := strings.ToLower(pathPattern)
+	pathLower := strings.ToLower(req.PathPattern)
 
-	// Resource Groups operations
-	if strings.Contains(pathLower, "resourcegroups") {
-		return mapResourceGroupsResponse(operationID, method, params, store)
+	// Subscription and tenant discovery
+	switch pathLower {
+	case "/tenants":
+		return mapTenantsResponse(req, store)
+	case "/subscriptions", "/subscriptions/{subscriptionid}", "/subscriptions/{subscriptionid}/locations":
+		return mapSubscriptionsResponse(req, store)
+	case "/providers/microsoft.resourcegraph/resources":
+		return mapResourceGraphResponse(req, store)
+	}
+
+	// Generic resources and tags, whatever their resource type
+	switch operationID {
+	case "Resources_List", "Resources_ListByResourceGroup",
+		"Resources_CheckExistence", "Resources_Get", "Resources_CreateOrUpdate", "Resources_Update", "Resources_Delete",
+		"Resources_CheckExistenceById", "Resources_GetById", "Resources_CreateOrUpdateById", "Resources_UpdateById", "Resources_DeleteById":
+		return mapResourcesResponse(req, store)
+	case "Tags_GetAtScope", "Tags_CreateOrUpdateAtScope", "Tags_UpdateAtScope", "Tags_DeleteAtScope":
+		return mapTagsResponse(req, store)
+	case "Resources_MoveResources", "Resources_ValidateMoveResources":
+		return mapMoveResourcesResponse(req, store)
+	case "ResourceGroups_ExportTemplate":
+		return mapExportTemplateResponse(req, store)
+	case "OperationResults_Get":
+		return mapOperationResultResponse(req, store)
+	}
+
+	// Template deployments, at resource group and subscription scope
+	if strings.HasPrefix(operationID, "Deployments_") || strings.HasPrefix(operationID, "DeploymentOperations_") {
+		return mapDeploymentsResponse(req, store)
+	}
+
+	// Microsoft.Network lists; single network resources are served by the generic resource routes
+	if networkListOperation(operationID) {
+		return mapNetworkListResponse(req, store)
+	}
+
+	// Managed disks and snapshots; single disks are served by the generic resource routes
+	if strings.HasPrefix(operationID, "Disks_") || strings.HasPrefix(operationID, "Snapshots_") {
+		return mapDisksResponse(req, store)
+	}
+
+	// VM sizes, resource SKUs and compute quota usage
+	switch operationID {
+	case "ResourceSkus_List", "VirtualMachineSizes_List", "Usage_List":
+		return mapComputeSkusResponse(req, store)
+	}
+
+	// Storage account lists and keys; single storage accounts are served by the generic resource routes
+	if strings.HasPrefix(operationID, "StorageAccounts_") {
+		return mapStorageAccountsResponse(req, store)
+	}
+
+	// Scale sets and their instances; single scale sets are served by the generic resource routes
+	if strings.HasPrefix(operationID, "VirtualMachineScaleSet") {
+		return mapScaleSetsResponse(req, store)
 	}
 
-	// Virtual Machines operations
-	if strings.Contains(pathLower, "virtualmachines") || strings.Contains(pathLower, "virtualmachines") {
+	switch operationID {
+	case "VirtualMachines_Update":
+		return mapVirtualMachineUpdateResponse(req, store)
+	case "VirtualMachines_Start", "VirtualMachines_Deallocate", "VirtualMachines_Restart":
+		return mapVirtualMachineActionResponse(req, store)
+	case "VirtualMachines_RunCommand":
+		return mapVirtualMachineRunCommandResponse(req, store)
+	case "VirtualMachineExtensions_List", "VirtualMachineExtensions_Get", "VirtualMachineExtensions_CreateOrUpdate", "VirtualMachineExtensions_Delete":
+		return mapVMExtensionsResponse(req, store)
+	}
+
+	// Virtual Machines operations (checked first, VM paths also contain resourceGroups)
+	if strings.Contains(pathLower, "virtualmachines") {
 		return mapVirtualMachinesResponse(operationID, method, params, store)
 	}
 
+	// Resource Groups operations
+	if strings.Contains(pathLower, "resourcegroups") {
+		return mapResourceGroupsResponse(req, store)
+	}
+
 	// Operations list
 	if strings.Contains(pathLower, "/operations") {
 		return mapOperationsResponse(operationID, method, params)
 	}
 
-	// Default: return empty response
-	return map[string]interface{}{"value": []interface{}{}}, nil
+	return nil, ErrNotMapped
 }
 
-// mapResourceGroupsResponse handles resource group operations
-func mapResourceGroupsResponse(operationID, method string, params map[string]string, store StoreInterface) (interface{}, error) {
-	resourceGroups := store.GetResourceGroups()
-
-	switch method {
-	case "GET":
-		// List resource groups or get specific one
-		rgName := params["resourceGroupName"]
-		if rgName != "" {
-			// Get specific resource group
-			for _, rg := range resourceGroups {
-				// Type assert to get name (this will need to be adapted based on actual types)
-				if rgMap, ok := rg.(map[string]interface{}); ok {
-					if name, ok := rgMap["name"].(string); ok && name == rgName {
-						return rg, nil
-					}
+// checkSubscriptionAccess returns SubscriptionNotFound unless the subscription exists
+// and the principal (if any) has access to it
+func checkSubscriptionAccess(subscriptionID string, principal Principal, store StoreInterface) error {
+	for _, sub := range store.GetSubscriptions() {
+		if subMap, ok := sub.(map[string]interface{}); ok {
+			if id, ok := subMap["subscriptionId"].(string); ok && strings.EqualFold(id, subscriptionID) {
+				if principal != nil && !principal.CanAccessSubscription(id) {
+					return subscriptionNotFound(subscriptionID)
 				}
+				return nil
 			}
-			return nil, fmt.Errorf("resource group not found: %s", rgName)
 		}
+	}
+	return subscriptionNotFound(subscriptionID)
+}
+
+// inSubscription reports whether a store item belongs to the requested subscription
+// Items are not filtered when no subscription is requested
+func inSubscription(item map[string]interface{}, subscriptionID string) bool {
+	if subscriptionID == "" {
+		return true
+	}
+	sub, _ := item["subscriptionId"].(string)
+	return strings.EqualFold(sub, subscriptionID)
+}
 
-		// List all resource groups
+// mapResourceGroupsResponse handles resource group operations
+func mapResourceGroupsResponse(req *Request, store StoreInterface) (interface{}, error) {
+	subscriptionID := req.Params["subscriptionId"]
+	rgName := req.Params["resourceGroupName"]
+
+	if rgName == "" {
+		if req.Method != "GET" {
+			return nil, fmt.Errorf("unsupported method: %s", req.Method)
+		}
+		// List resource groups
+		resourceGroups := []interface{}{}
+		for _, rg := range store.GetResourceGroups() {
+			if rgMap, ok := rg.(map[string]interface{}); ok && inSubscription(rgMap, subscriptionID) {
+				resourceGroups = append(resourceGroups, convertResourceGroupToARMFormat(rgMap))
+			}
+		}
 		return map[string]interface{}{
 			"value": resourceGroups,
 		}, nil
+	}
 
-	case "POST", "PUT":
-		// Create or update resource group
-		// This would typically parse the request body
-		// For now, return a success response
-		return map[string]interface{}{
-			"id":       fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", params["subscriptionId"], params["resourceGroupName"]),
-			"name":     params["resourceGroupName"],
-			"location": params["location"],
-		}, nil
+	existing := findResourceGroup(subscriptionID, rgName, store)
+	switch req.Method {
+	case "HEAD":
+		if existing == nil {
+			return &Response{StatusCode: http.StatusNotFound}, nil
+		}
+		return &Response{StatusCode: http.StatusNoContent}, nil
+
+	case "GET":
+		if existing == nil {
+			return nil, resourceGroupNotFound(rgName)
+		}
+		return convertResourceGroupToARMFormat(existing), nil
+	}
+
+	rs, ok := store.(ResourceStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support resource group updates")
+	}
+	if req.Principal != nil && !req.Principal.HasPermission(subscriptionID, rgName, "write") {
+		return nil, authorizationFailed("Microsoft.Resources/subscriptions/resourceGroups/"+strings.ToLower(req.Method), fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionID, rgName))
+	}
+
+	switch req.Method {
+	case "PUT", "PATCH":
+		body, err := decodeBody(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		rg := map[string]interface{}{
+			"id":             fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionID, rgName),
+			"name":           rgName,
+			"subscriptionId": subscriptionID,
+		}
+		if existing != nil {
+			rg = existing
+		}
+		location, _ := body["location"].(string)
+		if req.Method == "PUT" {
+			if location == "" {
+				return nil, &ARMError{
+					StatusCode: http.StatusBadRequest,
+					Code:       "LocationRequired",
+					Message:    "The location property is required for this definition.",
+				}
+			}
+			if existing != nil {
+				if current, _ := existing["location"].(string); NormalizeLocation(current) != NormalizeLocation(location) {
+					return nil, &ARMError{
+						StatusCode: http.StatusConflict,
+						Code:       "InvalidResourceGroupLocation",
+						Message:    fmt.Sprintf("Invalid resource group location '%s'. The Resource group already exists in location '%s'.", location, current),
+					}
+				}
+			}
+			rg["location"] = location
+			rg["tags"] = resourceTags(body)
+		} else if _, ok := body["tags"]; ok {
+			rg["tags"] = resourceTags(body)
+		}
+		if err := rs.PutResourceGroup(rg); err != nil {
+			return nil, err
+		}
+		result := convertResourceGroupToARMFormat(findResourceGroup(subscriptionID, rgName, store))
+		if existing == nil {
+			return &Response{StatusCode: http.StatusCreated, Body: result}, nil
+		}
+		return result, nil
 
 	case "DELETE":
-		// Delete resource group
-		return nil, nil
+		// Deleting a resource group deletes everything in it
+		if !rs.DeleteResourceGroup(subscriptionID, rgName) {
+			return nil, resourceGroupNotFound(rgName)
+		}
+		return &Response{StatusCode: http.StatusOK}, nil
 
 	default:
-		return nil, fmt.Errorf("unsupported method: %s", method)
+		return nil, fmt.Errorf("unsupported method: %s", req.Method)
+	}
+}
+
+// convertResourceGroupToARMFormat converts a resource group from internal format to ARM API format
+func convertResourceGroupToARMFormat(rg map[string]interface{}) map[string]interface{} {
+	tags := rg["tags"]
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	return map[string]interface{}{
+		"id":       rg["id"],
+		"name":     rg["name"],
+		"type":     "Microsoft.Resources/resourceGroups",
+		"location": rg["location"],
+		"tags":     tags,
+		"properties": map[string]interface{}{
+			"provisioningState": "Succeeded",
+		},
 	}
 }
 
 // mapVirtualMachinesResponse handles virtual machine operations
 func mapVirtualMachinesResponse(operationID, method string, params map[string]string, store StoreInterface) (interface{}, error) {
-	vms := store.GetVMs()
+	vms := []interface{}{}
+	for _, vm := range store.GetVMs() {
+		if vmMap, ok := vm.(map[string]interface{}); ok && inSubscription(vmMap, params["subscriptionId"]) {
+			vms = append(vms, vm)
+		}
+	}
 	vmName := params["vmName"]
 	resourceGroup := params["resourceGroupName"]
 
@@ -93,7 +286,10 @@ func mapVirtualMachinesResponse(operationID, method string, params map[string]st
 								continue
 							}
 						}
-						return convertVMToARMFormat(vmMap), nil
+						if operationID == "VirtualMachines_InstanceView" {
+							return vmInstanceView(vmMap), nil
+						}
+						return convertVMToARMFormatExpanded(vmMap, params["$expand"]), nil
 					}
 				}
 			}
@@ -106,10 +302,10 @@ func mapVirtualMachinesResponse(operationID, method string, params map[string]st
 			if vmMap, ok := vm.(map[string]interface{}); ok {
 				if resourceGroup != "" {
 					if rg, ok := vmMap["resourceGroup"].(string); ok && rg == resourceGroup {
-						filteredVMs = append(filteredVMs, convertVMToARMFormat(vmMap))
+						filteredVMs = append(filteredVMs, convertVMToARMFormatExpanded(vmMap, params["$expand"]))
 					}
 				} else {
-					filteredVMs = append(filteredVMs, convertVMToARMFormat(vmMap))
+					filteredVMs = append(filteredVMs, convertVMToARMFormatExpanded(vmMap, params["$expand"]))
 				}
 			}
 		}
@@ -158,47 +354,38 @@ func convertVMToARMFormat(vm map[string]interface{}) map[string]interface{} {
 		"tags":     vm["tags"],
 	}
 
-	// Build properties object
+	// Build properties object; the instance view is only returned on request, see vmInstanceView
 	properties := map[string]interface{}{
 		"vmId":              vm["id"],
 		"provisioningState": vm["provisioningState"],
 		"hardwareProfile": map[string]interface{}{
 			"vmSize": vm["vmSize"],
 		},
-		"storageProfile": map[string]interface{}{
-			"osDisk": map[string]interface{}{
-				"osType": vm["osType"],
-			},
-		},
+		"storageProfile": vmStorageProfile(vm),
+		"osProfile":      vmOSProfile(vm),
+		"networkProfile": vmNetworkProfile(vm),
 	}
-
-	// Add instance view if status is available
-	if status, ok := vm["status"].(string); ok {
-		powerStateCode := "PowerState/" + status
-		if status == "stopped" {
-			powerStateCode = "PowerState/deallocated"
-		}
-
-		properties["instanceView"] = map[string]interface{}{
-			"statuses": []map[string]interface{}{
-				{
-					"code":          powerStateCode,
-					"level":         "Info",
-					"displayStatus": vm["powerState"],
-				},
-				{
-					"code":          "ProvisioningState/" + fmt.Sprintf("%v", vm["provisioningState"]),
-					"level":         "Info",
-					"displayStatus": "Provisioning " + strings.ToLower(fmt.Sprintf("%v", vm["provisioningState"])),
-				},
-			},
-		}
+	if zones, ok := vm["zones"]; ok && zones != nil {
+		armVM["zones"] = zones
+	}
+	if extensions := vmExtensions(vm); len(extensions) > 0 {
+		armVM["resources"] = extensions
 	}
 
 	armVM["properties"] = properties
 	return armVM
 }
 
+// convertVMToARMFormatExpanded converts a VM to ARM format, adding its instance view
+// when the $expand query parameter asks for it
+func convertVMToARMFormatExpanded(vm map[string]interface{}, expand string) map[string]interface{} {
+	armVM := convertVMToARMFormat(vm)
+	if strings.Contains(strings.ToLower(expand), "instanceview") {
+		armVM["properties"].(map[string]interface{})["instanceView"] = vmInstanceView(vm)
+	}
+	return armVM
+}
+
 // mapOperationsResponse handles operations list
 func mapOperationsResponse(operationID, method string, params map[string]string) (interface{}, error) {
 	// Return list of available operations
@@ -0,0 +1,894 @@
+package mappers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// deploymentRequest is the body of Deployments_CreateOrUpdate, Deployments_Validate and Deployments_WhatIf
+type deploymentRequest struct {
+	Location   string            `json:"location"`
+	Tags       map[string]string `json:"tags"`
+	Properties struct {
+		Template       map[string]interface{} `json:"template"`
+		TemplateLink   map[string]interface{} `json:"templateLink"`
+		Parameters     map[string]interface{} `json:"parameters"`
+		ParametersLink map[string]interface{} `json:"parametersLink"`
+		Mode           string                 `json:"mode"`
+	} `json:"properties"`
+}
+
+// deploymentScope is the resource group or subscription a deployment targets
+type deploymentScope struct {
+	subscriptionID string
+	resourceGroup  map[string]interface{} // nil for subscription deployments
+	id             string
+}
+
+// plannedResource is a template resource with every expression evaluated
+type plannedResource struct {
+	id           string
+	resourceType string
+	name         string
+	body         map[string]interface{}
+	dependsOn    []string // IDs of planned resources
+}
+
+// deploymentPlan is a validated deployment, with its resources in dependency order
+type deploymentPlan struct {
+	request    deploymentRequest
+	mode       string
+	ctx        *templateContext
+	resources  []*plannedResource
+	parameters map[string]interface{} // parameters as echoed in the deployment properties
+}
+
+// templateResourceKeys are template resource keys that are not part of the deployed resource
+var templateResourceKeys = map[string]bool{
+	"type": true, "name": true, "apiversion": true, "dependson": true, "condition": true,
+	"comments": true, "resources": true, "metadata": true,
+}
+
+// mapDeploymentsResponse handles the Deployments_* and DeploymentOperations_* operations
+// at resource group and subscription scope
+func mapDeploymentsResponse(req *Request, store StoreInterface) (interface{}, error) {
+	ds, ok := store.(DeploymentStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support deployments")
+	}
+
+	scope := &deploymentScope{subscriptionID: req.Params["subscriptionId"]}
+	scope.id = "/subscriptions/" + scope.subscriptionID
+	if rgName := req.Params["resourceGroupName"]; rgName != "" {
+		if scope.resourceGroup = findResourceGroup(scope.subscriptionID, rgName, store); scope.resourceGroup == nil {
+			return nil, resourceGroupNotFound(rgName)
+		}
+		scope.id, _ = scope.resourceGroup["id"].(string)
+	}
+	name := req.Params["deploymentName"]
+	deploymentID := scope.id + "/providers/Microsoft.Resources/deployments/" + name
+	existing := findDeployment(deploymentID, ds)
+
+	operationID := strings.TrimSuffix(req.OperationID, "AtSubscriptionScope")
+	switch operationID {
+	case "Deployments_List", "Deployments_ListByResourceGroup":
+		deployments := []interface{}{}
+		prefix := scope.id + "/providers/Microsoft.Resources/deployments/"
+		for _, d := range ds.GetDeployments() {
+			if dMap, ok := d.(map[string]interface{}); ok {
+				if id, _ := dMap["id"].(string); hasPrefixFold(id, prefix) {
+					deployments = append(deployments, convertDeploymentToARMFormat(dMap))
+				}
+			}
+		}
+		sort.Slice(deployments, func(i, j int) bool {
+			return deployments[i].(map[string]interface{})["name"].(string) < deployments[j].(map[string]interface{})["name"].(string)
+		})
+		return map[string]interface{}{"value": deployments}, nil
+
+	case "Deployments_CheckExistence":
+		if existing == nil {
+			return &Response{StatusCode: http.StatusNotFound}, nil
+		}
+		return &Response{StatusCode: http.StatusNoContent}, nil
+	}
+
+	// Everything below needs the deployment, or writes it
+	switch operationID {
+	case "Deployments_CreateOrUpdate", "Deployments_Validate", "Deployments_WhatIf", "Deployments_Delete":
+		if req.Principal != nil && scope.resourceGroup != nil && !req.Principal.HasPermission(scope.subscriptionID, scope.resourceGroup["name"].(string), "write") {
+			return nil, authorizationFailed("Microsoft.Resources/deployments/write", deploymentID)
+		}
+	default:
+		if existing == nil {
+			return nil, &ARMError{
+				StatusCode: http.StatusNotFound,
+				Code:       "DeploymentNotFound",
+				Message:    fmt.Sprintf("Deployment '%s' could not be found.", name),
+			}
+		}
+	}
+
+	switch operationID {
+	case "Deployments_Get":
+		return convertDeploymentToARMFormat(existing), nil
+
+	case "Deployments_ExportTemplate":
+		return map[string]interface{}{"template": existing["template"]}, nil
+
+	case "DeploymentOperations_List":
+		operations, _ := existing["operations"].([]interface{})
+		if operations == nil {
+			operations = []interface{}{}
+		}
+		return map[string]interface{}{"value": operations}, nil
+
+	case "DeploymentOperations_Get":
+		operations, _ := existing["operations"].([]interface{})
+		for _, op := range operations {
+			if opMap, ok := op.(map[string]interface{}); ok && strings.EqualFold(fmt.Sprint(opMap["operationId"]), req.Params["operationId"]) {
+				return opMap, nil
+			}
+		}
+		return nil, &ARMError{
+			StatusCode: http.StatusNotFound,
+			Code:       "DeploymentOperationNotFound",
+			Message:    fmt.Sprintf("Deployment operation '%s' could not be found.", req.Params["operationId"]),
+		}
+
+	case "Deployments_Delete":
+		// Deleting a deployment removes its history, not the resources it deployed
+		if existing == nil {
+			return &Response{StatusCode: http.StatusNoContent}, nil
+		}
+		ds.DeleteDeployment(deploymentID)
+		return acceptOperation(req, store, scope.subscriptionID, &Operation{})
+
+	case "Deployments_Validate":
+		plan, err := planDeployment(req, store, scope, name)
+		if err != nil {
+			return nil, err
+		}
+		validated := []interface{}{}
+		for _, r := range plan.resources {
+			validated = append(validated, map[string]interface{}{"id": r.id})
+		}
+		now := time.Now().UTC()
+		return map[string]interface{}{
+			"id":   deploymentID,
+			"name": name,
+			"type": "Microsoft.Resources/deployments",
+			"properties": map[string]interface{}{
+				"provisioningState":  "Succeeded",
+				"mode":               plan.mode,
+				"parameters":         plan.parameters,
+				"timestamp":          now.Format(time.RFC3339Nano),
+				"duration":           "PT0S",
+				"correlationId":      newGUID(),
+				"validatedResources": validated,
+			},
+		}, nil
+
+	case "Deployments_WhatIf":
+		plan, err := planDeployment(req, store, scope, name)
+		if err != nil {
+			return nil, err
+		}
+		return acceptOperation(req, store, scope.subscriptionID, &Operation{
+			StatusCode: http.StatusOK,
+			Body: map[string]interface{}{
+				"status": "Succeeded",
+				"properties": map[string]interface{}{
+					"changes": whatIfChanges(plan, scope, store),
+				},
+			},
+		})
+
+	case "Deployments_CreateOrUpdate":
+		plan, err := planDeployment(req, store, scope, name)
+		if err != nil {
+			return nil, err
+		}
+		deployment := runDeployment(req, store, scope, deploymentID, plan)
+		if err := ds.PutDeployment(deployment); err != nil {
+			return nil, err
+		}
+		status := http.StatusOK
+		if existing == nil {
+			status = http.StatusCreated
+		}
+		return &Response{StatusCode: status, Body: convertDeploymentToARMFormat(deployment)}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported deployment operation: %s", req.OperationID)
+	}
+}
+
+// findDeployment looks a deployment up by ID
+func findDeployment(id string, ds DeploymentStore) map[string]interface{} {
+	for _, d := range ds.GetDeployments() {
+		if dMap, ok := d.(map[string]interface{}); ok {
+			if dID, _ := dMap["id"].(string); strings.EqualFold(dID, id) {
+				return dMap
+			}
+		}
+	}
+	return nil
+}
+
+// convertDeploymentToARMFormat returns the ARM view of a stored deployment, without its template and operations
+func convertDeploymentToARMFormat(deployment map[string]interface{}) map[string]interface{} {
+	armDeployment := map[string]interface{}{
+		"id":         deployment["id"],
+		"name":       deployment["name"],
+		"type":       "Microsoft.Resources/deployments",
+		"properties": deployment["properties"],
+	}
+	for _, field := range []string{"location", "tags"} {
+		if v, ok := deployment[field]; ok && v != nil {
+			armDeployment[field] = v
+		}
+	}
+	return armDeployment
+}
+
+// planDeployment validates a deployment request and evaluates its template
+func planDeployment(req *Request, store StoreInterface, scope *deploymentScope, name string) (*deploymentPlan, error) {
+	plan := &deploymentPlan{}
+	if err := json.Unmarshal(req.Body, &plan.request); err != nil {
+		return nil, invalidRequestContent(err)
+	}
+	props := plan.request.Properties
+	if props.TemplateLink != nil || props.ParametersLink != nil {
+		return nil, &ARMError{
+			StatusCode: http.StatusBadRequest,
+			Code:       "InvalidRequestContent",
+			Message:    "Linked templates and parameter files are not supported. Send the template and parameters inline.",
+		}
+	}
+	if props.Template == nil {
+		return nil, invalidRequestContent(fmt.Errorf("'properties.template' is required"))
+	}
+	switch strings.ToLower(props.Mode) {
+	case "", "incremental":
+		plan.mode = "Incremental"
+	case "complete":
+		if scope.resourceGroup == nil {
+			return nil, &ARMError{
+				StatusCode: http.StatusBadRequest,
+				Code:       "InvalidDeploymentMode",
+				Message:    "Complete mode is only supported for resource group deployments.",
+			}
+		}
+		plan.mode = "Complete"
+	default:
+		return nil, &ARMError{
+			StatusCode: http.StatusBadRequest,
+			Code:       "InvalidDeploymentMode",
+			Message:    fmt.Sprintf("The deployment mode '%s' is not valid. Allowed values are 'Incremental' and 'Complete'.", props.Mode),
+		}
+	}
+	if scope.resourceGroup == nil && plan.request.Location == "" {
+		return nil, &ARMError{
+			StatusCode: http.StatusBadRequest,
+			Code:       "LocationRequired",
+			Message:    "The location property is required for subscription deployments.",
+		}
+	}
+
+	ctx := &templateContext{
+		subscriptionID: scope.subscriptionID,
+		deploymentName: name,
+		provided:       props.Parameters,
+		parameters:     map[string]interface{}{},
+		variables:      map[string]interface{}{},
+		resolving:      map[string]bool{},
+	}
+	ctx.parameterDefs, _ = props.Template["parameters"].(map[string]interface{})
+	ctx.variableDefs, _ = props.Template["variables"].(map[string]interface{})
+	if req.Principal != nil {
+		ctx.tenantID = req.Principal.TenantID()
+	}
+	if scope.resourceGroup != nil {
+		ctx.resourceGroup = convertResourceGroupToARMFormat(scope.resourceGroup)
+	}
+	plan.ctx = ctx
+
+	// Every supplied parameter must be declared, and every declared one must resolve
+	for supplied := range props.Parameters {
+		if _, ok := lookupFold(ctx.parameterDefs, supplied); !ok {
+			return nil, invalidTemplate("The template parameter '%s' is not valid; it is not present in the original template and can therefore not be provided at deployment time", supplied)
+		}
+	}
+	plan.parameters = map[string]interface{}{}
+	for _, paramName := range sortedKeys(ctx.parameterDefs) {
+		value, err := ctx.parameter(paramName)
+		if err != nil {
+			return nil, err
+		}
+		def, _ := ctx.parameterDefs[paramName].(map[string]interface{})
+		paramType, _ := def["type"].(string)
+		echoed := map[string]interface{}{"type": paramType}
+		if !strings.HasPrefix(strings.ToLower(paramType), "secure") {
+			echoed["value"] = value
+		}
+		plan.parameters[paramName] = echoed
+	}
+	for _, variableName := range sortedKeys(ctx.variableDefs) {
+		if _, err := ctx.variable(variableName); err != nil {
+			return nil, err
+		}
+	}
+
+	rawResources, ok := props.Template["resources"].([]interface{})
+	if !ok && props.Template["resources"] != nil {
+		return nil, invalidTemplate("The template 'resources' property must be an array")
+	}
+	planned := []*plannedResource{}
+	for _, raw := range rawResources {
+		resources, err := planResource(ctx, scope, raw, nil)
+		if err != nil {
+			return nil, err
+		}
+		planned = append(planned, resources...)
+	}
+	ordered, err := orderResources(planned)
+	if err != nil {
+		return nil, err
+	}
+	plan.resources = ordered
+	return plan, nil
+}
+
+// planResource evaluates a template resource and the child resources nested in it
+func planResource(ctx *templateContext, scope *deploymentScope, raw interface{}, parent *plannedResource) ([]*plannedResource, error) {
+	resource, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, invalidTemplate("Template resources must be objects")
+	}
+	if condition, ok := resource["condition"]; ok {
+		value, err := ctx.evaluate(condition)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok := value.(bool); !ok {
+			return nil, invalidTemplate("The template resource condition must be a boolean")
+		} else if !b {
+			return nil, nil
+		}
+	}
+	for _, unsupported := range []string{"copy", "resourceGroup", "subscriptionId", "scope"} {
+		if _, ok := resource[unsupported]; ok {
+			return nil, invalidTemplate("The template resource property '%s' is not supported", unsupported)
+		}
+	}
+
+	resourceType, err := ctx.evaluateString(resource["type"], "resource type")
+	if err != nil {
+		return nil, err
+	}
+	name, err := ctx.evaluateString(resource["name"], "resource name")
+	if err != nil {
+		return nil, err
+	}
+	p := &plannedResource{resourceType: resourceType, name: name, body: map[string]interface{}{}}
+	if parent != nil {
+		p.resourceType = parent.resourceType + "/" + resourceType
+		p.name = parent.name + "/" + name
+		p.dependsOn = append(p.dependsOn, parent.id)
+	}
+
+	switch {
+	case strings.EqualFold(p.resourceType, "Microsoft.Resources/deployments"):
+		return nil, invalidTemplate("Nested deployments are not supported")
+	case strings.EqualFold(p.resourceType, "Microsoft.Resources/resourceGroups"):
+		if scope.resourceGroup != nil {
+			return nil, invalidTemplate("The resource group '%s' can only be deployed at subscription scope", p.name)
+		}
+		p.id = fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", scope.subscriptionID, p.name)
+	default:
+		typeSegments := strings.Split(p.resourceType, "/")
+		nameSegments := strings.Split(p.name, "/")
+		if len(typeSegments) < 2 || len(nameSegments) != len(typeSegments)-1 {
+			return nil, invalidTemplate("The template resource '%s' for type '%s' has incorrect segment lengths. A nested resource type must have identical number of segments as its resource name", p.name, p.resourceType)
+		}
+		p.id = scope.id + "/providers/" + typeSegments[0]
+		for i, segment := range nameSegments {
+			p.id += "/" + typeSegments[i+1] + "/" + segment
+		}
+	}
+
+	for k, v := range resource {
+		if templateResourceKeys[strings.ToLower(k)] {
+			continue
+		}
+		evaluated, err := ctx.evaluate(v)
+		if err != nil {
+			return nil, err
+		}
+		p.body[k] = evaluated
+	}
+	if dependsOn, ok := resource["dependsOn"].([]interface{}); ok {
+		for _, dep := range dependsOn {
+			s, err := ctx.evaluateString(dep, "dependsOn entry")
+			if err != nil {
+				return nil, err
+			}
+			p.dependsOn = append(p.dependsOn, s)
+		}
+	}
+
+	planned := []*plannedResource{p}
+	if children, ok := resource["resources"].([]interface{}); ok {
+		for _, child := range children {
+			nested, err := planResource(ctx, scope, child, p)
+			if err != nil {
+				return nil, err
+			}
+			planned = append(planned, nested...)
+		}
+	}
+	return planned, nil
+}
+
+// orderResources resolves dependsOn references and sorts resources so that
+// each comes after its dependencies, keeping template order otherwise
+func orderResources(resources []*plannedResource) ([]*plannedResource, error) {
+	byID := map[string]*plannedResource{}
+	for _, r := range resources {
+		key := strings.ToLower(r.id)
+		if byID[key] != nil {
+			return nil, invalidTemplate("The resource '%s' is defined multiple times in a template", r.id)
+		}
+		byID[key] = r
+	}
+	for _, r := range resources {
+		resolved := []string{}
+		for _, dep := range r.dependsOn {
+			var target *plannedResource
+			for _, candidate := range resources {
+				if strings.EqualFold(candidate.id, dep) || strings.EqualFold(candidate.name, dep) ||
+					strings.EqualFold(candidate.resourceType+"/"+candidate.name, dep) {
+					target = candidate
+					break
+				}
+			}
+			if target == nil {
+				return nil, invalidTemplate("The resource '%s' is not defined in the template", dep)
+			}
+			resolved = append(resolved, target.id)
+		}
+		r.dependsOn = resolved
+	}
+
+	ordered := []*plannedResource{}
+	state := map[string]int{} // 1 while visiting, 2 once ordered
+	var visit func(r *plannedResource) error
+	visit = func(r *plannedResource) error {
+		key := strings.ToLower(r.id)
+		switch state[key] {
+		case 1:
+			return invalidTemplate("Circular dependency detected on resource: '%s'", r.id)
+		case 2:
+			return nil
+		}
+		state[key] = 1
+		for _, dep := range r.dependsOn {
+			if err := visit(byID[strings.ToLower(dep)]); err != nil {
+				return err
+			}
+		}
+		state[key] = 2
+		ordered = append(ordered, r)
+		return nil
+	}
+	for _, r := range resources {
+		if err := visit(r); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// runDeployment applies a deployment plan and returns the deployment record.
+// Resources are deployed in dependency order and the deployment stops at the first failure.
+func runDeployment(req *Request, store StoreInterface, scope *deploymentScope, deploymentID string, plan *deploymentPlan) map[string]interface{} {
+	start := time.Now().UTC()
+	operations := []interface{}{}
+	outputResources := []interface{}{}
+	var failure *ARMError
+
+	record := func(provisioningOperation, id, resourceType string, status int, err error) {
+		opID := strings.ToUpper(newOperationID()[:16])
+		properties := map[string]interface{}{
+			"provisioningOperation": provisioningOperation,
+			"provisioningState":     "Succeeded",
+			"timestamp":             time.Now().UTC().Format(time.RFC3339Nano),
+			"duration":              "PT0S",
+			"trackingId":            newGUID(),
+			"targetResource": map[string]interface{}{
+				"id":           id,
+				"resourceType": resourceType,
+				"resourceName": id[strings.LastIndex(id, "/")+1:],
+			},
+		}
+		if err != nil {
+			armErr := asARMError(err)
+			status = armErr.StatusCode
+			properties["provisioningState"] = "Failed"
+			properties["statusMessage"] = armErr.Body()
+			failure = armErr
+		}
+		properties["statusCode"] = strings.ReplaceAll(http.StatusText(status), " ", "")
+		operations = append(operations, map[string]interface{}{
+			"id":          deploymentID + "/operations/" + opID,
+			"operationId": opID,
+			"properties":  properties,
+		})
+	}
+
+	for _, r := range plan.resources {
+		status, err := deployResource(req, store, r)
+		record("Create", r.id, r.resourceType, status, err)
+		if err != nil {
+			break
+		}
+		outputResources = append(outputResources, map[string]interface{}{"id": r.id})
+	}
+
+	// Complete mode removes top-level resources in the group that the template does not declare.
+	// A resource still used by another one fails to delete, so it is retried once the
+	// resources using it are gone, which deletes in reverse dependency order like ARM does.
+	if failure == nil && plan.mode == "Complete" {
+		pending := unmanagedResources(plan, scope, store)
+		for len(pending) > 0 {
+			retry := []string{}
+			errs := map[string]error{}
+			for _, id := range pending {
+				rid, _ := ParseResourceID(id)
+				_, err := mapResourcesResponse(&Request{
+					OperationID: "Resources_DeleteById",
+					Method:      "DELETE",
+					Params:      map[string]string{"resourceId": id},
+					Principal:   req.Principal,
+				}, store)
+				if err != nil {
+					retry = append(retry, id)
+					errs[id] = err
+					continue
+				}
+				record("Delete", id, rid.Type(), http.StatusOK, nil)
+			}
+			if len(retry) == len(pending) {
+				rid, _ := ParseResourceID(retry[0])
+				record("Delete", retry[0], rid.Type(), http.StatusOK, errs[retry[0]])
+				break
+			}
+			pending = retry
+		}
+	}
+
+	properties := map[string]interface{}{
+		"mode":            plan.mode,
+		"parameters":      plan.parameters,
+		"correlationId":   newGUID(),
+		"outputResources": outputResources,
+		"outputs":         map[string]interface{}{},
+	}
+	if failure == nil {
+		if outputs, ok := plan.request.Properties.Template["outputs"].(map[string]interface{}); ok {
+			evaluated, err := evaluateOutputs(plan.ctx, outputs)
+			if err != nil {
+				failure = asARMError(err)
+			} else {
+				properties["outputs"] = evaluated
+			}
+		}
+	}
+	properties["provisioningState"] = "Succeeded"
+	if failure != nil {
+		properties["provisioningState"] = "Failed"
+		properties["error"] = (&ARMError{
+			Code:    "DeploymentFailed",
+			Message: "At least one resource deployment operation failed. Please list deployment operations for details.",
+			Details: []*ARMError{failure},
+		}).detail()
+	}
+	end := time.Now().UTC()
+	properties["timestamp"] = end.Format(time.RFC3339Nano)
+	properties["duration"] = fmt.Sprintf("PT%.3fS", end.Sub(start).Seconds())
+
+	deployment := map[string]interface{}{
+		"id":         deploymentID,
+		"name":       deploymentID[strings.LastIndex(deploymentID, "/")+1:],
+		"properties": properties,
+		"template":   plan.request.Properties.Template,
+		"operations": operations,
+	}
+	if plan.request.Location != "" {
+		deployment["location"] = plan.request.Location
+	}
+	if len(plan.request.Tags) > 0 {
+		deployment["tags"] = plan.request.Tags
+	}
+	return deployment
+}
+
+// deployResource creates or updates one planned resource through the same mappers
+// the ARM API uses, so the same validation and authorization apply
+func deployResource(req *Request, store StoreInterface, r *plannedResource) (int, error) {
+	body, err := json.Marshal(r.body)
+	if err != nil {
+		return 0, err
+	}
+
+	var response interface{}
+	switch {
+	case strings.EqualFold(r.resourceType, "Microsoft.Resources/resourceGroups"):
+		rid := strings.Split(strings.Trim(r.id, "/"), "/")
+		response, err = mapResourceGroupsResponse(&Request{
+			Method:    "PUT",
+			Params:    map[string]string{"subscriptionId": rid[1], "resourceGroupName": r.name},
+			Principal: req.Principal,
+			Body:      body,
+		}, store)
+
+	case strings.EqualFold(r.resourceType, "Microsoft.Compute/virtualMachines"):
+		return deployVM(req, store, r)
+
+	default:
+		response, err = mapResourcesResponse(&Request{
+			OperationID: "Resources_CreateOrUpdateById",
+			Method:      "PUT",
+			Params:      map[string]string{"resourceId": r.id},
+			Principal:   req.Principal,
+			Body:        body,
+		}, store)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if resp, ok := response.(*Response); ok {
+		return resp.StatusCode, nil
+	}
+	return http.StatusOK, nil
+}
+
+// deployVM creates or updates a virtual machine from a template resource
+func deployVM(req *Request, store StoreInterface, r *plannedResource) (int, error) {
+	rid, err := ParseResourceID(r.id)
+	if err != nil {
+		return 0, err
+	}
+	return putVM(req, rid, r.body, store)
+}
+
+// unmanagedResources returns the top-level resources in the deployment's resource group
+// that the template does not declare
+func unmanagedResources(plan *deploymentPlan, scope *deploymentScope, store StoreInterface) []string {
+	declared := map[string]bool{}
+	for _, r := range plan.resources {
+		declared[strings.ToLower(r.id)] = true
+	}
+	candidates := []string{}
+	if rs, ok := store.(ResourceStore); ok {
+		for _, r := range rs.GetResources() {
+			if rMap, ok := r.(map[string]interface{}); ok {
+				id, _ := rMap["id"].(string)
+				candidates = append(candidates, id)
+			}
+		}
+	}
+	for _, vm := range store.GetVMs() {
+		if vmMap, ok := vm.(map[string]interface{}); ok {
+			id, _ := vmMap["id"].(string)
+			candidates = append(candidates, id)
+		}
+	}
+
+	unmanaged := []string{}
+	for _, id := range candidates {
+		rid, err := ParseResourceID(id)
+		if err != nil || rid.Parent() != nil || !hasPrefixFold(id, scope.id+"/") || declared[strings.ToLower(id)] {
+			continue
+		}
+		unmanaged = append(unmanaged, id)
+	}
+	sort.Strings(unmanaged)
+	return unmanaged
+}
+
+// evaluateOutputs evaluates the template outputs once the resources are deployed
+func evaluateOutputs(ctx *templateContext, outputs map[string]interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	for _, name := range sortedKeys(outputs) {
+		def, _ := outputs[name].(map[string]interface{})
+		value, err := ctx.evaluate(def["value"])
+		if err != nil {
+			return nil, err
+		}
+		result[name] = map[string]interface{}{"type": def["type"], "value": value}
+	}
+	return result, nil
+}
+
+// whatIfChanges predicts the changes a deployment would make without applying it
+func whatIfChanges(plan *deploymentPlan, scope *deploymentScope, store StoreInterface) []interface{} {
+	changes := []interface{}{}
+	for _, r := range plan.resources {
+		after := map[string]interface{}{
+			"id":   r.id,
+			"name": r.id[strings.LastIndex(r.id, "/")+1:],
+			"type": r.resourceType,
+		}
+		for k, v := range r.body {
+			after[k] = v
+		}
+		change := map[string]interface{}{"resourceId": r.id, "after": after}
+		before := currentResource(r.id, r.resourceType, store)
+		switch {
+		case before == nil:
+			change["changeType"] = "Create"
+		case isUnchanged(before, after):
+			change["changeType"] = "NoChange"
+			change["before"] = before
+		default:
+			change["changeType"] = "Modify"
+			change["before"] = before
+		}
+		changes = append(changes, change)
+	}
+
+	if scope.resourceGroup != nil {
+		changeType := "Ignore"
+		if plan.mode == "Complete" {
+			changeType = "Delete"
+		}
+		for _, id := range unmanagedResources(plan, scope, store) {
+			rid, _ := ParseResourceID(id)
+			changes = append(changes, map[string]interface{}{
+				"resourceId": id,
+				"changeType": changeType,
+				"before":     currentResource(id, rid.Type(), store),
+			})
+		}
+	}
+	return changes
+}
+
+// currentResource returns the ARM view of an existing resource group, VM or generic resource
+func currentResource(id, resourceType string, store StoreInterface) map[string]interface{} {
+	if strings.EqualFold(resourceType, "Microsoft.Resources/resourceGroups") {
+		parts := strings.Split(strings.Trim(id, "/"), "/")
+		if rg := findResourceGroup(parts[1], parts[len(parts)-1], store); rg != nil {
+			return convertResourceGroupToARMFormat(rg)
+		}
+		return nil
+	}
+	if vm := findVM(id, store); vm != nil {
+		return convertVMToARMFormat(vm)
+	}
+	if rs, ok := store.(ResourceStore); ok {
+		if r := findResource(id, rs); r != nil {
+			return convertResourceToARMFormat(r, true)
+		}
+	}
+	return nil
+}
+
+// isUnchanged reports whether every field a template sets already has that value.
+// Properties are compared as subsets, since existing resources carry read-only and default ones.
+func isUnchanged(before, after map[string]interface{}) bool {
+	for k, v := range after {
+		switch k {
+		case "id", "name", "type":
+			continue
+		case "location":
+			if NormalizeLocation(fmt.Sprint(v)) != NormalizeLocation(fmt.Sprint(before[k])) {
+				return false
+			}
+		case "properties":
+			props, _ := v.(map[string]interface{})
+			current, _ := before[k].(map[string]interface{})
+			if !jsonSubset(props, current) {
+				return false
+			}
+		default:
+			if !jsonEqual(v, before[k]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// jsonEqual compares two values by their JSON encoding, so that typed maps
+// compare equal to the decoded form of the same JSON
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(normalizeJSON(a), normalizeJSON(b))
+}
+
+// jsonSubset reports whether every field set in want has the same value in have,
+// comparing objects field by field and arrays element by element
+func jsonSubset(want, have interface{}) bool {
+	want, have = normalizeJSON(want), normalizeJSON(have)
+	switch w := want.(type) {
+	case map[string]interface{}:
+		h, ok := have.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range w {
+			if !jsonSubset(v, h[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		h, ok := have.([]interface{})
+		if !ok || len(h) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !jsonSubset(w[i], h[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(want, have)
+}
+
+// normalizeJSON returns the decoded form of a value's JSON encoding, or the value itself
+// when it cannot be encoded
+func normalizeJSON(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// asARMError converts a mapper error to an ARM error, treating plain errors as internal failures
+func asARMError(err error) *ARMError {
+	if armErr, ok := err.(*ARMError); ok {
+		return armErr
+	}
+	return &ARMError{StatusCode: http.StatusInternalServerError, Code: "InternalServerError", Message: err.Error()}
+}
+
+// tagStrings converts decoded JSON tags to a string map
+func tagStrings(v interface{}) map[string]string {
+	tags := map[string]string{}
+	if m, ok := v.(map[string]interface{}); ok {
+		for k, val := range m {
+			tags[k] = fmt.Sprint(val)
+		}
+	}
+	return tags
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// newGUID returns a random identifier in GUID format
+func newGUID() string {
+	id := newOperationID()
+	return id[0:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:32]
+}
@@ -0,0 +1,438 @@
+package mappers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Managed disks and snapshots are kept in the generic resource store, like network resources.
+// A VM's OS and data disks that have no resource of their own are served from the VM, the way
+// Azure creates managed disks along with a VM. Whether a disk is attached is derived from the
+// VMs referencing it, so disk state cannot drift from the VM model.
+// See https://learn.microsoft.com/rest/api/compute/disks
+
+const (
+	diskType     = "Microsoft.Compute/disks"
+	snapshotType = "Microsoft.Compute/snapshots"
+
+	defaultDiskSKU = "Standard_LRS"
+
+	diskStateAttached   = "Attached"
+	diskStateUnattached = "Unattached"
+	diskStateActiveSAS  = "ActiveSAS"
+)
+
+// diskSKUs are the storage types a managed disk can have
+var diskSKUs = []string{"Standard_LRS", "Premium_LRS", "StandardSSD_LRS", "UltraSSD_LRS", "Premium_ZRS", "StandardSSD_ZRS", "PremiumV2_LRS"}
+
+// snapshotSKUs are the storage types a snapshot can have
+var snapshotSKUs = []string{"Standard_LRS", "Premium_LRS", "Standard_ZRS"}
+
+// diskCreateOptions are the creation options each type accepts
+var diskCreateOptions = map[string][]string{
+	strings.ToLower(diskType):     {"Empty", "Copy", "CopyStart", "FromImage", "Import", "Upload"},
+	strings.ToLower(snapshotType): {"Copy", "CopyStart", "Import"},
+}
+
+// diskAccessRequest is the body of Disks_GrantAccess and Snapshots_GrantAccess
+type diskAccessRequest struct {
+	Access            string `json:"access"`
+	DurationInSeconds int    `json:"durationInSeconds"`
+}
+
+// isDiskResource reports whether a resource ID is a managed disk or snapshot
+func isDiskResource(rid *ResourceID) bool {
+	return strings.EqualFold(rid.Type(), diskType) || strings.EqualFold(rid.Type(), snapshotType)
+}
+
+// putDiskResource validates a disk or snapshot, fills in what the compute provider computes,
+// and stores it
+func putDiskResource(rid *ResourceID, resource map[string]interface{}, rs ResourceStore, store StoreInterface) error {
+	props, _ := resource["properties"].(map[string]interface{})
+	if props == nil {
+		props = map[string]interface{}{}
+		resource["properties"] = props
+	}
+	var existingProps map[string]interface{}
+	if existing := findDisk(rid.String(), rs, store); existing != nil {
+		existingProps, _ = existing["properties"].(map[string]interface{})
+	}
+
+	// The creation data cannot change, so updates may leave it out
+	creation, _ := props["creationData"].(map[string]interface{})
+	if creation == nil && existingProps != nil {
+		creation, _ = existingProps["creationData"].(map[string]interface{})
+	}
+	if creation == nil {
+		return invalidComputeParameter("creationData", "Required parameter 'creationData' is missing (null).")
+	}
+	props["creationData"] = creation
+
+	option := ""
+	allowed := diskCreateOptions[strings.ToLower(rid.Type())]
+	for _, o := range allowed {
+		if s, _ := creation["createOption"].(string); strings.EqualFold(o, s) {
+			option = o
+		}
+	}
+	if option == "" {
+		return invalidComputeParameter("creationData.createOption",
+			fmt.Sprintf("The value '%v' of parameter 'creationData.createOption' is not allowed. Allowed values are %s.", creation["createOption"], strings.Join(allowed, ", ")))
+	}
+	creation["createOption"] = option
+
+	size := intValue(props["diskSizeGB"])
+	switch option {
+	case "Empty":
+		if size <= 0 {
+			return invalidComputeParameter("diskSizeGB", "Required parameter 'diskSizeGB' is missing (null).")
+		}
+	case "Copy", "CopyStart":
+		sourceID, _ := creation["sourceResourceId"].(string)
+		source := findDisk(sourceID, rs, store)
+		if source == nil {
+			return &ARMError{
+				StatusCode: http.StatusNotFound,
+				Code:       "NotFound",
+				Target:     "creationData.sourceResourceId",
+				Message:    fmt.Sprintf("Source resource '%s' was not found.", sourceID),
+			}
+		}
+		sourceProps, _ := source["properties"].(map[string]interface{})
+		sourceSize := intValue(sourceProps["diskSizeGB"])
+		if size == 0 {
+			size = sourceSize
+		}
+		if size < sourceSize {
+			return invalidComputeParameter("diskSizeGB",
+				fmt.Sprintf("The specified disk size %d GB is smaller than the size %d GB of the source '%s'.", size, sourceSize, sourceID))
+		}
+		if osType, ok := sourceProps["osType"]; ok {
+			setDefault(props, "osType", osType)
+		}
+		creation["sourceUniqueId"] = sourceProps["uniqueId"]
+	case "FromImage":
+		if creation["imageReference"] == nil && creation["galleryImageReference"] == nil {
+			return invalidComputeParameter("creationData.imageReference", "Required parameter 'imageReference' is missing (null).")
+		}
+		if size == 0 {
+			size = defaultLinuxOSDiskSizeGB
+		}
+	case "Import":
+		if s, _ := creation["sourceUri"].(string); s == "" {
+			return invalidComputeParameter("creationData.sourceUri", "Required parameter 'sourceUri' is missing (null).")
+		}
+	case "Upload":
+		if bytes := intValue(creation["uploadSizeBytes"]); size == 0 && bytes > 0 {
+			size = (bytes + 1<<30 - 1) >> 30
+		}
+	}
+	if size <= 0 {
+		return invalidComputeParameter("diskSizeGB", "Required parameter 'diskSizeGB' is missing (null).")
+	}
+	if existingSize := intValue(existingProps["diskSizeGB"]); size < existingSize {
+		return invalidComputeParameter("diskSizeGB",
+			fmt.Sprintf("Disk '%s' cannot be resized from %d GB to %d GB. Disks can only be resized to a larger size.", rid.Name(), existingSize, size))
+	}
+	props["diskSizeGB"] = size
+	props["diskSizeBytes"] = int64(size) << 30
+
+	skus := diskSKUs
+	if strings.EqualFold(rid.Type(), snapshotType) {
+		skus = snapshotSKUs
+		setDefault(props, "incremental", false)
+	}
+	sku, _ := resource["sku"].(map[string]interface{})
+	if sku == nil {
+		sku = map[string]interface{}{"name": defaultDiskSKU}
+		resource["sku"] = sku
+	}
+	name := ""
+	for _, s := range skus {
+		if strings.EqualFold(s, fmt.Sprint(sku["name"])) {
+			name = s
+		}
+	}
+	if name == "" {
+		return invalidComputeParameter("sku.name",
+			fmt.Sprintf("The value '%v' of parameter 'sku.name' is not allowed. Allowed values are %s.", sku["name"], strings.Join(skus, ", ")))
+	}
+	sku["name"] = name
+	sku["tier"] = "Standard"
+	if strings.HasPrefix(name, "Premium") {
+		sku["tier"] = "Premium"
+	}
+
+	props["uniqueId"] = deterministicGUID(rid.String())
+	props["timeCreated"] = time.Now().UTC().Format(time.RFC3339Nano)
+	if created, ok := existingProps["timeCreated"]; ok {
+		props["timeCreated"] = created
+	}
+	props["provisioningState"] = "Succeeded"
+	setDefault(props, "encryption", map[string]interface{}{"type": "EncryptionAtRestWithPlatformKey"})
+	setDefault(props, "networkAccessPolicy", "AllowAll")
+	setDefault(props, "publicNetworkAccess", "Enabled")
+
+	// Disk state and the owning VM are derived on read; only an export grant is kept
+	delete(props, "diskState")
+	if existingProps["diskState"] == diskStateActiveSAS {
+		props["diskState"] = diskStateActiveSAS
+	}
+	delete(resource, "managedBy")
+	return rs.PutResource(resource)
+}
+
+// checkDiskDelete refuses to delete a disk that is attached to a VM or being exported
+func checkDiskDelete(rid *ResourceID, rs ResourceStore, store StoreInterface) error {
+	if vmID := diskAttachments(store)[strings.ToLower(rid.String())]; vmID != "" {
+		return computeOperationNotAllowed(fmt.Sprintf("Disk %s is attached to VM %s.", rid.Name(), vmID))
+	}
+	if disk := findResource(rid.String(), rs); disk != nil {
+		if props, _ := disk["properties"].(map[string]interface{}); props["diskState"] == diskStateActiveSAS {
+			kind := "Disk"
+			if strings.EqualFold(rid.Type(), snapshotType) {
+				kind = "Snapshot"
+			}
+			return computeOperationNotAllowed(fmt.Sprintf("%s %s has an active shared access signature. Revoke access with endGetAccess before deleting it.", kind, rid.Name()))
+		}
+	}
+	return nil
+}
+
+// diskView adds the disk state and the VM using a disk to the ARM view of a disk or snapshot
+func diskView(armResource map[string]interface{}, store StoreInterface) map[string]interface{} {
+	id, _ := armResource["id"].(string)
+	props, _ := armResource["properties"].(map[string]interface{})
+	if props == nil {
+		props = map[string]interface{}{}
+		armResource["properties"] = props
+	}
+	vmID := diskAttachments(store)[strings.ToLower(id)]
+	if vmID != "" {
+		armResource["managedBy"] = vmID
+	}
+	switch {
+	case props["diskState"] == diskStateActiveSAS:
+	case vmID != "":
+		props["diskState"] = diskStateAttached
+	default:
+		props["diskState"] = diskStateUnattached
+	}
+	return armResource
+}
+
+// mapDisksResponse handles the disk and snapshot operations that are not plain resource CRUD:
+// listing and granting or revoking export access
+func mapDisksResponse(req *Request, store StoreInterface) (interface{}, error) {
+	rs, ok := store.(ResourceStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support generic resources")
+	}
+	prefix, action, _ := strings.Cut(req.OperationID, "_")
+	resourceType := diskType
+	nameParam := "diskName"
+	if prefix == "Snapshots" {
+		resourceType = snapshotType
+		nameParam = "snapshotName"
+	}
+	subscriptionID := req.Params["subscriptionId"]
+	resourceGroup := req.Params["resourceGroupName"]
+	if resourceGroup != "" && findResourceGroup(subscriptionID, resourceGroup, store) == nil {
+		return nil, resourceGroupNotFound(resourceGroup)
+	}
+
+	switch action {
+	case "List", "ListByResourceGroup":
+		if req.Principal != nil && resourceGroup != "" && !req.Principal.HasPermission(subscriptionID, resourceGroup, "read") {
+			return nil, authorizationFailed(resourceType+"/read", "/subscriptions/"+subscriptionID+"/resourceGroups/"+resourceGroup)
+		}
+		items := resourcesOfType(resourceType, rs)
+		if resourceType == diskType {
+			items = append(items, vmDiskResources(rs, store)...)
+		}
+		value := []map[string]interface{}{}
+		for _, item := range items {
+			rid, err := ParseResourceID(fmt.Sprint(item["id"]))
+			if err != nil || !strings.EqualFold(rid.SubscriptionID, subscriptionID) {
+				continue
+			}
+			if resourceGroup != "" && !strings.EqualFold(rid.ResourceGroup, resourceGroup) {
+				continue
+			}
+			if req.Principal != nil && !req.Principal.HasPermission(rid.SubscriptionID, rid.ResourceGroup, "read") {
+				continue
+			}
+			value = append(value, diskView(convertResourceToARMFormat(item, true), store))
+		}
+		sort.Slice(value, func(i, j int) bool {
+			return strings.ToLower(value[i]["id"].(string)) < strings.ToLower(value[j]["id"].(string))
+		})
+		list := []interface{}{}
+		for _, v := range value {
+			list = append(list, v)
+		}
+		return map[string]interface{}{"value": list}, nil
+
+	case "GrantAccess", "RevokeAccess":
+		rid, err := ParseResourceID(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s",
+			subscriptionID, resourceGroup, resourceType, req.Params[nameParam]))
+		if err != nil {
+			return nil, err
+		}
+		disk := findDisk(rid.String(), rs, store)
+		if disk == nil {
+			return nil, resourceNotFound(resourceType, rid.Name(), resourceGroup)
+		}
+		verb := "beginGetAccess"
+		if action == "RevokeAccess" {
+			verb = "endGetAccess"
+		}
+		if req.Principal != nil && !req.Principal.HasPermission(subscriptionID, resourceGroup, "write") {
+			return nil, authorizationFailed(resourceType+"/"+verb+"/action", rid.String())
+		}
+		props, _ := disk["properties"].(map[string]interface{})
+		if props == nil {
+			props = map[string]interface{}{}
+			disk["properties"] = props
+		}
+
+		if action == "RevokeAccess" {
+			if props["diskState"] == diskStateActiveSAS {
+				delete(props, "diskState")
+				if err := rs.PutResource(disk); err != nil {
+					return nil, err
+				}
+			}
+			return acceptOperation(req, store, subscriptionID, &Operation{StatusCode: http.StatusOK})
+		}
+
+		var body diskAccessRequest
+		if err := json.Unmarshal(req.Body, &body); err != nil {
+			return nil, invalidRequestContent(err)
+		}
+		permission := ""
+		switch strings.ToLower(body.Access) {
+		case "read":
+			permission = "r"
+		case "write":
+			permission = "rw"
+		default:
+			return nil, invalidComputeParameter("access",
+				fmt.Sprintf("The value '%s' of parameter 'access' is not allowed. Allowed values are Read, Write.", body.Access))
+		}
+		if body.DurationInSeconds <= 0 {
+			return nil, invalidComputeParameter("durationInSeconds", "Required parameter 'durationInSeconds' must be a positive number of seconds.")
+		}
+		if vmID := diskAttachments(store)[strings.ToLower(rid.String())]; vmID != "" {
+			if vm := findVM(vmID, store); vm != nil && vm["status"] == "running" {
+				return nil, computeOperationNotAllowed(fmt.Sprintf("Cannot get access to disk %s because it is attached to running VM %s. Stop and deallocate the VM first.", rid.Name(), vmID))
+			}
+		}
+		props["diskState"] = diskStateActiveSAS
+		if err := rs.PutResource(disk); err != nil {
+			return nil, err
+		}
+		expiry := time.Now().UTC().Add(time.Duration(body.DurationInSeconds) * time.Second)
+		sas := fmt.Sprintf("https://md-%s.blob.core.windows.net/%s/abcd?sv=2018-03-28&sr=b&se=%s&sp=%s&sig=%s",
+			deterministicGUID(rid.String())[:12], strings.ReplaceAll(deterministicGUID(rid.String()+"/blob"), "-", "")[:12],
+			url.QueryEscape(expiry.Format(time.RFC3339)), permission, newOperationID())
+		return acceptOperation(req, store, subscriptionID, &Operation{
+			StatusCode: http.StatusOK,
+			Body:       map[string]interface{}{"accessSAS": sas},
+		})
+	}
+	return nil, fmt.Errorf("unsupported operation: %s", req.OperationID)
+}
+
+// findDisk looks a disk or snapshot up by ID, falling back to the disks of VMs
+func findDisk(id string, rs ResourceStore, store StoreInterface) map[string]interface{} {
+	if disk := findResource(id, rs); disk != nil {
+		return disk
+	}
+	for _, disk := range vmDiskResources(rs, store) {
+		if strings.EqualFold(fmt.Sprint(disk["id"]), id) {
+			return disk
+		}
+	}
+	return nil
+}
+
+// vmDiskResources returns the OS and data disks of VMs that have no stored resource of their own
+func vmDiskResources(rs ResourceStore, store StoreInterface) []map[string]interface{} {
+	disks := []map[string]interface{}{}
+	for _, vm := range store.GetVMs() {
+		vmMap, ok := vm.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		storage := vmStorageProfile(vmMap)
+		vmDisks := []map[string]interface{}{storage["osDisk"].(map[string]interface{})}
+		for _, d := range storage["dataDisks"].([]interface{}) {
+			vmDisks = append(vmDisks, d.(map[string]interface{}))
+		}
+		for _, d := range vmDisks {
+			managed, _ := d["managedDisk"].(map[string]interface{})
+			id, _ := managed["id"].(string)
+			if findResource(id, rs) != nil {
+				continue
+			}
+			rid, err := ParseResourceID(id)
+			if err != nil {
+				continue
+			}
+			props := map[string]interface{}{
+				"diskSizeGB":        d["diskSizeGB"],
+				"diskSizeBytes":     int64(intValue(d["diskSizeGB"])) << 30,
+				"uniqueId":          deterministicGUID(id),
+				"creationData":      map[string]interface{}{"createOption": d["createOption"]},
+				"encryption":        map[string]interface{}{"type": "EncryptionAtRestWithPlatformKey"},
+				"provisioningState": "Succeeded",
+			}
+			if osType, ok := d["osType"]; ok {
+				props["osType"] = osType
+				if image, ok := storage["imageReference"].(map[string]interface{}); ok {
+					props["creationData"].(map[string]interface{})["imageReference"] = image
+				}
+			}
+			disk := map[string]interface{}{
+				"id":         rid.String(),
+				"name":       rid.Name(),
+				"type":       diskType,
+				"location":   NormalizeLocation(fmt.Sprint(vmMap["location"])),
+				"sku":        map[string]interface{}{"name": managed["storageAccountType"]},
+				"properties": props,
+			}
+			if zones, ok := vmMap["zones"]; ok && zones != nil {
+				disk["zones"] = zones
+			}
+			disks = append(disks, disk)
+		}
+	}
+	return disks
+}
+
+// diskAttachments maps the lowercased IDs of attached disks to the ID of their VM
+func diskAttachments(store StoreInterface) map[string]string {
+	attached := map[string]string{}
+	for _, vm := range store.GetVMs() {
+		vmMap, ok := vm.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		vmID, _ := vmMap["id"].(string)
+		storage := vmStorageProfile(vmMap)
+		disks := append([]interface{}{storage["osDisk"]}, storage["dataDisks"].([]interface{})...)
+		for _, d := range disks {
+			managed, _ := d.(map[string]interface{})["managedDisk"].(map[string]interface{})
+			if id, _ := managed["id"].(string); id != "" {
+				attached[strings.ToLower(id)] = vmID
+			}
+		}
+	}
+	return attached
+}
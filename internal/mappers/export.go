@@ -0,0 +1,382 @@
+package mappers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	deploymentTemplateSchema = "https://schema.management.azure.com/schemas/2019-04-01/deploymentTemplate.json#"
+
+	// Mockzure does not record the API version a resource was written with, so exported
+	// templates use the version Mockzure serves that resource type with
+	resourcesAPIVersion       = "2021-04-01"
+	virtualMachinesAPIVersion = "2023-03-01"
+)
+
+// exportRequest is the body of ResourceGroups_ExportTemplate
+type exportRequest struct {
+	Resources []string `json:"resources"`
+	Options   string   `json:"options"`
+}
+
+// exportOptions are the parsed CSV options of an export request
+type exportOptions struct {
+	includeDefaults bool
+	includeComments bool
+	skipNames       bool
+	skipAll         bool
+}
+
+// parameterNameChars matches the characters ARM replaces when naming template parameters
+var parameterNameChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// mapExportTemplateResponse handles ResourceGroups_ExportTemplate.
+// It is long-running: the template is built up front and returned when the caller polls.
+func mapExportTemplateResponse(req *Request, store StoreInterface) (interface{}, error) {
+	rs, ok := store.(ResourceStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support generic resources")
+	}
+
+	subscriptionID := req.Params["subscriptionId"]
+	rgName := req.Params["resourceGroupName"]
+	rg := findResourceGroup(subscriptionID, rgName, store)
+	if rg == nil {
+		return nil, resourceGroupNotFound(rgName)
+	}
+	rgID, _ := rg["id"].(string)
+	if req.Principal != nil && !req.Principal.HasPermission(subscriptionID, rgName, "read") {
+		return nil, authorizationFailed("Microsoft.Resources/subscriptions/resourceGroups/exportTemplate/action", rgID)
+	}
+
+	var body exportRequest
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		return nil, invalidRequestContent(err)
+	}
+	opts, err := parseExportOptions(body.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect everything in the group, parents sorting before their nested resources
+	inGroup := []map[string]interface{}{}
+	for _, r := range rs.GetResources() {
+		if rMap, ok := r.(map[string]interface{}); ok {
+			if id, _ := rMap["id"].(string); hasPrefixFold(id, rgID+"/") {
+				inGroup = append(inGroup, templateResource(rMap))
+			}
+		}
+	}
+	for _, vm := range store.GetVMs() {
+		if vmMap, ok := vm.(map[string]interface{}); ok {
+			if id, _ := vmMap["id"].(string); hasPrefixFold(id, rgID+"/") {
+				inGroup = append(inGroup, templateVM(vmMap))
+			}
+		}
+	}
+	sort.Slice(inGroup, func(i, j int) bool {
+		return strings.ToLower(inGroup[i]["id"].(string)) < strings.ToLower(inGroup[j]["id"].(string))
+	})
+
+	selected, failures := selectExportResources(inGroup, body.Resources)
+	result := map[string]interface{}{
+		"template": buildTemplate(selected, opts),
+	}
+	if len(failures) > 0 {
+		result["error"] = (&ARMError{
+			Code:    "ExportTemplateCompletedWithErrors",
+			Message: "Export template operation completed with errors. Some resources were not exported. Please see details for more information.",
+			Details: failures,
+		}).detail()
+	}
+	return acceptOperation(req, store, subscriptionID, &Operation{StatusCode: http.StatusOK, Body: result})
+}
+
+// parseExportOptions parses the CSV options string of an export request
+func parseExportOptions(options string) (exportOptions, error) {
+	var opts exportOptions
+	for _, option := range strings.Split(options, ",") {
+		switch strings.ToLower(strings.TrimSpace(option)) {
+		case "":
+		case "includeparameterdefaultvalue":
+			opts.includeDefaults = true
+		case "includecomments":
+			opts.includeComments = true
+		case "skipresourcenameparameterization":
+			opts.skipNames = true
+		case "skipallparameterization":
+			opts.skipAll = true
+		default:
+			return opts, &ARMError{
+				StatusCode: http.StatusBadRequest,
+				Code:       "InvalidExportTemplateOptions",
+				Message:    fmt.Sprintf("The export template option '%s' is not supported.", strings.TrimSpace(option)),
+			}
+		}
+	}
+	return opts, nil
+}
+
+// selectExportResources applies the resource filter of an export request.
+// "*" selects everything; other entries are resource IDs, and each missing one is a failure.
+func selectExportResources(resources []map[string]interface{}, filter []string) ([]map[string]interface{}, []*ARMError) {
+	for _, id := range filter {
+		if id == "*" {
+			return resources, nil
+		}
+	}
+	selected := []map[string]interface{}{}
+	failures := []*ARMError{}
+	for _, id := range filter {
+		found := false
+		for _, r := range resources {
+			if strings.EqualFold(r["id"].(string), id) {
+				selected = append(selected, r)
+				found = true
+				break
+			}
+		}
+		if !found {
+			failures = append(failures, &ARMError{
+				Code:    "ResourceNotFound",
+				Target:  id,
+				Message: fmt.Sprintf("The resource '%s' could not be found in the resource group.", id),
+			})
+		}
+	}
+	return selected, failures
+}
+
+// templateResource returns the deployable fields of a generic resource
+func templateResource(resource map[string]interface{}) map[string]interface{} {
+	entry := map[string]interface{}{
+		"id":         resource["id"],
+		"type":       resource["type"],
+		"apiVersion": resourcesAPIVersion,
+	}
+	for _, field := range []string{"location", "kind", "sku", "plan", "identity", "zones", "extendedLocation", "tags"} {
+		if v, ok := resource[field]; ok && v != nil {
+			entry[field] = v
+		}
+	}
+	properties := map[string]interface{}{}
+	if p, ok := resource["properties"].(map[string]interface{}); ok {
+		for k, v := range p {
+			if k != "provisioningState" {
+				properties[k] = v
+			}
+		}
+	}
+	entry["properties"] = properties
+	return entry
+}
+
+// templateVM returns the deployable fields of a VM
+func templateVM(vm map[string]interface{}) map[string]interface{} {
+	entry := map[string]interface{}{
+		"id":         vm["id"],
+		"type":       "Microsoft.Compute/virtualMachines",
+		"apiVersion": virtualMachinesAPIVersion,
+		"location":   vm["location"],
+		"properties": map[string]interface{}{
+			"hardwareProfile": map[string]interface{}{
+				"vmSize": vm["vmSize"],
+			},
+			"storageProfile": map[string]interface{}{
+				"osDisk": map[string]interface{}{
+					"osType": vm["osType"],
+				},
+			},
+		},
+	}
+	if tags, ok := vm["tags"].(map[string]string); ok && len(tags) > 0 {
+		entry["tags"] = tags
+	}
+	if zones, ok := vm["zones"]; ok && zones != nil {
+		entry["zones"] = zones
+	}
+
+	// Keep the settings a redeployment needs; managed disk IDs and OS defaults are derived again
+	properties := entry["properties"].(map[string]interface{})
+	storage := vmStorageProfile(vm)
+	osDisk := properties["storageProfile"].(map[string]interface{})["osDisk"].(map[string]interface{})
+	for k, v := range templateDisk(storage["osDisk"].(map[string]interface{})) {
+		osDisk[k] = v
+	}
+	if image, ok := storage["imageReference"].(map[string]interface{}); ok {
+		delete(image, "exactVersion")
+		properties["storageProfile"].(map[string]interface{})["imageReference"] = image
+	}
+	dataDisks := []interface{}{}
+	for _, d := range storage["dataDisks"].([]interface{}) {
+		disk := templateDisk(d.(map[string]interface{}))
+		disk["lun"] = d.(map[string]interface{})["lun"]
+		dataDisks = append(dataDisks, disk)
+	}
+	properties["storageProfile"].(map[string]interface{})["dataDisks"] = dataDisks
+	osProfile := vmOSProfile(vm)
+	properties["osProfile"] = map[string]interface{}{
+		"computerName":  osProfile["computerName"],
+		"adminUsername": osProfile["adminUsername"],
+	}
+	network := vmNetworkProfile(vm)
+	if nics := network["networkInterfaces"].([]interface{}); len(nics) > 0 {
+		properties["networkProfile"] = network
+	}
+	return entry
+}
+
+// templateDisk returns the deployable fields of a VM disk, without its managed disk ID
+func templateDisk(disk map[string]interface{}) map[string]interface{} {
+	managed, _ := disk["managedDisk"].(map[string]interface{})
+	return map[string]interface{}{
+		"name":         disk["name"],
+		"createOption": disk["createOption"],
+		"caching":      disk["caching"],
+		"diskSizeGB":   disk["diskSizeGB"],
+		"managedDisk":  map[string]interface{}{"storageAccountType": managed["storageAccountType"]},
+	}
+}
+
+// buildTemplate builds an ARM deployment template from template resources.
+// Top-level resource names are parameterized unless the options say otherwise, and
+// nested resources depend on their parent when the parent is exported too.
+func buildTemplate(resources []map[string]interface{}, opts exportOptions) map[string]interface{} {
+	parameters := map[string]interface{}{}
+	exported := map[string]bool{}
+	for _, r := range resources {
+		exported[strings.ToLower(r["id"].(string))] = true
+	}
+
+	// rootName returns the template expression for the name of a resource's top-level ancestor
+	rootName := func(rid *ResourceID) string {
+		root := rid
+		for root.Parent() != nil {
+			root = root.Parent()
+		}
+		if opts.skipAll || opts.skipNames {
+			return "'" + strings.ReplaceAll(root.Name(), "'", "''") + "'"
+		}
+		parameterName := templateParameterName(root)
+		parameter := map[string]interface{}{"type": "String"}
+		if opts.includeDefaults {
+			parameter["defaultValue"] = root.Name()
+		}
+		parameters[parameterName] = parameter
+		return fmt.Sprintf("parameters('%s')", parameterName)
+	}
+
+	// References between exported resources become resourceId() expressions, so that
+	// they follow the template to wherever it is deployed
+	references := map[string]string{}
+	for _, r := range resources {
+		if rid, err := ParseResourceID(r["id"].(string)); err == nil {
+			references[strings.ToLower(rid.String())] = templateResourceIDExpr(rid, rootName(rid))
+		}
+	}
+
+	templateResources := []interface{}{}
+	for _, r := range resources {
+		id := r["id"].(string)
+		rid, err := ParseResourceID(id)
+		if err != nil {
+			continue
+		}
+
+		entry := map[string]interface{}{}
+		for k, v := range r {
+			if k != "id" {
+				entry[k] = v
+			}
+		}
+		root := rootName(rid)
+		nested := strings.Join(rid.Names[1:], "/")
+		switch {
+		case opts.skipAll || opts.skipNames:
+			entry["name"] = strings.Join(rid.Names, "/")
+		case nested == "":
+			entry["name"] = "[" + root + "]"
+		default:
+			entry["name"] = fmt.Sprintf("[concat(%s, '/%s')]", root, strings.ReplaceAll(nested, "'", "''"))
+		}
+		dependsOn := []string{}
+		if parent := rid.Parent(); parent != nil && exported[strings.ToLower(parent.String())] {
+			dependsOn = append(dependsOn, templateResourceIDExpr(parent, root))
+		}
+		if properties, ok := entry["properties"]; ok {
+			used := map[string]bool{strings.ToLower(id): true}
+			entry["properties"] = templateReferences(properties, references, used, &dependsOn)
+		}
+		if len(dependsOn) > 0 {
+			entry["dependsOn"] = dependsOn
+		}
+		if opts.includeComments {
+			entry["comments"] = fmt.Sprintf("Generalized from resource: '%s'.", id)
+		}
+		templateResources = append(templateResources, entry)
+	}
+
+	return map[string]interface{}{
+		"$schema":        deploymentTemplateSchema,
+		"contentVersion": "1.0.0.0",
+		"parameters":     parameters,
+		"variables":      map[string]interface{}{},
+		"resources":      templateResources,
+	}
+}
+
+// templateReferences returns a copy of a value with the IDs of exported resources replaced by
+// their resourceId() expressions, adding each referenced resource to dependsOn once
+func templateReferences(v interface{}, references map[string]string, used map[string]bool, dependsOn *[]string) interface{} {
+	switch value := v.(type) {
+	case string:
+		expr, ok := references[strings.ToLower(value)]
+		if !ok {
+			return value
+		}
+		if !used[strings.ToLower(value)] {
+			used[strings.ToLower(value)] = true
+			*dependsOn = append(*dependsOn, expr)
+		}
+		return expr
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for k, item := range value {
+			result[k] = templateReferences(item, references, used, dependsOn)
+		}
+		return result
+	case []interface{}:
+		result := []interface{}{}
+		for _, item := range value {
+			result = append(result, templateReferences(item, references, used, dependsOn))
+		}
+		return result
+	}
+	return v
+}
+
+// templateParameterName returns the parameter ARM generates for a resource name,
+// such as virtualMachines_vm_web_01_name
+func templateParameterName(rid *ResourceID) string {
+	return parameterNameChars.ReplaceAllString(rid.Types[len(rid.Types)-1]+"_"+rid.Name(), "_") + "_name"
+}
+
+// templateResourceIDExpr returns a resourceId() expression for a resource in the template,
+// given the expression for its top-level name
+func templateResourceIDExpr(rid *ResourceID, root string) string {
+	args := []string{"'" + rid.Type() + "'", root}
+	for _, name := range rid.Names[1:] {
+		args = append(args, "'"+strings.ReplaceAll(name, "'", "''")+"'")
+	}
+	return "[resourceId(" + strings.Join(args, ", ") + ")]"
+}
+
+// hasPrefixFold reports whether s begins with prefix, ignoring case
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -24,8 +24,7 @@ func MapGraphResponse(operationID, pathPattern, method string, params map[string
 		return mapServicePrincipalsResponse(operationID, method, params, store)
 	}
 
-	// Default: return empty response
-	return map[string]interface{}{"value": []interface{}{}}, nil
+	return nil, ErrNotMapped
 }
 
 // mapUsersResponse handles Microsoft Graph users operations
@@ -0,0 +1,763 @@
+package mappers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements the subset of the Kusto Query Language that Resource Graph
+// queries commonly use: a table followed by where, project, extend, summarize,
+// order by and take/limit operators.
+// See https://learn.microsoft.com/azure/governance/resource-graph/concepts/query-language
+
+// kqlTable is a query result: ordered columns and one map per row
+type kqlTable struct {
+	columns []string
+	rows    []map[string]interface{}
+}
+
+// kqlExpr evaluates an expression against a row
+type kqlExpr func(row map[string]interface{}) interface{}
+
+// kqlToken is a lexical token of a query
+type kqlToken struct {
+	kind string // "ident", "string", "number", "op" or "eof"
+	text string
+	pos  int
+}
+
+// kqlError is a query that cannot be parsed or run
+type kqlError struct {
+	pos     int
+	message string
+}
+
+func (e *kqlError) Error() string {
+	return fmt.Sprintf("%s (at position %d)", e.message, e.pos)
+}
+
+// kqlOperators are the punctuation tokens, longest first
+var kqlOperators = []string{"==", "!=", "=~", "!~", "<=", ">=", "|", ",", "(", ")", "[", "]", ".", "=", "<", ">"}
+
+// tokenizeKQL splits a query into tokens
+func tokenizeKQL(query string) ([]kqlToken, error) {
+	tokens := []kqlToken{}
+	i := 0
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '\'' || c == '"':
+			start := i
+			var b strings.Builder
+			i++
+			for i < len(query) && query[i] != c {
+				if query[i] == '\\' && i+1 < len(query) {
+					i++
+				}
+				b.WriteByte(query[i])
+				i++
+			}
+			if i >= len(query) {
+				return nil, &kqlError{start, "unterminated string literal"}
+			}
+			i++
+			tokens = append(tokens, kqlToken{"string", b.String(), start})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(query) && (query[i] == '.' || (query[i] >= '0' && query[i] <= '9')) {
+				i++
+			}
+			tokens = append(tokens, kqlToken{"number", query[start:i], start})
+		case c == '_' || c == '!' && i+1 < len(query) && isLetter(query[i+1]) || isLetter(c):
+			// Identifiers include the negated word operators such as !contains and !in
+			start := i
+			i++
+			for i < len(query) && (query[i] == '_' || isLetter(query[i]) || (query[i] >= '0' && query[i] <= '9')) {
+				i++
+			}
+			if i < len(query) && query[i] == '~' {
+				i++ // in~, !in~
+			}
+			tokens = append(tokens, kqlToken{"ident", query[start:i], start})
+		default:
+			matched := false
+			for _, op := range kqlOperators {
+				if strings.HasPrefix(query[i:], op) {
+					tokens = append(tokens, kqlToken{"op", op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &kqlError{i, fmt.Sprintf("unexpected character '%c'", c)}
+			}
+		}
+	}
+	return append(tokens, kqlToken{"eof", "", len(query)}), nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// kqlParser parses a query and applies its operators to the input tables
+type kqlParser struct {
+	tokens []kqlToken
+	pos    int
+}
+
+func (p *kqlParser) peek() kqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *kqlParser) next() kqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword reports whether the next token is the given keyword, ignoring case
+func (p *kqlParser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == "ident" && strings.EqualFold(t.text, keyword)
+}
+
+// isOp reports whether the next token is the given punctuation
+func (p *kqlParser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == "op" && t.text == op
+}
+
+func (p *kqlParser) expectOp(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("expected '%s'", op)
+	}
+	p.next()
+	return nil
+}
+
+func (p *kqlParser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	message := fmt.Sprintf(format, args...)
+	if t.kind == "eof" {
+		return &kqlError{t.pos, message + " but the query ended"}
+	}
+	return &kqlError{t.pos, fmt.Sprintf("%s, found '%s'", message, t.text)}
+}
+
+// runKQL runs a query against the named tables
+func runKQL(query string, tables map[string]*kqlTable) (*kqlTable, error) {
+	tokens, err := tokenizeKQL(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &kqlParser{tokens: tokens}
+
+	t := p.next()
+	if t.kind != "ident" {
+		return nil, &kqlError{t.pos, "expected a table name"}
+	}
+	var input *kqlTable
+	for name, table := range tables {
+		if strings.EqualFold(name, t.text) {
+			input = table
+		}
+	}
+	if input == nil {
+		return nil, &kqlError{t.pos, fmt.Sprintf("unknown table '%s'", t.text)}
+	}
+	result := &kqlTable{columns: append([]string{}, input.columns...), rows: input.rows}
+
+	for p.peek().kind != "eof" {
+		if err := p.expectOp("|"); err != nil {
+			return nil, err
+		}
+		operator := p.next()
+		switch strings.ToLower(operator.text) {
+		case "where":
+			result, err = p.where(result)
+		case "project":
+			result, err = p.project(result, false)
+		case "extend":
+			result, err = p.project(result, true)
+		case "summarize":
+			result, err = p.summarize(result)
+		case "order", "sort":
+			if !p.isKeyword("by") {
+				return nil, p.errorf("expected 'by'")
+			}
+			p.next()
+			result, err = p.orderBy(result)
+		case "take", "limit":
+			result, err = p.take(result)
+		default:
+			return nil, &kqlError{operator.pos, fmt.Sprintf("unsupported query operator '%s'", operator.text)}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// where keeps the rows matching a predicate
+func (p *kqlParser) where(input *kqlTable) (*kqlTable, error) {
+	predicate, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	result := &kqlTable{columns: input.columns}
+	for _, row := range input.rows {
+		if b, _ := predicate(row).(bool); b {
+			result.rows = append(result.rows, row)
+		}
+	}
+	return result, nil
+}
+
+// project keeps, renames and computes columns; extend adds computed columns to the existing ones
+func (p *kqlParser) project(input *kqlTable, extend bool) (*kqlTable, error) {
+	type column struct {
+		name string
+		expr kqlExpr
+	}
+	columns := []column{}
+	for {
+		name, expr, err := p.namedExpression(len(columns) + 1)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column{name, expr})
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+
+	result := &kqlTable{}
+	if extend {
+		result.columns = append(result.columns, input.columns...)
+	}
+	for _, c := range columns {
+		if !containsString(result.columns, c.name) {
+			result.columns = append(result.columns, c.name)
+		}
+	}
+	for _, row := range input.rows {
+		out := map[string]interface{}{}
+		if extend {
+			for k, v := range row {
+				out[k] = v
+			}
+		}
+		for _, c := range columns {
+			out[c.name] = c.expr(row)
+		}
+		result.rows = append(result.rows, out)
+	}
+	return result, nil
+}
+
+// namedExpression parses "name = expr" or an expression named after the column it reads
+func (p *kqlParser) namedExpression(index int) (string, kqlExpr, error) {
+	if p.peek().kind == "ident" && p.tokens[p.pos+1].kind == "op" && p.tokens[p.pos+1].text == "=" {
+		name := p.next().text
+		p.next()
+		expr, err := p.expression()
+		return name, expr, err
+	}
+	start := p.pos
+	expr, err := p.expression()
+	if err != nil {
+		return "", nil, err
+	}
+	// A column or dynamic path such as tags.env is named tags_env
+	parts := []string{}
+	for _, t := range p.tokens[start:p.pos] {
+		switch {
+		case t.kind == "ident" || t.kind == "string":
+			parts = append(parts, t.text)
+		case t.kind == "op" && (t.text == "." || t.text == "[" || t.text == "]"):
+		default:
+			return fmt.Sprintf("Column%d", index), expr, nil
+		}
+	}
+	return strings.Join(parts, "_"), expr, nil
+}
+
+// summarize groups rows by the "by" columns and counts each group
+func (p *kqlParser) summarize(input *kqlTable) (*kqlTable, error) {
+	countName := "count_"
+	if p.peek().kind == "ident" && p.tokens[p.pos+1].kind == "op" && p.tokens[p.pos+1].text == "=" {
+		countName = p.next().text
+		p.next()
+	}
+	if !p.isKeyword("count") {
+		return nil, p.errorf("only count() is supported in summarize")
+	}
+	p.next()
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		name string
+		expr kqlExpr
+	}
+	groups := []group{}
+	if p.isKeyword("by") {
+		p.next()
+		for {
+			name, expr, err := p.namedExpression(len(groups) + 1)
+			if err != nil {
+				return nil, err
+			}
+			groups = append(groups, group{name, expr})
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	result := &kqlTable{}
+	for _, g := range groups {
+		result.columns = append(result.columns, g.name)
+	}
+	result.columns = append(result.columns, countName)
+	byKey := map[string]map[string]interface{}{}
+	for _, row := range input.rows {
+		keyParts := []string{}
+		values := map[string]interface{}{}
+		for _, g := range groups {
+			v := g.expr(row)
+			values[g.name] = v
+			keyParts = append(keyParts, kqlString(v))
+		}
+		key := strings.Join(keyParts, "\x00")
+		out, ok := byKey[key]
+		if !ok {
+			out = values
+			out[countName] = float64(0)
+			byKey[key] = out
+			result.rows = append(result.rows, out)
+		}
+		out[countName] = out[countName].(float64) + 1
+	}
+	if len(groups) == 0 && len(result.rows) == 0 {
+		result.rows = append(result.rows, map[string]interface{}{countName: float64(0)})
+	}
+	return result, nil
+}
+
+// orderBy sorts rows; KQL sorts descending unless asc is given
+func (p *kqlParser) orderBy(input *kqlTable) (*kqlTable, error) {
+	type key struct {
+		expr kqlExpr
+		asc  bool
+	}
+	keys := []key{}
+	for {
+		expr, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		k := key{expr: expr}
+		if p.isKeyword("asc") || p.isKeyword("desc") {
+			k.asc = strings.EqualFold(p.next().text, "asc")
+		}
+		keys = append(keys, k)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+
+	rows := append([]map[string]interface{}{}, input.rows...)
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, k := range keys {
+			c := kqlCompare(k.expr(rows[i]), k.expr(rows[j]))
+			if c == 0 {
+				continue
+			}
+			if k.asc {
+				return c < 0
+			}
+			return c > 0
+		}
+		return false
+	})
+	return &kqlTable{columns: input.columns, rows: rows}, nil
+}
+
+// take keeps the first n rows
+func (p *kqlParser) take(input *kqlTable) (*kqlTable, error) {
+	t := p.next()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != "number" || err != nil || n < 0 {
+		return nil, &kqlError{t.pos, "expected a row count"}
+	}
+	rows := input.rows
+	if n < len(rows) {
+		rows = rows[:n]
+	}
+	return &kqlTable{columns: input.columns, rows: rows}, nil
+}
+
+// expression parses an or-expression
+func (p *kqlParser) expression() (kqlExpr, error) {
+	left, err := p.andExpression()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.andExpression()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(row map[string]interface{}) interface{} {
+			a, _ := l(row).(bool)
+			b, _ := right(row).(bool)
+			return a || b
+		}
+	}
+	return left, nil
+}
+
+func (p *kqlParser) andExpression() (kqlExpr, error) {
+	left, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(row map[string]interface{}) interface{} {
+			a, _ := l(row).(bool)
+			b, _ := right(row).(bool)
+			return a && b
+		}
+	}
+	return left, nil
+}
+
+// comparison parses a value optionally followed by a comparison or string operator
+func (p *kqlParser) comparison() (kqlExpr, error) {
+	left, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	op := strings.ToLower(t.text)
+	if t.kind == "op" {
+		switch op {
+		case "==", "!=", "=~", "!~", "<", ">", "<=", ">=":
+		default:
+			return left, nil
+		}
+	} else if t.kind == "ident" {
+		switch op {
+		case "contains", "!contains", "contains_cs", "!contains_cs", "startswith", "!startswith",
+			"endswith", "!endswith", "has", "!has":
+		case "in", "!in", "in~", "!in~":
+			p.next()
+			return p.inList(left, op)
+		default:
+			return left, nil
+		}
+	} else {
+		return left, nil
+	}
+	p.next()
+	right, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+
+	// != and !~ are the negations of == and =~; the word operators are negated by a leading !
+	base, negate := op, false
+	switch {
+	case op == "!=":
+		base, negate = "==", true
+	case op == "!~":
+		base, negate = "=~", true
+	case strings.HasPrefix(op, "!"):
+		base, negate = op[1:], true
+	}
+	test := func(a, b interface{}) bool {
+		as, bs := kqlString(a), kqlString(b)
+		switch base {
+		case "==":
+			return kqlCompare(a, b) == 0
+		case "=~":
+			return strings.EqualFold(as, bs)
+		case "<":
+			return kqlCompare(a, b) < 0
+		case ">":
+			return kqlCompare(a, b) > 0
+		case "<=":
+			return kqlCompare(a, b) <= 0
+		case ">=":
+			return kqlCompare(a, b) >= 0
+		case "contains":
+			return strings.Contains(strings.ToLower(as), strings.ToLower(bs))
+		case "contains_cs":
+			return strings.Contains(as, bs)
+		case "startswith":
+			return strings.HasPrefix(strings.ToLower(as), strings.ToLower(bs))
+		case "endswith":
+			return strings.HasSuffix(strings.ToLower(as), strings.ToLower(bs))
+		case "has":
+			for _, term := range strings.FieldsFunc(as, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+				if strings.EqualFold(term, bs) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return func(row map[string]interface{}) interface{} {
+		return test(left(row), right(row)) != negate
+	}, nil
+}
+
+// inList parses the parenthesized list of an in operator
+func (p *kqlParser) inList(left kqlExpr, op string) (kqlExpr, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	values := []kqlExpr{}
+	for !p.isOp(")") {
+		v, err := p.postfix()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	negate := strings.HasPrefix(op, "!")
+	fold := strings.HasSuffix(op, "~")
+	return func(row map[string]interface{}) interface{} {
+		a := left(row)
+		for _, v := range values {
+			b := v(row)
+			if (fold && strings.EqualFold(kqlString(a), kqlString(b))) || (!fold && kqlCompare(a, b) == 0) {
+				return !negate
+			}
+		}
+		return negate
+	}, nil
+}
+
+// postfix parses a primary value followed by property and index accessors
+func (p *kqlParser) postfix() (kqlExpr, error) {
+	expr, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			t := p.next()
+			if t.kind != "ident" {
+				return nil, &kqlError{t.pos, "expected a property name"}
+			}
+			expr = kqlIndex(expr, t.text)
+		case p.isOp("["):
+			p.next()
+			t := p.next()
+			if t.kind != "string" && t.kind != "number" {
+				return nil, &kqlError{t.pos, "expected a property name or index"}
+			}
+			if err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			expr = kqlIndex(expr, t.text)
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// kqlIndex reads a property of a dynamic object or an element of a dynamic array
+func kqlIndex(expr kqlExpr, key string) kqlExpr {
+	return func(row map[string]interface{}) interface{} {
+		switch v := expr(row).(type) {
+		case map[string]interface{}:
+			if item, ok := lookupFold(v, key); ok {
+				return item
+			}
+		case []interface{}:
+			if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(v) {
+				return v[i]
+			}
+		}
+		return nil
+	}
+}
+
+// primary parses a literal, column, function call or parenthesized expression
+func (p *kqlParser) primary() (kqlExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case "string":
+		return func(map[string]interface{}) interface{} { return t.text }, nil
+	case "number":
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, &kqlError{t.pos, fmt.Sprintf("invalid number '%s'", t.text)}
+		}
+		return func(map[string]interface{}) interface{} { return n }, nil
+	case "op":
+		if t.text != "(" {
+			break
+		}
+		expr, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case "ident":
+		switch strings.ToLower(t.text) {
+		case "true", "false":
+			b := strings.EqualFold(t.text, "true")
+			return func(map[string]interface{}) interface{} { return b }, nil
+		}
+		if p.isOp("(") {
+			return p.function(t)
+		}
+		name := t.text
+		return func(row map[string]interface{}) interface{} {
+			v, _ := lookupFold(row, name)
+			return v
+		}, nil
+	}
+	if t.kind != "eof" {
+		p.pos--
+	}
+	return nil, p.errorf("expected a value")
+}
+
+// function parses a call to a scalar function
+func (p *kqlParser) function(name kqlToken) (kqlExpr, error) {
+	p.next()
+	args := []kqlExpr{}
+	for !p.isOp(")") {
+		arg, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	unary := func(f func(v interface{}) interface{}) (kqlExpr, error) {
+		if len(args) != 1 {
+			return nil, &kqlError{name.pos, fmt.Sprintf("function '%s' expects 1 argument", name.text)}
+		}
+		return func(row map[string]interface{}) interface{} { return f(args[0](row)) }, nil
+	}
+
+	switch strings.ToLower(name.text) {
+	case "tolower":
+		return unary(func(v interface{}) interface{} { return strings.ToLower(kqlString(v)) })
+	case "toupper":
+		return unary(func(v interface{}) interface{} { return strings.ToUpper(kqlString(v)) })
+	case "tostring":
+		return unary(func(v interface{}) interface{} { return kqlString(v) })
+	case "isempty", "isnull":
+		return unary(func(v interface{}) interface{} { return kqlString(v) == "" })
+	case "isnotempty", "isnotnull":
+		return unary(func(v interface{}) interface{} { return kqlString(v) != "" })
+	case "not":
+		return unary(func(v interface{}) interface{} { b, _ := v.(bool); return !b })
+	case "strcat":
+		return func(row map[string]interface{}) interface{} {
+			var b strings.Builder
+			for _, arg := range args {
+				b.WriteString(kqlString(arg(row)))
+			}
+			return b.String()
+		}, nil
+	default:
+		return nil, &kqlError{name.pos, fmt.Sprintf("unsupported function '%s'", name.text)}
+	}
+}
+
+// kqlString converts a value to its string form; null is the empty string
+func kqlString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	default:
+		data, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Sprint(t)
+		}
+		return string(data)
+	}
+}
+
+// kqlCompare orders two values, numerically when both are numbers
+func kqlCompare(a, b interface{}) int {
+	if an, ok := a.(float64); ok {
+		if bn, ok := b.(float64); ok {
+			switch {
+			case an < bn:
+				return -1
+			case an > bn:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(kqlString(a), kqlString(b))
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
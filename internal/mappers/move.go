@@ -0,0 +1,123 @@
+package mappers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// moveRequest is the body of Resources_MoveResources and Resources_ValidateMoveResources
+type moveRequest struct {
+	Resources           []string `json:"resources"`
+	TargetResourceGroup string   `json:"targetResourceGroup"`
+}
+
+// mapMoveResourcesResponse handles Resources_MoveResources and Resources_ValidateMoveResources.
+// Both are long-running: the move is validated (and applied) up front, and its outcome is
+// returned when the caller polls the operation result.
+func mapMoveResourcesResponse(req *Request, store StoreInterface) (interface{}, error) {
+	rs, ok := store.(ResourceStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support generic resources")
+	}
+
+	subscriptionID := req.Params["subscriptionId"]
+	source := req.Params["sourceResourceGroupName"]
+	if findResourceGroup(subscriptionID, source, store) == nil {
+		return nil, resourceGroupNotFound(source)
+	}
+
+	var body moveRequest
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		return nil, invalidRequestContent(err)
+	}
+	if len(body.Resources) == 0 || body.TargetResourceGroup == "" {
+		return nil, invalidRequestContent(fmt.Errorf("'resources' and 'targetResourceGroup' are required"))
+	}
+	targetSubscriptionID, target := scopeParts(body.TargetResourceGroup)
+	if target == "" || len(strings.Split(strings.Trim(body.TargetResourceGroup, "/"), "/")) != 4 {
+		return nil, &ARMError{
+			StatusCode: http.StatusBadRequest,
+			Code:       "InvalidTargetResourceGroup",
+			Message:    fmt.Sprintf("The target resource group '%s' is invalid.", body.TargetResourceGroup),
+		}
+	}
+
+	// The caller needs write on both groups, and access to the target's subscription
+	sourceScope := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionID, source)
+	if req.Principal != nil && !req.Principal.HasPermission(subscriptionID, source, "write") {
+		return nil, authorizationFailed("Microsoft.Resources/subscriptions/resourceGroups/moveResources/action", sourceScope)
+	}
+	if req.Principal != nil && (!req.Principal.CanAccessSubscription(targetSubscriptionID) || !req.Principal.HasPermission(targetSubscriptionID, target, "write")) {
+		return nil, authorizationFailed("Microsoft.Resources/subscriptions/resourceGroups/write", body.TargetResourceGroup)
+	}
+
+	op := &Operation{}
+	failures := validateMove(req, store, rs, subscriptionID, source, targetSubscriptionID, target, body.Resources)
+	if len(failures) > 0 {
+		op.Error = &ARMError{
+			StatusCode: http.StatusConflict,
+			Code:       "ResourceMoveValidationFailed",
+			Message: fmt.Sprintf("The resource batch move request has '%d' validation errors. Diagnostic information: subscription id '%s', request correlation id '%s'.",
+				len(failures), subscriptionID, newOperationID()),
+			Details: failures,
+		}
+	} else if req.OperationID == "Resources_MoveResources" {
+		for _, id := range body.Resources {
+			rs.MoveResource(id, targetSubscriptionID, target)
+		}
+	}
+	return acceptOperation(req, store, subscriptionID, op)
+}
+
+// validateMove returns one error per problem that would make the move fail
+func validateMove(req *Request, store StoreInterface, rs ResourceStore, subscriptionID, source, targetSubscriptionID, target string, resources []string) []*ARMError {
+	targetID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", targetSubscriptionID, target)
+	failures := []*ARMError{}
+
+	if err := checkSubscriptionAccess(targetSubscriptionID, req.Principal, store); err != nil {
+		armErr := subscriptionNotFound(targetSubscriptionID)
+		armErr.Target = targetID
+		failures = append(failures, armErr)
+	} else if findResourceGroup(targetSubscriptionID, target, store) == nil {
+		armErr := resourceGroupNotFound(target)
+		armErr.Target = targetID
+		failures = append(failures, armErr)
+	}
+	if strings.EqualFold(subscriptionID, targetSubscriptionID) && strings.EqualFold(source, target) {
+		failures = append(failures, &ARMError{
+			Code:    "InvalidTargetResourceGroup",
+			Target:  targetID,
+			Message: fmt.Sprintf("The target resource group is the same as the source resource group '%s'.", source),
+		})
+	}
+
+	for _, id := range resources {
+		rid, err := ParseResourceID(id)
+		switch {
+		case err != nil:
+			failures = append(failures, &ARMError{Code: "InvalidResourceId", Target: id, Message: fmt.Sprintf("The resource ID '%s' is invalid.", id)})
+		case !strings.EqualFold(rid.SubscriptionID, subscriptionID) || !strings.EqualFold(rid.ResourceGroup, source):
+			failures = append(failures, &ARMError{Code: "ResourceNotInSourceResourceGroup", Target: id, Message: fmt.Sprintf("The resource '%s' is not in the source resource group '%s'.", id, source)})
+		case rid.Parent() != nil:
+			failures = append(failures, &ARMError{Code: "ResourceMoveNotSupported", Target: id, Message: fmt.Sprintf("Nested resource '%s' cannot be moved on its own; move its parent instead.", id)})
+		case !resourceExists(rid.String(), store, rs):
+			notFound := resourceNotFound(rid.Type(), rid.Name(), rid.ResourceGroup)
+			notFound.Target = id
+			failures = append(failures, notFound)
+		default:
+			moved := *rid
+			moved.SubscriptionID, moved.ResourceGroup = targetSubscriptionID, target
+			if resourceExists(moved.String(), store, rs) {
+				failures = append(failures, &ARMError{Code: "ResourceAlreadyExists", Target: id, Message: fmt.Sprintf("A resource '%s' already exists in the target resource group '%s'.", moved.String(), target)})
+			}
+		}
+	}
+	return failures
+}
+
+// resourceExists reports whether a VM or generic resource with the ID exists
+func resourceExists(id string, store StoreInterface, rs ResourceStore) bool {
+	return findResource(id, rs) != nil || findVM(id, store) != nil
+}
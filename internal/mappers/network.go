@@ -0,0 +1,921 @@
+package mappers
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Microsoft.Network resources are kept in the generic resource store. This file adds what
+// the network resource provider does on top: validation, defaults, deterministic address
+// allocation, back-references between resources, and refusing to delete resources in use.
+// See https://learn.microsoft.com/rest/api/virtualnetwork/
+
+const (
+	virtualNetworkType       = "Microsoft.Network/virtualNetworks"
+	subnetType               = "Microsoft.Network/virtualNetworks/subnets"
+	networkInterfaceType     = "Microsoft.Network/networkInterfaces"
+	ipConfigurationType      = "Microsoft.Network/networkInterfaces/ipConfigurations"
+	publicIPAddressType      = "Microsoft.Network/publicIPAddresses"
+	networkSecurityGroupType = "Microsoft.Network/networkSecurityGroups"
+	securityRuleType         = "Microsoft.Network/networkSecurityGroups/securityRules"
+
+	// reservedSubnetAddresses is how many addresses Azure reserves at the start of every subnet
+	reservedSubnetAddresses = 4
+)
+
+// publicIPPool is the range public IP addresses are allocated from
+var publicIPPool = netip.MustParsePrefix("20.51.0.0/16")
+
+// networkCollection describes child resources ARM also embeds in their parent's properties
+type networkCollection struct {
+	property  string
+	childType string
+}
+
+// networkCollections maps parent types to their embedded child collection
+var networkCollections = map[string]networkCollection{
+	strings.ToLower(virtualNetworkType):       {"subnets", subnetType},
+	strings.ToLower(networkSecurityGroupType): {"securityRules", securityRuleType},
+}
+
+// networkListOperations maps the Microsoft.Network list operations to the type they list
+var networkListOperations = map[string]string{
+	"VirtualNetworks":       virtualNetworkType,
+	"Subnets":               subnetType,
+	"NetworkInterfaces":     networkInterfaceType,
+	"PublicIPAddresses":     publicIPAddressType,
+	"NetworkSecurityGroups": networkSecurityGroupType,
+	"SecurityRules":         securityRuleType,
+}
+
+// defaultSecurityRules are the rules every network security group starts with
+var defaultSecurityRules = []struct {
+	name, direction, access, source, destination string
+	priority                                     int
+}{
+	{"AllowVnetInBound", "Inbound", "Allow", "VirtualNetwork", "VirtualNetwork", 65000},
+	{"AllowAzureLoadBalancerInBound", "Inbound", "Allow", "AzureLoadBalancer", "*", 65001},
+	{"DenyAllInBound", "Inbound", "Deny", "*", "*", 65500},
+	{"AllowVnetOutBound", "Outbound", "Allow", "VirtualNetwork", "VirtualNetwork", 65000},
+	{"AllowInternetOutBound", "Outbound", "Allow", "*", "Internet", 65001},
+	{"DenyAllOutBound", "Outbound", "Deny", "*", "*", 65500},
+}
+
+// isNetworkResource reports whether a resource ID is one of the modelled network types
+func isNetworkResource(rid *ResourceID) bool {
+	switch strings.ToLower(rid.Type()) {
+	case strings.ToLower(virtualNetworkType), strings.ToLower(subnetType), strings.ToLower(networkInterfaceType),
+		strings.ToLower(publicIPAddressType), strings.ToLower(networkSecurityGroupType), strings.ToLower(securityRuleType):
+		return true
+	}
+	return false
+}
+
+// putNetworkResource validates a network resource, fills in what the provider computes,
+// and stores it together with any child resources embedded in its properties
+func putNetworkResource(rid *ResourceID, resource map[string]interface{}, rs ResourceStore) error {
+	props, _ := resource["properties"].(map[string]interface{})
+	if props == nil {
+		props = map[string]interface{}{}
+		resource["properties"] = props
+	}
+	props["provisioningState"] = "Succeeded"
+
+	var children []map[string]interface{}
+	var removed []string
+	var updates []map[string]interface{} // other resources the write changes, such as public IPs it allocates
+	var err error
+
+	switch strings.ToLower(rid.Type()) {
+	case strings.ToLower(virtualNetworkType):
+		var prefixes []netip.Prefix
+		if prefixes, err = virtualNetworkPrefixes(rid, props); err != nil {
+			return err
+		}
+		props["resourceGuid"] = deterministicGUID(rid.String())
+		setDefault(props, "enableDdosProtection", false)
+		if children, removed, err = embeddedChildren(rid, props, rs); err != nil {
+			return err
+		}
+		siblings := map[string]netip.Prefix{}
+		if _, inline := props["subnets"]; !inline {
+			siblings = subnetPrefixes(rid, "", rs)
+		}
+		for _, child := range children {
+			childRID, _ := ParseResourceID(child["id"].(string))
+			if err := prepareSubnet(childRID, child["properties"].(map[string]interface{}), prefixes, siblings, rs); err != nil {
+				return err
+			}
+		}
+		for _, id := range removed {
+			if err := checkSubnetDelete(id, rs); err != nil {
+				return err
+			}
+		}
+		delete(props, "subnets")
+
+	case strings.ToLower(subnetType):
+		parent := findResource(rid.Parent().String(), rs)
+		parentProps, _ := parent["properties"].(map[string]interface{})
+		prefixes, err := virtualNetworkPrefixes(rid.Parent(), parentProps)
+		if err != nil {
+			return err
+		}
+		if err := prepareSubnet(rid, props, prefixes, subnetPrefixes(rid.Parent(), rid.Name(), rs), rs); err != nil {
+			return err
+		}
+
+	case strings.ToLower(networkInterfaceType):
+		if updates, err = prepareNetworkInterface(rid, props, rs); err != nil {
+			return err
+		}
+
+	case strings.ToLower(publicIPAddressType):
+		preparePublicIPAddress(rid, resource, props, rs)
+
+	case strings.ToLower(networkSecurityGroupType):
+		props["resourceGuid"] = deterministicGUID(rid.String())
+		if children, removed, err = embeddedChildren(rid, props, rs); err != nil {
+			return err
+		}
+		siblings := map[string]string{}
+		if _, inline := props["securityRules"]; !inline {
+			siblings = securityRulePriorities(rid, "", rs)
+		}
+		for _, child := range children {
+			childRID, _ := ParseResourceID(child["id"].(string))
+			if err := prepareSecurityRule(childRID, child["properties"].(map[string]interface{}), siblings); err != nil {
+				return err
+			}
+		}
+		for _, key := range []string{"securityRules", "defaultSecurityRules", "networkInterfaces", "subnets"} {
+			delete(props, key)
+		}
+
+	case strings.ToLower(securityRuleType):
+		if err := prepareSecurityRule(rid, props, securityRulePriorities(rid.Parent(), rid.Name(), rs)); err != nil {
+			return err
+		}
+	}
+
+	if err := rs.PutResource(resource); err != nil {
+		return err
+	}
+	for _, r := range append(children, updates...) {
+		if err := rs.PutResource(r); err != nil {
+			return err
+		}
+	}
+	for _, id := range removed {
+		rs.DeleteResource(id)
+	}
+	return nil
+}
+
+// embeddedChildren splits the child resources embedded in a parent's properties, such as
+// a virtual network's subnets, into resources of their own. When the parent lists its
+// children, existing children it leaves out are returned for removal.
+func embeddedChildren(rid *ResourceID, props map[string]interface{}, rs ResourceStore) ([]map[string]interface{}, []string, error) {
+	collection := networkCollections[strings.ToLower(rid.Type())]
+	list, inline := props[collection.property].([]interface{})
+	if !inline {
+		return nil, nil, nil
+	}
+	children := []map[string]interface{}{}
+	kept := map[string]bool{}
+	for _, item := range list {
+		itemMap, _ := item.(map[string]interface{})
+		name, _ := itemMap["name"].(string)
+		if name == "" {
+			return nil, nil, invalidNetworkRequest("InvalidRequestFormat",
+				fmt.Sprintf("Each item of '%s' in resource '%s' must have a name.", collection.property, rid.String()))
+		}
+		childProps, _ := itemMap["properties"].(map[string]interface{})
+		if childProps == nil {
+			childProps = map[string]interface{}{}
+		}
+		childProps["provisioningState"] = "Succeeded"
+		id := rid.String() + "/" + collection.property + "/" + name
+		kept[strings.ToLower(id)] = true
+		children = append(children, map[string]interface{}{
+			"id":         id,
+			"name":       name,
+			"type":       collection.childType,
+			"properties": childProps,
+		})
+	}
+	removed := []string{}
+	for _, child := range childResources(rid, collection.childType, rs) {
+		if id, _ := child["id"].(string); !kept[strings.ToLower(id)] {
+			removed = append(removed, id)
+		}
+	}
+	return children, removed, nil
+}
+
+// virtualNetworkPrefixes returns the validated address space of a virtual network
+func virtualNetworkPrefixes(rid *ResourceID, props map[string]interface{}) ([]netip.Prefix, error) {
+	space, _ := props["addressSpace"].(map[string]interface{})
+	list, _ := space["addressPrefixes"].([]interface{})
+	if len(list) == 0 {
+		return nil, invalidNetworkRequest("NetcfgInvalidAddressPrefix",
+			fmt.Sprintf("Virtual network '%s' must have at least one address prefix in its address space.", rid.Name()))
+	}
+	prefixes := []netip.Prefix{}
+	for _, item := range list {
+		s, _ := item.(string)
+		prefix, ok := parseNetworkPrefix(s)
+		if !ok {
+			return nil, invalidNetworkRequest("NetcfgInvalidAddressPrefix",
+				fmt.Sprintf("Value '%s' provided for the address prefix of virtual network '%s' is invalid.", s, rid.Name()))
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// prepareSubnet validates a subnet against its virtual network and sibling subnets
+func prepareSubnet(rid *ResourceID, props map[string]interface{}, vnetPrefixes []netip.Prefix, siblings map[string]netip.Prefix, rs ResourceStore) error {
+	s := subnetPrefixString(props)
+	prefix, ok := parseNetworkPrefix(s)
+	if !ok {
+		return invalidNetworkRequest("NetcfgInvalidAddressPrefix",
+			fmt.Sprintf("Value '%s' provided for the address prefix of subnet '%s' is invalid.", s, rid.Name()))
+	}
+	vnetName := rid.Parent().Name()
+	inside := false
+	for _, p := range vnetPrefixes {
+		if p.Bits() <= prefix.Bits() && p.Contains(prefix.Addr()) {
+			inside = true
+		}
+	}
+	if !inside {
+		return invalidNetworkRequest("NetcfgSubnetRangeOutsideVnet",
+			fmt.Sprintf("Subnet '%s' is not valid because its IP address range is outside the IP address range of virtual network '%s'.", rid.Name(), vnetName))
+	}
+	names := make([]string, 0, len(siblings))
+	for name := range siblings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !strings.EqualFold(name, rid.Name()) && siblings[name].Overlaps(prefix) {
+			return invalidNetworkRequest("NetcfgSubnetRangesOverlap",
+				fmt.Sprintf("Subnet '%s' is not valid in virtual network '%s' because its IP address range overlaps with that of an existing subnet '%s'.", rid.Name(), vnetName, name))
+		}
+	}
+	siblings[rid.Name()] = prefix
+
+	if nsgID := referenceID(props["networkSecurityGroup"]); nsgID != "" && findResource(nsgID, rs) == nil {
+		return invalidResourceReference(nsgID, rid.String())
+	}
+	setDefault(props, "privateEndpointNetworkPolicies", "Disabled")
+	setDefault(props, "privateLinkServiceNetworkPolicies", "Enabled")
+	delete(props, "ipConfigurations")
+	return nil
+}
+
+// prepareNetworkInterface validates the IP configurations of a network interface and assigns
+// their private addresses. Dynamic addresses are the lowest free ones in the subnet, so they
+// do not depend on timing. It returns public IPs that got an address through the interface.
+func prepareNetworkInterface(rid *ResourceID, props map[string]interface{}, rs ResourceStore) ([]map[string]interface{}, error) {
+	configs, _ := props["ipConfigurations"].([]interface{})
+	if len(configs) == 0 {
+		return nil, invalidNetworkRequest("InvalidRequestFormat",
+			fmt.Sprintf("Network interface '%s' must have at least one IP configuration.", rid.Name()))
+	}
+
+	// Addresses the interface already holds are kept across updates
+	previous := map[string]string{}
+	if existing := findResource(rid.String(), rs); existing != nil {
+		existingProps, _ := existing["properties"].(map[string]interface{})
+		for _, c := range ipConfigurations(existingProps) {
+			cp, _ := c["properties"].(map[string]interface{})
+			key := strings.ToLower(fmt.Sprint(c["name"]) + "|" + referenceID(cp["subnet"]))
+			previous[key], _ = cp["privateIPAddress"].(string)
+		}
+	}
+
+	hasPrimary := false
+	for _, c := range configs {
+		if cMap, ok := c.(map[string]interface{}); ok {
+			if cp, ok := cMap["properties"].(map[string]interface{}); ok && cp["primary"] == true {
+				hasPrimary = true
+			}
+		}
+	}
+
+	taken := map[string]bool{} // addresses assigned within this interface
+	updates := []map[string]interface{}{}
+	out := []interface{}{}
+	for i, c := range configs {
+		cMap, _ := c.(map[string]interface{})
+		name, _ := cMap["name"].(string)
+		if name == "" {
+			name = fmt.Sprintf("ipconfig%d", i+1)
+		}
+		cp, _ := cMap["properties"].(map[string]interface{})
+		if cp == nil {
+			cp = map[string]interface{}{}
+		}
+
+		subnetID := referenceID(cp["subnet"])
+		if subnetID == "" {
+			return nil, invalidNetworkRequest("InvalidRequestFormat",
+				fmt.Sprintf("IP configuration '%s' of network interface '%s' must reference a subnet.", name, rid.Name()))
+		}
+		subnet := findResource(subnetID, rs)
+		if subnet == nil {
+			return nil, invalidResourceReference(subnetID, rid.String())
+		}
+		subnetID, _ = subnet["id"].(string)
+		subnetProps, _ := subnet["properties"].(map[string]interface{})
+		prefix, _ := parseNetworkPrefix(subnetPrefixString(subnetProps))
+		used := subnetAddresses(subnetID, rid.String(), rs)
+		for addr := range taken {
+			used[addr] = true
+		}
+
+		method := "Dynamic"
+		if m, _ := cp["privateIPAllocationMethod"].(string); strings.EqualFold(m, "Static") {
+			method = "Static"
+		}
+		var address string
+		if method == "Static" {
+			address, _ = cp["privateIPAddress"].(string)
+			addr, err := netip.ParseAddr(address)
+			if err != nil || !prefix.Contains(addr) {
+				return nil, invalidNetworkRequest("PrivateIPAddressNotInSubnet",
+					fmt.Sprintf("IP configuration %s/ipConfigurations/%s is referencing private IP address %s which does not belong to subnet %s.", rid.String(), name, address, subnetID))
+			}
+			if used[addr.String()] {
+				return nil, invalidNetworkRequest("PrivateIPAddressInUse",
+					fmt.Sprintf("IP configuration %s/ipConfigurations/%s is using the private IP address %s which is already allocated to resource in subnet %s.", rid.String(), name, address, subnetID))
+			}
+			address = addr.String()
+		} else {
+			address = previous[strings.ToLower(name+"|"+subnetID)]
+			if address == "" || used[address] {
+				var ok bool
+				if address, ok = allocateAddress(prefix, reservedSubnetAddresses, used); !ok {
+					return nil, invalidNetworkRequest("SubnetIsFull",
+						fmt.Sprintf("Subnet %s with address prefix %s does not have enough capacity for 1 IP addresses.", subnetID, prefix))
+				}
+			}
+		}
+		taken[address] = true
+
+		ipConfigID := rid.String() + "/ipConfigurations/" + name
+		if pipID := referenceID(cp["publicIPAddress"]); pipID != "" {
+			pip := findResource(pipID, rs)
+			if pip == nil {
+				return nil, invalidResourceReference(pipID, rid.String())
+			}
+			pipID, _ = pip["id"].(string)
+			if user := publicIPAddressUser(pipID, rs); user != "" && !hasPrefixFold(user, rid.String()+"/") {
+				return nil, invalidNetworkRequest("PublicIPAddressInUse",
+					fmt.Sprintf("Public IP address %s is already allocated to resource %s.", pipID, user))
+			}
+			pipProps, _ := pip["properties"].(map[string]interface{})
+			if pipProps == nil {
+				pipProps = map[string]interface{}{}
+				pip["properties"] = pipProps
+			}
+			if ip, _ := pipProps["ipAddress"].(string); ip == "" {
+				// Dynamic public IPs get their address once they are associated
+				if ip, ok := allocateAddress(publicIPPool, 1, publicIPAddresses(rs)); ok {
+					pipProps["ipAddress"] = ip
+					updates = append(updates, pip)
+				}
+			}
+			cp["publicIPAddress"] = map[string]interface{}{"id": pipID}
+		}
+
+		cp["subnet"] = map[string]interface{}{"id": subnetID}
+		cp["privateIPAllocationMethod"] = method
+		cp["privateIPAddress"] = address
+		cp["privateIPAddressVersion"] = "IPv4"
+		cp["provisioningState"] = "Succeeded"
+		cp["primary"] = cp["primary"] == true || (!hasPrimary && i == 0)
+		out = append(out, map[string]interface{}{
+			"id":         ipConfigID,
+			"name":       name,
+			"type":       ipConfigurationType,
+			"properties": cp,
+		})
+	}
+	props["ipConfigurations"] = out
+
+	if nsgID := referenceID(props["networkSecurityGroup"]); nsgID != "" {
+		if findResource(nsgID, rs) == nil {
+			return nil, invalidResourceReference(nsgID, rid.String())
+		}
+	}
+	props["resourceGuid"] = deterministicGUID(rid.String())
+	props["macAddress"] = deterministicMAC(rid.String())
+	props["nicType"] = "Standard"
+	setDefault(props, "enableAcceleratedNetworking", false)
+	setDefault(props, "enableIPForwarding", false)
+	delete(props, "virtualMachine")
+	return updates, nil
+}
+
+// preparePublicIPAddress fills in the SKU and allocation method of a public IP and assigns
+// a static address. Addresses are kept across updates.
+func preparePublicIPAddress(rid *ResourceID, resource, props map[string]interface{}, rs ResourceStore) {
+	sku, _ := resource["sku"].(map[string]interface{})
+	if sku == nil {
+		sku = map[string]interface{}{"name": "Standard"}
+		resource["sku"] = sku
+	}
+	setDefault(sku, "tier", "Regional")
+
+	method := "Dynamic"
+	if m, _ := props["publicIPAllocationMethod"].(string); strings.EqualFold(m, "Static") || (m == "" && strings.EqualFold(fmt.Sprint(sku["name"]), "Standard")) {
+		method = "Static"
+	}
+	props["publicIPAllocationMethod"] = method
+
+	address := ""
+	if existing := findResource(rid.String(), rs); existing != nil {
+		existingProps, _ := existing["properties"].(map[string]interface{})
+		address, _ = existingProps["ipAddress"].(string)
+	}
+	if address == "" && method == "Static" {
+		address, _ = allocateAddress(publicIPPool, 1, publicIPAddresses(rs))
+	}
+	if address != "" {
+		props["ipAddress"] = address
+	} else {
+		delete(props, "ipAddress")
+	}
+	setDefault(props, "publicIPAddressVersion", "IPv4")
+	setDefault(props, "idleTimeoutInMinutes", 4)
+	props["resourceGuid"] = deterministicGUID(rid.String())
+	delete(props, "ipConfiguration")
+}
+
+// prepareSecurityRule validates a security rule; siblings maps direction and priority to rule names
+func prepareSecurityRule(rid *ResourceID, props map[string]interface{}, siblings map[string]string) error {
+	for field, allowed := range map[string][]string{
+		"protocol":  {"Tcp", "Udp", "Icmp", "Esp", "Ah", "*"},
+		"access":    {"Allow", "Deny"},
+		"direction": {"Inbound", "Outbound"},
+	} {
+		value, _ := props[field].(string)
+		canonical := ""
+		for _, a := range allowed {
+			if strings.EqualFold(a, value) {
+				canonical = a
+			}
+		}
+		if canonical == "" {
+			return invalidNetworkRequest("SecurityRuleParameterContainsUnsupportedValue",
+				fmt.Sprintf("Security rule parameter %s for rule with Id %s cannot specify value '%s'. Allowed values are %s.", field, rid.String(), value, strings.Join(allowed, ", ")))
+		}
+		props[field] = canonical
+	}
+	priority := intValue(props["priority"])
+	if priority < 100 || priority > 4096 {
+		return invalidNetworkRequest("SecurityRuleInvalidPriority",
+			fmt.Sprintf("Security rule has invalid Priority. Value provided: %d Allowed range 100-4096.", priority))
+	}
+	key := fmt.Sprintf("%s|%d", props["direction"], priority)
+	if other, ok := siblings[key]; ok && !strings.EqualFold(other, rid.Name()) {
+		return invalidNetworkRequest("SecurityRuleConflict",
+			fmt.Sprintf("Security rule %s conflicts with rule %s. Rules cannot have the same Priority and Direction.", rid.Name(), other))
+	}
+	siblings[key] = rid.Name()
+	return nil
+}
+
+// checkNetworkDelete refuses to delete network resources other resources still use
+func checkNetworkDelete(rid *ResourceID, rs ResourceStore, store StoreInterface) error {
+	id := rid.String()
+	switch strings.ToLower(rid.Type()) {
+	case strings.ToLower(networkInterfaceType):
+		if vmID := networkInterfaceVM(id, store); vmID != "" {
+			return invalidNetworkRequest("NicInUse",
+				fmt.Sprintf("Network Interface %s is used by existing resource %s. In order to delete the network interface, it must be dissociated from the resource. To learn more, see aka.ms/deletenic.", id, vmID))
+		}
+	case strings.ToLower(subnetType):
+		return checkSubnetDelete(id, rs)
+	case strings.ToLower(virtualNetworkType):
+		for _, subnet := range childResources(rid, subnetType, rs) {
+			if err := checkSubnetDelete(subnet["id"].(string), rs); err != nil {
+				return err
+			}
+		}
+	case strings.ToLower(publicIPAddressType):
+		if user := publicIPAddressUser(id, rs); user != "" {
+			return invalidNetworkRequest("PublicIPAddressCannotBeDeleted",
+				fmt.Sprintf("Public IP address %s can not be deleted since it is still allocated to resource %s. In order to delete the public IP, disassociate/detach the Public IP address from the resource. To learn how to do this, see aka.ms/deletepublicip.", id, user))
+		}
+	case strings.ToLower(networkSecurityGroupType):
+		if users := networkSecurityGroupUsers(id, rs); len(users) > 0 {
+			return invalidNetworkRequest("InUseNetworkSecurityGroupCannotBeDeleted",
+				fmt.Sprintf("Network security group %s cannot be deleted because it is in use by the following resources: %s. In order to delete the Network security group, remove the association with the resource(s). To learn how to do this, see aka.ms/deletensg.", id, strings.Join(users, ", ")))
+		}
+	}
+	return nil
+}
+
+// checkSubnetDelete refuses to delete a subnet that IP configurations still use
+func checkSubnetDelete(subnetID string, rs ResourceStore) error {
+	if users := subnetIPConfigurations(subnetID, rs); len(users) > 0 {
+		name := subnetID[strings.LastIndex(subnetID, "/")+1:]
+		return invalidNetworkRequest("InUseSubnetCannotBeDeleted",
+			fmt.Sprintf("Subnet %s is in use by %s and cannot be deleted. In order to delete the subnet, delete all the resources within the subnet. See aka.ms/deletesubnet.", name, strings.Join(users, ", ")))
+	}
+	return nil
+}
+
+// networkView adds what the network provider computes on read to the ARM view of a network
+// resource: embedded children, back-references to the resources using it, and default rules
+func networkView(armResource map[string]interface{}, rs ResourceStore, store StoreInterface) map[string]interface{} {
+	id, _ := armResource["id"].(string)
+	rid, err := ParseResourceID(id)
+	if err != nil || !isNetworkResource(rid) {
+		return armResource
+	}
+	props, _ := armResource["properties"].(map[string]interface{})
+	if props == nil {
+		props = map[string]interface{}{}
+		armResource["properties"] = props
+	}
+	idList := func(ids []string) []interface{} {
+		list := []interface{}{}
+		for _, id := range ids {
+			list = append(list, map[string]interface{}{"id": id})
+		}
+		return list
+	}
+
+	if collection, ok := networkCollections[strings.ToLower(rid.Type())]; ok {
+		children := []interface{}{}
+		for _, child := range childResources(rid, collection.childType, rs) {
+			children = append(children, networkView(convertResourceToARMFormat(child, true), rs, store))
+		}
+		props[collection.property] = children
+	}
+
+	switch strings.ToLower(rid.Type()) {
+	case strings.ToLower(subnetType):
+		if users := subnetIPConfigurations(id, rs); len(users) > 0 {
+			props["ipConfigurations"] = idList(users)
+		}
+	case strings.ToLower(networkInterfaceType):
+		if vmID := networkInterfaceVM(id, store); vmID != "" {
+			props["virtualMachine"] = map[string]interface{}{"id": vmID}
+		}
+	case strings.ToLower(publicIPAddressType):
+		if user := publicIPAddressUser(id, rs); user != "" {
+			props["ipConfiguration"] = map[string]interface{}{"id": user}
+		}
+	case strings.ToLower(networkSecurityGroupType):
+		defaults := []interface{}{}
+		for _, rule := range defaultSecurityRules {
+			defaults = append(defaults, map[string]interface{}{
+				"id":   id + "/defaultSecurityRules/" + rule.name,
+				"name": rule.name,
+				"type": "Microsoft.Network/networkSecurityGroups/defaultSecurityRules",
+				"properties": map[string]interface{}{
+					"protocol":                 "*",
+					"sourcePortRange":          "*",
+					"destinationPortRange":     "*",
+					"sourceAddressPrefix":      rule.source,
+					"destinationAddressPrefix": rule.destination,
+					"access":                   rule.access,
+					"priority":                 rule.priority,
+					"direction":                rule.direction,
+					"provisioningState":        "Succeeded",
+				},
+			})
+		}
+		props["defaultSecurityRules"] = defaults
+		nics, subnets := []string{}, []string{}
+		for _, user := range networkSecurityGroupUsers(id, rs) {
+			if strings.Contains(strings.ToLower(user), "/subnets/") {
+				subnets = append(subnets, user)
+			} else {
+				nics = append(nics, user)
+			}
+		}
+		if len(nics) > 0 {
+			props["networkInterfaces"] = idList(nics)
+		}
+		if len(subnets) > 0 {
+			props["subnets"] = idList(subnets)
+		}
+	}
+	return armResource
+}
+
+// mapNetworkListResponse handles the Microsoft.Network list operations, such as
+// VirtualNetworks_List, VirtualNetworks_ListAll and Subnets_List
+func mapNetworkListResponse(req *Request, store StoreInterface) (interface{}, error) {
+	rs, ok := store.(ResourceStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support generic resources")
+	}
+	prefix := req.OperationID[:strings.Index(req.OperationID, "_")]
+	resourceType := networkListOperations[prefix]
+	subscriptionID := req.Params["subscriptionId"]
+	resourceGroup := req.Params["resourceGroupName"]
+	if resourceGroup != "" && findResourceGroup(subscriptionID, resourceGroup, store) == nil {
+		return nil, resourceGroupNotFound(resourceGroup)
+	}
+	if req.Principal != nil && resourceGroup != "" && !req.Principal.HasPermission(subscriptionID, resourceGroup, "read") {
+		return nil, authorizationFailed(resourceType+"/read", "/subscriptions/"+subscriptionID+"/resourceGroups/"+resourceGroup)
+	}
+
+	var parent string
+	parentName := req.Params["virtualNetworkName"]
+	if parentName == "" {
+		parentName = req.Params["networkSecurityGroupName"]
+	}
+	if parentName != "" {
+		parentType := resourceType[strings.Index(resourceType, "/")+1 : strings.LastIndex(resourceType, "/")]
+		parent = fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/%s/%s", subscriptionID, resourceGroup, parentType, parentName)
+		if findResource(parent, rs) == nil {
+			return nil, resourceNotFound("Microsoft.Network/"+parentType, parentName, resourceGroup)
+		}
+	}
+
+	items := []map[string]interface{}{}
+	for _, r := range rs.GetResources() {
+		rMap, ok := r.(map[string]interface{})
+		if !ok || !strings.EqualFold(fmt.Sprint(rMap["type"]), resourceType) {
+			continue
+		}
+		id, _ := rMap["id"].(string)
+		rid, err := ParseResourceID(id)
+		if err != nil || !strings.EqualFold(rid.SubscriptionID, subscriptionID) {
+			continue
+		}
+		if resourceGroup != "" && !strings.EqualFold(rid.ResourceGroup, resourceGroup) {
+			continue
+		}
+		if parent != "" && !strings.EqualFold(rid.Parent().String(), parent) {
+			continue
+		}
+		if req.Principal != nil && !req.Principal.HasPermission(rid.SubscriptionID, rid.ResourceGroup, "read") {
+			continue
+		}
+		items = append(items, rMap)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return strings.ToLower(items[i]["id"].(string)) < strings.ToLower(items[j]["id"].(string))
+	})
+	value := []interface{}{}
+	for _, item := range items {
+		value = append(value, networkView(convertResourceToARMFormat(item, true), rs, store))
+	}
+	return map[string]interface{}{"value": value}, nil
+}
+
+// childResources returns the stored children of a resource with the given type, sorted by name
+func childResources(parent *ResourceID, childType string, rs ResourceStore) []map[string]interface{} {
+	children := []map[string]interface{}{}
+	prefix := parent.String() + "/"
+	for _, r := range rs.GetResources() {
+		rMap, ok := r.(map[string]interface{})
+		if !ok || !strings.EqualFold(fmt.Sprint(rMap["type"]), childType) {
+			continue
+		}
+		if id, _ := rMap["id"].(string); hasPrefixFold(id, prefix) && !strings.Contains(id[len(prefix):], "/providers/") {
+			children = append(children, rMap)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return strings.ToLower(fmt.Sprint(children[i]["name"])) < strings.ToLower(fmt.Sprint(children[j]["name"]))
+	})
+	return children
+}
+
+// resourcesOfType returns the stored resources of a type
+func resourcesOfType(resourceType string, rs ResourceStore) []map[string]interface{} {
+	items := []map[string]interface{}{}
+	for _, r := range rs.GetResources() {
+		if rMap, ok := r.(map[string]interface{}); ok && strings.EqualFold(fmt.Sprint(rMap["type"]), resourceType) {
+			items = append(items, rMap)
+		}
+	}
+	return items
+}
+
+// ipConfigurations returns the IP configurations in a network interface's properties
+func ipConfigurations(props map[string]interface{}) []map[string]interface{} {
+	configs := []map[string]interface{}{}
+	list, _ := props["ipConfigurations"].([]interface{})
+	for _, c := range list {
+		if cMap, ok := c.(map[string]interface{}); ok {
+			configs = append(configs, cMap)
+		}
+	}
+	return configs
+}
+
+// subnetPrefixes returns the address prefixes of a virtual network's subnets by name, except one
+func subnetPrefixes(vnet *ResourceID, except string, rs ResourceStore) map[string]netip.Prefix {
+	prefixes := map[string]netip.Prefix{}
+	for _, subnet := range childResources(vnet, subnetType, rs) {
+		name, _ := subnet["name"].(string)
+		props, _ := subnet["properties"].(map[string]interface{})
+		if prefix, ok := parseNetworkPrefix(subnetPrefixString(props)); ok && !strings.EqualFold(name, except) {
+			prefixes[name] = prefix
+		}
+	}
+	return prefixes
+}
+
+// securityRulePriorities maps the direction and priority of a group's rules to their names, except one
+func securityRulePriorities(nsg *ResourceID, except string, rs ResourceStore) map[string]string {
+	priorities := map[string]string{}
+	for _, rule := range childResources(nsg, securityRuleType, rs) {
+		name, _ := rule["name"].(string)
+		props, _ := rule["properties"].(map[string]interface{})
+		if !strings.EqualFold(name, except) {
+			priorities[fmt.Sprintf("%s|%d", props["direction"], intValue(props["priority"]))] = name
+		}
+	}
+	return priorities
+}
+
+// subnetAddresses returns the private addresses in use in a subnet, except by one network interface
+func subnetAddresses(subnetID, exceptNIC string, rs ResourceStore) map[string]bool {
+	used := map[string]bool{}
+	for _, nic := range resourcesOfType(networkInterfaceType, rs) {
+		if strings.EqualFold(fmt.Sprint(nic["id"]), exceptNIC) {
+			continue
+		}
+		props, _ := nic["properties"].(map[string]interface{})
+		for _, c := range ipConfigurations(props) {
+			cp, _ := c["properties"].(map[string]interface{})
+			if strings.EqualFold(referenceID(cp["subnet"]), subnetID) {
+				if address, _ := cp["privateIPAddress"].(string); address != "" {
+					used[address] = true
+				}
+			}
+		}
+	}
+	return used
+}
+
+// subnetIPConfigurations returns the IDs of the IP configurations using a subnet
+func subnetIPConfigurations(subnetID string, rs ResourceStore) []string {
+	users := []string{}
+	for _, nic := range resourcesOfType(networkInterfaceType, rs) {
+		props, _ := nic["properties"].(map[string]interface{})
+		for _, c := range ipConfigurations(props) {
+			cp, _ := c["properties"].(map[string]interface{})
+			if strings.EqualFold(referenceID(cp["subnet"]), subnetID) {
+				users = append(users, fmt.Sprint(c["id"]))
+			}
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// publicIPAddressUser returns the ID of the IP configuration a public IP is associated with
+func publicIPAddressUser(pipID string, rs ResourceStore) string {
+	for _, nic := range resourcesOfType(networkInterfaceType, rs) {
+		props, _ := nic["properties"].(map[string]interface{})
+		for _, c := range ipConfigurations(props) {
+			cp, _ := c["properties"].(map[string]interface{})
+			if strings.EqualFold(referenceID(cp["publicIPAddress"]), pipID) {
+				return fmt.Sprint(c["id"])
+			}
+		}
+	}
+	return ""
+}
+
+// publicIPAddresses returns the public IP addresses already allocated
+func publicIPAddresses(rs ResourceStore) map[string]bool {
+	used := map[string]bool{}
+	for _, pip := range resourcesOfType(publicIPAddressType, rs) {
+		props, _ := pip["properties"].(map[string]interface{})
+		if address, _ := props["ipAddress"].(string); address != "" {
+			used[address] = true
+		}
+	}
+	return used
+}
+
+// networkSecurityGroupUsers returns the IDs of the network interfaces and subnets using a group
+func networkSecurityGroupUsers(nsgID string, rs ResourceStore) []string {
+	users := []string{}
+	for _, resourceType := range []string{networkInterfaceType, subnetType} {
+		for _, r := range resourcesOfType(resourceType, rs) {
+			props, _ := r["properties"].(map[string]interface{})
+			if strings.EqualFold(referenceID(props["networkSecurityGroup"]), nsgID) {
+				users = append(users, fmt.Sprint(r["id"]))
+			}
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// networkInterfaceVM returns the ID of the VM a network interface is attached to
+func networkInterfaceVM(nicID string, store StoreInterface) string {
+	for _, vm := range store.GetVMs() {
+		vmMap, ok := vm.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		nics, _ := vmMap["networkInterfaces"].([]interface{})
+		for _, nic := range nics {
+			if strings.EqualFold(referenceID(nic), nicID) {
+				return fmt.Sprint(vmMap["id"])
+			}
+		}
+	}
+	return ""
+}
+
+// subnetPrefixString returns a subnet's address prefix, which may be given as a list
+func subnetPrefixString(props map[string]interface{}) string {
+	if s, _ := props["addressPrefix"].(string); s != "" {
+		return s
+	}
+	if list, _ := props["addressPrefixes"].([]interface{}); len(list) > 0 {
+		s, _ := list[0].(string)
+		return s
+	}
+	return ""
+}
+
+// parseNetworkPrefix parses an IPv4 CIDR prefix, which must not have host bits set
+func parseNetworkPrefix(s string) (netip.Prefix, bool) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil || !prefix.Addr().Is4() || prefix != prefix.Masked() {
+		return netip.Prefix{}, false
+	}
+	return prefix, true
+}
+
+// allocateAddress returns the lowest free address of a prefix after skipping its first
+// addresses, never handing out the broadcast address
+func allocateAddress(prefix netip.Prefix, skip int, used map[string]bool) (string, bool) {
+	addr := prefix.Addr()
+	for i := 0; i < skip; i++ {
+		addr = addr.Next()
+	}
+	for ; prefix.Contains(addr) && prefix.Contains(addr.Next()); addr = addr.Next() {
+		if !used[addr.String()] {
+			return addr.String(), true
+		}
+	}
+	return "", false
+}
+
+// referenceID reads the id of a {"id": ...} sub-resource reference
+func referenceID(v interface{}) string {
+	ref, _ := v.(map[string]interface{})
+	id, _ := ref["id"].(string)
+	return id
+}
+
+// setDefault sets a field unless it is already set
+func setDefault(m map[string]interface{}, key string, value interface{}) {
+	if _, ok := m[key]; !ok {
+		m[key] = value
+	}
+}
+
+// deterministicGUID derives a GUID from a seed, so that generated IDs are stable across runs
+func deterministicGUID(seed string) string {
+	sum := sha1.Sum([]byte(strings.ToLower(seed)))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// deterministicMAC derives a MAC address in Azure's 00-0D-3A range from a seed
+func deterministicMAC(seed string) string {
+	sum := sha1.Sum([]byte(strings.ToLower(seed)))
+	return strings.ToUpper(fmt.Sprintf("00-0D-3A-%02x-%02x-%02x", sum[0], sum[1], sum[2]))
+}
+
+// invalidNetworkRequest is a 400 error raised by the network resource provider
+func invalidNetworkRequest(code, message string) *ARMError {
+	return &ARMError{StatusCode: http.StatusBadRequest, Code: code, Message: message}
+}
+
+// invalidResourceReference is the error for a reference to a resource that does not exist
+func invalidResourceReference(referenced, referencing string) *ARMError {
+	return invalidNetworkRequest("InvalidResourceReference",
+		fmt.Sprintf("Resource %s referenced by resource %s was not found. Please make sure that the referenced resource exists, and that both resources are in the same region.", referenced, referencing))
+}
+
+// networkListOperation reports whether an operation ID is a Microsoft.Network list operation
+func networkListOperation(operationID string) bool {
+	prefix, suffix, ok := strings.Cut(operationID, "_")
+	_, known := networkListOperations[prefix]
+	return ok && known && (suffix == "List" || suffix == "ListAll")
+}
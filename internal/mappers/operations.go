@@ -0,0 +1,67 @@
+package mappers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// acceptOperation records a finished long-running operation and returns the 202 Accepted
+// response pointing the caller at its operation result
+func acceptOperation(req *Request, store StoreInterface, subscriptionID string, op *Operation) (interface{}, error) {
+	ops, ok := store.(OperationStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support long-running operations")
+	}
+	op.ID = newOperationID()
+	ops.PutOperation(op)
+
+	apiVersion := req.Params["api-version"]
+	if apiVersion == "" {
+		apiVersion = "2021-04-01"
+	}
+	return &Response{
+		StatusCode: http.StatusAccepted,
+		Headers: map[string]string{
+			"Location":    fmt.Sprintf("%s/subscriptions/%s/operationresults/%s?api-version=%s", req.BaseURL, subscriptionID, op.ID, apiVersion),
+			"Retry-After": "1",
+		},
+	}, nil
+}
+
+// mapOperationResultResponse handles polling of /subscriptions/{subscriptionId}/operationresults/{operationId}
+func mapOperationResultResponse(req *Request, store StoreInterface) (interface{}, error) {
+	ops, ok := store.(OperationStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support long-running operations")
+	}
+	op := ops.GetOperation(req.Params["operationId"])
+	if op == nil {
+		return nil, &ARMError{
+			StatusCode: http.StatusNotFound,
+			Code:       "OperationNotFound",
+			Message:    fmt.Sprintf("The operation '%s' could not be found.", req.Params["operationId"]),
+		}
+	}
+	if op.Error != nil {
+		return nil, op.Error
+	}
+	if op.Body == nil {
+		return &Response{StatusCode: http.StatusNoContent}, nil
+	}
+	status := op.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &Response{StatusCode: status, Body: op.Body}, nil
+}
+
+// newOperationID returns a random operation identifier
+func newOperationID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,296 @@
+package mappers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// resourceGraphRequest is the body of a Resource Graph query
+// See https://learn.microsoft.com/rest/api/azureresourcegraph/resourcegraph/resources/resources
+type resourceGraphRequest struct {
+	Subscriptions []string `json:"subscriptions"`
+	Query         string   `json:"query"`
+	Options       struct {
+		Top          *int   `json:"$top"`
+		Skip         int    `json:"$skip"`
+		SkipToken    string `json:"$skipToken"`
+		ResultFormat string `json:"resultFormat"`
+	} `json:"options"`
+}
+
+const (
+	resourceGraphDefaultTop = 100
+	resourceGraphMaxTop     = 1000
+)
+
+// resourceGraphColumns are the columns of the Resources and ResourceContainers tables
+var resourceGraphColumns = []string{"id", "name", "type", "tenantId", "kind", "location", "resourceGroup",
+	"subscriptionId", "managedBy", "sku", "plan", "properties", "tags", "identity", "zones", "extendedLocation"}
+
+// mapResourceGraphResponse handles Resource Graph queries over the subscriptions the caller can see
+func mapResourceGraphResponse(req *Request, store StoreInterface) (interface{}, error) {
+	if req.Method != "POST" {
+		return nil, fmt.Errorf("unsupported method: %s", req.Method)
+	}
+	var body resourceGraphRequest
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		return nil, invalidRequestContent(err)
+	}
+	if strings.TrimSpace(body.Query) == "" {
+		return nil, invalidResourceGraphQuery("The query is empty.")
+	}
+
+	top := resourceGraphDefaultTop
+	if body.Options.Top != nil {
+		top = *body.Options.Top
+	}
+	if top < 1 || top > resourceGraphMaxTop {
+		return nil, &ARMError{
+			StatusCode: http.StatusBadRequest,
+			Code:       "BadRequest",
+			Message:    fmt.Sprintf("The $top option must be between 1 and %d.", resourceGraphMaxTop),
+		}
+	}
+	offset := body.Options.Skip
+	if body.Options.SkipToken != "" {
+		decoded, err := base64.StdEncoding.DecodeString(body.Options.SkipToken)
+		if err == nil {
+			offset, err = strconv.Atoi(string(decoded))
+		}
+		if err != nil || offset < 0 {
+			return nil, &ARMError{
+				StatusCode: http.StatusBadRequest,
+				Code:       "BadRequest",
+				Message:    "The $skipToken is invalid.",
+			}
+		}
+	}
+
+	tables := resourceGraphTables(req, store, body.Subscriptions)
+	result, err := runKQL(body.Query, tables)
+	if err != nil {
+		return nil, invalidResourceGraphQuery(err.Error())
+	}
+
+	total := len(result.rows)
+	page := []map[string]interface{}{}
+	if offset < total {
+		page = result.rows[offset:]
+	}
+	if len(page) > top {
+		page = page[:top]
+	}
+
+	response := map[string]interface{}{
+		"totalRecords":    total,
+		"count":           len(page),
+		"resultTruncated": "false",
+		"facets":          []interface{}{},
+	}
+	if offset+len(page) < total {
+		response["$skipToken"] = base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(offset + len(page))))
+	}
+	if strings.EqualFold(body.Options.ResultFormat, "table") {
+		response["data"] = resourceGraphTableData(result.columns, page)
+	} else {
+		data := []interface{}{}
+		for _, row := range page {
+			item := map[string]interface{}{}
+			for _, column := range result.columns {
+				item[column] = row[column]
+			}
+			data = append(data, item)
+		}
+		response["data"] = data
+	}
+	return response, nil
+}
+
+// resourceGraphTables builds the Resources and ResourceContainers tables from the store,
+// limited to the requested subscriptions and to what the caller may read
+func resourceGraphTables(req *Request, store StoreInterface, subscriptions []string) map[string]*kqlTable {
+	tenants := map[string]string{}
+	containers := []map[string]interface{}{}
+	for _, sub := range store.GetSubscriptions() {
+		subMap, ok := sub.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := subMap["subscriptionId"].(string)
+		if !resourceGraphScope(req, subscriptions, id, "") {
+			continue
+		}
+		tenantID, _ := subMap["tenantId"].(string)
+		tenants[strings.ToLower(id)] = tenantID
+		arm := convertSubscriptionToARMFormat(subMap)
+		containers = append(containers, map[string]interface{}{
+			"id":             arm["id"],
+			"name":           arm["displayName"],
+			"type":           "microsoft.resources/subscriptions",
+			"tenantId":       tenantID,
+			"subscriptionId": id,
+			"resourceGroup":  "",
+			"location":       "",
+			"tags":           arm["tags"],
+			"properties": map[string]interface{}{
+				"state":                arm["state"],
+				"subscriptionPolicies": arm["subscriptionPolicies"],
+			},
+		})
+	}
+
+	for _, rg := range store.GetResourceGroups() {
+		rgMap, ok := rg.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		subscriptionID, _ := rgMap["subscriptionId"].(string)
+		name, _ := rgMap["name"].(string)
+		if _, ok := tenants[strings.ToLower(subscriptionID)]; !ok || !resourceGraphScope(req, subscriptions, subscriptionID, name) {
+			continue
+		}
+		row := convertResourceGroupToARMFormat(rgMap)
+		row["type"] = "microsoft.resources/subscriptions/resourcegroups"
+		row["location"] = NormalizeLocation(fmt.Sprint(row["location"]))
+		row["resourceGroup"] = strings.ToLower(name)
+		row["subscriptionId"] = subscriptionID
+		row["tenantId"] = tenants[strings.ToLower(subscriptionID)]
+		containers = append(containers, row)
+	}
+
+	candidates := []map[string]interface{}{}
+	for _, vm := range store.GetVMs() {
+		if vmMap, ok := vm.(map[string]interface{}); ok {
+			candidates = append(candidates, convertVMToARMFormat(vmMap))
+		}
+	}
+	if rs, ok := store.(ResourceStore); ok {
+		for _, r := range rs.GetResources() {
+			if rMap, ok := r.(map[string]interface{}); ok {
+				candidates = append(candidates, convertResourceToARMFormat(rMap, true))
+			}
+		}
+	}
+	resources := []map[string]interface{}{}
+	for _, r := range candidates {
+		id, _ := r["id"].(string)
+		rid, err := ParseResourceID(id)
+		if err != nil {
+			continue
+		}
+		if _, ok := tenants[strings.ToLower(rid.SubscriptionID)]; !ok || !resourceGraphScope(req, subscriptions, rid.SubscriptionID, rid.ResourceGroup) {
+			continue
+		}
+		r["type"] = strings.ToLower(fmt.Sprint(r["type"]))
+		if location, ok := r["location"].(string); ok {
+			r["location"] = NormalizeLocation(location)
+		}
+		r["resourceGroup"] = strings.ToLower(rid.ResourceGroup)
+		r["subscriptionId"] = rid.SubscriptionID
+		r["tenantId"] = tenants[strings.ToLower(rid.SubscriptionID)]
+		resources = append(resources, r)
+	}
+
+	return map[string]*kqlTable{
+		"Resources":          resourceGraphTable(resources),
+		"ResourceContainers": resourceGraphTable(containers),
+	}
+}
+
+// resourceGraphScope reports whether a subscription, or a resource group in it, is part of
+// the query: requested (all subscriptions when none are given) and readable by the caller
+func resourceGraphScope(req *Request, subscriptions []string, subscriptionID, resourceGroup string) bool {
+	if len(subscriptions) > 0 && !containsFold(subscriptions, subscriptionID) {
+		return false
+	}
+	if req.Principal == nil {
+		return true
+	}
+	if !req.Principal.CanAccessSubscription(subscriptionID) {
+		return false
+	}
+	return resourceGroup == "" || req.Principal.HasPermission(subscriptionID, resourceGroup, "read")
+}
+
+// resourceGraphTable turns ARM objects into table rows sorted by id. Rows go through
+// a JSON round trip so that typed maps such as tags can be indexed like dynamic values.
+func resourceGraphTable(items []map[string]interface{}) *kqlTable {
+	table := &kqlTable{columns: resourceGraphColumns}
+	for _, item := range items {
+		row, _ := normalizeJSON(item).(map[string]interface{})
+		if row == nil {
+			continue
+		}
+		table.rows = append(table.rows, row)
+	}
+	sort.Slice(table.rows, func(i, j int) bool {
+		return strings.ToLower(fmt.Sprint(table.rows[i]["id"])) < strings.ToLower(fmt.Sprint(table.rows[j]["id"]))
+	})
+	return table
+}
+
+// resourceGraphTableData returns rows in the "table" result format, typing each
+// column after its first non-null value
+func resourceGraphTableData(columns []string, rows []map[string]interface{}) map[string]interface{} {
+	columnList := []interface{}{}
+	for _, column := range columns {
+		columnType := "string"
+		for _, row := range rows {
+			if v := row[column]; v != nil {
+				switch v.(type) {
+				case float64:
+					columnType = "number"
+				case bool:
+					columnType = "boolean"
+				case map[string]interface{}, []interface{}:
+					columnType = "object"
+				}
+				break
+			}
+		}
+		columnList = append(columnList, map[string]interface{}{"name": column, "type": columnType})
+	}
+	rowList := []interface{}{}
+	for _, row := range rows {
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			values[i] = row[column]
+		}
+		rowList = append(rowList, values)
+	}
+	return map[string]interface{}{"columns": columnList, "rows": rowList}
+}
+
+// invalidResourceGraphQuery is the error Resource Graph returns for a query it cannot parse or run
+func invalidResourceGraphQuery(reason string) *ARMError {
+	return &ARMError{
+		StatusCode: http.StatusBadRequest,
+		Code:       "BadRequest",
+		Message:    "Please provide below info when asking for support: timestamp, correlationId.",
+		Details: []*ARMError{
+			{
+				Code:    "InvalidQuery",
+				Message: "Query is invalid. Please refer to the documentation for the Azure Resource Graph service and fix the error before retrying.",
+			},
+			{
+				Code:    "ParserFailure",
+				Message: reason,
+			},
+		},
+	}
+}
+
+// containsFold reports whether values contains s, ignoring case
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
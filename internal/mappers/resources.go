@@ -0,0 +1,798 @@
+package mappers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Resource types kept in typed stores rather than as generic resources
+const (
+	vmType         = "Microsoft.Compute/virtualMachines"
+	deploymentType = "Microsoft.Resources/deployments"
+)
+
+// ResourceID is a parsed ARM resource ID such as
+// /subscriptions/{sub}/resourceGroups/{rg}/providers/{namespace}/{type}/{name}[/{childType}/{childName}]
+type ResourceID struct {
+	SubscriptionID string
+	ResourceGroup  string
+	Namespace      string
+	Types          []string
+	Names          []string
+}
+
+// ParseResourceID parses a subscription- or resource-group-level provider resource ID
+func ParseResourceID(id string) (*ResourceID, error) {
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	if len(parts) < 2 || !strings.EqualFold(parts[0], "subscriptions") || parts[1] == "" {
+		return nil, fmt.Errorf("invalid resource ID: %s", id)
+	}
+	rid := &ResourceID{SubscriptionID: parts[1]}
+	parts = parts[2:]
+	if len(parts) >= 2 && strings.EqualFold(parts[0], "resourceGroups") {
+		rid.ResourceGroup = parts[1]
+		parts = parts[2:]
+	}
+	if len(parts) < 4 || !strings.EqualFold(parts[0], "providers") || len(parts)%2 != 0 {
+		return nil, fmt.Errorf("invalid resource ID: %s", id)
+	}
+	rid.Namespace = parts[1]
+	for i := 2; i < len(parts); i += 2 {
+		if parts[i] == "" || parts[i+1] == "" || strings.EqualFold(parts[i], "providers") {
+			return nil, fmt.Errorf("invalid resource ID: %s", id)
+		}
+		rid.Types = append(rid.Types, parts[i])
+		rid.Names = append(rid.Names, parts[i+1])
+	}
+	return rid, nil
+}
+
+// String returns the ID in its canonical casing
+func (r *ResourceID) String() string {
+	id := "/subscriptions/" + r.SubscriptionID
+	if r.ResourceGroup != "" {
+		id += "/resourceGroups/" + r.ResourceGroup
+	}
+	id += "/providers/" + r.Namespace
+	for i := range r.Types {
+		id += "/" + r.Types[i] + "/" + r.Names[i]
+	}
+	return id
+}
+
+// Type returns the full resource type, e.g. Microsoft.Network/virtualNetworks/subnets
+func (r *ResourceID) Type() string {
+	return r.Namespace + "/" + strings.Join(r.Types, "/")
+}
+
+// Name returns the resource's own name, the last name segment of the ID
+func (r *ResourceID) Name() string {
+	return r.Names[len(r.Names)-1]
+}
+
+// Parent returns the parent of a nested resource, or nil for top-level resources
+func (r *ResourceID) Parent() *ResourceID {
+	if len(r.Types) < 2 {
+		return nil
+	}
+	return &ResourceID{
+		SubscriptionID: r.SubscriptionID,
+		ResourceGroup:  r.ResourceGroup,
+		Namespace:      r.Namespace,
+		Types:          r.Types[:len(r.Types)-1],
+		Names:          r.Names[:len(r.Names)-1],
+	}
+}
+
+// resourceFields are the top-level ARM resource properties kept from request bodies
+var resourceFields = []string{"location", "tags", "kind", "sku", "plan", "identity", "zones", "managedBy", "extendedLocation", "properties"}
+
+// mapResourcesResponse handles the Resources_* operations over the generic resource store
+func mapResourcesResponse(req *Request, store StoreInterface) (interface{}, error) {
+	rs, ok := store.(ResourceStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support generic resources")
+	}
+
+	switch req.OperationID {
+	case "Resources_List", "Resources_ListByResourceGroup":
+		return listResources(req, store, rs)
+	}
+
+	rid, err := requestResourceID(req)
+	if err != nil {
+		return nil, err
+	}
+	// ById routes carry no subscriptionId parameter, so MapARMRequest could not check it
+	if err := checkSubscriptionAccess(rid.SubscriptionID, req.Principal, store); err != nil {
+		return nil, err
+	}
+	if rid.ResourceGroup != "" && findResourceGroup(rid.SubscriptionID, rid.ResourceGroup, store) == nil {
+		return nil, resourceGroupNotFound(rid.ResourceGroup)
+	}
+	existing := findExistingResource(rid, rs, store)
+	typed := isTypedResource(rid)
+	// VMs are created and deleted here but updated through VirtualMachines_Update; scale set
+	// instances and deployments are only written through their own routes
+	if typed && (req.Method == "PATCH" || (!isVMResource(rid) && (req.Method == "PUT" || req.Method == "DELETE"))) {
+		return nil, typedResourceOperation(rid, req.Method)
+	}
+
+	switch req.Method {
+	case "HEAD":
+		if existing == nil {
+			return &Response{StatusCode: http.StatusNotFound}, nil
+		}
+		return &Response{StatusCode: http.StatusNoContent}, nil
+
+	case "GET":
+		if existing == nil {
+			return nil, resourceNotFound(rid.Type(), rid.Name(), rid.ResourceGroup)
+		}
+		if err := authorize(req.Principal, rid, "read"); err != nil {
+			return nil, err
+		}
+		if typed {
+			return typedResourceView(rid, existing, rs), nil
+		}
+		return resourceView(convertResourceToARMFormat(existing, true), rs, store), nil
+
+	case "PUT":
+		if isVMResource(rid) {
+			body, err := decodeBody(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			status, err := putVM(req, rid, body, store)
+			if err != nil {
+				return nil, err
+			}
+			return &Response{StatusCode: status, Body: convertVMToARMFormat(findVM(rid.String(), store))}, nil
+		}
+		if err := authorize(req.Principal, rid, "write"); err != nil {
+			return nil, err
+		}
+		if parent := rid.Parent(); parent != nil && findResource(parent.String(), rs) == nil {
+			return nil, &ARMError{
+				StatusCode: http.StatusNotFound,
+				Code:       "ParentResourceNotFound",
+				Message:    fmt.Sprintf("Can not perform requested operation on nested resource. Parent resource '%s' not found.", strings.Join(parent.Names, "/")),
+			}
+		}
+		body, err := decodeBody(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		resource := map[string]interface{}{}
+		for _, field := range resourceFields {
+			if v, ok := body[field]; ok && v != nil {
+				resource[field] = v
+			}
+		}
+		resource["id"] = rid.String()
+		resource["name"] = rid.Name()
+		resource["type"] = rid.Type()
+		if err := putResource(rid, resource, rs, store); err != nil {
+			return nil, err
+		}
+		status := http.StatusOK
+		if existing == nil {
+			status = http.StatusCreated
+		}
+		return &Response{StatusCode: status, Body: resourceView(convertResourceToARMFormat(findResource(rid.String(), rs), true), rs, store)}, nil
+
+	case "PATCH":
+		if existing == nil {
+			return nil, resourceNotFound(rid.Type(), rid.Name(), rid.ResourceGroup)
+		}
+		if err := authorize(req.Principal, rid, "write"); err != nil {
+			return nil, err
+		}
+		body, err := decodeBody(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		// PATCH replaces the top-level fields it names and merges properties
+		for _, field := range resourceFields {
+			v, ok := body[field]
+			if !ok {
+				continue
+			}
+			patch, isMap := v.(map[string]interface{})
+			current, hasMap := existing[field].(map[string]interface{})
+			if field == "properties" && isMap && hasMap {
+				for k, pv := range patch {
+					current[k] = pv
+				}
+				continue
+			}
+			existing[field] = v
+		}
+		if err := putResource(rid, existing, rs, store); err != nil {
+			return nil, err
+		}
+		return resourceView(convertResourceToARMFormat(findResource(rid.String(), rs), true), rs, store), nil
+
+	case "DELETE":
+		if err := authorize(req.Principal, rid, "delete"); err != nil {
+			return nil, err
+		}
+		if err := checkDelete(rid, rs, store); err != nil {
+			return nil, err
+		}
+		if !rs.DeleteResource(rid.String()) {
+			return &Response{StatusCode: http.StatusNoContent}, nil
+		}
+		return &Response{StatusCode: http.StatusOK}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported method: %s", req.Method)
+	}
+}
+
+// putResource stores a resource, going through the resource provider for types Mockzure models
+func putResource(rid *ResourceID, resource map[string]interface{}, rs ResourceStore, store StoreInterface) error {
+	switch {
+	case isNetworkResource(rid):
+		return putNetworkResource(rid, resource, rs)
+	case isDiskResource(rid):
+		return putDiskResource(rid, resource, rs, store)
+	case isScaleSetResource(rid):
+		return putScaleSetResource(rid, resource, rs, store)
+	case isStorageAccountResource(rid):
+		return putStorageAccountResource(rid, resource, rs)
+	}
+	return rs.PutResource(resource)
+}
+
+// checkDelete refuses to delete resources their resource provider reports as in use
+func checkDelete(rid *ResourceID, rs ResourceStore, store StoreInterface) error {
+	switch {
+	case isNetworkResource(rid):
+		return checkNetworkDelete(rid, rs, store)
+	case isDiskResource(rid):
+		return checkDiskDelete(rid, rs, store)
+	}
+	return nil
+}
+
+// resourceView adds what the resource provider computes on read to the ARM view of a resource
+func resourceView(armResource map[string]interface{}, rs ResourceStore, store StoreInterface) map[string]interface{} {
+	rid, err := ParseResourceID(fmt.Sprint(armResource["id"]))
+	switch {
+	case err != nil:
+	case isNetworkResource(rid):
+		return networkView(armResource, rs, store)
+	case isDiskResource(rid):
+		return diskView(armResource, store)
+	case isStorageAccountResource(rid):
+		return storageAccountView(armResource, store)
+	}
+	return armResource
+}
+
+// requestResourceID builds the target resource ID from either route family:
+// /{resourceId} or .../providers/{resourceProviderNamespace}/{parentResourcePath}/{resourceType}/{resourceName}
+func requestResourceID(req *Request) (*ResourceID, error) {
+	id := req.Params["resourceId"]
+	if id == "" {
+		parent := strings.Trim(req.Params["parentResourcePath"], "/")
+		if parent != "" {
+			parent += "/"
+		}
+		id = fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s%s/%s",
+			req.Params["subscriptionId"], req.Params["resourceGroupName"], req.Params["resourceProviderNamespace"],
+			parent, req.Params["resourceType"], req.Params["resourceName"])
+	}
+	if !strings.HasPrefix(id, "/") {
+		id = "/" + id
+	}
+	if !strings.HasPrefix(strings.ToLower(id), "/subscriptions/") {
+		return nil, &ARMError{
+			StatusCode: http.StatusNotFound,
+			Code:       "NotFound",
+			Message:    fmt.Sprintf("No route matches the request path '%s'.", id),
+		}
+	}
+	rid, err := ParseResourceID(id)
+	if err != nil {
+		return nil, &ARMError{
+			StatusCode: http.StatusBadRequest,
+			Code:       "InvalidResourceId",
+			Message:    fmt.Sprintf("The resource ID '%s' is invalid.", id),
+		}
+	}
+	return rid, nil
+}
+
+// authorize checks the caller's permission on the resource's resource group
+func authorize(principal Principal, rid *ResourceID, permission string) error {
+	if principal == nil || principal.HasPermission(rid.SubscriptionID, rid.ResourceGroup, permission) {
+		return nil
+	}
+	return authorizationFailed(rid.Type()+"/"+permission, rid.String())
+}
+
+// decodeBody parses a JSON request body into an object
+func decodeBody(data []byte) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return body, nil
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, invalidRequestContent(err)
+	}
+	return body, nil
+}
+
+// findResource looks a generic resource up by ID, ignoring case like ARM does
+func findResource(id string, rs ResourceStore) map[string]interface{} {
+	for _, r := range rs.GetResources() {
+		if rMap, ok := r.(map[string]interface{}); ok {
+			if rid, _ := rMap["id"].(string); strings.EqualFold(rid, id) {
+				return rMap
+			}
+		}
+	}
+	return nil
+}
+
+// isVMResource reports whether a resource ID names a virtual machine
+func isVMResource(rid *ResourceID) bool {
+	return strings.EqualFold(rid.Type(), vmType)
+}
+
+// isTypedResource reports whether a resource type is kept in a store of its own rather than
+// as a generic resource
+func isTypedResource(rid *ResourceID) bool {
+	return isVMResource(rid) || strings.EqualFold(rid.Type(), scaleSetVMType) || strings.EqualFold(rid.Type(), deploymentType)
+}
+
+// findExistingResource looks a resource up wherever its type is kept, so a generic write
+// never duplicates a VM, scale set instance or deployment
+func findExistingResource(rid *ResourceID, rs ResourceStore, store StoreInterface) map[string]interface{} {
+	switch {
+	case isDiskResource(rid):
+		// A VM's disks exist before anything is stored for them
+		return findDisk(rid.String(), rs, store)
+	case isVMResource(rid):
+		return findVM(rid.String(), store)
+	case strings.EqualFold(rid.Type(), scaleSetVMType):
+		if ss, ok := store.(ScaleSetStore); ok {
+			for _, vm := range scaleSetInstances(rid.Parent().String(), ss) {
+				if id, _ := vm["id"].(string); strings.EqualFold(id, rid.String()) {
+					return vm
+				}
+			}
+		}
+		return nil
+	case strings.EqualFold(rid.Type(), deploymentType):
+		if ds, ok := store.(DeploymentStore); ok {
+			return findDeployment(rid.String(), ds)
+		}
+		return nil
+	}
+	return findResource(rid.String(), rs)
+}
+
+// typedResourceView returns the ARM view of a resource kept in a typed store
+func typedResourceView(rid *ResourceID, existing map[string]interface{}, rs ResourceStore) map[string]interface{} {
+	switch {
+	case isVMResource(rid):
+		return convertVMToARMFormat(existing)
+	case strings.EqualFold(rid.Type(), deploymentType):
+		return convertDeploymentToARMFormat(existing)
+	}
+	scaleSet := findResource(rid.Parent().String(), rs)
+	if scaleSet == nil {
+		scaleSet = map[string]interface{}{}
+	}
+	return convertScaleSetVMToARMFormat(existing, scaleSet, "")
+}
+
+// typedResourceOperation is the error for writes the generic resource routes cannot make to a
+// type kept in a typed store; those go through the type's own API
+func typedResourceOperation(rid *ResourceID, method string) *ARMError {
+	return &ARMError{
+		StatusCode: http.StatusBadRequest,
+		Code:       "InvalidResourceOperation",
+		Message:    fmt.Sprintf("The %s operation on resource type '%s' is not supported by the generic resource API. Use the resource provider's API instead.", method, rid.Type()),
+	}
+}
+
+// findVM looks a VM up by ID, ignoring case like ARM does
+func findVM(id string, store StoreInterface) map[string]interface{} {
+	for _, vm := range store.GetVMs() {
+		if vmMap, ok := vm.(map[string]interface{}); ok {
+			if vmID, _ := vmMap["id"].(string); strings.EqualFold(vmID, id) {
+				return vmMap
+			}
+		}
+	}
+	return nil
+}
+
+// findResourceGroup looks a resource group up within a subscription
+func findResourceGroup(subscriptionID, name string, store StoreInterface) map[string]interface{} {
+	for _, rg := range store.GetResourceGroups() {
+		if rgMap, ok := rg.(map[string]interface{}); ok && inSubscription(rgMap, subscriptionID) {
+			if rgName, _ := rgMap["name"].(string); strings.EqualFold(rgName, name) {
+				return rgMap
+			}
+		}
+	}
+	return nil
+}
+
+// convertResourceToARMFormat returns the ARM view of a stored resource, dropping empty fields
+func convertResourceToARMFormat(resource map[string]interface{}, withProperties bool) map[string]interface{} {
+	armResource := map[string]interface{}{
+		"id":   resource["id"],
+		"name": resource["name"],
+		"type": resource["type"],
+	}
+	for _, field := range resourceFields {
+		if field == "properties" && !withProperties {
+			continue
+		}
+		if v, ok := resource[field]; ok && v != nil {
+			armResource[field] = v
+		}
+	}
+	if withProperties {
+		properties, _ := armResource["properties"].(map[string]interface{})
+		if properties == nil {
+			properties = map[string]interface{}{}
+		}
+		if _, ok := properties["provisioningState"]; !ok {
+			properties["provisioningState"] = "Succeeded"
+		}
+		armResource["properties"] = properties
+	}
+	return armResource
+}
+
+// listResources handles Resources_List and Resources_ListByResourceGroup, including VMs
+func listResources(req *Request, store StoreInterface, rs ResourceStore) (interface{}, error) {
+	subscriptionID := req.Params["subscriptionId"]
+	resourceGroup := req.Params["resourceGroupName"]
+	if resourceGroup != "" && findResourceGroup(subscriptionID, resourceGroup, store) == nil {
+		return nil, resourceGroupNotFound(resourceGroup)
+	}
+
+	filter, err := parseResourceFilter(req.Params["$filter"])
+	if err != nil {
+		return nil, err
+	}
+	expand := strings.Contains(strings.ToLower(req.Params["$expand"]), "properties")
+
+	candidates := []map[string]interface{}{}
+	for _, r := range rs.GetResources() {
+		if rMap, ok := r.(map[string]interface{}); ok {
+			candidates = append(candidates, convertResourceToARMFormat(rMap, expand))
+		}
+	}
+	for _, vm := range store.GetVMs() {
+		if vmMap, ok := vm.(map[string]interface{}); ok {
+			armVM := convertVMToARMFormat(vmMap)
+			if !expand {
+				delete(armVM, "properties")
+			}
+			candidates = append(candidates, armVM)
+		}
+	}
+
+	resources := []map[string]interface{}{}
+	for _, r := range candidates {
+		id, _ := r["id"].(string)
+		rid, err := ParseResourceID(id)
+		if err != nil || !strings.EqualFold(rid.SubscriptionID, subscriptionID) {
+			continue
+		}
+		if resourceGroup != "" && !strings.EqualFold(rid.ResourceGroup, resourceGroup) {
+			continue
+		}
+		if req.Principal != nil && !req.Principal.HasPermission(rid.SubscriptionID, rid.ResourceGroup, "read") {
+			continue
+		}
+		if filter.matches(r, rid) {
+			resources = append(resources, r)
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return strings.ToLower(fmt.Sprint(resources[i]["id"])) < strings.ToLower(fmt.Sprint(resources[j]["id"]))
+	})
+
+	if top, err := strconv.Atoi(req.Params["$top"]); err == nil && top >= 0 && top < len(resources) {
+		resources = resources[:top]
+	}
+
+	value := make([]interface{}, len(resources))
+	for i, r := range resources {
+		value[i] = r
+	}
+	return map[string]interface{}{
+		"value": value,
+	}, nil
+}
+
+// resourceFilter is a parsed Resources_List $filter: clauses joined by "and"
+type resourceFilter struct {
+	clauses []filterClause
+}
+
+// filterClause is one comparison of a $filter expression
+type filterClause struct {
+	op    string // eq, substringof or startswith
+	field string
+	value string
+}
+
+var (
+	filterAndPattern        = regexp.MustCompile(`(?i)\s+and\s+`)
+	filterEqPattern         = regexp.MustCompile(`(?i)^(\w+)\s+eq\s+'((?:[^']|'')*)'$`)
+	filterSubstringPattern  = regexp.MustCompile(`(?i)^substringof\(\s*'((?:[^']|'')*)'\s*,\s*(\w+)\s*\)$`)
+	filterStartsWithPattern = regexp.MustCompile(`(?i)^startswith\(\s*(\w+)\s*,\s*'((?:[^']|'')*)'\s*\)$`)
+)
+
+// filterFields are the properties ARM supports in Resources_List $filter
+var filterFields = map[string]string{
+	"resourcetype":  "resourceType",
+	"name":          "name",
+	"location":      "location",
+	"resourcegroup": "resourceGroup",
+	"tagname":       "tagName",
+	"tagvalue":      "tagValue",
+}
+
+// parseResourceFilter parses the subset of OData ARM accepts on Resources_List
+func parseResourceFilter(expr string) (*resourceFilter, error) {
+	filter := &resourceFilter{}
+	if strings.TrimSpace(expr) == "" {
+		return filter, nil
+	}
+	for _, part := range filterAndPattern.Split(strings.TrimSpace(expr), -1) {
+		part = strings.TrimSpace(part)
+		var clause filterClause
+		if m := filterEqPattern.FindStringSubmatch(part); m != nil {
+			clause = filterClause{op: "eq", field: m[1], value: m[2]}
+		} else if m := filterSubstringPattern.FindStringSubmatch(part); m != nil {
+			clause = filterClause{op: "substringof", field: m[2], value: m[1]}
+		} else if m := filterStartsWithPattern.FindStringSubmatch(part); m != nil {
+			clause = filterClause{op: "startswith", field: m[1], value: m[2]}
+		} else {
+			return nil, invalidFilter(expr)
+		}
+		field, ok := filterFields[strings.ToLower(clause.field)]
+		if !ok {
+			return nil, invalidFilter(expr)
+		}
+		clause.field = field
+		clause.value = strings.ReplaceAll(clause.value, "''", "'")
+		filter.clauses = append(filter.clauses, clause)
+	}
+	return filter, nil
+}
+
+// invalidFilter returns the error ARM reports for unsupported $filter expressions
+func invalidFilter(expr string) *ARMError {
+	return &ARMError{
+		StatusCode: http.StatusBadRequest,
+		Code:       "InvalidFilterInQueryString",
+		Message:    fmt.Sprintf("Invalid $filter '%s' specified in the query string.", expr),
+	}
+}
+
+// matches reports whether a resource satisfies every clause.
+// A tagValue clause applies to the tag named by a tagName clause, or to any tag without one.
+func (f *resourceFilter) matches(resource map[string]interface{}, rid *ResourceID) bool {
+	tags := resourceTags(resource)
+	tagName := ""
+	for _, c := range f.clauses {
+		if c.field == "tagName" {
+			tagName = c.value
+		}
+	}
+
+	for _, c := range f.clauses {
+		switch c.field {
+		case "tagName":
+			found := false
+			for k := range tags {
+				if compareFilter(c.op, k, c.value) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		case "tagValue":
+			found := false
+			for k, v := range tags {
+				if (tagName == "" || strings.EqualFold(k, tagName)) && compareFilter(c.op, v, c.value) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		case "resourceType":
+			if !compareFilter(c.op, rid.Type(), c.value) {
+				return false
+			}
+		case "name":
+			if !compareFilter(c.op, rid.Name(), c.value) {
+				return false
+			}
+		case "resourceGroup":
+			if !compareFilter(c.op, rid.ResourceGroup, c.value) {
+				return false
+			}
+		case "location":
+			location, _ := resource["location"].(string)
+			if !compareFilter(c.op, NormalizeLocation(location), NormalizeLocation(c.value)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compareFilter applies a filter operator case-insensitively
+func compareFilter(op, actual, expected string) bool {
+	actual, expected = strings.ToLower(actual), strings.ToLower(expected)
+	switch op {
+	case "substringof":
+		return strings.Contains(actual, expected)
+	case "startswith":
+		return strings.HasPrefix(actual, expected)
+	default:
+		return actual == expected
+	}
+}
+
+// resourceTags returns a resource's tags whichever map type they are stored as
+func resourceTags(resource map[string]interface{}) map[string]string {
+	tags := map[string]string{}
+	switch t := resource["tags"].(type) {
+	case map[string]string:
+		for k, v := range t {
+			tags[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range t {
+			tags[k] = fmt.Sprint(v)
+		}
+	}
+	return tags
+}
+
+// mapTagsResponse handles the Tags_*AtScope operations on /{scope}/providers/Microsoft.Resources/tags/default
+func mapTagsResponse(req *Request, store StoreInterface) (interface{}, error) {
+	rs, ok := store.(ResourceStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support generic resources")
+	}
+
+	scope := "/" + strings.Trim(req.Params["scope"], "/")
+	subscriptionID, resourceGroup := scopeParts(scope)
+	if subscriptionID == "" {
+		return nil, &ARMError{
+			StatusCode: http.StatusBadRequest,
+			Code:       "InvalidScope",
+			Message:    fmt.Sprintf("The scope '%s' is invalid.", scope),
+		}
+	}
+	if err := checkSubscriptionAccess(subscriptionID, req.Principal, store); err != nil {
+		return nil, err
+	}
+	tags, found := scopeTags(scope, store, rs)
+	if !found {
+		return nil, &ARMError{
+			StatusCode: http.StatusNotFound,
+			Code:       "ResourceNotFound",
+			Message:    fmt.Sprintf("The resource '%s' was not found.", scope),
+		}
+	}
+
+	if req.Method != "GET" {
+		if req.Principal != nil && !req.Principal.HasPermission(subscriptionID, resourceGroup, "write") {
+			return nil, authorizationFailed("Microsoft.Resources/tags/write", scope)
+		}
+		body, err := decodeBody(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		requested := map[string]string{}
+		if properties, ok := body["properties"].(map[string]interface{}); ok {
+			requested = resourceTags(properties)
+		}
+
+		switch req.Method {
+		case "PUT":
+			tags = requested
+		case "PATCH":
+			operation, _ := body["operation"].(string)
+			switch strings.ToLower(operation) {
+			case "merge":
+				for k, v := range requested {
+					tags[k] = v
+				}
+			case "replace":
+				tags = requested
+			case "delete":
+				for k, v := range requested {
+					if current, ok := tags[k]; ok && (v == "" || current == v) {
+						delete(tags, k)
+					}
+				}
+			default:
+				return nil, &ARMError{
+					StatusCode: http.StatusBadRequest,
+					Code:       "InvalidTagOperation",
+					Message:    fmt.Sprintf("The tag operation '%s' is invalid. Supported operations are Merge, Replace and Delete.", operation),
+				}
+			}
+		case "DELETE":
+			rs.SetTags(scope, map[string]string{})
+			return &Response{StatusCode: http.StatusOK}, nil
+		default:
+			return nil, fmt.Errorf("unsupported method: %s", req.Method)
+		}
+		rs.SetTags(scope, tags)
+	}
+
+	return map[string]interface{}{
+		"id":   scope + "/providers/Microsoft.Resources/tags/default",
+		"name": "default",
+		"type": "Microsoft.Resources/tags",
+		"properties": map[string]interface{}{
+			"tags": tags,
+		},
+	}, nil
+}
+
+// scopeParts extracts the subscription and resource group from a scope
+func scopeParts(scope string) (string, string) {
+	parts := strings.Split(strings.Trim(scope, "/"), "/")
+	if len(parts) < 2 || !strings.EqualFold(parts[0], "subscriptions") {
+		return "", ""
+	}
+	if len(parts) >= 4 && strings.EqualFold(parts[2], "resourceGroups") {
+		return parts[1], parts[3]
+	}
+	return parts[1], ""
+}
+
+// scopeTags returns the tags of a subscription, resource group, VM or generic resource
+func scopeTags(scope string, store StoreInterface, rs ResourceStore) (map[string]string, bool) {
+	subscriptionID, resourceGroup := scopeParts(scope)
+	parts := strings.Split(strings.Trim(scope, "/"), "/")
+	switch {
+	case len(parts) == 2:
+		if sub := findSubscription(subscriptionID, store); sub != nil {
+			return resourceTags(sub), true
+		}
+	case len(parts) == 4 && resourceGroup != "":
+		if rg := findResourceGroup(subscriptionID, resourceGroup, store); rg != nil {
+			return resourceTags(rg), true
+		}
+	default:
+		if r := findResource(scope, rs); r != nil {
+			return resourceTags(r), true
+		}
+		for _, vm := range store.GetVMs() {
+			if vmMap, ok := vm.(map[string]interface{}); ok {
+				if id, _ := vmMap["id"].(string); strings.EqualFold(id, scope) {
+					return resourceTags(vmMap), true
+				}
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,314 @@
+package mappers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Run commands and custom script extensions do not run anything. Their output comes from the
+// responders in the config, the first of which matching the VM, command and script wins; an
+// unmatched script succeeds without output. Every invocation is recorded in the RunCommandStore.
+// See https://learn.microsoft.com/azure/virtual-machines/run-command-overview
+
+const (
+	runShellScript         = "RunShellScript"
+	runPowerShellScript    = "RunPowerShellScript"
+	customScriptCommandID  = "CustomScript"
+	linuxCustomScript      = "Microsoft.Azure.Extensions.CustomScript"
+	windowsCustomScript    = "Microsoft.Compute.CustomScriptExtension"
+	vmNotRunningForCommand = "The operation requires the VM to be running (or set to run)."
+)
+
+// runCommandInput is the body of VirtualMachines_RunCommand
+type runCommandInput struct {
+	CommandID  string   `json:"commandId"`
+	Script     []string `json:"script"`
+	Parameters []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"parameters"`
+}
+
+// runCommandOutput is what a responder scripts a command to print
+type runCommandOutput struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// runCommandResponse picks the output of a script run on a VM from the configured responders
+func runCommandResponse(store StoreInterface, vm map[string]interface{}, commandID string, script []string) runCommandOutput {
+	rcs, ok := store.(RunCommandStore)
+	if !ok {
+		return runCommandOutput{}
+	}
+	text := strings.Join(script, "\n")
+	for _, item := range rcs.GetRunCommandResponders() {
+		responder, _ := item.(map[string]interface{})
+		if target, _ := responder["vm"].(string); target != "" &&
+			!strings.EqualFold(target, fmt.Sprint(vm["name"])) && !strings.EqualFold(target, fmt.Sprint(vm["id"])) {
+			continue
+		}
+		if id, _ := responder["commandId"].(string); id != "" && !strings.EqualFold(id, commandID) {
+			continue
+		}
+		if pattern, _ := responder["script"].(string); pattern != "" {
+			// Patterns are validated when the config is loaded
+			re, err := regexp.Compile(pattern)
+			if err != nil || !re.MatchString(text) {
+				continue
+			}
+		}
+		stdout, _ := responder["stdout"].(string)
+		stderr, _ := responder["stderr"].(string)
+		return runCommandOutput{Stdout: stdout, Stderr: stderr, ExitCode: intValue(responder["exitCode"])}
+	}
+	return runCommandOutput{}
+}
+
+// recordRunCommand records an invocation of a script on a VM
+func recordRunCommand(store StoreInterface, vm map[string]interface{}, commandID string, script []string, parameters map[string]string, output runCommandOutput) error {
+	rcs, ok := store.(RunCommandStore)
+	if !ok {
+		return nil
+	}
+	return rcs.RecordRunCommand(map[string]interface{}{
+		"id":         newGUID(),
+		"vmId":       vm["id"],
+		"vmName":     vm["name"],
+		"commandId":  commandID,
+		"script":     script,
+		"parameters": parameters,
+		"stdout":     output.Stdout,
+		"stderr":     output.Stderr,
+		"exitCode":   output.ExitCode,
+		"invokedAt":  time.Now().UTC(),
+	})
+}
+
+// runCommandStatuses returns the statuses a script reports, as Azure's Linux and Windows agents
+// word them: Linux in one status with both streams, Windows in one status per stream
+func runCommandStatuses(osType string, output runCommandOutput) []interface{} {
+	if strings.EqualFold(osType, "windows") {
+		stderrCode := "ComponentStatus/StdErr/succeeded"
+		if output.ExitCode != 0 {
+			stderrCode = "ComponentStatus/StdErr/failed"
+		}
+		stdout := vmStatus("ComponentStatus/StdOut/succeeded", "Provisioning succeeded")
+		stdout["message"] = output.Stdout
+		stderr := vmStatus(stderrCode, "Provisioning succeeded")
+		stderr["message"] = output.Stderr
+		return []interface{}{stdout, stderr}
+	}
+	status := vmStatus("ProvisioningState/succeeded", "Provisioning succeeded")
+	status["message"] = fmt.Sprintf("Enable succeeded: \n[stdout]\n%s\n[stderr]\n%s\n", output.Stdout, output.Stderr)
+	if output.ExitCode != 0 {
+		status = vmStatus("ProvisioningState/failed", "Provisioning failed")
+		status["level"] = "Error"
+		status["message"] = fmt.Sprintf("Enable failed: failed to execute command: command terminated with exit status=%d\n[stdout]\n%s\n[stderr]\n%s\n",
+			output.ExitCode, output.Stdout, output.Stderr)
+	}
+	return []interface{}{status}
+}
+
+// mapVirtualMachineRunCommandResponse handles VirtualMachines_RunCommand. The command's output
+// is the result of its long-running operation.
+func mapVirtualMachineRunCommandResponse(req *Request, store StoreInterface) (interface{}, error) {
+	rid, err := ParseResourceID(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+		req.Params["subscriptionId"], req.Params["resourceGroupName"], req.Params["vmName"]))
+	if err != nil {
+		return nil, err
+	}
+	vm := findVM(rid.String(), store)
+	if vm == nil {
+		return nil, resourceNotFound("Microsoft.Compute/virtualMachines", rid.Name(), rid.ResourceGroup)
+	}
+	if req.Principal != nil && !req.Principal.HasPermission(rid.SubscriptionID, rid.ResourceGroup, "write") {
+		return nil, authorizationFailed(rid.Type()+"/runCommand/action", rid.String())
+	}
+	var input runCommandInput
+	if len(req.Body) > 0 {
+		if err := json.Unmarshal(req.Body, &input); err != nil {
+			return nil, invalidRequestContent(err)
+		}
+	}
+	if input.CommandID == "" {
+		return nil, invalidComputeParameter("commandId", "Required parameter 'commandId' is missing (null).")
+	}
+	// Each OS has its own script command
+	osType, _ := vm["osType"].(string)
+	commandID := runShellScript
+	if strings.EqualFold(osType, "windows") {
+		commandID = runPowerShellScript
+	}
+	if !strings.EqualFold(input.CommandID, commandID) {
+		return nil, &ARMError{StatusCode: http.StatusNotFound, Code: "NotFound", Target: "commandId", Message: "The entity was not found in this Azure location."}
+	}
+	if vm["status"] != "running" {
+		return nil, computeOperationNotAllowed(vmNotRunningForCommand)
+	}
+
+	parameters := map[string]string{}
+	for _, p := range input.Parameters {
+		parameters[p.Name] = p.Value
+	}
+	output := runCommandResponse(store, vm, commandID, input.Script)
+	if err := recordRunCommand(store, vm, commandID, input.Script, parameters, output); err != nil {
+		return nil, err
+	}
+	return acceptOperation(req, store, rid.SubscriptionID, &Operation{
+		StatusCode: http.StatusOK,
+		Body:       map[string]interface{}{"value": runCommandStatuses(osType, output)},
+	})
+}
+
+// isCustomScriptExtension reports whether an extension runs a custom script
+func isCustomScriptExtension(publisher, extensionType string) bool {
+	name := publisher + "." + extensionType
+	return strings.EqualFold(name, linuxCustomScript) || strings.EqualFold(name, windowsCustomScript)
+}
+
+// mapVMExtensionsResponse handles VirtualMachineExtensions_List, _Get, _CreateOrUpdate and _Delete.
+// Extensions are kept in the VM profile; custom script extensions run their commandToExecute
+// through the run command responders and report its output in their instance view.
+func mapVMExtensionsResponse(req *Request, store StoreInterface) (interface{}, error) {
+	vs, ok := store.(VMStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support updating virtual machines")
+	}
+	rid, err := ParseResourceID(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+		req.Params["subscriptionId"], req.Params["resourceGroupName"], req.Params["vmName"]))
+	if err != nil {
+		return nil, err
+	}
+	vm := findVM(rid.String(), store)
+	if vm == nil {
+		return nil, resourceNotFound("Microsoft.Compute/virtualMachines", rid.Name(), rid.ResourceGroup)
+	}
+	name := req.Params["vmExtensionName"]
+	extensions, _ := vm["extensions"].([]interface{})
+	index := -1
+	for i, e := range extensions {
+		if ext, ok := e.(map[string]interface{}); ok && strings.EqualFold(fmt.Sprint(ext["name"]), name) {
+			index = i
+		}
+	}
+	find := func() interface{} {
+		for _, ext := range vmExtensions(findVM(rid.String(), store)) {
+			if strings.EqualFold(fmt.Sprint(ext.(map[string]interface{})["name"]), name) {
+				return ext
+			}
+		}
+		return nil
+	}
+
+	switch req.OperationID {
+	case "VirtualMachineExtensions_List":
+		if err := authorize(req.Principal, rid, "read"); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"value": vmExtensions(vm)}, nil
+
+	case "VirtualMachineExtensions_Get":
+		if err := authorize(req.Principal, rid, "read"); err != nil {
+			return nil, err
+		}
+		if index < 0 {
+			return nil, resourceNotFound(vmExtensionType, rid.Name()+"/"+name, rid.ResourceGroup)
+		}
+		return find(), nil
+
+	case "VirtualMachineExtensions_Delete":
+		if err := authorize(req.Principal, rid, "write"); err != nil {
+			return nil, err
+		}
+		if index < 0 {
+			return &Response{StatusCode: http.StatusNoContent}, nil
+		}
+		if vm["status"] != "running" {
+			return nil, computeOperationNotAllowed("Cannot modify extensions in the VM when the VM is not running.")
+		}
+		vm["extensions"] = append(extensions[:index:index], extensions[index+1:]...)
+		if err := vs.PutVM(vm); err != nil {
+			return nil, err
+		}
+		return acceptOperation(req, store, rid.SubscriptionID, &Operation{})
+	}
+
+	if err := authorize(req.Principal, rid, "write"); err != nil {
+		return nil, err
+	}
+	body, err := decodeBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if vm["status"] != "running" {
+		return nil, computeOperationNotAllowed("Cannot modify extensions in the VM when the VM is not running.")
+	}
+	properties, _ := body["properties"].(map[string]interface{})
+	publisher, _ := properties["publisher"].(string)
+	extensionType, _ := properties["type"].(string)
+	if publisher == "" || extensionType == "" {
+		return nil, invalidComputeParameter("properties.publisher", "Required parameters 'publisher' and 'type' are missing (null).")
+	}
+	// Protected settings are used, but never stored or returned
+	ext := map[string]interface{}{
+		"name":               name,
+		"publisher":          publisher,
+		"type":               extensionType,
+		"typeHandlerVersion": properties["typeHandlerVersion"],
+		"provisioningState":  "Succeeded",
+	}
+	settings, _ := properties["settings"].(map[string]interface{})
+	if settings != nil {
+		ext["settings"] = settings
+	}
+
+	var failed *runCommandOutput
+	if isCustomScriptExtension(publisher, extensionType) {
+		protected, _ := properties["protectedSettings"].(map[string]interface{})
+		command, _ := protected["commandToExecute"].(string)
+		if command == "" {
+			command, _ = settings["commandToExecute"].(string)
+		}
+		if command == "" {
+			return nil, invalidComputeParameter("properties.settings.commandToExecute", "The custom script extension requires 'commandToExecute' in its settings or protected settings.")
+		}
+		output := runCommandResponse(store, vm, customScriptCommandID, []string{command})
+		if err := recordRunCommand(store, vm, customScriptCommandID, []string{command}, nil, output); err != nil {
+			return nil, err
+		}
+		ext["stdout"] = output.Stdout
+		ext["stderr"] = output.Stderr
+		if output.ExitCode != 0 {
+			ext["provisioningState"] = "Failed"
+			ext["exitCode"] = output.ExitCode
+			failed = &output
+		}
+	}
+
+	status := http.StatusCreated
+	if index >= 0 {
+		status = http.StatusOK
+		extensions[index] = ext
+	} else {
+		extensions = append(extensions, ext)
+	}
+	vm["extensions"] = extensions
+	if err := vs.PutVM(vm); err != nil {
+		return nil, err
+	}
+	if failed != nil {
+		return nil, &ARMError{
+			StatusCode: http.StatusConflict,
+			Code:       "VMExtensionProvisioningError",
+			Message: fmt.Sprintf("VM has reported a failure when processing extension '%s' (publisher '%s' and type '%s'). Error message: 'Enable failed: failed to execute command: command terminated with exit status=%d\n[stdout]\n%s\n[stderr]\n%s\n'.",
+				name, publisher, extensionType, failed.ExitCode, failed.Stdout, failed.Stderr),
+		}
+	}
+	return &Response{StatusCode: status, Body: find()}, nil
+}
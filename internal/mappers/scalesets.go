@@ -0,0 +1,546 @@
+package mappers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Virtual machine scale sets are kept in the generic resource store, like disks, and their
+// instances in the ScaleSetStore. Setting sku.capacity creates or removes instances, built from
+// the scale set's virtualMachineProfile; instances take the same power actions as standalone
+// VMs. Only the Uniform orchestration mode is modelled.
+// See https://learn.microsoft.com/rest/api/compute/virtual-machine-scale-sets
+
+const (
+	scaleSetType               = "Microsoft.Compute/virtualMachineScaleSets"
+	scaleSetVMType             = scaleSetType + "/virtualMachines"
+	maxScaleSetCapacity        = 1000
+	defaultScaleSetUpgradeMode = "Manual"
+	scaleSetOrchestrationMode  = "Uniform"
+)
+
+// scaleSetUpgradeModes are the upgrade policy modes a scale set can have
+var scaleSetUpgradeModes = []string{"Manual", "Automatic", "Rolling"}
+
+// scaleSetScaleInRules are the scale-in policy rules a scale set can have
+var scaleSetScaleInRules = []string{"Default", "NewestVM", "OldestVM"}
+
+// scaleSetUpgradeRequest is the body of VirtualMachineScaleSets_ManualUpgrade
+type scaleSetUpgradeRequest struct {
+	InstanceIDs []string `json:"instanceIds"`
+}
+
+// isScaleSetResource reports whether a resource ID is a virtual machine scale set
+func isScaleSetResource(rid *ResourceID) bool {
+	return strings.EqualFold(rid.Type(), scaleSetType)
+}
+
+// putScaleSetResource validates a scale set, stores it and scales its instances to
+// sku.capacity. A changed model is rolled out to the instances unless the upgrade policy
+// is Manual, in which case they report latestModelApplied false until upgraded.
+func putScaleSetResource(rid *ResourceID, resource map[string]interface{}, rs ResourceStore, store StoreInterface) error {
+	ss, ok := store.(ScaleSetStore)
+	if !ok {
+		return fmt.Errorf("store does not support scale sets")
+	}
+	existing := findResource(rid.String(), rs)
+
+	sku, _ := resource["sku"].(map[string]interface{})
+	if sku == nil {
+		sku = map[string]interface{}{}
+		resource["sku"] = sku
+	}
+	// An update may send only the capacity
+	if existing != nil {
+		current, _ := existing["sku"].(map[string]interface{})
+		for _, key := range []string{"name", "tier", "capacity"} {
+			if sku[key] == nil && current[key] != nil {
+				sku[key] = current[key]
+			}
+		}
+	}
+	if name, _ := sku["name"].(string); name == "" {
+		return invalidComputeParameter("sku.name", "Required parameter 'sku.name' is missing (null).")
+	}
+	if sku["capacity"] == nil {
+		return invalidComputeParameter("sku.capacity", "Required parameter 'sku.capacity' is missing (null).")
+	}
+	capacity := -1.0
+	switch c := sku["capacity"].(type) {
+	case float64:
+		capacity = c
+	case int:
+		capacity = float64(c)
+	}
+	if capacity != float64(int(capacity)) || capacity < 0 || capacity > maxScaleSetCapacity {
+		return invalidComputeParameter("sku.capacity", fmt.Sprintf("The value %v of parameter 'sku.capacity' is not valid. The capacity must be from 0 to %d.", sku["capacity"], maxScaleSetCapacity))
+	}
+	setDefault(sku, "tier", "Standard")
+
+	props, _ := resource["properties"].(map[string]interface{})
+	if props == nil {
+		props = map[string]interface{}{}
+		resource["properties"] = props
+	}
+	setDefault(props, "orchestrationMode", scaleSetOrchestrationMode)
+	if mode := fmt.Sprint(props["orchestrationMode"]); !strings.EqualFold(mode, scaleSetOrchestrationMode) {
+		return invalidComputeParameter("orchestrationMode", fmt.Sprintf("The orchestration mode '%s' is not supported. Only Uniform scale sets are supported.", mode))
+	}
+	upgradePolicy, _ := props["upgradePolicy"].(map[string]interface{})
+	if upgradePolicy == nil {
+		upgradePolicy = map[string]interface{}{}
+		props["upgradePolicy"] = upgradePolicy
+	}
+	setDefault(upgradePolicy, "mode", defaultScaleSetUpgradeMode)
+	if mode := fmt.Sprint(upgradePolicy["mode"]); !containsFold(scaleSetUpgradeModes, mode) {
+		return invalidComputeParameter("upgradePolicy.mode", fmt.Sprintf("The value '%s' of parameter 'upgradePolicy.mode' is not allowed. Allowed values are %s.", mode, strings.Join(scaleSetUpgradeModes, ", ")))
+	}
+	scaleInPolicy, _ := props["scaleInPolicy"].(map[string]interface{})
+	if rules, _ := scaleInPolicy["rules"].([]interface{}); len(rules) > 0 {
+		for _, rule := range rules {
+			if !containsFold(scaleSetScaleInRules, fmt.Sprint(rule)) {
+				return invalidComputeParameter("scaleInPolicy.rules", fmt.Sprintf("The scale-in rule '%v' is not allowed. Allowed values are %s.", rule, strings.Join(scaleSetScaleInRules, ", ")))
+			}
+		}
+	}
+	profile, _ := props["virtualMachineProfile"].(map[string]interface{})
+	if profile == nil {
+		return invalidComputeParameter("virtualMachineProfile", "Required parameter 'virtualMachineProfile' is missing (null).")
+	}
+	osProfile, _ := profile["osProfile"].(map[string]interface{})
+	if osProfile == nil {
+		osProfile = map[string]interface{}{}
+		profile["osProfile"] = osProfile
+	}
+	setDefault(osProfile, "computerNamePrefix", rid.Name())
+
+	// Plan the scale-in before anything is stored, so a refused update changes nothing
+	instances := scaleSetInstances(rid.String(), ss)
+	removed, err := scaleInInstances(resource, instances, len(instances)-int(capacity))
+	if err != nil {
+		return err
+	}
+	if err := checkScaleSetCapacity(rid, resource, instances, removed, store); err != nil {
+		return err
+	}
+
+	if existing != nil {
+		current, _ := existing["properties"].(map[string]interface{})
+		for _, key := range []string{"uniqueId", "timeCreated"} {
+			if current[key] != nil {
+				props[key] = current[key]
+			}
+		}
+	}
+	setDefault(props, "uniqueId", deterministicGUID(rid.String()))
+	setDefault(props, "timeCreated", time.Now().UTC().Format(time.RFC3339Nano))
+	props["provisioningState"] = "Succeeded"
+	if err := rs.PutResource(resource); err != nil {
+		return err
+	}
+
+	for _, vm := range removed {
+		ss.DeleteScaleSetVM(fmt.Sprint(vm["id"]))
+	}
+	if existing != nil && scaleSetModel(existing) != scaleSetModel(resource) {
+		automatic := !strings.EqualFold(fmt.Sprint(upgradePolicy["mode"]), "Manual")
+		for _, vm := range scaleSetInstances(rid.String(), ss) {
+			if automatic && !scaleSetActionsProtected(vm) {
+				applyScaleSetModel(vm, resource)
+			} else {
+				vm["latestModelApplied"] = false
+			}
+			if _, err := ss.PutScaleSetVM(vm); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(instances) - len(removed); i < int(capacity); i++ {
+		vm := map[string]interface{}{
+			"scaleSetId":     rid.String(),
+			"resourceGroup":  rid.ResourceGroup,
+			"subscriptionId": rid.SubscriptionID,
+		}
+		applyScaleSetModel(vm, resource)
+		if _, err := ss.PutScaleSetVM(vm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkScaleSetCapacity checks the scale set's size is offered in its location and zones, and that
+// the instances running that size after the update fit the quotas. Instances already at the size,
+// or upgraded to it, are counted once; with a Manual upgrade policy the others keep their size.
+func checkScaleSetCapacity(rid *ResourceID, scaleSet map[string]interface{}, instances, removed []map[string]interface{}, store StoreInterface) error {
+	sku, _ := scaleSet["sku"].(map[string]interface{})
+	size := fmt.Sprint(sku["name"])
+	props, _ := scaleSet["properties"].(map[string]interface{})
+	upgradePolicy, _ := props["upgradePolicy"].(map[string]interface{})
+	automatic := !strings.EqualFold(fmt.Sprint(upgradePolicy["mode"]), "Manual")
+
+	exclude := map[string]bool{}
+	for _, vm := range removed {
+		exclude[strings.ToLower(fmt.Sprint(vm["id"]))] = true
+	}
+	count := intValue(sku["capacity"]) - (len(instances) - len(removed))
+	for _, vm := range instances {
+		id := strings.ToLower(fmt.Sprint(vm["id"]))
+		if exclude[id] {
+			continue
+		}
+		if strings.EqualFold(fmt.Sprint(vm["vmSize"]), size) || (automatic && !scaleSetActionsProtected(vm)) {
+			exclude[id] = true
+			count++
+		}
+	}
+	return checkVMCapacity(store, rid.SubscriptionID, fmt.Sprint(scaleSet["location"]), size, zoneStrings(scaleSet["zones"]), count, exclude)
+}
+
+// scaleInInstances picks the instances to remove when a scale set shrinks by count, following
+// its scale-in policy. Protected instances are never picked.
+func scaleInInstances(scaleSet map[string]interface{}, instances []map[string]interface{}, count int) ([]map[string]interface{}, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	props, _ := scaleSet["properties"].(map[string]interface{})
+	scaleInPolicy, _ := props["scaleInPolicy"].(map[string]interface{})
+	rules, _ := scaleInPolicy["rules"].([]interface{})
+	// Instance IDs only grow, so the newest instances have the highest IDs
+	candidates := []map[string]interface{}{}
+	for i := len(instances) - 1; i >= 0; i-- {
+		candidates = append(candidates, instances[i])
+	}
+	if len(rules) > 0 && strings.EqualFold(fmt.Sprint(rules[0]), "OldestVM") {
+		candidates = instances
+	}
+	removed := []map[string]interface{}{}
+	for _, vm := range candidates {
+		if len(removed) == count {
+			break
+		}
+		policy, _ := vm["protectionPolicy"].(map[string]interface{})
+		if policy["protectFromScaleIn"] == true || policy["protectFromScaleSetActions"] == true {
+			continue
+		}
+		removed = append(removed, vm)
+	}
+	if len(removed) < count {
+		return nil, computeOperationNotAllowed(fmt.Sprintf("The scale set cannot be scaled in by %d instances because only %d instances are not protected from scale-in.", count, len(removed)))
+	}
+	return removed, nil
+}
+
+// scaleSetModel returns a key identifying the model instances are built from: the VM size
+// and the virtual machine profile
+func scaleSetModel(scaleSet map[string]interface{}) string {
+	sku, _ := scaleSet["sku"].(map[string]interface{})
+	props, _ := scaleSet["properties"].(map[string]interface{})
+	data, _ := json.Marshal([]interface{}{sku["name"], props["virtualMachineProfile"]})
+	return string(data)
+}
+
+// applyScaleSetModel brings the store view of an instance up to the scale set's model.
+// The computer name, NICs and zone follow from the instance ID, see scaleSetVMView.
+func applyScaleSetModel(vm, scaleSet map[string]interface{}) {
+	sku, _ := scaleSet["sku"].(map[string]interface{})
+	props, _ := scaleSet["properties"].(map[string]interface{})
+	profile, _ := props["virtualMachineProfile"].(map[string]interface{})
+	for key, v := range vmProfileFromARM(profile) {
+		if key != "networkInterfaces" {
+			vm[key] = v
+		}
+	}
+	if osProfile, ok := vm["osProfile"].(map[string]interface{}); ok {
+		delete(osProfile, "computerName")
+	}
+	storage, _ := profile["storageProfile"].(map[string]interface{})
+	osDisk, _ := storage["osDisk"].(map[string]interface{})
+	image, _ := storage["imageReference"].(map[string]interface{})
+	osType, _ := osDisk["osType"].(string)
+	if osType == "" {
+		osType = "linux"
+		if publisher, _ := image["publisher"].(string); strings.Contains(strings.ToLower(publisher), "windows") {
+			osType = "windows"
+		}
+	}
+	vm["osType"] = strings.ToLower(osType)
+	vm["vmSize"] = sku["name"]
+	vm["location"] = NormalizeLocation(fmt.Sprint(scaleSet["location"]))
+	vm["tags"] = scaleSet["tags"]
+	vm["latestModelApplied"] = true
+}
+
+// scaleSetVMView adds what an instance derives from its scale set and instance ID to its
+// store view: the computer name, network interfaces and zone
+func scaleSetVMView(vm, scaleSet map[string]interface{}) map[string]interface{} {
+	id, _ := vm["id"].(string)
+	instanceID, _ := strconv.Atoi(fmt.Sprint(vm["instanceId"]))
+	props, _ := scaleSet["properties"].(map[string]interface{})
+	profile, _ := props["virtualMachineProfile"].(map[string]interface{})
+
+	osProfile, _ := vm["osProfile"].(map[string]interface{})
+	if osProfile == nil {
+		osProfile = map[string]interface{}{}
+		vm["osProfile"] = osProfile
+	}
+	scaleSetOSProfile, _ := profile["osProfile"].(map[string]interface{})
+	// Azure appends the instance ID in base 36, padded to six characters
+	suffix := strconv.FormatInt(int64(instanceID), 36)
+	osProfile["computerName"] = fmt.Sprint(scaleSetOSProfile["computerNamePrefix"]) + strings.Repeat("0", max(0, 6-len(suffix))) + suffix
+
+	network, _ := profile["networkProfile"].(map[string]interface{})
+	configs, _ := network["networkInterfaceConfigurations"].([]interface{})
+	nics := []interface{}{}
+	for _, c := range configs {
+		config, _ := c.(map[string]interface{})
+		configProps, _ := config["properties"].(map[string]interface{})
+		nics = append(nics, map[string]interface{}{
+			"id":      fmt.Sprintf("%s/networkInterfaces/%v", id, config["name"]),
+			"primary": configProps["primary"] == true,
+		})
+	}
+	vm["networkInterfaces"] = nics
+
+	if zones, _ := scaleSet["zones"].([]interface{}); len(zones) > 0 {
+		vm["zones"] = []interface{}{zones[instanceID%len(zones)]}
+	}
+	return vm
+}
+
+// convertScaleSetVMToARMFormat converts a scale set instance to ARM API format, adding its
+// instance view when the $expand query parameter asks for it
+func convertScaleSetVMToARMFormat(vm, scaleSet map[string]interface{}, expand string) map[string]interface{} {
+	view := scaleSetVMView(vm, scaleSet)
+	armVM := convertVMToARMFormatExpanded(view, expand)
+	armVM["type"] = scaleSetVMType
+	armVM["instanceId"] = vm["instanceId"]
+	armVM["sku"] = map[string]interface{}{"name": vm["vmSize"], "tier": "Standard"}
+	properties := armVM["properties"].(map[string]interface{})
+	properties["latestModelApplied"] = vm["latestModelApplied"]
+	if policy, ok := vm["protectionPolicy"]; ok {
+		properties["protectionPolicy"] = policy
+	}
+	return armVM
+}
+
+// mapScaleSetsResponse handles the scale set lists, manual upgrades and the
+// VirtualMachineScaleSetVMs_* instance operations
+func mapScaleSetsResponse(req *Request, store StoreInterface) (interface{}, error) {
+	rs, ok := store.(ResourceStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support generic resources")
+	}
+	ss, ok := store.(ScaleSetStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support scale sets")
+	}
+	subscriptionID := req.Params["subscriptionId"]
+	resourceGroup := req.Params["resourceGroupName"]
+	if resourceGroup != "" && findResourceGroup(subscriptionID, resourceGroup, store) == nil {
+		return nil, resourceGroupNotFound(resourceGroup)
+	}
+
+	if req.OperationID == "VirtualMachineScaleSets_List" || req.OperationID == "VirtualMachineScaleSets_ListAll" {
+		if req.Principal != nil && resourceGroup != "" && !req.Principal.HasPermission(subscriptionID, resourceGroup, "read") {
+			return nil, authorizationFailed(scaleSetType+"/read", "/subscriptions/"+subscriptionID+"/resourceGroups/"+resourceGroup)
+		}
+		items := []map[string]interface{}{}
+		for _, item := range resourcesOfType(scaleSetType, rs) {
+			rid, err := ParseResourceID(fmt.Sprint(item["id"]))
+			if err != nil || !strings.EqualFold(rid.SubscriptionID, subscriptionID) {
+				continue
+			}
+			if resourceGroup != "" && !strings.EqualFold(rid.ResourceGroup, resourceGroup) {
+				continue
+			}
+			if req.Principal != nil && !req.Principal.HasPermission(rid.SubscriptionID, rid.ResourceGroup, "read") {
+				continue
+			}
+			items = append(items, item)
+		}
+		sort.Slice(items, func(i, j int) bool {
+			return strings.ToLower(items[i]["id"].(string)) < strings.ToLower(items[j]["id"].(string))
+		})
+		value := []interface{}{}
+		for _, item := range items {
+			value = append(value, convertResourceToARMFormat(item, true))
+		}
+		return map[string]interface{}{"value": value}, nil
+	}
+
+	rid, err := ParseResourceID(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s",
+		subscriptionID, resourceGroup, scaleSetType, req.Params["vmScaleSetName"]))
+	if err != nil {
+		return nil, err
+	}
+	scaleSet := findResource(rid.String(), rs)
+	if scaleSet == nil {
+		return nil, resourceNotFound(scaleSetType, rid.Name(), resourceGroup)
+	}
+
+	switch req.OperationID {
+	case "VirtualMachineScaleSets_ManualUpgrade":
+		if err := authorize(req.Principal, rid, "write"); err != nil {
+			return nil, err
+		}
+		var upgrade scaleSetUpgradeRequest
+		if err := json.Unmarshal(req.Body, &upgrade); err != nil {
+			return nil, invalidRequestContent(err)
+		}
+		if len(upgrade.InstanceIDs) == 0 {
+			return nil, invalidComputeParameter("instanceIds", "Required parameter 'instanceIds' is missing (null).")
+		}
+		instances := map[string]map[string]interface{}{}
+		for _, vm := range scaleSetInstances(rid.String(), ss) {
+			instances[fmt.Sprint(vm["instanceId"])] = vm
+		}
+		upgraded := []map[string]interface{}{}
+		for _, instanceID := range upgrade.InstanceIDs {
+			vm, ok := instances[instanceID]
+			if !ok {
+				return nil, invalidComputeParameter("instanceIds", fmt.Sprintf("The provided instanceId %s is not an active Virtual Machine Scale Set VM instanceId.", instanceID))
+			}
+			if scaleSetActionsProtected(vm) {
+				return nil, computeOperationNotAllowed(fmt.Sprintf("The instance %s of scale set %s is protected from scale set actions.", instanceID, rid.Name()))
+			}
+			upgraded = append(upgraded, vm)
+		}
+		sku, _ := scaleSet["sku"].(map[string]interface{})
+		resized := map[string]bool{}
+		for _, vm := range upgraded {
+			resized[strings.ToLower(fmt.Sprint(vm["id"]))] = true
+		}
+		if err := checkVMCapacity(store, subscriptionID, fmt.Sprint(scaleSet["location"]), fmt.Sprint(sku["name"]), nil, len(upgraded), resized); err != nil {
+			return nil, err
+		}
+		for _, vm := range upgraded {
+			applyScaleSetModel(vm, scaleSet)
+			if _, err := ss.PutScaleSetVM(vm); err != nil {
+				return nil, err
+			}
+		}
+		return acceptOperation(req, store, subscriptionID, &Operation{})
+
+	case "VirtualMachineScaleSetVMs_List":
+		if err := authorize(req.Principal, rid, "read"); err != nil {
+			return nil, err
+		}
+		value := []interface{}{}
+		for _, vm := range scaleSetInstances(rid.String(), ss) {
+			value = append(value, convertScaleSetVMToARMFormat(vm, scaleSet, req.Params["$expand"]))
+		}
+		return map[string]interface{}{"value": value}, nil
+	}
+
+	instanceID := req.Params["instanceId"]
+	var vm map[string]interface{}
+	for _, instance := range scaleSetInstances(rid.String(), ss) {
+		if instance["instanceId"] == instanceID {
+			vm = instance
+		}
+	}
+	if vm == nil {
+		return nil, resourceNotFound(scaleSetVMType, rid.Name()+"/"+instanceID, resourceGroup)
+	}
+	instanceRID, err := ParseResourceID(fmt.Sprint(vm["id"]))
+	if err != nil {
+		return nil, err
+	}
+
+	_, action, _ := strings.Cut(req.OperationID, "_")
+	switch action {
+	case "Get":
+		if err := authorize(req.Principal, instanceRID, "read"); err != nil {
+			return nil, err
+		}
+		return convertScaleSetVMToARMFormat(vm, scaleSet, req.Params["$expand"]), nil
+
+	case "GetInstanceView":
+		if err := authorize(req.Principal, instanceRID, "read"); err != nil {
+			return nil, err
+		}
+		return vmInstanceView(scaleSetVMView(vm, scaleSet)), nil
+
+	case "Update":
+		if err := authorize(req.Principal, instanceRID, "write"); err != nil {
+			return nil, err
+		}
+		body, err := decodeBody(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		properties, _ := body["properties"].(map[string]interface{})
+		if policy, ok := properties["protectionPolicy"].(map[string]interface{}); ok {
+			protection := map[string]interface{}{}
+			for _, key := range []string{"protectFromScaleIn", "protectFromScaleSetActions"} {
+				protection[key] = policy[key] == true
+			}
+			vm["protectionPolicy"] = protection
+		}
+		if _, err := ss.PutScaleSetVM(vm); err != nil {
+			return nil, err
+		}
+		return convertScaleSetVMToARMFormat(scaleSetInstance(instanceRID.String(), ss), scaleSet, ""), nil
+
+	case "Delete":
+		if err := authorize(req.Principal, instanceRID, "delete"); err != nil {
+			return nil, err
+		}
+		ss.DeleteScaleSetVM(instanceRID.String())
+		// Deleting an instance lowers the capacity, so it is not replaced
+		sku, _ := scaleSet["sku"].(map[string]interface{})
+		if sku != nil && intValue(sku["capacity"]) > 0 {
+			sku["capacity"] = intValue(sku["capacity"]) - 1
+			if err := rs.PutResource(scaleSet); err != nil {
+				return nil, err
+			}
+		}
+		return acceptOperation(req, store, subscriptionID, &Operation{})
+
+	default:
+		if err := applyVMPowerAction(req, instanceRID, vm, strings.ToLower(action)); err != nil {
+			return nil, err
+		}
+		if _, err := ss.PutScaleSetVM(vm); err != nil {
+			return nil, err
+		}
+		return acceptOperation(req, store, subscriptionID, &Operation{})
+	}
+}
+
+// scaleSetInstances returns the store view of a scale set's instances, ordered by instance ID
+func scaleSetInstances(scaleSetID string, ss ScaleSetStore) []map[string]interface{} {
+	instances := []map[string]interface{}{}
+	for _, vm := range ss.GetScaleSetVMs(scaleSetID) {
+		if vmMap, ok := vm.(map[string]interface{}); ok {
+			instances = append(instances, vmMap)
+		}
+	}
+	return instances
+}
+
+// scaleSetInstance looks a scale set instance up by ID
+func scaleSetInstance(id string, ss ScaleSetStore) map[string]interface{} {
+	rid, err := ParseResourceID(id)
+	if err != nil || rid.Parent() == nil {
+		return nil
+	}
+	for _, vm := range scaleSetInstances(rid.Parent().String(), ss) {
+		if strings.EqualFold(fmt.Sprint(vm["id"]), id) {
+			return vm
+		}
+	}
+	return nil
+}
+
+// scaleSetActionsProtected reports whether an instance is protected from scale set actions
+// such as upgrades
+func scaleSetActionsProtected(vm map[string]interface{}) bool {
+	policy, _ := vm["protectionPolicy"].(map[string]interface{})
+	return policy["protectFromScaleSetActions"] == true
+}
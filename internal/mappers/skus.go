@@ -0,0 +1,494 @@
+package mappers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// VM sizes come from a built-in catalog of real SKUs, which the config extends and overrides
+// through "vmSizes". VMs and scale sets may only use a size the catalog offers in their location
+// and zones, and their vCPUs count against the per-family and regional quotas of their
+// subscription, which the config overrides through "quotas". Like Azure, deallocated VMs keep
+// counting against the quota.
+// See https://learn.microsoft.com/azure/virtual-machines/quotas
+
+const (
+	regionalCoresQuota          = "cores"
+	virtualMachinesQuota        = "virtualMachines"
+	scaleSetsQuota              = "virtualMachineScaleSets"
+	defaultRegionalCoresLimit   = 100
+	defaultFamilyCoresLimit     = 50
+	defaultVirtualMachinesLimit = 25000
+	defaultScaleSetsLimit       = 2500
+	skuNotAvailableReason       = "NotAvailableForSubscription"
+)
+
+// vmSize is a VM size of the catalog
+type vmSize struct {
+	Name               string
+	Family             string
+	VCPUs              int
+	MemoryGB           float64
+	MaxDataDiskCount   int
+	ResourceDiskSizeMB int
+	Locations          []string // regions offering the size; empty means every region
+	Restrictions       []skuRestriction
+}
+
+// skuRestriction keeps a size out of a region, or out of some of its zones
+type skuRestriction struct {
+	Location   string
+	Zones      []string // empty restricts the whole region
+	ReasonCode string
+}
+
+// knownVMSizes is the built-in VM size catalog
+var knownVMSizes = []vmSize{
+	{Name: "Standard_B1s", Family: "standardBSFamily", VCPUs: 1, MemoryGB: 1, MaxDataDiskCount: 2, ResourceDiskSizeMB: 4096},
+	{Name: "Standard_B1ms", Family: "standardBSFamily", VCPUs: 1, MemoryGB: 2, MaxDataDiskCount: 2, ResourceDiskSizeMB: 4096},
+	{Name: "Standard_B2s", Family: "standardBSFamily", VCPUs: 2, MemoryGB: 4, MaxDataDiskCount: 4, ResourceDiskSizeMB: 8192},
+	{Name: "Standard_B2ms", Family: "standardBSFamily", VCPUs: 2, MemoryGB: 8, MaxDataDiskCount: 4, ResourceDiskSizeMB: 16384},
+	{Name: "Standard_B4ms", Family: "standardBSFamily", VCPUs: 4, MemoryGB: 16, MaxDataDiskCount: 8, ResourceDiskSizeMB: 32768},
+	{Name: "Standard_B8ms", Family: "standardBSFamily", VCPUs: 8, MemoryGB: 32, MaxDataDiskCount: 16, ResourceDiskSizeMB: 65536},
+	{Name: "Standard_D2s_v3", Family: "standardDSv3Family", VCPUs: 2, MemoryGB: 8, MaxDataDiskCount: 4, ResourceDiskSizeMB: 16384},
+	{Name: "Standard_D4s_v3", Family: "standardDSv3Family", VCPUs: 4, MemoryGB: 16, MaxDataDiskCount: 8, ResourceDiskSizeMB: 32768},
+	{Name: "Standard_D8s_v3", Family: "standardDSv3Family", VCPUs: 8, MemoryGB: 32, MaxDataDiskCount: 16, ResourceDiskSizeMB: 65536},
+	{Name: "Standard_D16s_v3", Family: "standardDSv3Family", VCPUs: 16, MemoryGB: 64, MaxDataDiskCount: 32, ResourceDiskSizeMB: 131072,
+		Restrictions: []skuRestriction{{Location: "westus", ReasonCode: skuNotAvailableReason}}},
+	{Name: "Standard_D2s_v5", Family: "standardDSv5Family", VCPUs: 2, MemoryGB: 8, MaxDataDiskCount: 4},
+	{Name: "Standard_D4s_v5", Family: "standardDSv5Family", VCPUs: 4, MemoryGB: 16, MaxDataDiskCount: 8},
+	{Name: "Standard_D8s_v5", Family: "standardDSv5Family", VCPUs: 8, MemoryGB: 32, MaxDataDiskCount: 16},
+	{Name: "Standard_D16s_v5", Family: "standardDSv5Family", VCPUs: 16, MemoryGB: 64, MaxDataDiskCount: 32},
+	{Name: "Standard_E2s_v5", Family: "standardESv5Family", VCPUs: 2, MemoryGB: 16, MaxDataDiskCount: 4},
+	{Name: "Standard_E4s_v5", Family: "standardESv5Family", VCPUs: 4, MemoryGB: 32, MaxDataDiskCount: 8},
+	{Name: "Standard_E8s_v5", Family: "standardESv5Family", VCPUs: 8, MemoryGB: 64, MaxDataDiskCount: 16},
+	{Name: "Standard_F2s_v2", Family: "standardFSv2Family", VCPUs: 2, MemoryGB: 4, MaxDataDiskCount: 4, ResourceDiskSizeMB: 16384},
+	{Name: "Standard_F4s_v2", Family: "standardFSv2Family", VCPUs: 4, MemoryGB: 8, MaxDataDiskCount: 8, ResourceDiskSizeMB: 32768},
+	{Name: "Standard_F8s_v2", Family: "standardFSv2Family", VCPUs: 8, MemoryGB: 16, MaxDataDiskCount: 16, ResourceDiskSizeMB: 65536},
+	{Name: "Standard_NC4as_T4_v3", Family: "standardNCASv3_T4Family", VCPUs: 4, MemoryGB: 28, MaxDataDiskCount: 8, ResourceDiskSizeMB: 180224,
+		Locations:    []string{"eastus", "eastus2", "westus2", "southcentralus", "northeurope", "westeurope", "uksouth", "japaneast", "southeastasia", "australiaeast", "centralindia", "koreacentral"},
+		Restrictions: []skuRestriction{{Location: "eastus", Zones: []string{"1"}, ReasonCode: skuNotAvailableReason}}},
+}
+
+// defaultFamilyLimits are the family quotas that differ from defaultFamilyCoresLimit. As on new
+// pay-as-you-go subscriptions, GPU families have no quota until it is requested.
+var defaultFamilyLimits = map[string]int{
+	"standardNCASv3_T4Family": 0,
+}
+
+// zonalRegions are the regions of the region catalog that have availability zones
+var zonalRegions = map[string]bool{
+	"eastus": true, "eastus2": true, "westus2": true, "westus3": true, "centralus": true, "southcentralus": true,
+	"canadacentral": true, "brazilsouth": true, "northeurope": true, "westeurope": true, "uksouth": true,
+	"francecentral": true, "germanywestcentral": true, "swedencentral": true, "switzerlandnorth": true,
+	"australiaeast": true, "southeastasia": true, "eastasia": true, "japaneast": true, "centralindia": true,
+	"koreacentral": true, "southafricanorth": true, "uaenorth": true,
+}
+
+// skuLocationFilterPattern matches the location filter of ResourceSkus_List
+var skuLocationFilterPattern = regexp.MustCompile(`(?i)^\s*location\s+eq\s+'([^']*)'\s*$`)
+
+// vmSizeCatalog returns the built-in VM sizes with the configured ones applied. A configured
+// size overrides the fields it sets of the built-in size with the same name, or adds a size.
+func vmSizeCatalog(store StoreInterface) []vmSize {
+	catalog := append([]vmSize(nil), knownVMSizes...)
+	cs, ok := store.(CapacityStore)
+	if !ok {
+		return catalog
+	}
+	for _, item := range cs.GetVMSizes() {
+		configured, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := configured["name"].(string)
+		if name == "" {
+			continue
+		}
+		size := findVMSize(catalog, name)
+		if size == nil {
+			catalog = append(catalog, vmSize{Name: name, Family: name})
+			size = &catalog[len(catalog)-1]
+		}
+		if family, _ := configured["family"].(string); family != "" {
+			size.Family = family
+		}
+		if n := intValue(configured["vCPUs"]); n > 0 {
+			size.VCPUs = n
+		}
+		if memory, _ := configured["memoryGB"].(float64); memory > 0 {
+			size.MemoryGB = memory
+		}
+		if n := intValue(configured["maxDataDiskCount"]); n > 0 {
+			size.MaxDataDiskCount = n
+		}
+		if n := intValue(configured["resourceDiskSizeMB"]); n > 0 {
+			size.ResourceDiskSizeMB = n
+		}
+		if locations, _ := configured["locations"].([]string); len(locations) > 0 {
+			size.Locations = make([]string, len(locations))
+			for i, location := range locations {
+				size.Locations[i] = NormalizeLocation(location)
+			}
+		}
+		if restrictions, ok := configured["restrictions"].([]interface{}); ok {
+			size.Restrictions = []skuRestriction{}
+			for _, r := range restrictions {
+				rMap, _ := r.(map[string]interface{})
+				restriction := skuRestriction{ReasonCode: skuNotAvailableReason}
+				location, _ := rMap["location"].(string)
+				restriction.Location = NormalizeLocation(location)
+				restriction.Zones, _ = rMap["zones"].([]string)
+				if reason, _ := rMap["reasonCode"].(string); reason != "" {
+					restriction.ReasonCode = reason
+				}
+				size.Restrictions = append(size.Restrictions, restriction)
+			}
+		}
+	}
+	return catalog
+}
+
+// findVMSize looks a size up in a catalog by name, ignoring case
+func findVMSize(catalog []vmSize, name string) *vmSize {
+	for i := range catalog {
+		if strings.EqualFold(catalog[i].Name, name) {
+			return &catalog[i]
+		}
+	}
+	return nil
+}
+
+// offeredIn reports whether a size is offered in a region
+func (s *vmSize) offeredIn(location string) bool {
+	return len(s.Locations) == 0 || containsFold(s.Locations, location)
+}
+
+// restriction returns the restriction that keeps the size out of a region or out of one of
+// the given zones, if any
+func (s *vmSize) restriction(location string, zones []string) *skuRestriction {
+	for i, r := range s.Restrictions {
+		if !strings.EqualFold(r.Location, location) {
+			continue
+		}
+		if len(r.Zones) == 0 {
+			return &s.Restrictions[i]
+		}
+		for _, zone := range zones {
+			if containsString(r.Zones, zone) {
+				return &s.Restrictions[i]
+			}
+		}
+	}
+	return nil
+}
+
+// familyDisplayName returns the localized name Azure gives a family quota, such as
+// "Standard DSv5 Family vCPUs" for standardDSv5Family
+func familyDisplayName(family string) string {
+	series := strings.TrimSuffix(strings.TrimPrefix(family, "standard"), "Family")
+	return "Standard " + series + " Family vCPUs"
+}
+
+// availableVMSize looks a size up and checks it is offered in a location and zones
+func availableVMSize(catalog []vmSize, name, location string, zones []string) (*vmSize, error) {
+	location = NormalizeLocation(location)
+	size := findVMSize(catalog, name)
+	if size == nil {
+		offered := []string{}
+		for i := range catalog {
+			if catalog[i].offeredIn(location) && catalog[i].restriction(location, nil) == nil {
+				offered = append(offered, catalog[i].Name)
+			}
+		}
+		return nil, invalidComputeParameter("hardwareProfile.vmSize", fmt.Sprintf(
+			"The value %s provided for the VM size is not valid. The valid sizes in the current region are: %s.", name, strings.Join(offered, ",")))
+	}
+	if !size.offeredIn(location) || size.restriction(location, zones) != nil || (len(zones) > 0 && !zonalRegions[location]) {
+		return nil, skuNotAvailable(size.Name, location)
+	}
+	return size, nil
+}
+
+// quotaLimit returns the limit of a quota in a location: the configured limit for the location,
+// then one configured for every location, then the built-in default
+func quotaLimit(store StoreInterface, location, name string) int {
+	limit, found := 0, false
+	if cs, ok := store.(CapacityStore); ok {
+		for _, item := range cs.GetQuotas() {
+			quota, _ := item.(map[string]interface{})
+			quotaName, _ := quota["name"].(string)
+			quotaLocation, _ := quota["location"].(string)
+			if !strings.EqualFold(quotaName, name) {
+				continue
+			}
+			if strings.EqualFold(NormalizeLocation(quotaLocation), location) {
+				return intValue(quota["limit"])
+			}
+			if quotaLocation == "" {
+				limit, found = intValue(quota["limit"]), true
+			}
+		}
+	}
+	if found {
+		return limit
+	}
+	switch name {
+	case regionalCoresQuota:
+		return defaultRegionalCoresLimit
+	case virtualMachinesQuota:
+		return defaultVirtualMachinesLimit
+	case scaleSetsQuota:
+		return defaultScaleSetsLimit
+	}
+	if limit, ok := defaultFamilyLimits[name]; ok {
+		return limit
+	}
+	return defaultFamilyCoresLimit
+}
+
+// computeUsage returns what the VMs and scale set instances in a subscription's location use:
+// vCPUs by family and in total under "cores", plus the VM and scale set counts. VMs whose ID is
+// in exclude are left out. Sizes missing from the catalog count as VMs without vCPUs.
+func computeUsage(store StoreInterface, catalog []vmSize, subscriptionID, location string, exclude map[string]bool) map[string]int {
+	location = NormalizeLocation(location)
+	usage := map[string]int{}
+	count := func(vm map[string]interface{}) {
+		id, _ := vm["id"].(string)
+		if !inSubscription(vm, subscriptionID) || exclude[strings.ToLower(id)] {
+			return
+		}
+		if NormalizeLocation(fmt.Sprint(vm["location"])) != location {
+			return
+		}
+		usage[virtualMachinesQuota]++
+		if size := findVMSize(catalog, fmt.Sprint(vm["vmSize"])); size != nil {
+			usage[size.Family] += size.VCPUs
+			usage[regionalCoresQuota] += size.VCPUs
+		}
+	}
+	for _, vm := range store.GetVMs() {
+		if vmMap, ok := vm.(map[string]interface{}); ok {
+			count(vmMap)
+		}
+	}
+	rs, ok := store.(ResourceStore)
+	ss, ok2 := store.(ScaleSetStore)
+	if !ok || !ok2 {
+		return usage
+	}
+	for _, scaleSet := range resourcesOfType(scaleSetType, rs) {
+		rid, err := ParseResourceID(fmt.Sprint(scaleSet["id"]))
+		if err != nil || !strings.EqualFold(rid.SubscriptionID, subscriptionID) {
+			continue
+		}
+		if NormalizeLocation(fmt.Sprint(scaleSet["location"])) == location {
+			usage[scaleSetsQuota]++
+		}
+		for _, vm := range scaleSetInstances(rid.String(), ss) {
+			count(vm)
+		}
+	}
+	return usage
+}
+
+// checkComputeQuota checks that count more VMs of a size fit the family and regional vCPU
+// quotas of a subscription's location. VMs in exclude are left out of the current usage, so a
+// VM being resized, or an instance being upgraded, is counted once at its new size.
+func checkComputeQuota(store StoreInterface, catalog []vmSize, size *vmSize, subscriptionID, location string, count int, exclude map[string]bool) error {
+	if count <= 0 {
+		return nil
+	}
+	location = NormalizeLocation(location)
+	usage := computeUsage(store, catalog, subscriptionID, location, exclude)
+	required := count * size.VCPUs
+	quotas := []struct{ name, displayName string }{
+		{size.Family, size.Family},
+		{regionalCoresQuota, "Total Regional"},
+	}
+	for _, quota := range quotas {
+		limit := quotaLimit(store, location, quota.name)
+		if usage[quota.name]+required > limit {
+			return computeOperationNotAllowed(fmt.Sprintf("Operation could not be completed as it results in exceeding approved %s Cores quota. "+
+				"Additional details - Deployment Model: Resource Manager, Location: %s, Current Limit: %d, Current Usage: %d, Additional Required: %d, (Minimum) New Limit Required: %d. "+
+				"Submit a request for Quota increase at https://aka.ms/ProdportalCRP/#blade/Microsoft_Azure_Capacity/UsageAndQuota.ReactView by specifying parameters listed in the 'Details' section for deployment to succeed.",
+				quota.displayName, location, limit, usage[quota.name], required, usage[quota.name]+required))
+		}
+	}
+	return nil
+}
+
+// checkVMCapacity checks that count more VMs of a size can be placed in a subscription's location
+// and zones: the size must be offered there and its vCPUs must fit the quotas. See checkComputeQuota
+// for exclude.
+func checkVMCapacity(store StoreInterface, subscriptionID, location, sizeName string, zones []string, count int, exclude map[string]bool) error {
+	catalog := vmSizeCatalog(store)
+	size, err := availableVMSize(catalog, sizeName, location, zones)
+	if err != nil {
+		return err
+	}
+	return checkComputeQuota(store, catalog, size, subscriptionID, location, count, exclude)
+}
+
+// zoneStrings reads a zones list from a request body or store view
+func zoneStrings(v interface{}) []string {
+	switch zones := v.(type) {
+	case []string:
+		return zones
+	case []interface{}:
+		result := make([]string, 0, len(zones))
+		for _, zone := range zones {
+			result = append(result, fmt.Sprint(zone))
+		}
+		return result
+	}
+	return nil
+}
+
+// skuNotAvailable is the compute provider's error for a size that is not offered in a location or zone
+func skuNotAvailable(size, location string) *ARMError {
+	return &ARMError{
+		StatusCode: http.StatusConflict,
+		Code:       "SkuNotAvailable",
+		Message: fmt.Sprintf("The requested VM size for resource 'Following SKUs have failed for Capacity Restrictions: %s' is currently not available in location '%s'. "+
+			"Please try another size or deploy to a different location or different zone. See https://aka.ms/azureskunotavailable for details.", size, location),
+	}
+}
+
+// mapComputeSkusResponse handles ResourceSkus_List, VirtualMachineSizes_List and Usage_List
+func mapComputeSkusResponse(req *Request, store StoreInterface) (interface{}, error) {
+	if req.Method != http.MethodGet {
+		return nil, fmt.Errorf("unsupported method: %s", req.Method)
+	}
+	subscriptionID := req.Params["subscriptionId"]
+	location := NormalizeLocation(req.Params["location"])
+	catalog := vmSizeCatalog(store)
+
+	switch req.OperationID {
+	case "VirtualMachineSizes_List":
+		value := []interface{}{}
+		for i := range catalog {
+			size := &catalog[i]
+			if !size.offeredIn(location) || size.restriction(location, nil) != nil {
+				continue
+			}
+			value = append(value, map[string]interface{}{
+				"name":                 size.Name,
+				"numberOfCores":        size.VCPUs,
+				"memoryInMB":           int(size.MemoryGB * 1024),
+				"maxDataDiskCount":     size.MaxDataDiskCount,
+				"osDiskSizeInMB":       1047552,
+				"resourceDiskSizeInMB": size.ResourceDiskSizeMB,
+			})
+		}
+		return map[string]interface{}{"value": value}, nil
+
+	case "Usage_List":
+		usage := computeUsage(store, catalog, subscriptionID, location, nil)
+		entry := func(name, localizedName string) map[string]interface{} {
+			return map[string]interface{}{
+				"unit":         "Count",
+				"currentValue": usage[name],
+				"limit":        quotaLimit(store, location, name),
+				"name":         map[string]interface{}{"value": name, "localizedValue": localizedName},
+			}
+		}
+		value := []interface{}{
+			entry(scaleSetsQuota, "Virtual Machine Scale Sets"),
+			entry(virtualMachinesQuota, "Virtual Machines"),
+			entry(regionalCoresQuota, "Total Regional vCPUs"),
+		}
+		families := []string{}
+		for i := range catalog {
+			if catalog[i].offeredIn(location) && !containsString(families, catalog[i].Family) {
+				families = append(families, catalog[i].Family)
+			}
+		}
+		sort.Strings(families)
+		for _, family := range families {
+			value = append(value, entry(family, familyDisplayName(family)))
+		}
+		return map[string]interface{}{"value": value}, nil
+	}
+
+	// ResourceSkus_List lists every size in every location of the subscription
+	filter := ""
+	if f := req.Params["$filter"]; f != "" {
+		matches := skuLocationFilterPattern.FindStringSubmatch(f)
+		if matches == nil {
+			return nil, &ARMError{StatusCode: http.StatusBadRequest, Code: "InvalidFilter", Message: fmt.Sprintf("The filter '%s' is not supported. Only 'location eq' filters are supported.", f)}
+		}
+		filter = NormalizeLocation(matches[1])
+	}
+	locations := []string{}
+	for _, l := range subscriptionLocations(findSubscription(subscriptionID, store)) {
+		name, _ := l.(map[string]interface{})["name"].(string)
+		if filter == "" || name == filter {
+			locations = append(locations, name)
+		}
+	}
+	value := []interface{}{}
+	for i := range catalog {
+		size := &catalog[i]
+		for _, loc := range locations {
+			if size.offeredIn(loc) {
+				value = append(value, resourceSku(size, loc))
+			}
+		}
+	}
+	return map[string]interface{}{"value": value}, nil
+}
+
+// resourceSku returns the ResourceSkus_List entry of a size in a location
+func resourceSku(size *vmSize, location string) map[string]interface{} {
+	zones := []string{}
+	if zonalRegions[location] {
+		zones = []string{"1", "2", "3"}
+	}
+	restrictions := []interface{}{}
+	for _, r := range size.Restrictions {
+		if !strings.EqualFold(r.Location, location) {
+			continue
+		}
+		restriction := map[string]interface{}{
+			"type":       "Location",
+			"values":     []string{location},
+			"reasonCode": r.ReasonCode,
+			"restrictionInfo": map[string]interface{}{
+				"locations": []string{location},
+			},
+		}
+		if len(r.Zones) > 0 {
+			restriction["type"] = "Zone"
+			restriction["restrictionInfo"].(map[string]interface{})["zones"] = r.Zones
+		}
+		restrictions = append(restrictions, restriction)
+	}
+	tier, sizeName, _ := strings.Cut(size.Name, "_")
+	return map[string]interface{}{
+		"resourceType": "virtualMachines",
+		"name":         size.Name,
+		"tier":         tier,
+		"size":         sizeName,
+		"family":       size.Family,
+		"locations":    []string{location},
+		"locationInfo": []interface{}{map[string]interface{}{"location": location, "zones": zones}},
+		"capabilities": []interface{}{
+			skuCapability("vCPUs", size.VCPUs),
+			skuCapability("vCPUsAvailable", size.VCPUs),
+			skuCapability("MemoryGB", size.MemoryGB),
+			skuCapability("MaxDataDiskCount", size.MaxDataDiskCount),
+			skuCapability("MaxResourceVolumeMB", size.ResourceDiskSizeMB),
+			skuCapability("PremiumIO", "True"),
+		},
+		"restrictions": restrictions,
+	}
+}
+
+// skuCapability returns a resource SKU capability; Azure reports every value as a string
+func skuCapability(name string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{"name": name, "value": fmt.Sprint(value)}
+}
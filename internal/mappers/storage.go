@@ -0,0 +1,186 @@
+package mappers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Storage accounts are kept in the generic resource store. The account keys and the blob
+// endpoint come from the store, which also serves the Blob data plane the keys sign requests for.
+// See https://learn.microsoft.com/rest/api/storagerp/storage-accounts
+
+const (
+	storageAccountType = "Microsoft.Storage/storageAccounts"
+
+	defaultStorageSKU  = "Standard_LRS"
+	defaultStorageKind = "StorageV2"
+)
+
+// storageAccountNamePattern is the naming rule of storage accounts, whose names are global
+var storageAccountNamePattern = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+
+// StorageStore supplies what the storage provider derives from the Blob data plane: an account's
+// blob endpoint, and its two access keys by account ID
+type StorageStore interface {
+	BlobEndpoint(accountName string) string
+	StorageAccountKeys(accountID string) []string
+}
+
+// isStorageAccountResource reports whether a resource ID is a storage account
+func isStorageAccountResource(rid *ResourceID) bool {
+	return strings.EqualFold(rid.Type(), storageAccountType)
+}
+
+// putStorageAccountResource validates a storage account, fills in what the storage provider
+// computes, and stores it
+func putStorageAccountResource(rid *ResourceID, resource map[string]interface{}, rs ResourceStore) error {
+	name := rid.Name()
+	if !storageAccountNamePattern.MatchString(name) {
+		return &ARMError{
+			StatusCode: http.StatusBadRequest,
+			Code:       "AccountNameInvalid",
+			Message:    fmt.Sprintf("%s is not a valid storage account name. Storage account name must be between 3 and 24 characters in length and use numbers and lower-case letters only.", name),
+		}
+	}
+	var existingProps map[string]interface{}
+	for _, account := range resourcesOfType(storageAccountType, rs) {
+		id := fmt.Sprint(account["id"])
+		switch {
+		case strings.EqualFold(id, rid.String()):
+			existingProps, _ = account["properties"].(map[string]interface{})
+		case strings.EqualFold(fmt.Sprint(account["name"]), name):
+			return &ARMError{
+				StatusCode: http.StatusConflict,
+				Code:       "StorageAccountAlreadyTaken",
+				Message:    fmt.Sprintf("The storage account named %s is already taken.", name),
+			}
+		}
+	}
+
+	if _, ok := resource["kind"]; !ok {
+		resource["kind"] = defaultStorageKind
+	}
+	sku, _ := resource["sku"].(map[string]interface{})
+	if sku == nil {
+		sku = map[string]interface{}{"name": defaultStorageSKU}
+		resource["sku"] = sku
+	}
+	if skuName, _ := sku["name"].(string); strings.HasPrefix(skuName, "Premium") {
+		sku["tier"] = "Premium"
+	} else {
+		sku["tier"] = "Standard"
+	}
+
+	props, _ := resource["properties"].(map[string]interface{})
+	if props == nil {
+		props = map[string]interface{}{}
+		resource["properties"] = props
+	}
+	if created, ok := existingProps["creationTime"]; ok {
+		props["creationTime"] = created
+	}
+	setDefault(props, "creationTime", time.Now().UTC().Format(time.RFC3339Nano))
+	setDefault(props, "accessTier", "Hot")
+	setDefault(props, "supportsHttpsTrafficOnly", true)
+	setDefault(props, "allowSharedKeyAccess", true)
+	setDefault(props, "minimumTlsVersion", "TLS1_2")
+	props["provisioningState"] = "Succeeded"
+	props["primaryLocation"] = resource["location"]
+	props["statusOfPrimary"] = "available"
+	return rs.PutResource(resource)
+}
+
+// storageAccountView adds the account's service endpoints to its ARM view
+func storageAccountView(armResource map[string]interface{}, store StoreInterface) map[string]interface{} {
+	ss, ok := store.(StorageStore)
+	if !ok {
+		return armResource
+	}
+	props, _ := armResource["properties"].(map[string]interface{})
+	if props == nil {
+		props = map[string]interface{}{}
+		armResource["properties"] = props
+	}
+	props["primaryEndpoints"] = map[string]interface{}{
+		"blob": ss.BlobEndpoint(fmt.Sprint(armResource["name"])),
+	}
+	return armResource
+}
+
+// mapStorageAccountsResponse handles the storage account lists and StorageAccounts_ListKeys.
+// Single storage accounts are served by the generic resource routes.
+func mapStorageAccountsResponse(req *Request, store StoreInterface) (interface{}, error) {
+	rs, ok := store.(ResourceStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support generic resources")
+	}
+	subscriptionID := req.Params["subscriptionId"]
+	resourceGroup := req.Params["resourceGroupName"]
+	if resourceGroup != "" && findResourceGroup(subscriptionID, resourceGroup, store) == nil {
+		return nil, resourceGroupNotFound(resourceGroup)
+	}
+
+	switch req.OperationID {
+	case "StorageAccounts_List", "StorageAccounts_ListByResourceGroup":
+		if req.Principal != nil && resourceGroup != "" && !req.Principal.HasPermission(subscriptionID, resourceGroup, "read") {
+			return nil, authorizationFailed(storageAccountType+"/read", "/subscriptions/"+subscriptionID+"/resourceGroups/"+resourceGroup)
+		}
+		value := []map[string]interface{}{}
+		for _, item := range resourcesOfType(storageAccountType, rs) {
+			rid, err := ParseResourceID(fmt.Sprint(item["id"]))
+			if err != nil || !strings.EqualFold(rid.SubscriptionID, subscriptionID) {
+				continue
+			}
+			if resourceGroup != "" && !strings.EqualFold(rid.ResourceGroup, resourceGroup) {
+				continue
+			}
+			if req.Principal != nil && !req.Principal.HasPermission(rid.SubscriptionID, rid.ResourceGroup, "read") {
+				continue
+			}
+			value = append(value, storageAccountView(convertResourceToARMFormat(item, true), store))
+		}
+		sort.Slice(value, func(i, j int) bool {
+			return strings.ToLower(value[i]["id"].(string)) < strings.ToLower(value[j]["id"].(string))
+		})
+		list := []interface{}{}
+		for _, v := range value {
+			list = append(list, v)
+		}
+		return map[string]interface{}{"value": list}, nil
+
+	case "StorageAccounts_ListKeys":
+		name := req.Params["accountName"]
+		id := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s", subscriptionID, resourceGroup, storageAccountType, name)
+		account := findResource(id, rs)
+		if account == nil {
+			return nil, resourceNotFound(storageAccountType, name, resourceGroup)
+		}
+		// Listing keys hands out full data access, so it takes write permission
+		if req.Principal != nil && !req.Principal.HasPermission(subscriptionID, resourceGroup, "write") {
+			return nil, authorizationFailed(storageAccountType+"/listkeys/action", id)
+		}
+		ss, ok := store.(StorageStore)
+		if !ok {
+			return nil, fmt.Errorf("store does not support storage account keys")
+		}
+		created := ""
+		if props, ok := account["properties"].(map[string]interface{}); ok {
+			created, _ = props["creationTime"].(string)
+		}
+		keys := []interface{}{}
+		for i, key := range ss.StorageAccountKeys(fmt.Sprint(account["id"])) {
+			keys = append(keys, map[string]interface{}{
+				"keyName":      fmt.Sprintf("key%d", i+1),
+				"value":        key,
+				"permissions":  "FULL",
+				"creationTime": created,
+			})
+		}
+		return map[string]interface{}{"keys": keys}, nil
+	}
+	return nil, fmt.Errorf("unsupported storage operation: %s", req.OperationID)
+}
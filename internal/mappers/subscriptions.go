@@ -0,0 +1,218 @@
+package mappers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// region describes an Azure region as returned by Subscriptions_ListLocations
+type region struct {
+	Name                string
+	DisplayName         string
+	RegionalDisplayName string
+	GeographyGroup      string
+	PhysicalLocation    string
+	Latitude            string
+	Longitude           string
+	PairedRegion        string
+}
+
+// knownRegions is the built-in region catalog, used when a subscription does not list its locations
+var knownRegions = []region{
+	{"eastus", "East US", "(US) East US", "US", "Virginia", "37.3719", "-79.8164", "westus"},
+	{"eastus2", "East US 2", "(US) East US 2", "US", "Virginia", "36.6681", "-78.3889", "centralus"},
+	{"westus", "West US", "(US) West US", "US", "California", "37.783", "-122.417", "eastus"},
+	{"westus2", "West US 2", "(US) West US 2", "US", "Washington", "47.233", "-119.852", "westcentralus"},
+	{"westus3", "West US 3", "(US) West US 3", "US", "Phoenix", "33.448376", "-112.074036", "eastus"},
+	{"centralus", "Central US", "(US) Central US", "US", "Iowa", "41.5908", "-93.6208", "eastus2"},
+	{"westcentralus", "West Central US", "(US) West Central US", "US", "Wyoming", "40.890", "-110.234", "westus2"},
+	{"canadacentral", "Canada Central", "(Canada) Canada Central", "Canada", "Toronto", "43.653", "-79.383", "canadaeast"},
+	{"canadaeast", "Canada East", "(Canada) Canada East", "Canada", "Quebec", "46.817", "-71.217", "canadacentral"},
+	{"brazilsouth", "Brazil South", "(South America) Brazil South", "South America", "Sao Paulo State", "-23.55", "-46.633", "southcentralus"},
+	{"southcentralus", "South Central US", "(US) South Central US", "US", "Texas", "29.4167", "-98.5", "northcentralus"},
+	{"northcentralus", "North Central US", "(US) North Central US", "US", "Illinois", "41.8819", "-87.6278", "southcentralus"},
+	{"northeurope", "North Europe", "(Europe) North Europe", "Europe", "Ireland", "53.3478", "-6.2597", "westeurope"},
+	{"westeurope", "West Europe", "(Europe) West Europe", "Europe", "Netherlands", "52.3667", "4.9", "northeurope"},
+	{"uksouth", "UK South", "(Europe) UK South", "Europe", "London", "50.941", "-0.799", "ukwest"},
+	{"ukwest", "UK West", "(Europe) UK West", "Europe", "Cardiff", "53.427", "-3.084", "uksouth"},
+	{"francecentral", "France Central", "(Europe) France Central", "Europe", "Paris", "46.3772", "2.3730", "francesouth"},
+	{"germanywestcentral", "Germany West Central", "(Europe) Germany West Central", "Europe", "Frankfurt", "50.110924", "8.682127", "germanynorth"},
+	{"swedencentral", "Sweden Central", "(Europe) Sweden Central", "Europe", "Gävle", "60.67488", "17.14127", "swedensouth"},
+	{"switzerlandnorth", "Switzerland North", "(Europe) Switzerland North", "Europe", "Zurich", "47.451542", "8.564572", "switzerlandwest"},
+	{"australiaeast", "Australia East", "(Asia Pacific) Australia East", "Asia Pacific", "New South Wales", "-33.86", "151.2094", "australiasoutheast"},
+	{"southeastasia", "Southeast Asia", "(Asia Pacific) Southeast Asia", "Asia Pacific", "Singapore", "1.283", "103.833", "eastasia"},
+	{"eastasia", "East Asia", "(Asia Pacific) East Asia", "Asia Pacific", "Hong Kong", "22.267", "114.188", "southeastasia"},
+	{"japaneast", "Japan East", "(Asia Pacific) Japan East", "Asia Pacific", "Tokyo, Saitama", "35.68", "139.77", "japanwest"},
+	{"centralindia", "Central India", "(Asia Pacific) Central India", "Asia Pacific", "Pune", "18.5822", "73.9197", "southindia"},
+	{"koreacentral", "Korea Central", "(Asia Pacific) Korea Central", "Asia Pacific", "Seoul", "37.5665", "126.9780", "koreasouth"},
+	{"southafricanorth", "South Africa North", "(Africa) South Africa North", "Africa", "Johannesburg", "-25.731340", "28.218370", "southafricawest"},
+	{"uaenorth", "UAE North", "(Middle East) UAE North", "Middle East", "Dubai", "25.266666", "55.316666", "uaecentral"},
+}
+
+// NormalizeLocation turns a location display name such as "East US" into its ARM name "eastus"
+func NormalizeLocation(location string) string {
+	return strings.ToLower(strings.ReplaceAll(location, " ", ""))
+}
+
+// mapSubscriptionsResponse handles Subscriptions_List, Subscriptions_Get and Subscriptions_ListLocations
+func mapSubscriptionsResponse(req *Request, store StoreInterface) (interface{}, error) {
+	if req.Method != "GET" {
+		return nil, fmt.Errorf("unsupported method: %s", req.Method)
+	}
+
+	subscriptionID := req.Params["subscriptionId"]
+	if subscriptionID == "" {
+		// List the subscriptions the caller can see
+		subscriptions := []interface{}{}
+		for _, sub := range store.GetSubscriptions() {
+			subMap, ok := sub.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, _ := subMap["subscriptionId"].(string)
+			if req.Principal != nil && !req.Principal.CanAccessSubscription(id) {
+				continue
+			}
+			subscriptions = append(subscriptions, convertSubscriptionToARMFormat(subMap))
+		}
+		return map[string]interface{}{
+			"value": subscriptions,
+			"count": map[string]interface{}{"type": "Total", "value": len(subscriptions)},
+		}, nil
+	}
+
+	// Access was checked by MapARMRequest, so the subscription exists
+	subMap := findSubscription(subscriptionID, store)
+	if strings.HasSuffix(strings.ToLower(req.PathPattern), "/locations") {
+		return map[string]interface{}{
+			"value": subscriptionLocations(subMap),
+		}, nil
+	}
+	return convertSubscriptionToARMFormat(subMap), nil
+}
+
+// mapTenantsResponse handles Tenants_List. Callers only reach subscriptions in their home
+// tenant, so an authenticated caller sees that tenant alone; anonymous callers see every tenant.
+func mapTenantsResponse(req *Request, store StoreInterface) (interface{}, error) {
+	if req.Method != "GET" {
+		return nil, fmt.Errorf("unsupported method: %s", req.Method)
+	}
+
+	tenants := []interface{}{}
+	for _, t := range store.GetTenants() {
+		tMap, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tenantID, _ := tMap["tenantId"].(string)
+		if req.Principal != nil && !strings.EqualFold(tenantID, req.Principal.TenantID()) {
+			continue
+		}
+		tenants = append(tenants, map[string]interface{}{
+			"id":             tMap["id"],
+			"tenantId":       tenantID,
+			"tenantCategory": "Home",
+			"displayName":    tMap["displayName"],
+			"defaultDomain":  tMap["defaultDomain"],
+			"domains":        tMap["domains"],
+			"tenantType":     "AAD",
+			"country":        "United States",
+			"countryCode":    "US",
+		})
+	}
+
+	return map[string]interface{}{
+		"value": tenants,
+	}, nil
+}
+
+// findSubscription returns a subscription from the store by its GUID
+func findSubscription(subscriptionID string, store StoreInterface) map[string]interface{} {
+	for _, sub := range store.GetSubscriptions() {
+		if subMap, ok := sub.(map[string]interface{}); ok {
+			if id, ok := subMap["subscriptionId"].(string); ok && strings.EqualFold(id, subscriptionID) {
+				return subMap
+			}
+		}
+	}
+	return nil
+}
+
+// convertSubscriptionToARMFormat converts a subscription from internal format to ARM API format
+func convertSubscriptionToARMFormat(sub map[string]interface{}) map[string]interface{} {
+	tags := sub["tags"]
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	return map[string]interface{}{
+		"id":                  sub["id"],
+		"subscriptionId":      sub["subscriptionId"],
+		"tenantId":            sub["tenantId"],
+		"displayName":         sub["displayName"],
+		"state":               sub["state"],
+		"authorizationSource": "RoleBased",
+		"managedByTenants":    []interface{}{},
+		"subscriptionPolicies": map[string]interface{}{
+			"locationPlacementId": "Public_2014-09-01",
+			"quotaId":             "PayAsYouGo_2014-09-01",
+			"spendingLimit":       "Off",
+		},
+		"tags": tags,
+	}
+}
+
+// subscriptionLocations lists the regions of a subscription in ARM format.
+// Subscriptions without configured locations get the whole built-in catalog.
+func subscriptionLocations(sub map[string]interface{}) []interface{} {
+	subID, _ := sub["id"].(string)
+	names, _ := sub["locations"].([]string)
+
+	regions := knownRegions
+	if len(names) > 0 {
+		regions = make([]region, 0, len(names))
+		for _, name := range names {
+			regions = append(regions, lookupRegion(name))
+		}
+	}
+
+	locations := make([]interface{}, 0, len(regions))
+	for _, r := range regions {
+		metadata := map[string]interface{}{
+			"regionType":       "Physical",
+			"regionCategory":   "Recommended",
+			"geographyGroup":   r.GeographyGroup,
+			"physicalLocation": r.PhysicalLocation,
+			"latitude":         r.Latitude,
+			"longitude":        r.Longitude,
+		}
+		if r.PairedRegion != "" {
+			metadata["pairedRegion"] = []interface{}{
+				map[string]interface{}{
+					"name": r.PairedRegion,
+					"id":   subID + "/locations/" + r.PairedRegion,
+				},
+			}
+		}
+		locations = append(locations, map[string]interface{}{
+			"id":                  subID + "/locations/" + r.Name,
+			"name":                r.Name,
+			"type":                "Region",
+			"displayName":         r.DisplayName,
+			"regionalDisplayName": r.RegionalDisplayName,
+			"metadata":            metadata,
+		})
+	}
+	return locations
+}
+
+// lookupRegion finds a region in the catalog by name or display name.
+// Unknown regions are returned with only their names filled in.
+func lookupRegion(location string) region {
+	name := NormalizeLocation(location)
+	for _, r := range knownRegions {
+		if r.Name == name {
+			return r
+		}
+	}
+	return region{Name: name, DisplayName: location, RegionalDisplayName: location}
+}
@@ -0,0 +1,644 @@
+package mappers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// templateContext evaluates ARM template language expressions for one deployment
+// See https://learn.microsoft.com/azure/azure-resource-manager/templates/template-expressions
+type templateContext struct {
+	subscriptionID string
+	tenantID       string
+	resourceGroup  map[string]interface{} // nil at subscription scope
+	deploymentName string
+
+	parameterDefs map[string]interface{}
+	provided      map[string]interface{} // parameter values supplied with the deployment
+	parameters    map[string]interface{} // resolved parameter values, by lowercased name
+	variableDefs  map[string]interface{}
+	variables     map[string]interface{} // evaluated variables, by lowercased name
+	resolving     map[string]bool        // guards against parameters or variables that refer to themselves
+}
+
+// invalidTemplate returns the error ARM reports when a template fails validation
+func invalidTemplate(format string, args ...interface{}) *ARMError {
+	return &ARMError{
+		StatusCode: http.StatusBadRequest,
+		Code:       "InvalidTemplate",
+		Message:    fmt.Sprintf("Deployment template validation failed: '%s'.", fmt.Sprintf(format, args...)),
+	}
+}
+
+// lookupFold returns the value of a map key, ignoring case like the template language does
+func lookupFold(m map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// parameter resolves a parameter from the supplied values or its default value
+func (c *templateContext) parameter(name string) (interface{}, error) {
+	key := strings.ToLower(name)
+	if v, ok := c.parameters[key]; ok {
+		return v, nil
+	}
+	def, ok := lookupFold(c.parameterDefs, name)
+	if !ok {
+		return nil, invalidTemplate("The template parameter '%s' is not found", name)
+	}
+	if c.resolving["parameters:"+key] {
+		return nil, invalidTemplate("The template parameter '%s' refers to itself", name)
+	}
+	c.resolving["parameters:"+key] = true
+	defer delete(c.resolving, "parameters:"+key)
+
+	defMap, _ := def.(map[string]interface{})
+	var value interface{}
+	if supplied, ok := lookupFold(c.provided, name); ok {
+		suppliedMap, _ := supplied.(map[string]interface{})
+		if value, ok = suppliedMap["value"]; !ok {
+			return nil, invalidTemplate("The value for the template parameter '%s' must be given as an object with a 'value' property", name)
+		}
+	} else if defaultValue, ok := defMap["defaultValue"]; ok {
+		var err error
+		if value, err = c.evaluate(defaultValue); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, invalidTemplate("The value for the template parameter '%s' is not provided", name)
+	}
+
+	if allowed, ok := defMap["allowedValues"].([]interface{}); ok {
+		found := false
+		for _, a := range allowed {
+			if jsonEqual(a, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, invalidTemplate("The provided value '%v' for the template parameter '%s' is not valid. The parameter value is not part of the allowed value(s): '%s'",
+				value, name, templateString(allowed))
+		}
+	}
+	c.parameters[key] = value
+	return value, nil
+}
+
+// variable evaluates a template variable, which may refer to parameters and other variables
+func (c *templateContext) variable(name string) (interface{}, error) {
+	key := strings.ToLower(name)
+	if v, ok := c.variables[key]; ok {
+		return v, nil
+	}
+	def, ok := lookupFold(c.variableDefs, name)
+	if !ok {
+		return nil, invalidTemplate("The template variable '%s' is not found", name)
+	}
+	if c.resolving["variables:"+key] {
+		return nil, invalidTemplate("The template variable '%s' refers to itself", name)
+	}
+	c.resolving["variables:"+key] = true
+	defer delete(c.resolving, "variables:"+key)
+
+	value, err := c.evaluate(def)
+	if err != nil {
+		return nil, err
+	}
+	c.variables[key] = value
+	return value, nil
+}
+
+// evaluate evaluates every expression in a template value.
+// Strings wrapped in brackets are expressions; a leading "[[" escapes a literal bracket.
+func (c *templateContext) evaluate(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		if len(t) < 2 || t[0] != '[' || t[len(t)-1] != ']' {
+			return t, nil
+		}
+		if strings.HasPrefix(t, "[[") {
+			return t[1:], nil
+		}
+		p := &expressionParser{ctx: c, src: t[1 : len(t)-1]}
+		result, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			evaluated, err := c.evaluate(item)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = evaluated
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(t))
+		for i, item := range t {
+			evaluated, err := c.evaluate(item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = evaluated
+		}
+		return result, nil
+	default:
+		return v, nil
+	}
+}
+
+// evaluateString evaluates a template value that must produce a string
+func (c *templateContext) evaluateString(v interface{}, what string) (string, error) {
+	evaluated, err := c.evaluate(v)
+	if err != nil {
+		return "", err
+	}
+	s, ok := evaluated.(string)
+	if !ok {
+		return "", invalidTemplate("The template %s must be a string", what)
+	}
+	return s, nil
+}
+
+// expressionParser evaluates a single template expression as it parses it
+type expressionParser struct {
+	ctx *templateContext
+	src string
+	pos int
+}
+
+// parse evaluates the whole expression
+func (p *expressionParser) parse() (interface{}, error) {
+	v, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected '%s'", p.src[p.pos:])
+	}
+	return v, nil
+}
+
+func (p *expressionParser) errorf(format string, args ...interface{}) error {
+	return invalidTemplate("The language expression '%s' is not valid: %s", p.src, fmt.Sprintf(format, args...))
+}
+
+func (p *expressionParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t' || p.src[p.pos] == '\n' || p.src[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space character, or 0 at the end of the expression
+func (p *expressionParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// expression parses a literal or function call followed by any property and index accessors
+func (p *expressionParser) expression() (interface{}, error) {
+	var value interface{}
+	var err error
+	switch c := p.peek(); {
+	case c == '\'':
+		value, err = p.stringLiteral()
+	case c == '-' || (c >= '0' && c <= '9'):
+		value, err = p.number()
+	case isIdentStart(c):
+		value, err = p.call()
+	case c == 0:
+		return nil, p.errorf("unexpected end of expression")
+	default:
+		return nil, p.errorf("unexpected '%c'", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch p.peek() {
+		case '.':
+			p.pos++
+			p.skipSpace()
+			start := p.pos
+			for p.pos < len(p.src) && isIdentPart(p.src[p.pos]) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("expected a property name after '.'")
+			}
+			if value, err = p.index(value, p.src[start:p.pos]); err != nil {
+				return nil, err
+			}
+		case '[':
+			p.pos++
+			key, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if p.peek() != ']' {
+				return nil, p.errorf("expected ']'")
+			}
+			p.pos++
+			if value, err = p.index(value, key); err != nil {
+				return nil, err
+			}
+		default:
+			return value, nil
+		}
+	}
+}
+
+// index reads a property of an object or an element of an array
+func (p *expressionParser) index(value, key interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		name, ok := key.(string)
+		if !ok {
+			return nil, p.errorf("objects can only be indexed by property name")
+		}
+		item, ok := lookupFold(v, name)
+		if !ok {
+			return nil, p.errorf("the object has no property '%s'", name)
+		}
+		return item, nil
+	case []interface{}:
+		i, ok := key.(float64)
+		if !ok || i != float64(int(i)) || int(i) < 0 || int(i) >= len(v) {
+			return nil, p.errorf("the array index '%v' is out of range", key)
+		}
+		return v[int(i)], nil
+	default:
+		return nil, p.errorf("cannot read '%v' from a value of type %T", key, value)
+	}
+}
+
+// stringLiteral parses a single-quoted string, where a doubled quote escapes a quote
+func (p *expressionParser) stringLiteral() (interface{}, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		if c != '\'' {
+			b.WriteByte(c)
+			continue
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == '\'' {
+			b.WriteByte('\'')
+			p.pos++
+			continue
+		}
+		return b.String(), nil
+	}
+	return nil, p.errorf("unterminated string literal")
+}
+
+// number parses an integer or decimal literal as a JSON number
+func (p *expressionParser) number() (interface{}, error) {
+	start := p.pos
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+		p.pos++
+	}
+	n, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+	if err != nil {
+		return nil, p.errorf("invalid number '%s'", p.src[start:p.pos])
+	}
+	return n, nil
+}
+
+// call parses a function call and evaluates it
+func (p *expressionParser) call() (interface{}, error) {
+	start := p.pos
+	for p.pos < len(p.src) && isIdentPart(p.src[p.pos]) {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+	if p.peek() != '(' {
+		return nil, p.errorf("expected '(' after '%s'", name)
+	}
+	p.pos++
+
+	args := []interface{}{}
+	if p.peek() == ')' {
+		p.pos++
+	} else {
+		for {
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			c := p.peek()
+			p.pos++
+			if c == ')' {
+				break
+			}
+			if c != ',' {
+				return nil, p.errorf("expected ',' or ')' in the arguments of '%s'", name)
+			}
+		}
+	}
+	return p.ctx.callFunction(name, args)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// callFunction evaluates a template function
+// See https://learn.microsoft.com/azure/azure-resource-manager/templates/template-functions
+func (c *templateContext) callFunction(name string, args []interface{}) (interface{}, error) {
+	strs := func() ([]string, error) {
+		result := make([]string, len(args))
+		for i, a := range args {
+			s, ok := a.(string)
+			if !ok {
+				return nil, invalidTemplate("The template function '%s' expects string arguments, got '%v'", name, a)
+			}
+			result[i] = s
+		}
+		return result, nil
+	}
+	arity := func(n int) error {
+		if len(args) != n {
+			return invalidTemplate("The template function '%s' expects %d argument(s), got %d", name, n, len(args))
+		}
+		return nil
+	}
+
+	switch strings.ToLower(name) {
+	case "parameters", "variables":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		s, err := strs()
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(name, "parameters") {
+			return c.parameter(s[0])
+		}
+		return c.variable(s[0])
+
+	case "concat":
+		if len(args) > 0 {
+			if _, isArray := args[0].([]interface{}); isArray {
+				result := []interface{}{}
+				for _, a := range args {
+					items, ok := a.([]interface{})
+					if !ok {
+						return nil, invalidTemplate("The template function 'concat' cannot mix arrays with other values")
+					}
+					result = append(result, items...)
+				}
+				return result, nil
+			}
+		}
+		var b strings.Builder
+		for _, a := range args {
+			b.WriteString(templateString(a))
+		}
+		return b.String(), nil
+
+	case "format":
+		if len(args) == 0 {
+			return nil, invalidTemplate("The template function 'format' expects a format string")
+		}
+		format, ok := args[0].(string)
+		if !ok {
+			return nil, invalidTemplate("The template function 'format' expects a format string")
+		}
+		for i, a := range args[1:] {
+			format = strings.ReplaceAll(format, "{"+strconv.Itoa(i)+"}", templateString(a))
+		}
+		return format, nil
+
+	case "tolower", "toupper":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		s, err := strs()
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(name, "toLower") {
+			return strings.ToLower(s[0]), nil
+		}
+		return strings.ToUpper(s[0]), nil
+
+	case "uniquestring":
+		if len(args) == 0 {
+			return nil, invalidTemplate("The template function 'uniqueString' expects at least one argument")
+		}
+		s, err := strs()
+		if err != nil {
+			return nil, err
+		}
+		return uniqueString(s...), nil
+
+	case "resourceid", "subscriptionresourceid":
+		s, err := strs()
+		if err != nil {
+			return nil, err
+		}
+		return c.resourceID(name, s)
+
+	case "resourcegroup":
+		if c.resourceGroup == nil {
+			return nil, invalidTemplate("The template function 'resourceGroup' is not expected at this location. It can only be used in resource group deployments")
+		}
+		return c.resourceGroup, nil
+
+	case "subscription":
+		return map[string]interface{}{
+			"id":             "/subscriptions/" + c.subscriptionID,
+			"subscriptionId": c.subscriptionID,
+			"tenantId":       c.tenantID,
+		}, nil
+
+	case "deployment":
+		return map[string]interface{}{
+			"name":       c.deploymentName,
+			"properties": map[string]interface{}{},
+		}, nil
+
+	case "if":
+		if err := arity(3); err != nil {
+			return nil, err
+		}
+		if cond, ok := args[0].(bool); !ok {
+			return nil, invalidTemplate("The template function 'if' expects a boolean condition")
+		} else if cond {
+			return args[1], nil
+		}
+		return args[2], nil
+
+	case "equals":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		return jsonEqual(args[0], args[1]), nil
+
+	case "not":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		b, ok := args[0].(bool)
+		if !ok {
+			return nil, invalidTemplate("The template function 'not' expects a boolean")
+		}
+		return !b, nil
+
+	case "and", "or":
+		isAnd := strings.EqualFold(name, "and")
+		result := isAnd
+		for _, a := range args {
+			b, ok := a.(bool)
+			if !ok {
+				return nil, invalidTemplate("The template function '%s' expects booleans", name)
+			}
+			if isAnd {
+				result = result && b
+			} else {
+				result = result || b
+			}
+		}
+		return result, nil
+
+	case "true", "false":
+		return strings.EqualFold(name, "true"), nil
+
+	default:
+		return nil, invalidTemplate("The template function '%s' is not supported", name)
+	}
+}
+
+// resourceID implements resourceId([subscriptionId], [resourceGroupName], resourceType, name1, ...)
+// and subscriptionResourceId([subscriptionId], resourceType, name1, ...)
+func (c *templateContext) resourceID(function string, args []string) (interface{}, error) {
+	typeIndex := -1
+	for i, a := range args {
+		if strings.Contains(a, "/") {
+			typeIndex = i
+			break
+		}
+	}
+	if typeIndex < 0 {
+		return nil, invalidTemplate("The template function '%s' expects a fully qualified resource type", function)
+	}
+	resourceType, names := args[typeIndex], args[typeIndex+1:]
+	typeSegments := strings.Split(resourceType, "/")
+	if len(names) != len(typeSegments)-1 {
+		return nil, invalidTemplate("The template function '%s' expects %d resource name(s) for type '%s'", function, len(typeSegments)-1, resourceType)
+	}
+
+	subscriptionID, resourceGroup := c.subscriptionID, ""
+	if c.resourceGroup != nil && !strings.EqualFold(function, "subscriptionResourceId") {
+		resourceGroup, _ = c.resourceGroup["name"].(string)
+	}
+	switch scope := args[:typeIndex]; {
+	case strings.EqualFold(function, "subscriptionResourceId") && len(scope) == 1:
+		subscriptionID = scope[0]
+	case len(scope) == 1:
+		resourceGroup = scope[0]
+	case len(scope) == 2 && !strings.EqualFold(function, "subscriptionResourceId"):
+		subscriptionID, resourceGroup = scope[0], scope[1]
+	case len(scope) != 0:
+		return nil, invalidTemplate("The template function '%s' has too many scope arguments", function)
+	}
+
+	// Resource groups are addressed by their own ID, which lets dependsOn refer to them
+	if strings.EqualFold(resourceType, "Microsoft.Resources/resourceGroups") {
+		return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionID, names[0]), nil
+	}
+	id := "/subscriptions/" + subscriptionID
+	if resourceGroup != "" {
+		id += "/resourceGroups/" + resourceGroup
+	}
+	id += "/providers/" + typeSegments[0]
+	for i, name := range names {
+		id += "/" + typeSegments[i+1] + "/" + name
+	}
+	return id, nil
+}
+
+// uniqueStringAlphabet is the base32 alphabet of uniqueString results
+const uniqueStringAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+// uniqueString returns a deterministic 13-character hash of its arguments.
+// It is stable across deployments like ARM's, but not byte-for-byte the same value.
+func uniqueString(values ...string) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(values, "-")))
+	sum := binary.BigEndian.Uint64(h.Sum(nil))
+	b := make([]byte, 13)
+	for i := range b {
+		b[i] = uniqueStringAlphabet[sum>>59]
+		sum <<= 5
+	}
+	return string(b)
+}
+
+// templateString converts a template value to the string concat and format produce
+func templateString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		if t {
+			return "True"
+		}
+		return "False"
+	case []interface{}:
+		parts := make([]string, len(t))
+		for i, item := range t {
+			parts[i] = templateString(item)
+		}
+		return strings.Join(parts, ", ")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = k + ": " + templateString(t[k])
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(t)
+	}
+}
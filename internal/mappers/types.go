@@ -1,5 +1,15 @@
 package mappers
 
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNotMapped reports an operation no mapper handles. Spec-driven routes answer it with a
+// response built from the spec.
+var ErrNotMapped = errors.New("operation is not mapped")
+
 // StoreInterface defines the interface for accessing store data
 // This allows mappers to work with the Store without tight coupling
 type StoreInterface interface {
@@ -7,5 +17,192 @@ type StoreInterface interface {
 	GetVMs() []interface{}
 	GetUsers() []interface{}
 	GetServiceAccounts() []interface{}
+	GetTenants() []interface{}
+	GetSubscriptions() []interface{}
+}
+
+// ResourceStore persists ARM resources written through the ARM API
+// Resources are exchanged in their ARM JSON shape
+type ResourceStore interface {
+	GetResources() []interface{}
+	PutResource(resource map[string]interface{}) error
+	DeleteResource(id string) bool
+	PutResourceGroup(resourceGroup map[string]interface{}) error
+	DeleteResourceGroup(subscriptionID, name string) bool
+	SetTags(id string, tags map[string]string) bool
+	MoveResource(id, targetSubscriptionID, targetResourceGroup string) bool
+}
+
+// Operation is the recorded outcome of a long-running operation
+// Mockzure completes operations before answering 202, so polling returns the outcome at once
+type Operation struct {
+	ID         string
+	StatusCode int         // status returned when polling; 204 when there is no body
+	Body       interface{} // result returned when polling, if any
+	Error      *ARMError   // set when the operation failed
+}
+
+// OperationStore records long-running operations so they can be polled
+type OperationStore interface {
+	PutOperation(op *Operation)
+	GetOperation(id string) *Operation
+}
+
+// VMStore creates or updates virtual machines written through the ARM API
+// VMs are exchanged in the same shape GetVMs returns
+type VMStore interface {
+	PutVM(vm map[string]interface{}) error
+}
+
+// ScaleSetStore keeps the VM instances of virtual machine scale sets
+// Instances are exchanged in the shape GetVMs returns, plus "scaleSetId", "instanceId",
+// "latestModelApplied" and "protectionPolicy"
+type ScaleSetStore interface {
+	GetScaleSetVMs(scaleSetID string) []interface{}
+	// PutScaleSetVM creates an instance when "instanceId" is empty, and returns the instance's ID
+	PutScaleSetVM(vm map[string]interface{}) (string, error)
+	DeleteScaleSetVM(id string) bool
+}
+
+// CapacityStore supplies the configured VM sizes and quotas, which extend and override the
+// built-in catalog. Sizes are exchanged with "name", "family", "vCPUs", "memoryGB",
+// "maxDataDiskCount", "resourceDiskSizeMB", "locations" and "restrictions"; quotas with
+// "location", "name" and "limit".
+type CapacityStore interface {
+	GetVMSizes() []interface{}
+	GetQuotas() []interface{}
+}
+
+// RunCommandStore scripts the output of run commands and custom script extensions, and
+// records their invocations. Responders are exchanged with "vm", "commandId", "script" (a
+// regular expression), "stdout", "stderr" and "exitCode".
+type RunCommandStore interface {
+	GetRunCommandResponders() []interface{}
+	RecordRunCommand(invocation map[string]interface{}) error
+}
+
+// DeploymentStore persists template deployments in their ARM JSON shape
+// Each deployment also keeps its "template" and "operations" for later export and listing
+type DeploymentStore interface {
+	GetDeployments() []interface{}
+	PutDeployment(deployment map[string]interface{}) error
+	DeleteDeployment(id string) bool
+}
+
+// Principal is the authenticated caller of a request
+// A nil Principal means the request was anonymous and is not filtered
+type Principal interface {
+	TenantID() string
+	CanAccessSubscription(subscriptionID string) bool
+	HasPermission(subscriptionID, resourceGroup, permission string) bool
+}
+
+// Authenticator resolves the caller of a request
+// Stores that implement it get per-caller filtering in the ARM mappers
+type Authenticator interface {
+	Authenticate(r *http.Request) Principal
 }
 
+// Request carries everything a mapper needs to know about an incoming call
+type Request struct {
+	OperationID string
+	PathPattern string
+	Method      string
+	Params      map[string]string
+	Principal   Principal
+	Body        []byte
+	BaseURL     string // scheme and host the request was sent to, for Location headers
+}
+
+// Response is a mapper result that needs a status code or headers other than 200 OK
+// A nil Body writes no response body
+type Response struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       interface{}
+}
+
+// ARMError is an error that maps onto an ARM error response body
+// See https://learn.microsoft.com/azure/azure-resource-manager/management/common-deployment-errors
+type ARMError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Target     string
+	Details    []*ARMError
+}
+
+func (e *ARMError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Body returns the ARM error envelope for the response
+func (e *ARMError) Body() map[string]interface{} {
+	return map[string]interface{}{
+		"error": e.detail(),
+	}
+}
+
+// detail returns the error object, including its target and nested details when set
+func (e *ARMError) detail() map[string]interface{} {
+	detail := map[string]interface{}{
+		"code":    e.Code,
+		"message": e.Message,
+	}
+	if e.Target != "" {
+		detail["target"] = e.Target
+	}
+	if len(e.Details) > 0 {
+		details := make([]interface{}, len(e.Details))
+		for i, d := range e.Details {
+			details[i] = d.detail()
+		}
+		detail["details"] = details
+	}
+	return detail
+}
+
+// subscriptionNotFound returns the error ARM reports for unknown or inaccessible subscriptions
+func subscriptionNotFound(subscriptionID string) *ARMError {
+	return &ARMError{
+		StatusCode: http.StatusNotFound,
+		Code:       "SubscriptionNotFound",
+		Message:    fmt.Sprintf("The subscription '%s' could not be found.", subscriptionID),
+	}
+}
+
+// resourceGroupNotFound returns the error ARM reports for missing resource groups
+func resourceGroupNotFound(name string) *ARMError {
+	return &ARMError{
+		StatusCode: http.StatusNotFound,
+		Code:       "ResourceGroupNotFound",
+		Message:    fmt.Sprintf("Resource group '%s' could not be found.", name),
+	}
+}
+
+// resourceNotFound returns the error ARM reports for missing resources
+func resourceNotFound(resourceType, name, resourceGroup string) *ARMError {
+	return &ARMError{
+		StatusCode: http.StatusNotFound,
+		Code:       "ResourceNotFound",
+		Message:    fmt.Sprintf("The Resource '%s/%s' under resource group '%s' was not found. For more details please go to https://aka.ms/ARMResourceNotFoundFix", resourceType, name, resourceGroup),
+	}
+}
+
+// invalidRequestContent returns the error ARM reports for bodies it cannot deserialize
+func invalidRequestContent(err error) *ARMError {
+	return &ARMError{
+		StatusCode: http.StatusBadRequest,
+		Code:       "InvalidRequestContent",
+		Message:    fmt.Sprintf("The request content was invalid and could not be deserialized: '%v'.", err),
+	}
+}
+
+// authorizationFailed returns the error ARM reports when the caller lacks a permission
+func authorizationFailed(action, scope string) *ARMError {
+	return &ARMError{
+		StatusCode: http.StatusForbidden,
+		Code:       "AuthorizationFailed",
+		Message:    fmt.Sprintf("The client does not have authorization to perform action '%s' over scope '%s' or the scope is invalid. If access was recently granted, please refresh your credentials.", action, scope),
+	}
+}
@@ -0,0 +1,716 @@
+package mappers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The optional VM profile (image, disks, NICs, OS profile, agent, extensions and zones)
+// is kept in the store view of a VM under the keys below. Unset parts get the values
+// Azure would report for a VM created from a marketplace image.
+// See https://learn.microsoft.com/rest/api/compute/virtual-machines/get
+
+const (
+	defaultVMAdminUsername     = "azureuser"
+	defaultVMStorageAccount    = "Premium_LRS"
+	defaultVMAgentVersion      = "2.9.1.1"
+	defaultLinuxOSDiskSizeGB   = 30
+	defaultWindowsOSDiskSizeGB = 127
+	defaultDataDiskSizeGB      = 128
+	vmExtensionType            = "Microsoft.Compute/virtualMachines/extensions"
+	provisioningSucceededCode  = "ProvisioningState/succeeded"
+)
+
+// vmStorageProfile returns the ARM storageProfile of a VM
+func vmStorageProfile(vm map[string]interface{}) map[string]interface{} {
+	vmID, _ := vm["id"].(string)
+	name, _ := vm["name"].(string)
+	osType, _ := vm["osType"].(string)
+
+	osDisk, _ := vm["osDisk"].(map[string]interface{})
+	defaultSize := defaultLinuxOSDiskSizeGB
+	if strings.EqualFold(osType, "windows") {
+		defaultSize = defaultWindowsOSDiskSizeGB
+	}
+	armOSDisk := vmDisk(vmID, osDisk, name+"_OsDisk_1", "FromImage", "ReadWrite", defaultSize)
+	armOSDisk["osType"] = vm["osType"]
+
+	dataDisks := []interface{}{}
+	for i, d := range vmDataDisks(vm) {
+		disk := vmDisk(vmID, d, fmt.Sprintf("%s_DataDisk_%d", name, i), "Empty", "None", defaultDataDiskSizeGB)
+		disk["lun"] = intValue(d["lun"])
+		dataDisks = append(dataDisks, disk)
+	}
+
+	profile := map[string]interface{}{
+		"osDisk":    armOSDisk,
+		"dataDisks": dataDisks,
+	}
+	if image, ok := vm["imageReference"].(map[string]interface{}); ok {
+		armImage := map[string]interface{}{}
+		for _, key := range []string{"publisher", "offer", "sku", "version"} {
+			if v, ok := image[key]; ok {
+				armImage[key] = v
+			}
+		}
+		if version, _ := image["version"].(string); version != "" {
+			// Azure reports the version the "latest" alias resolved to
+			armImage["exactVersion"] = version
+			if strings.EqualFold(version, "latest") {
+				armImage["exactVersion"] = "1.0.0"
+			}
+		}
+		profile["imageReference"] = armImage
+	}
+	return profile
+}
+
+// vmDisk returns the ARM view of an OS or data disk, filling in defaults
+func vmDisk(vmID string, disk map[string]interface{}, defaultName, defaultCreate, defaultCaching string, defaultSize int) map[string]interface{} {
+	str := func(key, fallback string) string {
+		if v, _ := disk[key].(string); v != "" {
+			return v
+		}
+		return fallback
+	}
+	name := str("name", defaultName)
+	size := intValue(disk["diskSizeGB"])
+	if size == 0 {
+		size = defaultSize
+	}
+	// Disks created with the VM are deleted with it; attached existing disks are kept
+	id, deleteOption := vmResourceGroupID(vmID)+"/providers/Microsoft.Compute/disks/"+name, "Delete"
+	if existing := str("id", ""); existing != "" {
+		id, deleteOption = existing, "Detach"
+	}
+	return map[string]interface{}{
+		"name":         name,
+		"createOption": str("createOption", defaultCreate),
+		"caching":      str("caching", defaultCaching),
+		"diskSizeGB":   size,
+		"deleteOption": deleteOption,
+		"managedDisk": map[string]interface{}{
+			"id":                 id,
+			"storageAccountType": str("storageAccountType", defaultVMStorageAccount),
+		},
+	}
+}
+
+// vmDataDisks returns the data disks in the store view of a VM
+func vmDataDisks(vm map[string]interface{}) []map[string]interface{} {
+	disks := []map[string]interface{}{}
+	list, _ := vm["dataDisks"].([]interface{})
+	for _, d := range list {
+		if disk, ok := d.(map[string]interface{}); ok {
+			disks = append(disks, disk)
+		}
+	}
+	return disks
+}
+
+// vmOSProfile returns the ARM osProfile of a VM
+func vmOSProfile(vm map[string]interface{}) map[string]interface{} {
+	osProfile, _ := vm["osProfile"].(map[string]interface{})
+	computerName, _ := osProfile["computerName"].(string)
+	if computerName == "" {
+		computerName, _ = vm["name"].(string)
+	}
+	adminUsername, _ := osProfile["adminUsername"].(string)
+	if adminUsername == "" {
+		adminUsername = defaultVMAdminUsername
+	}
+	profile := map[string]interface{}{
+		"computerName":                computerName,
+		"adminUsername":               adminUsername,
+		"secrets":                     []interface{}{},
+		"allowExtensionOperations":    true,
+		"requireGuestProvisionSignal": true,
+	}
+	if osType, _ := vm["osType"].(string); strings.EqualFold(osType, "windows") {
+		profile["windowsConfiguration"] = map[string]interface{}{
+			"provisionVMAgent":       true,
+			"enableAutomaticUpdates": true,
+		}
+	} else {
+		profile["linuxConfiguration"] = map[string]interface{}{
+			"disablePasswordAuthentication": true,
+			"provisionVMAgent":              true,
+		}
+	}
+	return profile
+}
+
+// vmNetworkProfile returns the ARM networkProfile of a VM
+func vmNetworkProfile(vm map[string]interface{}) map[string]interface{} {
+	nics := []interface{}{}
+	list, _ := vm["networkInterfaces"].([]interface{})
+	for _, n := range list {
+		nic, ok := n.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		primary, _ := nic["primary"].(bool)
+		nics = append(nics, map[string]interface{}{
+			"id": nic["id"],
+			"properties": map[string]interface{}{
+				"primary":      primary || len(list) == 1,
+				"deleteOption": "Detach",
+			},
+		})
+	}
+	return map[string]interface{}{"networkInterfaces": nics}
+}
+
+// vmExtensions returns the extensions of a VM as ARM child resources
+func vmExtensions(vm map[string]interface{}) []interface{} {
+	vmID, _ := vm["id"].(string)
+	extensions := []interface{}{}
+	list, _ := vm["extensions"].([]interface{})
+	for _, e := range list {
+		ext, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := ext["name"].(string)
+		state, _ := ext["provisioningState"].(string)
+		if state == "" {
+			state = "Succeeded"
+		}
+		properties := map[string]interface{}{
+			"publisher":               ext["publisher"],
+			"type":                    ext["type"],
+			"typeHandlerVersion":      ext["typeHandlerVersion"],
+			"autoUpgradeMinorVersion": true,
+			"provisioningState":       state,
+		}
+		if settings, ok := ext["settings"].(map[string]interface{}); ok {
+			properties["settings"] = settings
+		}
+		extensions = append(extensions, map[string]interface{}{
+			"id":         vmID + "/extensions/" + name,
+			"name":       name,
+			"type":       vmExtensionType,
+			"location":   vm["location"],
+			"properties": properties,
+		})
+	}
+	return extensions
+}
+
+// vmInstanceView returns the runtime view of a VM: power and provisioning state,
+// guest agent, disk and extension statuses
+func vmInstanceView(vm map[string]interface{}) map[string]interface{} {
+	status, _ := vm["status"].(string)
+	running := status == "running"
+	provisioningState := fmt.Sprint(vm["provisioningState"])
+	powerStateCode := "PowerState/" + status
+	if status == "stopped" {
+		powerStateCode = "PowerState/deallocated"
+	}
+
+	view := map[string]interface{}{
+		"computerName": vmOSProfile(vm)["computerName"],
+		"statuses": []interface{}{
+			vmStatus("ProvisioningState/"+strings.ToLower(provisioningState), "Provisioning "+strings.ToLower(provisioningState)),
+			vmStatus(powerStateCode, fmt.Sprint(vm["powerState"])),
+		},
+	}
+
+	// The guest agent only reports while the VM runs, unless the config pins its status
+	agent, _ := vm["vmAgent"].(map[string]interface{})
+	agentStatus, _ := agent["status"].(string)
+	if agentStatus == "" {
+		agentStatus = "Not Ready"
+		if running {
+			agentStatus = "Ready"
+		}
+	}
+	agentVersion, _ := agent["version"].(string)
+	if agentVersion == "" {
+		agentVersion = defaultVMAgentVersion
+	}
+	agentCode, agentMessage := provisioningSucceededCode, "Guest Agent is running"
+	if agentStatus != "Ready" {
+		agentCode, agentMessage = "ProvisioningState/Unavailable", "VM status blob is found but not yet populated."
+	}
+	// Custom script extensions also report the output of their script
+	outputs := map[string]map[string]interface{}{}
+	list, _ := vm["extensions"].([]interface{})
+	for _, e := range list {
+		if ext, ok := e.(map[string]interface{}); ok && (ext["stdout"] != nil || ext["stderr"] != nil) {
+			outputs[fmt.Sprint(ext["name"])] = ext
+		}
+	}
+	handlers := []interface{}{}
+	extensions := []interface{}{}
+	for _, e := range vmExtensions(vm) {
+		ext := e.(map[string]interface{})
+		properties := ext["properties"].(map[string]interface{})
+		state := fmt.Sprint(properties["provisioningState"])
+		handlers = append(handlers, map[string]interface{}{
+			"type":               fmt.Sprintf("%v.%v", properties["publisher"], properties["type"]),
+			"typeHandlerVersion": properties["typeHandlerVersion"],
+			"status":             vmStatus(agentCode, agentStatus),
+		})
+		extView := map[string]interface{}{
+			"name":               ext["name"],
+			"type":               fmt.Sprintf("%v.%v", properties["publisher"], properties["type"]),
+			"typeHandlerVersion": properties["typeHandlerVersion"],
+			"statuses": []interface{}{
+				vmStatus("ProvisioningState/"+strings.ToLower(state), "Provisioning "+strings.ToLower(state)),
+			},
+		}
+		if raw, ok := outputs[fmt.Sprint(ext["name"])]; ok {
+			output := runCommandOutput{Stdout: fmt.Sprint(raw["stdout"]), Stderr: fmt.Sprint(raw["stderr"]), ExitCode: intValue(raw["exitCode"])}
+			statuses := runCommandStatuses(fmt.Sprint(vm["osType"]), output)
+			if strings.EqualFold(fmt.Sprint(vm["osType"]), "windows") {
+				extView["substatuses"] = statuses
+			} else {
+				extView["statuses"] = statuses
+			}
+		}
+		extensions = append(extensions, extView)
+	}
+	agentView := vmStatus(agentCode, agentStatus)
+	agentView["message"] = agentMessage
+	view["vmAgent"] = map[string]interface{}{
+		"vmAgentVersion":    agentVersion,
+		"statuses":          []interface{}{agentView},
+		"extensionHandlers": handlers,
+	}
+	view["extensions"] = extensions
+
+	disks := []interface{}{}
+	storage := vmStorageProfile(vm)
+	diskList := append([]interface{}{storage["osDisk"]}, storage["dataDisks"].([]interface{})...)
+	for _, d := range diskList {
+		disks = append(disks, map[string]interface{}{
+			"name":     d.(map[string]interface{})["name"],
+			"statuses": []interface{}{vmStatus(provisioningSucceededCode, "Provisioning succeeded")},
+		})
+	}
+	view["disks"] = disks
+	return view
+}
+
+// vmStatus returns an instance view status entry
+func vmStatus(code, displayStatus string) map[string]interface{} {
+	return map[string]interface{}{
+		"code":          code,
+		"level":         "Info",
+		"displayStatus": displayStatus,
+	}
+}
+
+// mapVirtualMachineUpdateResponse handles VirtualMachines_Update. It changes the tags and
+// size of a VM, within the size catalog and quotas, and attaches and detaches data disks through storageProfile.dataDisks.
+func mapVirtualMachineUpdateResponse(req *Request, store StoreInterface) (interface{}, error) {
+	vs, ok := store.(VMStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support updating virtual machines")
+	}
+	rs, ok := store.(ResourceStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support generic resources")
+	}
+	rid, err := ParseResourceID(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+		req.Params["subscriptionId"], req.Params["resourceGroupName"], req.Params["vmName"]))
+	if err != nil {
+		return nil, err
+	}
+	vm := findVM(rid.String(), store)
+	if vm == nil {
+		return nil, resourceNotFound("Microsoft.Compute/virtualMachines", rid.Name(), rid.ResourceGroup)
+	}
+	if err := authorize(req.Principal, rid, "write"); err != nil {
+		return nil, err
+	}
+	body, err := decodeBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if tags, ok := body["tags"]; ok {
+		vm["tags"] = tagStrings(tags)
+	}
+	properties, _ := body["properties"].(map[string]interface{})
+	if hardware, ok := properties["hardwareProfile"].(map[string]interface{}); ok {
+		if size, _ := hardware["vmSize"].(string); size != "" && !strings.EqualFold(size, fmt.Sprint(vm["vmSize"])) {
+			// The VM is counted once, at its new size
+			resized := map[string]bool{strings.ToLower(fmt.Sprint(vm["id"])): true}
+			if err := checkVMCapacity(store, rid.SubscriptionID, fmt.Sprint(vm["location"]), size, zoneStrings(vm["zones"]), 1, resized); err != nil {
+				return nil, err
+			}
+			vm["vmSize"] = size
+		}
+	}
+	var detached []map[string]interface{}
+	storage, _ := properties["storageProfile"].(map[string]interface{})
+	if list, ok := storage["dataDisks"].([]interface{}); ok {
+		disks, removed, err := updateVMDataDisks(vm, list, rs, store)
+		if err != nil {
+			return nil, err
+		}
+		vm["dataDisks"] = disks
+		detached = removed
+	}
+
+	if err := vs.PutVM(vm); err != nil {
+		return nil, err
+	}
+	// Disks created with the VM have no resource of their own until they are detached
+	for _, disk := range detached {
+		disk["properties"].(map[string]interface{})["timeCreated"] = time.Now().UTC().Format(time.RFC3339Nano)
+		if err := rs.PutResource(disk); err != nil {
+			return nil, err
+		}
+	}
+	return convertVMToARMFormat(findVM(rid.String(), store)), nil
+}
+
+// vmPowerActions are the power actions of VMs and scale set instances: the permission each
+// needs and the state it leaves the VM in
+var vmPowerActions = map[string]struct{ permission, status, powerState string }{
+	"start":      {"start", "running", "VM running"},
+	"deallocate": {"stop", "stopped", "VM deallocated"},
+	"restart":    {"restart", "running", "VM running"},
+}
+
+// applyVMPowerAction checks the caller may run a power action on a VM or scale set instance,
+// and applies it to the store view of the VM
+func applyVMPowerAction(req *Request, rid *ResourceID, vm map[string]interface{}, action string) error {
+	power, ok := vmPowerActions[action]
+	if !ok {
+		return fmt.Errorf("unsupported power action: %s", action)
+	}
+	if req.Principal != nil && !req.Principal.HasPermission(rid.SubscriptionID, rid.ResourceGroup, power.permission) {
+		return authorizationFailed(rid.Type()+"/"+action+"/action", rid.String())
+	}
+	if action == "restart" && vm["status"] != "running" {
+		return computeOperationNotAllowed(fmt.Sprintf("Operation 'restart' is not allowed on VM '%v' since the VM is deallocated.", vm["name"]))
+	}
+	vm["status"] = power.status
+	vm["powerState"] = power.powerState
+	return nil
+}
+
+// mapVirtualMachineActionResponse handles VirtualMachines_Start, _Deallocate and _Restart
+func mapVirtualMachineActionResponse(req *Request, store StoreInterface) (interface{}, error) {
+	vs, ok := store.(VMStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support updating virtual machines")
+	}
+	rid, err := ParseResourceID(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+		req.Params["subscriptionId"], req.Params["resourceGroupName"], req.Params["vmName"]))
+	if err != nil {
+		return nil, err
+	}
+	vm := findVM(rid.String(), store)
+	if vm == nil {
+		return nil, resourceNotFound("Microsoft.Compute/virtualMachines", rid.Name(), rid.ResourceGroup)
+	}
+	_, action, _ := strings.Cut(req.OperationID, "_")
+	if err := applyVMPowerAction(req, rid, vm, strings.ToLower(action)); err != nil {
+		return nil, err
+	}
+	if err := vs.PutVM(vm); err != nil {
+		return nil, err
+	}
+	return acceptOperation(req, store, rid.SubscriptionID, &Operation{})
+}
+
+// updateVMDataDisks applies the data disk list of a VM update. Disks already on the VM are
+// matched by managed disk ID or name; new ones are attached (createOption Attach) or created
+// empty. It returns the store view of the new list and the VM-created disks it detaches.
+func updateVMDataDisks(vm map[string]interface{}, list []interface{}, rs ResourceStore, store StoreInterface) ([]interface{}, []map[string]interface{}, error) {
+	vmID, _ := vm["id"].(string)
+	vmName, _ := vm["name"].(string)
+	current := map[string]map[string]interface{}{}
+	currentIDs := map[string]string{}
+	for i, d := range vmStorageProfile(vm)["dataDisks"].([]interface{}) {
+		armDisk := d.(map[string]interface{})
+		id := strings.ToLower(armDisk["managedDisk"].(map[string]interface{})["id"].(string))
+		current[id] = vmDataDisks(vm)[i]
+		current[strings.ToLower(fmt.Sprint(armDisk["name"]))] = vmDataDisks(vm)[i]
+		currentIDs[id] = id
+		currentIDs[strings.ToLower(fmt.Sprint(armDisk["name"]))] = id
+	}
+	attachments := diskAttachments(store)
+
+	disks := []interface{}{}
+	kept := map[string]bool{}
+	luns := map[int]bool{}
+	for _, item := range list {
+		d, _ := item.(map[string]interface{})
+		if d["lun"] == nil {
+			return nil, nil, invalidComputeParameter("dataDisks.lun", "Required parameter 'lun' is missing (null).")
+		}
+		lun := intValue(d["lun"])
+		if lun < 0 || lun > 63 {
+			return nil, nil, invalidComputeParameter("dataDisks.lun", fmt.Sprintf("The LUN %d is out of range. LUNs must be from 0 to 63.", lun))
+		}
+		if luns[lun] {
+			return nil, nil, invalidComputeParameter("dataDisks.lun", fmt.Sprintf("LUN %d is used by more than one data disk of VM %s.", lun, vmName))
+		}
+		luns[lun] = true
+		managed, _ := d["managedDisk"].(map[string]interface{})
+		id, _ := managed["id"].(string)
+		name, _ := d["name"].(string)
+		caching, _ := d["caching"].(string)
+
+		key := strings.ToLower(id)
+		if key == "" {
+			key = strings.ToLower(name)
+		}
+		if existing, ok := current[key]; ok && key != "" {
+			disk := map[string]interface{}{}
+			for k, v := range existing {
+				disk[k] = v
+			}
+			disk["lun"] = lun
+			if caching != "" {
+				disk["caching"] = caching
+			}
+			disks = append(disks, disk)
+			kept[currentIDs[key]] = true
+			continue
+		}
+
+		switch option, _ := d["createOption"].(string); strings.ToLower(option) {
+		case "attach":
+			if id == "" {
+				return nil, nil, invalidComputeParameter("dataDisks.managedDisk.id", "Required parameter 'managedDisk.id' is missing (null).")
+			}
+			diskRID, err := ParseResourceID(id)
+			if err != nil || !strings.EqualFold(diskRID.Type(), diskType) {
+				return nil, nil, invalidComputeParameter("dataDisks.managedDisk.id", fmt.Sprintf("The managed disk ID '%s' is not a disk.", id))
+			}
+			existing := findDisk(id, rs, store)
+			if existing == nil {
+				return nil, nil, &ARMError{StatusCode: http.StatusNotFound, Code: "NotFound", Message: fmt.Sprintf("Disk %s was not found.", id)}
+			}
+			if other := attachments[strings.ToLower(id)]; other != "" {
+				return nil, nil, computeOperationNotAllowed(fmt.Sprintf("Disk %s is already attached to VM %s.", diskRID.Name(), other))
+			}
+			props, _ := existing["properties"].(map[string]interface{})
+			if props["diskState"] == diskStateActiveSAS {
+				return nil, nil, computeOperationNotAllowed(fmt.Sprintf("Disk %s cannot be attached while it has an active shared access signature.", diskRID.Name()))
+			}
+			sku, _ := existing["sku"].(map[string]interface{})
+			disk := map[string]interface{}{
+				"id":           existing["id"],
+				"name":         existing["name"],
+				"lun":          lun,
+				"diskSizeGB":   intValue(props["diskSizeGB"]),
+				"createOption": "Attach",
+			}
+			if sku["name"] != nil {
+				disk["storageAccountType"] = sku["name"]
+			}
+			if caching != "" {
+				disk["caching"] = caching
+			}
+			disks = append(disks, disk)
+
+		case "empty":
+			size := intValue(d["diskSizeGB"])
+			if size <= 0 {
+				return nil, nil, invalidComputeParameter("dataDisks.diskSizeGB", "Required parameter 'diskSizeGB' is missing (null).")
+			}
+			if name == "" {
+				name = fmt.Sprintf("%s_DataDisk_%d", vmName, lun)
+			}
+			if findDisk(vmResourceGroupID(vmID)+"/providers/Microsoft.Compute/disks/"+name, rs, store) != nil {
+				return nil, nil, invalidComputeParameter("dataDisks.name", fmt.Sprintf("A disk named %s already exists in the resource group.", name))
+			}
+			disk := map[string]interface{}{
+				"name":         name,
+				"lun":          lun,
+				"diskSizeGB":   size,
+				"createOption": "Empty",
+			}
+			if managed["storageAccountType"] != nil {
+				disk["storageAccountType"] = managed["storageAccountType"]
+			}
+			if caching != "" {
+				disk["caching"] = caching
+			}
+			disks = append(disks, disk)
+
+		default:
+			return nil, nil, invalidComputeParameter("dataDisks.createOption",
+				fmt.Sprintf("The value '%v' of parameter 'createOption' is not allowed for new data disks. Allowed values are Attach, Empty.", d["createOption"]))
+		}
+	}
+
+	detached := []map[string]interface{}{}
+	for _, disk := range vmDiskResources(rs, store) {
+		id := strings.ToLower(fmt.Sprint(disk["id"]))
+		if _, onVM := currentIDs[id]; onVM && !kept[id] {
+			detached = append(detached, disk)
+		}
+	}
+	return disks, detached, nil
+}
+
+// vmProfileFromARM reads the VM profile back out of ARM VM properties, in the store view
+// of a VM, so that deployed VMs keep the image, disks, NICs and OS profile they were given
+func vmProfileFromARM(properties map[string]interface{}) map[string]interface{} {
+	profile := map[string]interface{}{}
+	storage, _ := properties["storageProfile"].(map[string]interface{})
+	if image, ok := storage["imageReference"].(map[string]interface{}); ok {
+		profile["imageReference"] = map[string]interface{}{
+			"publisher": image["publisher"],
+			"offer":     image["offer"],
+			"sku":       image["sku"],
+			"version":   image["version"],
+		}
+	}
+	diskFromARM := func(d map[string]interface{}) map[string]interface{} {
+		disk := map[string]interface{}{}
+		for _, key := range []string{"name", "lun", "diskSizeGB", "caching", "createOption"} {
+			if v, ok := d[key]; ok {
+				disk[key] = v
+			}
+		}
+		if managed, ok := d["managedDisk"].(map[string]interface{}); ok && managed["storageAccountType"] != nil {
+			disk["storageAccountType"] = managed["storageAccountType"]
+		}
+		if managed, ok := d["managedDisk"].(map[string]interface{}); ok && strings.EqualFold(fmt.Sprint(d["createOption"]), "Attach") {
+			disk["id"] = managed["id"]
+		}
+		return disk
+	}
+	if osDisk, ok := storage["osDisk"].(map[string]interface{}); ok {
+		if disk := diskFromARM(osDisk); len(disk) > 0 {
+			profile["osDisk"] = disk
+		}
+	}
+	if dataDisks, ok := storage["dataDisks"].([]interface{}); ok {
+		disks := []interface{}{}
+		for _, d := range dataDisks {
+			if dMap, ok := d.(map[string]interface{}); ok {
+				disks = append(disks, diskFromARM(dMap))
+			}
+		}
+		profile["dataDisks"] = disks
+	}
+	if osProfile, ok := properties["osProfile"].(map[string]interface{}); ok {
+		profile["osProfile"] = map[string]interface{}{
+			"computerName":  osProfile["computerName"],
+			"adminUsername": osProfile["adminUsername"],
+		}
+	}
+	network, _ := properties["networkProfile"].(map[string]interface{})
+	if nics, ok := network["networkInterfaces"].([]interface{}); ok {
+		refs := []interface{}{}
+		for _, n := range nics {
+			nic, ok := n.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			nicProperties, _ := nic["properties"].(map[string]interface{})
+			refs = append(refs, map[string]interface{}{
+				"id":      nic["id"],
+				"primary": nicProperties["primary"] == true,
+			})
+		}
+		profile["networkInterfaces"] = refs
+	}
+	return profile
+}
+
+// invalidComputeParameter is the compute provider's error for an invalid request parameter
+func invalidComputeParameter(target, message string) *ARMError {
+	return &ARMError{StatusCode: http.StatusBadRequest, Code: "InvalidParameter", Target: target, Message: message}
+}
+
+// computeOperationNotAllowed is the compute provider's error for an operation the state of a disk or VM forbids
+func computeOperationNotAllowed(message string) *ARMError {
+	return &ARMError{StatusCode: http.StatusConflict, Code: "OperationNotAllowed", Message: message}
+}
+
+// vmResourceGroupID returns the ID of the resource group a resource ID belongs to
+func vmResourceGroupID(id string) string {
+	if i := strings.Index(strings.ToLower(id), "/providers/"); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// intValue reads a JSON or Go integer, returning 0 for anything else
+func intValue(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+// putVM creates or updates a virtual machine from its ARM body, placing it only when its size
+// is offered and fits the quotas. It returns 201 for a new VM and 200 for an update.
+func putVM(req *Request, rid *ResourceID, body map[string]interface{}, store StoreInterface) (int, error) {
+	vs, ok := store.(VMStore)
+	if !ok {
+		return 0, fmt.Errorf("store does not support creating virtual machines")
+	}
+	if err := authorize(req.Principal, rid, "write"); err != nil {
+		return 0, err
+	}
+	rg := findResourceGroup(rid.SubscriptionID, rid.ResourceGroup, store)
+	if rg == nil {
+		return 0, resourceGroupNotFound(rid.ResourceGroup)
+	}
+
+	vm := map[string]interface{}{
+		"id":            rid.String(),
+		"name":          rid.Name(),
+		"resourceGroup": rg["name"],
+		"location":      body["location"],
+		"tags":          tagStrings(body["tags"]),
+	}
+	properties, _ := body["properties"].(map[string]interface{})
+	if hardware, ok := properties["hardwareProfile"].(map[string]interface{}); ok {
+		vm["vmSize"] = hardware["vmSize"]
+	}
+	if storage, ok := properties["storageProfile"].(map[string]interface{}); ok {
+		if osDisk, ok := storage["osDisk"].(map[string]interface{}); ok {
+			vm["osType"] = osDisk["osType"]
+		}
+	}
+	for k, v := range vmProfileFromARM(properties) {
+		vm[k] = v
+	}
+	if zones, ok := body["zones"]; ok {
+		vm["zones"] = zones
+	}
+	if rs, ok := store.(ResourceStore); ok {
+		nics, _ := vm["networkInterfaces"].([]interface{})
+		for _, nic := range nics {
+			if id := referenceID(nic); findResource(id, rs) == nil {
+				return 0, invalidResourceReference(id, rid.String())
+			}
+		}
+	}
+
+	if size, _ := vm["vmSize"].(string); size != "" {
+		location, _ := body["location"].(string)
+		replaced := map[string]bool{strings.ToLower(rid.String()): true}
+		if err := checkVMCapacity(store, rid.SubscriptionID, location, size, zoneStrings(vm["zones"]), 1, replaced); err != nil {
+			return 0, err
+		}
+	}
+
+	status := http.StatusCreated
+	if findVM(rid.String(), store) != nil {
+		status = http.StatusOK
+	}
+	if err := vs.PutVM(vm); err != nil {
+		return 0, err
+	}
+	return status, nil
+}
@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+)
+
+// ConformanceMode says what happens when a response does not match the schema the spec declares
+// for its operation and status code
+type ConformanceMode int
+
+const (
+	// ConformanceOff sends responses unchecked
+	ConformanceOff ConformanceMode = iota
+	// ConformanceLog logs responses that drift from the spec and sends them unchanged
+	ConformanceLog
+	// ConformanceStrict replaces responses that drift from the spec with a 500 error that carries
+	// the diagnostic in the ConformanceHeader
+	ConformanceStrict
+)
+
+// ConformanceHeader carries the reason a response failed the conformance check in strict mode
+const ConformanceHeader = "x-mockzure-conformance"
+
+// bufferedResponse holds a response until it has been checked
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// ConformanceMiddleware checks the responses of a route against the spec operation it was
+// generated from. Only JSON bodies are checked; status codes the operation does not list must be
+// errors, which are checked against its default response.
+func ConformanceMiddleware(schema *OperationSchema, mode ConformanceMode, operationID string, next RouteHandler) RouteHandler {
+	if schema == nil || mode == ConformanceOff {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		buffered := &bufferedResponse{header: w.Header()}
+		next(buffered, r, params)
+		if buffered.status == 0 {
+			buffered.status = http.StatusOK
+		}
+
+		drift := schema.checkResponse(r.Method, buffered.status, buffered.header.Get("Content-Type"), buffered.body.Bytes())
+		if drift == "" {
+			w.WriteHeader(buffered.status)
+			if _, err := w.Write(buffered.body.Bytes()); err != nil {
+				log.Printf("Failed to write response: %v", err)
+			}
+			return
+		}
+
+		log.Printf("Response conformance: %s %s (%s) returned %d: %s", r.Method, r.URL.Path, operationID, buffered.status, drift)
+		if mode == ConformanceLog {
+			w.WriteHeader(buffered.status)
+			if _, err := w.Write(buffered.body.Bytes()); err != nil {
+				log.Printf("Failed to write response: %v", err)
+			}
+			return
+		}
+		for key := range w.Header() {
+			w.Header().Del(key)
+		}
+		w.Header().Set(ConformanceHeader, fmt.Sprintf("%s %d: %s", operationID, buffered.status, drift))
+		writeARMError(w, &mappers.ARMError{
+			StatusCode: http.StatusInternalServerError,
+			Code:       "ResponseConformanceFailed",
+			Message:    fmt.Sprintf("The %d response of %s does not match the spec: %s.", buffered.status, operationID, drift),
+		})
+	}
+}
+
+// checkResponse describes how a response drifts from the operation, or returns "" when it
+// conforms
+func (s *OperationSchema) checkResponse(method string, status int, contentType string, body []byte) string {
+	if s.responses == nil {
+		return ""
+	}
+	expected, declared := s.responses[status]
+	if !declared {
+		if status < 400 {
+			return fmt.Sprintf("status %d is not among the operation's responses", status)
+		}
+		expected = s.defaultResponse
+	}
+	if expected == nil || method == http.MethodHead {
+		return ""
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return "the response has no body"
+	}
+	if !strings.Contains(strings.ToLower(contentType), "json") {
+		return fmt.Sprintf("expected a JSON body, got Content-Type '%s'", contentType)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if expected.number {
+		decoder.UseNumber()
+	}
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Sprintf("the body is not valid JSON: %v", err)
+	}
+	if err := expected.validate(value); err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/getkin/kin-openapi/openapi3"
+	swagger "github.com/go-openapi/spec"
 	"github.com/yourcloudtools/mockzure/internal/specs"
 )
 
@@ -81,11 +82,12 @@ func (rg *RouteGenerator) generateFromOpenAPI3(spec *specs.Spec, apiType specs.A
 				continue
 			}
 
-			handler := rg.createHandler(operation.OperationID, pathPattern, method, apiType, spec.OpenAPI3)
+			schema := NewOpenAPI3OperationSchema(spec, pathItem, operation)
+			handler := rg.createHandler(operation.OperationID, pathPattern, method, apiType, schema)
 			routes = append(routes, Route{
 				Method:      method,
 				Path:        pathPattern,
-				Handler:     handler,
+				Handler:     ValidationMiddleware(schema, ConformanceMiddleware(schema, rg.conformance, operation.OperationID, handler)),
 				OperationID: operation.OperationID,
 				Tags:        operation.Tags,
 			})
@@ -105,70 +107,34 @@ func (rg *RouteGenerator) generateFromSwagger2(spec *specs.Spec, apiType specs.A
 
 	for pathPattern, pathItem := range spec.Swagger2.Paths.Paths {
 		// Handle each HTTP method
-		// Note: pathItem.Get, Post, etc. return *spec.Operation
-		operations := map[string]interface{}{
+		// Note: the map is typed so that absent operations compare equal to nil
+		operations := map[string]*swagger.Operation{
 			http.MethodGet:    pathItem.Get,
 			http.MethodPost:   pathItem.Post,
 			http.MethodPut:    pathItem.Put,
 			http.MethodDelete: pathItem.Delete,
 			http.MethodPatch:  pathItem.Patch,
+			http.MethodHead:   pathItem.Head,
 		}
 
 		for method, op := range operations {
 			if op == nil {
 				continue
 			}
-			
-			// Access operation fields directly using type assertion
-			// We know op is *spec.Operation, so we can use an interface to access fields
-			var operationID string
-			var tags []string
-			
-			// Create an interface that matches Operation's structure
-			type operationInterface struct {
-				ID   string
-				Tags []string
-			}
-			
-			// Use unsafe pointer conversion or reflection - simpler: use the actual operation
-			// Since we can't directly type assert, we'll get the operation from pathItem directly
-			switch method {
-			case http.MethodGet:
-				if pathItem.Get != nil {
-					operationID = pathItem.Get.ID
-					tags = pathItem.Get.Tags
-				}
-			case http.MethodPost:
-				if pathItem.Post != nil {
-					operationID = pathItem.Post.ID
-					tags = pathItem.Post.Tags
-				}
-			case http.MethodPut:
-				if pathItem.Put != nil {
-					operationID = pathItem.Put.ID
-					tags = pathItem.Put.Tags
-				}
-			case http.MethodDelete:
-				if pathItem.Delete != nil {
-					operationID = pathItem.Delete.ID
-					tags = pathItem.Delete.Tags
-				}
-			case http.MethodPatch:
-				if pathItem.Patch != nil {
-					operationID = pathItem.Patch.ID
-					tags = pathItem.Patch.Tags
-				}
-			}
-			
+
+			operationID := op.ID
+			tags := op.Tags
+
 			if operationID == "" {
 				operationID = method + "_" + strings.ReplaceAll(pathPattern, "/", "_")
 			}
 
-			handler := rg.createSwagger2Handler(operationID, pathPattern, method, apiType)
+			schema := NewSwagger2OperationSchema(spec, pathItem, op)
+			handler := rg.createSwagger2Handler(operationID, pathPattern, method, apiType, schema)
 			routes = append(routes, Route{
 				Method:      method,
 				Path:        pathPattern,
-				Handler:     handler,
+				Handler:     ValidationMiddleware(schema, ConformanceMiddleware(schema, rg.conformance, operationID, handler)),
 				OperationID: operationID,
 				Tags:        tags,
 			})
@@ -179,21 +145,43 @@ func (rg *RouteGenerator) generateFromSwagger2(spec *specs.Spec, apiType specs.A
 }
 
 // createHandler creates a handler function for an OpenAPI 3.0 operation
-func (rg *RouteGenerator) createHandler(operationID, pathPattern, method string, apiType specs.APIType, doc *openapi3.T) RouteHandler {
+func (rg *RouteGenerator) createHandler(operationID, pathPattern, method string, apiType specs.APIType, schema *OperationSchema) RouteHandler {
 	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		// Replay mode answers from the spec's examples before the mappers
+		if rg.replay {
+			if response := schema.replay(r, params); response != nil {
+				writeMapperResponse(w, response)
+				return
+			}
+		}
 		// Delegate to the generic handler
-		handleRequest(w, r, params, operationID, pathPattern, method, apiType, rg.store)
+		handleRequest(w, r, params, operationID, pathPattern, method, apiType, rg.store, schema)
 	}
 }
 
 // createSwagger2Handler creates a handler function for a Swagger 2.0 operation
-func (rg *RouteGenerator) createSwagger2Handler(operationID, pathPattern, method string, apiType specs.APIType) RouteHandler {
+func (rg *RouteGenerator) createSwagger2Handler(operationID, pathPattern, method string, apiType specs.APIType, schema *OperationSchema) RouteHandler {
 	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		// Replay mode answers from the spec's examples before the mappers
+		if rg.replay {
+			if response := schema.replay(r, params); response != nil {
+				writeMapperResponse(w, response)
+				return
+			}
+		}
 		// Delegate to the generic handler
-		handleRequest(w, r, params, operationID, pathPattern, method, apiType, rg.store)
+		handleRequest(w, r, params, operationID, pathPattern, method, apiType, rg.store, schema)
 	}
 }
 
+// multiSegmentParams are path parameters whose values span several segments
+// (x-ms-skip-url-encoding in the ARM specs), e.g. /{resourceId} or /{scope}/providers/...
+var multiSegmentParams = map[string]bool{
+	"resourceId":         true,
+	"scope":              true,
+	"parentResourcePath": true,
+}
+
 // MatchPath matches a request path against a route pattern and extracts parameters
 func MatchPath(pattern, requestPath string) (bool, map[string]string) {
 	params := make(map[string]string)
@@ -210,11 +198,15 @@ func MatchPath(pattern, requestPath string) (bool, map[string]string) {
 	for _, match := range matches {
 		paramName := match[1]
 		paramNames = append(paramNames, paramName)
-		regexPattern = strings.ReplaceAll(regexPattern, match[0], `([^/]+)`)
+		segment := `([^/]+)`
+		if multiSegmentParams[paramName] {
+			segment = `(.+)`
+		}
+		regexPattern = strings.ReplaceAll(regexPattern, match[0], segment)
 	}
 
-	// Match the pattern
-	regex := regexp.MustCompile("^" + regexPattern + "$")
+	// Match the pattern (ARM paths are case-insensitive, e.g. resourceGroups vs resourcegroups)
+	regex := regexp.MustCompile("(?i)^" + regexPattern + "$")
 	match := regex.FindStringSubmatch(requestPath)
 	if match == nil {
 		return false, nil
@@ -2,9 +2,12 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/yourcloudtools/mockzure/internal/mappers"
@@ -12,7 +15,8 @@ import (
 )
 
 // handleRequest is the generic request handler that routes to appropriate mappers
-func handleRequest(w http.ResponseWriter, r *http.Request, pathParams map[string]string, operationID, pathPattern, method string, apiType specs.APIType, store interface{}) {
+// schema is the spec operation of generated routes, which answers operations no mapper handles
+func handleRequest(w http.ResponseWriter, r *http.Request, pathParams map[string]string, operationID, pathPattern, method string, apiType specs.APIType, store interface{}, schema *OperationSchema) {
 	// Extract query parameters
 	queryParams := make(map[string]string)
 	for k, v := range r.URL.Query() {
@@ -33,9 +37,9 @@ func handleRequest(w http.ResponseWriter, r *http.Request, pathParams map[string
 	// Route to appropriate mapper based on API type
 	switch apiType {
 	case specs.APITypeARM:
-		handleARMRequest(w, r, allParams, operationID, pathPattern, method, store)
+		handleARMRequest(w, r, allParams, operationID, pathPattern, method, store, schema)
 	case specs.APITypeGraph:
-		handleGraphRequest(w, r, allParams, operationID, pathPattern, method, store)
+		handleGraphRequest(w, r, allParams, operationID, pathPattern, method, store, schema)
 	case specs.APITypeIdentity:
 		handleIdentityRequest(w, r, allParams, operationID, pathPattern, method, store)
 	default:
@@ -43,8 +47,14 @@ func handleRequest(w http.ResponseWriter, r *http.Request, pathParams map[string
 	}
 }
 
+// ServeARM serves an ARM operation that was matched outside the spec-driven routes
+// It applies the same caller resolution, mapping and error handling as generated routes
+func ServeARM(w http.ResponseWriter, r *http.Request, params map[string]string, operationID, pathPattern string, store interface{}) {
+	handleRequest(w, r, params, operationID, pathPattern, r.Method, specs.APITypeARM, store, nil)
+}
+
 // handleARMRequest handles ARM API requests
-func handleARMRequest(w http.ResponseWriter, r *http.Request, params map[string]string, operationID, pathPattern, method string, store interface{}) {
+func handleARMRequest(w http.ResponseWriter, r *http.Request, params map[string]string, operationID, pathPattern, method string, store interface{}, schema *OperationSchema) {
 	// Type assert store to access Store methods
 	storeTyped, ok := store.(mappers.StoreInterface)
 	if !ok {
@@ -52,8 +62,15 @@ func handleARMRequest(w http.ResponseWriter, r *http.Request, params map[string]
 		return
 	}
 
+	// Resolve the caller when the store supports it; anonymous requests stay unfiltered
+	var principal mappers.Principal
+	if authenticator, ok := store.(mappers.Authenticator); ok {
+		principal = authenticator.Authenticate(r)
+	}
+
 	// Check if this is an operation status check (LRO pattern)
-	if strings.Contains(pathPattern, "/operations/") && method == "GET" {
+	// Deployment operations are history records, not operation statuses
+	if strings.Contains(pathPattern, "/operations/") && method == "GET" && !strings.HasPrefix(operationID, "DeploymentOperations_") {
 		response, err := mappers.MapARMOperationStatus(operationID, params)
 		if err != nil {
 			log.Printf("Error mapping ARM operation status: %v", err)
@@ -67,10 +84,36 @@ func handleARMRequest(w http.ResponseWriter, r *http.Request, params map[string]
 		return
 	}
 
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Use ARM mapper to generate response
-	response, err := mappers.MapARMResponse(operationID, pathPattern, method, params, storeTyped)
+	response, err := mappers.MapARMRequest(&mappers.Request{
+		OperationID: operationID,
+		PathPattern: pathPattern,
+		Method:      method,
+		Params:      params,
+		Principal:   principal,
+		Body:        body,
+		BaseURL:     requestBaseURL(r),
+	}, storeTyped)
+	if errors.Is(err, mappers.ErrNotMapped) {
+		writeFallback(w, r, params, schema)
+		return
+	}
 	if err != nil {
 		log.Printf("Error mapping ARM response: %v", err)
+		var armErr *mappers.ARMError
+		if errors.As(err, &armErr) {
+			writeARMError(w, armErr)
+			return
+		}
 		// Return spec-compliant error response
 		errorResponse := map[string]interface{}{
 			"error": map[string]interface{}{
@@ -90,14 +133,54 @@ func handleARMRequest(w http.ResponseWriter, r *http.Request, params map[string]
 		return
 	}
 
+	if resp, ok := response.(*mappers.Response); ok {
+		writeMapperResponse(w, resp)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("Failed to encode JSON response: %v", err)
 	}
 }
 
+// requestBaseURL returns the scheme and host the request was sent to
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// writeMapperResponse writes a mapper response with its own status code and headers
+func writeMapperResponse(w http.ResponseWriter, resp *mappers.Response) {
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	if resp.Body == nil {
+		w.WriteHeader(resp.StatusCode)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	if err := json.NewEncoder(w).Encode(resp.Body); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// writeARMError writes an ARM error envelope with the error's status code
+func writeARMError(w http.ResponseWriter, armErr *mappers.ARMError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("x-ms-failure-cause", "gateway")
+	w.WriteHeader(armErr.StatusCode)
+	if err := json.NewEncoder(w).Encode(armErr.Body()); err != nil {
+		log.Printf("Failed to encode error response: %v", err)
+	}
+}
+
 // handleGraphRequest handles Microsoft Graph API requests
-func handleGraphRequest(w http.ResponseWriter, r *http.Request, params map[string]string, operationID, pathPattern, method string, store interface{}) {
+func handleGraphRequest(w http.ResponseWriter, r *http.Request, params map[string]string, operationID, pathPattern, method string, store interface{}, schema *OperationSchema) {
 	// Type assert store to access Store methods
 	storeTyped, ok := store.(mappers.StoreInterface)
 	if !ok {
@@ -107,6 +190,10 @@ func handleGraphRequest(w http.ResponseWriter, r *http.Request, params map[strin
 
 	// Use Graph mapper to generate response
 	response, err := mappers.MapGraphResponse(operationID, pathPattern, method, params, storeTyped)
+	if errors.Is(err, mappers.ErrNotMapped) {
+		writeFallback(w, r, params, schema)
+		return
+	}
 	if err != nil {
 		log.Printf("Error mapping Graph response: %v", err)
 		// Return Graph API-compliant error response
@@ -141,123 +228,69 @@ func handleIdentityRequest(w http.ResponseWriter, r *http.Request, params map[st
 	http.Error(w, "Identity endpoint not implemented", http.StatusNotImplemented)
 }
 
-// RegisterRoutes registers generated routes with an HTTP mux
-// Uses a single catch-all handler that matches routes dynamically to handle overlapping paths
-func RegisterRoutes(mux *http.ServeMux, routes []Route) {
-	registeredCount := 0
-	byMethod := make(map[string]int)
-
-	// Group routes by their base path prefix to optimize matching
-	// For routes with parameters, we need a catch-all handler
-	routeMap := make(map[string][]Route)
-	var exactRoutes []Route
-
-	for _, route := range routes {
-		if strings.Contains(route.Path, "{") {
-			// Parameterized route - group by base path
-			basePath := extractBasePath(route.Path)
-			if basePath == "" {
-				basePath = "/"
-			}
-			routeMap[basePath] = append(routeMap[basePath], route)
-		} else {
-			// Exact path route
-			exactRoutes = append(exactRoutes, route)
-		}
-		registeredCount++
-		byMethod[route.Method]++
-	}
+// Handler serves the generated routes
+type Handler struct {
+	routes   []Route // most specific first
+	notFound http.Handler
+}
 
-	// Register exact path routes first (they take precedence)
-	for _, route := range exactRoutes {
-		handler := createRouteHandler(route)
-		mux.HandleFunc(route.Path, handler)
-		if !strings.HasSuffix(route.Path, "/") {
-			mux.HandleFunc(route.Path+"/", handler)
-		}
+// NewHandler returns an http.Handler serving the generated routes
+// Requests that match no route are passed to notFound (or answered with 404 when nil),
+// which lets the spec-driven routes sit behind the hardcoded mock handlers
+func NewHandler(routes []Route, notFound http.Handler) *Handler {
+	if notFound == nil {
+		notFound = http.NotFoundHandler()
 	}
 
-	// Register parameterized routes with catch-all handlers
-	for basePath, routeGroup := range routeMap {
-		// Create a handler that checks all routes in this group
-		handler := func(routes []Route) http.HandlerFunc {
-			return func(w http.ResponseWriter, r *http.Request) {
-				// Try to match against all routes in this group
-				for _, route := range routes {
-					if r.Method != route.Method {
-						continue
-					}
-
-					matched, params := MatchPath(route.Path, r.URL.Path)
-					if matched {
-						// Found a match - call the handler
-						route.Handler(w, r, params)
-						return
-					}
-				}
+	// Try literal paths before parameterized ones so that, for example,
+	// /providers/Microsoft.Resources/operations wins over /providers/{resourceProviderNamespace}
+	sorted := make([]Route, len(routes))
+	copy(sorted, routes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return routeSpecificity(sorted[i].Path) > routeSpecificity(sorted[j].Path)
+	})
 
-				// No match found
-				http.NotFound(w, r)
-			}
-		}(routeGroup)
-
-		// Register with the base path
-		// In http.ServeMux, patterns ending with '/' match all paths with that prefix
-		if basePath == "/" {
-			// Root path - register as catch-all (but only if no exact route registered)
-			// Check if we already registered exact "/" route
-			hasExactRoot := false
-			for _, route := range exactRoutes {
-				if route.Path == "/" {
-					hasExactRoot = true
-					break
-				}
-			}
-			if !hasExactRoot {
-				mux.HandleFunc("/", handler)
-			}
-		} else {
-			// Ensure base path ends with '/' for prefix matching
-			prefixPath := basePath
-			if !strings.HasSuffix(prefixPath, "/") {
-				prefixPath = prefixPath + "/"
-			}
-			mux.HandleFunc(prefixPath, handler)
-		}
+	byMethod := make(map[string]int)
+	for _, route := range sorted {
+		byMethod[route.Method]++
 	}
-
-	log.Printf("Registered %d route(s) with HTTP mux", registeredCount)
+	log.Printf("Registered %d route(s) with HTTP handler", len(sorted))
 	for method, count := range byMethod {
 		log.Printf("  - %s: %d route(s)", method, count)
 	}
+
+	return &Handler{routes: sorted, notFound: notFound}
 }
 
-// createRouteHandler creates a handler function for a single route
-func createRouteHandler(route Route) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Only handle the correct HTTP method
+// Match returns the route a request is served by, along with its path parameters
+func (h *Handler) Match(r *http.Request) (*Route, map[string]string) {
+	for i := range h.routes {
+		route := &h.routes[i]
 		if r.Method != route.Method {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+			continue
 		}
-
-		// Match the path and extract parameters
-		matched, params := MatchPath(route.Path, r.URL.Path)
-		if !matched {
-			http.NotFound(w, r)
-			return
+		if matched, params := MatchPath(route.Path, r.URL.Path); matched {
+			return route, params
 		}
+	}
+	return nil, nil
+}
 
-		// Call the route handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if route, params := h.Match(r); route != nil {
 		route.Handler(w, r, params)
+		return
 	}
+	h.notFound.ServeHTTP(w, r)
 }
 
-// extractBasePath extracts the base path before the first parameter
-func extractBasePath(pattern string) string {
-	idx := strings.Index(pattern, "{")
-	if idx == -1 {
-		return pattern
+// routeSpecificity scores a path pattern by its literal segments, then by its length
+func routeSpecificity(pattern string) int {
+	literals := 0
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment != "" && !strings.Contains(segment, "{") {
+			literals++
+		}
 	}
-	return pattern[:idx]
+	return literals*1000 + len(pattern)
 }
@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+	"github.com/yourcloudtools/mockzure/internal/specs"
+)
+
+// armEndpoint is the host the x-ms-examples of ARM specs point their headers at
+const armEndpoint = "https://management.azure.com"
+
+// replay answers a request with the x-ms-examples entry of the operation that best matches it,
+// or returns nil when the operation has no examples.
+//
+// Every example parameter with the same value as the request counts for the example and every
+// one with a different value counts against it; ties go to the first example by name. The
+// example's own parameter values, such as its subscription and resource group names, are
+// replaced by the request's in the body and headers, and header URLs point at Mockzure.
+func (s *OperationSchema) replay(r *http.Request, pathParams map[string]string) *mappers.Response {
+	if s == nil || len(s.examples) == 0 {
+		return nil
+	}
+	params := map[string]string{}
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			params[strings.ToLower(key)] = values[0]
+		}
+	}
+	for key, value := range pathParams {
+		params[strings.ToLower(key)] = value
+	}
+
+	var best *specs.Example
+	bestScore := 0
+	for _, example := range s.examples {
+		score := 0
+		for name, value := range example.Parameters {
+			if name == s.bodyName {
+				continue
+			}
+			actual, ok := params[strings.ToLower(name)]
+			if !ok {
+				continue
+			}
+			if strings.EqualFold(actual, fmt.Sprint(value)) {
+				score += 2
+			} else {
+				score--
+			}
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = example, score
+		}
+	}
+
+	status := replayStatus(best)
+	response := best.Responses[status]
+	replacements := map[string]string{}
+	for name, value := range best.Parameters {
+		text, ok := value.(string)
+		actual, found := params[strings.ToLower(name)]
+		if !ok || !found || name == "api-version" || len(text) < 2 || text == actual {
+			continue
+		}
+		replacements[strings.ToLower(text)] = actual
+	}
+
+	result := &mappers.Response{StatusCode: status, Body: substituteParameters(response.Body, replacements)}
+	if len(response.Headers) > 0 {
+		result.Headers = map[string]string{}
+		for key, value := range response.Headers {
+			if rest, ok := strings.CutPrefix(value, armEndpoint); ok {
+				value = requestBaseURL(r) + substituteSegments(rest, replacements)
+			}
+			result.Headers[key] = value
+		}
+	}
+	return result
+}
+
+// replayStatus picks the response of an example to replay: its lowest success status other than
+// 202, so that clients need not poll, then 202, then the lowest status it documents
+func replayStatus(example *specs.Example) int {
+	codes := make([]int, 0, len(example.Responses))
+	for code := range example.Responses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	accepted := 0
+	for _, code := range codes {
+		if code >= 200 && code < 300 {
+			if code != http.StatusAccepted {
+				return code
+			}
+			accepted = code
+		}
+	}
+	if accepted != 0 {
+		return accepted
+	}
+	return codes[0]
+}
+
+// substituteParameters copies an example body, replacing strings that are, or have path segments
+// that are, example parameter values. The indexed example is left untouched.
+func substituteParameters(value interface{}, replacements map[string]string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = substituteParameters(item, replacements)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = substituteParameters(item, replacements)
+		}
+		return out
+	case string:
+		if actual, ok := replacements[strings.ToLower(v)]; ok {
+			return actual
+		}
+		if strings.Contains(v, "/") {
+			return substituteSegments(v, replacements)
+		}
+		return v
+	}
+	return value
+}
+
+// substituteSegments replaces the path segments of a string that are example parameter values
+func substituteSegments(path string, replacements map[string]string) string {
+	if len(replacements) == 0 {
+		return path
+	}
+	query := ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i:]
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if actual, ok := replacements[strings.ToLower(segment)]; ok {
+			segments[i] = actual
+		}
+	}
+	return strings.Join(segments, "/") + query
+}
@@ -0,0 +1,302 @@
+package routes
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	swagger "github.com/go-openapi/spec"
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+)
+
+// Operations that no mapper handles are answered from the spec. The x-ms-examples entry that
+// best matches the request is replayed (see replay.go); without one, an example the spec carries
+// for the operation's success response is used as is, and otherwise a body is synthesized from
+// the response schema. Synthesized bodies are deterministic: every property is filled once with its
+// default, example, first enum value or a placeholder for its type and format, and arrays hold
+// a single item, so that SDKs deserialize them like any real response.
+
+// maxSynthesizedDepth bounds how deep synthesized bodies nest
+const maxSynthesizedDepth = 8
+
+// placeholders for string formats, chosen to parse in every SDK
+var formatPlaceholders = map[string]string{
+	"date-time":         "2024-01-01T00:00:00Z",
+	"date-time-rfc1123": "Mon, 01 Jan 2024 00:00:00 GMT",
+	"date":              "2024-01-01",
+	"duration":          "PT1H",
+	"uuid":              "00000000-0000-0000-0000-000000000000",
+	"uri":               "https://example.com",
+	"url":               "https://example.com",
+	"arm-id":            "/subscriptions/00000000-0000-0000-0000-000000000000",
+	"byte":              "",
+	"password":          "",
+}
+
+// successStatus returns the lowest 2xx status code among an operation's responses
+func successStatus(codes []int) (int, bool) {
+	sort.Ints(codes)
+	for _, code := range codes {
+		if code >= 200 && code < 300 {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// swagger2Fallback builds the response of a Swagger 2.0 operation no mapper handles
+func swagger2Fallback(doc *swagger.Swagger, op *swagger.Operation) *mappers.Response {
+	if op.Responses == nil {
+		return nil
+	}
+	codes := make([]int, 0, len(op.Responses.StatusCodeResponses))
+	for code := range op.Responses.StatusCodeResponses {
+		codes = append(codes, code)
+	}
+	status, ok := successStatus(codes)
+	if !ok {
+		return nil
+	}
+	response := op.Responses.StatusCodeResponses[status]
+	if example, ok := response.Examples["application/json"]; ok {
+		return &mappers.Response{StatusCode: status, Body: example}
+	}
+	if response.Schema == nil {
+		return &mappers.Response{StatusCode: status}
+	}
+	s := swagger2Synthesizer{doc: doc, skip: pageableNextLink(op.Extensions)}
+	return &mappers.Response{StatusCode: status, Body: s.value(response.Schema, 0, map[string]bool{})}
+}
+
+// pageableNextLink returns the next link property of a pageable operation, which synthesized
+// pages leave out so that SDK pagers stop after them
+func pageableNextLink(extensions swagger.Extensions) string {
+	for key, value := range extensions {
+		if !strings.EqualFold(key, "x-ms-pageable") {
+			continue
+		}
+		props, _ := value.(map[string]interface{})
+		if name, ok := props["nextLinkName"].(string); ok {
+			return name
+		}
+		return "nextLink"
+	}
+	return ""
+}
+
+// swagger2Synthesizer fills Swagger 2.0 schemas with placeholder values
+type swagger2Synthesizer struct {
+	doc  *swagger.Swagger
+	skip string // top-level property to leave out
+}
+
+// value synthesizes a value for a schema. seen holds the definitions being filled, which are
+// not entered again so that recursive definitions end.
+func (s swagger2Synthesizer) value(schema *swagger.Schema, depth int, seen map[string]bool) interface{} {
+	if ref := schema.Ref.String(); ref != "" {
+		defName, ok := strings.CutPrefix(ref, "#/definitions/")
+		def, found := s.doc.Definitions[defName]
+		if !ok || !found || seen[defName] {
+			return nil
+		}
+		seen[defName] = true
+		defer delete(seen, defName)
+		return s.value(&def, depth, seen)
+	}
+	if schema.Default != nil {
+		return schema.Default
+	}
+	if schema.Example != nil {
+		return schema.Example
+	}
+	if len(schema.Enum) > 0 {
+		return schema.Enum[0]
+	}
+
+	switch swagger2Type(schema) {
+	case "object":
+		obj := map[string]interface{}{}
+		if depth >= maxSynthesizedDepth {
+			return obj
+		}
+		for i := range schema.AllOf {
+			if parent, ok := s.value(&schema.AllOf[i], depth, seen).(map[string]interface{}); ok {
+				for key, value := range parent {
+					obj[key] = value
+				}
+			}
+		}
+		for propName, prop := range schema.Properties {
+			if depth == 0 && propName == s.skip {
+				continue
+			}
+			if value := s.value(&prop, depth+1, seen); value != nil {
+				obj[propName] = value
+			}
+		}
+		return obj
+	case "array":
+		if schema.Items == nil || schema.Items.Schema == nil || depth >= maxSynthesizedDepth {
+			return []interface{}{}
+		}
+		item := s.value(schema.Items.Schema, depth+1, seen)
+		if item == nil {
+			return []interface{}{}
+		}
+		return []interface{}{item}
+	case "string":
+		return placeholderString(schema.Format)
+	case "integer":
+		return placeholderNumber(schema.Minimum, true)
+	case "number":
+		return placeholderNumber(schema.Minimum, false)
+	case "boolean":
+		return false
+	}
+	// Untyped schemas with allOf are objects made of their parents
+	if len(schema.AllOf) > 0 {
+		return s.value(&swagger.Schema{SchemaProps: swagger.SchemaProps{Type: swagger.StringOrArray{"object"}, AllOf: schema.AllOf}}, depth, seen)
+	}
+	return nil
+}
+
+func placeholderString(format string) string {
+	if value, ok := formatPlaceholders[strings.ToLower(format)]; ok {
+		return value
+	}
+	return "string"
+}
+
+func placeholderNumber(minimum *float64, integer bool) interface{} {
+	value := 0.0
+	if minimum != nil && *minimum > 0 {
+		value = *minimum
+	}
+	if integer {
+		return int64(math.Ceil(value))
+	}
+	return value
+}
+
+// openAPI3Fallback builds the response of an OpenAPI 3.0 operation no mapper handles
+func openAPI3Fallback(op *openapi3.Operation) *mappers.Response {
+	if op.Responses == nil {
+		return nil
+	}
+	codes := []int{}
+	for key := range op.Responses.Map() {
+		if code, err := strconv.Atoi(key); err == nil {
+			codes = append(codes, code)
+		}
+	}
+	status, ok := successStatus(codes)
+	if !ok {
+		return nil
+	}
+	ref := op.Responses.Status(status)
+	if ref == nil || ref.Value == nil {
+		return &mappers.Response{StatusCode: status}
+	}
+	media := ref.Value.Content.Get("application/json")
+	if media == nil {
+		return &mappers.Response{StatusCode: status}
+	}
+	if media.Example != nil {
+		return &mappers.Response{StatusCode: status, Body: media.Example}
+	}
+	names := make([]string, 0, len(media.Examples))
+	for name := range media.Examples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if example := media.Examples[name]; example != nil && example.Value != nil {
+			return &mappers.Response{StatusCode: status, Body: example.Value.Value}
+		}
+	}
+	if media.Schema == nil || media.Schema.Value == nil {
+		return &mappers.Response{StatusCode: status}
+	}
+	return &mappers.Response{StatusCode: status, Body: openAPI3Value(media.Schema.Value, 0, map[*openapi3.Schema]bool{})}
+}
+
+// openAPI3Value fills an OpenAPI 3.0 schema with placeholder values, like swagger2Synthesizer
+func openAPI3Value(schema *openapi3.Schema, depth int, seen map[*openapi3.Schema]bool) interface{} {
+	if seen[schema] {
+		return nil
+	}
+	seen[schema] = true
+	defer delete(seen, schema)
+	if schema.Default != nil {
+		return schema.Default
+	}
+	if schema.Example != nil {
+		return schema.Example
+	}
+	if len(schema.Enum) > 0 {
+		return schema.Enum[0]
+	}
+
+	switch {
+	case schema.Type.Is("object") || schema.Type == nil && (len(schema.Properties) > 0 || len(schema.AllOf) > 0):
+		obj := map[string]interface{}{}
+		if depth >= maxSynthesizedDepth {
+			return obj
+		}
+		for _, parent := range schema.AllOf {
+			if parent.Value == nil {
+				continue
+			}
+			if values, ok := openAPI3Value(parent.Value, depth, seen).(map[string]interface{}); ok {
+				for key, value := range values {
+					obj[key] = value
+				}
+			}
+		}
+		for name, prop := range schema.Properties {
+			if prop.Value == nil {
+				continue
+			}
+			if value := openAPI3Value(prop.Value, depth+1, seen); value != nil {
+				obj[name] = value
+			}
+		}
+		return obj
+	case schema.Type.Is("array"):
+		if schema.Items == nil || schema.Items.Value == nil || depth >= maxSynthesizedDepth {
+			return []interface{}{}
+		}
+		item := openAPI3Value(schema.Items.Value, depth+1, seen)
+		if item == nil {
+			return []interface{}{}
+		}
+		return []interface{}{item}
+	case schema.Type.Is("string"):
+		return placeholderString(schema.Format)
+	case schema.Type.Is("integer"):
+		return placeholderNumber(schema.Min, true)
+	case schema.Type.Is("number"):
+		return placeholderNumber(schema.Min, false)
+	case schema.Type.Is("boolean"):
+		return false
+	}
+	return nil
+}
+
+// writeFallback answers an operation no mapper handles with the response built from its spec,
+// or with an empty list when the route has no spec or the spec declares none
+func writeFallback(w http.ResponseWriter, r *http.Request, params map[string]string, schema *OperationSchema) {
+	var fallback *mappers.Response
+	if schema != nil {
+		if fallback = schema.replay(r, params); fallback == nil {
+			fallback = schema.fallback
+		}
+	}
+	if fallback == nil {
+		fallback = &mappers.Response{StatusCode: http.StatusOK, Body: map[string]interface{}{"value": []interface{}{}}}
+	}
+	writeMapperResponse(w, fallback)
+}
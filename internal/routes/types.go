@@ -18,7 +18,9 @@ type Route struct {
 
 // RouteGenerator generates routes from API specifications
 type RouteGenerator struct {
-	store interface{} // Store interface for data access
+	store       interface{}     // Store interface for data access
+	conformance ConformanceMode // What to do with responses that drift from the spec
+	replay      bool            // Answer operations from their x-ms-examples before the mappers
 }
 
 // NewRouteGenerator creates a new route generator
@@ -28,3 +30,13 @@ func NewRouteGenerator(store interface{}) *RouteGenerator {
 	}
 }
 
+// SetConformanceMode sets how generated routes check their responses against the specs
+func (rg *RouteGenerator) SetConformanceMode(mode ConformanceMode) {
+	rg.conformance = mode
+}
+
+// SetReplayMode makes generated routes answer from the x-ms-examples of their operation, when it
+// has any, instead of the mappers
+func (rg *RouteGenerator) SetReplayMode(replay bool) {
+	rg.replay = replay
+}
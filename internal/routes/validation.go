@@ -0,0 +1,633 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	swagger "github.com/go-openapi/spec"
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+	"github.com/yourcloudtools/mockzure/internal/specs"
+)
+
+// OperationSchema is what a spec declares about one operation: its parameters, its body, its
+// responses and, for ARM, the api-version it serves
+type OperationSchema struct {
+	apiType    specs.APIType
+	apiVersion string // info.version of the spec
+	params     []paramSchema
+	body       *bodySchema
+
+	responses       map[int]*bodySchema // by status code; a nil schema declares a response without a body
+	defaultResponse *bodySchema         // the error response, for status codes the spec does not list
+
+	fallback *mappers.Response // the answer when no mapper handles the operation
+	examples []*specs.Example  // x-ms-examples of the operation
+	bodyName string            // name of the body parameter, as examples list it
+}
+
+// paramSchema is a path, query or header parameter of an operation
+type paramSchema struct {
+	name     string
+	in       string
+	required bool
+	// specVersion marks the shared api-version parameter, whose value must be the spec's version.
+	// Operations that declare api-version inline, such as the generic resource operations, take
+	// the version of the target resource provider instead.
+	specVersion bool
+	validate    func(value string) error // nil when only presence is checked
+}
+
+// bodySchema is the JSON body of a request or response
+type bodySchema struct {
+	required bool
+	validate func(value interface{}) error
+	number   bool // decode numbers as json.Number, as the Swagger validator expects
+}
+
+// apiVersionPattern is the shape of ARM api-versions, e.g. 2021-04-01 or 2023-01-01-preview
+var apiVersionPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(-[a-zA-Z]+)?$`)
+
+// ValidationMiddleware validates requests against the spec operation a route was generated from.
+// Requests with missing or malformed parameters, an api-version the spec does not serve, or a
+// body that does not match the operation's schema are rejected the way Azure rejects them.
+func ValidationMiddleware(schema *OperationSchema, next RouteHandler) RouteHandler {
+	if schema == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		if err := schema.Validate(r, params); err != nil {
+			writeValidationError(w, schema.apiType, err)
+			return
+		}
+		next(w, r, params)
+	}
+}
+
+// Validate checks a request against the operation. The body is restored for the handler.
+func (s *OperationSchema) Validate(r *http.Request, pathParams map[string]string) *mappers.ARMError {
+	query := r.URL.Query()
+	for _, p := range s.params {
+		var value string
+		var present bool
+		switch p.in {
+		case "path":
+			value, present = pathParams[p.name]
+		case "query":
+			if values, ok := query[p.name]; ok && len(values) > 0 {
+				value, present = values[0], true
+			}
+		case "header":
+			value = r.Header.Get(p.name)
+			present = value != ""
+		default:
+			continue
+		}
+
+		if p.name == "api-version" && p.in == "query" && s.apiType == specs.APITypeARM {
+			if err := s.validateAPIVersion(p, value, present); err != nil {
+				return err
+			}
+			continue
+		}
+		if !present || value == "" {
+			if p.required {
+				return invalidParameter(fmt.Sprintf("The required %s parameter '%s' is missing.", p.in, p.name))
+			}
+			continue
+		}
+		if p.validate != nil {
+			if err := p.validate(value); err != nil {
+				return invalidParameter(fmt.Sprintf("The value '%s' of %s parameter '%s' is invalid: %v.", value, p.in, p.name, err))
+			}
+		}
+	}
+
+	if s.body == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil
+	}
+	raw, err := readRequestBody(r)
+	if err != nil {
+		return invalidRequestContent(err)
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		if s.body.required {
+			return invalidRequestContent(fmt.Errorf("The request body is required"))
+		}
+		return nil
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.Contains(strings.ToLower(ct), "json") {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if s.body.number {
+		decoder.UseNumber()
+	}
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return invalidRequestContent(err)
+	}
+	if err := s.body.validate(value); err != nil {
+		if missing, ok := err.(*missingPropertyError); ok && missing.path == "location" && s.apiType == specs.APITypeARM {
+			return &mappers.ARMError{
+				StatusCode: http.StatusBadRequest,
+				Code:       "LocationRequired",
+				Message:    "The location property is required for this definition.",
+			}
+		}
+		return invalidRequestContent(err)
+	}
+	return nil
+}
+
+// validateAPIVersion checks the api-version of an ARM request
+func (s *OperationSchema) validateAPIVersion(p paramSchema, value string, present bool) *mappers.ARMError {
+	if !present || value == "" {
+		return &mappers.ARMError{
+			StatusCode: http.StatusBadRequest,
+			Code:       "MissingApiVersionParameter",
+			Message:    "The api-version query parameter (?api-version=) is required for all requests.",
+		}
+	}
+	if p.specVersion && s.apiVersion != "" {
+		if !strings.EqualFold(value, s.apiVersion) {
+			return &mappers.ARMError{
+				StatusCode: http.StatusBadRequest,
+				Code:       "InvalidApiVersionParameter",
+				Message:    fmt.Sprintf("The api-version '%s' is invalid. The supported versions are '%s'.", value, s.apiVersion),
+			}
+		}
+		return nil
+	}
+	if !apiVersionPattern.MatchString(value) {
+		return &mappers.ARMError{
+			StatusCode: http.StatusBadRequest,
+			Code:       "InvalidApiVersionParameter",
+			Message:    fmt.Sprintf("The api-version '%s' is invalid. The api-version must be a date in the format 'yyyy-MM-dd', optionally followed by a suffix such as '-preview'.", value),
+		}
+	}
+	return nil
+}
+
+// invalidRequestContent returns the error ARM reports for bodies it cannot deserialize
+func invalidRequestContent(err error) *mappers.ARMError {
+	return &mappers.ARMError{
+		StatusCode: http.StatusBadRequest,
+		Code:       "InvalidRequestContent",
+		Message:    fmt.Sprintf("The request content was invalid and could not be deserialized: '%v'.", err),
+	}
+}
+
+// invalidParameter returns the error for a parameter that is missing or does not match its definition
+func invalidParameter(message string) *mappers.ARMError {
+	return &mappers.ARMError{
+		StatusCode: http.StatusBadRequest,
+		Code:       "InvalidRequestContent",
+		Message:    message,
+	}
+}
+
+// writeValidationError writes a validation error in the error format of the API
+func writeValidationError(w http.ResponseWriter, apiType specs.APIType, err *mappers.ARMError) {
+	if apiType == specs.APITypeARM {
+		writeARMError(w, err)
+		return
+	}
+	// Graph reports every malformed request as BadRequest
+	writeARMError(w, &mappers.ARMError{StatusCode: err.StatusCode, Code: "BadRequest", Message: err.Message})
+}
+
+// NewSwagger2OperationSchema builds the request schema of a Swagger 2.0 operation. Parameters
+// declared on the path item apply unless the operation overrides them.
+func NewSwagger2OperationSchema(spec *specs.Spec, pathItem swagger.PathItem, op *swagger.Operation) *OperationSchema {
+	doc := spec.Swagger2
+	schema := &OperationSchema{apiType: spec.Type}
+	if doc.Info != nil {
+		schema.apiVersion = doc.Info.Version
+	}
+
+	seen := map[string]bool{}
+	all := append(append([]swagger.Parameter{}, op.Parameters...), pathItem.Parameters...)
+	for _, param := range all {
+		resolved, shared, ok := resolveSwagger2Parameter(doc, param)
+		if !ok {
+			continue
+		}
+		key := resolved.In + ":" + resolved.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if resolved.In == "body" {
+			if resolved.Schema == nil {
+				continue
+			}
+			schema.body = swagger2Body(doc, resolved.Schema, false)
+			schema.body.required = resolved.Required
+			schema.bodyName = resolved.Name
+			continue
+		}
+		p := paramSchema{
+			name:        resolved.Name,
+			in:          resolved.In,
+			required:    resolved.Required,
+			specVersion: shared && resolved.Name == "api-version",
+		}
+		if validator := swagger2ParamValidator(resolved); validator != nil {
+			p.validate = validator
+		}
+		schema.params = append(schema.params, p)
+	}
+
+	if op.Responses != nil {
+		schema.responses = map[int]*bodySchema{}
+		for code, response := range op.Responses.StatusCodeResponses {
+			schema.responses[code] = swagger2Body(doc, response.Schema, true)
+		}
+		if op.Responses.Default != nil {
+			schema.defaultResponse = swagger2Body(doc, op.Responses.Default.Schema, true)
+		}
+	}
+	schema.fallback = swagger2Fallback(doc, op)
+	schema.examples = spec.Examples[op.ID]
+	return schema
+}
+
+// swagger2Body validates request or response bodies against a Swagger 2.0 schema; it returns
+// nil when there is none
+func swagger2Body(doc *swagger.Swagger, def *swagger.Schema, response bool) *bodySchema {
+	if def == nil {
+		return nil
+	}
+	v := swagger2Validator{doc: doc, response: response}
+	return &bodySchema{
+		number: true,
+		validate: func(value interface{}) error {
+			return v.validate(def, value, "", 0)
+		},
+	}
+}
+
+// resolveSwagger2Parameter follows a parameter reference into the spec's shared parameters.
+// shared reports whether the parameter came from a reference. References into other files
+// cannot be followed; the common-types api-version parameter is recognised by name, the others
+// are skipped.
+func resolveSwagger2Parameter(doc *swagger.Swagger, param swagger.Parameter) (swagger.Parameter, bool, bool) {
+	ref := param.Ref.String()
+	if ref == "" {
+		return param, false, true
+	}
+	if name, ok := strings.CutPrefix(ref, "#/parameters/"); ok {
+		resolved, found := doc.Parameters[name]
+		return resolved, true, found
+	}
+	if strings.HasSuffix(ref, "/ApiVersionParameter") {
+		return swagger.Parameter{ParamProps: swagger.ParamProps{Name: "api-version", In: "query", Required: true}}, true, true
+	}
+	return swagger.Parameter{}, false, false
+}
+
+// swagger2ParamValidator checks the type, enum and length of a non-body parameter
+func swagger2ParamValidator(param swagger.Parameter) func(string) error {
+	if param.Type == "" || param.Type == "string" && len(param.Enum) == 0 && param.Pattern == "" && param.MinLength == nil && param.MaxLength == nil {
+		return nil
+	}
+	return func(value string) error {
+		switch param.Type {
+		case "integer":
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				return fmt.Errorf("expected an integer")
+			}
+		case "number":
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return fmt.Errorf("expected a number")
+			}
+		case "boolean":
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("expected true or false")
+			}
+		case "string":
+			length := int64(len([]rune(value)))
+			if param.MinLength != nil && length < *param.MinLength {
+				return fmt.Errorf("expected at least %d characters", *param.MinLength)
+			}
+			if param.MaxLength != nil && length > *param.MaxLength {
+				return fmt.Errorf("expected at most %d characters", *param.MaxLength)
+			}
+			if param.Pattern != "" {
+				if re, err := regexp.Compile(param.Pattern); err == nil && !re.MatchString(value) {
+					return fmt.Errorf("expected a value matching '%s'", param.Pattern)
+				}
+			}
+		}
+		if len(param.Enum) > 0 && !enumContains(param.Enum, value) {
+			return fmt.Errorf("expected one of %s", formatEnum(param.Enum))
+		}
+		return nil
+	}
+}
+
+// missingPropertyError is a required property the body does not have
+type missingPropertyError struct {
+	path string
+}
+
+func (e *missingPropertyError) Error() string {
+	return fmt.Sprintf("Required property '%s' not found in JSON", e.path)
+}
+
+// maxSchemaDepth bounds schema recursion, e.g. for definitions that reference themselves
+const maxSchemaDepth = 64
+
+// swagger2Validator checks decoded JSON values against the schemas of a Swagger 2.0 spec. It
+// follows the ARM and SDK deserializers rather than strict JSON Schema: unknown properties are
+// ignored, null counts as absent, and enums are only enforced when x-ms-enum does not model them
+// as extensible strings. Requests may omit or carry anything in read-only properties, which
+// responses must fill like any other.
+type swagger2Validator struct {
+	doc      *swagger.Swagger
+	response bool
+}
+
+func (v swagger2Validator) validate(schema *swagger.Schema, value interface{}, path string, depth int) error {
+	if schema == nil || value == nil || depth > maxSchemaDepth {
+		return nil
+	}
+	if ref := schema.Ref.String(); ref != "" {
+		name, ok := strings.CutPrefix(ref, "#/definitions/")
+		if !ok {
+			return nil // definitions in other files cannot be followed
+		}
+		def, found := v.doc.Definitions[name]
+		if !found {
+			return nil
+		}
+		return v.validate(&def, value, path, depth+1)
+	}
+	for i := range schema.AllOf {
+		if err := v.validate(&schema.AllOf[i], value, path, depth+1); err != nil {
+			return err
+		}
+	}
+
+	switch swagger2Type(schema) {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return typeMismatch(path, "an object", value)
+		}
+		for _, name := range schema.Required {
+			if prop, ok := schema.Properties[name]; ok && prop.ReadOnly && !v.response {
+				continue
+			}
+			if obj[name] == nil {
+				return &missingPropertyError{path: joinPath(path, name)}
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := schema.Properties[name]; ok {
+				if prop.ReadOnly && !v.response {
+					continue
+				}
+				if err := v.validate(&prop, obj[name], joinPath(path, name), depth+1); err != nil {
+					return err
+				}
+			} else if schema.AdditionalProperties != nil && schema.AdditionalProperties.Schema != nil && len(schema.AllOf) == 0 {
+				// With allOf, properties of the parents are not listed here, so they are not additional
+				if err := v.validate(schema.AdditionalProperties.Schema, obj[name], joinPath(path, name), depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return typeMismatch(path, "an array", value)
+		}
+		if schema.Items != nil && schema.Items.Schema != nil {
+			for i, item := range items {
+				if err := v.validate(schema.Items.Schema, item, fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return typeMismatch(path, "a string", value)
+		}
+		if schema.Pattern != "" {
+			if re, err := regexp.Compile(schema.Pattern); err == nil && !re.MatchString(s) {
+				return fmt.Errorf("The value '%s' at path '%s' does not match the pattern '%s'", s, path, schema.Pattern)
+			}
+		}
+		if len(schema.Enum) > 0 && enumIsClosed(schema) && !enumContains(schema.Enum, s) {
+			return fmt.Errorf("The value '%s' at path '%s' is not one of %s", s, path, formatEnum(schema.Enum))
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return typeMismatch(path, "an integer", value)
+		}
+		if _, err := n.Int64(); err != nil {
+			return typeMismatch(path, "an integer", value)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return typeMismatch(path, "a number", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return typeMismatch(path, "a boolean", value)
+		}
+	}
+	return nil
+}
+
+// swagger2Type returns the type of a schema, treating schemas with properties as objects
+func swagger2Type(schema *swagger.Schema) string {
+	if len(schema.Type) > 0 {
+		return schema.Type[0]
+	}
+	if len(schema.Properties) > 0 || schema.AdditionalProperties != nil || len(schema.Required) > 0 {
+		return "object"
+	}
+	return ""
+}
+
+// enumIsClosed reports whether an enum rejects values it does not list. AutoRest models enums
+// as extensible strings when x-ms-enum sets modelAsString.
+func enumIsClosed(schema *swagger.Schema) bool {
+	for key, ext := range schema.Extensions {
+		if !strings.EqualFold(key, "x-ms-enum") {
+			continue
+		}
+		if props, ok := ext.(map[string]interface{}); ok {
+			if asString, _ := props["modelAsString"].(bool); asString {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// enumContains reports whether an enum lists a value, ignoring case as ARM does
+func enumContains(enum []interface{}, value string) bool {
+	for _, v := range enum {
+		if strings.EqualFold(fmt.Sprint(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, v := range enum {
+		values[i] = fmt.Sprintf("'%v'", v)
+	}
+	return strings.Join(values, ", ")
+}
+
+// typeMismatch describes a value of the wrong JSON type
+func typeMismatch(path, expected string, value interface{}) error {
+	var actual string
+	switch value.(type) {
+	case map[string]interface{}:
+		actual = "an object"
+	case []interface{}:
+		actual = "an array"
+	case string:
+		actual = "a string"
+	case bool:
+		actual = "a boolean"
+	default:
+		actual = "a number"
+	}
+	if path == "" {
+		return fmt.Errorf("Expected %s but found %s", expected, actual)
+	}
+	return fmt.Errorf("Expected %s at path '%s' but found %s", expected, path, actual)
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// NewOpenAPI3OperationSchema builds the request schema of an OpenAPI 3.0 operation, whose
+// references the loader has already resolved
+func NewOpenAPI3OperationSchema(spec *specs.Spec, pathItem *openapi3.PathItem, op *openapi3.Operation) *OperationSchema {
+	doc := spec.OpenAPI3
+	schema := &OperationSchema{apiType: spec.Type}
+	if doc.Info != nil {
+		schema.apiVersion = doc.Info.Version
+	}
+
+	seen := map[string]bool{}
+	all := append(append(openapi3.Parameters{}, op.Parameters...), pathItem.Parameters...)
+	for _, ref := range all {
+		if ref == nil || ref.Value == nil {
+			continue
+		}
+		param := ref.Value
+		key := param.In + ":" + param.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		p := paramSchema{
+			name:        param.Name,
+			in:          param.In,
+			required:    param.Required,
+			specVersion: ref.Ref != "" && param.Name == "api-version",
+		}
+		if param.Schema != nil && param.Schema.Value != nil {
+			p.validate = openAPI3ParamValidator(param.Schema.Value)
+		}
+		schema.params = append(schema.params, p)
+	}
+
+	if op.RequestBody != nil && op.RequestBody.Value != nil {
+		body := op.RequestBody.Value
+		if schema.body = openAPI3Body(body.Content, false); schema.body != nil {
+			schema.body.required = body.Required
+		}
+	}
+
+	if op.Responses != nil {
+		schema.responses = map[int]*bodySchema{}
+		for status, ref := range op.Responses.Map() {
+			if ref == nil || ref.Value == nil {
+				continue
+			}
+			if status == "default" {
+				schema.defaultResponse = openAPI3Body(ref.Value.Content, true)
+				continue
+			}
+			if code, err := strconv.Atoi(status); err == nil {
+				schema.responses[code] = openAPI3Body(ref.Value.Content, true)
+			}
+		}
+	}
+	schema.fallback = openAPI3Fallback(op)
+	return schema
+}
+
+// openAPI3Body validates request or response bodies against the JSON schema of an OpenAPI 3.0
+// content map; it returns nil when there is none
+func openAPI3Body(content openapi3.Content, response bool) *bodySchema {
+	media := content.Get("application/json")
+	if media == nil || media.Schema == nil || media.Schema.Value == nil {
+		return nil
+	}
+	def := media.Schema.Value
+	direction := openapi3.VisitAsRequest()
+	if response {
+		direction = openapi3.VisitAsResponse()
+	}
+	return &bodySchema{
+		validate: func(value interface{}) error {
+			return def.VisitJSON(value, direction, openapi3.MultiErrors())
+		},
+	}
+}
+
+// openAPI3ParamValidator checks a parameter value against its schema, converting it to the
+// schema's type first
+func openAPI3ParamValidator(schema *openapi3.Schema) func(string) error {
+	return func(value string) error {
+		var typed interface{} = value
+		switch {
+		case schema.Type.Is("integer"), schema.Type.Is("number"):
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("expected a number")
+			}
+			typed = n
+		case schema.Type.Is("boolean"):
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("expected true or false")
+			}
+			typed = b
+		case schema.Type.Is("array"), schema.Type.Is("object"):
+			return nil
+		}
+		return schema.VisitJSON(typed, openapi3.VisitAsRequest())
+	}
+}
@@ -0,0 +1,120 @@
+package specs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-openapi/spec"
+)
+
+// exampleDocument is the x-ms-examples format, as written in example files and inline
+type exampleDocument struct {
+	Parameters map[string]interface{} `json:"parameters"`
+	Responses  map[string]struct {
+		Headers map[string]interface{} `json:"headers"`
+		Body    interface{}            `json:"body"`
+	} `json:"responses"`
+}
+
+// loadSwagger2Examples indexes the x-ms-examples of every operation in a Swagger 2.0 spec by
+// operation ID. Examples are read from files relative to the spec, as the Azure REST API specs
+// lay them out, or taken inline. Examples that cannot be read are skipped, with one log line
+// per spec.
+func loadSwagger2Examples(doc *spec.Swagger, specPath string) map[string][]*Example {
+	examples := map[string][]*Example{}
+	skipped := 0
+	var firstErr error
+	if doc.Paths == nil {
+		return examples
+	}
+	for _, pathItem := range doc.Paths.Paths {
+		for _, op := range []*spec.Operation{pathItem.Get, pathItem.Put, pathItem.Post, pathItem.Delete, pathItem.Patch, pathItem.Head} {
+			if op == nil || op.ID == "" {
+				continue
+			}
+			var entries map[string]interface{}
+			for key, value := range op.Extensions {
+				if strings.EqualFold(key, "x-ms-examples") {
+					entries, _ = value.(map[string]interface{})
+				}
+			}
+			names := make([]string, 0, len(entries))
+			for name := range entries {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				example, err := loadExample(name, entries[name], specPath)
+				if err != nil {
+					if skipped == 0 {
+						firstErr = fmt.Errorf("example %q of %s: %w", name, op.ID, err)
+					}
+					skipped++
+					continue
+				}
+				examples[op.ID] = append(examples[op.ID], example)
+			}
+		}
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d x-ms-example(s) of %s that could not be read, e.g. %v", skipped, filepath.Base(specPath), firstErr)
+	}
+	return examples
+}
+
+// loadExample reads one x-ms-examples entry, following its $ref when it has one
+func loadExample(name string, entry interface{}, specPath string) (*Example, error) {
+	fields, ok := entry.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not an object")
+	}
+	var data []byte
+	var file string
+	if ref, ok := fields["$ref"].(string); ok {
+		if strings.Contains(ref, "://") {
+			return nil, fmt.Errorf("remote example %s is not fetched", ref)
+		}
+		file = filepath.Join(filepath.Dir(specPath), filepath.FromSlash(ref))
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if data, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	var doc exampleDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse example: %w", err)
+	}
+	example := &Example{
+		Name:       name,
+		File:       file,
+		Parameters: doc.Parameters,
+		Responses:  map[int]*ExampleResponse{},
+	}
+	for status, response := range doc.Responses {
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			continue
+		}
+		headers := map[string]string{}
+		for key, value := range response.Headers {
+			headers[key] = fmt.Sprint(value)
+		}
+		example.Responses[code] = &ExampleResponse{Headers: headers, Body: response.Body}
+	}
+	if len(example.Responses) == 0 {
+		return nil, fmt.Errorf("no responses")
+	}
+	return example, nil
+}
@@ -67,6 +67,10 @@ func (l *Loader) LoadAll(registry *Registry) error {
 func (l *Loader) loadARMSpecs(registry *Registry) (int, int, error) {
 	armDir := filepath.Join(l.specsDir, "arm")
 	files, err := os.ReadDir(armDir)
+	if os.IsNotExist(err) {
+		log.Printf("No ARM specs directory at %s, skipping", armDir)
+		return 0, 0, nil
+	}
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to read ARM directory: %w", err)
 	}
@@ -106,6 +110,10 @@ func (l *Loader) loadARMSpecs(registry *Registry) (int, int, error) {
 func (l *Loader) loadGraphSpecs(registry *Registry) (int, int, error) {
 	graphDir := filepath.Join(l.specsDir, "graph")
 	files, err := os.ReadDir(graphDir)
+	if os.IsNotExist(err) {
+		log.Printf("No Graph specs directory at %s, skipping", graphDir)
+		return 0, 0, nil
+	}
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to read Graph directory: %w", err)
 	}
@@ -145,6 +153,10 @@ func (l *Loader) loadGraphSpecs(registry *Registry) (int, int, error) {
 func (l *Loader) loadIdentitySpecs(registry *Registry) (int, int, error) {
 	identityDir := filepath.Join(l.specsDir, "identity")
 	files, err := os.ReadDir(identityDir)
+	if os.IsNotExist(err) {
+		log.Printf("No Identity specs directory at %s, skipping", identityDir)
+		return 0, 0, nil
+	}
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to read Identity directory: %w", err)
 	}
@@ -280,6 +292,7 @@ func (l *Loader) loadSwagger2(data []byte, filePath string, apiType APIType) (*S
 		Type:     apiType,
 		Swagger2: doc.Spec(),
 		Path:     filePath,
+		Examples: loadSwagger2Examples(doc.Spec(), filePath),
 	}, nil
 }
 
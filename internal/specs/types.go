@@ -21,6 +21,21 @@ type Spec struct {
 	Swagger2    *spec.Swagger
 	Path        string
 	Name        string
+	Examples    map[string][]*Example // x-ms-examples by operation ID
+}
+
+// Example is an x-ms-examples request/response pair of an operation
+type Example struct {
+	Name       string
+	File       string                 // the example file, or empty when written inline in the spec
+	Parameters map[string]interface{} // request parameters by name, including the body parameter
+	Responses  map[int]*ExampleResponse
+}
+
+// ExampleResponse is the response an example documents for one status code
+type ExampleResponse struct {
+	Headers map[string]string
+	Body    interface{}
 }
 
 // IsOpenAPI3 returns true if this is an OpenAPI 3.0 spec
@@ -0,0 +1,866 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultKeyVaultHost         = "vault.azure.net"
+	defaultKeyVaultPath         = "/keyvault"
+	keyVaultAudience            = "https://vault.azure.net"
+	defaultSoftDeleteRetainDays = 90
+)
+
+// KeyVault is a vault served by the Key Vault data plane, at https://{name}.{keyVaultHost} or
+// under {keyVaultPath}/{name}
+type KeyVault struct {
+	Name                      string                 `json:"name" yaml:"name"`
+	TenantID                  string                 `json:"tenantId,omitempty" yaml:"tenantId,omitempty"`                                   // defaults to the first tenant
+	SoftDeleteRetentionInDays int                    `json:"softDeleteRetentionInDays,omitempty" yaml:"softDeleteRetentionInDays,omitempty"` // defaults to 90
+	EnablePurgeProtection     bool                   `json:"enablePurgeProtection,omitempty" yaml:"enablePurgeProtection,omitempty"`
+	AccessPolicies            []KeyVaultAccessPolicy `json:"accessPolicies,omitempty" yaml:"accessPolicies,omitempty"`
+	Secrets                   []KeyVaultSecret       `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+
+	objects map[string]*vaultObject // secrets, keys and certificates by collection and lowercased name
+}
+
+// KeyVaultAccessPolicy grants permissions on a vault to the callers whose token carries the object
+// ID (a user or service account ID) and application ID it names. Either may be left out.
+type KeyVaultAccessPolicy struct {
+	ObjectID      string              `json:"objectId,omitempty" yaml:"objectId,omitempty"`
+	ApplicationID string              `json:"applicationId,omitempty" yaml:"applicationId,omitempty"`
+	Permissions   KeyVaultPermissions `json:"permissions" yaml:"permissions"`
+}
+
+// KeyVaultPermissions lists the granted operations by object type, such as get, list, set and
+// delete; "all" grants every operation
+type KeyVaultPermissions struct {
+	Secrets      []string `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	Keys         []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+	Certificates []string `json:"certificates,omitempty" yaml:"certificates,omitempty"`
+}
+
+// KeyVaultSecret seeds a secret into a vault
+type KeyVaultSecret struct {
+	Name        string            `json:"name" yaml:"name"`
+	Value       string            `json:"value,omitempty" yaml:"value,omitempty"`
+	ContentType string            `json:"contentType,omitempty" yaml:"contentType,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// vaultObject is a secret, key or certificate with its versions. A deleted object keeps its
+// versions until it is purged or its retention ends.
+type vaultObject struct {
+	name     string
+	versions []*vaultVersion // oldest first
+	deleted  time.Time       // zero while the object is live
+	managed  bool            // the key or secret backing a certificate
+	policy   *certificatePolicy
+	pending  map[string]interface{} // the last certificate operation
+}
+
+// vaultVersion is one version of a secret, key or certificate
+type vaultVersion struct {
+	id          string
+	enabled     bool
+	notBefore   int64 // Unix seconds, zero when unset
+	expires     int64
+	created     int64
+	updated     int64
+	tags        map[string]string
+	value       string // secret value
+	contentType string
+	kty         string // key type as requested, such as RSA or EC-HSM
+	key         crypto.Signer
+	keyOps      []string
+	cert        []byte // DER certificate
+}
+
+// vaultKind describes one of the object collections of a vault
+type vaultKind struct {
+	collection string // URL segment, such as secrets
+	noun       string // secret, key or certificate
+	title      string
+	disabled   string // inner error code of operations on disabled versions
+}
+
+var (
+	secretKind      = &vaultKind{"secrets", "secret", "Secret", "SecretDisabled"}
+	keyKind         = &vaultKind{"keys", "key", "Key", "KeyDisabled"}
+	certificateKind = &vaultKind{"certificates", "certificate", "Certificate", "CertificateDisabled"}
+)
+
+// vaultKinds finds a vault collection by its live or deleted URL segment
+var vaultKinds = map[string]*vaultKind{
+	"secrets": secretKind, "keys": keyKind, "certificates": certificateKind,
+}
+
+var (
+	vaultNamePattern  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]{1,22}[a-zA-Z0-9]$`)
+	objectNamePattern = regexp.MustCompile(`^[a-zA-Z0-9-]{1,127}$`)
+)
+
+// vaultAttributes are the attributes accepted when setting or updating an object
+type vaultAttributes struct {
+	Enabled   *bool  `json:"enabled,omitempty"`
+	NotBefore *int64 `json:"nbf,omitempty"`
+	Expires   *int64 `json:"exp,omitempty"`
+}
+
+// vaultRequest is a data-plane request to a vault by an authenticated caller
+type vaultRequest struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	vault  *KeyVault
+	base   string // vault URI, such as https://kv-dev.vault.azure.net
+	claims map[string]interface{}
+	// generated is the key material of a create request, made before the store was locked
+	generated *generatedKey
+}
+
+// validateKeyVaults checks vault and secret names, and the soft-delete retention
+func validateKeyVaults(vaults []*KeyVault) error {
+	seen := map[string]bool{}
+	for i, vault := range vaults {
+		if !vaultNamePattern.MatchString(vault.Name) {
+			return fmt.Errorf("keyVaults[%d]: invalid vault name %q", i, vault.Name)
+		}
+		if seen[strings.ToLower(vault.Name)] {
+			return fmt.Errorf("keyVaults[%d]: duplicate vault name %q", i, vault.Name)
+		}
+		seen[strings.ToLower(vault.Name)] = true
+		if days := vault.SoftDeleteRetentionInDays; days != 0 && (days < 7 || days > 90) {
+			return fmt.Errorf("keyVaults[%d]: softDeleteRetentionInDays must be between 7 and 90", i)
+		}
+		for j, secret := range vault.Secrets {
+			if !objectNamePattern.MatchString(secret.Name) {
+				return fmt.Errorf("keyVaults[%d].secrets[%d]: invalid secret name %q", i, j, secret.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeKeyVaults applies the vault defaults and seeds the configured secrets
+func (s *Store) normalizeKeyVaults() {
+	for _, vault := range s.keyVaults {
+		if vault.TenantID == "" {
+			vault.TenantID = s.tenants[0].ID
+		}
+		if vault.SoftDeleteRetentionInDays == 0 {
+			vault.SoftDeleteRetentionInDays = defaultSoftDeleteRetainDays
+		}
+		vault.objects = map[string]*vaultObject{}
+		now := time.Now().Unix()
+		for _, secret := range vault.Secrets {
+			obj := vault.objectFor(secretKind, secret.Name)
+			obj.versions = append(obj.versions, &vaultVersion{
+				id: newVaultVersion(), enabled: true, created: now, updated: now,
+				value: secret.Value, contentType: secret.ContentType, tags: secret.Tags,
+			})
+		}
+	}
+}
+
+// clearKeyVaults forgets the secrets, keys and certificates of every vault
+func (s *Store) clearKeyVaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	for _, vault := range s.keyVaults {
+		vault.objects = map[string]*vaultObject{}
+	}
+}
+
+// newVaultVersion returns a random object version identifier
+func newVaultVersion() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Failed to generate version: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// keyVaultForRequest finds the vault a request is addressed to, by host name or path prefix. It
+// returns the vault URI and the path below it.
+func (s *Store) keyVaultForRequest(r *http.Request) (*KeyVault, string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if suffix := "." + s.keyVaultHost; s.keyVaultHost != "" && len(host) > len(suffix) && strings.HasSuffix(strings.ToLower(host), strings.ToLower(suffix)) {
+		if vault := s.findKeyVault(host[:len(host)-len(suffix)]); vault != nil {
+			return vault, baseURL(r), r.URL.Path
+		}
+	}
+	if prefix := s.keyVaultPath + "/"; s.keyVaultPath != "" && strings.HasPrefix(r.URL.Path, prefix) {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		if vault := s.findKeyVault(name); vault != nil {
+			return vault, baseURL(r) + prefix + vault.Name, "/" + rest
+		}
+	}
+	return nil, "", ""
+}
+
+// findKeyVault looks a vault up by name, ignoring case
+func (s *Store) findKeyVault(name string) *KeyVault {
+	for _, vault := range s.keyVaults {
+		if strings.EqualFold(vault.Name, name) {
+			return vault
+		}
+	}
+	return nil
+}
+
+// vaultClaims authenticates a data-plane request. Requests without a Mockzure-issued Key Vault token
+// of the vault's tenant get a 401 challenge naming the tenant's authority, like Key Vault does.
+func (s *Store) vaultClaims(w http.ResponseWriter, r *http.Request, vault *KeyVault) (map[string]interface{}, bool) {
+	fail := func(message string) (map[string]interface{}, bool) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer authorization="%s/%s", resource="%s"`, baseURL(r), vault.TenantID, keyVaultAudience))
+		writeVaultError(w, http.StatusUnauthorized, "Unauthorized", message, "")
+		return nil, false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return fail("AKV10000: Request is missing a Bearer or PoP token.")
+	}
+	claims, err := parseUnsignedJWT(token)
+	if err != nil {
+		return fail(fmt.Sprintf("AKV10000: Invalid bearer token: %v.", err))
+	}
+	if aud, _ := claims["aud"].(string); strings.TrimSuffix(aud, "/") != keyVaultAudience {
+		return fail(fmt.Sprintf("AKV10022: Invalid audience. Expected %s, found: %s.", keyVaultAudience, aud))
+	}
+	if tid, _ := claims["tid"].(string); !strings.EqualFold(tid, vault.TenantID) {
+		iss, _ := claims["iss"].(string)
+		return fail(fmt.Sprintf("AKV10032: Invalid issuer. Expected one of %s, found %s.", tenantIssuer(r, vault.TenantID), iss))
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	oid, _ := claims["oid"].(string)
+	appID, _ := claims["appid"].(string)
+	if claims["idtyp"] == "app" {
+		if sa := s.findServiceAccount(appID); sa != nil && sa.ID == oid && strings.EqualFold(sa.TenantID, vault.TenantID) {
+			return claims, true
+		}
+	} else {
+		for _, u := range s.users {
+			if u != nil && u.ID == oid && u.AccountEnabled {
+				return claims, true
+			}
+		}
+	}
+	return fail(fmt.Sprintf("AKV10046: The principal %s is disabled or not found in tenant %s.", oid, vault.TenantID))
+}
+
+// allows reports whether an access policy grants a caller an operation on a collection
+func (v *KeyVault) allows(claims map[string]interface{}, collection, operation string) bool {
+	oid, _ := claims["oid"].(string)
+	appID, _ := claims["appid"].(string)
+	for _, policy := range v.AccessPolicies {
+		if policy.ObjectID == "" && policy.ApplicationID == "" {
+			continue
+		}
+		if (policy.ObjectID != "" && !strings.EqualFold(policy.ObjectID, oid)) ||
+			(policy.ApplicationID != "" && !strings.EqualFold(policy.ApplicationID, appID)) {
+			continue
+		}
+		granted := policy.Permissions.Secrets
+		switch collection {
+		case "keys":
+			granted = policy.Permissions.Keys
+		case "certificates":
+			granted = policy.Permissions.Certificates
+		}
+		for _, p := range granted {
+			if strings.EqualFold(p, operation) || strings.EqualFold(p, "all") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recoveryLevel is the deletion recovery level reported in object attributes
+func (v *KeyVault) recoveryLevel() string {
+	if v.EnablePurgeProtection {
+		return "Recoverable"
+	}
+	return "Recoverable+Purgeable"
+}
+
+// object returns a live or deleted object, or nil
+func (v *KeyVault) object(kind *vaultKind, name string) *vaultObject {
+	return v.objects[kind.collection+"/"+strings.ToLower(name)]
+}
+
+// liveObject returns an object that is not deleted, or nil
+func (v *KeyVault) liveObject(kind *vaultKind, name string) *vaultObject {
+	if obj := v.object(kind, name); obj != nil && obj.deleted.IsZero() {
+		return obj
+	}
+	return nil
+}
+
+// objectFor returns the live object with a name, creating it when missing
+func (v *KeyVault) objectFor(kind *vaultKind, name string) *vaultObject {
+	obj := v.object(kind, name)
+	if obj == nil {
+		obj = &vaultObject{name: name}
+		v.objects[kind.collection+"/"+strings.ToLower(name)] = obj
+	}
+	return obj
+}
+
+// purgeExpired drops the deleted objects whose retention has ended
+func (v *KeyVault) purgeExpired(now time.Time) bool {
+	purged := false
+	for key, obj := range v.objects {
+		if !obj.deleted.IsZero() && now.After(v.scheduledPurge(obj)) {
+			delete(v.objects, key)
+			purged = true
+		}
+	}
+	return purged
+}
+
+// scheduledPurge is when a deleted object is purged
+func (v *KeyVault) scheduledPurge(obj *vaultObject) time.Time {
+	return obj.deleted.AddDate(0, 0, v.SoftDeleteRetentionInDays)
+}
+
+// list returns the live or deleted objects of a collection by name
+func (v *KeyVault) list(kind *vaultKind, deleted bool) []*vaultObject {
+	list := []*vaultObject{}
+	for key, obj := range v.objects {
+		if strings.HasPrefix(key, kind.collection+"/") && obj.deleted.IsZero() != deleted {
+			list = append(list, obj)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return strings.ToLower(list[i].name) < strings.ToLower(list[j].name) })
+	return list
+}
+
+// version returns a version of an object, the latest one for an empty version
+func (obj *vaultObject) version(id string) *vaultVersion {
+	if id == "" {
+		return obj.versions[len(obj.versions)-1]
+	}
+	for _, v := range obj.versions {
+		if strings.EqualFold(v.id, id) {
+			return v
+		}
+	}
+	return nil
+}
+
+// serveKeyVaultRoute serves the Key Vault data plane, reporting whether the request was
+// addressed to a configured vault
+func serveKeyVaultRoute(w http.ResponseWriter, r *http.Request, store *Store) bool {
+	vault, base, path := store.keyVaultForRequest(r)
+	if vault == nil {
+		return false
+	}
+	claims, ok := store.vaultClaims(w, r, vault)
+	if !ok {
+		return true
+	}
+	q := &vaultRequest{w: w, r: r, vault: vault, base: base, claims: claims}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	collection := strings.ToLower(segments[0])
+	// Key material is generated before the store is locked, as a large RSA key takes seconds
+	if kind := vaultKinds[collection]; kind != nil && kind != secretKind && r.Method == http.MethodPost && len(segments) == 3 && strings.EqualFold(segments[2], "create") {
+		q.pregenerateKey(store, kind, segments[1])
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	// Reads and key operations such as sign and verify leave the vault as it was
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead ||
+		(r.Method == http.MethodPost && keyOperations[strings.ToLower(segments[len(segments)-1])] != "")
+	if vault.purgeExpired(time.Now()) || !readOnly {
+		store.changed()
+	}
+	if kind := vaultKinds[collection]; kind != nil {
+		q.serveObjects(kind, segments[1:])
+	} else if kind := vaultKinds[strings.TrimPrefix(collection, "deleted")]; kind != nil {
+		q.serveDeleted(kind, segments[1:])
+	} else {
+		q.notFound()
+	}
+	return true
+}
+
+// serveObjects serves the live objects of a collection
+func (q *vaultRequest) serveObjects(kind *vaultKind, rest []string) {
+	method := q.r.Method
+	switch {
+	case len(rest) == 0 && method == http.MethodGet:
+		if q.allowed(kind, "list") {
+			q.listObjects(kind, false)
+		}
+	case len(rest) == 0:
+		q.notFound()
+	case !objectNamePattern.MatchString(rest[0]):
+		q.error(http.StatusBadRequest, "BadParameter", fmt.Sprintf("The request URI contains an invalid name: %s", rest[0]), "")
+	case len(rest) == 1 && method == http.MethodPut && kind == secretKind:
+		if q.allowed(kind, "set") {
+			q.setSecret(rest[0])
+		}
+	case len(rest) == 1 && method == http.MethodDelete:
+		if q.allowed(kind, "delete") {
+			q.deleteObject(kind, rest[0])
+		}
+	case len(rest) == 2 && method == http.MethodPost && strings.EqualFold(rest[1], "create") && kind != secretKind:
+		if q.allowed(kind, "create") {
+			if kind == keyKind {
+				q.createKey(rest[0])
+			} else {
+				q.createCertificate(rest[0])
+			}
+		}
+	case len(rest) == 2 && method == http.MethodGet && strings.EqualFold(rest[1], "versions"):
+		if q.allowed(kind, "list") {
+			q.listVersions(kind, rest[0])
+		}
+	case len(rest) == 2 && method == http.MethodGet && kind == certificateKind && (strings.EqualFold(rest[1], "pending") || strings.EqualFold(rest[1], "policy")):
+		if q.allowed(kind, "get") {
+			q.getCertificateDetail(rest[0], strings.ToLower(rest[1]))
+		}
+	case kind == keyKind && method == http.MethodPost && len(rest) >= 2 && len(rest) <= 3 && keyOperations[strings.ToLower(rest[len(rest)-1])] != "":
+		operation := keyOperations[strings.ToLower(rest[len(rest)-1])]
+		if q.allowed(kind, operation) {
+			version := ""
+			if len(rest) == 3 {
+				version = rest[1]
+			}
+			q.keyOperation(rest[0], version, operation)
+		}
+	case len(rest) <= 2 && method == http.MethodGet:
+		if q.allowed(kind, "get") {
+			q.getObject(kind, rest[0], strings.Join(rest[1:], ""))
+		}
+	case len(rest) <= 2 && method == http.MethodPatch:
+		permission := "update"
+		if kind == secretKind {
+			permission = "set"
+		}
+		if q.allowed(kind, permission) {
+			q.updateObject(kind, rest[0], strings.Join(rest[1:], ""))
+		}
+	default:
+		q.notFound()
+	}
+}
+
+// serveDeleted serves the soft-deleted objects of a collection
+func (q *vaultRequest) serveDeleted(kind *vaultKind, rest []string) {
+	method := q.r.Method
+	switch {
+	case len(rest) == 0 && method == http.MethodGet:
+		if q.allowed(kind, "list") {
+			q.listObjects(kind, true)
+		}
+	case len(rest) == 1 && method == http.MethodGet:
+		if q.allowed(kind, "list") {
+			if obj := q.deletedObject(kind, rest[0]); obj != nil {
+				q.reply(http.StatusOK, q.deletedView(kind, obj, q.bundle(kind, obj, obj.version(""))))
+			}
+		}
+	case len(rest) == 1 && method == http.MethodDelete:
+		if q.allowed(kind, "purge") {
+			q.purgeObject(kind, rest[0])
+		}
+	case len(rest) == 2 && method == http.MethodPost && strings.EqualFold(rest[1], "recover"):
+		if q.allowed(kind, "recover") {
+			q.recoverObject(kind, rest[0])
+		}
+	default:
+		q.notFound()
+	}
+}
+
+// allowed checks the caller's access policy, answering 403 when it lacks the permission
+func (q *vaultRequest) allowed(kind *vaultKind, operation string) bool {
+	if q.vault.allows(q.claims, kind.collection, operation) {
+		return true
+	}
+	appID, _ := q.claims["appid"].(string)
+	oid, _ := q.claims["oid"].(string)
+	iss, _ := q.claims["iss"].(string)
+	q.error(http.StatusForbidden, "Forbidden", fmt.Sprintf("The user, group or application 'appid=%s;oid=%s;iss=%s' does not have %s %s permission on key vault '%s'. For help resolving this issue, please see https://go.microsoft.com/fwlink/?linkid=2125287",
+		appID, oid, iss, kind.collection, operation, q.vault.Name), "AccessDenied")
+	return false
+}
+
+// listObjects lists the live or deleted objects of a collection
+func (q *vaultRequest) listObjects(kind *vaultKind, deleted bool) {
+	value := []interface{}{}
+	for _, obj := range q.vault.list(kind, deleted) {
+		item := q.item(kind, obj, obj.version(""), false)
+		if deleted {
+			item = q.deletedView(kind, obj, item)
+		}
+		value = append(value, item)
+	}
+	q.reply(http.StatusOK, map[string]interface{}{"value": value, "nextLink": nil})
+}
+
+// listVersions lists the versions of a live object
+func (q *vaultRequest) listVersions(kind *vaultKind, name string) {
+	obj := q.vault.liveObject(kind, name)
+	if obj == nil {
+		q.objectNotFound(kind, name)
+		return
+	}
+	value := []interface{}{}
+	for _, v := range obj.versions {
+		value = append(value, q.item(kind, obj, v, true))
+	}
+	q.reply(http.StatusOK, map[string]interface{}{"value": value, "nextLink": nil})
+}
+
+// getObject returns a version of a live object, the latest for an empty version
+func (q *vaultRequest) getObject(kind *vaultKind, name, version string) {
+	obj, v := q.findVersion(kind, name, version)
+	if v == nil {
+		return
+	}
+	if kind == secretKind && !v.enabled {
+		q.error(http.StatusForbidden, "Forbidden", "Operation get is not allowed on a disabled secret.", kind.disabled)
+		return
+	}
+	bundle := q.bundle(kind, obj, v)
+	if kind == certificateKind && version == "" {
+		bundle["policy"] = q.policyView(obj)
+	}
+	q.reply(http.StatusOK, bundle)
+}
+
+// updateObject changes the attributes, tags and, for secrets, the content type of a version
+func (q *vaultRequest) updateObject(kind *vaultKind, name, version string) {
+	var req struct {
+		ContentType *string           `json:"contentType"`
+		Attributes  *vaultAttributes  `json:"attributes"`
+		Tags        map[string]string `json:"tags"`
+		KeyOps      []string          `json:"key_ops"`
+	}
+	if !q.decode(&req) {
+		return
+	}
+	obj, v := q.findVersion(kind, name, version)
+	if v == nil {
+		return
+	}
+	if req.ContentType != nil && kind == secretKind {
+		v.contentType = *req.ContentType
+	}
+	if req.Tags != nil {
+		v.tags = req.Tags
+	}
+	if req.KeyOps != nil && kind == keyKind {
+		v.keyOps = req.KeyOps
+	}
+	v.applyAttributes(req.Attributes)
+	v.updated = time.Now().Unix()
+	bundle := q.bundle(kind, obj, v)
+	delete(bundle, "value")
+	q.reply(http.StatusOK, bundle)
+}
+
+// deleteObject soft-deletes an object, with the key and secret backing a certificate
+func (q *vaultRequest) deleteObject(kind *vaultKind, name string) {
+	obj := q.vault.liveObject(kind, name)
+	if obj == nil {
+		q.objectNotFound(kind, name)
+		return
+	}
+	now := time.Now()
+	obj.deleted = now
+	if kind == certificateKind {
+		for _, backing := range []*vaultKind{keyKind, secretKind} {
+			if b := q.vault.liveObject(backing, name); b != nil && b.managed {
+				b.deleted = now
+			}
+		}
+	}
+	q.reply(http.StatusOK, q.deletedView(kind, obj, q.bundle(kind, obj, obj.version(""))))
+}
+
+// recoverObject restores a soft-deleted object
+func (q *vaultRequest) recoverObject(kind *vaultKind, name string) {
+	obj := q.deletedObject(kind, name)
+	if obj == nil {
+		return
+	}
+	if kind == certificateKind {
+		for _, backing := range []*vaultKind{keyKind, secretKind} {
+			if b := q.vault.object(backing, name); b != nil && b.managed && b.deleted.Equal(obj.deleted) {
+				b.deleted = time.Time{}
+			}
+		}
+	}
+	obj.deleted = time.Time{}
+	q.reply(http.StatusOK, q.bundle(kind, obj, obj.version("")))
+}
+
+// purgeObject permanently deletes a soft-deleted object
+func (q *vaultRequest) purgeObject(kind *vaultKind, name string) {
+	if q.vault.EnablePurgeProtection {
+		q.error(http.StatusForbidden, "Forbidden", `Operation "purge" is not enabled for this vault.`, "")
+		return
+	}
+	obj := q.deletedObject(kind, name)
+	if obj == nil {
+		return
+	}
+	delete(q.vault.objects, kind.collection+"/"+strings.ToLower(name))
+	if kind == certificateKind {
+		for _, backing := range []*vaultKind{keyKind, secretKind} {
+			if b := q.vault.object(backing, name); b != nil && b.managed && b.deleted.Equal(obj.deleted) {
+				delete(q.vault.objects, backing.collection+"/"+strings.ToLower(name))
+			}
+		}
+	}
+	q.reply(http.StatusNoContent, nil)
+}
+
+// setSecret adds a version to a secret, creating the secret when missing
+func (q *vaultRequest) setSecret(name string) {
+	var req struct {
+		Value       *string           `json:"value"`
+		ContentType string            `json:"contentType"`
+		Attributes  *vaultAttributes  `json:"attributes"`
+		Tags        map[string]string `json:"tags"`
+	}
+	if !q.decode(&req) {
+		return
+	}
+	if req.Value == nil {
+		q.error(http.StatusBadRequest, "BadParameter", "Property value is required.", "")
+		return
+	}
+	if !q.nameAvailable(secretKind, name) {
+		return
+	}
+	now := time.Now().Unix()
+	v := &vaultVersion{id: newVaultVersion(), enabled: true, created: now, updated: now,
+		value: *req.Value, contentType: req.ContentType, tags: req.Tags}
+	v.applyAttributes(req.Attributes)
+	obj := q.vault.objectFor(secretKind, name)
+	obj.versions = append(obj.versions, v)
+	q.reply(http.StatusOK, q.bundle(secretKind, obj, v))
+}
+
+// nameAvailable answers 409 when a soft-deleted object holds a name
+func (q *vaultRequest) nameAvailable(kind *vaultKind, name string) bool {
+	if obj := q.vault.object(kind, name); obj != nil && !obj.deleted.IsZero() {
+		q.error(http.StatusConflict, "Conflict", fmt.Sprintf("%s %s is currently in a deleted but recoverable state, and its name cannot be reused; in this state, the %s can only be recovered or purged.",
+			kind.title, name, kind.noun), "ObjectIsDeletedButRecoverable")
+		return false
+	}
+	return true
+}
+
+// findVersion returns a version of a live object, answering 404 when either is missing
+func (q *vaultRequest) findVersion(kind *vaultKind, name, version string) (*vaultObject, *vaultVersion) {
+	obj := q.vault.liveObject(kind, name)
+	if obj == nil {
+		q.objectNotFound(kind, name)
+		return nil, nil
+	}
+	v := obj.version(version)
+	if v == nil {
+		q.objectNotFound(kind, name+"/"+version)
+		return nil, nil
+	}
+	return obj, v
+}
+
+// deletedObject returns a soft-deleted object, answering 404 when there is none
+func (q *vaultRequest) deletedObject(kind *vaultKind, name string) *vaultObject {
+	obj := q.vault.object(kind, name)
+	if obj == nil || obj.deleted.IsZero() {
+		q.error(http.StatusNotFound, kind.title+"NotFound", fmt.Sprintf("Deleted %s not found: %s", kind.title, name), "")
+		return nil
+	}
+	return obj
+}
+
+// applyAttributes sets the attributes given in a request
+func (v *vaultVersion) applyAttributes(attrs *vaultAttributes) {
+	if attrs == nil {
+		return
+	}
+	if attrs.Enabled != nil {
+		v.enabled = *attrs.Enabled
+	}
+	if attrs.NotBefore != nil {
+		v.notBefore = *attrs.NotBefore
+	}
+	if attrs.Expires != nil {
+		v.expires = *attrs.Expires
+	}
+}
+
+// attributes renders the attributes of a version
+func (q *vaultRequest) attributes(v *vaultVersion) map[string]interface{} {
+	attrs := map[string]interface{}{
+		"enabled":         v.enabled,
+		"created":         v.created,
+		"updated":         v.updated,
+		"recoveryLevel":   q.vault.recoveryLevel(),
+		"recoverableDays": q.vault.SoftDeleteRetentionInDays,
+	}
+	if v.notBefore != 0 {
+		attrs["nbf"] = v.notBefore
+	}
+	if v.expires != 0 {
+		attrs["exp"] = v.expires
+	}
+	return attrs
+}
+
+// objectURI is the identifier of an object, or of one of its versions
+func (q *vaultRequest) objectURI(kind *vaultKind, obj *vaultObject, version string) string {
+	uri := q.base + "/" + kind.collection + "/" + obj.name
+	if version != "" {
+		uri += "/" + version
+	}
+	return uri
+}
+
+// bundle renders a version of an object the way Key Vault returns it from get, set and create
+func (q *vaultRequest) bundle(kind *vaultKind, obj *vaultObject, v *vaultVersion) map[string]interface{} {
+	bundle := map[string]interface{}{"attributes": q.attributes(v)}
+	if len(v.tags) > 0 {
+		bundle["tags"] = v.tags
+	}
+	if obj.managed {
+		bundle["managed"] = true
+	}
+	switch kind {
+	case secretKind:
+		bundle["id"] = q.objectURI(kind, obj, v.id)
+		bundle["value"] = v.value
+		if v.contentType != "" {
+			bundle["contentType"] = v.contentType
+		}
+		if obj.managed {
+			bundle["kid"] = q.objectURI(keyKind, obj, v.id)
+		}
+	case keyKind:
+		bundle["key"] = jsonWebKey(q.objectURI(kind, obj, v.id), v)
+	case certificateKind:
+		bundle["id"] = q.objectURI(kind, obj, v.id)
+		bundle["kid"] = q.objectURI(keyKind, obj, v.id)
+		bundle["sid"] = q.objectURI(secretKind, obj, v.id)
+		bundle["x5t"] = certificateThumbprint(v.cert)
+		bundle["cer"] = v.cert
+		bundle["contentType"] = obj.policy.SecretProps.ContentType
+	}
+	return bundle
+}
+
+// item renders an object or version the way Key Vault lists it, without secret values or key material
+func (q *vaultRequest) item(kind *vaultKind, obj *vaultObject, v *vaultVersion, versioned bool) map[string]interface{} {
+	version := ""
+	if versioned {
+		version = v.id
+	}
+	item := map[string]interface{}{"attributes": q.attributes(v)}
+	if len(v.tags) > 0 {
+		item["tags"] = v.tags
+	}
+	if obj.managed {
+		item["managed"] = true
+	}
+	switch kind {
+	case secretKind:
+		item["id"] = q.objectURI(kind, obj, version)
+		if v.contentType != "" {
+			item["contentType"] = v.contentType
+		}
+	case keyKind:
+		item["kid"] = q.objectURI(kind, obj, version)
+	case certificateKind:
+		item["id"] = q.objectURI(kind, obj, version)
+		item["x5t"] = certificateThumbprint(v.cert)
+	}
+	return item
+}
+
+// deletedView adds the recovery details of a soft-deleted object to its bundle or item
+func (q *vaultRequest) deletedView(kind *vaultKind, obj *vaultObject, view map[string]interface{}) map[string]interface{} {
+	view["recoveryId"] = q.base + "/deleted" + kind.collection + "/" + obj.name
+	view["deletedDate"] = obj.deleted.Unix()
+	view["scheduledPurgeDate"] = q.vault.scheduledPurge(obj).Unix()
+	return view
+}
+
+// decode reads the JSON request body, answering 400 when it is malformed
+func (q *vaultRequest) decode(v interface{}) bool {
+	if err := json.NewDecoder(q.r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		q.error(http.StatusBadRequest, "BadParameter", fmt.Sprintf("The request body is not valid JSON: %v", err), "")
+		return false
+	}
+	return true
+}
+
+// objectNotFound answers 404 for a missing secret, key or certificate
+func (q *vaultRequest) objectNotFound(kind *vaultKind, name string) {
+	q.error(http.StatusNotFound, kind.title+"NotFound", fmt.Sprintf("A %s with (name/id) %s was not found in this key vault. If you recently deleted this %s you may be able to recover it using the correct recovery command. For help resolving this issue, please see https://go.microsoft.com/fwlink/?linkid=2125182",
+		kind.noun, name, kind.noun), "")
+}
+
+// notFound answers 404 for paths the data plane does not serve
+func (q *vaultRequest) notFound() {
+	q.error(http.StatusNotFound, "NotFound", fmt.Sprintf("%s %s is not supported by this vault.", q.r.Method, q.r.URL.Path), "")
+}
+
+// error writes a Key Vault error
+func (q *vaultRequest) error(status int, code, message, inner string) {
+	writeVaultError(q.w, status, code, message, inner)
+}
+
+// reply writes a JSON response, or an empty one for a nil body
+func (q *vaultRequest) reply(status int, body interface{}) {
+	if body == nil {
+		q.w.WriteHeader(status)
+		return
+	}
+	q.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	q.w.WriteHeader(status)
+	if err := json.NewEncoder(q.w).Encode(body); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// writeVaultError writes an error in Key Vault's shape, with an optional inner error code
+func writeVaultError(w http.ResponseWriter, status int, code, message, inner string) {
+	body := map[string]interface{}{"code": code, "message": message}
+	if inner != "" {
+		body["innererror"] = map[string]interface{}{"code": inner}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"error": body}); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
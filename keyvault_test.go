@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const keyVaultConfig = `
+serviceAccounts:
+  - id: sp-reader
+    applicationId: reader-app
+    secret: reader-secret
+  - id: sp-admin
+    applicationId: admin-app
+    secret: admin-secret
+keyVaults:
+  - name: kv-test
+    accessPolicies:
+      - objectId: sp-reader
+        permissions:
+          secrets: [get, list]
+      - applicationId: admin-app
+        permissions:
+          secrets: [all]
+          keys: [all]
+          certificates: [all]
+    secrets:
+      - name: db-password
+        value: s3cret
+        contentType: text/plain
+`
+
+// requestVaultToken gets a Key Vault token for a service account of the default tenant
+func requestVaultToken(t *testing.T, mux http.Handler, clientID, secret string) string {
+	t.Helper()
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {secret},
+		"scope":         {"https://vault.azure.net/.default"},
+	}
+	req := httptest.NewRequest("POST", "/"+defaultTenantID+"/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &token); err != nil || token.AccessToken == "" {
+		t.Fatalf("failed to get a vault token: %d %s", w.Code, w.Body.String())
+	}
+	return token.AccessToken
+}
+
+// keyVaultCall sends a data-plane request to kv-test under the default path prefix
+func keyVaultCall(t *testing.T, mux http.Handler, token, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, "/keyvault/kv-test"+path+"?api-version=7.4", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	result := map[string]interface{}{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q", method, path, w.Body.String())
+		}
+	}
+	return w.Code, result
+}
+
+// vaultBytes decodes a base64url field of a Key Vault response
+func vaultBytes(t *testing.T, m map[string]interface{}, field string) []byte {
+	t.Helper()
+	s, _ := m[field].(string)
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid base64url %s in %v", field, m)
+	}
+	return b
+}
+
+func TestKeyVault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(keyVaultConfig), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	store := &Store{configPath: path}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+	reader := requestVaultToken(t, mux, "reader-app", "reader-secret")
+	admin := requestVaultToken(t, mux, "admin-app", "admin-secret")
+
+	t.Run("authorization", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/keyvault/kv-test/secrets/db-password", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if challenge := w.Header().Get("WWW-Authenticate"); w.Code != http.StatusUnauthorized || !strings.Contains(challenge, defaultTenantID) || !strings.Contains(challenge, `resource="https://vault.azure.net"`) {
+			t.Errorf("expected a 401 challenge naming the tenant, got %d %q", w.Code, challenge)
+		}
+
+		armToken := appAccessToken(req, store.findServiceAccount("admin-app"), defaultTenantID, "https://management.azure.com/.default")
+		if code, body := keyVaultCall(t, mux, armToken, "GET", "/secrets/db-password", ""); code != http.StatusUnauthorized || !strings.Contains(body["error"].(map[string]interface{})["message"].(string), "AKV10022") {
+			t.Errorf("expected a management token to be refused, got %d %v", code, body)
+		}
+		code, body := keyVaultCall(t, mux, reader, "GET", "/secrets/db-password", "")
+		if code != http.StatusOK || body["value"] != "s3cret" || body["contentType"] != "text/plain" {
+			t.Errorf("expected the reader to get the seeded secret, got %d %v", code, body)
+		}
+		code, body = keyVaultCall(t, mux, reader, "PUT", "/secrets/db-password", `{"value":"changed"}`)
+		if code != http.StatusForbidden || body["error"].(map[string]interface{})["innererror"].(map[string]interface{})["code"] != "AccessDenied" {
+			t.Errorf("expected the reader to be denied set, got %d %v", code, body)
+		}
+		if code, _ := keyVaultCall(t, mux, reader, "POST", "/keys/signing/create", `{"kty":"EC"}`); code != http.StatusForbidden {
+			t.Errorf("expected the reader to be denied keys, got %d", code)
+		}
+
+		req = httptest.NewRequest("GET", "/secrets/db-password?api-version=7.4", nil)
+		req.Host = "kv-test.vault.azure.net"
+		req.Header.Set("Authorization", "Bearer "+reader)
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"http://kv-test.vault.azure.net/secrets/db-password/`) {
+			t.Errorf("expected the vault to answer on its host name, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("secrets", func(t *testing.T) {
+		_, first := keyVaultCall(t, mux, admin, "PUT", "/secrets/api-key", `{"value":"one","tags":{"env":"dev"}}`)
+		code, second := keyVaultCall(t, mux, admin, "PUT", "/secrets/api-key", `{"value":"two"}`)
+		if code != http.StatusOK || !strings.HasPrefix(second["id"].(string), "http://example.com/keyvault/kv-test/secrets/api-key/") {
+			t.Fatalf("expected the secret to be set, got %d %v", code, second)
+		}
+		if _, latest := keyVaultCall(t, mux, admin, "GET", "/secrets/api-key", ""); latest["value"] != "two" {
+			t.Errorf("expected the latest version, got %v", latest)
+		}
+		version := first["id"].(string)[strings.LastIndex(first["id"].(string), "/"):]
+		if _, old := keyVaultCall(t, mux, admin, "GET", "/secrets/api-key"+version, ""); old["value"] != "one" {
+			t.Errorf("expected the first version, got %v", old)
+		}
+		if _, versions := keyVaultCall(t, mux, reader, "GET", "/secrets/api-key/versions", ""); len(versions["value"].([]interface{})) != 2 {
+			t.Errorf("expected two versions, got %v", versions)
+		}
+		_, list := keyVaultCall(t, mux, reader, "GET", "/secrets", "")
+		if items := list["value"].([]interface{}); len(items) != 2 || items[0].(map[string]interface{})["value"] != nil {
+			t.Errorf("expected two secrets listed without values, got %v", list)
+		}
+
+		code, deleted := keyVaultCall(t, mux, admin, "DELETE", "/secrets/api-key", "")
+		if code != http.StatusOK || !strings.HasSuffix(deleted["recoveryId"].(string), "/deletedsecrets/api-key") || deleted["scheduledPurgeDate"] == nil {
+			t.Fatalf("expected the secret to be soft-deleted, got %d %v", code, deleted)
+		}
+		if code, _ := keyVaultCall(t, mux, admin, "GET", "/secrets/api-key", ""); code != http.StatusNotFound {
+			t.Errorf("expected the deleted secret to be gone, got %d", code)
+		}
+		code, body := keyVaultCall(t, mux, admin, "PUT", "/secrets/api-key", `{"value":"three"}`)
+		if code != http.StatusConflict || body["error"].(map[string]interface{})["innererror"].(map[string]interface{})["code"] != "ObjectIsDeletedButRecoverable" {
+			t.Errorf("expected the deleted name to be taken, got %d %v", code, body)
+		}
+		if code, recovered := keyVaultCall(t, mux, admin, "POST", "/deletedsecrets/api-key/recover", ""); code != http.StatusOK || recovered["value"] != "two" {
+			t.Errorf("expected the secret to be recovered, got %d %v", code, recovered)
+		}
+		keyVaultCall(t, mux, admin, "DELETE", "/secrets/api-key", "")
+		if _, list := keyVaultCall(t, mux, admin, "GET", "/deletedsecrets", ""); len(list["value"].([]interface{})) != 1 {
+			t.Errorf("expected one deleted secret, got %v", list)
+		}
+		if code, _ := keyVaultCall(t, mux, admin, "DELETE", "/deletedsecrets/api-key", ""); code != http.StatusNoContent {
+			t.Errorf("expected the secret to be purged, got %d", code)
+		}
+		if code, _ := keyVaultCall(t, mux, admin, "GET", "/deletedsecrets/api-key", ""); code != http.StatusNotFound {
+			t.Errorf("expected the purged secret to be gone, got %d", code)
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		digest := sha256.Sum256([]byte("payload"))
+		encoded := base64.RawURLEncoding.EncodeToString(digest[:])
+
+		code, created := keyVaultCall(t, mux, admin, "POST", "/keys/rsa-key/create", `{"kty":"RSA","key_size":2048}`)
+		if code != http.StatusOK {
+			t.Fatalf("expected the RSA key to be created, got %d %v", code, created)
+		}
+		jwk := created["key"].(map[string]interface{})
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(vaultBytes(t, jwk, "n")), E: int(new(big.Int).SetBytes(vaultBytes(t, jwk, "e")).Int64())}
+		_, signed := keyVaultCall(t, mux, admin, "POST", "/keys/rsa-key/sign", `{"alg":"RS256","value":"`+encoded+`"}`)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], vaultBytes(t, signed, "value")); err != nil {
+			t.Errorf("expected an RS256 signature valid for the public key: %v", err)
+		}
+		if signed["kid"] != jwk["kid"] {
+			t.Errorf("expected the signing key version, got %v", signed["kid"])
+		}
+		_, verified := keyVaultCall(t, mux, admin, "POST", "/keys/rsa-key/verify", `{"alg":"RS256","digest":"`+encoded+`","value":"`+signed["value"].(string)+`"}`)
+		if verified["value"] != true {
+			t.Errorf("expected the signature to verify, got %v", verified)
+		}
+
+		secret := base64.RawURLEncoding.EncodeToString([]byte("data encryption key"))
+		_, wrapped := keyVaultCall(t, mux, admin, "POST", "/keys/rsa-key/wrapkey", `{"alg":"RSA-OAEP-256","value":"`+secret+`"}`)
+		_, unwrapped := keyVaultCall(t, mux, admin, "POST", "/keys/rsa-key/unwrapkey", `{"alg":"RSA-OAEP-256","value":"`+wrapped["value"].(string)+`"}`)
+		if string(vaultBytes(t, unwrapped, "value")) != "data encryption key" {
+			t.Errorf("expected the wrapped key back, got %v", unwrapped)
+		}
+
+		_, created = keyVaultCall(t, mux, admin, "POST", "/keys/ec-key/create", `{"kty":"EC","crv":"P-256"}`)
+		jwk = created["key"].(map[string]interface{})
+		version := jwk["kid"].(string)[strings.LastIndex(jwk["kid"].(string), "/"):]
+		_, signed = keyVaultCall(t, mux, admin, "POST", "/keys/ec-key"+version+"/sign", `{"alg":"ES256","value":"`+encoded+`"}`)
+		signature := vaultBytes(t, signed, "value")
+		ecPub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, vaultBytes(t, jwk, "x")...), vaultBytes(t, jwk, "y")...))
+		if err != nil || len(signature) != 64 || !ecdsa.Verify(ecPub, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			t.Errorf("expected an ES256 signature valid for the public key, got %v", signed)
+		}
+		if code, _ := keyVaultCall(t, mux, admin, "POST", "/keys/ec-key/sign", `{"alg":"RS256","value":"`+encoded+`"}`); code != http.StatusBadRequest {
+			t.Errorf("expected RS256 with an EC key to fail, got %d", code)
+		}
+		if code, _ := keyVaultCall(t, mux, admin, "POST", "/keys/ec-key/wrapkey", `{"alg":"RSA-OAEP","value":"`+secret+`"}`); code != http.StatusForbidden {
+			t.Errorf("expected wrapKey to be outside the EC key operations, got %d", code)
+		}
+	})
+
+	t.Run("certificates", func(t *testing.T) {
+		policy := `{"policy":{"issuer":{"name":"Self"},"key_props":{"kty":"EC","crv":"P-256"},"secret_props":{"contentType":"application/x-pem-file"},
+			"x509_props":{"subject":"CN=app.contoso.com, O=Contoso","sans":{"dns_names":["app.contoso.com"]},"validity_months":6}}}`
+		code, operation := keyVaultCall(t, mux, admin, "POST", "/certificates/app-cert/create", policy)
+		if code != http.StatusAccepted || operation["status"] != "completed" {
+			t.Fatalf("expected the certificate to be issued, got %d %v", code, operation)
+		}
+		_, bundle := keyVaultCall(t, mux, admin, "GET", "/certificates/app-cert", "")
+		der, _ := base64.StdEncoding.DecodeString(bundle["cer"].(string))
+		cert, err := x509.ParseCertificate(der)
+		if err != nil || cert.Subject.CommonName != "app.contoso.com" || cert.DNSNames[0] != "app.contoso.com" || cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) != nil {
+			t.Fatalf("expected a self-signed certificate for app.contoso.com, got %v %v", cert, err)
+		}
+		if bundle["policy"].(map[string]interface{})["issuer"].(map[string]interface{})["name"] != "Self" {
+			t.Errorf("expected the policy with the certificate, got %v", bundle["policy"])
+		}
+
+		sid := bundle["sid"].(string)
+		_, secret := keyVaultCall(t, mux, admin, "GET", sid[strings.Index(sid, "/secrets/"):], "")
+		if value, _ := secret["value"].(string); secret["managed"] != true || !strings.Contains(value, "PRIVATE KEY") || !strings.Contains(value, "CERTIFICATE") {
+			t.Errorf("expected the backing secret to hold the PEM key and certificate, got %v", secret)
+		}
+		digest := sha256.Sum256([]byte("payload"))
+		_, signed := keyVaultCall(t, mux, admin, "POST", "/keys/app-cert/sign", `{"alg":"ES256","value":"`+base64.RawURLEncoding.EncodeToString(digest[:])+`"}`)
+		signature := vaultBytes(t, signed, "value")
+		if !ecdsa.Verify(cert.PublicKey.(*ecdsa.PublicKey), digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			t.Errorf("expected the backing key to sign for the certificate, got %v", signed)
+		}
+
+		code, body := keyVaultCall(t, mux, admin, "POST", "/certificates/ca-cert/create", `{"policy":{"issuer":{"name":"DigiCert"},"x509_props":{"subject":"CN=ca"}}}`)
+		if code != http.StatusBadRequest {
+			t.Errorf("expected a CA-issued certificate to be refused, got %d %v", code, body)
+		}
+		keyVaultCall(t, mux, admin, "DELETE", "/certificates/app-cert", "")
+		if code, _ := keyVaultCall(t, mux, admin, "GET", "/secrets/app-cert", ""); code != http.StatusNotFound {
+			t.Errorf("expected deleting the certificate to delete its secret, got %d", code)
+		}
+		keyVaultCall(t, mux, admin, "POST", "/deletedcertificates/app-cert/recover", "")
+		if code, _ := keyVaultCall(t, mux, admin, "GET", "/keys/app-cert", ""); code != http.StatusOK {
+			t.Errorf("expected recovering the certificate to recover its key, got %d", code)
+		}
+	})
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// certificatePolicy is the policy of a certificate, as sent to and returned by Key Vault
+type certificatePolicy struct {
+	ID          string           `json:"id,omitempty"`
+	KeyProps    certKeyProps     `json:"key_props"`
+	SecretProps certSecretProps  `json:"secret_props"`
+	X509Props   certX509Props    `json:"x509_props"`
+	Issuer      certIssuer       `json:"issuer"`
+	Attributes  *vaultAttributes `json:"attributes,omitempty"`
+	// Lifetime actions are kept for clients that read them back; certificates are never renewed
+	LifetimeActions []interface{} `json:"lifetime_actions,omitempty"`
+}
+
+type certKeyProps struct {
+	Exportable *bool  `json:"exportable,omitempty"`
+	Kty        string `json:"kty,omitempty"`
+	KeySize    int    `json:"key_size,omitempty"`
+	Curve      string `json:"crv,omitempty"`
+	ReuseKey   bool   `json:"reuse_key"`
+}
+
+type certSecretProps struct {
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type certX509Props struct {
+	Subject        string   `json:"subject,omitempty"`
+	SANs           certSANs `json:"sans"`
+	EKUs           []string `json:"ekus,omitempty"`
+	KeyUsage       []string `json:"key_usage,omitempty"`
+	ValidityMonths int      `json:"validity_months,omitempty"`
+}
+
+type certSANs struct {
+	DNSNames []string `json:"dns_names,omitempty"`
+	Emails   []string `json:"emails,omitempty"`
+}
+
+type certIssuer struct {
+	Name string `json:"name,omitempty"`
+}
+
+// keyUsages maps the key_usage names of a policy to x509 key usages
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalsignature": x509.KeyUsageDigitalSignature,
+	"nonrepudiation":   x509.KeyUsageContentCommitment,
+	"keyencipherment":  x509.KeyUsageKeyEncipherment,
+	"dataencipherment": x509.KeyUsageDataEncipherment,
+	"keyagreement":     x509.KeyUsageKeyAgreement,
+	"keycertsign":      x509.KeyUsageCertSign,
+	"crlsign":          x509.KeyUsageCRLSign,
+	"encipheronly":     x509.KeyUsageEncipherOnly,
+	"decipheronly":     x509.KeyUsageDecipherOnly,
+}
+
+// extKeyUsages maps the well-known extended key usage OIDs to x509 extended key usages
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"1.3.6.1.5.5.7.3.1": x509.ExtKeyUsageServerAuth,
+	"1.3.6.1.5.5.7.3.2": x509.ExtKeyUsageClientAuth,
+	"1.3.6.1.5.5.7.3.3": x509.ExtKeyUsageCodeSigning,
+	"1.3.6.1.5.5.7.3.4": x509.ExtKeyUsageEmailProtection,
+	"1.3.6.1.5.5.7.3.8": x509.ExtKeyUsageTimeStamping,
+}
+
+// createCertificate issues a new self-signed version of a certificate, creating the certificate
+// and its backing key and secret when missing. Creation completes at once; the certificate
+// operation is returned with status completed.
+func (q *vaultRequest) createCertificate(name string) {
+	var req struct {
+		Policy     *certificatePolicy `json:"policy"`
+		Attributes *vaultAttributes   `json:"attributes"`
+		Tags       map[string]string  `json:"tags"`
+	}
+	if !q.decode(&req) {
+		return
+	}
+	if !q.nameAvailable(certificateKind, name) {
+		return
+	}
+	obj := q.vault.liveObject(certificateKind, name)
+	policy := req.Policy
+	if policy == nil && obj != nil {
+		policy = obj.policy
+	}
+	if policy == nil {
+		q.error(http.StatusBadRequest, "BadParameter", "Property policy is required.", "")
+		return
+	}
+	if err := normalizeCertificatePolicy(policy); err != nil {
+		q.error(http.StatusBadRequest, "BadParameter", err.Error(), "")
+		return
+	}
+	template, err := certificateTemplate(policy)
+	if err != nil {
+		q.error(http.StatusBadRequest, "BadParameter", err.Error(), "")
+		return
+	}
+
+	var key crypto.Signer
+	if policy.KeyProps.ReuseKey {
+		if k := q.vault.liveObject(keyKind, name); k != nil && k.managed {
+			key = k.version("").key
+		}
+	}
+	if key == nil {
+		if key, err = q.newKey(policy.KeyProps.Kty, policy.KeyProps.KeySize, policy.KeyProps.Curve); err != nil {
+			q.error(http.StatusBadRequest, "BadParameter", err.Error(), "")
+			return
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		q.error(http.StatusBadRequest, "BadParameter", fmt.Sprintf("The certificate could not be created: %v", err), "")
+		return
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: template.Subject, DNSNames: template.DNSNames}, key)
+	if err != nil {
+		q.error(http.StatusBadRequest, "BadParameter", fmt.Sprintf("The certificate request could not be created: %v", err), "")
+		return
+	}
+	secretKey := key
+	if !*policy.KeyProps.Exportable {
+		secretKey = nil
+	}
+	value, err := certificateSecretValue(policy.SecretProps.ContentType, secretKey, der)
+	if err != nil {
+		q.error(http.StatusBadRequest, "BadParameter", err.Error(), "")
+		return
+	}
+
+	// The certificate, key and secret share the version, as in Key Vault
+	now := time.Now().Unix()
+	version := newVaultVersion()
+	cert := &vaultVersion{id: version, enabled: true, created: now, updated: now, tags: req.Tags, cert: der,
+		notBefore: template.NotBefore.Unix(), expires: template.NotAfter.Unix()}
+	cert.applyAttributes(req.Attributes)
+	obj = q.vault.objectFor(certificateKind, name)
+	obj.versions = append(obj.versions, cert)
+	obj.policy = policy
+
+	keyObj := q.vault.objectFor(keyKind, name)
+	keyObj.managed = true
+	keyObj.versions = append(keyObj.versions, &vaultVersion{id: version, enabled: true, created: now, updated: now,
+		notBefore: cert.notBefore, expires: cert.expires, kty: policy.KeyProps.Kty, key: key, keyOps: certificateKeyOps(key, template.KeyUsage)})
+	secretObj := q.vault.objectFor(secretKind, name)
+	secretObj.managed = true
+	secretObj.versions = append(secretObj.versions, &vaultVersion{id: version, enabled: true, created: now, updated: now,
+		notBefore: cert.notBefore, expires: cert.expires, value: value, contentType: policy.SecretProps.ContentType})
+
+	obj.pending = map[string]interface{}{
+		"id":                     q.objectURI(certificateKind, obj, "pending"),
+		"issuer":                 policy.Issuer,
+		"csr":                    csr,
+		"cancellation_requested": false,
+		"status":                 "completed",
+		"target":                 q.objectURI(certificateKind, obj, ""),
+		"request_id":             newVaultVersion(),
+	}
+	q.w.Header().Set("Location", q.objectURI(certificateKind, obj, "pending")+"?api-version="+q.r.URL.Query().Get("api-version"))
+	q.reply(http.StatusAccepted, obj.pending)
+}
+
+// getCertificateDetail returns the last operation or the policy of a certificate
+func (q *vaultRequest) getCertificateDetail(name, detail string) {
+	obj := q.vault.liveObject(certificateKind, name)
+	switch {
+	case obj == nil:
+		q.objectNotFound(certificateKind, name)
+	case detail == "policy":
+		q.reply(http.StatusOK, q.policyView(obj))
+	case obj.pending == nil:
+		q.error(http.StatusNotFound, "PendingCertificateNotFound", fmt.Sprintf("Pending certificate not found: %s", name), "")
+	default:
+		q.reply(http.StatusOK, obj.pending)
+	}
+}
+
+// policyView renders the policy of a certificate with its identifier
+func (q *vaultRequest) policyView(obj *vaultObject) *certificatePolicy {
+	view := *obj.policy
+	view.ID = q.objectURI(certificateKind, obj, "policy")
+	return &view
+}
+
+// normalizeCertificatePolicy fills in Key Vault's policy defaults and rejects what Mockzure cannot issue
+func normalizeCertificatePolicy(policy *certificatePolicy) error {
+	if policy.Issuer.Name == "" {
+		policy.Issuer.Name = "Self"
+	}
+	if !strings.EqualFold(policy.Issuer.Name, "Self") {
+		return fmt.Errorf("Issuer %s is not supported; only self-signed certificates (issuer Self) can be created.", policy.Issuer.Name)
+	}
+	if policy.X509Props.Subject == "" {
+		return fmt.Errorf("Property x509_props.subject is required.")
+	}
+	if policy.KeyProps.Kty == "" {
+		policy.KeyProps.Kty = "RSA"
+	}
+	if policy.KeyProps.Kty == "RSA" && policy.KeyProps.KeySize == 0 {
+		policy.KeyProps.KeySize = 2048
+	}
+	if policy.KeyProps.Exportable == nil {
+		exportable := true
+		policy.KeyProps.Exportable = &exportable
+	}
+	if policy.SecretProps.ContentType == "" {
+		policy.SecretProps.ContentType = "application/x-pkcs12"
+	}
+	if policy.X509Props.ValidityMonths == 0 {
+		policy.X509Props.ValidityMonths = 12
+	}
+	if policy.X509Props.EKUs == nil {
+		policy.X509Props.EKUs = []string{"1.3.6.1.5.5.7.3.1", "1.3.6.1.5.5.7.3.2"}
+	}
+	if policy.X509Props.KeyUsage == nil {
+		policy.X509Props.KeyUsage = []string{"digitalSignature", "keyEncipherment"}
+		if strings.HasPrefix(policy.KeyProps.Kty, "EC") {
+			policy.X509Props.KeyUsage = []string{"digitalSignature"}
+		}
+	}
+	return nil
+}
+
+// certificateTemplate builds the self-signed certificate described by a policy
+func certificateTemplate(policy *certificatePolicy) (*x509.Certificate, error) {
+	subject, err := parseDistinguishedName(policy.X509Props.Subject)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             now,
+		NotAfter:              now.AddDate(0, policy.X509Props.ValidityMonths, 0),
+		DNSNames:              policy.X509Props.SANs.DNSNames,
+		EmailAddresses:        policy.X509Props.SANs.Emails,
+		BasicConstraintsValid: true,
+	}
+	for _, usage := range policy.X509Props.KeyUsage {
+		u, ok := keyUsages[strings.ToLower(usage)]
+		if !ok {
+			return nil, fmt.Errorf("Key usage %s is not supported.", usage)
+		}
+		template.KeyUsage |= u
+	}
+	for _, eku := range policy.X509Props.EKUs {
+		if u, ok := extKeyUsages[eku]; ok {
+			template.ExtKeyUsage = append(template.ExtKeyUsage, u)
+			continue
+		}
+		oid, err := parseObjectIdentifier(eku)
+		if err != nil {
+			return nil, err
+		}
+		template.UnknownExtKeyUsage = append(template.UnknownExtKeyUsage, oid)
+	}
+	return template, nil
+}
+
+// parseDistinguishedName parses a subject such as "CN=app.contoso.com, O=Contoso"
+func parseDistinguishedName(dn string) (pkix.Name, error) {
+	var name pkix.Name
+	for _, part := range strings.Split(dn, ",") {
+		attr, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || value == "" {
+			return name, fmt.Errorf("The subject %q is not a valid distinguished name.", dn)
+		}
+		switch strings.ToUpper(strings.TrimSpace(attr)) {
+		case "CN":
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "S", "ST":
+			name.Province = append(name.Province, value)
+		case "STREET":
+			name.StreetAddress = append(name.StreetAddress, value)
+		default:
+			return name, fmt.Errorf("The subject attribute %s is not supported.", attr)
+		}
+	}
+	return name, nil
+}
+
+// parseObjectIdentifier parses a dotted OID such as 1.3.6.1.5.5.7.3.1
+func parseObjectIdentifier(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("The extended key usage %s is not a valid OID.", s)
+		}
+		oid = append(oid, n)
+	}
+	return oid, nil
+}
+
+// certificateKeyOps are the operations permitted on the key backing a certificate, from its key usage
+func certificateKeyOps(key crypto.Signer, usage x509.KeyUsage) []string {
+	ops := []string{}
+	if usage&x509.KeyUsageDigitalSignature != 0 {
+		ops = append(ops, "sign", "verify")
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok && usage&x509.KeyUsageKeyEncipherment != 0 {
+		ops = append(ops, "encrypt", "decrypt", "wrapKey", "unwrapKey")
+	}
+	return ops
+}
+
+// certificateThumbprint is the base64url SHA-1 thumbprint of a certificate
+func certificateThumbprint(der []byte) string {
+	sum := sha1.Sum(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// certificateSecretValue encodes a certificate and its private key as the value of the backing
+// secret: a PEM bundle, or a base64 PFX without password. A nil key leaves the key out.
+func certificateSecretValue(contentType string, key crypto.Signer, der []byte) (string, error) {
+	switch contentType {
+	case "application/x-pem-file":
+		var buf bytes.Buffer
+		if key != nil {
+			pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return "", err
+			}
+			if err := pem.Encode(&buf, &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}); err != nil {
+				return "", err
+			}
+		}
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	case "application/x-pkcs12":
+		pfx, err := encodePKCS12(key, der)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(pfx), nil
+	}
+	return "", fmt.Errorf("Content type %s is not supported; use application/x-pkcs12 or application/x-pem-file.", contentType)
+}
+
+// PKCS #12 (RFC 7292) structures for an unencrypted PFX protected by an empty-password MAC
+var (
+	oidDataContent = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidCertBag     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Cert    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidSHA1        = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+const pfxMacIterations = 2048
+
+type pfxContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type pfxAttribute struct {
+	ID     asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type pfxSafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pfxAttribute `asn1:"set,optional"`
+}
+
+type pfxCertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data asn1.RawValue
+}
+
+type pfxDigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pfxMacData struct {
+	Mac        pfxDigestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe pfxContentInfo
+	MacData  pfxMacData
+}
+
+// explicitContent wraps DER in the [0] EXPLICIT tag of content infos and safe bags
+func explicitContent(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// dataContent wraps DER in a data content info
+func dataContent(der []byte) (pfxContentInfo, error) {
+	octets, err := asn1.Marshal(der)
+	return pfxContentInfo{ContentType: oidDataContent, Content: explicitContent(octets)}, err
+}
+
+// encodePKCS12 encodes a certificate and, unless nil, its private key as a PFX with an empty
+// password, the way Key Vault exports certificates
+func encodePKCS12(key crypto.Signer, der []byte) ([]byte, error) {
+	thumbprint := sha1.Sum(der)
+	localKeyID, err := asn1.Marshal(thumbprint[:])
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pfxAttribute{{ID: oidLocalKeyID, Values: []asn1.RawValue{{FullBytes: localKeyID}}}}
+	certOctets, err := asn1.Marshal(der)
+	if err != nil {
+		return nil, err
+	}
+	certBag, err := asn1.Marshal(pfxCertBag{ID: oidX509Cert, Data: explicitContent(certOctets)})
+	if err != nil {
+		return nil, err
+	}
+	bags := []pfxSafeBag{{ID: oidCertBag, Value: explicitContent(certBag), Attributes: attributes}}
+	if key != nil {
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		bags = append(bags, pfxSafeBag{ID: oidKeyBag, Value: explicitContent(pkcs8), Attributes: attributes})
+	}
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+	safe, err := dataContent(safeContents)
+	if err != nil {
+		return nil, err
+	}
+	authenticatedSafe, err := asn1.Marshal([]pfxContentInfo{safe})
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := dataContent(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, pkcs12MacKey(salt, pfxMacIterations))
+	mac.Write(authenticatedSafe)
+	return asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: authSafe,
+		MacData: pfxMacData{
+			Mac:        pfxDigestInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue}, Digest: mac.Sum(nil)},
+			MacSalt:    salt,
+			Iterations: pfxMacIterations,
+		},
+	})
+}
+
+// pkcs12MacKey derives the SHA-1 MAC key of the empty password (RFC 7292 appendix B.2). The
+// password is the BMPString of "" with its terminator; one hash block is all a MAC key needs.
+func pkcs12MacKey(salt []byte, iterations int) []byte {
+	const blockSize = 64
+	fill := func(b []byte) []byte {
+		out := make([]byte, blockSize*((len(b)+blockSize-1)/blockSize))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	input := bytes.Repeat([]byte{3}, blockSize) // diversifier 3 selects MAC key material
+	input = append(input, fill(salt)...)
+	input = append(input, fill([]byte{0, 0})...)
+	for i := 0; i < iterations; i++ {
+		sum := sha1.Sum(input)
+		input = sum[:]
+	}
+	return input
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// keyOperations maps the key operation URL segments to their key_ops name and permission
+var keyOperations = map[string]string{
+	"sign":      "sign",
+	"verify":    "verify",
+	"encrypt":   "encrypt",
+	"decrypt":   "decrypt",
+	"wrapkey":   "wrapKey",
+	"unwrapkey": "unwrapKey",
+}
+
+// signatureHashes maps the supported signature algorithms to the hash of their digest
+var signatureHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// ecdsaCurves maps the supported curve names to their curves and signature algorithms
+var ecdsaCurves = map[string]struct {
+	curve     elliptic.Curve
+	algorithm string
+}{
+	"P-256": {elliptic.P256(), "ES256"},
+	"P-384": {elliptic.P384(), "ES384"},
+	"P-521": {elliptic.P521(), "ES512"},
+}
+
+// createKey adds a version to a key with new key material, creating the key when missing
+func (q *vaultRequest) createKey(name string) {
+	var req struct {
+		Kty        string            `json:"kty"`
+		KeySize    int               `json:"key_size"`
+		Curve      string            `json:"crv"`
+		KeyOps     []string          `json:"key_ops"`
+		Attributes *vaultAttributes  `json:"attributes"`
+		Tags       map[string]string `json:"tags"`
+	}
+	if !q.decode(&req) {
+		return
+	}
+	key, err := q.newKey(req.Kty, req.KeySize, req.Curve)
+	if err != nil {
+		q.error(http.StatusBadRequest, "BadParameter", err.Error(), "")
+		return
+	}
+	if !q.nameAvailable(keyKind, name) {
+		return
+	}
+	now := time.Now().Unix()
+	v := &vaultVersion{id: newVaultVersion(), enabled: true, created: now, updated: now,
+		kty: req.Kty, key: key, keyOps: req.KeyOps, tags: req.Tags}
+	if v.keyOps == nil {
+		v.keyOps = defaultKeyOps(key)
+	}
+	v.applyAttributes(req.Attributes)
+	obj := q.vault.objectFor(keyKind, name)
+	obj.versions = append(obj.versions, v)
+	q.reply(http.StatusOK, q.bundle(keyKind, obj, v))
+}
+
+// keyOperation runs a cryptographic operation with a version of a key, the latest for an empty version
+func (q *vaultRequest) keyOperation(name, version, operation string) {
+	var req struct {
+		Algorithm string `json:"alg"`
+		Value     string `json:"value"`
+		Digest    string `json:"digest"`
+	}
+	if !q.decode(&req) {
+		return
+	}
+	obj, v := q.findVersion(keyKind, name, version)
+	if v == nil {
+		return
+	}
+	now := time.Now().Unix()
+	switch {
+	case !v.enabled:
+		q.error(http.StatusForbidden, "Forbidden", fmt.Sprintf("Operation %s is not allowed on a disabled key.", operation), keyKind.disabled)
+		return
+	case (v.notBefore != 0 && now < v.notBefore) || (v.expires != 0 && now > v.expires):
+		q.error(http.StatusForbidden, "Forbidden", fmt.Sprintf("Operation %s is not allowed on a key outside its validity period.", operation), "")
+		return
+	case !slices.Contains(v.keyOps, operation):
+		q.error(http.StatusForbidden, "Forbidden", fmt.Sprintf("Operation %s is not permitted on this key.", operation), "")
+		return
+	}
+	value, err := decodeVaultBytes(req.Value)
+	if err != nil {
+		q.error(http.StatusBadRequest, "BadParameter", "Property value is not valid base64url.", "")
+		return
+	}
+
+	var result []byte
+	switch operation {
+	case "sign":
+		result, err = vaultSign(v.key, req.Algorithm, value)
+	case "verify":
+		var digest []byte
+		if digest, err = decodeVaultBytes(req.Digest); err == nil {
+			var valid bool
+			if valid, err = vaultVerify(v.key.Public(), req.Algorithm, digest, value); err == nil {
+				q.reply(http.StatusOK, map[string]interface{}{"value": valid})
+				return
+			}
+		}
+	case "encrypt", "wrapKey":
+		result, err = vaultEncrypt(v.key, req.Algorithm, value)
+	case "decrypt", "unwrapKey":
+		result, err = vaultDecrypt(v.key, req.Algorithm, value)
+	}
+	if err != nil {
+		q.error(http.StatusBadRequest, "BadParameter", err.Error(), "")
+		return
+	}
+	q.reply(http.StatusOK, map[string]interface{}{
+		"kid":   q.objectURI(keyKind, obj, v.id),
+		"value": base64.RawURLEncoding.EncodeToString(result),
+	})
+}
+
+// generatedKey is key material made for a create request before the store lock is taken
+type generatedKey struct {
+	kty, curve string
+	size       int
+	key        crypto.Signer
+	err        error
+}
+
+// pregenerateKey generates the key material a key or certificate create request will need, so
+// that generating it does not hold the store lock. The body is put back for the handler. A
+// certificate created without a policy uses its current one, read under the read lock.
+func (q *vaultRequest) pregenerateKey(store *Store, kind *vaultKind, name string) {
+	data, err := io.ReadAll(q.r.Body)
+	q.r.Body = io.NopCloser(bytes.NewReader(data))
+	var req struct {
+		Kty     string             `json:"kty"`
+		KeySize int                `json:"key_size"`
+		Curve   string             `json:"crv"`
+		Policy  *certificatePolicy `json:"policy"`
+	}
+	if err != nil || json.Unmarshal(data, &req) != nil {
+		return
+	}
+	kty, size, curve := req.Kty, req.KeySize, req.Curve
+	if kind == certificateKind {
+		policy := req.Policy
+		if policy == nil {
+			store.mu.RLock()
+			if obj := q.vault.liveObject(certificateKind, name); obj != nil && obj.policy != nil {
+				current := *obj.policy
+				policy = &current
+			}
+			store.mu.RUnlock()
+		}
+		if policy == nil || normalizeCertificatePolicy(policy) != nil {
+			return
+		}
+		kty, size, curve = policy.KeyProps.Kty, policy.KeyProps.KeySize, policy.KeyProps.Curve
+	}
+	key, err := generateVaultKey(kty, size, curve)
+	q.generated = &generatedKey{kty: kty, size: size, curve: curve, key: key, err: err}
+}
+
+// newKey returns the key material generated before the store was locked when it was made for
+// the same parameters, and generates it otherwise
+func (q *vaultRequest) newKey(kty string, size int, curve string) (crypto.Signer, error) {
+	if g := q.generated; g != nil && g.kty == kty && g.size == size && g.curve == curve {
+		q.generated = nil
+		return g.key, g.err
+	}
+	return generateVaultKey(kty, size, curve)
+}
+
+// generateVaultKey creates RSA or EC key material. HSM key types get software keys.
+func generateVaultKey(kty string, size int, curve string) (crypto.Signer, error) {
+	switch kty {
+	case "RSA", "RSA-HSM":
+		if size == 0 {
+			size = 2048
+		}
+		if size != 2048 && size != 3072 && size != 4096 {
+			return nil, fmt.Errorf("Invalid key size %d; RSA keys are 2048, 3072 or 4096 bits.", size)
+		}
+		return rsa.GenerateKey(rand.Reader, size)
+	case "EC", "EC-HSM":
+		if curve == "" {
+			curve = "P-256"
+		}
+		c, ok := ecdsaCurves[curve]
+		if !ok {
+			return nil, fmt.Errorf("Invalid curve %s; EC keys use P-256, P-384 or P-521.", curve)
+		}
+		return ecdsa.GenerateKey(c.curve, rand.Reader)
+	case "":
+		return nil, fmt.Errorf("Property kty is required.")
+	}
+	return nil, fmt.Errorf("Key type %s is not supported; use RSA, RSA-HSM, EC or EC-HSM.", kty)
+}
+
+// defaultKeyOps are the operations permitted on a key created without key_ops
+func defaultKeyOps(key crypto.Signer) []string {
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return []string{"encrypt", "decrypt", "sign", "verify", "wrapKey", "unwrapKey"}
+	}
+	return []string{"sign", "verify"}
+}
+
+// jsonWebKey renders the public part of a key version as a JSON web key
+func jsonWebKey(kid string, v *vaultVersion) map[string]interface{} {
+	jwk := map[string]interface{}{"kid": kid, "kty": v.kty, "key_ops": v.keyOps}
+	switch key := v.key.(type) {
+	case *rsa.PrivateKey:
+		jwk["n"] = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PrivateKey:
+		if pub, err := key.PublicKey.ECDH(); err == nil {
+			point := pub.Bytes()[1:] // uncompressed X || Y
+			jwk["crv"] = key.Curve.Params().Name
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(point[:len(point)/2])
+			jwk["y"] = base64.RawURLEncoding.EncodeToString(point[len(point)/2:])
+		}
+	}
+	return jwk
+}
+
+// decodeVaultBytes decodes base64url data, with or without padding
+func decodeVaultBytes(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// signatureHash checks a digest fits a signature algorithm and the key, returning its hash
+func signatureHash(pub crypto.PublicKey, alg string, digest []byte) (crypto.Hash, error) {
+	hash, ok := signatureHashes[alg]
+	if !ok {
+		return 0, fmt.Errorf("Algorithm %s is not supported.", alg)
+	}
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "ES") {
+			return 0, fmt.Errorf("Algorithm %s is not supported for RSA keys.", alg)
+		}
+	case *ecdsa.PublicKey:
+		if ecdsaCurves[key.Curve.Params().Name].algorithm != alg {
+			return 0, fmt.Errorf("Algorithm %s is not supported for %s keys.", alg, key.Curve.Params().Name)
+		}
+	}
+	if len(digest) != hash.Size() {
+		return 0, fmt.Errorf("Invalid digest length %d for algorithm %s; expected %d.", len(digest), alg, hash.Size())
+	}
+	return hash, nil
+}
+
+// vaultSign signs a digest. EC signatures are the fixed-size concatenation of r and s.
+func vaultSign(key crypto.Signer, alg string, digest []byte) ([]byte, error) {
+	hash, err := signatureHash(key.Public(), alg, digest)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.SignPSS(rand.Reader, k, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...), nil
+	}
+	return nil, fmt.Errorf("Unsupported key.")
+}
+
+// vaultVerify checks a signature over a digest
+func vaultVerify(pub crypto.PublicKey, alg string, digest, signature []byte) (bool, error) {
+	hash, err := signatureHash(pub, alg, digest)
+	if err != nil {
+		return false, err
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(k, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil, nil
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false, nil
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, digest, r, s), nil
+	}
+	return false, fmt.Errorf("Unsupported key.")
+}
+
+// rsaEncryptionKey returns the RSA key of an encryption algorithm
+func rsaEncryptionKey(key crypto.Signer, alg string) (*rsa.PrivateKey, error) {
+	k, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Algorithm %s is not supported for EC keys.", alg)
+	}
+	switch alg {
+	case "RSA1_5", "RSA-OAEP", "RSA-OAEP-256":
+		return k, nil
+	}
+	return nil, fmt.Errorf("Algorithm %s is not supported; use RSA1_5, RSA-OAEP or RSA-OAEP-256.", alg)
+}
+
+// vaultEncrypt encrypts or wraps a value with the public part of an RSA key
+func vaultEncrypt(key crypto.Signer, alg string, plaintext []byte) ([]byte, error) {
+	k, err := rsaEncryptionKey(key, alg)
+	if err != nil {
+		return nil, err
+	}
+	switch alg {
+	case "RSA-OAEP":
+		return rsa.EncryptOAEP(sha1.New(), rand.Reader, &k.PublicKey, plaintext, nil)
+	case "RSA-OAEP-256":
+		return rsa.EncryptOAEP(sha256.New(), rand.Reader, &k.PublicKey, plaintext, nil)
+	}
+	return rsa.EncryptPKCS1v15(rand.Reader, &k.PublicKey, plaintext)
+}
+
+// vaultDecrypt decrypts or unwraps a value with an RSA key
+func vaultDecrypt(key crypto.Signer, alg string, ciphertext []byte) ([]byte, error) {
+	k, err := rsaEncryptionKey(key, alg)
+	if err != nil {
+		return nil, err
+	}
+	var plaintext []byte
+	switch alg {
+	case "RSA-OAEP":
+		plaintext, err = rsa.DecryptOAEP(sha1.New(), rand.Reader, k, ciphertext, nil)
+	case "RSA-OAEP-256":
+		plaintext, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, k, ciphertext, nil)
+	default:
+		plaintext, err = rsa.DecryptPKCS1v15(rand.Reader, k, ciphertext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("The value could not be decrypted with algorithm %s.", alg)
+	}
+	return plaintext, nil
+}
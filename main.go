@@ -1,22 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/yourcloudtools/mockzure/internal/mappers"
 	"github.com/yourcloudtools/mockzure/internal/routes"
-	"github.com/yourcloudtools/mockzure/internal/specs"
 	yaml "gopkg.in/yaml.v3"
 )
 
@@ -29,10 +33,11 @@ func encodeJSON(w http.ResponseWriter, data interface{}) error {
 // Lightweight replicas of types and behavior from Sandman's internal mock
 
 type ResourceGroup struct {
-	ID       string            `json:"id" yaml:"id"`
-	Name     string            `json:"name" yaml:"name"`
-	Location string            `json:"location" yaml:"location"`
-	Tags     map[string]string `json:"tags" yaml:"tags"`
+	ID             string            `json:"id" yaml:"id"`
+	Name           string            `json:"name" yaml:"name"`
+	SubscriptionID string            `json:"subscriptionId,omitempty" yaml:"subscriptionId,omitempty"` // Owning subscription; derived from id when empty
+	Location       string            `json:"location" yaml:"location"`
+	Tags           map[string]string `json:"tags" yaml:"tags"`
 }
 
 type MockVM struct {
@@ -50,6 +55,16 @@ type MockVM struct {
 	Owner             string            `json:"owner" yaml:"owner"`
 	CostCenter        string            `json:"costCenter" yaml:"costCenter"`
 	Environment       string            `json:"environment" yaml:"environment"`
+
+	// Optional profile returned in the ARM view of the VM; see vms.go
+	Zones             []string             `json:"zones,omitempty" yaml:"zones,omitempty"`
+	ImageReference    *VMImageReference    `json:"imageReference,omitempty" yaml:"imageReference,omitempty"`
+	OSDisk            *VMDisk              `json:"osDisk,omitempty" yaml:"osDisk,omitempty"`
+	DataDisks         []VMDisk             `json:"dataDisks,omitempty" yaml:"dataDisks,omitempty"`
+	NetworkInterfaces []VMNetworkInterface `json:"networkInterfaces,omitempty" yaml:"networkInterfaces,omitempty"`
+	OSProfile         *VMOSProfile         `json:"osProfile,omitempty" yaml:"osProfile,omitempty"`
+	VMAgent           *VMAgent             `json:"vmAgent,omitempty" yaml:"vmAgent,omitempty"`
+	Extensions        []VMExtension        `json:"extensions,omitempty" yaml:"extensions,omitempty"`
 }
 
 type MockAzureRole struct {
@@ -81,6 +96,7 @@ type MockUser struct {
 	Permissions       []MockPermission `json:"permissions" yaml:"permissions"`
 	ResourceGroups    []string         `json:"resourceGroups" yaml:"resourceGroups"`
 	Subscriptions     []string         `json:"subscriptions" yaml:"subscriptions"`
+	TenantID          string           `json:"tenantId,omitempty" yaml:"tenantId,omitempty"` // Home tenant; defaults to the first tenant
 }
 
 // ServiceAccount represents an Azure Service Principal / Service Account
@@ -94,11 +110,14 @@ type ServiceAccount struct {
 	Permissions      []ResourceGroupPerm `json:"permissions" yaml:"permissions"`
 	ServicePrincipal bool                `json:"servicePrincipal" yaml:"servicePrincipal"`
 	GraphPermissions []string            `json:"graphPermissions" yaml:"graphPermissions"` // Microsoft Graph API permissions
+	TenantID         string              `json:"tenantId" yaml:"tenantId"`                 // Home tenant
+	Subscriptions    []string            `json:"subscriptions" yaml:"subscriptions"`       // Accessible subscriptions; empty means all in the home tenant
+	AzureRoles       []MockAzureRole     `json:"azureRoles" yaml:"azureRoles"`             // Role assignments checked by data planes such as Blob storage
 }
 
 // ResourceGroupPerm represents permissions for a service account on a resource group
 type ResourceGroupPerm struct {
-	ResourceGroup string   `json:"resourceGroup" yaml:"resourceGroup"` // Resource group name or "*" for all
+	ResourceGroup string   `json:"resourceGroup" yaml:"resourceGroup"` // Resource group name, its scope, or "*" for all
 	Permissions   []string `json:"permissions" yaml:"permissions"`     // "read", "write", "start", "stop", "restart"
 }
 
@@ -118,24 +137,43 @@ type ServiceAccountSecret struct {
 
 // FullConfig represents the YAML/JSON configuration file schema
 type FullConfig struct {
+	Tenants         []*Tenant              `json:"tenants" yaml:"tenants"`
+	Subscriptions   []*Subscription        `json:"subscriptions" yaml:"subscriptions"`
 	ResourceGroups  []*ResourceGroup       `json:"resourceGroups" yaml:"resourceGroups"`
 	VMs             []*MockVM              `json:"vms" yaml:"vms"`
 	Users           []*MockUser            `json:"users" yaml:"users"`
 	ServiceAccounts []FullConfigServiceAcc `json:"serviceAccounts" yaml:"serviceAccounts"`
+	Resources       []*GenericResource     `json:"resources" yaml:"resources"`
+	VMSizes         []*VMSize              `json:"vmSizes,omitempty" yaml:"vmSizes,omitempty"`
+	Quotas          []*Quota               `json:"quotas,omitempty" yaml:"quotas,omitempty"`
+	RunCommands     []*RunCommandResponder `json:"runCommands,omitempty" yaml:"runCommands,omitempty"`
+	KeyVaults       []*KeyVault            `json:"keyVaults,omitempty" yaml:"keyVaults,omitempty"`
+	KeyVaultHost    string                 `json:"keyVaultHost,omitempty" yaml:"keyVaultHost,omitempty"` // vaults answer at {name}.{keyVaultHost}
+	KeyVaultPath    string                 `json:"keyVaultPath,omitempty" yaml:"keyVaultPath,omitempty"` // and at {keyVaultPath}/{name}
+	BlobHost        string                 `json:"blobHost,omitempty" yaml:"blobHost,omitempty"`         // storage accounts answer at {name}.{blobHost}
+	BlobPath        string                 `json:"blobPath,omitempty" yaml:"blobPath,omitempty"`         // and at {blobPath}/{name}
+	BlobDataDir     string                 `json:"blobDataDir,omitempty" yaml:"blobDataDir,omitempty"`   // keeps blobs on disk; in memory when empty
+	StateDir        string                 `json:"stateDir,omitempty" yaml:"stateDir,omitempty"`         // keeps mock data and snapshots on disk; in memory when empty
+	StateBackend    string                 `json:"stateBackend,omitempty" yaml:"stateBackend,omitempty"` // how stateDir keeps them: "files" (the default) or "bolt"
+	Faults          []*FaultRule           `json:"faults,omitempty" yaml:"faults,omitempty"`
+	Throttling      *ThrottlingConfig      `json:"throttling,omitempty" yaml:"throttling,omitempty"` // ARM and Graph throttling; off when unset
 }
 
 // FullConfigServiceAcc is a service account definition including secret as stored in config
 type FullConfigServiceAcc struct {
 	ID               string              `json:"id,omitempty" yaml:"id,omitempty"`
 	ApplicationID    string              `json:"applicationId" yaml:"applicationId"`
-	Secret           string              `json:"secret" yaml:"secret"`
+	Secret           string              `json:"secret,omitempty" yaml:"secret,omitempty"` // an account without one cannot sign in
 	DisplayName      string              `json:"displayName,omitempty" yaml:"displayName,omitempty"`
 	Description      string              `json:"description,omitempty" yaml:"description,omitempty"`
-	AccountEnabled   bool                `json:"accountEnabled,omitempty" yaml:"accountEnabled,omitempty"`
+	AccountEnabled   *bool               `json:"accountEnabled,omitempty" yaml:"accountEnabled,omitempty"` // defaults to true
 	CreatedDateTime  time.Time           `json:"createdDateTime,omitempty" yaml:"createdDateTime,omitempty"`
 	Permissions      []ResourceGroupPerm `json:"permissions,omitempty" yaml:"permissions,omitempty"`
-	ServicePrincipal bool                `json:"servicePrincipal,omitempty" yaml:"servicePrincipal,omitempty"`
+	ServicePrincipal *bool               `json:"servicePrincipal,omitempty" yaml:"servicePrincipal,omitempty"` // defaults to true
 	GraphPermissions []string            `json:"graphPermissions,omitempty" yaml:"graphPermissions,omitempty"`
+	TenantID         string              `json:"tenantId,omitempty" yaml:"tenantId,omitempty"`
+	Subscriptions    []string            `json:"subscriptions,omitempty" yaml:"subscriptions,omitempty"`
+	AzureRoles       []MockAzureRole     `json:"azureRoles,omitempty" yaml:"azureRoles,omitempty"`
 }
 
 type MockEntraIDResponse struct {
@@ -161,25 +199,56 @@ type MockUserInfo struct {
 }
 
 type Store struct {
+	mu              sync.RWMutex // guards resources written through the ARM API
+	tenants         []*Tenant
+	subscriptions   []*Subscription
 	resourceGroups  []*ResourceGroup
 	vms             []*MockVM
+	scaleSetVMs     []*ScaleSetVM
+	scaleSetNextIDs map[string]int // next instance ID of each scale set, by lowercased scale set ID
 	users           []*MockUser
 	serviceAccounts []*ServiceAccount
+	resources       []*GenericResource
+	deployments     []*Deployment
+	vmSizes         []*VMSize               // VM sizes extending the built-in catalog
+	quotas          []*Quota                // compute quota limits overriding the defaults
+	runResponders   []*RunCommandResponder  // scripted run command output, first match wins
+	runCommands     []*RunCommandInvocation // run command and custom script invocations, oldest first
+	keyVaults       []*KeyVault
+	keyVaultHost    string // host name suffix of the vault data plane
+	keyVaultPath    string // path prefix of the vault data plane
+	blobHost        string // host name suffix of the Blob service
+	blobPath        string // path prefix of the Blob service
+	blobBackend     blobBackend
+	blobAccounts    map[string]*blobAccount // Blob services loaded so far, by lowercased account name
+	stateBackend    stateBackend            // saves the mock data between restarts and holds snapshots
+	changes         uint64                  // counts changes to the data stateBackend saves
+	persistMu       sync.Mutex              // serializes saves to stateBackend
+	savedChanges    uint64                  // changes when the data was last saved; guarded by persistMu
+	faults          *faultInjector          // fault rules applied in front of every handler
+	throttling      *throttler              // ARM and Graph rate limits; nil when throttling is off
+	operations      map[string]*recordedOperation
+	operationOrder  []string
 	clients         map[string]*RegisteredClient
 	codes           map[string]*AuthCode
 	config          *ServiceAccountConfig
 	configPath      string
+	conformance     routes.ConformanceMode // how spec-driven routes check their responses
+	replay          bool                   // spec-driven routes answer from x-ms-examples before the mappers
 }
 
 // GetResourceGroups returns resource groups as interface slice for mappers
 func (s *Store) GetResourceGroups() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	result := make([]interface{}, len(s.resourceGroups))
 	for i, rg := range s.resourceGroups {
 		result[i] = map[string]interface{}{
-			"id":       rg.ID,
-			"name":     rg.Name,
-			"location": rg.Location,
-			"tags":     rg.Tags,
+			"id":             rg.ID,
+			"name":           rg.Name,
+			"subscriptionId": rg.SubscriptionID,
+			"location":       rg.Location,
+			"tags":           rg.Tags,
 		}
 	}
 	return result
@@ -187,29 +256,43 @@ func (s *Store) GetResourceGroups() []interface{} {
 
 // GetVMs returns VMs as interface slice for mappers
 func (s *Store) GetVMs() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	result := make([]interface{}, len(s.vms))
 	for i, vm := range s.vms {
-		result[i] = map[string]interface{}{
-			"id":                vm.ID,
-			"name":              vm.Name,
-			"resourceGroup":     vm.ResourceGroup,
-			"location":          vm.Location,
-			"vmSize":            vm.VMSize,
-			"osType":            vm.OSType,
-			"provisioningState": vm.ProvisioningState,
-			"powerState":        vm.PowerState,
-			"status":            vm.Status,
-			"tags":              vm.Tags,
-		}
+		result[i] = s.vmView(vm)
 	}
 	return result
 }
 
+// vmView returns the shape mappers see a VM in. The caller must hold s.mu.
+func (s *Store) vmView(vm *MockVM) map[string]interface{} {
+	m := map[string]interface{}{
+		"id":                vm.ID,
+		"name":              vm.Name,
+		"resourceGroup":     vm.ResourceGroup,
+		"location":          vm.Location,
+		"vmSize":            vm.VMSize,
+		"osType":            vm.OSType,
+		"provisioningState": vm.ProvisioningState,
+		"powerState":        vm.PowerState,
+		"status":            vm.Status,
+		"tags":              vm.Tags,
+		"subscriptionId":    s.vmSubscriptionID(vm),
+	}
+	for k, v := range vmProfile(vm) {
+		m[k] = v
+	}
+	return m
+}
+
 // GetUsers returns users as interface slice for mappers
 func (s *Store) GetUsers() []interface{} {
 	if s == nil {
 		return []interface{}{}
 	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.users == nil {
 		return []interface{}{}
 	}
@@ -236,6 +319,8 @@ func (s *Store) GetUsers() []interface{} {
 
 // GetServiceAccounts returns service accounts as interface slice for mappers
 func (s *Store) GetServiceAccounts() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	result := make([]interface{}, len(s.serviceAccounts))
 	for i, sa := range s.serviceAccounts {
 		result[i] = map[string]interface{}{
@@ -251,20 +336,43 @@ func (s *Store) GetServiceAccounts() []interface{} {
 }
 
 func (s *Store) init() {
+	if err := s.reset(); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := s.loadSavedState(); err != nil {
+		log.Fatalf("Failed to load saved mock data: %v", err)
+	}
+	// The data is what was saved, or what the config gives again on the next start
+	s.savedChanges = s.changes
+}
+
+// initData empties the mock data and applies the defaults config values override
+func (s *Store) initData() {
 	// Start empty; load only what is defined in config
+	s.tenants = []*Tenant{}
+	s.subscriptions = []*Subscription{}
 	s.resourceGroups = []*ResourceGroup{}
 	s.vms = []*MockVM{}
+	s.scaleSetVMs = []*ScaleSetVM{}
+	s.scaleSetNextIDs = map[string]int{}
 	s.users = []*MockUser{}
 	s.serviceAccounts = []*ServiceAccount{}
-
-	// Load from config path (must be set)
-	if err := s.loadConfig(); err != nil {
-		log.Fatalf("Failed to load config: %v", err)
-	}
-
-	// app registrations and auth codes
-	s.clients = make(map[string]*RegisteredClient)
-	s.codes = make(map[string]*AuthCode)
+	s.resources = []*GenericResource{}
+	s.deployments = []*Deployment{}
+	s.vmSizes = []*VMSize{}
+	s.quotas = []*Quota{}
+	s.runResponders = []*RunCommandResponder{}
+	s.runCommands = []*RunCommandInvocation{}
+	s.keyVaults = []*KeyVault{}
+	s.keyVaultHost = defaultKeyVaultHost
+	s.keyVaultPath = defaultKeyVaultPath
+	s.blobHost = defaultBlobHost
+	s.blobPath = defaultBlobPath
+	s.blobBackend = memoryBlobs{}
+	s.stateBackend = newMemoryState()
+	s.faults, _ = newFaultInjector(nil)
+	s.throttling = nil
+	s.blobAccounts = map[string]*blobAccount{}
 }
 
 // loadConfig loads resources and secrets from the configured file
@@ -297,10 +405,20 @@ func (s *Store) loadConfig() error {
 		}
 	}
 
+	for _, problem := range validateConfig(data) {
+		log.Printf("Config warning: %s:%s", s.configPath, problem)
+	}
+
 	// Secrets for auth
 	s.config = &ServiceAccountConfig{ServiceAccounts: []ServiceAccountSecret{}}
 
 	// Hydrate resources
+	if fc.Tenants != nil {
+		s.tenants = fc.Tenants
+	}
+	if fc.Subscriptions != nil {
+		s.subscriptions = fc.Subscriptions
+	}
 	if fc.ResourceGroups != nil {
 		s.resourceGroups = fc.ResourceGroups
 	}
@@ -318,11 +436,14 @@ func (s *Store) loadConfig() error {
 				ApplicationID:    csa.ApplicationID,
 				DisplayName:      csa.DisplayName,
 				Description:      csa.Description,
-				AccountEnabled:   csa.AccountEnabled || true,
+				AccountEnabled:   csa.AccountEnabled == nil || *csa.AccountEnabled,
 				CreatedDateTime:  csa.CreatedDateTime,
 				Permissions:      csa.Permissions,
-				ServicePrincipal: csa.ServicePrincipal || true,
+				ServicePrincipal: csa.ServicePrincipal == nil || *csa.ServicePrincipal,
 				GraphPermissions: csa.GraphPermissions,
+				TenantID:         csa.TenantID,
+				Subscriptions:    csa.Subscriptions,
+				AzureRoles:       csa.AzureRoles,
 			}
 			s.serviceAccounts = append(s.serviceAccounts, sa)
 			// Add secret to auth config
@@ -336,8 +457,70 @@ func (s *Store) loadConfig() error {
 		}
 	}
 
-	log.Printf("Config loaded: %d RGs, %d VMs, %d users, %d service accounts",
-		len(s.resourceGroups), len(s.vms), len(s.users), len(s.serviceAccounts))
+	if fc.Resources != nil {
+		s.resources = fc.Resources
+	}
+	if fc.VMSizes != nil {
+		s.vmSizes = fc.VMSizes
+	}
+	if fc.Quotas != nil {
+		s.quotas = fc.Quotas
+	}
+	if fc.RunCommands != nil {
+		if err := validateRunCommandResponders(fc.RunCommands); err != nil {
+			return err
+		}
+		s.runResponders = fc.RunCommands
+	}
+	if fc.KeyVaults != nil {
+		if err := validateKeyVaults(fc.KeyVaults); err != nil {
+			return err
+		}
+		s.keyVaults = fc.KeyVaults
+	}
+	if fc.KeyVaultHost != "" {
+		s.keyVaultHost = strings.Trim(fc.KeyVaultHost, ".")
+	}
+	if fc.KeyVaultPath != "" {
+		s.keyVaultPath = "/" + strings.Trim(fc.KeyVaultPath, "/")
+	}
+	if fc.BlobHost != "" {
+		s.blobHost = strings.Trim(fc.BlobHost, ".")
+	}
+	if fc.BlobPath != "" {
+		s.blobPath = "/" + strings.Trim(fc.BlobPath, "/")
+	}
+	if fc.BlobDataDir != "" {
+		s.blobBackend = fileBlobs{root: fc.BlobDataDir}
+	}
+	if fc.StateDir != "" || fc.StateBackend != "" {
+		backend, err := newStateBackend(fc.StateDir, fc.StateBackend)
+		if err != nil {
+			return err
+		}
+		s.stateBackend = backend
+	}
+	if fc.Faults != nil {
+		faults, err := newFaultInjector(fc.Faults)
+		if err != nil {
+			return err
+		}
+		s.faults = faults
+	}
+	if fc.Throttling != nil {
+		throttling, err := newThrottler(fc.Throttling)
+		if err != nil {
+			return fmt.Errorf("throttling: %w", err)
+		}
+		s.throttling = throttling
+	}
+
+	s.normalizeTenancy()
+	s.normalizeResources()
+	s.normalizeKeyVaults()
+
+	log.Printf("Config loaded: %d tenants, %d subscriptions, %d RGs, %d VMs, %d resources, %d users, %d service accounts",
+		len(s.tenants), len(s.subscriptions), len(s.resourceGroups), len(s.vms), len(s.resources), len(s.users), len(s.serviceAccounts))
 	return nil
 }
 
@@ -365,6 +548,17 @@ func (s *Store) authenticateServiceAccount(r *http.Request) (*ServiceAccount, er
 			}
 		}
 
+		// Tenant-scoped token endpoints issue JWTs carrying appid and tid claims
+		if claims, err := parseUnsignedJWT(token); err == nil && claims["idtyp"] == "app" {
+			appID, _ := claims["appid"].(string)
+			tid, _ := claims["tid"].(string)
+			for _, sa := range s.serviceAccounts {
+				if sa.ApplicationID == appID && strings.EqualFold(sa.TenantID, tid) && sa.AccountEnabled {
+					return sa, nil
+				}
+			}
+		}
+
 		return nil, fmt.Errorf("invalid or expired token")
 	}
 
@@ -413,9 +607,14 @@ func (s *Store) authenticateServiceAccount(r *http.Request) (*ServiceAccount, er
 
 // hasPermission checks if a service account has a specific permission on a resource group
 func (sa *ServiceAccount) hasPermission(resourceGroup, permission string) bool {
+	return sa.hasPermissionIn("", resourceGroup, permission)
+}
+
+// hasPermissionIn is hasPermission for a resource group in a subscription
+func (sa *ServiceAccount) hasPermissionIn(subscriptionID, resourceGroup, permission string) bool {
 	for _, perm := range sa.Permissions {
 		// Check for wildcard or specific resource group
-		if perm.ResourceGroup != "*" && perm.ResourceGroup != resourceGroup {
+		if !grantCovers(perm.ResourceGroup, subscriptionID, resourceGroup) {
 			continue
 		}
 
@@ -444,6 +643,7 @@ type AuthCode struct {
 	RedirectURI string
 	Scope       string
 	UserSub     string
+	TenantRef   string // {tenant} segment of the authorize URL; empty for unscoped requests
 	IssuedAt    time.Time
 }
 
@@ -981,8 +1181,15 @@ func renderUserSelectionPage(w http.ResponseWriter, r *http.Request, clientID, r
 		
 		<div class="user-list">`
 
-	// Add each user from the store
+	// Add each user from the store; tenant-scoped sign-ins only offer that tenant's users
+	var tenant *Tenant
+	if ref, ok := tenantFromRequest(r); ok && !isMultiTenantAlias(ref) {
+		tenant = store.findTenant(ref)
+	}
 	for _, user := range store.users {
+		if tenant != nil && !strings.EqualFold(user.TenantID, tenant.ID) {
+			continue
+		}
 		html += fmt.Sprintf(`
 			<div class="user-card" onclick="selectUser('%s')">
 				<div class="user-name">%s</div>
@@ -1008,7 +1215,7 @@ func renderUserSelectionPage(w http.ResponseWriter, r *http.Request, clientID, r
 		function selectUser(userId) {
 			const params = new URLSearchParams(window.location.search);
 			params.set('user_id', userId);
-			window.location.href = '/oauth2/v2.0/authorize?' + params.toString();
+			window.location.href = window.location.pathname + '?' + params.toString();
 		}
 	</script>
 </body>
@@ -1021,117 +1228,169 @@ func renderUserSelectionPage(w http.ResponseWriter, r *http.Request, clientID, r
 	}
 }
 
-// registerFallbackVMRoutes registers essential VM routes manually as a fallback
-// when arm-compute.json is empty or missing
-func registerFallbackVMRoutes(mux *http.ServeMux, store *Store) {
-	// Register VM list routes using the route matching system
-	// These routes are essential for VM discovery to work
+// armRoute is an ARM operation matched by the hardcoded routes
+type armRoute struct {
+	operationID string
+	pattern     string
+	params      map[string]string
+}
 
-	// List VMs in a resource group
-	mux.HandleFunc("/subscriptions/", func(w http.ResponseWriter, r *http.Request) {
-		// Only handle GET requests for VM list endpoints
-		if r.Method != http.MethodGet {
-			http.NotFound(w, r)
+// registerFallbackVMRoutes registers essential VM and subscription routes manually as a
+// fallback when arm-compute.json is empty or missing. Requests they do not match are
+// passed on to specs, which serves the spec-driven ARM routes. So are requests for an
+// operation the loaded specs describe, so that its validation, conformance checks and replay apply.
+func registerFallbackVMRoutes(mux *http.ServeMux, store *Store, specs *reloader) {
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		route := fallbackARMRoute(r)
+		if specRoute, _ := specs.match(r); route == nil || (specRoute != nil && specRoute.OperationID == route.operationID) {
+			// No match, or the spec describes the operation - let the spec-driven routes handle it
+			specs.ServeHTTP(w, r)
 			return
 		}
+		routes.ServeARM(w, r, route.params, route.operationID, route.pattern, store)
+	}
 
-		path := r.URL.Path
+	// VM, network, disk, scale set, SKU, storage and deployment routes
+	mux.HandleFunc("/subscriptions/", serve)
+
+	// Subscription and tenant discovery used by az account list and azurerm
+	mux.HandleFunc("/subscriptions", serve)
+	mux.HandleFunc("/tenants", serve)
+
+	// Resource Graph queries used by az graph query and the portal
+	mux.HandleFunc("/providers/Microsoft.ResourceGraph/resources", serve)
+}
+
+// fallbackARMRoute matches a request against the routes registerFallbackVMRoutes serves. It
+// returns nil when they pass the request on to the spec-driven routes.
+func fallbackARMRoute(r *http.Request) *armRoute {
+	path := r.URL.Path
+	switch path {
+	case "/subscriptions":
+		if r.Method != http.MethodGet {
+			return nil
+		}
+		return &armRoute{operationID: "Subscriptions_List", pattern: "/subscriptions", params: map[string]string{}}
+	case "/tenants":
+		if r.Method != http.MethodGet {
+			return nil
+		}
+		return &armRoute{operationID: "Tenants_List", pattern: "/tenants", params: map[string]string{}}
+	case "/providers/Microsoft.ResourceGraph/resources":
+		if r.Method != http.MethodPost {
+			return nil
+		}
+		return &armRoute{operationID: "Resources", pattern: "/providers/Microsoft.ResourceGraph/resources", params: map[string]string{}}
+	}
+	if !strings.HasPrefix(path, "/subscriptions/") {
+		return nil
+	}
+
+	// Template deployments take every method, so match them before the GET-only routes
+	for _, match := range []func(*http.Request) *armRoute{deploymentRoute, networkRoute, diskRoute, scaleSetRoute, computeSkuRoute, storageRoute} {
+		if route := match(r); route != nil {
+			return route
+		}
+	}
 
-		// Match: /subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines
-		rgPattern := regexp.MustCompile(`^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachines/?$`)
-		if matches := rgPattern.FindStringSubmatch(path); matches != nil {
+	// VM updates attach and detach data disks
+	if r.Method == http.MethodPatch {
+		vmUpdatePattern := regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachines/([^/]+)/?$`)
+		if matches := vmUpdatePattern.FindStringSubmatch(path); matches != nil {
 			params := map[string]string{
 				"subscriptionId":    matches[1],
 				"resourceGroupName": matches[2],
+				"vmName":            matches[3],
 			}
-			// Call ARM mapper directly
-			response, err := mappers.MapARMResponse("VirtualMachines_List", "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines", "GET", params, store)
-			if err != nil {
-				log.Printf("Error mapping ARM response: %v", err)
-				errorResponse := map[string]interface{}{
-					"error": map[string]interface{}{
-						"code":    "ResourceNotFound",
-						"message": err.Error(),
-					},
-				}
-				w.Header().Set("Content-Type", "application/json")
-				if strings.Contains(err.Error(), "not found") {
-					w.WriteHeader(http.StatusNotFound)
-				} else {
-					w.WriteHeader(http.StatusInternalServerError)
-				}
-				json.NewEncoder(w).Encode(errorResponse)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-			return
+			return &armRoute{operationID: "VirtualMachines_Update", pattern: "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}", params: params}
 		}
+	}
 
-		// Match: /subscriptions/{subscriptionId}/providers/Microsoft.Compute/virtualMachines
-		allPattern := regexp.MustCompile(`^/subscriptions/([^/]+)/providers/Microsoft.Compute/virtualMachines/?$`)
-		if matches := allPattern.FindStringSubmatch(path); matches != nil {
-			params := map[string]string{
-				"subscriptionId": matches[1],
-			}
-			// Call ARM mapper directly
-			response, err := mappers.MapARMResponse("VirtualMachines_ListAll", "/subscriptions/{subscriptionId}/providers/Microsoft.Compute/virtualMachines", "GET", params, store)
-			if err != nil {
-				log.Printf("Error mapping ARM response: %v", err)
-				errorResponse := map[string]interface{}{
-					"error": map[string]interface{}{
-						"code":    "ResourceNotFound",
-						"message": err.Error(),
-					},
-				}
-				w.Header().Set("Content-Type", "application/json")
-				if strings.Contains(err.Error(), "not found") {
-					w.WriteHeader(http.StatusNotFound)
-				} else {
-					w.WriteHeader(http.StatusInternalServerError)
-				}
-				json.NewEncoder(w).Encode(errorResponse)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-			return
-		}
+	if route := vmExtensionRoute(r); route != nil {
+		return route
+	}
 
-		// Match: /subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}
-		vmPattern := regexp.MustCompile(`^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachines/([^/]+)/?$`)
-		if matches := vmPattern.FindStringSubmatch(path); matches != nil {
+	// VM power actions and run commands
+	if r.Method == http.MethodPost {
+		if matches := vmActionPattern.FindStringSubmatch(path); matches != nil {
 			params := map[string]string{
 				"subscriptionId":    matches[1],
 				"resourceGroupName": matches[2],
 				"vmName":            matches[3],
 			}
-			// Call ARM mapper directly
-			response, err := mappers.MapARMResponse("VirtualMachines_Get", "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}", "GET", params, store)
-			if err != nil {
-				log.Printf("Error mapping ARM response: %v", err)
-				errorResponse := map[string]interface{}{
-					"error": map[string]interface{}{
-						"code":    "ResourceNotFound",
-						"message": err.Error(),
-					},
-				}
-				w.Header().Set("Content-Type", "application/json")
-				if strings.Contains(err.Error(), "not found") {
-					w.WriteHeader(http.StatusNotFound)
-				} else {
-					w.WriteHeader(http.StatusInternalServerError)
-				}
-				json.NewEncoder(w).Encode(errorResponse)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-			return
+			op := map[string]string{"start": "VirtualMachines_Start", "deallocate": "VirtualMachines_Deallocate", "restart": "VirtualMachines_Restart", "runcommand": "VirtualMachines_RunCommand"}[strings.ToLower(matches[4])]
+			return &armRoute{operationID: op, pattern: "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}/" + matches[4], params: params}
 		}
+	}
 
-		// No match - let other handlers try (don't return 404 here, let the spec-driven routes handle it)
-	})
+	// Only handle GET requests for VM list endpoints
+	if r.Method != http.MethodGet {
+		return nil
+	}
+
+	// Match: /subscriptions/{subscriptionId}
+	subPattern := regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/?$`)
+	if matches := subPattern.FindStringSubmatch(path); matches != nil {
+		return &armRoute{operationID: "Subscriptions_Get", pattern: "/subscriptions/{subscriptionId}", params: map[string]string{"subscriptionId": matches[1]}}
+	}
+
+	// Match: /subscriptions/{subscriptionId}/locations
+	locPattern := regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/locations/?$`)
+	if matches := locPattern.FindStringSubmatch(path); matches != nil {
+		return &armRoute{operationID: "Subscriptions_ListLocations", pattern: "/subscriptions/{subscriptionId}/locations", params: map[string]string{"subscriptionId": matches[1]}}
+	}
+
+	// Match: /subscriptions/{subscriptionId}/operationresults/{operationId}
+	opPattern := regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/operationresults/([^/]+)/?$`)
+	if matches := opPattern.FindStringSubmatch(path); matches != nil {
+		params := map[string]string{
+			"subscriptionId": matches[1],
+			"operationId":    matches[2],
+		}
+		return &armRoute{operationID: "OperationResults_Get", pattern: "/subscriptions/{subscriptionId}/operationresults/{operationId}", params: params}
+	}
+
+	// Match: /subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines
+	rgPattern := regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachines/?$`)
+	if matches := rgPattern.FindStringSubmatch(path); matches != nil {
+		params := map[string]string{
+			"subscriptionId":    matches[1],
+			"resourceGroupName": matches[2],
+		}
+		return &armRoute{operationID: "VirtualMachines_List", pattern: "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines", params: params}
+	}
+
+	// Match: /subscriptions/{subscriptionId}/providers/Microsoft.Compute/virtualMachines
+	allPattern := regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/providers/Microsoft.Compute/virtualMachines/?$`)
+	if matches := allPattern.FindStringSubmatch(path); matches != nil {
+		params := map[string]string{
+			"subscriptionId": matches[1],
+		}
+		return &armRoute{operationID: "VirtualMachines_ListAll", pattern: "/subscriptions/{subscriptionId}/providers/Microsoft.Compute/virtualMachines", params: params}
+	}
+
+	// Match: /subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}
+	vmPattern := regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachines/([^/]+)/?$`)
+	if matches := vmPattern.FindStringSubmatch(path); matches != nil {
+		params := map[string]string{
+			"subscriptionId":    matches[1],
+			"resourceGroupName": matches[2],
+			"vmName":            matches[3],
+		}
+		return &armRoute{operationID: "VirtualMachines_Get", pattern: "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}", params: params}
+	}
+
+	// Match: /subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}/instanceView
+	instanceViewPattern := regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachines/([^/]+)/instanceView/?$`)
+	if matches := instanceViewPattern.FindStringSubmatch(path); matches != nil {
+		params := map[string]string{
+			"subscriptionId":    matches[1],
+			"resourceGroupName": matches[2],
+			"vmName":            matches[3],
+		}
+		return &armRoute{operationID: "VirtualMachines_InstanceView", pattern: "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}/instanceView", params: params}
+	}
+	return nil
 }
 
 // registerFallbackGraphRoutes registers essential Graph API routes manually as a fallback
@@ -1227,11 +1486,22 @@ func registerFallbackGraphRoutes(mux *http.ServeMux, store *Store) {
 }
 
 func main() {
+	// Subcommands take their own flags
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	// Parse command line flags
 	var showHelp = flag.Bool("help", false, "Show help information")
 	var showVersion = flag.Bool("version", false, "Show version information")
 	var configPathFlag = flag.String("config", "", "Path to config file (json|yaml). Can also use MOCKZURE_CONFIG env var")
 	var debugFlag = flag.Bool("debug", false, "Enable extensive debug logging for all requests")
+	var strictFlag = flag.Bool("strict", false, "Fail responses that do not match their spec schema. Can also use MOCKZURE_STRICT env var")
+	var replayFlag = flag.Bool("replay", false, "Answer spec operations from their x-ms-examples. Can also use MOCKZURE_REPLAY env var")
+	var watchFlag = flag.Bool("watch", false, "Reload the config and specs when their files change. Can also use MOCKZURE_WATCH env var")
 	flag.Parse()
 
 	// Check for debug mode via flag or environment variable
@@ -1243,6 +1513,27 @@ func main() {
 	if debugMode {
 		log.Printf("Debug mode enabled - all requests will be logged")
 	}
+	strictMode := *strictFlag
+	if !strictMode {
+		strictEnv := os.Getenv("MOCKZURE_STRICT")
+		strictMode = strictEnv == "true" || strictEnv == "1" || strictEnv == "yes"
+	}
+	if strictMode {
+		log.Printf("Strict mode enabled - responses that do not match their spec will fail")
+	}
+	replayMode := *replayFlag
+	if !replayMode {
+		replayEnv := os.Getenv("MOCKZURE_REPLAY")
+		replayMode = replayEnv == "true" || replayEnv == "1" || replayEnv == "yes"
+	}
+	if replayMode {
+		log.Printf("Replay mode enabled - spec operations with examples will answer from them")
+	}
+	watchMode := *watchFlag
+	if !watchMode {
+		watchEnv := os.Getenv("MOCKZURE_WATCH")
+		watchMode = watchEnv == "true" || watchEnv == "1" || watchEnv == "yes"
+	}
 
 	// Handle help flag
 	if *showHelp {
@@ -1250,10 +1541,16 @@ func main() {
 		fmt.Println("")
 		fmt.Println("Usage:")
 		fmt.Println("  mockzure --config /path/to/config.(json|yaml) [options]")
+		fmt.Println("  mockzure validate --config /path/to/config.(json|yaml)")
+		fmt.Println("  mockzure validate --schema > config.schema.json")
+		fmt.Println("  mockzure export [--url http://localhost:8090] [--format yaml|json] [--include-secrets] [--output file]")
 		fmt.Println("")
 		fmt.Println("Options:")
 		fmt.Println("  --config   Path to config file (or set MOCKZURE_CONFIG)")
 		fmt.Println("  --debug    Enable extensive debug logging (or set MOCKZURE_DEBUG=true)")
+		fmt.Println("  --strict   Fail responses that do not match their spec (or set MOCKZURE_STRICT=true)")
+		fmt.Println("  --replay   Answer spec operations from their x-ms-examples (or set MOCKZURE_REPLAY=true)")
+		fmt.Println("  --watch    Reload the config and specs when they change (or set MOCKZURE_WATCH=true)")
 		fmt.Println("  --help     Show this help message")
 		fmt.Println("  --version  Show version information")
 		fmt.Println("")
@@ -1271,6 +1568,11 @@ func main() {
 		fmt.Println("  GET  /mock/azure/stats         - Get server statistics")
 		fmt.Println("  POST /mock/azure/data/clear    - Clear all mock data")
 		fmt.Println("  POST /mock/azure/data/reset    - Reset to default data")
+		fmt.Println("  POST /mock/azure/admin/reload  - Reload the config and specs")
+		fmt.Println("  POST /mock/azure/admin/snapshots              - Save a named snapshot of the mock data")
+		fmt.Println("  POST /mock/azure/admin/snapshots/{name}/restore - Roll back to a snapshot")
+		fmt.Println("  GET  /mock/azure/admin/export  - Export the mock data as a config file")
+		fmt.Println("  POST /mock/azure/admin/faults  - Add a fault injection rule")
 		os.Exit(0)
 	}
 
@@ -1301,47 +1603,86 @@ func main() {
 
 	store := &Store{configPath: cfgPath}
 	store.init()
+	switch {
+	case strictMode:
+		store.conformance = routes.ConformanceStrict
+	case debugMode:
+		store.conformance = routes.ConformanceLog
+	}
+	store.replay = replayMode
+
+	mux, reloads := newServer(store, "mockzure-specs")
+	if watchMode {
+		log.Printf("Watch mode enabled - config and spec changes will be reloaded")
+		go reloads.watch(2*time.Second, nil)
+	}
+
+	// Save the mock data after changes when the config keeps it on disk
+	var handler http.Handler = persistState(store, mux)
+
+	// Apply debug middleware if enabled
+	if debugMode {
+		handler = routes.DebugMiddleware(handler)
+	}
+
+	addr := ":8090"
+	log.Printf("Starting Mockzure on %s", addr)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Stop on SIGINT or SIGTERM once the requests being served are done, then close the state backend
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Shutdown did not finish cleanly: %v", err)
+		}
+	}()
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Mockzure failed to start: %v", err)
+	}
+	<-stopped
+	if err := store.closeState(); err != nil {
+		log.Printf("Failed to close the state backend: %v", err)
+	}
+	log.Printf("Mockzure stopped")
+}
+
+// newMux builds every Mockzure HTTP handler for a store. Hardcoded handlers are
+// registered on the mux; requests they do not claim fall through to the routes
+// generated from the specs in specsDir. Throttling and fault rules apply in front of both.
+func newMux(store *Store, specsDir string) http.Handler {
+	mux, _ := newServer(store, specsDir)
+	return mux
+}
 
+// newServer builds the mux of newMux along with the reloader that swaps in a new
+// config and new specs
+func newServer(store *Store, specsDir string) (http.Handler, *reloader) {
 	mux := http.NewServeMux()
 
 	// Load API specifications and generate routes
-	specsDir := "mockzure-specs"
-	log.Printf("Loading API specifications from: %s", specsDir)
-	if _, err := os.Stat(specsDir); os.IsNotExist(err) {
-		log.Printf("Warning: specs directory '%s' not found, skipping spec-driven routes", specsDir)
-		log.Printf("Note: Only hardcoded mock-specific routes will be available")
-	} else {
-		// Initialize spec loader and registry
-		loader := specs.NewLoader(specsDir)
-		registry := specs.NewRegistry()
-
-		// Load all specs
-		if err := loader.LoadAll(registry); err != nil {
-			log.Printf("Error: Failed to load specs: %v", err)
-			log.Printf("Continuing without spec-driven routes")
-		} else {
-			// Generate routes from specs
-			routeGen := routes.NewRouteGenerator(store)
-			generatedRoutes, err := routeGen.GenerateRoutes(registry)
-			if err != nil {
-				log.Printf("Error: Failed to generate routes from specs: %v", err)
-				log.Printf("Continuing without spec-driven routes")
-			} else if len(generatedRoutes) == 0 {
-				log.Printf("Warning: No routes generated from specifications")
-			} else {
-				log.Printf("Successfully generated %d route(s) from specifications", len(generatedRoutes))
-
-				// Register spec-driven routes
-				// All Azure API endpoints are now generated from specs
-				// Remaining hardcoded routes are only for mock-specific functionality (portal, stats, data management)
-				routes.RegisterRoutes(mux, generatedRoutes)
-			}
-		}
+	loaded, err := loadSpecRoutes(store, specsDir)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		log.Printf("Continuing without spec-driven routes")
+		loaded = &specRoutes{handler: http.NotFoundHandler()}
 	}
+	// All Azure API endpoints are now generated from specs
+	// Spec-driven routes are served behind the hardcoded handlers, and swapped on reload
+	specHandler := newReloader(store, specsDir, loaded)
 
 	// Register fallback VM routes if arm-compute.json is empty or missing
 	// These routes are essential for VM discovery to work
-	registerFallbackVMRoutes(mux, store)
+	registerFallbackVMRoutes(mux, store, specHandler)
 
 	// Register fallback Graph API routes if graph specs are empty or missing
 	// These routes are essential for user sync to work
@@ -1351,16 +1692,33 @@ func main() {
 	// Note: These are kept as hardcoded handlers because they require custom mock logic
 	// (dynamic issuer URL, mock-specific endpoints) that isn't in the OIDC spec.
 	// The spec defines the endpoint structure, but the implementation is mock-specific.
+	// Tenant-scoped requests (/{tenant}/v2.0/.well-known/openid-configuration) advertise
+	// tenant-scoped endpoints and a login.microsoftonline.com style issuer.
 	oidcDiscoveryHandler := func(w http.ResponseWriter, r *http.Request) {
 		iss := baseURL(r)
+		endpoints := baseURL(r)
+		if ref, ok := tenantFromRequest(r); ok {
+			tenantID := "{tenantid}"
+			if !isMultiTenantAlias(ref) {
+				tenant := store.findTenant(ref)
+				if tenant == nil {
+					writeTenantNotFound(w, ref)
+					return
+				}
+				tenantID = tenant.ID
+			}
+			iss = tenantIssuer(r, tenantID)
+			endpoints = baseURL(r) + "/" + ref
+		}
 		doc := map[string]interface{}{
 			"issuer":                                iss,
-			"authorization_endpoint":                iss + "/oauth2/v2.0/authorize",
-			"token_endpoint":                        iss + "/oauth2/v2.0/token",
-			"userinfo_endpoint":                     iss + "/oidc/userinfo",
+			"authorization_endpoint":                endpoints + "/oauth2/v2.0/authorize",
+			"token_endpoint":                        endpoints + "/oauth2/v2.0/token",
+			"userinfo_endpoint":                     baseURL(r) + "/oidc/userinfo",
 			"response_types_supported":              []string{"code"},
 			"id_token_signing_alg_values_supported": []string{"none"},
 			"scopes_supported":                      []string{"openid", "profile", "email", "User.Read"},
+			"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "name", "email", "tid", "oid"},
 		}
 		if err := encodeJSON(w, doc); err != nil {
 			log.Printf("Failed to encode OIDC discovery document: %v", err)
@@ -1369,8 +1727,7 @@ func main() {
 		}
 	}
 	mux.HandleFunc("/.well-known/openid-configuration", oidcDiscoveryHandler)
-	mux.HandleFunc("/tenant-id/v2.0/.well-known/openid-configuration", oidcDiscoveryHandler)
-	mux.HandleFunc("/common/v2.0/.well-known/openid-configuration", oidcDiscoveryHandler)
+	// /{tenant}/v2.0/.well-known/openid-configuration is dispatched by the root handler
 
 	// App registration (JSON)
 	mux.HandleFunc("/mock/azure/apps", func(w http.ResponseWriter, r *http.Request) {
@@ -1396,7 +1753,10 @@ func main() {
 			if c.Scopes == nil {
 				c.Scopes = []string{"openid", "profile", "email"}
 			}
+			store.mu.Lock()
 			store.clients[c.ClientID] = &c
+			store.changed()
+			store.mu.Unlock()
 			w.WriteHeader(http.StatusCreated)
 			if err := encodeJSON(w, c); err != nil {
 				log.Printf("Failed to encode client response: %v", err)
@@ -1409,9 +1769,18 @@ func main() {
 	})
 
 	// Basic web portal at root with tabbed interface
+	// The root pattern also receives tenant-scoped identity routes and spec-driven routes
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if serveKeyVaultRoute(w, r, store) {
+			return
+		}
+		if serveBlobRoute(w, r, store) {
+			return
+		}
 		if r.URL.Path != "/" {
-			http.NotFound(w, r)
+			if !serveTenantRoute(mux, w, r) {
+				specHandler.ServeHTTP(w, r)
+			}
 			return
 		}
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -1437,6 +1806,8 @@ func main() {
 	//   - App Registration: /mock/azure/apps
 	//   - Stats: /mock/azure/stats
 	//   - Data Management: /mock/azure/data/clear, /mock/azure/data/reset
+	//   - Admin: /mock/azure/admin/reload, /mock/azure/admin/snapshots, /mock/azure/admin/export,
+	//     /mock/azure/admin/faults
 	//
 	// ============================================================================
 
@@ -1465,6 +1836,11 @@ func main() {
 			http.Error(w, "invalid authorize request", http.StatusBadRequest)
 			return
 		}
+		tenantRef, scoped := tenantFromRequest(r)
+		if scoped && !isMultiTenantAlias(tenantRef) && store.findTenant(tenantRef) == nil {
+			writeTenantNotFound(w, tenantRef)
+			return
+		}
 		if c, ok := store.clients[clientID]; ok {
 			// validate redirect
 			valid := len(c.RedirectURIs) == 0
@@ -1486,6 +1862,16 @@ func main() {
 			return
 		}
 
+		// Tenant-scoped sign-ins only accept the tenant's own users
+		if tenant := store.findTenant(tenantRef); scoped && tenant != nil {
+			for _, user := range store.users {
+				if user != nil && user.ID == selectedUser && !strings.EqualFold(user.TenantID, tenant.ID) {
+					writeUserNotInTenant(w, user, clientID, tenantRef)
+					return
+				}
+			}
+		}
+
 		// User was selected, create auth code and redirect
 		code := fmt.Sprintf("code_%d", time.Now().UnixNano())
 		store.codes[code] = &AuthCode{
@@ -1494,6 +1880,7 @@ func main() {
 			RedirectURI: redirectURI,
 			Scope:       scope,
 			UserSub:     selectedUser,
+			TenantRef:   tenantRef,
 			IssuedAt:    time.Now(),
 		}
 		u, err := url.Parse(redirectURI)
@@ -1522,6 +1909,15 @@ func main() {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		// Tenant-scoped requests must name a known tenant
+		tenantRef, scoped := tenantFromRequest(r)
+		var tenant *Tenant
+		if scoped && !isMultiTenantAlias(tenantRef) {
+			if tenant = store.findTenant(tenantRef); tenant == nil {
+				writeTenantNotFound(w, tenantRef)
+				return
+			}
+		}
 		// support x-www-form-urlencoded
 		if ct := r.Header.Get("Content-Type"); strings.Contains(ct, "application/x-www-form-urlencoded") {
 			if err := r.ParseForm(); err != nil {
@@ -1541,7 +1937,7 @@ func main() {
 				authenticated := false
 				if store.config != nil {
 					for _, secret := range store.config.ServiceAccounts {
-						if secret.ApplicationID == clientID && secret.Secret == clientSecret {
+						if secret.ApplicationID == clientID && secret.Secret != "" && secret.Secret == clientSecret {
 							authenticated = true
 							break
 						}
@@ -1552,10 +1948,30 @@ func main() {
 					http.Error(w, "invalid_client", http.StatusUnauthorized)
 					return
 				}
+				// Disabled service accounts keep their secret but cannot sign in
+				if store.findServiceAccount(clientID) == nil {
+					http.Error(w, "unauthorized_client", http.StatusUnauthorized)
+					return
+				}
+
+				// Tenant-scoped requests get a JWT carrying the tenant; unscoped ones keep the opaque mock token
+				accessToken := "mock_access_token_" + clientID
+				if scoped {
+					sa := store.findServiceAccount(clientID)
+					if sa == nil {
+						http.Error(w, "invalid_client", http.StatusUnauthorized)
+						return
+					}
+					if tenant != nil && !strings.EqualFold(tenant.ID, sa.TenantID) {
+						writeAppNotInTenant(w, clientID, tenantRef)
+						return
+					}
+					accessToken = appAccessToken(r, sa, sa.TenantID, scope)
+				}
 
 				// Return access token for service account
 				token := map[string]interface{}{
-					"access_token": "mock_access_token_" + clientID,
+					"access_token": accessToken,
 					"token_type":   "Bearer",
 					"expires_in":   3600,
 					"scope":        scope,
@@ -1580,12 +1996,13 @@ func main() {
 			}
 			delete(store.codes, code)
 			// build id_token - look up user from store
-			iss := baseURL(r)
 			var email, name, givenName, familyName = "unknown@dev.local", "Unknown User", "Unknown", "User"
+			var signedIn *MockUser
 
 			// Find the user in the store
 			for _, user := range store.users {
 				if user.ID == ac.UserSub {
+					signedIn = user
 					email = user.UserPrincipalName
 					name = user.DisplayName
 					// Parse given/family names from display name
@@ -1600,10 +2017,13 @@ func main() {
 				}
 			}
 
+			tid, iss := store.signInTenant(r, ac, signedIn)
 			claims := map[string]interface{}{
 				"iss":         iss,
 				"aud":         ac.ClientID,
 				"sub":         ac.UserSub,
+				"oid":         ac.UserSub,
+				"tid":         tid,
 				"email":       email,
 				"name":        name,
 				"given_name":  givenName,
@@ -1613,7 +2033,7 @@ func main() {
 			}
 			idt := makeUnsignedJWT(claims)
 			token := map[string]interface{}{
-				"access_token":  "mock_access_token_" + code,
+				"access_token":  store.signInAccessToken(r, ac, signedIn, tid, "mock_access_token_"+code),
 				"token_type":    "Bearer",
 				"expires_in":    3600,
 				"refresh_token": "mock_refresh_token_" + code,
@@ -1640,12 +2060,13 @@ func main() {
 			return
 		}
 		delete(store.codes, req.Code)
-		iss := baseURL(r)
 
 		// Look up user from store
 		var email, name, givenName, familyName = "unknown@dev.local", "Unknown User", "Unknown", "User"
+		var signedIn *MockUser
 		for _, user := range store.users {
 			if user.ID == ac.UserSub {
+				signedIn = user
 				email = user.UserPrincipalName
 				name = user.DisplayName
 				nameParts := strings.Fields(user.DisplayName)
@@ -1659,8 +2080,9 @@ func main() {
 			}
 		}
 
-		idt := makeUnsignedJWT(map[string]interface{}{"iss": iss, "aud": ac.ClientID, "sub": ac.UserSub, "email": email, "name": name, "given_name": givenName, "family_name": familyName, "iat": time.Now().Unix(), "exp": time.Now().Add(1 * time.Hour).Unix()})
-		token := map[string]interface{}{"access_token": "mock_access_token_" + req.Code, "token_type": "Bearer", "expires_in": 3600, "refresh_token": "mock_refresh_token_" + req.Code, "scope": ac.Scope, "id_token": idt}
+		tid, iss := store.signInTenant(r, ac, signedIn)
+		idt := makeUnsignedJWT(map[string]interface{}{"iss": iss, "aud": ac.ClientID, "sub": ac.UserSub, "oid": ac.UserSub, "tid": tid, "email": email, "name": name, "given_name": givenName, "family_name": familyName, "iat": time.Now().Unix(), "exp": time.Now().Add(1 * time.Hour).Unix()})
+		token := map[string]interface{}{"access_token": store.signInAccessToken(r, ac, signedIn, tid, "mock_access_token_"+req.Code), "token_type": "Bearer", "expires_in": 3600, "refresh_token": "mock_refresh_token_" + req.Code, "scope": ac.Scope, "id_token": idt}
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(token); err != nil {
 			log.Printf("Failed to encode JSON response: %v", err)
@@ -1759,13 +2181,26 @@ func main() {
 		}
 	})
 
+	mux.HandleFunc("/mock/azure/runcommands", func(w http.ResponseWriter, r *http.Request) {
+		serveRunCommandLog(w, r, store)
+	})
+
 	mux.HandleFunc("/mock/azure/data/clear", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		store.mu.Lock()
 		store.vms = []*MockVM{}
+		store.scaleSetVMs = []*ScaleSetVM{}
 		store.users = []*MockUser{}
+		store.resources = []*GenericResource{}
+		store.deployments = []*Deployment{}
+		store.runCommands = []*RunCommandInvocation{}
+		store.changed()
+		store.mu.Unlock()
+		store.clearBlobData()
+		store.clearKeyVaults()
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{"message": "Mock data cleared successfully", "status": "success"}); err != nil {
 			log.Printf("Failed to encode JSON response: %v", err)
@@ -1777,15 +2212,40 @@ func main() {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		store.vms = nil
-		store.users = nil
-		store.init()
 		w.Header().Set("Content-Type", "application/json")
+		if err := store.reset(); err != nil {
+			log.Printf("Reset failed, keeping the current data: %v", err)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			if err := json.NewEncoder(w).Encode(map[string]interface{}{"message": "Reset failed; the current data is kept", "error": err.Error(), "status": "error"}); err != nil {
+				log.Printf("Failed to encode JSON response: %v", err)
+			}
+			return
+		}
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{"message": "Mock data reset to defaults successfully", "status": "success"}); err != nil {
 			log.Printf("Failed to encode JSON response: %v", err)
 		}
 	})
 
+	mux.HandleFunc("/mock/azure/admin/reload", func(w http.ResponseWriter, r *http.Request) {
+		serveReload(w, r, specHandler)
+	})
+
+	faultsHandler := func(w http.ResponseWriter, r *http.Request) {
+		serveFaults(w, r, store)
+	}
+	mux.HandleFunc("/mock/azure/admin/faults", faultsHandler)
+	mux.HandleFunc("/mock/azure/admin/faults/", faultsHandler)
+
+	snapshotsHandler := func(w http.ResponseWriter, r *http.Request) {
+		serveSnapshots(w, r, store)
+	}
+	mux.HandleFunc("/mock/azure/admin/snapshots", snapshotsHandler)
+	mux.HandleFunc("/mock/azure/admin/snapshots/", snapshotsHandler)
+
+	mux.HandleFunc("/mock/azure/admin/export", func(w http.ResponseWriter, r *http.Request) {
+		serveExport(w, r, store)
+	})
+
 	// Convenience API endpoints for client applications
 	// POST /api/users/sync - Sync/fetch users from Azure (Graph API)
 	mux.HandleFunc("/api/users/sync", func(w http.ResponseWriter, r *http.Request) {
@@ -1850,8 +2310,11 @@ func main() {
 			reqBody = make(map[string]interface{})
 		}
 
-		// Extract subscription ID and resource group from request or use defaults
-		subscriptionID := "12345678-1234-1234-1234-123456789012" // Default subscription ID
+		// Extract subscription ID and resource group from request, defaulting to the caller's first subscription
+		store.mu.RLock()
+		caller := store.authenticate(r)
+		subscriptionID := store.defaultSubscriptionFor(caller)
+		store.mu.RUnlock()
 		resourceGroup := ""
 		if subID, ok := reqBody["subscriptionId"].(string); ok && subID != "" {
 			subscriptionID = subID
@@ -1870,22 +2333,29 @@ func main() {
 
 		// Call ARM API mapper to get VMs
 		// Try the resource group specific endpoint first if resource group is provided
-		var response interface{}
-		var err error
-
+		req := mappers.Request{Method: "GET", Params: params}
+		if caller != nil {
+			req.Principal = caller
+		}
 		if resourceGroup != "" {
 			// Try listing VMs in a specific resource group
-			pathPattern := "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines"
-			response, err = mappers.MapARMResponse("VirtualMachines_List", pathPattern, "GET", params, store)
+			req.OperationID = "VirtualMachines_List"
+			req.PathPattern = "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines"
 		} else {
 			// List all VMs across all resource groups
-			pathPattern := "/subscriptions/{subscriptionId}/providers/Microsoft.Compute/virtualMachines"
-			response, err = mappers.MapARMResponse("VirtualMachines_ListAll", pathPattern, "GET", params, store)
+			req.OperationID = "VirtualMachines_ListAll"
+			req.PathPattern = "/subscriptions/{subscriptionId}/providers/Microsoft.Compute/virtualMachines"
 		}
+		response, err := mappers.MapARMRequest(&req, store)
 
 		if err != nil {
 			log.Printf("Error discovering VMs: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to discover VMs: %v", err), http.StatusInternalServerError)
+			status := http.StatusInternalServerError
+			var armErr *mappers.ARMError
+			if errors.As(err, &armErr) {
+				status = armErr.StatusCode
+			}
+			http.Error(w, fmt.Sprintf("Failed to discover VMs: %v", err), status)
 			return
 		}
 
@@ -1895,20 +2365,5 @@ func main() {
 		}
 	})
 
-	// Apply debug middleware if enabled
-	var handler http.Handler = mux
-	if debugMode {
-		handler = routes.DebugMiddleware(mux)
-	}
-
-	addr := ":8090"
-	log.Printf("Starting Mockzure on %s", addr)
-	srv := &http.Server{
-		Addr:              addr,
-		Handler:           handler,
-		ReadHeaderTimeout: 10 * time.Second,
-	}
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Mockzure failed to start: %v", err)
-	}
+	return throttleHandler(store, faultHandler(store, specHandler, mux)), specHandler
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// networkListPattern matches the Microsoft.Network list routes. Single network resources
+// are served by the generic resource routes.
+var networkListPattern = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)(?:/resourcegroups/([^/]+))?/providers/Microsoft\.Network/(virtualNetworks|networkInterfaces|publicIPAddresses|networkSecurityGroups)(?:/([^/]+)/(subnets|securityRules))?/?$`)
+
+// networkListOperations maps the collections in networkListPattern to operation ID prefixes
+var networkListOperations = map[string]string{
+	"virtualnetworks":       "VirtualNetworks",
+	"networkinterfaces":     "NetworkInterfaces",
+	"publicipaddresses":     "PublicIPAddresses",
+	"networksecuritygroups": "NetworkSecurityGroups",
+	"subnets":               "Subnets",
+	"securityrules":         "SecurityRules",
+}
+
+// networkRoute matches the Microsoft.Network list routes, returning nil when the request does not
+func networkRoute(r *http.Request) *armRoute {
+	if r.Method != http.MethodGet {
+		return nil
+	}
+	matches := networkListPattern.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		return nil
+	}
+	subscriptionID, resourceGroup, collection, parentName, childCollection := matches[1], matches[2], matches[3], matches[4], matches[5]
+	if parentName != "" && resourceGroup == "" {
+		return nil
+	}
+
+	pattern := "/subscriptions/{subscriptionId}"
+	params := map[string]string{"subscriptionId": subscriptionID}
+	if resourceGroup != "" {
+		pattern += "/resourceGroups/{resourceGroupName}"
+		params["resourceGroupName"] = resourceGroup
+	}
+	pattern += "/providers/Microsoft.Network/" + collection
+
+	var op string
+	switch {
+	case parentName != "":
+		parentParam := "virtualNetworkName"
+		if strings.EqualFold(collection, "networkSecurityGroups") {
+			parentParam = "networkSecurityGroupName"
+		}
+		pattern += "/{" + parentParam + "}/" + childCollection
+		params[parentParam] = parentName
+		op = networkListOperations[strings.ToLower(childCollection)] + "_List"
+	case resourceGroup != "":
+		op = networkListOperations[strings.ToLower(collection)] + "_List"
+	default:
+		op = networkListOperations[strings.ToLower(collection)] + "_ListAll"
+	}
+	return &armRoute{operationID: op, pattern: pattern, params: params}
+}
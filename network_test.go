@@ -0,0 +1,264 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+const testNetworkPath = testSubscriptionPath + "/resourceGroups/rg-dev/providers/Microsoft.Network"
+
+// nicBody returns a network interface body with one IP configuration in the configured subnet
+func nicBody(ipConfiguration string) string {
+	return `{"location":"eastus","properties":{"ipConfigurations":[{"name":"ipconfig1","properties":{"subnet":{"id":"` +
+		testNetworkPath + `/virtualNetworks/vnet-web/subnets/snet-web"}` + ipConfiguration + `}}]}}`
+}
+
+// nicAddress returns the private IP address of a network interface's first IP configuration
+func nicAddress(nic map[string]interface{}) interface{} {
+	properties, _ := nic["properties"].(map[string]interface{})
+	configs, _ := properties["ipConfigurations"].([]interface{})
+	if len(configs) == 0 {
+		return nil
+	}
+	return configs[0].(map[string]interface{})["properties"].(map[string]interface{})["privateIPAddress"]
+}
+
+func TestNetworkLookupFromVM(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	_, vm := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines/vm-web-01", "")
+	network := vm["properties"].(map[string]interface{})["networkProfile"].(map[string]interface{})
+	nicID := network["networkInterfaces"].([]interface{})[0].(map[string]interface{})["id"].(string)
+
+	code, nic := armRequest(t, mux, "GET", nicID, "")
+	if code != http.StatusOK {
+		t.Fatalf("expected the VM's NIC, got %d %v", code, nic)
+	}
+	properties := nic["properties"].(map[string]interface{})
+	if attached, _ := properties["virtualMachine"].(map[string]interface{}); attached["id"] != vm["id"] {
+		t.Errorf("expected the NIC to reference its VM, got %v", properties["virtualMachine"])
+	}
+	config := properties["ipConfigurations"].([]interface{})[0].(map[string]interface{})["properties"].(map[string]interface{})
+	if config["privateIPAddress"] != "10.1.1.4" {
+		t.Errorf("unexpected private IP address: %v", config["privateIPAddress"])
+	}
+
+	subnetID := config["subnet"].(map[string]interface{})["id"].(string)
+	code, subnet := armRequest(t, mux, "GET", subnetID, "")
+	if code != http.StatusOK || subnet["properties"].(map[string]interface{})["addressPrefix"] != "10.1.1.0/24" {
+		t.Fatalf("expected the NIC's subnet, got %d %v", code, subnet)
+	}
+	if users, _ := subnet["properties"].(map[string]interface{})["ipConfigurations"].([]interface{}); len(users) != 1 {
+		t.Errorf("expected the subnet to list the NIC's IP configuration, got %v", users)
+	}
+
+	code, vnet := armRequest(t, mux, "GET", testNetworkPath+"/virtualNetworks/vnet-web", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected the subnet's vnet, got %d %v", code, vnet)
+	}
+	if subnets, _ := vnet["properties"].(map[string]interface{})["subnets"].([]interface{}); len(subnets) != 1 || subnets[0].(map[string]interface{})["id"] != subnetID {
+		t.Errorf("expected the vnet to embed its subnet, got %v", vnet["properties"])
+	}
+
+	pipID := config["publicIPAddress"].(map[string]interface{})["id"].(string)
+	code, pip := armRequest(t, mux, "GET", pipID, "")
+	if code != http.StatusOK || pip["properties"].(map[string]interface{})["ipAddress"] != "20.51.0.1" {
+		t.Fatalf("expected the NIC's public IP, got %d %v", code, pip)
+	}
+
+	code, nsg := armRequest(t, mux, "GET", testNetworkPath+"/networkSecurityGroups/nsg-web", "")
+	nsgProperties := nsg["properties"].(map[string]interface{})
+	if code != http.StatusOK || len(nsgProperties["securityRules"].([]interface{})) != 1 || len(nsgProperties["defaultSecurityRules"].([]interface{})) != 6 {
+		t.Errorf("expected the NSG with its rule and the default rules, got %d %v", code, nsg)
+	}
+	if subnets, _ := nsgProperties["subnets"].([]interface{}); len(subnets) != 1 {
+		t.Errorf("expected the NSG to list the subnet using it, got %v", nsgProperties["subnets"])
+	}
+}
+
+func TestNetworkInterfaceAddressAllocation(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	// Dynamic addresses are the lowest free ones after the NIC the config seeds at 10.1.1.4
+	for _, want := range []struct{ name, address string }{{"nic-a", "10.1.1.5"}, {"nic-b", "10.1.1.6"}} {
+		code, nic := armRequest(t, mux, "PUT", testNetworkPath+"/networkInterfaces/"+want.name, nicBody(""))
+		if code != http.StatusCreated || nicAddress(nic) != want.address {
+			t.Fatalf("expected %s at %s, got %d %v", want.name, want.address, code, nic)
+		}
+		if nic["properties"].(map[string]interface{})["macAddress"] == nil {
+			t.Errorf("expected %s to have a MAC address", want.name)
+		}
+	}
+	if _, nic := armRequest(t, mux, "PUT", testNetworkPath+"/networkInterfaces/nic-a", nicBody("")); nicAddress(nic) != "10.1.1.5" {
+		t.Errorf("expected an update to keep the address, got %v", nicAddress(nic))
+	}
+
+	tests := []struct {
+		name, body, code string
+	}{
+		{"address in use", nicBody(`,"privateIPAllocationMethod":"Static","privateIPAddress":"10.1.1.5"`), "PrivateIPAddressInUse"},
+		{"address outside subnet", nicBody(`,"privateIPAllocationMethod":"Static","privateIPAddress":"10.1.2.4"`), "PrivateIPAddressNotInSubnet"},
+		{"public IP in use", nicBody(`,"publicIPAddress":{"id":"` + testNetworkPath + `/publicIPAddresses/vm-web-01-pip"}`), "PublicIPAddressInUse"},
+		{"missing subnet", `{"location":"eastus","properties":{"ipConfigurations":[{"name":"ipconfig1","properties":{"subnet":{"id":"` + testNetworkPath + `/virtualNetworks/vnet-web/subnets/missing"}}}]}}`, "InvalidResourceReference"},
+		{"no IP configuration", `{"location":"eastus","properties":{}}`, "InvalidRequestFormat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := armRequest(t, mux, "PUT", testNetworkPath+"/networkInterfaces/nic-c", tt.body)
+			if code != http.StatusBadRequest || armErrorCode(body) != tt.code {
+				t.Errorf("expected %s, got %d %v", tt.code, code, body)
+			}
+		})
+	}
+
+	code, nic := armRequest(t, mux, "PUT", testNetworkPath+"/networkInterfaces/nic-c", nicBody(`,"privateIPAllocationMethod":"Static","privateIPAddress":"10.1.1.100"`))
+	if code != http.StatusCreated || nicAddress(nic) != "10.1.1.100" {
+		t.Errorf("expected a static address, got %d %v", code, nic)
+	}
+
+	// Freed addresses are handed out again
+	if code, _ := armRequest(t, mux, "DELETE", testNetworkPath+"/networkInterfaces/nic-a", ""); code != http.StatusOK {
+		t.Fatalf("expected nic-a to be deleted, got %d", code)
+	}
+	if _, nic := armRequest(t, mux, "PUT", testNetworkPath+"/networkInterfaces/nic-d", nicBody("")); nicAddress(nic) != "10.1.1.5" {
+		t.Errorf("expected the freed address, got %v", nicAddress(nic))
+	}
+
+	// A dynamic public IP gets its address once a NIC uses it
+	code, pip := armRequest(t, mux, "PUT", testNetworkPath+"/publicIPAddresses/pip-basic", `{"location":"eastus","sku":{"name":"Basic"}}`)
+	pipProperties := pip["properties"].(map[string]interface{})
+	if code != http.StatusCreated || pipProperties["publicIPAllocationMethod"] != "Dynamic" || pipProperties["ipAddress"] != nil {
+		t.Fatalf("expected an unassigned dynamic public IP, got %d %v", code, pip)
+	}
+	armRequest(t, mux, "PUT", testNetworkPath+"/networkInterfaces/nic-d", nicBody(`,"publicIPAddress":{"id":"`+testNetworkPath+`/publicIPAddresses/pip-basic"}`))
+	_, pip = armRequest(t, mux, "GET", testNetworkPath+"/publicIPAddresses/pip-basic", "")
+	if pip["properties"].(map[string]interface{})["ipAddress"] != "20.51.0.2" {
+		t.Errorf("expected the public IP to get the next pool address, got %v", pip["properties"])
+	}
+}
+
+func TestNetworkValidation(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	vnetPath := testNetworkPath + "/virtualNetworks/vnet-app"
+	code, vnet := armRequest(t, mux, "PUT", vnetPath, `{"location":"eastus","properties":{"addressSpace":{"addressPrefixes":["10.2.0.0/16"]},
+		"subnets":[{"name":"app","properties":{"addressPrefix":"10.2.1.0/24"}},{"name":"data","properties":{"addressPrefix":"10.2.2.0/24"}}]}}`)
+	if code != http.StatusCreated {
+		t.Fatalf("expected the vnet to be created, got %d %v", code, vnet)
+	}
+	if subnets := vnet["properties"].(map[string]interface{})["subnets"].([]interface{}); len(subnets) != 2 {
+		t.Errorf("expected two inline subnets, got %v", subnets)
+	}
+	code, list := armRequest(t, mux, "GET", vnetPath+"/subnets", "")
+	if code != http.StatusOK || len(list["value"].([]interface{})) != 2 {
+		t.Errorf("expected Subnets_List to return both subnets, got %d %v", code, list)
+	}
+
+	tests := []struct {
+		name, path, body, code string
+	}{
+		{"invalid prefix", vnetPath + "/subnets/bad", `{"properties":{"addressPrefix":"10.2.3.1/24"}}`, "NetcfgInvalidAddressPrefix"},
+		{"outside the vnet", vnetPath + "/subnets/bad", `{"properties":{"addressPrefix":"10.3.0.0/24"}}`, "NetcfgSubnetRangeOutsideVnet"},
+		{"overlapping subnets", vnetPath + "/subnets/bad", `{"properties":{"addressPrefix":"10.2.1.128/25"}}`, "NetcfgSubnetRangesOverlap"},
+		{"missing address space", testNetworkPath + "/virtualNetworks/bad", `{"location":"eastus"}`, "NetcfgInvalidAddressPrefix"},
+		{"rule priority", testNetworkPath + "/networkSecurityGroups/nsg-web/securityRules/bad", `{"properties":{"protocol":"Tcp","access":"Allow","direction":"Inbound","priority":50}}`, "SecurityRuleInvalidPriority"},
+		{"rule conflict", testNetworkPath + "/networkSecurityGroups/nsg-web/securityRules/bad", `{"properties":{"protocol":"Tcp","access":"Deny","direction":"Inbound","priority":1000}}`, "SecurityRuleConflict"},
+		{"rule protocol", testNetworkPath + "/networkSecurityGroups/nsg-web/securityRules/bad", `{"properties":{"protocol":"Http","access":"Allow","direction":"Inbound","priority":200}}`, "SecurityRuleParameterContainsUnsupportedValue"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := armRequest(t, mux, "PUT", tt.path, tt.body)
+			if code != http.StatusBadRequest || armErrorCode(body) != tt.code {
+				t.Errorf("expected %s, got %d %v", tt.code, code, body)
+			}
+		})
+	}
+
+	// Listing the subnets on the vnet replaces the ones it leaves out
+	code, vnet = armRequest(t, mux, "PUT", vnetPath, `{"location":"eastus","properties":{"addressSpace":{"addressPrefixes":["10.2.0.0/16"]},
+		"subnets":[{"name":"app","properties":{"addressPrefix":"10.2.1.0/24"}}]}}`)
+	if code != http.StatusOK || len(vnet["properties"].(map[string]interface{})["subnets"].([]interface{})) != 1 {
+		t.Errorf("expected the data subnet to be removed, got %d %v", code, vnet)
+	}
+	if code, _ := armRequest(t, mux, "GET", vnetPath+"/subnets/data", ""); code != http.StatusNotFound {
+		t.Errorf("expected the removed subnet to be gone, got %d", code)
+	}
+}
+
+func TestNetworkResourcesInUse(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	tests := []struct {
+		name, path, code string
+	}{
+		{"nic attached to a VM", "/networkInterfaces/vm-web-01-nic", "NicInUse"},
+		{"subnet with a nic", "/virtualNetworks/vnet-web/subnets/snet-web", "InUseSubnetCannotBeDeleted"},
+		{"vnet with a subnet in use", "/virtualNetworks/vnet-web", "InUseSubnetCannotBeDeleted"},
+		{"public IP on a nic", "/publicIPAddresses/vm-web-01-pip", "PublicIPAddressCannotBeDeleted"},
+		{"nsg on a subnet", "/networkSecurityGroups/nsg-web", "InUseNetworkSecurityGroupCannotBeDeleted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := armRequest(t, mux, "DELETE", testNetworkPath+tt.path, "")
+			if code != http.StatusBadRequest || armErrorCode(body) != tt.code {
+				t.Errorf("expected %s, got %d %v", tt.code, code, body)
+			}
+		})
+	}
+
+	// Once the VM is gone the NIC, then the public IP, can be deleted
+	if code, _ := armRequest(t, mux, "DELETE", testSubscriptionPath+"/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines/vm-web-01", ""); code != http.StatusOK {
+		t.Fatalf("expected the VM to be deleted, got %d", code)
+	}
+	for _, path := range []string{"/networkInterfaces/vm-web-01-nic", "/publicIPAddresses/vm-web-01-pip", "/virtualNetworks/vnet-web"} {
+		if code, body := armRequest(t, mux, "DELETE", testNetworkPath+path, ""); code != http.StatusOK {
+			t.Errorf("expected %s to be deleted, got %d %v", path, code, body)
+		}
+	}
+}
+
+func TestNetworkListEndpoints(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	tests := []struct {
+		path  string
+		count int
+	}{
+		{testSubscriptionPath + "/providers/Microsoft.Network/virtualNetworks", 1},
+		{testNetworkPath + "/virtualNetworks", 1},
+		{testNetworkPath + "/networkInterfaces", 1},
+		{testSubscriptionPath + "/providers/Microsoft.Network/publicIPAddresses", 1},
+		{testNetworkPath + "/networkSecurityGroups", 1},
+		{testNetworkPath + "/networkSecurityGroups/nsg-web/securityRules", 1},
+		{testSubscriptionPath + "/resourceGroups/rg-prod/providers/Microsoft.Network/networkInterfaces", 0},
+	}
+	for _, tt := range tests {
+		code, body := armRequest(t, mux, "GET", tt.path, "")
+		if code != http.StatusOK {
+			t.Errorf("expected 200 from %s, got %d %v", tt.path, code, body)
+			continue
+		}
+		if value, _ := body["value"].([]interface{}); len(value) != tt.count {
+			t.Errorf("expected %d item(s) from %s, got %v", tt.count, tt.path, body)
+		}
+	}
+
+	if code, body := armRequest(t, mux, "GET", testNetworkPath+"/virtualNetworks/missing/subnets", ""); code != http.StatusNotFound {
+		t.Errorf("expected 404 listing subnets of a missing vnet, got %d %v", code, body)
+	}
+	_, body := armRequest(t, mux, "GET", testNetworkPath+"/networkInterfaces", "")
+	nic := body["value"].([]interface{})[0].(map[string]interface{})
+	if nic["properties"].(map[string]interface{})["virtualMachine"] == nil {
+		t.Errorf("expected listed NICs to reference their VM, got %v", nic)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+)
+
+// Long-running operations can be polled for an hour, and only the most recent are kept
+const (
+	operationRetention = time.Hour
+	maxOperations      = 1000
+)
+
+// recordedOperation is a long-running operation and when it was started
+type recordedOperation struct {
+	op      *mappers.Operation
+	created time.Time
+}
+
+// PutOperation records the outcome of a long-running ARM operation. Operations older than
+// operationRetention are forgotten, as are the oldest beyond maxOperations.
+func (s *Store) PutOperation(op *mappers.Operation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.operations == nil {
+		s.operations = make(map[string]*recordedOperation)
+	}
+	// operationOrder lists the IDs oldest first, so expired operations are at its front
+	now := time.Now()
+	for len(s.operationOrder) > 0 {
+		oldest := s.operations[s.operationOrder[0]]
+		if oldest != nil && len(s.operationOrder) < maxOperations && now.Sub(oldest.created) <= operationRetention {
+			break
+		}
+		delete(s.operations, s.operationOrder[0])
+		s.operationOrder = s.operationOrder[1:]
+	}
+	if _, exists := s.operations[op.ID]; !exists {
+		s.operationOrder = append(s.operationOrder, op.ID)
+	}
+	s.operations[op.ID] = &recordedOperation{op: op, created: now}
+}
+
+// GetOperation returns a recorded long-running operation, or nil if it is unknown or expired
+func (s *Store) GetOperation(id string) *mappers.Operation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	recorded := s.operations[id]
+	if recorded == nil || time.Since(recorded.created) > operationRetention {
+		return nil
+	}
+	return recorded.op
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yourcloudtools/mockzure/internal/routes"
+	"github.com/yourcloudtools/mockzure/internal/specs"
+)
+
+// reset replaces the mock data, app registrations and auth codes with the contents of the config
+// file. When the file cannot be loaded the store is left as it was.
+func (s *Store) reset() error {
+	next, err := s.readConfig()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.swapData(next)
+	s.clients = make(map[string]*RegisteredClient)
+	s.codes = make(map[string]*AuthCode)
+	return nil
+}
+
+// readConfig loads the config file into a new store, leaving this one untouched
+func (s *Store) readConfig() (*Store, error) {
+	next := &Store{configPath: s.configPath}
+	next.initData()
+	if err := next.loadConfig(); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// swapData moves the mock data loaded into next into the store. Operations being polled, app
+// registrations, auth codes, the store's modes and snapshots kept in memory are kept. The caller
+// must hold s.mu.
+func (s *Store) swapData(next *Store) {
+	s.tenants = next.tenants
+	s.subscriptions = next.subscriptions
+	s.resourceGroups = next.resourceGroups
+	s.vms = next.vms
+	s.scaleSetVMs = next.scaleSetVMs
+	s.scaleSetNextIDs = next.scaleSetNextIDs
+	s.users = next.users
+	s.serviceAccounts = next.serviceAccounts
+	s.resources = next.resources
+	s.deployments = next.deployments
+	s.vmSizes = next.vmSizes
+	s.quotas = next.quotas
+	s.runResponders = next.runResponders
+	s.runCommands = next.runCommands
+	s.keyVaults = next.keyVaults
+	s.keyVaultHost = next.keyVaultHost
+	s.keyVaultPath = next.keyVaultPath
+	s.blobHost = next.blobHost
+	s.blobPath = next.blobPath
+	s.blobBackend = next.blobBackend
+	s.blobAccounts = next.blobAccounts
+	s.config = next.config
+	s.faults = next.faults
+	s.throttling = next.throttling
+	_, wasInMemory := s.stateBackend.(*memoryState)
+	if _, inMemory := next.stateBackend.(*memoryState); !inMemory || !wasInMemory {
+		if s.stateBackend != nil && s.stateBackend != next.stateBackend {
+			if err := s.stateBackend.close(); err != nil {
+				log.Printf("Failed to close the previous state backend: %v", err)
+			}
+		}
+		s.stateBackend = next.stateBackend
+	}
+	s.changed()
+}
+
+// specRoutes is the handler of the routes generated from a specs directory
+type specRoutes struct {
+	handler http.Handler
+	router  *routes.Handler // nil when no routes were generated
+	count   int
+}
+
+// loadSpecRoutes loads the specs in specsDir and generates their routes for a store. A missing
+// directory is not an error: Mockzure then serves its hardcoded routes only.
+func loadSpecRoutes(store *Store, specsDir string) (*specRoutes, error) {
+	empty := &specRoutes{handler: http.NotFoundHandler()}
+	log.Printf("Loading API specifications from: %s", specsDir)
+	if _, err := os.Stat(specsDir); os.IsNotExist(err) {
+		log.Printf("Warning: specs directory '%s' not found, skipping spec-driven routes", specsDir)
+		log.Printf("Note: Only hardcoded mock-specific routes will be available")
+		return empty, nil
+	}
+
+	loader := specs.NewLoader(specsDir)
+	registry := specs.NewRegistry()
+	if err := loader.LoadAll(registry); err != nil {
+		return nil, fmt.Errorf("failed to load specs: %w", err)
+	}
+	routeGen := routes.NewRouteGenerator(store)
+	routeGen.SetConformanceMode(store.conformance)
+	routeGen.SetReplayMode(store.replay)
+	generatedRoutes, err := routeGen.GenerateRoutes(registry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate routes from specs: %w", err)
+	}
+	if len(generatedRoutes) == 0 {
+		log.Printf("Warning: No routes generated from specifications")
+		return empty, nil
+	}
+	log.Printf("Successfully generated %d route(s) from specifications", len(generatedRoutes))
+	router := routes.NewHandler(generatedRoutes, nil)
+	return &specRoutes{handler: router, router: router, count: len(generatedRoutes)}, nil
+}
+
+// reloader serves the spec-driven routes and swaps in a new config and new specs while the
+// server runs. Requests in flight finish on the routes they started on.
+type reloader struct {
+	mu       sync.Mutex // serializes reloads
+	store    *Store
+	specsDir string
+	specs    atomic.Pointer[specRoutes]
+}
+
+func newReloader(store *Store, specsDir string, initial *specRoutes) *reloader {
+	rl := &reloader{store: store, specsDir: specsDir}
+	rl.specs.Store(initial)
+	return rl
+}
+
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rl.specs.Load().handler.ServeHTTP(w, r)
+}
+
+// operationID returns the operationId of the route a request matches, hardcoded or spec-driven,
+// if any
+func (rl *reloader) operationID(r *http.Request) string {
+	if route := fallbackARMRoute(r); route != nil {
+		return route.operationID
+	}
+	if route, _ := rl.match(r); route != nil {
+		return route.OperationID
+	}
+	return ""
+}
+
+// match returns the spec-driven route a request matches, if any, with its path parameters
+func (rl *reloader) match(r *http.Request) (*routes.Route, map[string]string) {
+	router := rl.specs.Load().router
+	if router == nil {
+		return nil, nil
+	}
+	return router.Match(r)
+}
+
+// reload loads the config file and the specs and swaps both in. When either fails to load,
+// neither is swapped and the error names every problem found. With a state directory the
+// current mock data is kept, so that watch does not save the config's data over it.
+func (rl *reloader) reload() (*specRoutes, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	var errs []error
+	next, err := rl.store.readConfig()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("config %s: %w", rl.store.configPath, err))
+	}
+	loaded, err := loadSpecRoutes(rl.store, rl.specsDir)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("specs %s: %w", rl.specsDir, err))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	rl.store.mu.Lock()
+	defer rl.store.mu.Unlock()
+	_, inMemory := next.stateBackend.(*memoryState)
+	if !inMemory {
+		// With a state directory the data created at runtime is kept, as a restart keeps it:
+		// only the settings the data does not hold come from the reloaded config
+		current, err := rl.store.encodeStateLocked()
+		if err == nil {
+			err = next.applyState(current)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("keep saved state: %w", err)
+		}
+	}
+	blobs := rl.store.blobAccounts
+	rl.store.swapData(next)
+	if _, blobsInMemory := next.blobBackend.(memoryBlobs); !inMemory && blobsInMemory {
+		rl.store.blobAccounts = blobs
+	}
+	rl.specs.Store(loaded)
+	return loaded, nil
+}
+
+// watch reloads whenever the config file or a file in the specs directory changes, checking
+// every interval until stop is closed. Failed reloads are logged and keep the previous config
+// and specs; the next change is tried again.
+func (rl *reloader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := rl.fingerprint()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		current := rl.fingerprint()
+		if current == last {
+			continue
+		}
+		last = current
+		log.Printf("Config or specs changed, reloading")
+		if _, err := rl.reload(); err != nil {
+			log.Printf("Reload failed, still serving the previous config and specs: %v", err)
+			continue
+		}
+		if err := rl.store.persist(); err != nil {
+			log.Printf("Failed to save mock data: %v", err)
+		}
+	}
+}
+
+// fingerprint summarizes the size and modification time of the config file and every spec file
+func (rl *reloader) fingerprint() string {
+	var b strings.Builder
+	stamp := func(path string, info fs.FileInfo) {
+		fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	if info, err := os.Stat(rl.store.configPath); err == nil {
+		stamp(rl.store.configPath, info)
+	}
+	_ = filepath.WalkDir(rl.specsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			stamp(path, info)
+		}
+		return nil
+	})
+	return b.String()
+}
+
+// serveReload handles POST /mock/azure/admin/reload
+func serveReload(w http.ResponseWriter, r *http.Request, rl *reloader) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	loaded, err := rl.reload()
+	if err != nil {
+		log.Printf("Reload failed, still serving the previous config and specs: %v", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"message": "Reload failed; the previous config and specs are still served", "error": err.Error(), "status": "error"}); err != nil {
+			log.Printf("Failed to encode JSON response: %v", err)
+		}
+		return
+	}
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"message": "Config and specs reloaded successfully", "routes": loaded.count, "status": "success"}); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
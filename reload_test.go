@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reloadConfig copies config.yaml.example to a temporary file the test can rewrite
+func reloadConfig(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile("config.yaml.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// renameResourceGroup rewrites a config so that rg-dev is called rg-reloaded
+func renameResourceGroup(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(string(data), "rg-dev", "rg-reloaded")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func postAdmin(t *testing.T, mux http.Handler, path string) (int, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON response from %s: %v: %s", path, err, w.Body.String())
+	}
+	return w.Code, body
+}
+
+func TestReload(t *testing.T) {
+	t.Run("admin reload swaps in the new config", func(t *testing.T) {
+		configPath := reloadConfig(t)
+		store := &Store{configPath: configPath}
+		store.init()
+		mux := newMux(store, "mockzure-specs")
+
+		renameResourceGroup(t, configPath)
+		code, body := postAdmin(t, mux, "/mock/azure/admin/reload")
+		if code != http.StatusOK || body["status"] != "success" {
+			t.Fatalf("expected the reload to succeed, got %d %v", code, body)
+		}
+		if routes, _ := body["routes"].(float64); routes == 0 {
+			t.Errorf("expected the reload to report the generated routes, got %v", body)
+		}
+		if code, body := armRequest(t, mux, "GET", testSubscriptionPath+"/resourcegroups/rg-reloaded", ""); code != http.StatusOK {
+			t.Errorf("expected the reloaded resource group, got %d %v", code, body)
+		}
+		if code, _ := armRequest(t, mux, "GET", testSubscriptionPath+"/resourcegroups/rg-dev", ""); code != http.StatusNotFound {
+			t.Errorf("expected the old resource group to be gone, got %d", code)
+		}
+	})
+
+	t.Run("invalid config is reported and the previous one kept", func(t *testing.T) {
+		configPath := reloadConfig(t)
+		store := &Store{configPath: configPath}
+		store.init()
+		mux := newMux(store, "mockzure-specs")
+
+		if err := os.WriteFile(configPath, []byte("resourceGroups: [\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{"/mock/azure/admin/reload", "/mock/azure/data/reset"} {
+			code, body := postAdmin(t, mux, path)
+			if code != http.StatusUnprocessableEntity || body["status"] != "error" {
+				t.Errorf("%s: expected 422, got %d %v", path, code, body)
+			}
+			if msg, _ := body["error"].(string); !strings.Contains(msg, "parse yaml") {
+				t.Errorf("%s: expected the parse error in the response, got %v", path, body)
+			}
+		}
+		if code, body := armRequest(t, mux, "GET", testSubscriptionPath+"/resourcegroups/rg-dev", ""); code != http.StatusOK {
+			t.Errorf("expected the previous config to be served, got %d %v", code, body)
+		}
+	})
+
+	t.Run("invalid specs are reported and neither is swapped", func(t *testing.T) {
+		configPath := reloadConfig(t)
+		specsDir := conformanceSpec(t, "string")
+		store := &Store{configPath: configPath}
+		store.init()
+		mux := newMux(store, specsDir)
+
+		renameResourceGroup(t, configPath)
+		if err := os.WriteFile(filepath.Join(specsDir, "arm", "arm-resources.json"), []byte("{not json"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		code, body := postAdmin(t, mux, "/mock/azure/admin/reload")
+		if msg, _ := body["error"].(string); code != http.StatusUnprocessableEntity || !strings.Contains(msg, "arm-resources.json") {
+			t.Fatalf("expected the broken spec to be reported, got %d %v", code, body)
+		}
+		if code, body := armRequest(t, mux, "GET", testSubscriptionPath+"/resourcegroups/rg-dev", ""); code != http.StatusOK {
+			t.Errorf("expected the previous config and routes to be served, got %d %v", code, body)
+		}
+	})
+
+	t.Run("watch reloads changed files", func(t *testing.T) {
+		configPath := reloadConfig(t)
+		store := &Store{configPath: configPath}
+		store.init()
+		mux, reloads := newServer(store, "mockzure-specs")
+		stop := make(chan struct{})
+		defer close(stop)
+		go reloads.watch(10*time.Millisecond, stop)
+
+		// Let the watcher see the original files first; the rename also changes the size, in
+		// case the file system's timestamps are coarse
+		time.Sleep(50 * time.Millisecond)
+		renameResourceGroup(t, configPath)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			code, _ := armRequest(t, mux, "GET", testSubscriptionPath+"/resourcegroups/rg-reloaded", "")
+			if code == http.StatusOK {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the watcher to reload the config, last status %d", code)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+}
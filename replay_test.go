@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// replaySpecs writes a spec whose operations point at x-ms-examples files, as the Azure REST API
+// specs lay them out
+func replaySpecs(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	spec := `{
+  "swagger": "2.0",
+  "info": {"title": "ResourceManagementClient", "version": "2021-04-01"},
+  "paths": {
+    "/subscriptions/{subscriptionId}/providers/Microsoft.Features/features/{featureName}": {
+      "get": {
+        "operationId": "Features_Get",
+        "parameters": [
+          {"name": "subscriptionId", "in": "path", "required": true, "type": "string"},
+          {"name": "featureName", "in": "path", "required": true, "type": "string"},
+          {"name": "api-version", "in": "query", "required": true, "type": "string"}
+        ],
+        "responses": {"200": {"description": "OK", "schema": {"type": "object"}}},
+        "x-ms-examples": {
+          "Get AHUB": {"$ref": "./examples/GetAhub.json"},
+          "Get preview feature": {"$ref": "./examples/GetPreview.json"}
+        }
+      }
+    },
+    "/subscriptions/{subscriptionId}/resourcegroups/{resourceGroupName}": {
+      "get": {
+        "operationId": "ResourceGroups_Get",
+        "parameters": [
+          {"name": "subscriptionId", "in": "path", "required": true, "type": "string"},
+          {"name": "resourceGroupName", "in": "path", "required": true, "type": "string"},
+          {"name": "api-version", "in": "query", "required": true, "type": "string"}
+        ],
+        "responses": {"200": {"description": "OK", "schema": {"type": "object"}}},
+        "x-ms-examples": {"Get a resource group": {"$ref": "./examples/GetResourceGroup.json"}}
+      }
+    }
+  }
+}`
+	examples := map[string]string{
+		"GetAhub.json": `{
+  "parameters": {"subscriptionId": "subid", "featureName": "AHUB", "api-version": "2021-04-01"},
+  "responses": {"200": {"body": {"id": "/subscriptions/subid/providers/Microsoft.Features/features/AHUB", "name": "AHUB", "properties": {"state": "Registered"}}}}
+}`,
+		"GetPreview.json": `{
+  "parameters": {"subscriptionId": "subid", "featureName": "preview", "api-version": "2021-04-01"},
+  "responses": {
+    "202": {"headers": {"Location": "https://management.azure.com/subscriptions/subid/providers/Microsoft.Features/features/preview?api-version=2021-04-01"}},
+    "200": {
+      "headers": {"Location": "https://management.azure.com/subscriptions/subid/providers/Microsoft.Features/features/preview?api-version=2021-04-01"},
+      "body": {"id": "/subscriptions/subid/providers/Microsoft.Features/features/preview", "name": "preview", "properties": {"state": "Pending"}}
+    }
+  }
+}`,
+		"GetResourceGroup.json": `{
+  "parameters": {"subscriptionId": "subid", "resourceGroupName": "my-resource-group", "api-version": "2021-04-01"},
+  "responses": {"200": {"body": {"id": "/subscriptions/subid/resourceGroups/my-resource-group", "name": "my-resource-group", "location": "eastus", "tags": {"recorded": "true"}}}}
+}`,
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "arm", "examples"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "arm", "arm-resources.json"), []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, example := range examples {
+		if err := os.WriteFile(filepath.Join(dir, "arm", "examples", name), []byte(example), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestExampleReplay(t *testing.T) {
+	specsDir := replaySpecs(t)
+	serve := func(t *testing.T, replay bool, path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		t.Helper()
+		store := &Store{configPath: "config.yaml.example"}
+		store.init()
+		store.replay = replay
+		mux := newMux(store, specsDir)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path+"?api-version=2021-04-01", nil))
+		var body map[string]interface{}
+		if w.Body.Len() > 0 {
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON response: %v: %s", err, w.Body.String())
+			}
+		}
+		return w, body
+	}
+
+	t.Run("unmapped operations replay the best-matching example", func(t *testing.T) {
+		w, body := serve(t, false, testSubscriptionPath+"/providers/Microsoft.Features/features/preview")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected the example's 200 rather than its 202, got %d %s", w.Code, w.Body.String())
+		}
+		if body["name"] != "preview" {
+			t.Errorf("expected the example matching featureName, got %v", body)
+		}
+		if want := testSubscriptionPath + "/providers/Microsoft.Features/features/preview"; body["id"] != want {
+			t.Errorf("expected the request's subscription in the id, got %v want %s", body["id"], want)
+		}
+		want := "http://example.com" + testSubscriptionPath + "/providers/Microsoft.Features/features/preview?api-version=2021-04-01"
+		if location := w.Header().Get("Location"); location != want {
+			t.Errorf("expected the Location header to point at Mockzure, got %q want %q", location, want)
+		}
+
+		// Names no example uses are substituted into the closest one
+		_, body = serve(t, false, testSubscriptionPath+"/providers/Microsoft.Features/features/other")
+		if body["name"] != "other" || body["id"] != testSubscriptionPath+"/providers/Microsoft.Features/features/other" {
+			t.Errorf("expected the first example with the request's names, got %v", body)
+		}
+	})
+
+	t.Run("mapped operations replay only in replay mode", func(t *testing.T) {
+		path := testSubscriptionPath + "/resourcegroups/rg-dev"
+		_, body := serve(t, false, path)
+		tags, _ := body["tags"].(map[string]interface{})
+		if body["name"] != "rg-dev" || tags["recorded"] != nil {
+			t.Errorf("expected the configured resource group, got %v", body)
+		}
+
+		w, body := serve(t, true, path)
+		tags, _ = body["tags"].(map[string]interface{})
+		if w.Code != http.StatusOK || tags["recorded"] != "true" {
+			t.Fatalf("expected the recorded example, got %d %v", w.Code, body)
+		}
+		if body["name"] != "rg-dev" || body["id"] != testSubscriptionPath+"/resourceGroups/rg-dev" {
+			t.Errorf("expected the request's names in the example, got %v", body)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// graphQuery posts a Resource Graph query and returns the status and decoded body
+func graphQuery(t *testing.T, mux http.Handler, query string, options map[string]interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	body := map[string]interface{}{"query": query}
+	if options != nil {
+		body["options"] = options
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("encode query: %v", err)
+	}
+	return armRequest(t, mux, "POST", "/providers/Microsoft.ResourceGraph/resources?api-version=2021-03-01", string(data))
+}
+
+// graphRows returns the objectArray rows of a Resource Graph response
+func graphRows(body map[string]interface{}) []map[string]interface{} {
+	rows := []map[string]interface{}{}
+	data, _ := body["data"].([]interface{})
+	for _, item := range data {
+		if row, ok := item.(map[string]interface{}); ok {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func TestResourceGraphQuery(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	t.Run("where and project", func(t *testing.T) {
+		code, body := graphQuery(t, mux, "Resources | where type =~ 'Microsoft.Compute/virtualMachines' | where tags.Environment == 'Production' | project name, location, env = tags.Environment", nil)
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %v", code, body)
+		}
+		rows := graphRows(body)
+		if len(rows) != 1 || rows[0]["name"] != "vm-web-prod-01" || rows[0]["location"] != "westus" || rows[0]["env"] != "Production" {
+			t.Fatalf("unexpected rows: %v", rows)
+		}
+		if len(rows[0]) != 3 {
+			t.Errorf("expected only the projected columns, got %v", rows[0])
+		}
+	})
+
+	t.Run("in, contains and or", func(t *testing.T) {
+		_, body := graphQuery(t, mux, "Resources | where name in ('vm-web-01', 'vm-api-01') and (name contains 'WEB' or resourceGroup == 'rg-prod') | project name", nil)
+		rows := graphRows(body)
+		if len(rows) != 1 || rows[0]["name"] != "vm-web-01" {
+			t.Fatalf("unexpected rows: %v", rows)
+		}
+	})
+
+	t.Run("summarize count by", func(t *testing.T) {
+		_, body := graphQuery(t, mux, "Resources | where type =~ 'microsoft.compute/virtualmachines' | summarize count() by resourceGroup | order by resourceGroup asc", nil)
+		rows := graphRows(body)
+		if len(rows) != 2 || rows[0]["resourceGroup"] != "rg-dev" || rows[0]["count_"] != float64(2) || rows[1]["count_"] != float64(1) {
+			t.Fatalf("unexpected rows: %v", rows)
+		}
+	})
+
+	t.Run("extend, order and take", func(t *testing.T) {
+		_, body := graphQuery(t, mux, "Resources | where type =~ 'microsoft.compute/virtualmachines' | extend upper = toupper(name) | order by name desc | take 2 | project upper", nil)
+		rows := graphRows(body)
+		if len(rows) != 2 || rows[0]["upper"] != "VM-WEB-PROD-01" || rows[1]["upper"] != "VM-WEB-01" {
+			t.Fatalf("unexpected rows: %v", rows)
+		}
+	})
+
+	t.Run("skip token paging", func(t *testing.T) {
+		query := "Resources | where type =~ 'microsoft.compute/virtualmachines' | order by name asc | project name"
+		_, first := graphQuery(t, mux, query, map[string]interface{}{"$top": 2})
+		if first["totalRecords"] != float64(3) || first["count"] != float64(2) {
+			t.Fatalf("unexpected first page: %v", first)
+		}
+		token, _ := first["$skipToken"].(string)
+		if token == "" {
+			t.Fatalf("expected a $skipToken on the first page: %v", first)
+		}
+		_, second := graphQuery(t, mux, query, map[string]interface{}{"$top": 2, "$skipToken": token})
+		rows := graphRows(second)
+		if len(rows) != 1 || rows[0]["name"] != "vm-web-prod-01" {
+			t.Fatalf("unexpected second page: %v", rows)
+		}
+		if _, ok := second["$skipToken"]; ok {
+			t.Errorf("expected no $skipToken on the last page")
+		}
+	})
+
+	t.Run("resource containers", func(t *testing.T) {
+		_, body := graphQuery(t, mux, "ResourceContainers | where type == 'microsoft.resources/subscriptions/resourcegroups' | project name, location", nil)
+		rows := graphRows(body)
+		if len(rows) != 2 || rows[0]["name"] != "rg-dev" || rows[0]["location"] != "eastus" {
+			t.Fatalf("unexpected rows: %v", rows)
+		}
+		_, body = graphQuery(t, mux, "ResourceContainers | where type == 'microsoft.resources/subscriptions' | project subscriptionId", nil)
+		if rows := graphRows(body); len(rows) == 0 {
+			t.Fatalf("expected subscriptions in ResourceContainers: %v", body)
+		}
+	})
+
+	t.Run("table result format", func(t *testing.T) {
+		_, body := graphQuery(t, mux, "Resources | where type =~ 'microsoft.compute/virtualmachines' | summarize count()", map[string]interface{}{"resultFormat": "table"})
+		data, _ := body["data"].(map[string]interface{})
+		rows, _ := data["rows"].([]interface{})
+		if len(rows) != 1 {
+			t.Fatalf("unexpected table data: %v", body)
+		}
+		if row, _ := rows[0].([]interface{}); len(row) != 1 || row[0] != float64(3) {
+			t.Fatalf("unexpected table row: %v", rows[0])
+		}
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		for _, query := range []string{"Resources | where", "Nope | take 1", "Resources | join Resources", "Resources | where name == 'x"} {
+			code, body := graphQuery(t, mux, query, nil)
+			if code != http.StatusBadRequest || armErrorCode(body) != "BadRequest" {
+				t.Errorf("%q: expected 400 BadRequest, got %d %v", query, code, body)
+			}
+		}
+	})
+}
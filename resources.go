@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+)
+
+// GenericResource is an ARM resource of a type Mockzure does not model explicitly.
+// Its body is kept as sent, with id, name and type computed from the request path.
+type GenericResource struct {
+	ID               string                 `json:"id" yaml:"id"`
+	Name             string                 `json:"name,omitempty" yaml:"name,omitempty"`
+	Type             string                 `json:"type,omitempty" yaml:"type,omitempty"`
+	Location         string                 `json:"location,omitempty" yaml:"location,omitempty"`
+	Kind             string                 `json:"kind,omitempty" yaml:"kind,omitempty"`
+	ManagedBy        string                 `json:"managedBy,omitempty" yaml:"managedBy,omitempty"`
+	Tags             map[string]string      `json:"tags,omitempty" yaml:"tags,omitempty"`
+	SKU              map[string]interface{} `json:"sku,omitempty" yaml:"sku,omitempty"`
+	Plan             map[string]interface{} `json:"plan,omitempty" yaml:"plan,omitempty"`
+	Identity         map[string]interface{} `json:"identity,omitempty" yaml:"identity,omitempty"`
+	Zones            []string               `json:"zones,omitempty" yaml:"zones,omitempty"`
+	ExtendedLocation map[string]interface{} `json:"extendedLocation,omitempty" yaml:"extendedLocation,omitempty"`
+	Properties       map[string]interface{} `json:"properties,omitempty" yaml:"properties,omitempty"`
+	CreatedTime      time.Time              `json:"createdTime,omitempty" yaml:"createdTime,omitempty"`
+	ChangedTime      time.Time              `json:"changedTime,omitempty" yaml:"changedTime,omitempty"`
+}
+
+// GetResources returns generic resources as interface slice for mappers.
+// Each entry is a copy, so mappers may modify it freely.
+func (s *Store) GetResources() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]interface{}, 0, len(s.resources))
+	for _, r := range s.resources {
+		var m map[string]interface{}
+		data, err := json.Marshal(r)
+		if err == nil {
+			err = json.Unmarshal(data, &m)
+		}
+		if err != nil {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// PutResource creates or replaces a generic resource, keyed by its ID
+func (s *Store) PutResource(resource map[string]interface{}) error {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("encode resource: %w", err)
+	}
+	var r GenericResource
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("decode resource: %w", err)
+	}
+	if r.ID == "" {
+		return fmt.Errorf("resource id is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	now := time.Now().UTC()
+	r.ChangedTime = now
+	for i, existing := range s.resources {
+		if strings.EqualFold(existing.ID, r.ID) {
+			r.CreatedTime = existing.CreatedTime
+			s.resources[i] = &r
+			return nil
+		}
+	}
+	r.CreatedTime = now
+	s.resources = append(s.resources, &r)
+	return nil
+}
+
+// PutVM creates or updates a VM written through the ARM API, such as by a template deployment
+func (s *Store) PutVM(vm map[string]interface{}) error {
+	id, _ := vm["id"].(string)
+	name, _ := vm["name"].(string)
+	if id == "" || name == "" {
+		return fmt.Errorf("vm id and name are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	for _, existing := range s.vms {
+		if strings.EqualFold(existing.ID, id) {
+			return updateMockVM(existing, vm)
+		}
+	}
+	created, err := newMockVM(vm)
+	if err != nil {
+		return err
+	}
+	s.vms = append(s.vms, created)
+	return nil
+}
+
+// newMockVM builds a VM from its mapper view. New VMs are running unless the view says otherwise.
+func newMockVM(vm map[string]interface{}) (*MockVM, error) {
+	str := func(key string) string {
+		v, _ := vm[key].(string)
+		return v
+	}
+	created := &MockVM{
+		ID:                str("id"),
+		Name:              str("name"),
+		ResourceGroup:     str("resourceGroup"),
+		Location:          str("location"),
+		VMSize:            str("vmSize"),
+		OSType:            str("osType"),
+		ProvisioningState: "Succeeded",
+		PowerState:        "VM running",
+		Status:            "running",
+		LastUpdated:       time.Now(),
+		Tags:              tagMap(vm["tags"]),
+	}
+	if status := str("status"); status != "" {
+		created.Status, created.PowerState = status, str("powerState")
+	}
+	if err := setVMProfile(created, vm); err != nil {
+		return nil, fmt.Errorf("decode vm profile: %w", err)
+	}
+	return created, nil
+}
+
+// updateMockVM applies the mapper view of a VM to a stored VM, including power state changes
+func updateMockVM(existing *MockVM, vm map[string]interface{}) error {
+	str := func(key string) string {
+		v, _ := vm[key].(string)
+		return v
+	}
+	if size := str("vmSize"); size != "" {
+		existing.VMSize = size
+	}
+	if osType := str("osType"); osType != "" {
+		existing.OSType = osType
+	}
+	if status := str("status"); status != "" {
+		existing.Status, existing.PowerState = status, str("powerState")
+	}
+	existing.Tags = tagMap(vm["tags"])
+	existing.LastUpdated = time.Now()
+	if err := setVMProfile(existing, vm); err != nil {
+		return fmt.Errorf("decode vm profile: %w", err)
+	}
+	return nil
+}
+
+// DeleteResource deletes a VM or generic resource, with its nested resources.
+// It reports whether the resource existed.
+func (s *Store) DeleteResource(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	found := false
+	keptVMs := s.vms[:0]
+	for _, vm := range s.vms {
+		if strings.EqualFold(vm.ID, id) {
+			found = true
+			continue
+		}
+		keptVMs = append(keptVMs, vm)
+	}
+	s.vms = keptVMs
+	s.deleteScaleSetVMs(id)
+	s.deleteBlobData(id)
+	kept := s.resources[:0]
+	for _, r := range s.resources {
+		if strings.EqualFold(r.ID, id) {
+			found = true
+			continue
+		}
+		if hasIDPrefix(r.ID, id) {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	s.resources = kept
+	return found
+}
+
+// PutResourceGroup creates or updates a resource group
+func (s *Store) PutResourceGroup(resourceGroup map[string]interface{}) error {
+	name, _ := resourceGroup["name"].(string)
+	subscriptionID, _ := resourceGroup["subscriptionId"].(string)
+	if name == "" || subscriptionID == "" {
+		return fmt.Errorf("resource group name and subscription are required")
+	}
+	location, _ := resourceGroup["location"].(string)
+	tags := tagMap(resourceGroup["tags"])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	for _, rg := range s.resourceGroups {
+		if strings.EqualFold(rg.Name, name) && strings.EqualFold(rg.SubscriptionID, subscriptionID) {
+			if location != "" {
+				rg.Location = location
+			}
+			rg.Tags = tags
+			return nil
+		}
+	}
+	s.resourceGroups = append(s.resourceGroups, &ResourceGroup{
+		ID:             fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionID, name),
+		Name:           name,
+		SubscriptionID: subscriptionID,
+		Location:       location,
+		Tags:           tags,
+	})
+	return nil
+}
+
+// DeleteResourceGroup deletes a resource group with its VMs, generic resources and deployments.
+// It reports whether the resource group existed.
+func (s *Store) DeleteResourceGroup(subscriptionID, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	var deleted *ResourceGroup
+	keptGroups := s.resourceGroups[:0]
+	for _, rg := range s.resourceGroups {
+		if deleted == nil && strings.EqualFold(rg.Name, name) && strings.EqualFold(rg.SubscriptionID, subscriptionID) {
+			deleted = rg
+			continue
+		}
+		keptGroups = append(keptGroups, rg)
+	}
+	s.resourceGroups = keptGroups
+	if deleted == nil {
+		return false
+	}
+
+	keptVMs := s.vms[:0]
+	for _, vm := range s.vms {
+		if strings.EqualFold(vm.ResourceGroup, deleted.Name) && strings.EqualFold(s.vmSubscriptionID(vm), subscriptionID) {
+			continue
+		}
+		keptVMs = append(keptVMs, vm)
+	}
+	s.vms = keptVMs
+	s.deleteScaleSetVMs(deleted.ID)
+	s.deleteBlobData(deleted.ID)
+
+	keptResources := s.resources[:0]
+	for _, r := range s.resources {
+		if !hasIDPrefix(r.ID, deleted.ID) {
+			keptResources = append(keptResources, r)
+		}
+	}
+	s.resources = keptResources
+
+	keptDeployments := s.deployments[:0]
+	for _, d := range s.deployments {
+		if !hasIDPrefix(d.ID, deleted.ID) {
+			keptDeployments = append(keptDeployments, d)
+		}
+	}
+	s.deployments = keptDeployments
+	return true
+}
+
+// SetTags replaces the tags of the subscription, resource group, VM or generic resource with the given ID
+func (s *Store) SetTags(id string, tags map[string]string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	for _, sub := range s.subscriptions {
+		if strings.EqualFold("/subscriptions/"+sub.ID, id) {
+			sub.Tags = tags
+			return true
+		}
+	}
+	for _, rg := range s.resourceGroups {
+		if strings.EqualFold(rg.ID, id) {
+			rg.Tags = tags
+			return true
+		}
+	}
+	for _, vm := range s.vms {
+		if strings.EqualFold(vm.ID, id) {
+			vm.Tags = tags
+			return true
+		}
+	}
+	for _, r := range s.resources {
+		if strings.EqualFold(r.ID, id) {
+			r.Tags = tags
+			r.ChangedTime = time.Now().UTC()
+			return true
+		}
+	}
+	return false
+}
+
+// MoveResource moves a VM or generic resource, with its nested resources, to another
+// resource group. It reports whether the resource was found.
+func (s *Store) MoveResource(id, targetSubscriptionID, targetResourceGroup string) bool {
+	rid, err := mappers.ParseResourceID(id)
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	// Use the target group's own casing for its name
+	for _, rg := range s.resourceGroups {
+		if strings.EqualFold(rg.Name, targetResourceGroup) && strings.EqualFold(rg.SubscriptionID, targetSubscriptionID) {
+			targetResourceGroup = rg.Name
+		}
+	}
+	rid.SubscriptionID, rid.ResourceGroup = targetSubscriptionID, targetResourceGroup
+	newID := rid.String()
+
+	found := false
+	for _, vm := range s.vms {
+		if strings.EqualFold(vm.ID, id) {
+			vm.ID = newID
+			vm.ResourceGroup = targetResourceGroup
+			vm.LastUpdated = time.Now()
+			found = true
+		}
+	}
+	for _, r := range s.resources {
+		if strings.EqualFold(r.ID, id) || hasIDPrefix(r.ID, id) {
+			r.ID = newID + r.ID[len(id):]
+			r.ChangedTime = time.Now().UTC()
+			found = true
+		}
+	}
+	for _, vm := range s.scaleSetVMs {
+		if hasIDPrefix(vm.ID, id) {
+			vm.ID = newID + vm.ID[len(id):]
+			vm.ScaleSetID = newID
+			vm.ResourceGroup = targetResourceGroup
+			vm.LastUpdated = time.Now()
+		}
+	}
+	return found
+}
+
+// normalizeResources computes the name and type of configured resources from their IDs
+func (s *Store) normalizeResources() {
+	for _, r := range s.resources {
+		rid, err := mappers.ParseResourceID(r.ID)
+		if err != nil {
+			continue
+		}
+		if r.Name == "" {
+			r.Name = rid.Name()
+		}
+		if r.Type == "" {
+			r.Type = rid.Type()
+		}
+	}
+}
+
+// hasIDPrefix reports whether id lies under the parent resource ID
+func hasIDPrefix(id, parent string) bool {
+	return len(id) > len(parent) && strings.EqualFold(id[:len(parent)+1], parent+"/")
+}
+
+// tagMap converts decoded JSON tags to a string map
+func tagMap(v interface{}) map[string]string {
+	tags := map[string]string{}
+	switch t := v.(type) {
+	case map[string]string:
+		for k, val := range t {
+			tags[k] = val
+		}
+	case map[string]interface{}:
+		for k, val := range t {
+			tags[k] = fmt.Sprint(val)
+		}
+	}
+	return tags
+}
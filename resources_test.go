@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+)
+
+const testSubscriptionPath = "/subscriptions/12345678-1234-1234-1234-123456789012"
+
+// armRequest sends an ARM request to the mux and decodes any JSON response body
+func armRequest(t *testing.T, mux http.Handler, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	if !strings.Contains(path, "api-version") {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + "api-version=2021-04-01"
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var decoded map[string]interface{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code, decoded
+}
+
+// armErrorCode returns the error code of an ARM error response
+func armErrorCode(body map[string]interface{}) string {
+	errBody, _ := body["error"].(map[string]interface{})
+	code, _ := errBody["code"].(string)
+	return code
+}
+
+func TestGenericResourceLifecycle(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	accountID := testSubscriptionPath + "/resourceGroups/rg-dev/providers/Microsoft.Storage/storageAccounts/stdev01"
+	payload := `{"location":"eastus","kind":"StorageV2","sku":{"name":"Standard_LRS"},"tags":{"env":"dev"},"properties":{"minimumTlsVersion":"TLS1_2"}}`
+
+	code, body := armRequest(t, mux, "PUT", accountID, payload)
+	if code != http.StatusCreated {
+		t.Fatalf("expected 201 on create, got %d: %v", code, body)
+	}
+	if body["id"] != accountID || body["name"] != "stdev01" || body["type"] != "Microsoft.Storage/storageAccounts" {
+		t.Errorf("expected computed id/name/type, got %v", body)
+	}
+
+	code, _ = armRequest(t, mux, "PUT", accountID, payload)
+	if code != http.StatusOK {
+		t.Errorf("expected 200 on update, got %d", code)
+	}
+
+	// The provider-path route family addresses the same resource
+	code, body = armRequest(t, mux, "GET", testSubscriptionPath+"/resourcegroups/rg-dev/providers/Microsoft.Storage/storageAccounts/stdev01", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200 on get, got %d: %v", code, body)
+	}
+	properties := body["properties"].(map[string]interface{})
+	if properties["minimumTlsVersion"] != "TLS1_2" || properties["provisioningState"] != "Succeeded" {
+		t.Errorf("unexpected properties: %v", properties)
+	}
+	if body["kind"] != "StorageV2" {
+		t.Errorf("expected kind to round-trip, got %v", body["kind"])
+	}
+
+	if code, _ := armRequest(t, mux, "HEAD", accountID, ""); code != http.StatusNoContent {
+		t.Errorf("expected 204 on existence check, got %d", code)
+	}
+
+	code, body = armRequest(t, mux, "PATCH", accountID, `{"tags":{"env":"test","owner":"qa"}}`)
+	if code != http.StatusOK || body["tags"].(map[string]interface{})["owner"] != "qa" {
+		t.Errorf("expected tags to be updated, got %d %v", code, body)
+	}
+	if body["sku"] == nil {
+		t.Errorf("expected PATCH to keep fields it does not name, got %v", body)
+	}
+
+	code, body = armRequest(t, mux, "PATCH", accountID+"/providers/Microsoft.Resources/tags/default", `{"operation":"Merge","properties":{"tags":{"team":"core"}}}`)
+	tags := body["properties"].(map[string]interface{})["tags"].(map[string]interface{})
+	if code != http.StatusOK || tags["team"] != "core" || tags["owner"] != "qa" {
+		t.Errorf("expected merged tags, got %d %v", code, body)
+	}
+
+	code, _ = armRequest(t, mux, "DELETE", accountID, "")
+	if code != http.StatusOK {
+		t.Errorf("expected 200 on delete, got %d", code)
+	}
+	code, body = armRequest(t, mux, "GET", accountID, "")
+	if code != http.StatusNotFound || armErrorCode(body) != "ResourceNotFound" {
+		t.Errorf("expected ResourceNotFound after delete, got %d %v", code, body)
+	}
+	if code, _ := armRequest(t, mux, "DELETE", accountID, ""); code != http.StatusNoContent {
+		t.Errorf("expected 204 deleting a missing resource, got %d", code)
+	}
+}
+
+func TestGenericResourceValidation(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	code, body := armRequest(t, mux, "PUT", testSubscriptionPath+"/resourceGroups/rg-missing/providers/Microsoft.KeyVault/vaults/kv1", `{"location":"eastus"}`)
+	if code != http.StatusNotFound || armErrorCode(body) != "ResourceGroupNotFound" {
+		t.Errorf("expected ResourceGroupNotFound, got %d %v", code, body)
+	}
+
+	code, body = armRequest(t, mux, "PUT", testSubscriptionPath+"/resourceGroups/rg-dev/providers/Microsoft.Network/virtualNetworks/vnet1/subnets/default", `{"properties":{}}`)
+	if code != http.StatusNotFound || armErrorCode(body) != "ParentResourceNotFound" {
+		t.Errorf("expected ParentResourceNotFound, got %d %v", code, body)
+	}
+
+	code, body = armRequest(t, mux, "PUT", testSubscriptionPath+"/resourceGroups/rg-dev/providers/Microsoft.KeyVault/vaults/kv1", `{"location":`)
+	if code != http.StatusBadRequest || armErrorCode(body) != "InvalidRequestContent" {
+		t.Errorf("expected InvalidRequestContent, got %d %v", code, body)
+	}
+
+	// VM writes go through the compute provider, which checks the size and never shadows a VM
+	vmPath := testSubscriptionPath + "/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines/vm-web-01"
+	code, body = armRequest(t, mux, "PUT", testSubscriptionPath+"/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines/vm-bogus", `{"location":"eastus","properties":{"hardwareProfile":{"vmSize":"Standard_Bogus"}}}`)
+	if code != http.StatusBadRequest || armErrorCode(body) != "InvalidParameter" {
+		t.Errorf("expected an invalid size to be rejected, got %d %v", code, body)
+	}
+	newVMPath := testSubscriptionPath + "/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines/vm-generic"
+	if code, body := armRequest(t, mux, "PUT", newVMPath, `{"location":"eastus","properties":{"hardwareProfile":{"vmSize":"Standard_B1s"}}}`); code != http.StatusCreated {
+		t.Errorf("expected the VM to be created, got %d %v", code, body)
+	}
+	if code, body := armRequest(t, mux, "GET", newVMPath, ""); code != http.StatusOK {
+		t.Errorf("expected the created VM, got %d %v", code, body)
+	}
+	code, body = armRequest(t, mux, "PUT", vmPath, `{"location":"eastus","properties":{"hardwareProfile":{"vmSize":"Standard_B2ms"}}}`)
+	if code != http.StatusOK || body["id"] != vmPath {
+		t.Errorf("expected the existing VM to be updated, got %d %v", code, body)
+	}
+	if _, vm := armRequest(t, mux, "GET", vmPath, ""); vm["properties"].(map[string]interface{})["hardwareProfile"].(map[string]interface{})["vmSize"] != "Standard_B2ms" {
+		t.Errorf("expected the new size, got %v", vm)
+	}
+	_, list := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-dev/resources", "")
+	count := 0
+	for _, r := range list["value"].([]interface{}) {
+		if strings.EqualFold(r.(map[string]interface{})["id"].(string), vmPath) {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected vm-web-01 to be listed once, got %d", count)
+	}
+}
+
+func TestResourceGroupLifecycleCascades(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	rgPath := testSubscriptionPath + "/resourcegroups/rg-iac"
+	code, body := armRequest(t, mux, "PUT", rgPath, `{"location":"westeurope","tags":{"owner":"iac"}}`)
+	if code != http.StatusCreated || body["type"] != "Microsoft.Resources/resourceGroups" {
+		t.Fatalf("expected resource group to be created, got %d %v", code, body)
+	}
+	code, body = armRequest(t, mux, "PUT", rgPath, `{"location":"eastus"}`)
+	if code != http.StatusConflict || armErrorCode(body) != "InvalidResourceGroupLocation" {
+		t.Errorf("expected InvalidResourceGroupLocation, got %d %v", code, body)
+	}
+
+	vnetID := testSubscriptionPath + "/resourceGroups/rg-iac/providers/Microsoft.Network/virtualNetworks/vnet1"
+	if code, body := armRequest(t, mux, "PUT", vnetID, `{"location":"westeurope","properties":{"addressSpace":{"addressPrefixes":["10.0.0.0/16"]}}}`); code != http.StatusCreated {
+		t.Fatalf("expected vnet to be created, got %d %v", code, body)
+	}
+	if code, body := armRequest(t, mux, "PUT", vnetID+"/subnets/default", `{"properties":{"addressPrefix":"10.0.0.0/24"}}`); code != http.StatusCreated || body["name"] != "default" || body["type"] != "Microsoft.Network/virtualNetworks/subnets" {
+		t.Fatalf("expected subnet to be created, got %d %v", code, body)
+	}
+
+	if code, _ := armRequest(t, mux, "DELETE", rgPath, ""); code != http.StatusOK {
+		t.Fatalf("expected 200 deleting resource group, got %d", code)
+	}
+	if code, _ := armRequest(t, mux, "HEAD", rgPath, ""); code != http.StatusNotFound {
+		t.Errorf("expected resource group to be gone, got %d", code)
+	}
+	for _, r := range store.GetResources() {
+		if id := r.(map[string]interface{})["id"].(string); strings.Contains(strings.ToLower(id), "/resourcegroups/rg-iac/") {
+			t.Errorf("expected resources in the group to be deleted, got %s", id)
+		}
+	}
+}
+
+func TestListResourcesWithFilter(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	for _, r := range []struct{ id, body string }{
+		{"/resourceGroups/rg-dev/providers/Microsoft.Storage/storageAccounts/stdev01", `{"location":"eastus","tags":{"env":"dev"}}`},
+		{"/resourceGroups/rg-dev/providers/Microsoft.KeyVault/vaults/kv-dev", `{"location":"eastus","tags":{"env":"dev"}}`},
+		{"/resourceGroups/rg-prod/providers/Microsoft.Storage/storageAccounts/stprod01", `{"location":"westus","tags":{"env":"prod"}}`},
+	} {
+		if code, body := armRequest(t, mux, "PUT", testSubscriptionPath+r.id, r.body); code != http.StatusCreated {
+			t.Fatalf("PUT %s: got %d %v", r.id, code, body)
+		}
+	}
+
+	names := func(body map[string]interface{}) []string {
+		result := []string{}
+		for _, v := range body["value"].([]interface{}) {
+			result = append(result, v.(map[string]interface{})["name"].(string))
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		filter string
+		want   string
+	}{
+		{"all in subscription", "/resources", "", "allow-ssh,kv-dev,nsg-web,snet-web,stdev01,stprod01,vm-api-01,vm-web-01,vm-web-01-nic,vm-web-01-pip,vm-web-prod-01,vnet-web"},
+		{"by resource group", "/resourceGroups/rg-dev/resources", "", "allow-ssh,kv-dev,nsg-web,snet-web,stdev01,vm-api-01,vm-web-01,vm-web-01-nic,vm-web-01-pip,vnet-web"},
+		{"by type", "/resources", "resourceType eq 'Microsoft.Storage/storageAccounts'", "stdev01,stprod01"},
+		{"by tag", "/resources", "tagName eq 'env' and tagValue eq 'prod'", "stprod01"},
+		{"by name substring", "/resources", "substringof('dev', name)", "kv-dev,stdev01"},
+		{"by location", "/resourceGroups/rg-dev/resources", "location eq 'eastus' and resourceType eq 'Microsoft.KeyVault/vaults'", "kv-dev"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := testSubscriptionPath + tt.path
+			if tt.filter != "" {
+				path += "?$filter=" + url.QueryEscape(tt.filter)
+			}
+			code, body := armRequest(t, mux, "GET", path, "")
+			if code != http.StatusOK {
+				t.Fatalf("expected 200, got %d %v", code, body)
+			}
+			if got := strings.Join(names(body), ","); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	code, body := armRequest(t, mux, "GET", testSubscriptionPath+"/resources?$filter="+url.QueryEscape("properties/foo eq 'bar'"), "")
+	if code != http.StatusBadRequest || armErrorCode(body) != "InvalidFilterInQueryString" {
+		t.Errorf("expected InvalidFilterInQueryString, got %d %v", code, body)
+	}
+}
+
+// pollOperation follows the Location header of a 202 response and returns the operation result
+func pollOperation(t *testing.T, mux http.Handler, w *httptest.ResponseRecorder) (int, map[string]interface{}) {
+	t.Helper()
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || location.Path == "" {
+		t.Fatalf("expected a Location header, got %q", w.Header().Get("Location"))
+	}
+	return armRequest(t, mux, "GET", location.RequestURI(), "")
+}
+
+func TestMoveResources(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	vmPath := "/providers/Microsoft.Compute/virtualMachines/vm-web-01"
+	accountPath := "/providers/Microsoft.Storage/storageAccounts/stdev01"
+	if code, body := armRequest(t, mux, "PUT", testSubscriptionPath+"/resourceGroups/rg-dev"+accountPath, `{"location":"eastus"}`); code != http.StatusCreated {
+		t.Fatalf("expected storage account to be created, got %d %v", code, body)
+	}
+
+	move := func(action, target string, ids ...string) *httptest.ResponseRecorder {
+		for i, id := range ids {
+			ids[i] = `"` + testSubscriptionPath + "/resourceGroups/rg-dev" + id + `"`
+		}
+		body := `{"resources":[` + strings.Join(ids, ",") + `],"targetResourceGroup":"` + testSubscriptionPath + "/resourceGroups/" + target + `"}`
+		req := httptest.NewRequest("POST", testSubscriptionPath+"/resourceGroups/rg-dev/"+action+"?api-version=2021-04-01", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	code, body := pollOperation(t, mux, move("validateMoveResources", "rg-missing", vmPath))
+	if code != http.StatusConflict || armErrorCode(body) != "ResourceMoveValidationFailed" {
+		t.Fatalf("expected ResourceMoveValidationFailed, got %d %v", code, body)
+	}
+	details := body["error"].(map[string]interface{})["details"].([]interface{})
+	if len(details) != 1 || details[0].(map[string]interface{})["code"] != "ResourceGroupNotFound" {
+		t.Errorf("expected a ResourceGroupNotFound detail, got %v", details)
+	}
+
+	if code, body := pollOperation(t, mux, move("validateMoveResources", "rg-prod", vmPath, accountPath)); code != http.StatusNoContent {
+		t.Fatalf("expected validation to succeed, got %d %v", code, body)
+	}
+	if code, _ := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-dev"+vmPath, ""); code != http.StatusOK {
+		t.Errorf("expected validation to leave the VM in place, got %d", code)
+	}
+
+	if code, body := pollOperation(t, mux, move("moveResources", "rg-prod", vmPath, accountPath)); code != http.StatusNoContent {
+		t.Fatalf("expected move to succeed, got %d %v", code, body)
+	}
+	for _, path := range []string{vmPath, accountPath} {
+		if code, _ := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-dev"+path, ""); code != http.StatusNotFound {
+			t.Errorf("expected %s to be gone from rg-dev, got %d", path, code)
+		}
+		code, body := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-prod"+path, "")
+		if code != http.StatusOK || body["id"] != testSubscriptionPath+"/resourceGroups/rg-prod"+path {
+			t.Errorf("expected %s in rg-prod, got %d %v", path, code, body)
+		}
+	}
+
+	// Write on a group of the same name does not grant a subscription the caller cannot access
+	otherSubscription := "87654321-4321-4321-4321-210987654321"
+	store.subscriptions = append(store.subscriptions, &Subscription{ID: otherSubscription, TenantID: store.tenants[0].ID, State: "Enabled"})
+	store.resourceGroups = append(store.resourceGroups, &ResourceGroup{ID: "/subscriptions/" + otherSubscription + "/resourceGroups/rg-dev", Name: "rg-dev", SubscriptionID: otherSubscription, Location: "eastus"})
+	sa := store.serviceAccounts[0]
+	sa.Subscriptions = []string{"12345678-1234-1234-1234-123456789012"}
+	sa.Permissions = []ResourceGroupPerm{{ResourceGroup: "*", Permissions: []string{"read", "write"}}}
+	moveBody := `{"resources":["` + testSubscriptionPath + "/resourceGroups/rg-prod" + vmPath + `"],"targetResourceGroup":"/subscriptions/` + otherSubscription + `/resourceGroups/rg-dev"}`
+	req := httptest.NewRequest("POST", testSubscriptionPath+"/resourceGroups/rg-prod/moveResources?api-version=2021-04-01", strings.NewReader(moveBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(sa.ApplicationID, "sandman-secret-key-development-only")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "AuthorizationFailed") {
+		t.Errorf("expected AuthorizationFailed for the inaccessible target subscription, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestExportResourceGroupTemplate(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	vnetID := testSubscriptionPath + "/resourceGroups/rg-dev/providers/Microsoft.Network/virtualNetworks/vnet-dev"
+	if code, body := armRequest(t, mux, "PUT", vnetID, `{"location":"eastus","properties":{"addressSpace":{"addressPrefixes":["10.0.0.0/16"]}}}`); code != http.StatusCreated {
+		t.Fatalf("expected vnet to be created, got %d %v", code, body)
+	}
+	if code, body := armRequest(t, mux, "PUT", vnetID+"/subnets/default", `{"properties":{"addressPrefix":"10.0.0.0/24"}}`); code != http.StatusCreated {
+		t.Fatalf("expected subnet to be created, got %d %v", code, body)
+	}
+
+	export := func(body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", testSubscriptionPath+"/resourcegroups/rg-dev/exportTemplate?api-version=2021-04-01", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return pollOperation(t, mux, w)
+	}
+
+	code, body := export(`{"resources":["*"],"options":"IncludeParameterDefaultValue,IncludeComments"}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200 from the export result, got %d %v", code, body)
+	}
+	template := body["template"].(map[string]interface{})
+	if template["$schema"] == nil || template["contentVersion"] != "1.0.0.0" {
+		t.Errorf("expected template header fields, got %v", template)
+	}
+	parameters := template["parameters"].(map[string]interface{})
+	vmParameter, ok := parameters["virtualMachines_vm_web_01_name"].(map[string]interface{})
+	if !ok || vmParameter["defaultValue"] != "vm-web-01" {
+		t.Errorf("expected a VM name parameter with its default value, got %v", parameters)
+	}
+
+	byName := map[string]map[string]interface{}{}
+	for _, r := range template["resources"].([]interface{}) {
+		entry := r.(map[string]interface{})
+		byName[entry["name"].(string)] = entry
+	}
+	if len(byName) != 10 {
+		t.Errorf("expected 2 VMs, the vnet and its subnet and the 6 configured network resources, got %v", byName)
+	}
+	subnet, ok := byName["[concat(parameters('virtualNetworks_vnet_dev_name'), '/default')]"]
+	if !ok {
+		t.Fatalf("expected the subnet named after its parent parameter, got %v", byName)
+	}
+	if dependsOn := subnet["dependsOn"].([]interface{}); len(dependsOn) != 1 || dependsOn[0] != "[resourceId('Microsoft.Network/virtualNetworks', parameters('virtualNetworks_vnet_dev_name'))]" {
+		t.Errorf("expected the subnet to depend on its vnet, got %v", subnet["dependsOn"])
+	}
+	if subnet["comments"] != "Generalized from resource: '"+vnetID+"/subnets/default'." {
+		t.Errorf("expected a comment naming the source resource, got %v", subnet["comments"])
+	}
+	vm := byName["[parameters('virtualMachines_vm_web_01_name')]"]
+	if vm == nil || vm["type"] != "Microsoft.Compute/virtualMachines" || vm["apiVersion"] == nil {
+		t.Errorf("expected the VM in the template, got %v", vm)
+	}
+
+	code, body = export(`{"resources":["` + vnetID + `","` + vnetID + `/subnets/missing"]}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200 from the export result, got %d %v", code, body)
+	}
+	template = body["template"].(map[string]interface{})
+	if resources := template["resources"].([]interface{}); len(resources) != 1 {
+		t.Errorf("expected only the vnet to be exported, got %v", resources)
+	}
+	if parameter := template["parameters"].(map[string]interface{})["virtualNetworks_vnet_dev_name"].(map[string]interface{}); parameter["defaultValue"] != nil {
+		t.Errorf("expected no default value without IncludeParameterDefaultValue, got %v", parameter)
+	}
+	if armErrorCode(body) != "ExportTemplateCompletedWithErrors" {
+		t.Errorf("expected the missing resource to be reported, got %v", body["error"])
+	}
+}
+
+func TestOperationRetention(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	for i := 0; i <= maxOperations; i++ {
+		store.PutOperation(&mappers.Operation{ID: fmt.Sprintf("op-%d", i)})
+	}
+	if len(store.operations) != maxOperations || store.GetOperation("op-0") != nil || store.GetOperation(fmt.Sprintf("op-%d", maxOperations)) == nil {
+		t.Errorf("expected the oldest operation to be dropped, got %d kept", len(store.operations))
+	}
+	store.operations["op-1"].created = time.Now().Add(-2 * operationRetention)
+	if store.GetOperation("op-1") != nil {
+		t.Errorf("expected an expired operation to be gone")
+	}
+}
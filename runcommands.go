@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RunCommandResponder scripts the output of run commands and custom script extensions. The first
+// responder matching the VM, command and script answers; empty fields match anything, so a
+// responder with only a VM is the default for that VM.
+type RunCommandResponder struct {
+	VM        string `json:"vm,omitempty" yaml:"vm,omitempty"`               // VM name or resource ID
+	CommandID string `json:"commandId,omitempty" yaml:"commandId,omitempty"` // RunShellScript, RunPowerShellScript or CustomScript
+	Script    string `json:"script,omitempty" yaml:"script,omitempty"`       // regular expression matched against the script lines
+	Stdout    string `json:"stdout,omitempty" yaml:"stdout,omitempty"`
+	Stderr    string `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+	ExitCode  int    `json:"exitCode,omitempty" yaml:"exitCode,omitempty"`
+}
+
+// RunCommandInvocation records a script run on a VM through a run command or custom script extension
+type RunCommandInvocation struct {
+	ID         string            `json:"id"`
+	VMID       string            `json:"vmId"`
+	VMName     string            `json:"vmName"`
+	CommandID  string            `json:"commandId"`
+	Script     []string          `json:"script"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Stdout     string            `json:"stdout"`
+	Stderr     string            `json:"stderr"`
+	ExitCode   int               `json:"exitCode"`
+	InvokedAt  time.Time         `json:"invokedAt"`
+}
+
+// validateRunCommandResponders checks the script patterns of the responders compile
+func validateRunCommandResponders(responders []*RunCommandResponder) error {
+	for i, responder := range responders {
+		if _, err := regexp.Compile(responder.Script); err != nil {
+			return fmt.Errorf("runCommands[%d]: invalid script pattern: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// GetRunCommandResponders returns the configured run command responders as interface slice for mappers
+func (s *Store) GetRunCommandResponders() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]interface{}, len(s.runResponders))
+	for i, responder := range s.runResponders {
+		result[i] = map[string]interface{}{
+			"vm":        responder.VM,
+			"commandId": responder.CommandID,
+			"script":    responder.Script,
+			"stdout":    responder.Stdout,
+			"stderr":    responder.Stderr,
+			"exitCode":  responder.ExitCode,
+		}
+	}
+	return result
+}
+
+// RecordRunCommand records a run command or custom script invocation
+func (s *Store) RecordRunCommand(invocation map[string]interface{}) error {
+	data, err := json.Marshal(invocation)
+	if err != nil {
+		return err
+	}
+	var recorded RunCommandInvocation
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	s.runCommands = append(s.runCommands, &recorded)
+	return nil
+}
+
+// serveRunCommandLog serves /mock/azure/runcommands: GET lists the recorded invocations, oldest
+// first, optionally for one VM (?vm= name or ID); DELETE forgets them
+func serveRunCommandLog(w http.ResponseWriter, r *http.Request, store *Store) {
+	switch r.Method {
+	case http.MethodGet:
+		vm := r.URL.Query().Get("vm")
+		store.mu.RLock()
+		list := []*RunCommandInvocation{}
+		for _, invocation := range store.runCommands {
+			if vm == "" || strings.EqualFold(invocation.VMName, vm) || strings.EqualFold(invocation.VMID, vm) {
+				list = append(list, invocation)
+			}
+		}
+		store.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"value": list, "count": len(list)}); err != nil {
+			log.Printf("Failed to encode JSON response: %v", err)
+		}
+	case http.MethodDelete:
+		store.mu.Lock()
+		store.runCommands = []*RunCommandInvocation{}
+		store.changed()
+		store.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// runCommandMessage returns the message of the first status in a run command result
+func runCommandMessage(result map[string]interface{}) string {
+	value, _ := result["value"].([]interface{})
+	if len(value) == 0 {
+		return ""
+	}
+	message, _ := value[0].(map[string]interface{})["message"].(string)
+	return message
+}
+
+func TestRunCommands(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	store.runResponders = []*RunCommandResponder{
+		{VM: "vm-web-01", Script: `systemctl restart nginx`, Stdout: "nginx restarted"},
+		{Script: `^exit 3$`, Stderr: "boom", ExitCode: 3},
+		{VM: "vm-web-01", Stdout: "web default"},
+	}
+	mux := newMux(store, "mockzure-specs")
+	vmPath := testComputePath + "/virtualMachines/"
+
+	t.Run("run command", func(t *testing.T) {
+		tests := []struct {
+			name, script, message string
+		}{
+			{"matched", "sudo systemctl restart nginx", "[stdout]\nnginx restarted\n[stderr]\n\n"},
+			{"vm default", "uptime", "[stdout]\nweb default\n"},
+			{"failed", "exit 3", "Enable failed: failed to execute command: command terminated with exit status=3"},
+		}
+		for _, tt := range tests {
+			code, result := armAction(t, mux, vmPath+"vm-web-01/runCommand", `{"commandId":"RunShellScript","script":["`+tt.script+`"]}`)
+			if code != http.StatusOK || !strings.Contains(runCommandMessage(result), tt.message) {
+				t.Errorf("%s: expected %q in the output, got %d %v", tt.name, tt.message, code, result)
+			}
+		}
+
+		code, body := armAction(t, mux, vmPath+"vm-web-01/runCommand", `{"commandId":"RunPowerShellScript","script":["Get-Date"]}`)
+		if code != http.StatusNotFound {
+			t.Errorf("expected a PowerShell script on a Linux VM to fail, got %d %v", code, body)
+		}
+		code, _ = armAction(t, mux, vmPath+"vm-api-01/runCommand", `{"commandId":"RunShellScript","script":["uptime"]}`)
+		if code != http.StatusConflict {
+			t.Errorf("expected a run command on a deallocated VM to fail, got %d", code)
+		}
+	})
+
+	t.Run("custom script extension", func(t *testing.T) {
+		extensionPath := vmPath + "vm-web-01/extensions/remediate"
+		body := func(command string) string {
+			return `{"location":"eastus","properties":{"publisher":"Microsoft.Azure.Extensions","type":"CustomScript","typeHandlerVersion":"2.1",
+				"protectedSettings":{"commandToExecute":"` + command + `"}}}`
+		}
+		code, result := armRequest(t, mux, "PUT", extensionPath, body("exit 3"))
+		if code != http.StatusConflict || armErrorCode(result) != "VMExtensionProvisioningError" {
+			t.Errorf("expected the failing script to fail the extension, got %d %v", code, result)
+		}
+		code, result = armRequest(t, mux, "PUT", extensionPath, body("systemctl restart nginx"))
+		if code != http.StatusOK || result["properties"].(map[string]interface{})["provisioningState"] != "Succeeded" {
+			t.Fatalf("expected the extension to be updated, got %d %v", code, result)
+		}
+		if _, ok := result["properties"].(map[string]interface{})["protectedSettings"]; ok {
+			t.Errorf("expected protected settings to be hidden, got %v", result)
+		}
+
+		_, view := armRequest(t, mux, "GET", vmPath+"vm-web-01/instanceView", "")
+		found := false
+		for _, e := range view["extensions"].([]interface{}) {
+			ext := e.(map[string]interface{})
+			if ext["name"] == "remediate" {
+				found = strings.Contains(runCommandMessage(map[string]interface{}{"value": ext["statuses"]}), "nginx restarted")
+			}
+		}
+		if !found {
+			t.Errorf("expected the script output in the instance view, got %v", view["extensions"])
+		}
+		if code, _ := armRequest(t, mux, "DELETE", extensionPath, ""); code != http.StatusAccepted {
+			t.Errorf("expected the extension to be deleted, got %d", code)
+		}
+		if code, _ := armRequest(t, mux, "GET", extensionPath, ""); code != http.StatusNotFound {
+			t.Errorf("expected the deleted extension to be gone, got %d", code)
+		}
+	})
+
+	t.Run("invocation log", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/mock/azure/runcommands?vm=vm-web-01", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var log struct {
+			Value []RunCommandInvocation `json:"value"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &log); err != nil {
+			t.Fatalf("failed to decode the invocation log: %v", err)
+		}
+		if len(log.Value) != 5 {
+			t.Fatalf("expected three run commands and two custom scripts, got %+v", log.Value)
+		}
+		first, last := log.Value[0], log.Value[4]
+		if first.CommandID != "RunShellScript" || first.Script[0] != "sudo systemctl restart nginx" || first.Stdout != "nginx restarted" {
+			t.Errorf("unexpected first invocation: %+v", first)
+		}
+		if last.CommandID != "CustomScript" || last.Script[0] != "systemctl restart nginx" {
+			t.Errorf("unexpected last invocation: %+v", last)
+		}
+
+		req = httptest.NewRequest("DELETE", "/mock/azure/runcommands", nil)
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+		if len(store.runCommands) != 0 {
+			t.Errorf("expected the log to be cleared, got %d invocations", len(store.runCommands))
+		}
+	})
+}
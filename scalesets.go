@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ScaleSetVM is a VM instance of a virtual machine scale set. The scale set itself is a
+// generic resource; its instances are VMs with the same power state as standalone ones.
+type ScaleSetVM struct {
+	MockVM
+	ScaleSetID         string                      `json:"scaleSetId" yaml:"scaleSetId"`
+	InstanceID         string                      `json:"instanceId" yaml:"instanceId"`
+	LatestModelApplied bool                        `json:"latestModelApplied" yaml:"latestModelApplied"`
+	ProtectionPolicy   *ScaleSetVMProtectionPolicy `json:"protectionPolicy,omitempty" yaml:"protectionPolicy,omitempty"`
+}
+
+// ScaleSetVMProtectionPolicy protects an instance from scale-in and scale set wide actions
+type ScaleSetVMProtectionPolicy struct {
+	ProtectFromScaleIn         bool `json:"protectFromScaleIn" yaml:"protectFromScaleIn"`
+	ProtectFromScaleSetActions bool `json:"protectFromScaleSetActions" yaml:"protectFromScaleSetActions"`
+}
+
+// scaleSetPattern matches the scale set list routes, manual upgrades and the instance routes.
+// Scale sets themselves are served by the generic resource routes.
+var scaleSetPattern = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)(?:/resourcegroups/([^/]+))?/providers/Microsoft\.Compute/virtualMachineScaleSets(?:/([^/]+)/(manualupgrade|virtualMachines)(?:/([^/]+)(?:/(start|deallocate|restart|instanceView))?)?)?/?$`)
+
+// scaleSetVMActions maps instance actions to operation IDs
+var scaleSetVMActions = map[string]string{
+	"start":        "VirtualMachineScaleSetVMs_Start",
+	"deallocate":   "VirtualMachineScaleSetVMs_Deallocate",
+	"restart":      "VirtualMachineScaleSetVMs_Restart",
+	"instanceview": "VirtualMachineScaleSetVMs_GetInstanceView",
+}
+
+// GetScaleSetVMs returns the instances of a scale set, ordered by instance ID, as interface
+// slice for mappers. Each entry is a copy, so mappers may modify it freely.
+func (s *Store) GetScaleSetVMs(scaleSetID string) []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	instances := []*ScaleSetVM{}
+	for _, vm := range s.scaleSetVMs {
+		if strings.EqualFold(vm.ScaleSetID, scaleSetID) {
+			instances = append(instances, vm)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		a, _ := strconv.Atoi(instances[i].InstanceID)
+		b, _ := strconv.Atoi(instances[j].InstanceID)
+		return a < b
+	})
+	result := make([]interface{}, len(instances))
+	for i, vm := range instances {
+		m := s.vmView(&vm.MockVM)
+		m["scaleSetId"] = vm.ScaleSetID
+		m["instanceId"] = vm.InstanceID
+		m["latestModelApplied"] = vm.LatestModelApplied
+		if vm.ProtectionPolicy != nil {
+			m["protectionPolicy"] = map[string]interface{}{
+				"protectFromScaleIn":         vm.ProtectionPolicy.ProtectFromScaleIn,
+				"protectFromScaleSetActions": vm.ProtectionPolicy.ProtectFromScaleSetActions,
+			}
+		}
+		result[i] = m
+	}
+	return result
+}
+
+// PutScaleSetVM creates or updates a scale set instance. New instances get the next unused
+// instance ID of their scale set; like Azure, instance IDs are not reused.
+func (s *Store) PutScaleSetVM(vm map[string]interface{}) (string, error) {
+	scaleSetID, _ := vm["scaleSetId"].(string)
+	instanceID, _ := vm["instanceId"].(string)
+	if scaleSetID == "" {
+		return "", fmt.Errorf("scale set id is required")
+	}
+	latest, _ := vm["latestModelApplied"].(bool)
+	var protection *ScaleSetVMProtectionPolicy
+	if policy, ok := vm["protectionPolicy"].(map[string]interface{}); ok {
+		scaleIn, _ := policy["protectFromScaleIn"].(bool)
+		actions, _ := policy["protectFromScaleSetActions"].(bool)
+		protection = &ScaleSetVMProtectionPolicy{ProtectFromScaleIn: scaleIn, ProtectFromScaleSetActions: actions}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	if instanceID != "" {
+		for _, existing := range s.scaleSetVMs {
+			if strings.EqualFold(existing.ScaleSetID, scaleSetID) && existing.InstanceID == instanceID {
+				if err := updateMockVM(&existing.MockVM, vm); err != nil {
+					return "", err
+				}
+				existing.LatestModelApplied = latest
+				existing.ProtectionPolicy = protection
+				return existing.ID, nil
+			}
+		}
+		return "", fmt.Errorf("scale set instance %s/virtualMachines/%s not found", scaleSetID, instanceID)
+	}
+
+	key := strings.ToLower(scaleSetID)
+	instanceID = strconv.Itoa(s.scaleSetNextIDs[key])
+	s.scaleSetNextIDs[key]++
+	name := scaleSetID[strings.LastIndex(scaleSetID, "/")+1:]
+	created := map[string]interface{}{}
+	for k, v := range vm {
+		created[k] = v
+	}
+	created["id"] = scaleSetID + "/virtualMachines/" + instanceID
+	created["name"] = name + "_" + instanceID
+	mockVM, err := newMockVM(created)
+	if err != nil {
+		return "", err
+	}
+	s.scaleSetVMs = append(s.scaleSetVMs, &ScaleSetVM{
+		MockVM:             *mockVM,
+		ScaleSetID:         scaleSetID,
+		InstanceID:         instanceID,
+		LatestModelApplied: latest,
+		ProtectionPolicy:   protection,
+	})
+	return mockVM.ID, nil
+}
+
+// DeleteScaleSetVM deletes a scale set instance, reporting whether it existed
+func (s *Store) DeleteScaleSetVM(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+	found := false
+	kept := s.scaleSetVMs[:0]
+	for _, vm := range s.scaleSetVMs {
+		if strings.EqualFold(vm.ID, id) {
+			found = true
+			continue
+		}
+		kept = append(kept, vm)
+	}
+	s.scaleSetVMs = kept
+	return found
+}
+
+// deleteScaleSetVMs deletes the instances of the scale sets at or under an ID, and forgets
+// their instance IDs. The caller must hold s.mu.
+func (s *Store) deleteScaleSetVMs(id string) {
+	kept := s.scaleSetVMs[:0]
+	for _, vm := range s.scaleSetVMs {
+		if strings.EqualFold(vm.ScaleSetID, id) || hasIDPrefix(vm.ScaleSetID, id) {
+			continue
+		}
+		kept = append(kept, vm)
+	}
+	s.scaleSetVMs = kept
+	for key := range s.scaleSetNextIDs {
+		if strings.EqualFold(key, id) || hasIDPrefix(key, id) {
+			delete(s.scaleSetNextIDs, key)
+		}
+	}
+}
+
+// scaleSetRoute matches the scale set list, manual upgrade and instance routes, returning nil
+// when the request does not
+func scaleSetRoute(r *http.Request) *armRoute {
+	matches := scaleSetPattern.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		return nil
+	}
+	subscriptionID, resourceGroup, name, collection, instanceID, action := matches[1], matches[2], matches[3], strings.ToLower(matches[4]), matches[5], strings.ToLower(matches[6])
+	if name != "" && resourceGroup == "" {
+		return nil
+	}
+
+	pattern := "/subscriptions/{subscriptionId}"
+	params := map[string]string{"subscriptionId": subscriptionID}
+	if resourceGroup != "" {
+		pattern += "/resourceGroups/{resourceGroupName}"
+		params["resourceGroupName"] = resourceGroup
+	}
+	pattern += "/providers/Microsoft.Compute/virtualMachineScaleSets"
+	if name != "" {
+		pattern += "/{vmScaleSetName}/" + matches[4]
+		params["vmScaleSetName"] = name
+	}
+	if instanceID != "" {
+		pattern += "/{instanceId}"
+		params["instanceId"] = instanceID
+	}
+	if action != "" {
+		pattern += "/" + matches[6]
+	}
+
+	var op string
+	switch {
+	case name == "" && r.Method == http.MethodGet && resourceGroup != "":
+		op = "VirtualMachineScaleSets_List"
+	case name == "" && r.Method == http.MethodGet:
+		op = "VirtualMachineScaleSets_ListAll"
+	case collection == "manualupgrade" && instanceID == "" && r.Method == http.MethodPost:
+		op = "VirtualMachineScaleSets_ManualUpgrade"
+	case collection != "virtualmachines":
+	case instanceID == "" && r.Method == http.MethodGet:
+		op = "VirtualMachineScaleSetVMs_List"
+	case instanceID == "":
+	case action == "instanceview" && r.Method == http.MethodGet:
+		op = scaleSetVMActions[action]
+	case action != "" && action != "instanceview" && r.Method == http.MethodPost:
+		op = scaleSetVMActions[action]
+	case action == "" && r.Method == http.MethodGet:
+		op = "VirtualMachineScaleSetVMs_Get"
+	case action == "" && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		op = "VirtualMachineScaleSetVMs_Update"
+	case action == "" && r.Method == http.MethodDelete:
+		op = "VirtualMachineScaleSetVMs_Delete"
+	}
+	if op == "" {
+		return nil
+	}
+	return &armRoute{operationID: op, pattern: pattern, params: params}
+}
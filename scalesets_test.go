@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// scaleSetInstanceIDs returns the instance IDs of a scale set, in list order
+func scaleSetInstanceIDs(t *testing.T, mux http.Handler, scaleSetPath string) []string {
+	t.Helper()
+	code, list := armRequest(t, mux, "GET", scaleSetPath+"/virtualMachines", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected the instances to be listed, got %d %v", code, list)
+	}
+	ids := []string{}
+	for _, item := range list["value"].([]interface{}) {
+		ids = append(ids, item.(map[string]interface{})["instanceId"].(string))
+	}
+	return ids
+}
+
+// powerStateCode returns the power state code in an instance view
+func powerStateCode(view map[string]interface{}) interface{} {
+	statuses, _ := view["statuses"].([]interface{})
+	if len(statuses) < 2 {
+		return nil
+	}
+	return statuses[1].(map[string]interface{})["code"]
+}
+
+func TestVirtualMachineScaleSets(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+	scaleSetPath := testComputePath + "/virtualMachineScaleSets/vmss-web"
+	body := `{"location":"eastus","zones":["1","2"],"sku":{"name":"Standard_B2s","capacity":3},"properties":{
+		"upgradePolicy":{"mode":"Manual"},
+		"virtualMachineProfile":{
+			"storageProfile":{"imageReference":{"publisher":"Canonical","offer":"0001-com-ubuntu-server-jammy","sku":"22_04-lts-gen2","version":"latest"},"osDisk":{"createOption":"FromImage","managedDisk":{"storageAccountType":"Premium_LRS"}}},
+			"osProfile":{"computerNamePrefix":"web","adminUsername":"webadmin"},
+			"networkProfile":{"networkInterfaceConfigurations":[{"name":"nic","properties":{"primary":true}}]}}}}`
+
+	t.Run("create", func(t *testing.T) {
+		code, scaleSet := armRequest(t, mux, "PUT", scaleSetPath, body)
+		if code != http.StatusCreated {
+			t.Fatalf("expected the scale set to be created, got %d %v", code, scaleSet)
+		}
+		properties := scaleSet["properties"].(map[string]interface{})
+		if properties["orchestrationMode"] != "Uniform" || properties["provisioningState"] != "Succeeded" {
+			t.Errorf("unexpected scale set properties: %v", properties)
+		}
+		if ids := scaleSetInstanceIDs(t, mux, scaleSetPath); len(ids) != 3 || ids[0] != "0" || ids[2] != "2" {
+			t.Fatalf("expected three instances, got %v", ids)
+		}
+		code, vm := armRequest(t, mux, "GET", scaleSetPath+"/virtualMachines/1", "")
+		if code != http.StatusOK || vm["name"] != "vmss-web_1" || vm["type"] != "Microsoft.Compute/virtualMachineScaleSets/virtualMachines" {
+			t.Fatalf("unexpected instance: %d %v", code, vm)
+		}
+		vmProperties := vm["properties"].(map[string]interface{})
+		osProfile := vmProperties["osProfile"].(map[string]interface{})
+		if osProfile["computerName"] != "web000001" || osProfile["adminUsername"] != "webadmin" {
+			t.Errorf("unexpected os profile: %v", osProfile)
+		}
+		if vmProperties["latestModelApplied"] != true || vmProperties["hardwareProfile"].(map[string]interface{})["vmSize"] != "Standard_B2s" {
+			t.Errorf("unexpected instance properties: %v", vmProperties)
+		}
+		if zones, _ := vm["zones"].([]interface{}); len(zones) != 1 || zones[0] != "2" {
+			t.Errorf("expected instances to be spread over the zones, got %v", vm["zones"])
+		}
+		_, list := armRequest(t, mux, "GET", testSubscriptionPath+"/providers/Microsoft.Compute/virtualMachineScaleSets", "")
+		if value, _ := list["value"].([]interface{}); len(value) != 1 {
+			t.Errorf("expected the scale set in the subscription list, got %v", list)
+		}
+		// Scale set instances are not standalone VMs
+		_, vms := armRequest(t, mux, "GET", testComputePath+"/virtualMachines", "")
+		for _, item := range vms["value"].([]interface{}) {
+			if name := item.(map[string]interface{})["name"]; name == "vmss-web_0" {
+				t.Errorf("expected instances to stay out of the VM list")
+			}
+		}
+	})
+
+	t.Run("scale", func(t *testing.T) {
+		if code, body := armRequest(t, mux, "PATCH", scaleSetPath, `{"sku":{"capacity":5}}`); code != http.StatusOK {
+			t.Fatalf("expected the scale set to scale out, got %d %v", code, body)
+		}
+		if ids := scaleSetInstanceIDs(t, mux, scaleSetPath); len(ids) != 5 || ids[4] != "4" {
+			t.Fatalf("expected five instances, got %v", ids)
+		}
+		// Scale-in removes the newest instances, except protected ones
+		code, vm := armRequest(t, mux, "PUT", scaleSetPath+"/virtualMachines/3", `{"properties":{"protectionPolicy":{"protectFromScaleIn":true}}}`)
+		if policy, _ := vm["properties"].(map[string]interface{})["protectionPolicy"].(map[string]interface{}); code != http.StatusOK || policy["protectFromScaleIn"] != true {
+			t.Fatalf("expected the instance to be protected, got %d %v", code, vm)
+		}
+		armRequest(t, mux, "PATCH", scaleSetPath, `{"sku":{"capacity":2}}`)
+		if ids := scaleSetInstanceIDs(t, mux, scaleSetPath); len(ids) != 2 || ids[0] != "0" || ids[1] != "3" {
+			t.Fatalf("expected instances 0 and 3 to remain, got %v", ids)
+		}
+		code, body := armRequest(t, mux, "PATCH", scaleSetPath, `{"sku":{"capacity":0}}`)
+		if code != http.StatusConflict || armErrorCode(body) != "OperationNotAllowed" {
+			t.Errorf("expected scale-in past a protected instance to fail, got %d %v", code, body)
+		}
+		if _, scaleSet := armRequest(t, mux, "GET", scaleSetPath, ""); scaleSet["sku"].(map[string]interface{})["capacity"] != float64(2) {
+			t.Errorf("expected a refused scale-in to keep the capacity, got %v", scaleSet["sku"])
+		}
+
+		// Deleting an instance lowers the capacity; instance IDs are not reused
+		if code, _ := armRequest(t, mux, "DELETE", scaleSetPath+"/virtualMachines/0", ""); code != http.StatusAccepted {
+			t.Fatalf("expected the instance to be deleted, got %d", code)
+		}
+		if _, scaleSet := armRequest(t, mux, "GET", scaleSetPath, ""); scaleSet["sku"].(map[string]interface{})["capacity"] != float64(1) {
+			t.Errorf("expected the capacity to drop, got %v", scaleSet["sku"])
+		}
+		armRequest(t, mux, "PATCH", scaleSetPath, `{"sku":{"capacity":2}}`)
+		if ids := scaleSetInstanceIDs(t, mux, scaleSetPath); len(ids) != 2 || ids[0] != "3" || ids[1] != "5" {
+			t.Errorf("expected a new instance ID, got %v", ids)
+		}
+	})
+
+	t.Run("power", func(t *testing.T) {
+		instancePath := scaleSetPath + "/virtualMachines/5"
+		if code, _ := armAction(t, mux, instancePath+"/deallocate", ""); code != http.StatusNoContent {
+			t.Fatalf("expected the instance to be deallocated, got %d", code)
+		}
+		if _, view := armRequest(t, mux, "GET", instancePath+"/instanceView", ""); powerStateCode(view) != "PowerState/deallocated" {
+			t.Errorf("expected a deallocated instance, got %v", view["statuses"])
+		}
+		if code, body := armRequest(t, mux, "POST", instancePath+"/restart", ""); code != http.StatusConflict || armErrorCode(body) != "OperationNotAllowed" {
+			t.Errorf("expected restarting a deallocated instance to fail, got %d %v", code, body)
+		}
+		armAction(t, mux, instancePath+"/start", "")
+		if _, vm := armRequest(t, mux, "GET", instancePath+"?$expand=instanceView", ""); powerStateCode(vm["properties"].(map[string]interface{})["instanceView"].(map[string]interface{})) != "PowerState/running" {
+			t.Errorf("expected a running instance, got %v", vm["properties"])
+		}
+
+		vmPath := testComputePath + "/virtualMachines/vm-web-01"
+		if code, _ := armAction(t, mux, vmPath+"/deallocate", ""); code != http.StatusNoContent {
+			t.Fatalf("expected the VM to be deallocated, got %d", code)
+		}
+		if _, view := armRequest(t, mux, "GET", vmPath+"/instanceView", ""); powerStateCode(view) != "PowerState/deallocated" {
+			t.Errorf("expected a deallocated VM, got %v", view["statuses"])
+		}
+		armAction(t, mux, vmPath+"/start", "")
+		if code, _ := armAction(t, mux, vmPath+"/restart", ""); code != http.StatusNoContent {
+			t.Errorf("expected the VM to restart, got %d", code)
+		}
+	})
+
+	t.Run("manual upgrade", func(t *testing.T) {
+		if code, body := armRequest(t, mux, "PATCH", scaleSetPath, `{"sku":{"name":"Standard_D2s_v5"}}`); code != http.StatusOK {
+			t.Fatalf("expected the model to change, got %d %v", code, body)
+		}
+		_, vm := armRequest(t, mux, "GET", scaleSetPath+"/virtualMachines/5", "")
+		if vm["properties"].(map[string]interface{})["latestModelApplied"] != false {
+			t.Fatalf("expected the instance to run an old model, got %v", vm["properties"])
+		}
+		armRequest(t, mux, "PUT", scaleSetPath+"/virtualMachines/3", `{"properties":{"protectionPolicy":{"protectFromScaleIn":true,"protectFromScaleSetActions":true}}}`)
+		if code, body := armRequest(t, mux, "POST", scaleSetPath+"/manualupgrade", `{"instanceIds":["3","5"]}`); code != http.StatusConflict {
+			t.Errorf("expected upgrading a protected instance to fail, got %d %v", code, body)
+		}
+		if code, _ := armAction(t, mux, scaleSetPath+"/manualupgrade", `{"instanceIds":["5"]}`); code != http.StatusNoContent {
+			t.Fatalf("expected the instance to be upgraded, got %d", code)
+		}
+		_, vm = armRequest(t, mux, "GET", scaleSetPath+"/virtualMachines/5", "")
+		properties := vm["properties"].(map[string]interface{})
+		if properties["latestModelApplied"] != true || properties["hardwareProfile"].(map[string]interface{})["vmSize"] != "Standard_D2s_v5" {
+			t.Errorf("expected the instance to run the latest model, got %v", properties)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		tests := []struct {
+			name, body string
+		}{
+			{"missing sku", `{"location":"eastus","properties":{"virtualMachineProfile":{}}}`},
+			{"capacity too large", `{"location":"eastus","sku":{"name":"Standard_B2s","capacity":1001},"properties":{"virtualMachineProfile":{}}}`},
+			{"flexible", `{"location":"eastus","sku":{"name":"Standard_B2s","capacity":1},"properties":{"orchestrationMode":"Flexible","virtualMachineProfile":{}}}`},
+			{"upgrade mode", `{"location":"eastus","sku":{"name":"Standard_B2s","capacity":1},"properties":{"upgradePolicy":{"mode":"Sometimes"},"virtualMachineProfile":{}}}`},
+			{"missing profile", `{"location":"eastus","sku":{"name":"Standard_B2s","capacity":1}}`},
+		}
+		for _, tt := range tests {
+			code, body := armRequest(t, mux, "PUT", testComputePath+"/virtualMachineScaleSets/bad", tt.body)
+			if code != http.StatusBadRequest || armErrorCode(body) != "InvalidParameter" {
+				t.Errorf("%s: expected 400 InvalidParameter, got %d %v", tt.name, code, body)
+			}
+		}
+		if code, body := armRequest(t, mux, "GET", scaleSetPath+"/virtualMachines/42", ""); code != http.StatusNotFound {
+			t.Errorf("expected a missing instance to be not found, got %d %v", code, body)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if code, _ := armRequest(t, mux, "DELETE", scaleSetPath, ""); code != http.StatusOK {
+			t.Fatalf("expected the scale set to be deleted, got %d", code)
+		}
+		if code, _ := armRequest(t, mux, "GET", scaleSetPath+"/virtualMachines", ""); code != http.StatusNotFound {
+			t.Errorf("expected the instances to go with the scale set, got %d", code)
+		}
+		if len(store.GetScaleSetVMs(scaleSetPath)) != 0 {
+			t.Errorf("expected no instances left in the store")
+		}
+	})
+}
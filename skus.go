@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// VMSize extends or overrides the built-in VM size catalog. Fields left unset keep the values
+// of the built-in size with the same name.
+type VMSize struct {
+	Name               string           `json:"name" yaml:"name"`
+	Family             string           `json:"family,omitempty" yaml:"family,omitempty"` // quota family, such as standardDSv5Family
+	VCPUs              int              `json:"vCPUs,omitempty" yaml:"vCPUs,omitempty"`
+	MemoryGB           float64          `json:"memoryGB,omitempty" yaml:"memoryGB,omitempty"`
+	MaxDataDiskCount   int              `json:"maxDataDiskCount,omitempty" yaml:"maxDataDiskCount,omitempty"`
+	ResourceDiskSizeMB int              `json:"resourceDiskSizeMB,omitempty" yaml:"resourceDiskSizeMB,omitempty"`
+	Locations          []string         `json:"locations,omitempty" yaml:"locations,omitempty"` // regions offering the size; defaults to every region
+	Restrictions       []SkuRestriction `json:"restrictions,omitempty" yaml:"restrictions,omitempty"`
+}
+
+// SkuRestriction keeps a VM size out of a region, or out of some of its zones
+type SkuRestriction struct {
+	Location   string   `json:"location" yaml:"location"`
+	Zones      []string `json:"zones,omitempty" yaml:"zones,omitempty"`           // restricted zones; defaults to the whole region
+	ReasonCode string   `json:"reasonCode,omitempty" yaml:"reasonCode,omitempty"` // defaults to NotAvailableForSubscription
+}
+
+// Quota overrides a compute quota limit: a VM family's vCPUs, "cores" for the regional vCPU
+// total, "virtualMachines" or "virtualMachineScaleSets"
+type Quota struct {
+	Location string `json:"location,omitempty" yaml:"location,omitempty"` // defaults to every region
+	Name     string `json:"name" yaml:"name"`
+	Limit    int    `json:"limit" yaml:"limit"`
+}
+
+// computeSkuPattern matches the resource SKU list and the per-location VM size and usage lists
+var computeSkuPattern = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/providers/Microsoft\.Compute/(?:skus|locations/([^/]+)/(vmSizes|usages))/?$`)
+
+// GetVMSizes returns the configured VM sizes as interface slice for mappers
+func (s *Store) GetVMSizes() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]interface{}, len(s.vmSizes))
+	for i, size := range s.vmSizes {
+		m := map[string]interface{}{
+			"name":               size.Name,
+			"family":             size.Family,
+			"vCPUs":              size.VCPUs,
+			"memoryGB":           size.MemoryGB,
+			"maxDataDiskCount":   size.MaxDataDiskCount,
+			"resourceDiskSizeMB": size.ResourceDiskSizeMB,
+			"locations":          size.Locations,
+		}
+		if size.Restrictions != nil {
+			restrictions := make([]interface{}, len(size.Restrictions))
+			for j, r := range size.Restrictions {
+				restrictions[j] = map[string]interface{}{
+					"location":   r.Location,
+					"zones":      r.Zones,
+					"reasonCode": r.ReasonCode,
+				}
+			}
+			m["restrictions"] = restrictions
+		}
+		result[i] = m
+	}
+	return result
+}
+
+// GetQuotas returns the configured quota limits as interface slice for mappers
+func (s *Store) GetQuotas() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]interface{}, len(s.quotas))
+	for i, q := range s.quotas {
+		result[i] = map[string]interface{}{
+			"location": q.Location,
+			"name":     q.Name,
+			"limit":    q.Limit,
+		}
+	}
+	return result
+}
+
+// computeSkuRoute matches the resource SKU, VM size and usage lists, returning nil when the request does not
+func computeSkuRoute(r *http.Request) *armRoute {
+	matches := computeSkuPattern.FindStringSubmatch(r.URL.Path)
+	if matches == nil || r.Method != http.MethodGet {
+		return nil
+	}
+	subscriptionID, location, collection := matches[1], matches[2], strings.ToLower(matches[3])
+
+	params := map[string]string{"subscriptionId": subscriptionID}
+	pattern, op := "/subscriptions/{subscriptionId}/providers/Microsoft.Compute/skus", "ResourceSkus_List"
+	if location != "" {
+		params["location"] = location
+		pattern, op = "/subscriptions/{subscriptionId}/providers/Microsoft.Compute/locations/{location}/vmSizes", "VirtualMachineSizes_List"
+		if collection == "usages" {
+			pattern, op = "/subscriptions/{subscriptionId}/providers/Microsoft.Compute/locations/{location}/usages", "Usage_List"
+		}
+	}
+	return &armRoute{operationID: op, pattern: pattern, params: params}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// usageEntry returns the entry of a quota in a usage list
+func usageEntry(list map[string]interface{}, name string) map[string]interface{} {
+	value, _ := list["value"].([]interface{})
+	for _, item := range value {
+		entry := item.(map[string]interface{})
+		if entry["name"].(map[string]interface{})["value"] == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+func TestComputeSkusAndQuotas(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+	computePath := testSubscriptionPath + "/providers/Microsoft.Compute"
+
+	t.Run("catalog", func(t *testing.T) {
+		code, list := armRequest(t, mux, "GET", computePath+"/skus?$filter="+url.QueryEscape("location eq 'eastus'"), "")
+		if code != http.StatusOK {
+			t.Fatalf("expected the SKUs to be listed, got %d %v", code, list)
+		}
+		var gpu map[string]interface{}
+		for _, item := range list["value"].([]interface{}) {
+			sku := item.(map[string]interface{})
+			if locations := sku["locations"].([]interface{}); len(locations) != 1 || locations[0] != "eastus" {
+				t.Fatalf("expected only eastus SKUs, got %v", sku)
+			}
+			if sku["name"] == "Standard_NC4as_T4_v3" {
+				gpu = sku
+			}
+		}
+		restrictions, _ := gpu["restrictions"].([]interface{})
+		if len(restrictions) != 1 || restrictions[0].(map[string]interface{})["type"] != "Zone" {
+			t.Errorf("expected the GPU size to be restricted in a zone of eastus, got %v", gpu)
+		}
+
+		_, sizes := armRequest(t, mux, "GET", computePath+"/locations/westus/vmSizes", "")
+		found := map[string]map[string]interface{}{}
+		for _, item := range sizes["value"].([]interface{}) {
+			size := item.(map[string]interface{})
+			found[size["name"].(string)] = size
+		}
+		if b2s := found["Standard_B2s"]; b2s == nil || b2s["numberOfCores"] != float64(2) || b2s["memoryInMB"] != float64(4096) {
+			t.Errorf("expected Standard_B2s with 2 cores and 4 GiB, got %v", b2s)
+		}
+		if found["Standard_D16s_v3"] != nil || found["Standard_NC4as_T4_v3"] != nil {
+			t.Errorf("expected restricted and unoffered sizes to be left out of westus, got %v", sizes)
+		}
+
+		code, usages := armRequest(t, mux, "GET", computePath+"/locations/eastus/usages", "")
+		family := usageEntry(usages, "standardBSFamily")
+		if code != http.StatusOK || family == nil || family["currentValue"] != float64(4) || family["limit"] != float64(50) {
+			t.Errorf("expected the two eastus B-series VMs to use 4 vCPUs, got %d %v", code, family)
+		}
+		if cores := usageEntry(usages, "cores"); cores == nil || cores["currentValue"] != float64(4) {
+			t.Errorf("expected 4 regional vCPUs in use, got %v", cores)
+		}
+	})
+
+	t.Run("resize", func(t *testing.T) {
+		tests := []struct {
+			name, vm, size string
+			status         int
+			code           string
+		}{
+			{"unknown size", "vm-web-01", "Standard_Bogus", http.StatusBadRequest, "InvalidParameter"},
+			{"restricted zone", "vm-web-01", "Standard_NC4as_T4_v3", http.StatusConflict, "SkuNotAvailable"},
+			{"no quota", "vm-api-01", "Standard_NC4as_T4_v3", http.StatusConflict, "OperationNotAllowed"},
+			{"resize", "vm-web-01", "Standard_D4s_v5", http.StatusOK, ""},
+		}
+		for _, tt := range tests {
+			code, body := armRequest(t, mux, "PATCH", testComputePath+"/virtualMachines/"+tt.vm, `{"properties":{"hardwareProfile":{"vmSize":"`+tt.size+`"}}}`)
+			if code != tt.status || (tt.code != "" && armErrorCode(body) != tt.code) {
+				t.Errorf("%s: expected %d %s, got %d %v", tt.name, tt.status, tt.code, code, body)
+			}
+		}
+		_, usages := armRequest(t, mux, "GET", computePath+"/locations/eastus/usages", "")
+		if family := usageEntry(usages, "standardDSv5Family"); family["currentValue"] != float64(4) {
+			t.Errorf("expected the resized VM to count at its new size, got %v", family)
+		}
+	})
+
+	t.Run("scale set quota", func(t *testing.T) {
+		store.quotas = []*Quota{{Location: "East US", Name: "standardBSFamily", Limit: 6}}
+		scaleSetPath := testComputePath + "/virtualMachineScaleSets/vmss-quota"
+		body := func(size string, capacity int, zones string) string {
+			return fmt.Sprintf(`{"location":"eastus","zones":%s,"sku":{"name":"%s","capacity":%d},
+				"properties":{"virtualMachineProfile":{"storageProfile":{"osDisk":{"createOption":"FromImage"}}}}}`, zones, size, capacity)
+		}
+		code, result := armRequest(t, mux, "PUT", scaleSetPath, body("Standard_B2s", 3, "[]"))
+		if code != http.StatusConflict || armErrorCode(result) != "OperationNotAllowed" || !strings.Contains(result["error"].(map[string]interface{})["message"].(string), "Current Limit: 6") {
+			t.Fatalf("expected the scale set to exceed the B-series quota, got %d %v", code, result)
+		}
+		if code, result = armRequest(t, mux, "PUT", scaleSetPath, body("Standard_B2s", 2, "[]")); code != http.StatusCreated {
+			t.Fatalf("expected two instances to fit the quota, got %d %v", code, result)
+		}
+		if code, result = armRequest(t, mux, "PATCH", scaleSetPath, `{"sku":{"capacity":3}}`); code != http.StatusConflict {
+			t.Errorf("expected scaling out past the quota to fail, got %d %v", code, result)
+		}
+		if ids := scaleSetInstanceIDs(t, mux, scaleSetPath); len(ids) != 2 {
+			t.Errorf("expected the refused scale-out to keep two instances, got %v", ids)
+		}
+		code, result = armRequest(t, mux, "PUT", testComputePath+"/virtualMachineScaleSets/vmss-gpu", body("Standard_NC4as_T4_v3", 1, `["1"]`))
+		if code != http.StatusConflict || armErrorCode(result) != "SkuNotAvailable" {
+			t.Errorf("expected the GPU size to be unavailable in zone 1, got %d %v", code, result)
+		}
+	})
+
+	t.Run("configured sizes", func(t *testing.T) {
+		store.vmSizes = []*VMSize{
+			{Name: "Standard_Custom_v1", Family: "customFamily", VCPUs: 2, MemoryGB: 4, Locations: []string{"East US"}},
+			{Name: "Standard_D4s_v5", Restrictions: []SkuRestriction{{Location: "eastus"}}},
+		}
+		_, sizes := armRequest(t, mux, "GET", computePath+"/locations/eastus/vmSizes", "")
+		names := []string{}
+		for _, item := range sizes["value"].([]interface{}) {
+			names = append(names, item.(map[string]interface{})["name"].(string))
+		}
+		if !slices.Contains(names, "Standard_Custom_v1") || slices.Contains(names, "Standard_D4s_v5") {
+			t.Errorf("expected the configured size and restriction to apply, got %v", names)
+		}
+		code, body := armRequest(t, mux, "PATCH", testComputePath+"/virtualMachines/vm-api-01", `{"properties":{"hardwareProfile":{"vmSize":"Standard_D4s_v5"}}}`)
+		if code != http.StatusConflict || armErrorCode(body) != "SkuNotAvailable" {
+			t.Errorf("expected the restricted size to be refused, got %d %v", code, body)
+		}
+		if code, body := armRequest(t, mux, "PATCH", testComputePath+"/virtualMachines/vm-api-01", `{"properties":{"hardwareProfile":{"vmSize":"Standard_Custom_v1"}}}`); code != http.StatusOK {
+			t.Errorf("expected the configured size to be accepted, got %d %v", code, body)
+		}
+	})
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storeState is the mock data a state backend saves and a snapshot captures: everything the ARM,
+// Graph, Entra and Key Vault APIs change. Settings only the config file sets, such as VM sizes,
+// quotas and run command responders, are read from it on every start. Blob contents are kept by
+// the blob backend and are not part of the state.
+type storeState struct {
+	SavedAt         time.Time               `json:"savedAt"`
+	Tenants         []*Tenant               `json:"tenants"`
+	Subscriptions   []*Subscription         `json:"subscriptions"`
+	ResourceGroups  []*ResourceGroup        `json:"resourceGroups"`
+	VMs             []*MockVM               `json:"vms"`
+	ScaleSetVMs     []*ScaleSetVM           `json:"scaleSetVMs"`
+	ScaleSetNextIDs map[string]int          `json:"scaleSetNextIds"`
+	Users           []*MockUser             `json:"users"`
+	ServiceAccounts []*ServiceAccount       `json:"serviceAccounts"`
+	Secrets         []ServiceAccountSecret  `json:"secrets"`
+	Resources       []*GenericResource      `json:"resources"`
+	Deployments     []*Deployment           `json:"deployments"`
+	RunCommands     []*RunCommandInvocation `json:"runCommands"`
+	KeyVaults       []vaultState            `json:"keyVaults"`
+	Clients         []*RegisteredClient     `json:"clients"`
+}
+
+// vaultState is the secrets, keys and certificates of a configured vault
+type vaultState struct {
+	Name    string             `json:"name"`
+	Objects []vaultObjectState `json:"objects"`
+}
+
+type vaultObjectState struct {
+	Collection string                 `json:"collection"`
+	Name       string                 `json:"name"`
+	Versions   []vaultVersionState    `json:"versions"`
+	Deleted    time.Time              `json:"deleted,omitzero"`
+	Managed    bool                   `json:"managed,omitempty"`
+	Policy     *certificatePolicy     `json:"policy,omitempty"`
+	Pending    map[string]interface{} `json:"pending,omitempty"`
+}
+
+type vaultVersionState struct {
+	ID          string            `json:"id"`
+	Enabled     bool              `json:"enabled"`
+	NotBefore   int64             `json:"nbf,omitempty"`
+	Expires     int64             `json:"exp,omitempty"`
+	Created     int64             `json:"created"`
+	Updated     int64             `json:"updated"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Value       string            `json:"value,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Kty         string            `json:"kty,omitempty"`
+	Key         []byte            `json:"key,omitempty"` // PKCS #8 private key
+	KeyOps      []string          `json:"keyOps,omitempty"`
+	Cert        []byte            `json:"cert,omitempty"` // DER certificate
+}
+
+// stateBackend keeps the encoded mock data between restarts and holds named snapshots of it
+type stateBackend interface {
+	load() ([]byte, error) // nil when nothing was saved yet
+	save(data []byte) error
+	listSnapshots() ([]snapshotInfo, error)
+	loadSnapshot(name string) ([]byte, error)
+	saveSnapshot(name string, data []byte) error
+	deleteSnapshot(name string) error
+	close() error // releases what the backend holds open; a later call may reopen it
+}
+
+// snapshotInfo describes a saved snapshot
+type snapshotInfo struct {
+	Name        string    `json:"name"`
+	CreatedTime time.Time `json:"createdTime"`
+	Size        int       `json:"size"`
+}
+
+var errSnapshotNotFound = errors.New("snapshot not found")
+
+// snapshotNames are the names snapshots may have; they double as file names
+var snapshotNames = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// memoryState keeps snapshots in memory, for as long as Mockzure runs, and saves nothing
+type memoryState struct {
+	mu        sync.Mutex
+	snapshots map[string]memorySnapshot
+}
+
+type memorySnapshot struct {
+	created time.Time
+	data    []byte
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{snapshots: map[string]memorySnapshot{}}
+}
+
+func (m *memoryState) load() ([]byte, error) { return nil, nil }
+func (m *memoryState) save([]byte) error     { return nil }
+func (m *memoryState) close() error          { return nil }
+
+func (m *memoryState) listSnapshots() ([]snapshotInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := []snapshotInfo{}
+	for name, snap := range m.snapshots {
+		list = append(list, snapshotInfo{Name: name, CreatedTime: snap.created, Size: len(snap.data)})
+	}
+	return list, nil
+}
+
+func (m *memoryState) loadSnapshot(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snap, ok := m.snapshots[name]
+	if !ok {
+		return nil, errSnapshotNotFound
+	}
+	return snap.data, nil
+}
+
+func (m *memoryState) saveSnapshot(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots[name] = memorySnapshot{created: time.Now().UTC(), data: data}
+	return nil
+}
+
+func (m *memoryState) deleteSnapshot(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.snapshots[name]; !ok {
+		return errSnapshotNotFound
+	}
+	delete(m.snapshots, name)
+	return nil
+}
+
+// fileState keeps the mock data under a directory, as state.json for the current data and
+// snapshots/{name}.json for each snapshot
+type fileState struct {
+	root string
+}
+
+func (f fileState) statePath() string {
+	return filepath.Join(f.root, "state.json")
+}
+
+func (f fileState) snapshotPath(name string) string {
+	return filepath.Join(f.root, "snapshots", name+".json")
+}
+
+func (f fileState) load() ([]byte, error) {
+	data, err := os.ReadFile(f.statePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f fileState) save(data []byte) error {
+	return writeFileAtomic(f.statePath(), data)
+}
+
+func (f fileState) close() error { return nil }
+
+func (f fileState) listSnapshots() ([]snapshotInfo, error) {
+	entries, err := os.ReadDir(filepath.Join(f.root, "snapshots"))
+	if os.IsNotExist(err) {
+		return []snapshotInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []snapshotInfo{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() || !snapshotNames.MatchString(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, snapshotInfo{Name: name, CreatedTime: info.ModTime().UTC(), Size: int(info.Size())})
+	}
+	return list, nil
+}
+
+func (f fileState) loadSnapshot(name string) ([]byte, error) {
+	data, err := os.ReadFile(f.snapshotPath(name))
+	if os.IsNotExist(err) {
+		return nil, errSnapshotNotFound
+	}
+	return data, err
+}
+
+func (f fileState) saveSnapshot(name string, data []byte) error {
+	return writeFileAtomic(f.snapshotPath(name), data)
+}
+
+func (f fileState) deleteSnapshot(name string) error {
+	err := os.Remove(f.snapshotPath(name))
+	if os.IsNotExist(err) {
+		return errSnapshotNotFound
+	}
+	return err
+}
+
+// newStateBackend returns the backend that keeps the mock data under dir: JSON files, or a bbolt
+// database when kind is "bolt"
+func newStateBackend(dir, kind string) (stateBackend, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("stateBackend %q needs a stateDir", kind)
+	}
+	switch strings.ToLower(kind) {
+	case "", "files":
+		return fileState{root: dir}, nil
+	case "bolt":
+		return newBoltState(dir), nil
+	}
+	return nil, fmt.Errorf("invalid stateBackend %q: must be files or bolt", kind)
+}
+
+// encodeState captures the mock data as JSON
+func (s *Store) encodeState() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.encodeStateLocked()
+}
+
+// encodeStateLocked is encodeState for callers that hold s.mu
+func (s *Store) encodeStateLocked() ([]byte, error) {
+	state := &storeState{
+		SavedAt:         time.Now().UTC(),
+		Tenants:         s.tenants,
+		Subscriptions:   s.subscriptions,
+		ResourceGroups:  s.resourceGroups,
+		VMs:             s.vms,
+		ScaleSetVMs:     s.scaleSetVMs,
+		ScaleSetNextIDs: s.scaleSetNextIDs,
+		Users:           s.users,
+		ServiceAccounts: s.serviceAccounts,
+		Resources:       s.resources,
+		Deployments:     s.deployments,
+		RunCommands:     s.runCommands,
+		KeyVaults:       []vaultState{},
+		Clients:         []*RegisteredClient{},
+	}
+	if s.config != nil {
+		state.Secrets = s.config.ServiceAccounts
+	}
+	for _, vault := range s.keyVaults {
+		vs, err := encodeVault(vault)
+		if err != nil {
+			return nil, fmt.Errorf("key vault %s: %w", vault.Name, err)
+		}
+		state.KeyVaults = append(state.KeyVaults, vs)
+	}
+	for _, c := range s.clients {
+		state.Clients = append(state.Clients, c)
+	}
+	sort.Slice(state.Clients, func(i, j int) bool { return state.Clients[i].ClientID < state.Clients[j].ClientID })
+	return json.MarshalIndent(state, "", "  ")
+}
+
+func encodeVault(vault *KeyVault) (vaultState, error) {
+	vs := vaultState{Name: vault.Name, Objects: []vaultObjectState{}}
+	keys := make([]string, 0, len(vault.objects))
+	for key := range vault.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		obj := vault.objects[key]
+		collection, _, _ := strings.Cut(key, "/")
+		saved := vaultObjectState{Collection: collection, Name: obj.name, Deleted: obj.deleted, Managed: obj.managed, Policy: obj.policy, Pending: obj.pending}
+		for _, v := range obj.versions {
+			version := vaultVersionState{
+				ID: v.id, Enabled: v.enabled, NotBefore: v.notBefore, Expires: v.expires, Created: v.created, Updated: v.updated,
+				Tags: v.tags, Value: v.value, ContentType: v.contentType, Kty: v.kty, KeyOps: v.keyOps, Cert: v.cert,
+			}
+			if v.key != nil {
+				der, err := x509.MarshalPKCS8PrivateKey(v.key)
+				if err != nil {
+					return vs, fmt.Errorf("key %s: %w", obj.name, err)
+				}
+				version.Key = der
+			}
+			saved.Versions = append(saved.Versions, version)
+		}
+		vs.Objects = append(vs.Objects, saved)
+	}
+	return vs, nil
+}
+
+// decodeVaultObjects rebuilds the objects of a vault from their saved state
+func decodeVaultObjects(vs vaultState) (map[string]*vaultObject, error) {
+	objects := map[string]*vaultObject{}
+	for _, saved := range vs.Objects {
+		obj := &vaultObject{name: saved.Name, deleted: saved.Deleted, managed: saved.Managed, policy: saved.Policy, pending: saved.Pending}
+		for _, v := range saved.Versions {
+			version := &vaultVersion{
+				id: v.ID, enabled: v.Enabled, notBefore: v.NotBefore, expires: v.Expires, created: v.Created, updated: v.Updated,
+				tags: v.Tags, value: v.Value, contentType: v.ContentType, kty: v.Kty, keyOps: v.KeyOps, cert: v.Cert,
+			}
+			if v.Key != nil {
+				key, err := x509.ParsePKCS8PrivateKey(v.Key)
+				if err != nil {
+					return nil, fmt.Errorf("key %s: %w", saved.Name, err)
+				}
+				signer, ok := key.(crypto.Signer)
+				if !ok {
+					return nil, fmt.Errorf("key %s: unsupported key type %T", saved.Name, key)
+				}
+				version.key = signer
+			}
+			obj.versions = append(obj.versions, version)
+		}
+		objects[saved.Collection+"/"+strings.ToLower(saved.Name)] = obj
+	}
+	return objects, nil
+}
+
+// applyState replaces the mock data with a saved state. Vaults are configured in the config file;
+// those the state does not mention keep their objects. When the state cannot be decoded the store
+// is left as it was.
+func (s *Store) applyState(data []byte) error {
+	var state storeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parse state: %w", err)
+	}
+	vaultObjects := map[string]map[string]*vaultObject{}
+	for _, vs := range state.KeyVaults {
+		objects, err := decodeVaultObjects(vs)
+		if err != nil {
+			return fmt.Errorf("key vault %s: %w", vs.Name, err)
+		}
+		vaultObjects[strings.ToLower(vs.Name)] = objects
+	}
+	if state.ScaleSetNextIDs == nil {
+		state.ScaleSetNextIDs = map[string]int{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tenants = state.Tenants
+	s.subscriptions = state.Subscriptions
+	s.resourceGroups = state.ResourceGroups
+	s.vms = state.VMs
+	s.scaleSetVMs = state.ScaleSetVMs
+	s.scaleSetNextIDs = state.ScaleSetNextIDs
+	s.users = state.Users
+	s.serviceAccounts = state.ServiceAccounts
+	s.config = &ServiceAccountConfig{ServiceAccounts: state.Secrets}
+	s.resources = state.Resources
+	s.deployments = state.Deployments
+	s.runCommands = state.RunCommands
+	s.changed()
+	for _, vault := range s.keyVaults {
+		if objects, ok := vaultObjects[strings.ToLower(vault.Name)]; ok {
+			vault.objects = objects
+		}
+	}
+	s.clients = make(map[string]*RegisteredClient, len(state.Clients))
+	for _, c := range state.Clients {
+		s.clients[c.ClientID] = c
+	}
+	return nil
+}
+
+// loadSavedState replaces the data loaded from the config file with the data the state backend
+// saved, when it saved any
+func (s *Store) loadSavedState() error {
+	data, err := s.stateBackend.load()
+	if err != nil || data == nil {
+		return err
+	}
+	if err := s.applyState(data); err != nil {
+		return err
+	}
+	log.Printf("Restored saved mock data: %d RGs, %d VMs, %d resources, %d users",
+		len(s.resourceGroups), len(s.vms), len(s.resources), len(s.users))
+	return nil
+}
+
+// changed records a change to the data the state backend saves. The caller must hold s.mu for
+// writing.
+func (s *Store) changed() {
+	s.changes++
+}
+
+// persist saves the mock data to the state backend when it changed since the last save. Nothing
+// is written when the backend keeps data in memory. Saves are made one at a time, each of the
+// data as it is when the save starts, so an older state never replaces a newer one.
+func (s *Store) persist() error {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	s.mu.RLock()
+	backend, changes := s.stateBackend, s.changes
+	_, inMemory := backend.(*memoryState)
+	if inMemory || changes == s.savedChanges {
+		s.mu.RUnlock()
+		return nil
+	}
+	data, err := s.encodeStateLocked()
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := backend.save(data); err != nil {
+		return err
+	}
+	s.savedChanges = changes
+	return nil
+}
+
+// persistState saves the mock data after every request that changed it
+func persistState(store *Store, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		if err := store.persist(); err != nil {
+			log.Printf("Failed to save mock data: %v", err)
+		}
+	})
+}
+
+// writeAdminJSON writes a response of the admin API, logging encoding failures
+func writeAdminJSON(w http.ResponseWriter, status int, body map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string, err error) {
+	writeAdminJSON(w, status, map[string]interface{}{"message": message, "error": err.Error(), "status": "error"})
+}
+
+// closeState closes the state backend, once the server has stopped
+func (s *Store) closeState() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stateBackend.close()
+}
+
+// currentStateBackend returns the state backend; a reload may swap it
+func (s *Store) currentStateBackend() stateBackend {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stateBackend
+}
+
+// serveSnapshots handles the snapshot admin API:
+//
+//	GET    /mock/azure/admin/snapshots                 - list snapshots
+//	POST   /mock/azure/admin/snapshots                 - save a snapshot named by {"name": ...} or ?name=
+//	DELETE /mock/azure/admin/snapshots/{name}          - delete a snapshot
+//	POST   /mock/azure/admin/snapshots/{name}/restore  - replace the mock data with a snapshot
+func serveSnapshots(w http.ResponseWriter, r *http.Request, store *Store) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/mock/azure/admin/snapshots"), "/")
+	name, action, _ := strings.Cut(rest, "/")
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		list, err := store.currentStateBackend().listSnapshots()
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, "Failed to list snapshots", err)
+			return
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		writeAdminJSON(w, http.StatusOK, map[string]interface{}{"value": list})
+	case name == "" && r.Method == http.MethodPost:
+		name = r.URL.Query().Get("name")
+		if name == "" && r.ContentLength != 0 {
+			var body struct {
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeAdminError(w, http.StatusBadRequest, "Invalid snapshot request", err)
+				return
+			}
+			name = body.Name
+		}
+		if name == "" {
+			name = "snapshot-" + time.Now().UTC().Format("20060102T150405.000Z")
+		}
+		saveSnapshot(w, store, name)
+	case name != "" && action == "" && r.Method == http.MethodDelete:
+		if !checkSnapshotName(w, name) {
+			return
+		}
+		if err := store.currentStateBackend().deleteSnapshot(name); err != nil {
+			writeSnapshotLookupError(w, name, "delete", err)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Snapshot %s deleted", name), "name": name, "status": "success"})
+	case name != "" && action == "restore" && r.Method == http.MethodPost:
+		if !checkSnapshotName(w, name) {
+			return
+		}
+		data, err := store.currentStateBackend().loadSnapshot(name)
+		if err != nil {
+			writeSnapshotLookupError(w, name, "restore", err)
+			return
+		}
+		if err := store.applyState(data); err != nil {
+			log.Printf("Restoring snapshot %s failed, keeping the current data: %v", name, err)
+			writeAdminError(w, http.StatusUnprocessableEntity, "Restore failed; the current data is kept", err)
+			return
+		}
+		log.Printf("Restored snapshot %s", name)
+		writeAdminJSON(w, http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Snapshot %s restored", name), "name": name, "status": "success"})
+	case name == "" || action == "" || action == "restore":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func saveSnapshot(w http.ResponseWriter, store *Store, name string) {
+	if !checkSnapshotName(w, name) {
+		return
+	}
+	data, err := store.encodeState()
+	if err == nil {
+		err = store.currentStateBackend().saveSnapshot(name, data)
+	}
+	if err != nil {
+		log.Printf("Saving snapshot %s failed: %v", name, err)
+		writeAdminError(w, http.StatusInternalServerError, "Failed to save snapshot", err)
+		return
+	}
+	log.Printf("Saved snapshot %s", name)
+	writeAdminJSON(w, http.StatusCreated, map[string]interface{}{"message": fmt.Sprintf("Snapshot %s saved", name), "name": name, "status": "success"})
+}
+
+// checkSnapshotName rejects names that are not usable as file names
+func checkSnapshotName(w http.ResponseWriter, name string) bool {
+	if snapshotNames.MatchString(name) {
+		return true
+	}
+	writeAdminError(w, http.StatusBadRequest, "Invalid snapshot name",
+		fmt.Errorf("snapshot name %q must be 1-64 letters, digits, dots, dashes or underscores, starting with a letter or digit", name))
+	return false
+}
+
+func writeSnapshotLookupError(w http.ResponseWriter, name, verb string, err error) {
+	if errors.Is(err, errSnapshotNotFound) {
+		writeAdminError(w, http.StatusNotFound, fmt.Sprintf("Snapshot %s not found", name), err)
+		return
+	}
+	log.Printf("Failed to %s snapshot %s: %v", verb, name, err)
+	writeAdminError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to %s snapshot", verb), err)
+}
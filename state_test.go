@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestSnapshots(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+	rgPath := testSubscriptionPath + "/resourcegroups/rg-snap"
+
+	if code, body := postAdmin(t, mux, "/mock/azure/admin/snapshots?name=base"); code != http.StatusCreated || body["name"] != "base" {
+		t.Fatalf("expected the snapshot to be saved, got %d %v", code, body)
+	}
+	if code, body := armRequest(t, mux, "PUT", rgPath, `{"location":"westeurope"}`); code != http.StatusCreated {
+		t.Fatalf("expected the resource group to be created, got %d %v", code, body)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/mock/azure/admin/snapshots", strings.NewReader(`{"name":"with-rg"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the named snapshot to be saved, got %d %s", w.Code, w.Body.String())
+	}
+
+	// Snapshots survive a reset to the config
+	if code, body := postAdmin(t, mux, "/mock/azure/data/reset"); code != http.StatusOK {
+		t.Fatalf("expected the reset to succeed, got %d %v", code, body)
+	}
+	if code, body := postAdmin(t, mux, "/mock/azure/admin/snapshots/with-rg/restore"); code != http.StatusOK || body["status"] != "success" {
+		t.Fatalf("expected the restore to succeed, got %d %v", code, body)
+	}
+	if code, body := armRequest(t, mux, "GET", rgPath, ""); code != http.StatusOK {
+		t.Errorf("expected the snapshot's resource group, got %d %v", code, body)
+	}
+	postAdmin(t, mux, "/mock/azure/admin/snapshots/base/restore")
+	if code, _ := armRequest(t, mux, "GET", rgPath, ""); code != http.StatusNotFound {
+		t.Errorf("expected the resource group to be rolled back, got %d", code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/mock/azure/admin/snapshots", nil))
+	var list struct {
+		Value []snapshotInfo `json:"value"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Value) != 2 || list.Value[0].Name != "base" || list.Value[1].Name != "with-rg" {
+		t.Errorf("expected both snapshots to be listed, got %s", w.Body.String())
+	}
+
+	for path, want := range map[string]int{
+		"/mock/azure/admin/snapshots/missing/restore": http.StatusNotFound,
+		"/mock/azure/admin/snapshots?name=../escape":  http.StatusBadRequest,
+		"/mock/azure/admin/snapshots/base":            http.StatusMethodNotAllowed,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != want {
+			t.Errorf("POST %s: expected %d, got %d %s", path, want, w.Code, w.Body.String())
+		}
+	}
+
+	// Restores swap the data while spec-driven routes read it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			postAdmin(t, mux, "/mock/azure/admin/snapshots/base/restore")
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		for _, path := range []string{"/subscriptions", "/tenants", testSubscriptionPath + "/resourcegroups"} {
+			if code, body := armRequest(t, mux, "GET", path, ""); code != http.StatusOK {
+				t.Errorf("GET %s during a restore: expected 200, got %d %v", path, code, body)
+			}
+		}
+	}
+	<-done
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/mock/azure/admin/snapshots/base", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the snapshot to be deleted, got %d %s", w.Code, w.Body.String())
+	}
+	if code, _ := postAdmin(t, mux, "/mock/azure/admin/snapshots/base/restore"); code != http.StatusNotFound {
+		t.Errorf("expected the deleted snapshot to be gone, got %d", code)
+	}
+}
+
+func TestStateDir(t *testing.T) {
+	for _, backend := range []string{"files", "bolt"} {
+		t.Run(backend, func(t *testing.T) { testStateDir(t, backend) })
+	}
+
+	t.Run("invalid backends", func(t *testing.T) {
+		for config, want := range map[string]string{
+			"stateBackend: bolt\n":                      "needs a stateDir",
+			"stateDir: /tmp/state\nstateBackend: sql\n": `invalid stateBackend "sql"`,
+		} {
+			problems := validateConfig([]byte(config))
+			if len(problems) != 1 || !strings.Contains(problems[0].String(), want) {
+				t.Errorf("%q: expected a problem containing %q, got %v", config, want, problems)
+			}
+		}
+	})
+}
+
+// testStateDir checks that a state backend keeps the mock data across restarts, reloads and resets
+func testStateDir(t *testing.T, backend string) {
+	data, err := os.ReadFile("config.yaml.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+	config := string(data) + "\nstateDir: " + stateDir + "\nstateBackend: " + backend + "\nkeyVaults:\n  - name: kv-state\n"
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rgPath := testSubscriptionPath + "/resourcegroups/rg-saved"
+
+	store := &Store{configPath: configPath}
+	store.init()
+	mux := persistState(store, newMux(store, "mockzure-specs"))
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := store.keyVaults[0].objectFor(keyKind, "signing")
+	obj.versions = append(obj.versions, &vaultVersion{id: "v1", enabled: true, kty: "EC", key: key, keyOps: []string{"sign"}})
+
+	// Requests that change nothing do not save
+	armRequest(t, mux, "POST", "/providers/Microsoft.ResourceGraph/resources?api-version=2021-03-01", `{"query":"Resources"}`)
+	if saved, err := store.stateBackend.load(); saved != nil || err != nil {
+		t.Errorf("expected a query to leave the state unsaved, got %d bytes, %v", len(saved), err)
+	}
+	if code, body := armRequest(t, mux, "PUT", rgPath, `{"location":"westeurope"}`); code != http.StatusCreated {
+		t.Fatalf("expected the resource group to be created, got %d %v", code, body)
+	}
+	if code, body := postAdmin(t, mux, "/mock/azure/admin/snapshots?name=saved"); code != http.StatusCreated {
+		t.Fatalf("expected the snapshot to be saved, got %d %v", code, body)
+	}
+	onDisk, err := newStateBackend(stateDir, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.stateBackend != onDisk {
+		t.Errorf("expected the %s backend, got %#v", backend, store.stateBackend)
+	}
+	if _, err := onDisk.loadSnapshot("saved"); err != nil {
+		t.Errorf("expected the snapshot on disk: %v", err)
+	}
+	if list, err := onDisk.listSnapshots(); err != nil || len(list) != 1 || list[0].Name != "saved" || list[0].CreatedTime.IsZero() {
+		t.Errorf("expected the snapshot to be listed, got %+v, %v", list, err)
+	}
+
+	// A restart picks up the saved data rather than the config
+	restarted := &Store{configPath: configPath}
+	restarted.init()
+	mux = persistState(restarted, newMux(restarted, "mockzure-specs"))
+	if code, body := armRequest(t, mux, "GET", rgPath, ""); code != http.StatusOK {
+		t.Errorf("expected the saved resource group after a restart, got %d %v", code, body)
+	}
+	saved := restarted.keyVaults[0].object(keyKind, "signing")
+	if saved == nil || len(saved.versions) != 1 || !key.Equal(saved.versions[0].key) {
+		t.Errorf("expected the vault key to survive the restart, got %+v", saved)
+	}
+
+	// A reload keeps the saved data as well, and still applies the config's settings
+	if err := os.WriteFile(configPath, []byte(config+"\nquotas:\n  - name: cores\n    limit: 7\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := postAdmin(t, mux, "/mock/azure/admin/reload"); code != http.StatusOK {
+		t.Fatalf("expected the reload to succeed, got %d %v", code, body)
+	}
+	if code, body := armRequest(t, mux, "GET", rgPath, ""); code != http.StatusOK {
+		t.Errorf("expected the saved resource group after a reload, got %d %v", code, body)
+	}
+	if saved := restarted.keyVaults[0].object(keyKind, "signing"); saved == nil || len(saved.versions) != 1 {
+		t.Errorf("expected the vault key to survive the reload, got %+v", saved)
+	}
+	if len(restarted.quotas) == 0 {
+		t.Errorf("expected the reloaded config's quotas")
+	}
+
+	// A reset is saved too, and snapshots outlive it
+	postAdmin(t, mux, "/mock/azure/data/reset")
+	restarted = &Store{configPath: configPath}
+	restarted.init()
+	mux = persistState(restarted, newMux(restarted, "mockzure-specs"))
+	if code, _ := armRequest(t, mux, "GET", rgPath, ""); code != http.StatusNotFound {
+		t.Errorf("expected the reset data after a restart, got %d", code)
+	}
+	postAdmin(t, mux, "/mock/azure/admin/snapshots/saved/restore")
+	if code, body := armRequest(t, mux, "GET", rgPath, ""); code != http.StatusOK {
+		t.Errorf("expected the snapshot's resource group, got %d %v", code, body)
+	}
+
+	// Closing the backend releases the database, and a later request opens it again
+	if err := restarted.closeState(); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := onDisk.(boltState); ok {
+		db, err := bolt.Open(b.db.path, 0o644, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			t.Fatalf("expected the closed database to be free: %v", err)
+		}
+		db.Close()
+	}
+	if code, body := postAdmin(t, mux, "/mock/azure/admin/snapshots?name=reopened"); code != http.StatusCreated {
+		t.Errorf("expected a snapshot after the close, got %d %v", code, body)
+	}
+	restarted.closeState()
+}
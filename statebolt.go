@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of a bolt state database
+var (
+	boltStateBucket         = []byte("state")          // holds the current data under boltCurrentKey
+	boltSnapshotBucket      = []byte("snapshots")      // snapshot data by name
+	boltSnapshotTimesBucket = []byte("snapshot-times") // snapshot creation times by name, in RFC 3339
+	boltCurrentKey          = []byte("current")
+)
+
+// boltStateTimeout bounds the wait for the database file lock
+const boltStateTimeout = 10 * time.Second
+
+// boltState keeps the mock data and its snapshots in a bbolt database, state.db under a
+// directory. The database stays open between operations; the boltStates of one directory share
+// it, since bbolt locks the file while it is open and a reload builds its new store while the old
+// one still serves requests.
+type boltState struct {
+	root string
+	db   *boltDB
+}
+
+// boltDB is the open database of a state directory
+type boltDB struct {
+	path string
+	mu   sync.RWMutex // held for reading during transactions, for writing to open or close db
+	db   *bolt.DB     // nil until first used and after close
+}
+
+// boltDBs holds the database of each state directory by path
+var boltDBs = struct {
+	sync.Mutex
+	byPath map[string]*boltDB
+}{byPath: map[string]*boltDB{}}
+
+func newBoltState(root string) boltState {
+	path := filepath.Join(root, "state.db")
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	boltDBs.Lock()
+	defer boltDBs.Unlock()
+	db := boltDBs.byPath[path]
+	if db == nil {
+		db = &boltDB{path: path}
+		boltDBs.byPath[path] = db
+	}
+	return boltState{root: root, db: db}
+}
+
+// use runs fn with the database, opening it first when needed
+func (d *boltDB) use(fn func(db *bolt.DB) error) error {
+	for {
+		d.mu.RLock()
+		if d.db != nil {
+			defer d.mu.RUnlock()
+			return fn(d.db)
+		}
+		d.mu.RUnlock()
+		if err := d.open(); err != nil {
+			return err
+		}
+	}
+}
+
+// open opens the database, creating it and its buckets on first use
+func (d *boltDB) open() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.db != nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0o755); err != nil {
+		return err
+	}
+	db, err := bolt.Open(d.path, 0o644, &bolt.Options{Timeout: boltStateTimeout})
+	if err != nil {
+		return fmt.Errorf("open %s: %w", d.path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltStateBucket, boltSnapshotBucket, boltSnapshotTimesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	d.db = db
+	return nil
+}
+
+func (b boltState) view(fn func(tx *bolt.Tx) error) error {
+	return b.db.use(func(db *bolt.DB) error { return db.View(fn) })
+}
+
+func (b boltState) update(fn func(tx *bolt.Tx) error) error {
+	return b.db.use(func(db *bolt.DB) error { return db.Update(fn) })
+}
+
+// close closes the database once its transactions are done; a later operation opens it again
+func (b boltState) close() error {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+	if b.db.db == nil {
+		return nil
+	}
+	err := b.db.db.Close()
+	b.db.db = nil
+	return err
+}
+
+func (b boltState) load() ([]byte, error) {
+	var data []byte
+	err := b.view(func(tx *bolt.Tx) error {
+		// Values are only valid during the transaction
+		if value := tx.Bucket(boltStateBucket).Get(boltCurrentKey); value != nil {
+			data = append([]byte{}, value...)
+		}
+		return nil
+	})
+	return data, err
+}
+
+func (b boltState) save(data []byte) error {
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltStateBucket).Put(boltCurrentKey, data)
+	})
+}
+
+func (b boltState) listSnapshots() ([]snapshotInfo, error) {
+	list := []snapshotInfo{}
+	err := b.view(func(tx *bolt.Tx) error {
+		times := tx.Bucket(boltSnapshotTimesBucket)
+		return tx.Bucket(boltSnapshotBucket).ForEach(func(name, data []byte) error {
+			created, _ := time.Parse(time.RFC3339Nano, string(times.Get(name)))
+			list = append(list, snapshotInfo{Name: string(name), CreatedTime: created, Size: len(data)})
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (b boltState) loadSnapshot(name string) ([]byte, error) {
+	var data []byte
+	err := b.view(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltSnapshotBucket).Get([]byte(name))
+		if value == nil {
+			return errSnapshotNotFound
+		}
+		data = append([]byte{}, value...)
+		return nil
+	})
+	return data, err
+}
+
+func (b boltState) saveSnapshot(name string, data []byte) error {
+	return b.update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltSnapshotBucket).Put([]byte(name), data); err != nil {
+			return err
+		}
+		return tx.Bucket(boltSnapshotTimesBucket).Put([]byte(name), []byte(time.Now().UTC().Format(time.RFC3339Nano)))
+	})
+}
+
+func (b boltState) deleteSnapshot(name string) error {
+	return b.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltSnapshotBucket).Get([]byte(name)) == nil {
+			return errSnapshotNotFound
+		}
+		if err := tx.Bucket(boltSnapshotBucket).Delete([]byte(name)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltSnapshotTimesBucket).Delete([]byte(name))
+	})
+}
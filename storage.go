@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultBlobHost    = "blob.core.windows.net"
+	defaultBlobPath    = "/blob"
+	storageAudience    = "https://storage.azure.com"
+	storageAccountType = "Microsoft.Storage/storageAccounts"
+
+	// storageKeySeed derives the account keys, so that they are stable across restarts
+	storageKeySeed = "mockzure-storage-account-key"
+)
+
+// storagePattern matches the storage account list routes and the key listing action. Single
+// storage accounts are served by the generic resource routes.
+var storagePattern = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)(?:/resourcegroups/([^/]+))?/providers/Microsoft\.Storage/storageAccounts(?:/([^/]+)/(listKeys))?/?$`)
+
+// Data actions of the Blob service, checked against the roles of bearer token callers
+const (
+	blobContainerRead   = "Microsoft.Storage/storageAccounts/blobServices/containers/read"
+	blobContainerWrite  = "Microsoft.Storage/storageAccounts/blobServices/containers/write"
+	blobContainerDelete = "Microsoft.Storage/storageAccounts/blobServices/containers/delete"
+	blobRead            = "Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read"
+	blobWrite           = "Microsoft.Storage/storageAccounts/blobServices/containers/blobs/write"
+	blobDelete          = "Microsoft.Storage/storageAccounts/blobServices/containers/blobs/delete"
+)
+
+// builtinStorageRoles are the actions of the built-in Blob data roles, by lowercased role name
+var builtinStorageRoles = map[string][]string{
+	"storage blob data reader": {blobContainerRead, blobRead},
+	"storage blob data contributor": {blobContainerRead, blobContainerWrite, blobContainerDelete,
+		blobRead, blobWrite, blobDelete, "Microsoft.Storage/storageAccounts/blobServices/containers/blobs/add/action"},
+	"storage blob data owner": {"Microsoft.Storage/storageAccounts/blobServices/containers/*"},
+}
+
+// blobCaller is the authenticated caller of a Blob service request
+type blobCaller struct {
+	sharedKey bool            // signed with an account key, which grants every operation
+	anonymous bool            // sent without credentials, which only reaches public containers
+	roles     []MockAzureRole // role assignments of a bearer token's principal
+}
+
+// storageRoute matches the storage account list and key routes, returning nil when the request does not
+func storageRoute(r *http.Request) *armRoute {
+	matches := storagePattern.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		return nil
+	}
+	subscriptionID, resourceGroup, name := matches[1], matches[2], matches[3]
+
+	pattern := "/subscriptions/{subscriptionId}"
+	params := map[string]string{"subscriptionId": subscriptionID}
+	if resourceGroup != "" {
+		pattern += "/resourceGroups/{resourceGroupName}"
+		params["resourceGroupName"] = resourceGroup
+	}
+	pattern += "/providers/Microsoft.Storage/storageAccounts"
+
+	var op string
+	switch {
+	case name == "" && r.Method == http.MethodGet && resourceGroup != "":
+		op = "StorageAccounts_ListByResourceGroup"
+	case name == "" && r.Method == http.MethodGet:
+		op = "StorageAccounts_List"
+	case name != "" && resourceGroup != "" && r.Method == http.MethodPost:
+		pattern += "/{accountName}/listKeys"
+		params["accountName"] = name
+		op = "StorageAccounts_ListKeys"
+	}
+	if op == "" {
+		return nil
+	}
+	return &armRoute{operationID: op, pattern: pattern, params: params}
+}
+
+// BlobEndpoint returns the blob endpoint reported for a storage account
+func (s *Store) BlobEndpoint(accountName string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.blobEndpoint(accountName)
+}
+
+// blobEndpoint is BlobEndpoint for callers that hold s.mu
+func (s *Store) blobEndpoint(accountName string) string {
+	return fmt.Sprintf("https://%s.%s/", strings.ToLower(accountName), s.blobHost)
+}
+
+// StorageAccountKeys returns the two access keys of a storage account. Keys are derived from the
+// account ID, so a recreated account gets the same keys.
+func (s *Store) StorageAccountKeys(accountID string) []string {
+	keys := make([]string, 2)
+	for i := range keys {
+		mac := hmac.New(sha512.New, []byte(storageKeySeed))
+		fmt.Fprintf(mac, "%s/key%d", strings.ToLower(accountID), i+1)
+		keys[i] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return keys
+}
+
+// findStorageAccount looks a storage account up by name, ignoring case
+func (s *Store) findStorageAccount(name string) *GenericResource {
+	for _, r := range s.resources {
+		if strings.EqualFold(r.Type, storageAccountType) && strings.EqualFold(r.Name, name) {
+			return r
+		}
+	}
+	return nil
+}
+
+// storageAccountForRequest finds the storage account a Blob service request is addressed to, by
+// host name or path prefix. It returns the service endpoint and the path below it.
+func (s *Store) storageAccountForRequest(r *http.Request) (*GenericResource, string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if suffix := "." + s.blobHost; s.blobHost != "" && len(host) > len(suffix) && strings.HasSuffix(strings.ToLower(host), strings.ToLower(suffix)) {
+		if account := s.findStorageAccount(host[:len(host)-len(suffix)]); account != nil {
+			return account, baseURL(r) + "/", r.URL.Path
+		}
+	}
+	if prefix := s.blobPath + "/"; s.blobPath != "" && strings.HasPrefix(r.URL.Path, prefix) {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		if account := s.findStorageAccount(name); account != nil {
+			return account, baseURL(r) + prefix + account.Name + "/", "/" + rest
+		}
+	}
+	return nil, "", ""
+}
+
+// storageAccountTenant is the tenant of the subscription a storage account belongs to
+func (s *Store) storageAccountTenant(account *GenericResource) string {
+	if sub := s.findSubscription(subscriptionFromResourceID(account.ID)); sub != nil && sub.TenantID != "" {
+		return sub.TenantID
+	}
+	return s.tenants[0].ID
+}
+
+// blobCaller authenticates a Blob service request signed with an account key or carrying a
+// Mockzure-issued storage token. Requests without credentials pass as anonymous, for public
+// containers.
+func (s *Store) blobCaller(w http.ResponseWriter, r *http.Request, account *GenericResource) (*blobCaller, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tenantID := s.storageAccountTenant(account)
+	fail := func(code, message, detail string) (*blobCaller, bool) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer authorization_uri=%s/%s/oauth2/authorize resource_id=%s", baseURL(r), tenantID, storageAudience))
+		writeBlobError(w, r, http.StatusUnauthorized, code, message, detail)
+		return nil, false
+	}
+
+	auth := r.Header.Get("Authorization")
+	scheme, credentials, _ := strings.Cut(auth, " ")
+	switch {
+	case auth == "":
+		return &blobCaller{anonymous: true}, true
+
+	case scheme == "SharedKey":
+		name, signature, _ := strings.Cut(credentials, ":")
+		if allowed, ok := account.Properties["allowSharedKeyAccess"].(bool); ok && !allowed {
+			writeBlobError(w, r, http.StatusForbidden, "KeyBasedAuthenticationNotPermitted", "Key based authentication is not permitted on this storage account.", "")
+			return nil, false
+		}
+		given, err := base64.StdEncoding.DecodeString(signature)
+		if err == nil && strings.EqualFold(name, account.Name) {
+			stringToSign := sharedKeyStringToSign(r, account.Name)
+			for _, key := range s.StorageAccountKeys(account.ID) {
+				secret, _ := base64.StdEncoding.DecodeString(key)
+				mac := hmac.New(sha256.New, secret)
+				mac.Write([]byte(stringToSign))
+				if hmac.Equal(mac.Sum(nil), given) {
+					return &blobCaller{sharedKey: true}, true
+				}
+			}
+		}
+		writeBlobError(w, r, http.StatusForbidden, "AuthenticationFailed",
+			"Server failed to authenticate the request. Make sure the value of Authorization header is formed correctly including the signature.",
+			"The MAC signature found in the HTTP request is not the same as any computed signature.")
+		return nil, false
+
+	case scheme == "Bearer":
+		const message = "Server failed to authenticate the request. Please refer to the information in the www-authenticate header."
+		claims, err := parseUnsignedJWT(credentials)
+		if err != nil {
+			return fail("InvalidAuthenticationInfo", message, fmt.Sprintf("Invalid bearer token: %v.", err))
+		}
+		aud, _ := claims["aud"].(string)
+		if aud = strings.TrimSuffix(aud, "/"); aud != storageAudience && !strings.EqualFold(aud+"/", s.blobEndpoint(account.Name)) {
+			return fail("InvalidAuthenticationInfo", message, "Audience validation failed. Audience did not match.")
+		}
+		if tid, _ := claims["tid"].(string); !strings.EqualFold(tid, tenantID) {
+			return fail("InvalidAuthenticationInfo", message, "Issuer validation failed. Issuer did not match.")
+		}
+		oid, _ := claims["oid"].(string)
+		appID, _ := claims["appid"].(string)
+		if claims["idtyp"] == "app" {
+			if sa := s.findServiceAccount(appID); sa != nil && sa.ID == oid {
+				return &blobCaller{roles: sa.AzureRoles}, true
+			}
+		} else {
+			for _, u := range s.users {
+				if u != nil && u.ID == oid && u.AccountEnabled {
+					return &blobCaller{roles: u.AzureRoles}, true
+				}
+			}
+		}
+		return fail("InvalidAuthenticationInfo", message, fmt.Sprintf("The principal %s was not found in tenant %s.", oid, tenantID))
+	}
+	return fail("InvalidAuthenticationInfo", "Authentication scheme "+scheme+" is not supported.", "")
+}
+
+// grants reports whether a role assignment allows a data action at a scope. Built-in Blob data
+// roles are found by name; other roles grant the actions they list, where a trailing * matches
+// any suffix. A role without a scope applies everywhere.
+func (role MockAzureRole) grants(action, scope string) bool {
+	if role.Scope != "" && role.Scope != "/" {
+		roleScope := strings.TrimSuffix(role.Scope, "/")
+		if !strings.EqualFold(roleScope, scope) && !hasIDPrefix(scope, roleScope) {
+			return false
+		}
+	}
+	actions := role.Actions
+	if builtin, ok := builtinStorageRoles[strings.ToLower(role.Name)]; ok {
+		actions = builtin
+	}
+	for _, a := range actions {
+		if prefix, wildcard := strings.CutSuffix(a, "*"); wildcard && len(action) >= len(prefix) && strings.EqualFold(action[:len(prefix)], prefix) {
+			return true
+		}
+		if strings.EqualFold(a, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedKeyStringToSign builds the string a SharedKey signature covers
+// See https://learn.microsoft.com/rest/api/storageservices/authorize-with-shared-key
+func sharedKeyStringToSign(r *http.Request, accountName string) string {
+	contentLength := r.Header.Get("Content-Length")
+	if contentLength == "" && r.ContentLength > 0 {
+		contentLength = strconv.FormatInt(r.ContentLength, 10)
+	}
+	if contentLength == "0" {
+		contentLength = ""
+	}
+	lines := []string{
+		r.Method,
+		r.Header.Get("Content-Encoding"),
+		r.Header.Get("Content-Language"),
+		contentLength,
+		r.Header.Get("Content-MD5"),
+		r.Header.Get("Content-Type"),
+		r.Header.Get("Date"),
+		r.Header.Get("If-Modified-Since"),
+		r.Header.Get("If-Match"),
+		r.Header.Get("If-None-Match"),
+		r.Header.Get("If-Unmodified-Since"),
+		r.Header.Get("Range"),
+	}
+
+	names := []string{}
+	headers := map[string][]string{}
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			if _, seen := headers[name]; !seen {
+				names = append(names, name)
+			}
+			headers[name] = append(headers[name], values...)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, name+":"+strings.Join(headers[name], ","))
+	}
+
+	resource := "/" + accountName + r.URL.EscapedPath()
+	if r.URL.Path == "" {
+		resource += "/"
+	}
+	query, _ := url.ParseQuery(r.URL.RawQuery)
+	params := map[string][]string{}
+	keys := []string{}
+	for name, values := range query {
+		name = strings.ToLower(name)
+		if _, seen := params[name]; !seen {
+			keys = append(keys, name)
+		}
+		params[name] = append(params[name], values...)
+	}
+	sort.Strings(keys)
+	for _, name := range keys {
+		values := params[name]
+		sort.Strings(values)
+		resource += "\n" + name + ":" + strings.Join(values, ",")
+	}
+	return strings.Join(append(lines, resource), "\n")
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const storageAccountID = testSubscriptionPath + "/resourceGroups/rg-data/providers/Microsoft.Storage/storageAccounts/stdata"
+
+const storageConfig = `
+resourceGroups:
+  - name: rg-data
+    location: eastus
+  - name: rg-ops
+    location: eastus
+serviceAccounts:
+  - id: sp-reader
+    applicationId: reader-app
+    secret: reader-secret
+    azureRoles:
+      - name: Storage Blob Data Reader
+        scope: ` + storageAccountID + `
+  - id: sp-writer
+    applicationId: writer-app
+    secret: writer-secret
+    azureRoles:
+      - name: Storage Blob Data Contributor
+        scope: ` + storageAccountID + `/blobServices/default/containers/exports
+  - id: sp-none
+    applicationId: none-app
+    secret: none-secret
+`
+
+// blobClient sends Blob service requests to a storage account under the default path prefix
+type blobClient struct {
+	t       *testing.T
+	mux     http.Handler
+	account string
+	key     string // signs requests with SharedKey when set
+	token   string // or sends a bearer token
+}
+
+// do sends a request and returns the recorded response
+func (c *blobClient) do(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	req := httptest.NewRequest(method, "/blob/"+c.account+path, strings.NewReader(body))
+	req.Header.Set("x-ms-version", "2025-01-05")
+	req.Header.Set("x-ms-date", "Mon, 05 Jan 2026 10:00:00 GMT")
+	if body != "" {
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	switch {
+	case c.key != "":
+		req.Header.Set("Authorization", "SharedKey "+c.account+":"+testSharedKeySignature(c.t, req, c.account, c.key))
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	w := httptest.NewRecorder()
+	c.mux.ServeHTTP(w, req)
+	return w
+}
+
+// testSharedKeySignature signs a request the way the Azure SDKs do, independently of the server
+func testSharedKeySignature(t *testing.T, req *http.Request, account, key string) string {
+	t.Helper()
+	h := req.Header
+	length := h.Get("Content-Length")
+	if length == "0" {
+		length = ""
+	}
+	parts := []string{req.Method, h.Get("Content-Encoding"), h.Get("Content-Language"), length, h.Get("Content-MD5"),
+		h.Get("Content-Type"), h.Get("Date"), h.Get("If-Modified-Since"), h.Get("If-Match"), h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"), h.Get("Range")}
+	names := []string{}
+	for name := range h {
+		if strings.HasPrefix(strings.ToLower(name), "x-ms-") {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, name+":"+h.Get(name))
+	}
+	resource := "/" + account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	params := []string{}
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(query[name], ",")
+	}
+	secret, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		t.Fatalf("invalid account key %q", key)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join(append(parts, resource), "\n")))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// blobErrorCode returns the error code of a Blob service response
+func blobErrorCode(w *httptest.ResponseRecorder) string {
+	return w.Header().Get("x-ms-error-code")
+}
+
+// newStorageStore loads the storage test config, with blobs kept under dataDir when set
+func newStorageStore(t *testing.T, dataDir string) (*Store, http.Handler) {
+	t.Helper()
+	config := storageConfig
+	if dataDir != "" {
+		config += "blobDataDir: " + dataDir + "\n"
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	store := &Store{configPath: path}
+	store.init()
+	return store, newMux(store, "mockzure-specs")
+}
+
+// createStorageAccount creates stdata through ARM and returns its first key
+func createStorageAccount(t *testing.T, mux http.Handler) string {
+	t.Helper()
+	if code, body := armRequest(t, mux, "PUT", storageAccountID, `{"location":"eastus"}`); code != http.StatusCreated {
+		t.Fatalf("create storage account: %d %v", code, body)
+	}
+	code, body := armRequest(t, mux, "POST", storageAccountID+"/listKeys", "")
+	keys, _ := body["keys"].([]interface{})
+	if code != http.StatusOK || len(keys) != 2 {
+		t.Fatalf("list keys: %d %v", code, body)
+	}
+	return keys[0].(map[string]interface{})["value"].(string)
+}
+
+func TestStorageAccounts(t *testing.T) {
+	_, mux := newStorageStore(t, "")
+	key := createStorageAccount(t, mux)
+
+	code, account := armRequest(t, mux, "GET", storageAccountID, "")
+	props, _ := account["properties"].(map[string]interface{})
+	endpoints, _ := props["primaryEndpoints"].(map[string]interface{})
+	if code != http.StatusOK || account["kind"] != "StorageV2" || props["provisioningState"] != "Succeeded" || endpoints["blob"] != "https://stdata.blob.core.windows.net/" {
+		t.Errorf("expected a provisioned StorageV2 account with a blob endpoint, got %d %v", code, account)
+	}
+	if code, body := armRequest(t, mux, "POST", storageAccountID+"/listKeys", ""); code != http.StatusOK || body["keys"].([]interface{})[0].(map[string]interface{})["value"] != key {
+		t.Errorf("expected stable account keys, got %d %v", code, body)
+	}
+	if code, body := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-data/providers/Microsoft.Storage/storageAccounts", ""); code != http.StatusOK || len(body["value"].([]interface{})) != 1 {
+		t.Errorf("expected the account to be listed, got %d %v", code, body)
+	}
+	if code, body := armRequest(t, mux, "PUT", testSubscriptionPath+"/resourceGroups/rg-data/providers/Microsoft.Storage/storageAccounts/Bad_Name", `{"location":"eastus"}`); code != http.StatusBadRequest || armErrorCode(body) != "AccountNameInvalid" {
+		t.Errorf("expected AccountNameInvalid, got %d %v", code, body)
+	}
+	if code, body := armRequest(t, mux, "PUT", testSubscriptionPath+"/resourceGroups/rg-ops/providers/Microsoft.Storage/storageAccounts/stdata", `{"location":"eastus"}`); code != http.StatusConflict || armErrorCode(body) != "StorageAccountAlreadyTaken" {
+		t.Errorf("expected StorageAccountAlreadyTaken, got %d %v", code, body)
+	}
+}
+
+func TestBlobStorage(t *testing.T) {
+	store, mux := newStorageStore(t, "")
+	key := createStorageAccount(t, mux)
+	admin := &blobClient{t: t, mux: mux, account: "stdata", key: key}
+
+	t.Run("shared key", func(t *testing.T) {
+		if w := admin.do("PUT", "/exports?restype=container", "", nil); w.Code != http.StatusCreated {
+			t.Fatalf("create container: %d %s", w.Code, w.Body.String())
+		}
+		if w := admin.do("PUT", "/exports?restype=container", "", nil); w.Code != http.StatusConflict || blobErrorCode(w) != "ContainerAlreadyExists" {
+			t.Errorf("expected ContainerAlreadyExists, got %d", w.Code)
+		}
+		forged := &blobClient{t: t, mux: mux, account: "stdata", key: base64.StdEncoding.EncodeToString([]byte("not the account key"))}
+		if w := forged.do("GET", "/?comp=list", "", nil); w.Code != http.StatusForbidden || blobErrorCode(w) != "AuthenticationFailed" {
+			t.Errorf("expected a forged signature to fail, got %d", w.Code)
+		}
+
+		for _, name := range []string{"logs/2026/01/a.log", "logs/2026/02/b.log", "logs/root.log", "disk.vhd"} {
+			w := admin.do("PUT", "/exports/"+name, "data of "+name, map[string]string{"x-ms-blob-type": "BlockBlob", "x-ms-meta-source": "test"})
+			if w.Code != http.StatusCreated || w.Header().Get("ETag") == "" || w.Header().Get("Content-MD5") == "" {
+				t.Fatalf("put blob %s: %d %s", name, w.Code, w.Body.String())
+			}
+		}
+		w := admin.do("GET", "/exports?restype=container&comp=list&prefix=logs/&delimiter=/&include=metadata", "", nil)
+		var list struct {
+			Blobs []struct {
+				Name     string `xml:"Name"`
+				Metadata struct {
+					Source string `xml:"source"`
+				} `xml:"Metadata"`
+			} `xml:"Blobs>Blob"`
+			Prefixes []string `xml:"Blobs>BlobPrefix>Name"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &list); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("list blobs: %d %s", w.Code, w.Body.String())
+		}
+		if len(list.Blobs) != 1 || list.Blobs[0].Name != "logs/root.log" || list.Blobs[0].Metadata.Source != "test" ||
+			len(list.Prefixes) != 1 || list.Prefixes[0] != "logs/2026/" {
+			t.Errorf("expected logs/root.log and the logs/2026/ prefix, got %+v", list)
+		}
+
+		w = admin.do("GET", "/exports/disk.vhd", "", map[string]string{"x-ms-range": "bytes=8-11"})
+		if w.Code != http.StatusPartialContent || w.Body.String() != "disk" || w.Header().Get("Content-Range") != "bytes 8-11/16" {
+			t.Errorf("expected a ranged read, got %d %q %q", w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+		}
+		if w := admin.do("GET", "/exports/disk.vhd", "", map[string]string{"If-None-Match": admin.do("HEAD", "/exports/disk.vhd", "", nil).Header().Get("ETag")}); w.Code != http.StatusNotModified {
+			t.Errorf("expected a conditional read to answer 304, got %d", w.Code)
+		}
+		if w := admin.do("PUT", "/exports/disk.vhd", "new", map[string]string{"x-ms-blob-type": "BlockBlob", "If-None-Match": "*"}); w.Code != http.StatusConflict || blobErrorCode(w) != "BlobAlreadyExists" {
+			t.Errorf("expected BlobAlreadyExists, got %d", w.Code)
+		}
+		if w := admin.do("DELETE", "/exports/disk.vhd", "", nil); w.Code != http.StatusAccepted {
+			t.Errorf("delete blob: %d", w.Code)
+		}
+		if w := admin.do("GET", "/exports/disk.vhd", "", nil); w.Code != http.StatusNotFound || blobErrorCode(w) != "BlobNotFound" {
+			t.Errorf("expected BlobNotFound after delete, got %d", w.Code)
+		}
+	})
+
+	t.Run("block blobs", func(t *testing.T) {
+		a, b := base64.StdEncoding.EncodeToString([]byte("block-a")), base64.StdEncoding.EncodeToString([]byte("block-b"))
+		for id, data := range map[string]string{a: "hello ", b: "world"} {
+			if w := admin.do("PUT", "/exports/big.bin?comp=block&blockid="+id, data, nil); w.Code != http.StatusCreated {
+				t.Fatalf("put block: %d %s", w.Code, w.Body.String())
+			}
+		}
+		if w := admin.do("GET", "/exports/big.bin", "", nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected staged blocks to stay invisible, got %d", w.Code)
+		}
+		blockList := `<?xml version="1.0" encoding="utf-8"?><BlockList><Latest>` + a + `</Latest><Uncommitted>` + b + `</Uncommitted></BlockList>`
+		if w := admin.do("PUT", "/exports/big.bin?comp=blocklist", blockList, map[string]string{"x-ms-blob-content-type": "text/plain"}); w.Code != http.StatusCreated {
+			t.Fatalf("put block list: %d %s", w.Code, w.Body.String())
+		}
+		if w := admin.do("GET", "/exports/big.bin", "", nil); w.Body.String() != "hello world" || w.Header().Get("Content-Type") != "text/plain" {
+			t.Errorf("expected the committed blocks in order, got %q %q", w.Body.String(), w.Header().Get("Content-Type"))
+		}
+
+		// Reorder the committed blocks without uploading them again
+		blockList = `<BlockList><Committed>` + b + `</Committed><Latest>` + a + `</Latest></BlockList>`
+		if w := admin.do("PUT", "/exports/big.bin?comp=blocklist", blockList, nil); w.Code != http.StatusCreated {
+			t.Fatalf("recommit blocks: %d %s", w.Code, w.Body.String())
+		}
+		if w := admin.do("GET", "/exports/big.bin", "", nil); w.Body.String() != "worldhello " {
+			t.Errorf("expected reordered content, got %q", w.Body.String())
+		}
+		w := admin.do("GET", "/exports/big.bin?comp=blocklist&blocklisttype=all", "", nil)
+		var blocks struct {
+			Committed []string `xml:"CommittedBlocks>Block>Name"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &blocks); err != nil || len(blocks.Committed) != 2 || blocks.Committed[0] != b {
+			t.Errorf("expected the committed block list, got %d %s", w.Code, w.Body.String())
+		}
+		missing := base64.StdEncoding.EncodeToString([]byte("block-x"))
+		if w := admin.do("PUT", "/exports/big.bin?comp=blocklist", `<BlockList><Latest>`+missing+`</Latest></BlockList>`, nil); w.Code != http.StatusBadRequest || blobErrorCode(w) != "InvalidBlockList" {
+			t.Errorf("expected InvalidBlockList, got %d", w.Code)
+		}
+	})
+
+	t.Run("leases", func(t *testing.T) {
+		w := admin.do("PUT", "/exports/big.bin?comp=lease", "", map[string]string{"x-ms-lease-action": "acquire", "x-ms-lease-duration": "-1"})
+		leaseID := w.Header().Get("x-ms-lease-id")
+		if w.Code != http.StatusCreated || leaseID == "" {
+			t.Fatalf("acquire lease: %d %s", w.Code, w.Body.String())
+		}
+		if w := admin.do("PUT", "/exports/big.bin?comp=lease", "", map[string]string{"x-ms-lease-action": "acquire", "x-ms-lease-duration": "15"}); w.Code != http.StatusConflict || blobErrorCode(w) != "LeaseAlreadyPresent" {
+			t.Errorf("expected LeaseAlreadyPresent, got %d", w.Code)
+		}
+		if w := admin.do("PUT", "/exports/big.bin", "x", map[string]string{"x-ms-blob-type": "BlockBlob"}); w.Code != http.StatusPreconditionFailed || blobErrorCode(w) != "LeaseIdMissing" {
+			t.Errorf("expected LeaseIdMissing, got %d", w.Code)
+		}
+		if w := admin.do("DELETE", "/exports/big.bin", "", map[string]string{"x-ms-lease-id": "00000000-0000-0000-0000-000000000000"}); w.Code != http.StatusPreconditionFailed || blobErrorCode(w) != "LeaseIdMismatchWithBlobOperation" {
+			t.Errorf("expected LeaseIdMismatchWithBlobOperation, got %d", w.Code)
+		}
+		if w := admin.do("HEAD", "/exports/big.bin", "", nil); w.Header().Get("x-ms-lease-state") != "leased" || w.Header().Get("x-ms-lease-duration") != "infinite" {
+			t.Errorf("expected an infinite lease, got %v", w.Header())
+		}
+		if w := admin.do("PUT", "/exports/big.bin", "leased write", map[string]string{"x-ms-blob-type": "BlockBlob", "x-ms-lease-id": leaseID}); w.Code != http.StatusCreated {
+			t.Errorf("expected the lease holder to write, got %d", w.Code)
+		}
+		if w := admin.do("PUT", "/exports/big.bin?comp=lease", "", map[string]string{"x-ms-lease-action": "break"}); w.Code != http.StatusAccepted || w.Header().Get("x-ms-lease-time") != "0" {
+			t.Errorf("expected an immediate break, got %d %v", w.Code, w.Header())
+		}
+		if w := admin.do("HEAD", "/exports/big.bin", "", nil); w.Header().Get("x-ms-lease-state") != "broken" || w.Header().Get("x-ms-lease-status") != "unlocked" {
+			t.Errorf("expected a broken lease, got %v", w.Header())
+		}
+		if w := admin.do("DELETE", "/exports/big.bin", "", nil); w.Code != http.StatusAccepted {
+			t.Errorf("expected a broken lease to allow deletion, got %d", w.Code)
+		}
+
+		w = admin.do("PUT", "/exports?restype=container&comp=lease", "", map[string]string{"x-ms-lease-action": "acquire", "x-ms-lease-duration": "30"})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("acquire container lease: %d", w.Code)
+		}
+		if w := admin.do("DELETE", "/exports?restype=container", "", nil); w.Code != http.StatusPreconditionFailed || blobErrorCode(w) != "LeaseIdMissing" {
+			t.Errorf("expected a leased container to refuse deletion, got %d", w.Code)
+		}
+		if w := admin.do("PUT", "/exports?restype=container&comp=lease", "", map[string]string{"x-ms-lease-action": "release", "x-ms-lease-id": w.Header().Get("x-ms-lease-id")}); w.Code != http.StatusOK {
+			t.Errorf("release container lease: %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("bearer tokens", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		token := func(appID, scope string) string {
+			return appAccessToken(req, store.findServiceAccount(appID), defaultTenantID, scope)
+		}
+		reader := &blobClient{t: t, mux: mux, account: "stdata", token: token("reader-app", "https://storage.azure.com/.default")}
+		writer := &blobClient{t: t, mux: mux, account: "stdata", token: token("writer-app", "https://storage.azure.com/.default")}
+		nobody := &blobClient{t: t, mux: mux, account: "stdata", token: token("none-app", "https://storage.azure.com/.default")}
+		anonymous := &blobClient{t: t, mux: mux, account: "stdata"}
+
+		if w := writer.do("PUT", "/exports/report.csv", "a,b", map[string]string{"x-ms-blob-type": "BlockBlob"}); w.Code != http.StatusCreated {
+			t.Errorf("expected the contributor to write in its container, got %d %s", w.Code, w.Body.String())
+		}
+		if w := writer.do("PUT", "/other?restype=container", "", nil); w.Code != http.StatusForbidden || blobErrorCode(w) != "AuthorizationPermissionMismatch" {
+			t.Errorf("expected the container-scoped contributor to be denied elsewhere, got %d", w.Code)
+		}
+		if w := reader.do("GET", "/exports/report.csv", "", nil); w.Code != http.StatusOK || w.Body.String() != "a,b" {
+			t.Errorf("expected the reader to read, got %d %q", w.Code, w.Body.String())
+		}
+		if w := reader.do("DELETE", "/exports/report.csv", "", nil); w.Code != http.StatusForbidden || blobErrorCode(w) != "AuthorizationPermissionMismatch" {
+			t.Errorf("expected the reader to be denied deletes, got %d", w.Code)
+		}
+		if w := nobody.do("GET", "/exports/report.csv", "", nil); w.Code != http.StatusForbidden {
+			t.Errorf("expected a principal without roles to be denied, got %d", w.Code)
+		}
+		management := &blobClient{t: t, mux: mux, account: "stdata", token: token("reader-app", "https://management.azure.com/.default")}
+		if w := management.do("GET", "/exports/report.csv", "", nil); w.Code != http.StatusUnauthorized || blobErrorCode(w) != "InvalidAuthenticationInfo" ||
+			!strings.Contains(w.Header().Get("WWW-Authenticate"), "resource_id=https://storage.azure.com") {
+			t.Errorf("expected a management token to be refused with a challenge, got %d %v", w.Code, w.Header())
+		}
+		if w := anonymous.do("GET", "/exports/report.csv", "", nil); w.Code != http.StatusUnauthorized || blobErrorCode(w) != "NoAuthenticationInformation" {
+			t.Errorf("expected anonymous reads of a private container to be refused, got %d", w.Code)
+		}
+		if w := admin.do("PUT", "/public?restype=container", "", map[string]string{"x-ms-blob-public-access": "blob"}); w.Code != http.StatusCreated {
+			t.Fatalf("create public container: %d", w.Code)
+		}
+		admin.do("PUT", "/public/logo.png", "png", map[string]string{"x-ms-blob-type": "BlockBlob"})
+		if w := anonymous.do("GET", "/public/logo.png", "", nil); w.Code != http.StatusOK {
+			t.Errorf("expected anonymous reads of a public blob, got %d", w.Code)
+		}
+		if w := anonymous.do("GET", "/public?restype=container&comp=list", "", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected blob-level public access to keep listing private, got %d", w.Code)
+		}
+
+		req = httptest.NewRequest("GET", "/exports/report.csv", nil)
+		req.Host = "stdata.blob.core.windows.net"
+		req.Header.Set("Authorization", "Bearer "+reader.token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != "a,b" {
+			t.Errorf("expected the account to answer on its host name, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("account deletion", func(t *testing.T) {
+		if code, _ := armRequest(t, mux, "DELETE", storageAccountID, ""); code != http.StatusOK {
+			t.Fatalf("delete storage account: %d", code)
+		}
+		createStorageAccount(t, mux)
+		if w := admin.do("GET", "/?comp=list", "", nil); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "<Container>") {
+			t.Errorf("expected a recreated account to start empty, got %d %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestBlobStorageOnDisk(t *testing.T) {
+	dataDir := t.TempDir()
+	_, mux := newStorageStore(t, dataDir)
+	key := createStorageAccount(t, mux)
+	client := &blobClient{t: t, mux: mux, account: "stdata", key: key}
+	client.do("PUT", "/exports?restype=container", "", nil)
+	if w := client.do("PUT", "/exports/nested/disk.vhd", "persisted", map[string]string{"x-ms-blob-type": "BlockBlob"}); w.Code != http.StatusCreated {
+		t.Fatalf("put blob: %d %s", w.Code, w.Body.String())
+	}
+
+	// A second Mockzure over the same directory sees the blob once the account exists again
+	_, mux = newStorageStore(t, dataDir)
+	createStorageAccount(t, mux)
+	client.mux = mux
+	w := client.do("GET", "/exports/nested/disk.vhd", "", nil)
+	if body, _ := io.ReadAll(w.Body); w.Code != http.StatusOK || string(body) != "persisted" {
+		t.Errorf("expected the blob to be read back from disk, got %d %q", w.Code, body)
+	}
+	if code, _ := armRequest(t, mux, "DELETE", storageAccountID, ""); code != http.StatusOK {
+		t.Fatalf("delete storage account: %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "stdata")); !os.IsNotExist(err) {
+		t.Errorf("expected the account's blobs to be removed from disk, got %v", err)
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+)
+
+// Defaults used when the config does not declare tenants or subscriptions.
+// The subscription ID matches the one used throughout the example configs.
+const (
+	defaultTenantID       = "87654321-4321-4321-4321-210987654321"
+	defaultSubscriptionID = "12345678-1234-1234-1234-123456789012"
+)
+
+// Tenant is an Entra ID directory that owns subscriptions, users and service accounts
+type Tenant struct {
+	ID            string   `json:"id" yaml:"id"`
+	DisplayName   string   `json:"displayName" yaml:"displayName"`
+	DefaultDomain string   `json:"defaultDomain" yaml:"defaultDomain"`
+	Domains       []string `json:"domains,omitempty" yaml:"domains,omitempty"` // Extra names the tenant answers to in URLs
+}
+
+// Subscription is an Azure subscription owned by a tenant
+type Subscription struct {
+	ID          string            `json:"id" yaml:"id"` // Subscription GUID, not the ARM resource ID
+	DisplayName string            `json:"displayName" yaml:"displayName"`
+	TenantID    string            `json:"tenantId" yaml:"tenantId"`
+	State       string            `json:"state" yaml:"state"` // Enabled, Warned, PastDue, Disabled, Deleted
+	Tags        map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Locations   []string          `json:"locations,omitempty" yaml:"locations,omitempty"` // Regions listed for the subscription; defaults to the built-in catalog
+}
+
+// GetTenants returns tenants as interface slice for mappers
+func (s *Store) GetTenants() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]interface{}, len(s.tenants))
+	for i, t := range s.tenants {
+		result[i] = map[string]interface{}{
+			"id":            "/tenants/" + t.ID,
+			"tenantId":      t.ID,
+			"displayName":   t.DisplayName,
+			"defaultDomain": t.DefaultDomain,
+			"domains":       t.domainNames(),
+		}
+	}
+	return result
+}
+
+// GetSubscriptions returns subscriptions as interface slice for mappers
+func (s *Store) GetSubscriptions() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]interface{}, len(s.subscriptions))
+	for i, sub := range s.subscriptions {
+		result[i] = map[string]interface{}{
+			"id":             "/subscriptions/" + sub.ID,
+			"subscriptionId": sub.ID,
+			"tenantId":       sub.TenantID,
+			"displayName":    sub.DisplayName,
+			"state":          sub.State,
+			"tags":           sub.Tags,
+			"locations":      sub.Locations,
+		}
+	}
+	return result
+}
+
+// domainNames returns every domain the tenant is known by
+func (t *Tenant) domainNames() []string {
+	names := []string{}
+	if t.DefaultDomain != "" {
+		names = append(names, t.DefaultDomain)
+	}
+	return append(names, t.Domains...)
+}
+
+// normalizeTenancy fills in the tenant/subscription hierarchy after a config load.
+// Configs written before tenants existed get a single default tenant, and their
+// subscriptions are derived from resource group IDs.
+func (s *Store) normalizeTenancy() {
+	if len(s.tenants) == 0 {
+		s.tenants = []*Tenant{{
+			ID:            defaultTenantID,
+			DisplayName:   "Default Directory",
+			DefaultDomain: "mockzure.onmicrosoft.com",
+			// Earlier releases served discovery at the literal /tenant-id/ path
+			Domains: []string{"tenant-id"},
+		}}
+	}
+	homeTenant := s.tenants[0].ID
+
+	if len(s.subscriptions) == 0 {
+		seen := map[string]bool{}
+		for _, rg := range s.resourceGroups {
+			subID := rg.SubscriptionID
+			if subID == "" {
+				subID = subscriptionFromResourceID(rg.ID)
+			}
+			if subID != "" && !seen[strings.ToLower(subID)] {
+				seen[strings.ToLower(subID)] = true
+				s.subscriptions = append(s.subscriptions, &Subscription{ID: subID})
+			}
+		}
+		if len(s.subscriptions) == 0 {
+			s.subscriptions = []*Subscription{{ID: defaultSubscriptionID}}
+		}
+	}
+	for _, sub := range s.subscriptions {
+		if sub.TenantID == "" {
+			sub.TenantID = homeTenant
+		}
+		if sub.DisplayName == "" {
+			sub.DisplayName = "Mockzure Subscription " + sub.ID
+		}
+		if sub.State == "" {
+			sub.State = "Enabled"
+		}
+	}
+
+	for _, rg := range s.resourceGroups {
+		if rg.SubscriptionID == "" {
+			rg.SubscriptionID = subscriptionFromResourceID(rg.ID)
+		}
+		if rg.SubscriptionID == "" {
+			rg.SubscriptionID = s.subscriptions[0].ID
+		}
+		if rg.ID == "" {
+			rg.ID = fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", rg.SubscriptionID, rg.Name)
+		}
+	}
+	for _, u := range s.users {
+		if u != nil && u.TenantID == "" {
+			u.TenantID = homeTenant
+		}
+	}
+	for _, sa := range s.serviceAccounts {
+		if sa.TenantID == "" {
+			sa.TenantID = homeTenant
+		}
+	}
+}
+
+// subscriptionFromResourceID extracts the subscription GUID from an ARM resource ID
+func subscriptionFromResourceID(id string) string {
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	if len(parts) >= 2 && strings.EqualFold(parts[0], "subscriptions") {
+		return parts[1]
+	}
+	return ""
+}
+
+// findTenant looks a tenant up by ID or by one of its domains
+func (s *Store) findTenant(ref string) *Tenant {
+	for _, t := range s.tenants {
+		if strings.EqualFold(t.ID, ref) {
+			return t
+		}
+		for _, d := range t.domainNames() {
+			if strings.EqualFold(d, ref) {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// findSubscription looks a subscription up by its GUID
+func (s *Store) findSubscription(id string) *Subscription {
+	for _, sub := range s.subscriptions {
+		if strings.EqualFold(sub.ID, id) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// defaultSubscriptionFor returns the first subscription the caller can access.
+// Anonymous callers get the first configured subscription. The caller must hold s.mu.
+func (s *Store) defaultSubscriptionFor(caller *principal) string {
+	for _, sub := range s.subscriptions {
+		if caller == nil || caller.canAccessSubscription(sub.ID) {
+			return sub.ID
+		}
+	}
+	return s.subscriptions[0].ID
+}
+
+// vmSubscriptionID returns the subscription a VM lives in, from its ID or its resource group
+func (s *Store) vmSubscriptionID(vm *MockVM) string {
+	if sub := subscriptionFromResourceID(vm.ID); sub != "" {
+		return sub
+	}
+	for _, rg := range s.resourceGroups {
+		if rg.Name == vm.ResourceGroup {
+			return rg.SubscriptionID
+		}
+	}
+	return ""
+}
+
+// principal is the authenticated caller of an ARM request. Its exported methods take the
+// store's lock; the unexported ones leave that to the caller.
+type principal struct {
+	store          *Store
+	serviceAccount *ServiceAccount
+	user           *MockUser
+}
+
+// TenantID returns the caller's home tenant
+func (p *principal) TenantID() string {
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+	return p.tenantID()
+}
+
+func (p *principal) tenantID() string {
+	if p.serviceAccount != nil {
+		return p.serviceAccount.TenantID
+	}
+	return p.user.TenantID
+}
+
+// CanAccessSubscription reports whether the subscription is in the caller's tenant
+// and, when the caller lists subscriptions explicitly, among them
+func (p *principal) CanAccessSubscription(subscriptionID string) bool {
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+	return p.canAccessSubscription(subscriptionID)
+}
+
+func (p *principal) canAccessSubscription(subscriptionID string) bool {
+	sub := p.store.findSubscription(subscriptionID)
+	if sub == nil || !strings.EqualFold(sub.TenantID, p.tenantID()) {
+		return false
+	}
+	allowed := p.user.subscriptionList()
+	if p.serviceAccount != nil {
+		allowed = p.serviceAccount.Subscriptions
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, id := range allowed {
+		if strings.EqualFold(id, subscriptionID) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission checks the caller's permission on a resource group. The group's subscription
+// must be one the caller can access.
+func (p *principal) HasPermission(subscriptionID, resourceGroup, permission string) bool {
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+	if !p.canAccessSubscription(subscriptionID) {
+		return false
+	}
+	if p.serviceAccount != nil {
+		return p.serviceAccount.hasPermissionIn(subscriptionID, resourceGroup, permission)
+	}
+	return p.user.hasPermissionIn(subscriptionID, resourceGroup, permission)
+}
+
+// grantCovers reports whether a permission grant's resource group covers a resource group.
+// Grants name a group, in every subscription, or give its scope,
+// /subscriptions/{id}/resourceGroups/{name}, to grant it in one subscription only; "*" covers
+// every group. An empty subscriptionID matches scopes in any subscription.
+func grantCovers(grant, subscriptionID, resourceGroup string) bool {
+	if grant == "*" || grant == resourceGroup {
+		return true
+	}
+	parts := strings.Split(strings.Trim(grant, "/"), "/")
+	return len(parts) == 4 && strings.EqualFold(parts[0], "subscriptions") && strings.EqualFold(parts[2], "resourceGroups") &&
+		(subscriptionID == "" || strings.EqualFold(parts[1], subscriptionID)) && parts[3] == resourceGroup
+}
+
+// subscriptionList returns the user's subscriptions, tolerating a nil user
+func (u *MockUser) subscriptionList() []string {
+	if u == nil {
+		return nil
+	}
+	return u.Subscriptions
+}
+
+// hasPermission checks if a user has a specific permission on a resource group.
+// Resource groups listed on the user grant read access.
+func (u *MockUser) hasPermission(resourceGroup, permission string) bool {
+	return u.hasPermissionIn("", resourceGroup, permission)
+}
+
+// hasPermissionIn is hasPermission for a resource group in a subscription
+func (u *MockUser) hasPermissionIn(subscriptionID, resourceGroup, permission string) bool {
+	for _, perm := range u.Permissions {
+		if !grantCovers(perm.ResourceGroup, subscriptionID, resourceGroup) {
+			continue
+		}
+		for _, a := range perm.Actions {
+			if a == permission || a == "*" {
+				return true
+			}
+		}
+	}
+	if permission == "read" {
+		for _, rg := range u.ResourceGroups {
+			if grantCovers(rg, subscriptionID, resourceGroup) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticate resolves the caller of a request for the mappers.
+// Requests without credentials, or with credentials Mockzure does not recognize,
+// are treated as anonymous for backward compatibility.
+func (s *Store) Authenticate(r *http.Request) mappers.Principal {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if caller := s.authenticate(r); caller != nil {
+		return caller
+	}
+	return nil
+}
+
+// authenticate is Authenticate for callers that hold s.mu. It returns nil for anonymous callers.
+func (s *Store) authenticate(r *http.Request) *principal {
+	if r.Header.Get("Authorization") == "" {
+		return nil
+	}
+	if sa, err := s.authenticateServiceAccount(r); err == nil {
+		return &principal{store: s, serviceAccount: sa}
+	}
+	if user := s.authenticateUser(r); user != nil {
+		return &principal{store: s, user: user}
+	}
+	return nil
+}
+
+// authenticateUser resolves a user from a Mockzure-issued bearer token
+func (s *Store) authenticateUser(r *http.Request) *MockUser {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	claims, err := parseUnsignedJWT(token)
+	if err != nil {
+		return nil
+	}
+	oid, _ := claims["oid"].(string)
+	for _, u := range s.users {
+		if u != nil && u.ID == oid && u.AccountEnabled {
+			return u
+		}
+	}
+	return nil
+}
+
+// tenantContextKey carries the {tenant} segment of tenant-scoped identity routes
+type tenantContextKey struct{}
+
+// tenantRoutePattern matches identity endpoints prefixed with a tenant ID or domain,
+// e.g. /{tenant}/oauth2/v2.0/token or /{tenant}/v2.0/.well-known/openid-configuration
+var tenantRoutePattern = regexp.MustCompile(`^/([^/]+)(/oauth2/v2\.0/(?:authorize|token)|/v2\.0/\.well-known/openid-configuration|/\.well-known/openid-configuration)$`)
+
+// serveTenantRoute rewrites a tenant-scoped identity request onto its unscoped handler,
+// remembering the tenant in the request context. It reports whether the path matched.
+func serveTenantRoute(mux *http.ServeMux, w http.ResponseWriter, r *http.Request) bool {
+	matches := tenantRoutePattern.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		return false
+	}
+	scoped := r.Clone(context.WithValue(r.Context(), tenantContextKey{}, matches[1]))
+	scoped.URL.Path = matches[2]
+	if scoped.URL.Path == "/v2.0/.well-known/openid-configuration" {
+		scoped.URL.Path = "/.well-known/openid-configuration"
+	}
+	mux.ServeHTTP(w, scoped)
+	return true
+}
+
+// tenantFromRequest returns the tenant segment of a tenant-scoped request
+func tenantFromRequest(r *http.Request) (string, bool) {
+	ref, ok := r.Context().Value(tenantContextKey{}).(string)
+	return ref, ok
+}
+
+// isMultiTenantAlias reports whether a tenant segment is one of Entra ID's multi-tenant aliases
+func isMultiTenantAlias(ref string) bool {
+	switch strings.ToLower(ref) {
+	case "common", "organizations", "consumers":
+		return true
+	}
+	return false
+}
+
+// tenantIssuer returns the v2.0 issuer for a tenant, in the login.microsoftonline.com style
+func tenantIssuer(r *http.Request, tenantID string) string {
+	return baseURL(r) + "/" + tenantID + "/v2.0"
+}
+
+// findServiceAccount looks up an enabled service account by application ID
+func (s *Store) findServiceAccount(appID string) *ServiceAccount {
+	for _, sa := range s.serviceAccounts {
+		if sa.ApplicationID == appID && sa.AccountEnabled {
+			return sa
+		}
+	}
+	return nil
+}
+
+// signInTenant returns the tenant a user signed in to and the issuer for their tokens.
+// Unscoped sign-ins keep the legacy issuer so existing clients validate unchanged.
+func (s *Store) signInTenant(r *http.Request, ac *AuthCode, user *MockUser) (string, string) {
+	tid := s.tenants[0].ID
+	if user != nil && user.TenantID != "" {
+		tid = user.TenantID
+	}
+	if ac.TenantRef == "" {
+		return tid, baseURL(r)
+	}
+	if t := s.findTenant(ac.TenantRef); t != nil {
+		tid = t.ID
+	}
+	return tid, tenantIssuer(r, tid)
+}
+
+// signInAccessToken issues the access token for an authorization code sign-in.
+// Tenant-scoped sign-ins get a JWT; unscoped ones get the legacy opaque token.
+func (s *Store) signInAccessToken(r *http.Request, ac *AuthCode, user *MockUser, tenantID, legacy string) string {
+	if ac.TenantRef == "" || user == nil {
+		return legacy
+	}
+	return userAccessToken(r, user, tenantID, ac.ClientID, ac.Scope)
+}
+
+// writeTenantNotFound writes the error Entra ID returns for unknown tenants
+func writeTenantNotFound(w http.ResponseWriter, ref string) {
+	writeOAuthError(w, "invalid_tenant", 90002,
+		fmt.Sprintf("AADSTS90002: Tenant '%s' not found. Check to make sure you have the correct tenant ID and are signing into the correct cloud.", ref))
+}
+
+// writeAppNotInTenant writes the error Entra ID returns when an app is not registered in the tenant
+func writeAppNotInTenant(w http.ResponseWriter, appID, ref string) {
+	writeOAuthError(w, "unauthorized_client", 700016,
+		fmt.Sprintf("AADSTS700016: Application with identifier '%s' was not found in the directory '%s'.", appID, ref))
+}
+
+// writeUserNotInTenant writes the error Entra ID returns when a user signs in to a tenant other
+// than their own
+func writeUserNotInTenant(w http.ResponseWriter, user *MockUser, appID, ref string) {
+	writeOAuthError(w, "invalid_request", 50020,
+		fmt.Sprintf("AADSTS50020: User account '%s' from identity provider 'https://sts.windows.net/%s/' does not exist in tenant '%s' and cannot access the application '%s' in that tenant.", user.UserPrincipalName, user.TenantID, ref, appID))
+}
+
+// writeOAuthError writes an OAuth2 error response in the Entra ID format
+func writeOAuthError(w http.ResponseWriter, code string, aadsts int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":             code,
+		"error_description": description,
+		"error_codes":       []int{aadsts},
+	}); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
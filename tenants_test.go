@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const multiTenantConfig = `
+tenants:
+  - id: "11111111-1111-1111-1111-111111111111"
+    displayName: Contoso
+    defaultDomain: contoso.onmicrosoft.com
+  - id: "22222222-2222-2222-2222-222222222222"
+    displayName: Fabrikam
+    defaultDomain: fabrikam.onmicrosoft.com
+subscriptions:
+  - id: "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"
+    displayName: Contoso Dev
+    tenantId: "11111111-1111-1111-1111-111111111111"
+  - id: "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb"
+    displayName: Fabrikam Prod
+    tenantId: "22222222-2222-2222-2222-222222222222"
+resourceGroups:
+  - id: "/subscriptions/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa/resourceGroups/rg-contoso"
+    name: rg-contoso
+    location: eastus
+  - id: "/subscriptions/bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb/resourceGroups/rg-fabrikam"
+    name: rg-fabrikam
+    location: westus
+vms:
+  - id: "/subscriptions/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa/resourceGroups/rg-contoso/providers/Microsoft.Compute/virtualMachines/vm-contoso"
+    name: vm-contoso
+    resourceGroup: rg-contoso
+    location: eastus
+  - id: "/subscriptions/bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb/resourceGroups/rg-fabrikam/providers/Microsoft.Compute/virtualMachines/vm-fabrikam"
+    name: vm-fabrikam
+    resourceGroup: rg-fabrikam
+    location: westus
+serviceAccounts:
+  - applicationId: contoso-app
+    secret: contoso-secret
+    tenantId: "11111111-1111-1111-1111-111111111111"
+    permissions:
+      - resourceGroup: "*"
+        actions: ["read"]
+`
+
+// newMultiTenantStore loads a store with two tenants, each owning one subscription
+func newMultiTenantStore(t *testing.T) *Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(multiTenantConfig), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	store := &Store{configPath: path}
+	store.init()
+	return store
+}
+
+// requestClientToken runs the client credentials flow against a token endpoint
+func requestClientToken(mux http.Handler, path, clientID, secret string) *httptest.ResponseRecorder {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {secret},
+		"scope":         {"https://management.azure.com/.default"},
+	}
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestTenantScopedDiscovery(t *testing.T) {
+	store := newMultiTenantStore(t)
+	mux := newMux(store, "mockzure-specs")
+
+	for _, ref := range []string{"11111111-1111-1111-1111-111111111111", "contoso.onmicrosoft.com"} {
+		req := httptest.NewRequest("GET", "/"+ref+"/v2.0/.well-known/openid-configuration", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", ref, w.Code)
+		}
+		var doc map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+			t.Fatalf("decode discovery: %v", err)
+		}
+		want := "http://example.com/11111111-1111-1111-1111-111111111111/v2.0"
+		if doc["issuer"] != want {
+			t.Errorf("%s: expected issuer %s, got %v", ref, want, doc["issuer"])
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/unknown-tenant/v2.0/.well-known/openid-configuration", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "AADSTS90002") {
+		t.Errorf("expected AADSTS90002 for unknown tenant, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTenantScopedClientCredentials(t *testing.T) {
+	store := newMultiTenantStore(t)
+	mux := newMux(store, "mockzure-specs")
+
+	w := requestClientToken(mux, "/11111111-1111-1111-1111-111111111111/oauth2/v2.0/token", "contoso-app", "contoso-secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var token map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&token); err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+	claims, err := parseUnsignedJWT(token["access_token"].(string))
+	if err != nil {
+		t.Fatalf("access token is not a JWT: %v", err)
+	}
+	if claims["tid"] != "11111111-1111-1111-1111-111111111111" {
+		t.Errorf("expected tid claim for Contoso, got %v", claims["tid"])
+	}
+	if claims["aud"] != "https://management.azure.com" {
+		t.Errorf("expected ARM audience, got %v", claims["aud"])
+	}
+
+	// The app is not registered in Fabrikam
+	w = requestClientToken(mux, "/22222222-2222-2222-2222-222222222222/oauth2/v2.0/token", "contoso-app", "contoso-secret")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "AADSTS700016") {
+		t.Errorf("expected AADSTS700016 for foreign tenant, got %d %s", w.Code, w.Body.String())
+	}
+
+	// Unscoped endpoint keeps issuing legacy tokens
+	w = requestClientToken(mux, "/oauth2/v2.0/token", "contoso-app", "contoso-secret")
+	if !strings.Contains(w.Body.String(), "mock_access_token_contoso-app") {
+		t.Errorf("expected legacy token from unscoped endpoint, got %s", w.Body.String())
+	}
+}
+
+func TestTenantScopedAuthorize(t *testing.T) {
+	store := newMultiTenantStore(t)
+	store.users = append(store.users,
+		&MockUser{ID: "contoso-user", UserPrincipalName: "alex@contoso.onmicrosoft.com", AccountEnabled: true, TenantID: "11111111-1111-1111-1111-111111111111"},
+		&MockUser{ID: "fabrikam-user", UserPrincipalName: "dana@fabrikam.onmicrosoft.com", AccountEnabled: true, TenantID: "22222222-2222-2222-2222-222222222222"})
+	mux := newMux(store, "mockzure-specs")
+
+	for _, tc := range []struct {
+		tenant, user string
+		want         int
+	}{
+		{"11111111-1111-1111-1111-111111111111", "contoso-user", http.StatusFound},
+		{"fabrikam.onmicrosoft.com", "fabrikam-user", http.StatusFound},
+		{"common", "fabrikam-user", http.StatusFound},
+		{"11111111-1111-1111-1111-111111111111", "fabrikam-user", http.StatusBadRequest},
+		{"fabrikam.onmicrosoft.com", "contoso-user", http.StatusBadRequest},
+	} {
+		path := "/" + tc.tenant + "/oauth2/v2.0/authorize?client_id=web-app&redirect_uri=http://localhost/callback&response_type=code&scope=openid&user_id=" + tc.user
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s signing in to %s: expected %d, got %d %s", tc.user, tc.tenant, tc.want, w.Code, w.Body.String())
+		}
+		if tc.want == http.StatusBadRequest && (!strings.Contains(w.Body.String(), "AADSTS50020") || !strings.Contains(w.Body.String(), "invalid_request")) {
+			t.Errorf("%s signing in to %s: expected AADSTS50020, got %s", tc.user, tc.tenant, w.Body.String())
+		}
+	}
+}
+
+func TestSubscriptionScopedARMAccess(t *testing.T) {
+	store := newMultiTenantStore(t)
+	mux := newMux(store, "mockzure-specs")
+
+	w := requestClientToken(mux, "/contoso.onmicrosoft.com/oauth2/v2.0/token", "contoso-app", "contoso-secret")
+	var token map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&token); err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+	bearer := "Bearer " + token["access_token"].(string)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"own subscription", "/subscriptions/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa/providers/Microsoft.Compute/virtualMachines", http.StatusOK, "vm-contoso"},
+		{"other tenant's subscription", "/subscriptions/bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb/providers/Microsoft.Compute/virtualMachines", http.StatusNotFound, "SubscriptionNotFound"},
+		{"unknown subscription", "/subscriptions/cccccccc-cccc-cccc-cccc-cccccccccccc/resourcegroups", http.StatusNotFound, "SubscriptionNotFound"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path+"?api-version=2021-04-01", nil)
+			req.Header.Set("Authorization", bearer)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("expected body to contain %q, got %s", tt.wantBody, w.Body.String())
+			}
+			if strings.Contains(w.Body.String(), "vm-fabrikam") {
+				t.Errorf("response leaked a VM from another tenant: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPermissionsAreSubscriptionScoped(t *testing.T) {
+	const subA, subB = "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb"
+	config := `
+subscriptions:
+  - id: "` + subA + `"
+  - id: "` + subB + `"
+resourceGroups:
+  - id: "/subscriptions/` + subA + `/resourceGroups/rg-app"
+    name: rg-app
+    location: eastus
+  - id: "/subscriptions/` + subB + `/resourceGroups/rg-app"
+    name: rg-app
+    location: eastus
+serviceAccounts:
+  - applicationId: app-in-a
+    secret: secret-a
+    subscriptions: ["` + subA + `"]
+    permissions:
+      - resourceGroup: rg-app
+        permissions: [read, write]
+  - applicationId: app-scoped
+    secret: secret-scoped
+    permissions:
+      - resourceGroup: /subscriptions/` + subA + `/resourceGroups/rg-app
+        permissions: [read, write]
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	store := &Store{configPath: path}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+
+	for _, tc := range []struct {
+		appID, secret, subscription string
+		want                        int
+	}{
+		{"app-in-a", "secret-a", subA, http.StatusOK},
+		{"app-in-a", "secret-a", subB, http.StatusNotFound},
+		{"app-scoped", "secret-scoped", subA, http.StatusOK},
+		{"app-scoped", "secret-scoped", subB, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("PUT", "/subscriptions/"+tc.subscription+"/resourcegroups/rg-app?api-version=2021-04-01", strings.NewReader(`{"location":"eastus"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(tc.appID, tc.secret)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s writing rg-app in %s: expected %d, got %d %s", tc.appID, tc.subscription, tc.want, w.Code, w.Body.String())
+		}
+
+		caller := &principal{store: store, serviceAccount: store.findServiceAccount(tc.appID)}
+		if got := caller.HasPermission(tc.subscription, "rg-app", "write"); got != (tc.want == http.StatusOK) {
+			t.Errorf("%s: expected write on rg-app in %s to be %v", tc.appID, tc.subscription, !got)
+		}
+	}
+}
+
+func TestDiscoverVMsDefaultsToCallerSubscription(t *testing.T) {
+	store := newMultiTenantStore(t)
+	mux := newMux(store, "mockzure-specs")
+
+	req := httptest.NewRequest("POST", "/api/vms/discover", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "vm-contoso") || strings.Contains(w.Body.String(), "vm-fabrikam") {
+		t.Errorf("expected only the first subscription's VMs, got %s", w.Body.String())
+	}
+}
+
+func TestSubscriptionAndTenantListing(t *testing.T) {
+	store := newMultiTenantStore(t)
+	mux := newMux(store, "mockzure-specs")
+
+	w := requestClientToken(mux, "/contoso.onmicrosoft.com/oauth2/v2.0/token", "contoso-app", "contoso-secret")
+	var token map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&token); err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+	bearer := "Bearer " + token["access_token"].(string)
+
+	get := func(path, auth string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("GET", path+"?api-version=2022-12-01", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var body map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: decode response: %v", path, err)
+		}
+		return w.Code, body
+	}
+
+	_, body := get("/subscriptions", "")
+	if n := len(body["value"].([]interface{})); n != 2 {
+		t.Errorf("anonymous caller: expected 2 subscriptions, got %d", n)
+	}
+
+	_, body = get("/subscriptions", bearer)
+	subs := body["value"].([]interface{})
+	if len(subs) != 1 || subs[0].(map[string]interface{})["subscriptionId"] != "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa" {
+		t.Errorf("expected only the Contoso subscription, got %v", subs)
+	}
+	if subs[0].(map[string]interface{})["tenantId"] != "11111111-1111-1111-1111-111111111111" {
+		t.Errorf("expected tenantId on subscription, got %v", subs[0])
+	}
+
+	code, body := get("/subscriptions/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", bearer)
+	if code != http.StatusOK || body["displayName"] != "Contoso Dev" || body["state"] != "Enabled" {
+		t.Errorf("unexpected subscription: %d %v", code, body)
+	}
+
+	code, _ = get("/subscriptions/bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb", bearer)
+	if code != http.StatusNotFound {
+		t.Errorf("expected 404 for another tenant's subscription, got %d", code)
+	}
+
+	code, body = get("/subscriptions/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa/locations", bearer)
+	if code != http.StatusOK || len(body["value"].([]interface{})) == 0 {
+		t.Fatalf("expected locations, got %d %v", code, body)
+	}
+	loc := body["value"].([]interface{})[0].(map[string]interface{})
+	if loc["name"] != "eastus" || loc["displayName"] != "East US" {
+		t.Errorf("unexpected first location: %v", loc)
+	}
+
+	_, body = get("/tenants", bearer)
+	tenants := body["value"].([]interface{})
+	if len(tenants) != 1 || tenants[0].(map[string]interface{})["tenantId"] != "11111111-1111-1111-1111-111111111111" {
+		t.Errorf("expected only the caller's tenant, got %v", tenants)
+	}
+}
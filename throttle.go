@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourcloudtools/mockzure/internal/mappers"
+)
+
+// ThrottlingConfig turns on ARM and Microsoft Graph request throttling. Limits left unset
+// take the defaults below.
+type ThrottlingConfig struct {
+	ARMReads  *ThrottleLimit `json:"armReads,omitempty" yaml:"armReads,omitempty"`   // ARM GET and HEAD requests, per subscription and principal
+	ARMWrites *ThrottleLimit `json:"armWrites,omitempty" yaml:"armWrites,omitempty"` // other ARM requests, per subscription and principal
+	Graph     *ThrottleLimit `json:"graph,omitempty" yaml:"graph,omitempty"`         // Graph requests, per application and tenant
+}
+
+// ThrottleLimit is a token bucket: each request takes a token, and tokens are added back at a
+// steady rate up to the capacity
+type ThrottleLimit struct {
+	Capacity        int     `json:"capacity,omitempty" yaml:"capacity,omitempty"`               // tokens in a full bucket
+	RefillPerSecond float64 `json:"refillPerSecond,omitempty" yaml:"refillPerSecond,omitempty"` // tokens added back each second
+}
+
+// Default limits follow ARM's token bucket limits for a subscription and principal
+var (
+	defaultARMReadLimit  = ThrottleLimit{Capacity: 250, RefillPerSecond: 25}
+	defaultARMWriteLimit = ThrottleLimit{Capacity: 200, RefillPerSecond: 10}
+	defaultGraphLimit    = ThrottleLimit{Capacity: 2000, RefillPerSecond: 200}
+)
+
+// validate checks a limit and returns it with the defaults filled in
+func (limit *ThrottleLimit) validate(defaults ThrottleLimit) (ThrottleLimit, error) {
+	if limit == nil {
+		return defaults, nil
+	}
+	if limit.Capacity < 0 || limit.RefillPerSecond < 0 {
+		return ThrottleLimit{}, fmt.Errorf("capacity and refillPerSecond cannot be negative")
+	}
+	filled := *limit
+	if filled.Capacity == 0 {
+		filled.Capacity = defaults.Capacity
+	}
+	if filled.RefillPerSecond == 0 {
+		filled.RefillPerSecond = defaults.RefillPerSecond
+	}
+	return filled, nil
+}
+
+// throttler holds the token buckets of the loaded throttling config
+type throttler struct {
+	mu        sync.Mutex
+	armReads  ThrottleLimit
+	armWrites ThrottleLimit
+	graph     ThrottleLimit
+	buckets   map[string]*tokenBucket // by bucket kind and scope
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// throttleResult is the state of a bucket after a request took from it
+type throttleResult struct {
+	limit      ThrottleLimit
+	remaining  int           // whole tokens left
+	retryAfter time.Duration // until the next token when the request was throttled
+	full       time.Duration // until the bucket is full again
+	throttled  bool
+}
+
+func newThrottler(config *ThrottlingConfig) (*throttler, error) {
+	t := &throttler{buckets: map[string]*tokenBucket{}}
+	var err error
+	if t.armReads, err = config.ARMReads.validate(defaultARMReadLimit); err != nil {
+		return nil, fmt.Errorf("armReads: %w", err)
+	}
+	if t.armWrites, err = config.ARMWrites.validate(defaultARMWriteLimit); err != nil {
+		return nil, fmt.Errorf("armWrites: %w", err)
+	}
+	if t.graph, err = config.Graph.validate(defaultGraphLimit); err != nil {
+		return nil, fmt.Errorf("graph: %w", err)
+	}
+	return t, nil
+}
+
+// config returns the limits in the config file schema
+func (t *throttler) config() *ThrottlingConfig {
+	armReads, armWrites, graph := t.armReads, t.armWrites, t.graph
+	return &ThrottlingConfig{ARMReads: &armReads, ARMWrites: &armWrites, Graph: &graph}
+}
+
+// take removes a token from the bucket of a scope, refilling it for the time since it was
+// last used. A new bucket starts full.
+func (t *throttler) take(limit ThrottleLimit, key string) throttleResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	capacity := float64(limit.Capacity)
+	bucket := t.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		t.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*limit.RefillPerSecond)
+	bucket.updated = now
+
+	result := throttleResult{limit: limit}
+	if bucket.tokens < 1 {
+		result.throttled = true
+		result.retryAfter = time.Duration((1 - bucket.tokens) / limit.RefillPerSecond * float64(time.Second))
+	} else {
+		bucket.tokens--
+	}
+	result.remaining = int(bucket.tokens)
+	result.full = time.Duration((capacity - bucket.tokens) / limit.RefillPerSecond * float64(time.Second))
+	return result
+}
+
+// seconds rounds a wait up to whole seconds, as Retry-After and RateLimit-Reset carry them
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// isARMRequest reports whether a request is for the ARM control plane
+func isARMRequest(r *http.Request) bool {
+	path := strings.ToLower(r.URL.Path)
+	return path == "/subscriptions" || path == "/tenants" ||
+		strings.HasPrefix(path, "/subscriptions/") || strings.HasPrefix(path, "/providers/")
+}
+
+// isGraphRequest reports whether a request is for Microsoft Graph
+func isGraphRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/v1.0/") || strings.HasPrefix(r.URL.Path, "/beta/")
+}
+
+// throttleHandler applies the store's throttling limits in front of the handlers. ARM responses
+// carry x-ms-ratelimit-remaining-subscription-reads or -writes, or the tenant variants for
+// requests outside a subscription, and Graph responses carry the RateLimit headers. Requests
+// that find their bucket empty are answered with 429 and Retry-After.
+func throttleHandler(store *Store, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		throttling := store.throttler()
+		if throttling == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case isARMRequest(r):
+			scope, scopeID := "subscription", subscriptionFromResourceID(r.URL.Path)
+			principalID, tenantID := store.throttlingPrincipal(r)
+			if scopeID == "" {
+				scope, scopeID = "tenant", tenantID
+			}
+			kind, limit := "reads", throttling.armReads
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				kind, limit = "writes", throttling.armWrites
+			}
+			result := throttling.take(limit, strings.ToLower(strings.Join([]string{"arm", kind, scope, scopeID, principalID}, "/")))
+			w.Header().Set(fmt.Sprintf("x-ms-ratelimit-remaining-%s-%s", scope, kind), strconv.Itoa(result.remaining))
+			if result.throttled {
+				log.Printf("Throttling %s %s: no %s left for %s %s and principal %s", r.Method, r.URL.Path, kind, scope, scopeID, principalID)
+				message := fmt.Sprintf("Number of %s requests for %s '%s' and principal '%s' exceeded the limit of %d. Please try again after %s seconds.",
+					strings.TrimSuffix(kind, "s"), scope, scopeID, principalID, limit.Capacity, seconds(result.retryAfter))
+				writeThrottled(w, result, (&mappers.ARMError{StatusCode: http.StatusTooManyRequests, Code: "TooManyRequests", Message: message}).Body())
+				return
+			}
+		case isGraphRequest(r):
+			appID, tenantID := store.graphThrottlingApp(r)
+			result := throttling.take(throttling.graph, strings.ToLower("graph/"+appID+"/"+tenantID))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.limit.Capacity))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+			w.Header().Set("RateLimit-Reset", seconds(result.full))
+			if result.throttled {
+				log.Printf("Throttling %s %s: no requests left for application %s in tenant %s", r.Method, r.URL.Path, appID, tenantID)
+				requestID := newBlobRequestID()
+				writeThrottled(w, result, map[string]interface{}{"error": map[string]interface{}{
+					"code":    "activityLimitReached",
+					"message": "The request has been throttled",
+					"innerError": map[string]interface{}{
+						"code":              "throttledRequest",
+						"date":              time.Now().UTC().Format(time.RFC3339),
+						"request-id":        requestID,
+						"client-request-id": requestID,
+					},
+				}})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeThrottled answers a throttled request with 429 and the wait for the next token
+func writeThrottled(w http.ResponseWriter, result throttleResult, body interface{}) {
+	w.Header().Set("Retry-After", seconds(result.retryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// graphThrottlingApp returns the application and tenant a Graph request is throttled as. Graph
+// limits each application in a tenant, so a delegated token counts against the app it was issued
+// to, from its appid and tid claims, whichever user signed in. Other callers are throttled as
+// their principal.
+func (s *Store) graphThrottlingApp(r *http.Request) (string, string) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if claims, err := parseUnsignedJWT(token); err == nil {
+			appID, _ := claims["appid"].(string)
+			tenantID, _ := claims["tid"].(string)
+			if appID != "" && tenantID != "" {
+				return appID, tenantID
+			}
+		}
+	}
+	return s.throttlingPrincipal(r)
+}
+
+// throttlingPrincipal returns who a request is throttled as: the application or user ID of its
+// caller, and the caller's tenant. Anonymous callers share one bucket in the first tenant.
+func (s *Store) throttlingPrincipal(r *http.Request) (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tenantID := ""
+	if len(s.tenants) > 0 {
+		tenantID = s.tenants[0].ID
+	}
+	caller := s.authenticate(r)
+	switch {
+	case caller == nil:
+		principalID := requestClientID(r)
+		if principalID == "" {
+			principalID = "anonymous"
+		}
+		return principalID, tenantID
+	case caller.serviceAccount != nil:
+		return caller.serviceAccount.ApplicationID, caller.tenantID()
+	default:
+		return caller.user.ID, caller.tenantID()
+	}
+}
+
+// throttler returns the throttling limits of the loaded config, or nil when it sets none
+func (s *Store) throttler() *throttler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.throttling
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// throttledRequest sends a request with Basic credentials for appID, or anonymously when it is empty
+func throttledRequest(t *testing.T, mux http.Handler, method, path, appID, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if appID != "" {
+		req.SetBasicAuth(appID, "sandman-secret-key-development-only")
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var decoded map[string]interface{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w, decoded
+}
+
+func TestThrottling(t *testing.T) {
+	data, err := os.ReadFile("config.yaml.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Buckets refill too slowly to matter while the test runs
+	config := string(data) + `
+throttling:
+  armReads: {capacity: 2, refillPerSecond: 0.01}
+  armWrites: {capacity: 1, refillPerSecond: 0.01}
+  graph: {capacity: 1, refillPerSecond: 0.01}
+`
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if problems := validateConfig([]byte(config)); len(problems) != 0 {
+		t.Errorf("expected the config to validate, got %v", problems)
+	}
+	store := &Store{configPath: configPath}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+	rgPath := testSubscriptionPath + "/resourcegroups/rg-dev?api-version=2021-04-01"
+	appID := "sandman-app-id-12345"
+
+	t.Run("ARM reads and writes", func(t *testing.T) {
+		for _, want := range []string{"1", "0"} {
+			w, _ := throttledRequest(t, mux, "GET", rgPath, appID, "")
+			if w.Code != http.StatusOK || w.Header().Get("x-ms-ratelimit-remaining-subscription-reads") != want {
+				t.Errorf("expected %s reads left, got %d %v", want, w.Code, w.Header())
+			}
+		}
+		w, body := throttledRequest(t, mux, "GET", rgPath, appID, "")
+		if w.Code != http.StatusTooManyRequests || armErrorCode(body) != "TooManyRequests" || w.Header().Get("Retry-After") != "100" {
+			t.Errorf("expected the reads to be throttled, got %d %v %v", w.Code, w.Header(), body)
+		}
+
+		// Other principals and writes have buckets of their own
+		if w, _ := throttledRequest(t, mux, "GET", rgPath, "", ""); w.Code != http.StatusOK {
+			t.Errorf("expected anonymous reads to pass, got %d", w.Code)
+		}
+		w, _ = throttledRequest(t, mux, "PUT", testSubscriptionPath+"/resourcegroups/rg-throttled?api-version=2021-04-01", "", `{"location":"westeurope"}`)
+		if w.Code != http.StatusCreated || w.Header().Get("x-ms-ratelimit-remaining-subscription-writes") != "0" {
+			t.Errorf("expected the write to pass, got %d %v", w.Code, w.Header())
+		}
+		if w, _ := throttledRequest(t, mux, "DELETE", testSubscriptionPath+"/resourcegroups/rg-throttled?api-version=2021-04-01", "", ""); w.Code != http.StatusTooManyRequests {
+			t.Errorf("expected the writes to be throttled, got %d", w.Code)
+		}
+		w, _ = throttledRequest(t, mux, "GET", "/subscriptions?api-version=2022-12-01", appID, "")
+		if w.Code != http.StatusOK || w.Header().Get("x-ms-ratelimit-remaining-tenant-reads") != "1" {
+			t.Errorf("expected tenant reads to be counted apart, got %d %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("Graph", func(t *testing.T) {
+		w, _ := throttledRequest(t, mux, "GET", "/v1.0/users", appID, "")
+		if w.Code == http.StatusTooManyRequests || w.Header().Get("RateLimit-Limit") != "1" || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Reset") != "100" {
+			t.Errorf("expected the RateLimit headers, got %d %v", w.Code, w.Header())
+		}
+		w, body := throttledRequest(t, mux, "GET", "/v1.0/users", appID, "")
+		graphErr, _ := body["error"].(map[string]interface{})
+		if w.Code != http.StatusTooManyRequests || graphErr["code"] != "activityLimitReached" || graphErr["innerError"] == nil || w.Header().Get("Retry-After") != "100" {
+			t.Errorf("expected Graph to be throttled, got %d %v %v", w.Code, w.Header(), body)
+		}
+
+		// Delegated tokens count against the app they were issued to, not the signed-in user
+		graphAs := func(user *MockUser, clientID string) int {
+			token := userAccessToken(httptest.NewRequest("GET", "/", nil), user, user.TenantID, clientID, "https://graph.microsoft.com/.default")
+			req := httptest.NewRequest("GET", "/v1.0/users", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			return w.Code
+		}
+		if code := graphAs(store.users[0], "portal-app"); code == http.StatusTooManyRequests {
+			t.Errorf("expected the app's first request to pass")
+		}
+		if code := graphAs(store.users[1], "portal-app"); code != http.StatusTooManyRequests {
+			t.Errorf("expected another user of the same app to share its bucket, got %d", code)
+		}
+		if code := graphAs(store.users[1], "other-app"); code == http.StatusTooManyRequests {
+			t.Errorf("expected another app to have a bucket of its own")
+		}
+	})
+
+	t.Run("reset refills the buckets", func(t *testing.T) {
+		if code, body := postAdmin(t, mux, "/mock/azure/data/reset"); code != http.StatusOK {
+			t.Fatalf("expected the reset to succeed, got %d %v", code, body)
+		}
+		if w, _ := throttledRequest(t, mux, "GET", rgPath, appID, ""); w.Code != http.StatusOK || w.Header().Get("x-ms-ratelimit-remaining-subscription-reads") != "1" {
+			t.Errorf("expected a full bucket after the reset, got %d %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("off without a throttling config", func(t *testing.T) {
+		store := &Store{configPath: "config.yaml.example"}
+		store.init()
+		w, _ := throttledRequest(t, newMux(store, "mockzure-specs"), "GET", rgPath, appID, "")
+		if w.Code != http.StatusOK || w.Header().Get("x-ms-ratelimit-remaining-subscription-reads") != "" {
+			t.Errorf("expected no rate limit headers, got %d %v", w.Code, w.Header())
+		}
+		if problems := validateConfig([]byte(string(data) + "\nthrottling:\n  graph: {capacity: -1}\n")); len(problems) == 0 {
+			t.Errorf("expected a negative capacity to be rejected")
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// parseUnsignedJWT decodes the claims of a Mockzure-issued JWT and checks its expiry.
+// Signatures are not verified; Mockzure only issues alg=none tokens.
+func parseUnsignedJWT(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token payload: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if exp, ok := claims["exp"].(float64); ok && time.Now().Unix() > int64(exp) {
+		return nil, fmt.Errorf("token expired")
+	}
+	return claims, nil
+}
+
+// audienceFromScope derives the token audience from an OAuth2 scope string,
+// e.g. https://management.azure.com/.default -> https://management.azure.com
+func audienceFromScope(scope string) string {
+	for _, s := range strings.Fields(scope) {
+		switch s {
+		case "openid", "profile", "email", "offline_access":
+			continue
+		}
+		if i := strings.Index(s, "://"); i >= 0 {
+			if j := strings.LastIndex(s, "/"); j > i+2 {
+				return s[:j]
+			}
+			return s
+		}
+		// Bare permissions such as User.Read are Microsoft Graph scopes
+		return "https://graph.microsoft.com"
+	}
+	return "https://graph.microsoft.com"
+}
+
+// appAccessToken issues an app-only access token for a service account in a tenant
+func appAccessToken(r *http.Request, sa *ServiceAccount, tenantID, scope string) string {
+	now := time.Now()
+	return makeUnsignedJWT(map[string]interface{}{
+		"aud":   audienceFromScope(scope),
+		"iss":   tenantIssuer(r, tenantID),
+		"tid":   tenantID,
+		"appid": sa.ApplicationID,
+		"azp":   sa.ApplicationID,
+		"oid":   sa.ID,
+		"sub":   sa.ID,
+		"idtyp": "app",
+		"roles": sa.GraphPermissions,
+		"ver":   "2.0",
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"exp":   now.Add(1 * time.Hour).Unix(),
+	})
+}
+
+// userAccessToken issues a delegated access token for a user signed in to a client
+func userAccessToken(r *http.Request, user *MockUser, tenantID, clientID, scope string) string {
+	now := time.Now()
+	return makeUnsignedJWT(map[string]interface{}{
+		"aud":   audienceFromScope(scope),
+		"iss":   tenantIssuer(r, tenantID),
+		"tid":   tenantID,
+		"appid": clientID,
+		"azp":   clientID,
+		"oid":   user.ID,
+		"sub":   user.ID,
+		"upn":   user.UserPrincipalName,
+		"name":  user.DisplayName,
+		"scp":   scope,
+		"idtyp": "user",
+		"ver":   "2.0",
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"exp":   now.Add(1 * time.Hour).Unix(),
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourcloudtools/mockzure/internal/routes"
+)
+
+func TestRequestValidation(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+	rgPath := testSubscriptionPath + "/resourcegroups/rg-dev"
+
+	t.Run("api-version", func(t *testing.T) {
+		req := httptest.NewRequest("GET", rgPath, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "MissingApiVersionParameter") {
+			t.Errorf("expected MissingApiVersionParameter, got %d %s", w.Code, w.Body.String())
+		}
+
+		code, body := armRequest(t, mux, "GET", rgPath+"?api-version=2019-01-01", "")
+		if code != http.StatusBadRequest || armErrorCode(body) != "InvalidApiVersionParameter" {
+			t.Errorf("expected InvalidApiVersionParameter, got %d %v", code, body)
+		}
+		if code, body := armRequest(t, mux, "GET", rgPath+"?api-version=2021-04-01", ""); code != http.StatusOK {
+			t.Errorf("expected the spec's api-version to be served, got %d %v", code, body)
+		}
+
+		// Generic resource operations take the api-version of the resource provider
+		vault := rgPath + "/providers/Microsoft.KeyVault/vaults/kvvalidation"
+		if code, body := armRequest(t, mux, "PUT", vault+"?api-version=2023-07-01", `{"location":"eastus"}`); code != http.StatusOK && code != http.StatusCreated {
+			t.Errorf("expected a provider api-version to be accepted, got %d %v", code, body)
+		}
+		code, body = armRequest(t, mux, "GET", vault+"?api-version=latest", "")
+		if code != http.StatusBadRequest || armErrorCode(body) != "InvalidApiVersionParameter" {
+			t.Errorf("expected InvalidApiVersionParameter for a malformed version, got %d %v", code, body)
+		}
+	})
+
+	t.Run("parameters", func(t *testing.T) {
+		code, body := armRequest(t, mux, "GET", rgPath+"/resources?api-version=2021-04-01&$top=ten", "")
+		if code != http.StatusBadRequest || armErrorCode(body) != "InvalidRequestContent" {
+			t.Errorf("expected InvalidRequestContent for a non-integer $top, got %d %v", code, body)
+		}
+		if code, body := armRequest(t, mux, "GET", rgPath+"/resources?api-version=2021-04-01&$top=10", ""); code != http.StatusOK {
+			t.Errorf("expected an integer $top to be accepted, got %d %v", code, body)
+		}
+	})
+
+	t.Run("bodies", func(t *testing.T) {
+		newGroup := testSubscriptionPath + "/resourcegroups/rg-validation"
+		code, body := armRequest(t, mux, "PUT", newGroup, `{"location":42}`)
+		if code != http.StatusBadRequest || armErrorCode(body) != "InvalidRequestContent" {
+			t.Errorf("expected InvalidRequestContent for a numeric location, got %d %v", code, body)
+		}
+		code, body = armRequest(t, mux, "PUT", newGroup, `{"tags":{"env":"dev"}}`)
+		if code != http.StatusBadRequest || armErrorCode(body) != "LocationRequired" {
+			t.Errorf("expected LocationRequired, got %d %v", code, body)
+		}
+		code, body = armRequest(t, mux, "PUT", newGroup, `{"location":"eastus","tags":{"env":["dev"]}}`)
+		if code != http.StatusBadRequest || armErrorCode(body) != "InvalidRequestContent" {
+			t.Errorf("expected InvalidRequestContent for a non-string tag, got %d %v", code, body)
+		}
+		// Read-only properties are ignored, as ARM does for bodies round-tripped from a GET
+		if code, body := armRequest(t, mux, "PUT", newGroup, `{"id":7,"location":"eastus"}`); code != http.StatusCreated && code != http.StatusOK {
+			t.Errorf("expected read-only properties to be ignored, got %d %v", code, body)
+		}
+
+		vault := rgPath + "/providers/Microsoft.KeyVault/vaults/kvvalidation2"
+		code, body = armRequest(t, mux, "PUT", vault, `{"location":"eastus","sku":{"name":"standard","capacity":"two"}}`)
+		if code != http.StatusBadRequest || armErrorCode(body) != "InvalidRequestContent" {
+			t.Errorf("expected InvalidRequestContent for a string capacity, got %d %v", code, body)
+		}
+		code, body = armRequest(t, mux, "PUT", vault, `{"location":"eastus","identity":{"type":"Everyone"}}`)
+		if code != http.StatusBadRequest || armErrorCode(body) != "InvalidRequestContent" {
+			t.Errorf("expected InvalidRequestContent for an unknown identity type, got %d %v", code, body)
+		}
+		code, body = armRequest(t, mux, "PUT", vault, "")
+		if code != http.StatusBadRequest || armErrorCode(body) != "InvalidRequestContent" {
+			t.Errorf("expected InvalidRequestContent for a missing body, got %d %v", code, body)
+		}
+	})
+}
+
+// Operations the hardcoded routes serve are checked against the specs that describe them
+func TestValidationOfHardcodedRoutes(t *testing.T) {
+	dir := t.TempDir()
+	spec := `{
+  "swagger": "2.0",
+  "info": {"title": "ComputeManagementClient", "version": "2024-07-01"},
+  "paths": {
+    "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}": {
+      "get": {
+        "operationId": "VirtualMachines_Get",
+        "parameters": [
+          {"name": "subscriptionId", "in": "path", "required": true, "type": "string"},
+          {"name": "resourceGroupName", "in": "path", "required": true, "type": "string"},
+          {"name": "vmName", "in": "path", "required": true, "type": "string"},
+          {"$ref": "#/parameters/ApiVersionParameter"}
+        ],
+        "responses": {"200": {"description": "OK", "schema": {"type": "object", "properties": {"name": {"type": "integer"}}}}}
+      }
+    }
+  },
+  "parameters": {
+    "ApiVersionParameter": {"name": "api-version", "in": "query", "required": true, "type": "string"}
+  }
+}`
+	if err := os.MkdirAll(filepath.Join(dir, "arm"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "arm", "arm-compute.json"), []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	store.conformance = routes.ConformanceStrict
+	mux := newMux(store, dir)
+	vmsPath := testSubscriptionPath + "/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines"
+
+	code, body := armRequest(t, mux, "GET", vmsPath+"/vm-web-01?api-version=2019-01-01", "")
+	if code != http.StatusBadRequest || armErrorCode(body) != "InvalidApiVersionParameter" {
+		t.Errorf("expected the spec's api-version check, got %d %v", code, body)
+	}
+	code, body = armRequest(t, mux, "GET", vmsPath+"/vm-web-01?api-version=2024-07-01", "")
+	if code != http.StatusInternalServerError || armErrorCode(body) != "ResponseConformanceFailed" {
+		t.Errorf("expected the spec's conformance check, got %d %v", code, body)
+	}
+
+	// Operations the spec does not describe are served as before
+	if code, body := armRequest(t, mux, "GET", vmsPath+"?api-version=2019-01-01", ""); code != http.StatusOK {
+		t.Errorf("expected the VM list to be served, got %d %v", code, body)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+)
+
+// vmActionPattern matches the power actions and run commands of a standalone VM
+var vmActionPattern = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Compute/virtualMachines/([^/]+)/(start|deallocate|restart|runCommand)/?$`)
+
+// vmExtensionPattern matches the extensions of a standalone VM
+var vmExtensionPattern = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Compute/virtualMachines/([^/]+)/extensions(?:/([^/]+))?/?$`)
+
+// VMImageReference is the marketplace image a VM was created from
+type VMImageReference struct {
+	Publisher string `json:"publisher,omitempty" yaml:"publisher,omitempty"`
+	Offer     string `json:"offer,omitempty" yaml:"offer,omitempty"`
+	SKU       string `json:"sku,omitempty" yaml:"sku,omitempty"`
+	Version   string `json:"version,omitempty" yaml:"version,omitempty"`
+}
+
+// VMDisk is the OS disk or a data disk of a VM. Unset fields get Azure's defaults.
+type VMDisk struct {
+	ID                 string `json:"id,omitempty" yaml:"id,omitempty"` // an existing managed disk attached to the VM
+	Name               string `json:"name,omitempty" yaml:"name,omitempty"`
+	Lun                int    `json:"lun" yaml:"lun"` // data disks only
+	DiskSizeGB         int    `json:"diskSizeGB,omitempty" yaml:"diskSizeGB,omitempty"`
+	StorageAccountType string `json:"storageAccountType,omitempty" yaml:"storageAccountType,omitempty"`
+	Caching            string `json:"caching,omitempty" yaml:"caching,omitempty"`
+	CreateOption       string `json:"createOption,omitempty" yaml:"createOption,omitempty"`
+}
+
+// VMNetworkInterface references a network interface attached to a VM
+type VMNetworkInterface struct {
+	ID      string `json:"id" yaml:"id"`
+	Primary bool   `json:"primary,omitempty" yaml:"primary,omitempty"`
+}
+
+// VMOSProfile holds the guest OS settings of a VM
+type VMOSProfile struct {
+	ComputerName  string `json:"computerName,omitempty" yaml:"computerName,omitempty"`
+	AdminUsername string `json:"adminUsername,omitempty" yaml:"adminUsername,omitempty"`
+}
+
+// VMAgent is the status the guest agent reports in the instance view
+type VMAgent struct {
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	Status  string `json:"status,omitempty" yaml:"status,omitempty"` // Ready or Not Ready
+}
+
+// VMExtension is a VM extension such as a monitoring or script agent
+type VMExtension struct {
+	Name               string                 `json:"name" yaml:"name"`
+	Publisher          string                 `json:"publisher,omitempty" yaml:"publisher,omitempty"`
+	Type               string                 `json:"type,omitempty" yaml:"type,omitempty"`
+	TypeHandlerVersion string                 `json:"typeHandlerVersion,omitempty" yaml:"typeHandlerVersion,omitempty"`
+	ProvisioningState  string                 `json:"provisioningState,omitempty" yaml:"provisioningState,omitempty"`
+	Settings           map[string]interface{} `json:"settings,omitempty" yaml:"settings,omitempty"`
+
+	// Output of the script a custom script extension ran, reported in the instance view
+	Stdout   *string `json:"stdout,omitempty" yaml:"stdout,omitempty"`
+	Stderr   *string `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+	ExitCode int     `json:"exitCode,omitempty" yaml:"exitCode,omitempty"`
+}
+
+// vmProfileFields are the keys of the VM profile in the mapper view of a VM
+var vmProfileFields = []string{"zones", "imageReference", "osDisk", "dataDisks", "networkInterfaces", "osProfile", "vmAgent", "extensions"}
+
+// vmProfile returns the optional profile of a VM in the generic shape mappers use.
+// Unset fields are left out.
+func vmProfile(vm *MockVM) map[string]interface{} {
+	var all map[string]interface{}
+	data, err := json.Marshal(vm)
+	if err == nil {
+		err = json.Unmarshal(data, &all)
+	}
+	profile := map[string]interface{}{}
+	if err != nil {
+		return profile
+	}
+	for _, key := range vmProfileFields {
+		if v, ok := all[key]; ok && v != nil {
+			profile[key] = v
+		}
+	}
+	return profile
+}
+
+// setVMProfile replaces the profile fields present in a mapper view of a VM
+func setVMProfile(vm *MockVM, fields map[string]interface{}) error {
+	profile := map[string]interface{}{}
+	for _, key := range vmProfileFields {
+		if v, ok := fields[key]; ok && v != nil {
+			profile[key] = v
+		}
+	}
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, vm)
+}
+
+// vmExtensionRoute matches the extension routes of a standalone VM, returning nil when the request does not
+func vmExtensionRoute(r *http.Request) *armRoute {
+	matches := vmExtensionPattern.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		return nil
+	}
+	params := map[string]string{
+		"subscriptionId":    matches[1],
+		"resourceGroupName": matches[2],
+		"vmName":            matches[3],
+	}
+	pattern := "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}/extensions"
+	var op string
+	switch {
+	case matches[4] == "" && r.Method == http.MethodGet:
+		op = "VirtualMachineExtensions_List"
+	case matches[4] == "":
+	case r.Method == http.MethodGet:
+		op = "VirtualMachineExtensions_Get"
+	case r.Method == http.MethodPut:
+		op = "VirtualMachineExtensions_CreateOrUpdate"
+	case r.Method == http.MethodDelete:
+		op = "VirtualMachineExtensions_Delete"
+	}
+	if op == "" {
+		return nil
+	}
+	if matches[4] != "" {
+		pattern += "/{vmExtensionName}"
+		params["vmExtensionName"] = matches[4]
+	}
+	return &armRoute{operationID: op, pattern: pattern, params: params}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestVirtualMachineProfileAndInstanceView(t *testing.T) {
+	store := &Store{configPath: "config.yaml.example"}
+	store.init()
+	mux := newMux(store, "mockzure-specs")
+	vmPath := testSubscriptionPath + "/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines/"
+
+	t.Run("configured profile", func(t *testing.T) {
+		code, vm := armRequest(t, mux, "GET", vmPath+"vm-web-01", "")
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %v", code, vm)
+		}
+		if zones, _ := vm["zones"].([]interface{}); len(zones) != 1 || zones[0] != "1" {
+			t.Errorf("unexpected zones: %v", vm["zones"])
+		}
+		properties, _ := vm["properties"].(map[string]interface{})
+		if _, ok := properties["instanceView"]; ok {
+			t.Errorf("expected no instance view without $expand")
+		}
+		storage, _ := properties["storageProfile"].(map[string]interface{})
+		image, _ := storage["imageReference"].(map[string]interface{})
+		if image["publisher"] != "Canonical" || image["version"] != "latest" || image["exactVersion"] == nil {
+			t.Errorf("unexpected image reference: %v", image)
+		}
+		osDisk, _ := storage["osDisk"].(map[string]interface{})
+		managed, _ := osDisk["managedDisk"].(map[string]interface{})
+		if osDisk["name"] != "vm-web-01_OsDisk_1" || osDisk["diskSizeGB"] != float64(64) || managed["storageAccountType"] != "Premium_LRS" {
+			t.Errorf("unexpected os disk: %v", osDisk)
+		}
+		dataDisks, _ := storage["dataDisks"].([]interface{})
+		if len(dataDisks) != 1 {
+			t.Fatalf("expected one data disk, got %v", storage["dataDisks"])
+		}
+		dataDisk := dataDisks[0].(map[string]interface{})
+		if dataDisk["lun"] != float64(0) || dataDisk["name"] != "vm-web-01-data-0" || dataDisk["diskSizeGB"] != float64(256) {
+			t.Errorf("unexpected data disk: %v", dataDisk)
+		}
+		osProfile, _ := properties["osProfile"].(map[string]interface{})
+		if osProfile["computerName"] != "web01" || osProfile["adminUsername"] != "webadmin" || osProfile["linuxConfiguration"] == nil {
+			t.Errorf("unexpected os profile: %v", osProfile)
+		}
+		network, _ := properties["networkProfile"].(map[string]interface{})
+		if nics, _ := network["networkInterfaces"].([]interface{}); len(nics) != 1 {
+			t.Errorf("unexpected network profile: %v", network)
+		}
+		extensions, _ := vm["resources"].([]interface{})
+		if len(extensions) != 1 || extensions[0].(map[string]interface{})["type"] != "Microsoft.Compute/virtualMachines/extensions" {
+			t.Errorf("unexpected extensions: %v", vm["resources"])
+		}
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		_, vm := armRequest(t, mux, "GET", vmPath+"vm-api-01", "")
+		properties, _ := vm["properties"].(map[string]interface{})
+		storage, _ := properties["storageProfile"].(map[string]interface{})
+		osDisk, _ := storage["osDisk"].(map[string]interface{})
+		if osDisk["name"] != "vm-api-01_OsDisk_1" || osDisk["diskSizeGB"] != float64(30) {
+			t.Errorf("unexpected default os disk: %v", osDisk)
+		}
+		if dataDisks, ok := storage["dataDisks"].([]interface{}); !ok || len(dataDisks) != 0 {
+			t.Errorf("expected an empty data disk list, got %v", storage["dataDisks"])
+		}
+		osProfile, _ := properties["osProfile"].(map[string]interface{})
+		if osProfile["computerName"] != "vm-api-01" || osProfile["adminUsername"] != "azureuser" {
+			t.Errorf("unexpected default os profile: %v", osProfile)
+		}
+	})
+
+	t.Run("instance view", func(t *testing.T) {
+		code, view := armRequest(t, mux, "GET", vmPath+"vm-web-01/instanceView", "")
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %v", code, view)
+		}
+		statuses, _ := view["statuses"].([]interface{})
+		if len(statuses) != 2 || statuses[1].(map[string]interface{})["code"] != "PowerState/running" {
+			t.Errorf("unexpected statuses: %v", view["statuses"])
+		}
+		agent, _ := view["vmAgent"].(map[string]interface{})
+		agentStatuses, _ := agent["statuses"].([]interface{})
+		if agent["vmAgentVersion"] != "2.10.0.8" || len(agentStatuses) != 1 || agentStatuses[0].(map[string]interface{})["displayStatus"] != "Ready" {
+			t.Errorf("unexpected agent: %v", agent)
+		}
+		if disks, _ := view["disks"].([]interface{}); len(disks) != 2 {
+			t.Errorf("expected os and data disk statuses, got %v", view["disks"])
+		}
+		if extensions, _ := view["extensions"].([]interface{}); len(extensions) != 1 {
+			t.Errorf("unexpected extension statuses: %v", view["extensions"])
+		}
+
+		_, stopped := armRequest(t, mux, "GET", vmPath+"vm-api-01/instanceView", "")
+		statuses, _ = stopped["statuses"].([]interface{})
+		if len(statuses) != 2 || statuses[1].(map[string]interface{})["code"] != "PowerState/deallocated" {
+			t.Errorf("unexpected statuses for a stopped VM: %v", stopped["statuses"])
+		}
+		agent, _ = stopped["vmAgent"].(map[string]interface{})
+		agentStatuses, _ = agent["statuses"].([]interface{})
+		if len(agentStatuses) != 1 || agentStatuses[0].(map[string]interface{})["displayStatus"] != "Not Ready" {
+			t.Errorf("expected the agent of a stopped VM to be not ready: %v", agent)
+		}
+	})
+
+	t.Run("expand instance view", func(t *testing.T) {
+		_, vm := armRequest(t, mux, "GET", vmPath+"vm-web-01?$expand=instanceView", "")
+		properties, _ := vm["properties"].(map[string]interface{})
+		view, _ := properties["instanceView"].(map[string]interface{})
+		if view["computerName"] != "web01" {
+			t.Errorf("unexpected expanded instance view: %v", properties["instanceView"])
+		}
+		_, list := armRequest(t, mux, "GET", testSubscriptionPath+"/resourceGroups/rg-dev/providers/Microsoft.Compute/virtualMachines?$expand=instanceView", "")
+		for _, item := range list["value"].([]interface{}) {
+			if item.(map[string]interface{})["properties"].(map[string]interface{})["instanceView"] == nil {
+				t.Errorf("expected instance views in the expanded list: %v", item)
+			}
+		}
+	})
+}